@@ -0,0 +1,195 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// runAdopt matches the files in an existing show directory to the episodes in the show's feed and records them in the
+// state, so that a library from another downloader can be synced without downloading everything again.
+func runAdopt(config *Config, urlArg string, args []string) error {
+	if len(args) == 0 {
+		Log("No show directory specified")
+		return errUsage
+	}
+	showDir := args[0]
+
+	fs := flag.NewFlagSet("adopt", flag.ContinueOnError)
+	feedArg := fs.String("u", urlArg, "URL of show's RSS feed")
+	dryRun := fs.Bool("dry-run", false, "Optional. List the matches without recording them")
+	if err := fs.Parse(args[1:]); err != nil {
+		return errUsage
+	}
+	if *feedArg == "" {
+		Log("No feed specified")
+		return errUsage
+	}
+
+	if info, err := os.Stat(showDir); err != nil || !info.IsDir() {
+		return fmt.Errorf("invalid show directory: %v", showDir)
+	}
+
+	show, err := adoptShow(config, *feedArg)
+	if err != nil {
+		return err
+	}
+	if err := show.Fetch(); err != nil {
+		return err
+	}
+	show.Dir = showDir
+
+	matches, unmatched, err := show.matchFiles()
+	if err != nil {
+		return err
+	}
+
+	for path, episode := range matches {
+		Log("Matched", filepath.Base(path), "->", episode.Title)
+		if *dryRun {
+			continue
+		}
+
+		episode.path = path
+		if abs, err := filepath.Abs(path); err == nil {
+			episode.path = abs
+		}
+		if info, err := os.Stat(path); err == nil {
+			episode.size = int(info.Size())
+		}
+		StateDB.RecordDownload(show.URL.String(), show.Title, episode)
+	}
+	for _, path := range unmatched {
+		Log("No match for", filepath.Base(path))
+	}
+
+	Log("")
+	Log("Matched", len(matches), "files to episodes;", len(unmatched), "files unmatched")
+	if *dryRun {
+		return nil
+	}
+
+	if err := StateDB.Save(); err != nil {
+		return fmt.Errorf("error saving state: %v", err)
+	}
+
+	if abs, err := filepath.Abs(showDir); err == nil && filepath.Base(abs) != show.Title {
+		Log("Note: syncs look for this show in a directory named", show.Title)
+	}
+	return nil
+}
+
+// adoptShow creates the show for the feed, using the login information from the config if the feed is subscribed to.
+func adoptShow(config *Config, feed string) (*Show, error) {
+	if config != nil {
+		for _, sub := range config.Subscriptions {
+			if sub.URL == feed {
+				return NewShow(sub)
+			}
+		}
+	}
+
+	u, err := url.Parse(feed)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %v", err)
+	}
+
+	return &Show{URL: u}, nil
+}
+
+// matchFiles matches the audio files in the show's directory to the episodes in the feed. Files are matched by the
+// GUID frame first, then by the title tag, and then by the filename. This returns the matched files (keyed by path)
+// and the paths of the files that didn't match anything.
+func (s *Show) matchFiles() (map[string]*Episode, []string, error) {
+	byGUID := make(map[string]*Episode)
+	byTitle := make(map[string]*Episode)
+	for i := range s.Episodes {
+		episode := &s.Episodes[i]
+		if episode.GUID != "" {
+			byGUID[episode.GUID] = episode
+		}
+		byTitle[normalizeTitle(episode.Title)] = episode
+	}
+
+	matches := make(map[string]*Episode)
+	claimed := make(map[*Episode]bool)
+	var unmatched []string
+	err := filepath.Walk(s.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() && path != s.Dir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() || !isAudio(info.Name()) {
+			return nil
+		}
+
+		var episode *Episode
+		if entry, err := TagCache.Lookup(path, info); err != nil {
+			s.debug("Error reading metadata of", info.Name(), "-", err)
+		} else if e, ok := byGUID[entry.GUID]; ok && entry.GUID != "" {
+			episode = e
+		} else if e, ok := byTitle[normalizeTitle(entry.Title)]; ok && entry.Title != "" {
+			episode = e
+		}
+		if episode == nil {
+			episode = s.matchName(info.Name(), claimed)
+		}
+
+		if episode == nil || claimed[episode] {
+			unmatched = append(unmatched, path)
+			return nil
+		}
+		claimed[episode] = true
+		matches[path] = episode
+		return nil
+	})
+
+	return matches, unmatched, err
+}
+
+// matchName finds the unclaimed episode whose title appears in the filename, ignoring case, punctuation, and spacing.
+// If more than one title appears, the longest one wins, so that "Part 1" doesn't match every "Part 1x" file.
+func (s *Show) matchName(filename string, claimed map[*Episode]bool) *Episode {
+	name := normalizeName(strings.TrimSuffix(filename, filepath.Ext(filename)))
+	if name == "" {
+		return nil
+	}
+
+	var best *Episode
+	bestLen := 0
+	for i := range s.Episodes {
+		episode := &s.Episodes[i]
+		if claimed[episode] {
+			continue
+		}
+
+		title := normalizeName(episode.Title)
+		if title != "" && strings.Contains(name, title) && len(title) > bestLen {
+			best = episode
+			bestLen = len(title)
+		}
+	}
+
+	return best
+}
+
+// normalizeName lowercases the name and drops everything but letters and digits.
+func normalizeName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/snhilde/getcast/internal/feedtest"
+)
+
+// Test that the files in an existing show directory are matched to the feed's episodes by GUID, then by title tag, and
+// then by filename, and that each episode is matched only once.
+func TestMatchFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast-adopt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tmpCache := TagCache
+	defer func() { TagCache = tmpCache }()
+	TagCache = nil
+
+	tagged := func(title string, guid string) []byte {
+		frames := []feedtest.Frame{feedtest.TextFrame("TIT2", title)}
+		if guid != "" {
+			frames = append(frames, feedtest.TextFrame("TXXX", "GUID\x00"+guid))
+		}
+		return feedtest.MP3(feedtest.Tag{Version: 3, Frames: frames}, 1000)
+	}
+	untagged := feedtest.MP3(feedtest.Tag{Version: 3}, 1000)
+
+	os.Mkdir(filepath.Join(dir, ".old"), 0755)
+	files := map[string][]byte{
+		"01 Renamed.mp3":     tagged("Old Title", "guid-1"),
+		"02 Tagged.mp3":      tagged("the  INTERVIEW", ""),
+		"Part_1.mp3":         untagged,
+		"Pilot copy.mp3":     untagged,
+		"Show - Part 10.mp3": untagged,
+		"Unknown.mp3":        untagged,
+		"cover.jpg":          nil,
+		".old/Pilot.mp3":     untagged,
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	show := &Show{Dir: dir, Episodes: []Episode{
+		{Title: "Pilot", GUID: "guid-1"},
+		{Title: "The Interview", GUID: "guid-2"},
+		{Title: "Part 1", GUID: "guid-3"},
+		{Title: "Part 10", GUID: "guid-4"},
+	}}
+	matches, unmatched, err := show.matchFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"01 Renamed.mp3":     "Pilot",
+		"02 Tagged.mp3":      "The Interview",
+		"Part_1.mp3":         "Part 1",
+		"Show - Part 10.mp3": "Part 10",
+	}
+	if len(matches) != len(want) {
+		t.Error("Incorrect number of matches - Want:", len(want), "Have:", len(matches))
+	}
+	for name, title := range want {
+		if episode, ok := matches[filepath.Join(dir, name)]; !ok || episode.Title != title {
+			t.Error("Incorrect match for", name, "- Want:", title, "Have:", episode)
+		}
+	}
+
+	// The pilot was already claimed by its GUID, so the copy doesn't match anything.
+	wantUnmatched := []string{filepath.Join(dir, "Pilot copy.mp3"), filepath.Join(dir, "Unknown.mp3")}
+	if len(unmatched) != 2 || unmatched[0] != wantUnmatched[0] || unmatched[1] != wantUnmatched[1] {
+		t.Error("Incorrect unmatched files - Want:", wantUnmatched, "Have:", unmatched)
+	}
+}
+
+// Test that adopting a subscribed feed uses the subscription, and that adopting requires a show directory and a feed.
+func TestAdoptShow(t *testing.T) {
+	config := &Config{Subscriptions: []Subscription{{URL: "https://example.com/feed.xml", Username: "user"}}}
+	show, err := adoptShow(config, "https://example.com/feed.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if show.Auth == nil || show.Auth.Username != "user" {
+		t.Error("Subscription's login was not used")
+	}
+
+	show, err = adoptShow(nil, "https://example.com/other.xml")
+	if err != nil || show.URL.String() != "https://example.com/other.xml" {
+		t.Error("Incorrect show for unsubscribed feed - Have:", show, err)
+	}
+
+	if err := runAdopt(nil, "https://example.com/feed.xml", nil); err != errUsage {
+		t.Error("Missing show directory - Want:", errUsage, "Have:", err)
+	}
+	if err := runAdopt(nil, "", []string{os.TempDir()}); err != errUsage {
+		t.Error("Missing feed - Want:", errUsage, "Have:", err)
+	}
+	if err := runAdopt(nil, "https://example.com/feed.xml", []string{"/does/not/exist"}); err == nil {
+		t.Error("Missing show directory was accepted")
+	}
+
+	if name := normalizeName("Ep. 12: The End!"); name != "ep12theend" {
+		t.Error("Normalized name - Want: ep12theend Have:", name)
+	}
+}
@@ -0,0 +1,523 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// These are the formats that seasons can be archived in. zstd compression uses the zstd program.
+const (
+	ArchiveTarGz  = "tar.gz"
+	ArchiveTarZst = "tar.zst"
+	ArchiveZip    = "zip"
+)
+
+// archiveManifestName is the name of the manifest at the root of each archive.
+const archiveManifestName = "manifest.json"
+
+// ArchiveManifest describes the episodes in an archive, so that it can be identified without unpacking it.
+type ArchiveManifest struct {
+	Show     string                 `json:"show"`
+	FeedURL  string                 `json:"feed_url"`
+	Season   int                    `json:"season"`
+	Created  time.Time              `json:"created"`
+	Episodes []ArchiveManifestEntry `json:"episodes"`
+}
+
+// ArchiveManifestEntry describes one episode in an archive.
+type ArchiveManifestEntry struct {
+	Title     string    `json:"title"`
+	GUID      string    `json:"guid,omitempty"`
+	Number    string    `json:"number,omitempty"`
+	Published time.Time `json:"published,omitempty"`
+	Duration  string    `json:"duration,omitempty"`
+	Path      string    `json:"path"` // location in the archive
+	Size      int64     `json:"size"`
+	SHA256    string    `json:"sha256"`
+	Files     []string  `json:"files,omitempty"` // other files that go with the episode, such as show notes
+}
+
+// archiveFile is a file to put in an archive.
+type archiveFile struct {
+	path string // location on disk
+	name string // location in the archive
+}
+
+// runArchive bundles a finished season of a show into a compressed archive, for cold storage.
+func runArchive(config *Config, dirArg string, args []string) error {
+	fs := flag.NewFlagSet("archive", flag.ContinueOnError)
+	showArg := fs.String("show", "", "Required. Title or feed URL of the show")
+	seasonArg := fs.String("season", "", "Required. Number of the season to archive")
+	format := fs.String("format", ArchiveTarGz, "Optional. Archive format: tar.gz, tar.zst, or zip")
+	output := fs.String("o", "", "Optional. Directory to save the archive in (default the show's directory)")
+	remove := fs.Bool("remove", false, "Optional. Delete the archived episodes once the archive is written")
+	force := fs.Bool("force", false, "Optional. Archive the season even if it isn't the show's last one")
+	dryRun := fs.Bool("dry-run", false, "Optional. List the episodes without archiving them")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if *showArg == "" || *seasonArg == "" {
+		Log("Need a show and a season")
+		return errUsage
+	}
+	switch *format {
+	case ArchiveTarGz, ArchiveZip:
+	case ArchiveTarZst:
+		if _, err := exec.LookPath("zstd"); err != nil {
+			return fmt.Errorf("zstd is required for %v archives", ArchiveTarZst)
+		}
+	default:
+		return fmt.Errorf("invalid format: %v", *format)
+	}
+
+	mainDir, err := downloadDir(config, dirArg)
+	if err != nil {
+		return err
+	}
+	mainDir, err = filepath.Abs(mainDir)
+	if err != nil {
+		return err
+	}
+
+	feedURL, title, episodes, err := StateDB.ShowEpisodes(*showArg, config)
+	if err != nil {
+		return err
+	}
+
+	season, err := strconv.Atoi(*seasonArg)
+	if err != nil || season < 0 {
+		return fmt.Errorf("invalid season: %v", *seasonArg)
+	}
+
+	// A season is only finished once the show has moved on to the next one.
+	var inSeason []EpisodeState
+	finished := false
+	for _, es := range episodes {
+		n, err := strconv.Atoi(es.Season)
+		switch {
+		case err != nil:
+			continue
+		case n == season && es.Path != "":
+			inSeason = append(inSeason, es)
+		case n > season:
+			finished = true
+		}
+	}
+	if len(inSeason) == 0 {
+		return fmt.Errorf("no downloaded episodes of season %v of %v", season, title)
+	}
+	if !finished && !*force {
+		return fmt.Errorf("season %v is the newest season of %v (use -force to archive it anyway)", season, title)
+	}
+	sort.SliceStable(inSeason, func(i, j int) bool {
+		return inSeason[i].Published.Before(inSeason[j].Published)
+	})
+
+	outDir := *output
+	if outDir == "" {
+		outDir = filepath.Join(mainDir, title)
+	}
+	if err := ValidateDir(outDir); err != nil {
+		return err
+	}
+	archivePath := filepath.Join(outDir, fmt.Sprintf("%s - Season %02d.%s", title, season, *format))
+	if _, err := os.Stat(archivePath); err == nil {
+		return fmt.Errorf("archive already exists: %v", archivePath)
+	}
+
+	manifest := ArchiveManifest{Show: title, FeedURL: feedURL, Season: season, Created: time.Now().UTC()}
+	var files []archiveFile
+	var archived []string
+	for _, es := range inSeason {
+		info, err := os.Stat(es.Path)
+		if err != nil {
+			Log("Skipping", es.Title, "- file not found")
+			continue
+		}
+
+		entry := ArchiveManifestEntry{
+			Title:     es.Title,
+			GUID:      es.GUID,
+			Number:    es.Number,
+			Published: es.Published,
+			Duration:  es.Duration,
+			Path:      archiveName(mainDir, es.Path),
+			Size:      info.Size(),
+		}
+		if entry.SHA256, err = fileChecksum(es.Path); err != nil {
+			return err
+		}
+		files = append(files, archiveFile{es.Path, entry.Path})
+		archived = append(archived, es.Path)
+
+		for _, extra := range episodeSidecars(es.Path) {
+			name := archiveName(mainDir, extra)
+			entry.Files = append(entry.Files, name)
+			files = append(files, archiveFile{extra, name})
+		}
+		manifest.Episodes = append(manifest.Episodes, entry)
+		Log("Archiving", filepath.Base(es.Path))
+	}
+	if *dryRun || len(manifest.Episodes) == 0 {
+		return nil
+	}
+
+	if err := writeArchive(archivePath, *format, manifest, files); err != nil {
+		return fmt.Errorf("error writing archive: %v", err)
+	}
+	Log("Archived", len(manifest.Episodes), "episodes to", archivePath)
+
+	// The state remembers the archive, so that the episodes aren't downloaded again once they're removed.
+	StateDB.markArchived(archived, archivePath)
+	if err := StateDB.Save(); err != nil {
+		return fmt.Errorf("error saving state: %v", err)
+	}
+
+	if *remove {
+		removeArchived(filepath.Join(mainDir, title), files)
+	}
+
+	return nil
+}
+
+// archiveName returns the name that the file at the path gets in an archive: its path relative to the main directory,
+// so that unpacking the archive there puts everything back where it was.
+func archiveName(mainDir string, path string) string {
+	rel, err := filepath.Rel(mainDir, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return filepath.Base(path)
+	}
+
+	return filepath.ToSlash(rel)
+}
+
+// episodeSidecars returns the files next to the episode that go with it, such as its show notes, chapters, and
+// artwork, which all share the episode's name.
+func episodeSidecars(path string) []string {
+	prefix := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)) + "."
+	infos, err := ioutil.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return nil
+	}
+
+	var sidecars []string
+	for _, info := range infos {
+		name := info.Name()
+		if !info.IsDir() && strings.HasPrefix(name, prefix) && name != filepath.Base(path) {
+			sidecars = append(sidecars, filepath.Join(filepath.Dir(path), name))
+		}
+	}
+
+	return sidecars
+}
+
+// writeArchive writes the manifest and the files to a new archive in the format. The archive is written to a temporary
+// file first so that an interrupted archive isn't mistaken for a finished one.
+func writeArchive(path string, format string, manifest ArchiveManifest, files []archiveFile) error {
+	manifestData, err := json.MarshalIndent(manifest, "", "\t")
+	if err != nil {
+		return err
+	}
+	manifestData = append(manifestData, '\n')
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".getcast-archive-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	switch format {
+	case ArchiveZip:
+		err = writeZip(tmp, manifestData, files)
+	case ArchiveTarGz:
+		gz := gzip.NewWriter(tmp)
+		if err = writeTar(gz, manifestData, files); err == nil {
+			err = gz.Close()
+		}
+	case ArchiveTarZst:
+		cmd := exec.Command("zstd", "-q", "-c")
+		cmd.Stdout = tmp
+		var stdin io.WriteCloser
+		if stdin, err = cmd.StdinPipe(); err != nil {
+			break
+		}
+		if err = cmd.Start(); err != nil {
+			break
+		}
+		err = writeTar(stdin, manifestData, files)
+		stdin.Close()
+		if waitErr := cmd.Wait(); err == nil && waitErr != nil {
+			err = fmt.Errorf("error running zstd: %v", waitErr)
+		}
+	}
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// writeTar writes the manifest and the files as a tar stream.
+func writeTar(w io.Writer, manifestData []byte, files []archiveFile) error {
+	tw := tar.NewWriter(w)
+	header := &tar.Header{Name: archiveManifestName, Mode: 0644, Size: int64(len(manifestData)), ModTime: time.Now()}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err := tw.Write(manifestData); err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if Interrupted() {
+			return errInterrupted
+		}
+
+		info, err := os.Stat(file.path)
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = file.name
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if err := copyInto(tw, file.path); err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+// writeZip writes the manifest and the files as a zip archive. Audio is already compressed, so episodes are stored as
+// they are.
+func writeZip(w io.Writer, manifestData []byte, files []archiveFile) error {
+	zw := zip.NewWriter(w)
+	mw, err := zw.Create(archiveManifestName)
+	if err != nil {
+		return err
+	}
+	if _, err := mw.Write(manifestData); err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if Interrupted() {
+			return errInterrupted
+		}
+
+		info, err := os.Stat(file.path)
+		if err != nil {
+			return err
+		}
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = file.name
+		header.Method = zip.Deflate
+		if isAudio(file.path) {
+			header.Method = zip.Store
+		}
+		fw, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if err := copyInto(fw, file.path); err != nil {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+// archiveFormat returns the format of the archive at the path, going by its extension, or "" if it isn't one.
+func archiveFormat(path string) string {
+	for _, format := range []string{ArchiveTarGz, ArchiveTarZst, ArchiveZip} {
+		if strings.HasSuffix(strings.ToLower(path), "."+format) {
+			return format
+		}
+	}
+
+	return ""
+}
+
+// readArchiveManifest reads the manifest of the archive at the path. The manifest is the first thing in each archive,
+// so the rest of it isn't read.
+func readArchiveManifest(path string) (ArchiveManifest, error) {
+	var manifest ArchiveManifest
+	var data []byte
+	var err error
+
+	switch archiveFormat(path) {
+	case ArchiveZip:
+		data, err = readZipManifest(path)
+	case ArchiveTarGz:
+		var file *os.File
+		if file, err = os.Open(path); err != nil {
+			break
+		}
+		defer file.Close()
+		var gz *gzip.Reader
+		if gz, err = gzip.NewReader(file); err != nil {
+			break
+		}
+		data, err = readTarManifest(gz)
+	case ArchiveTarZst:
+		cmd := exec.Command("zstd", "-q", "-d", "-c", path)
+		var stdout io.ReadCloser
+		if stdout, err = cmd.StdoutPipe(); err != nil {
+			break
+		}
+		if err = cmd.Start(); err != nil {
+			break
+		}
+		data, err = readTarManifest(stdout)
+		// We're done with the rest of it.
+		cmd.Process.Kill()
+		cmd.Wait()
+	default:
+		err = fmt.Errorf("unknown archive format")
+	}
+	if err != nil {
+		return manifest, err
+	}
+
+	err = json.Unmarshal(data, &manifest)
+	return manifest, err
+}
+
+// readTarManifest reads the manifest from the start of the tar stream.
+func readTarManifest(r io.Reader) ([]byte, error) {
+	tr := tar.NewReader(r)
+	header, err := tr.Next()
+	if err != nil {
+		return nil, err
+	} else if header.Name != archiveManifestName {
+		return nil, fmt.Errorf("no manifest found")
+	}
+
+	return ioutil.ReadAll(tr)
+}
+
+// readZipManifest reads the manifest from the zip archive at the path.
+func readZipManifest(path string) ([]byte, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	for _, file := range zr.File {
+		if file.Name != archiveManifestName {
+			continue
+		}
+		r, err := file.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	}
+
+	return nil, fmt.Errorf("no manifest found")
+}
+
+// copyInto copies the contents of the file at the path into the writer.
+func copyInto(w io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = io.Copy(w, file)
+	return err
+}
+
+// removeArchived deletes the archived files from the show's directory, along with any directories they leave empty.
+func removeArchived(showDir string, files []archiveFile) {
+	for _, file := range files {
+		if err := os.Remove(file.path); err != nil && !os.IsNotExist(err) {
+			Log("Error removing", filepath.Base(file.path), "-", err)
+			continue
+		}
+		removeEmptyDirs(showDir, filepath.Dir(file.path))
+	}
+	Log("Removed", len(files), "archived files")
+
+	if _, err := os.Stat(filepath.Join(showDir, ChecksumsFile)); err == nil {
+		if err := UpdateChecksums(showDir); err != nil {
+			Log("Error updating checksums:", err)
+		}
+	}
+}
+
+// ShowEpisodes returns the feed URL and title of the show (by feed URL or title) and a copy of the record of each of
+// its episodes.
+func (s *State) ShowEpisodes(show string, config *Config) (string, string, []EpisodeState, error) {
+	if s == nil {
+		return "", "", nil, fmt.Errorf("no state available")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	feedURL := s.findShow(show, config)
+	ss, ok := s.Shows[feedURL]
+	if feedURL == "" || !ok {
+		return "", "", nil, fmt.Errorf("show not found: %v", show)
+	}
+
+	var episodes []EpisodeState
+	for _, es := range ss.Episodes {
+		episodes = append(episodes, *es)
+	}
+
+	return feedURL, ss.Title, episodes, nil
+}
+
+// markArchived records that the episodes at the paths were moved into the archive.
+func (s *State) markArchived(paths []string, archive string) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	archived := make(map[string]bool)
+	for _, path := range paths {
+		archived[path] = true
+	}
+	for feedURL, ss := range s.Shows {
+		for key, es := range ss.Episodes {
+			if archived[es.Path] {
+				es.Archive = archive
+				s.touch(feedURL, key)
+			}
+		}
+	}
+}
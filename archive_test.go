@@ -0,0 +1,105 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Test that archives hold the manifest and every file, in each of the built-in formats.
+func TestWriteArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	showDir := filepath.Join(dir, "Show", "Season 02")
+	os.MkdirAll(showDir, 0755)
+	episode := filepath.Join(showDir, "01 Pilot.mp3")
+	ioutil.WriteFile(episode, []byte("audio"), 0644)
+	ioutil.WriteFile(filepath.Join(showDir, "01 Pilot.md"), []byte("notes"), 0644)
+	ioutil.WriteFile(filepath.Join(showDir, "01 Pilot.chapters.json"), []byte("{}"), 0644)
+	ioutil.WriteFile(filepath.Join(showDir, "01 Pilot Extended.mp3"), []byte("other"), 0644)
+
+	sidecars := episodeSidecars(episode)
+	wantSidecars := []string{filepath.Join(showDir, "01 Pilot.chapters.json"), filepath.Join(showDir, "01 Pilot.md")}
+	if !reflect.DeepEqual(sidecars, wantSidecars) {
+		t.Error("Incorrect sidecars - Want:", wantSidecars, "Have:", sidecars)
+	}
+
+	files := []archiveFile{{episode, archiveName(dir, episode)}}
+	for _, sidecar := range sidecars {
+		files = append(files, archiveFile{sidecar, archiveName(dir, sidecar)})
+	}
+	manifest := ArchiveManifest{Show: "Show", Season: 2, Episodes: []ArchiveManifestEntry{{Title: "Pilot", Path: files[0].name}}}
+
+	want := map[string]string{
+		"Show/Season 02/01 Pilot.mp3":           "audio",
+		"Show/Season 02/01 Pilot.md":            "notes",
+		"Show/Season 02/01 Pilot.chapters.json": "{}",
+	}
+
+	for _, format := range []string{ArchiveTarGz, ArchiveZip} {
+		path := filepath.Join(dir, "Show - Season 02."+format)
+		if err := writeArchive(path, format, manifest, files); err != nil {
+			t.Fatal(format, "-", err)
+		}
+
+		have := make(map[string]string)
+		switch format {
+		case ArchiveTarGz:
+			f, err := os.Open(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gz, err := gzip.NewReader(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tr := tar.NewReader(gz)
+			for {
+				header, err := tr.Next()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				data, _ := ioutil.ReadAll(tr)
+				have[header.Name] = string(data)
+			}
+			f.Close()
+		case ArchiveZip:
+			zr, err := zip.OpenReader(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, file := range zr.File {
+				r, _ := file.Open()
+				data, _ := ioutil.ReadAll(r)
+				r.Close()
+				have[file.Name] = string(data)
+			}
+			zr.Close()
+		}
+
+		var haveManifest ArchiveManifest
+		if err := json.Unmarshal([]byte(have[archiveManifestName]), &haveManifest); err != nil {
+			t.Error(format, "- Error reading manifest:", err)
+		} else if haveManifest.Season != 2 || len(haveManifest.Episodes) != 1 || haveManifest.Episodes[0].Path != "Show/Season 02/01 Pilot.mp3" {
+			t.Error(format, "- Incorrect manifest:", haveManifest)
+		}
+		delete(have, archiveManifestName)
+
+		if !reflect.DeepEqual(have, want) {
+			t.Error(format, "- Incorrect files - Want:", want, "Have:", have)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// These are the ways that each episode's artwork can be saved.
+const (
+	ArtworkEmbed    = "embed"    // in the episode's metadata (APIC frame)
+	ArtworkExternal = "external" // in an image file next to the episode
+	ArtworkNone     = "none"     // not at all
+)
+
+// ValidateArtworkMode checks that the artwork mode exists.
+func ValidateArtworkMode(mode string) error {
+	switch mode {
+	case ArtworkEmbed, ArtworkExternal, ArtworkNone:
+		return nil
+	}
+
+	return fmt.Errorf("invalid artwork mode: %v", mode)
+}
+
+// artworkConcurrency is the number of images that are fetched at the same time ahead of the downloads.
+const artworkConcurrency = 4
+
+// artworkCacheSize is the most bytes of images that are held in memory ahead of the downloads.
+var artworkCacheSize = 64 * 1024 * 1024
+
+// artworkCache holds the images for a show's episodes while they're being downloaded. Images are fetched in the
+// background ahead of the episodes so that building the tags doesn't have to wait on them, and each image is only
+// fetched once no matter how many episodes use it. Images that don't fit in the cache's size are let go once they're
+// fetched and fetched again when they're needed, which the HTTP cache can usually answer from disk.
+type artworkCache struct {
+	mutex  sync.Mutex
+	images map[string]*artworkEntry
+	size   int // bytes of images held
+}
+
+// artworkEntry is one image in the cache.
+type artworkEntry struct {
+	done    chan struct{} // closed once the image has been fetched
+	data    []byte        // raw image data, or nil if the image couldn't be fetched
+	dropped bool          // whether the image was let go because the cache was full
+	users   int           // number of episodes that still need the image
+}
+
+// prefetchArtwork starts fetching the images for the episodes in the background and returns the cache that will hold
+// them. Each episode is pointed at the cache so that it can pick up its image when its tag is built.
+func prefetchArtwork(episodes []Episode) *artworkCache {
+	c := &artworkCache{images: make(map[string]*artworkEntry)}
+
+	var links []string
+	var creds []*Credentials
+	for i := range episodes {
+		episodes[i].artwork = c
+		for _, link := range episodes[i].imageLinks() {
+			if entry, ok := c.images[link]; ok {
+				entry.users++
+				continue
+			}
+			c.images[link] = &artworkEntry{done: make(chan struct{}), users: 1}
+			links = append(links, link)
+			creds = append(creds, episodes[i].showAuth)
+		}
+	}
+
+	if len(links) > 0 {
+		Debug("Fetching", len(links), "images in the background")
+	}
+
+	slots := make(chan struct{}, artworkConcurrency)
+	for i := range links {
+		entry := c.images[links[i]]
+		go func(link string, creds *Credentials) {
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			data := fetchImage(link, creds)
+			c.mutex.Lock()
+			if c.size+len(data) > artworkCacheSize {
+				Debug("Not holding image in memory, the artwork cache is full:", link)
+				entry.dropped = true
+			} else {
+				entry.data = data
+				c.size += len(data)
+			}
+			c.mutex.Unlock()
+			close(entry.done)
+		}(links[i], creds[i])
+	}
+
+	return c
+}
+
+// get waits for the image at the link to be fetched and returns its data. Once the last episode using the image has
+// picked it up, the cache lets go of it. Links that weren't prefetched are fetched directly.
+func (c *artworkCache) get(link string, creds *Credentials) []byte {
+	c.mutex.Lock()
+	entry, ok := c.images[link]
+	c.mutex.Unlock()
+	if !ok {
+		return fetchImage(link, creds)
+	}
+
+	<-entry.done
+
+	c.mutex.Lock()
+	data, dropped := entry.data, entry.dropped
+	entry.users--
+	if entry.users <= 0 {
+		c.size -= len(entry.data)
+		delete(c.images, link)
+	}
+	c.mutex.Unlock()
+
+	if dropped {
+		return fetchImage(link, creds)
+	}
+
+	return data
+}
+
+// imageLink returns the link to the episode's image, falling back to the show's image.
+func (e *Episode) imageLink() string {
+	if e.Image != "" {
+		return e.Image
+	}
+
+	return e.showImage
+}
+
+// imageLinks returns the links to all of the images that will be embedded in the episode's metadata.
+func (e *Episode) imageLinks() []string {
+	var links []string
+	seen := make(map[string]bool)
+	for _, rule := range e.pictureRules() {
+		if link := rule.link(e); link != "" && !seen[link] && !e.external(link) {
+			seen[link] = true
+			links = append(links, link)
+		}
+	}
+
+	return links
+}
+
+// downloadImages downloads the images for the episode's pictures and builds the APIC frames with the data. By default,
+// this is either the episode (preferred) or show (fallback) image as the front cover. Pictures without an image or
+// with any trouble downloading the image are left out.
+func (e *Episode) downloadImages() [][]byte {
+	if e == nil {
+		return nil
+	}
+	e.debug("Downloading images")
+
+	rules := e.pictureRules()
+	images := make(map[string][]byte)
+	var frames [][]byte
+	for _, rule := range rules {
+		link := rule.link(e)
+		if link == "" {
+			e.debug("No", rule.Source, "image to download")
+			continue
+		}
+
+		data, ok := images[link]
+		if !ok {
+			data = e.imageData(link)
+			images[link] = data
+		}
+		if data == nil {
+			continue
+		}
+
+		picture := NewPicture(data)
+		picture.Type = rule.Type
+		// Each picture needs its own description when there's more than one.
+		if len(rules) > 1 {
+			picture.Desc = rule.Source + " " + pictureTypeName(rule.Type)
+		}
+		frames = append(frames, picture.frameValue(e.meta.Version()))
+	}
+
+	return frames
+}
+
+// imageData returns the raw data of the image at the link, waiting on the prefetched copy if there is one.
+func (e *Episode) imageData(link string) []byte {
+	if e.external(link) {
+		return nil
+	}
+
+	if e.artwork != nil {
+		return e.artwork.get(link, e.showAuth)
+	}
+
+	return fetchImage(link, e.showAuth)
+}
+
+// SaveArtwork saves the episode's image (or the show's, if the episode doesn't have one) next to the episode's audio
+// file with the same name. This must be called after the episode has been downloaded.
+func (e *Episode) SaveArtwork() error {
+	if e == nil || e.path == "" {
+		return nil
+	}
+
+	link := e.imageLink()
+	if link == "" {
+		e.debug("No episode or show image to save")
+		return nil
+	}
+
+	data := e.imageData(link)
+	if data == nil {
+		return nil
+	}
+
+	ext := ".jpg"
+	switch http.DetectContentType(data) {
+	case "image/png":
+		ext = ".png"
+	case "image/gif":
+		ext = ".gif"
+	case "image/webp":
+		ext = ".webp"
+	}
+
+	artPath := strings.TrimSuffix(e.path, filepath.Ext(e.path)) + ext
+	e.debug("Saving artwork to", artPath)
+	return ioutil.WriteFile(artPath, data, 0644)
+}
+
+// fetchImage downloads the image at the link and returns its raw data. If there's any trouble downloading the image,
+// this returns nil. Images are kept in the HTTP cache, so they're only downloaded again once they've changed.
+func fetchImage(link string, creds *Credentials) []byte {
+	u, err := url.Parse(link)
+	if u == nil || err != nil {
+		Debug("Error parsing image link")
+		return nil
+	}
+
+	data, err := fetchCached(fetchRequest{link: u.String(), creds: creds, small: true, limit: MaxImageSize})
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		Debug("Error getting image:", err)
+		return nil
+	} else if errors.Is(err, errTooLarge) {
+		Log("Skipping image:", err)
+		return nil
+	} else if err != nil {
+		Debug("Error retrieving image:", err)
+		return nil
+	}
+
+	return data
+}
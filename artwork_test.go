@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// Test that the prefetched images never take more memory than the cache's size, and that images that didn't fit are
+// still handed out.
+func TestArtworkCacheSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast-artwork")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("XDG_CACHE_HOME", dir)
+	defer os.Unsetenv("XDG_CACHE_HOME")
+
+	oldSize := artworkCacheSize
+	defer func() { artworkCacheSize = oldSize }()
+	artworkCacheSize = 250
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(bytes.Repeat([]byte(r.URL.Path[1:2]), 100))
+	}))
+	defer server.Close()
+
+	var episodes []Episode
+	for _, name := range []string{"a", "b", "c"} {
+		episodes = append(episodes, Episode{Title: name, Image: server.URL + "/" + name + ".png"})
+	}
+	cache := prefetchArtwork(episodes)
+	for _, episode := range episodes {
+		for _, entry := range cache.images {
+			<-entry.done
+		}
+		cache.mutex.Lock()
+		size := cache.size
+		cache.mutex.Unlock()
+		if size > artworkCacheSize {
+			t.Error("Cache holds too much - Want: at most", artworkCacheSize, "Have:", size)
+		}
+
+		want := bytes.Repeat([]byte(episode.Title), 100)
+		if have := cache.get(episode.Image, nil); !bytes.Equal(have, want) {
+			t.Error(episode.Title, "- Incorrect image:", string(have))
+		}
+	}
+	if cache.size != 0 {
+		t.Error("Cache still holds images - Want: 0 Have:", cache.size)
+	}
+}
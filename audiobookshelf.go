@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// AudiobookshelfConfig holds the settings for notifying an Audiobookshelf server about new episodes.
+type AudiobookshelfConfig struct {
+	URL     string `json:"url"`     // base URL of the server, e.g. http://localhost:13378
+	Token   string `json:"token"`   // API token or reference to it (see ResolveSecret)
+	Library string `json:"library"` // ID of the podcast library to rescan
+}
+
+// merge fills in any settings that are not set with the settings from other.
+func (a AudiobookshelfConfig) merge(other *AudiobookshelfConfig) AudiobookshelfConfig {
+	if other == nil {
+		return a
+	}
+
+	if a.URL == "" {
+		a.URL = other.URL
+	}
+	if a.Token == "" {
+		a.Token = other.Token
+	}
+	if a.Library == "" {
+		a.Library = other.Library
+	}
+
+	return a
+}
+
+// Enabled returns whether or not enough settings are present to contact the server.
+func (a AudiobookshelfConfig) Enabled() bool {
+	return a.URL != "" && a.Library != ""
+}
+
+// Scan tells the server to rescan the library folder so that new episodes show up immediately.
+func (a AudiobookshelfConfig) Scan() error {
+	if !a.Enabled() {
+		return nil
+	}
+
+	token, err := ResolveSecret(a.Token)
+	if err != nil {
+		return fmt.Errorf("error reading Audiobookshelf token: %v", err)
+	}
+
+	u := strings.TrimSuffix(a.URL, "/") + "/api/libraries/" + a.Library + "/scan"
+	req, err := http.NewRequest(http.MethodPost, u, nil)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	Debug("Requesting library scan from", u)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("server responded with %v", resp.Status)
+	}
+
+	Log("Requested Audiobookshelf library scan")
+	return nil
+}
+
+// notifyAudiobookshelf asks the Audiobookshelf server (if configured) to rescan the library. Settings from the command
+// line take precedence over the config file.
+func notifyAudiobookshelf(config *Config) {
+	var fromConfig *AudiobookshelfConfig
+	if config != nil {
+		fromConfig = config.Audiobookshelf
+	}
+
+	abs := AudiobookshelfFlags.merge(fromConfig)
+	if err := abs.Scan(); err != nil {
+		Log("Error notifying Audiobookshelf:", err)
+	}
+}
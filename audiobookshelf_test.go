@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// Test that a library scan is requested from the library's scan endpoint with the resolved token, and that a failed
+// response or an unresolvable token is returned as an error.
+func TestAudiobookshelfScan(t *testing.T) {
+	var requests int
+	var method, path, auth string
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		method, path, auth = r.Method, r.URL.Path, r.Header.Get("Authorization")
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	tmpToken, hadToken := os.LookupEnv("GETCAST_TEST_ABS_TOKEN")
+	defer func() {
+		if hadToken {
+			os.Setenv("GETCAST_TEST_ABS_TOKEN", tmpToken)
+		} else {
+			os.Unsetenv("GETCAST_TEST_ABS_TOKEN")
+		}
+	}()
+	os.Setenv("GETCAST_TEST_ABS_TOKEN", "secret")
+
+	tests := []struct {
+		config   AudiobookshelfConfig
+		status   int
+		requests int    // number of requests the server should receive
+		auth     string // Authorization header the server should receive
+		err      bool
+	}{
+		{AudiobookshelfConfig{URL: server.URL + "/", Token: "env:GETCAST_TEST_ABS_TOKEN", Library: "lib1"},
+			http.StatusOK, 1, "Bearer secret", false},
+		{AudiobookshelfConfig{URL: server.URL, Library: "lib1"}, http.StatusOK, 1, "", false},
+		{AudiobookshelfConfig{URL: server.URL, Token: "env:GETCAST_TEST_ABS_TOKEN", Library: "lib1"},
+			http.StatusUnauthorized, 1, "Bearer secret", true},
+		{AudiobookshelfConfig{URL: server.URL, Token: "env:GETCAST_TEST_ABS_MISSING", Library: "lib1"},
+			http.StatusOK, 0, "", true},
+		{AudiobookshelfConfig{URL: server.URL, Token: "env:GETCAST_TEST_ABS_TOKEN"}, http.StatusOK, 0, "", false},
+	}
+
+	for i, test := range tests {
+		requests, method, path, auth, status = 0, "", "", "", test.status
+		err := test.config.Scan()
+		if (err != nil) != test.err {
+			t.Error(i, "- Incorrect error - Want error:", test.err, "Have:", err)
+		}
+		if requests != test.requests {
+			t.Error(i, "- Incorrect number of requests - Want:", test.requests, "Have:", requests)
+			continue
+		}
+		if requests == 0 {
+			continue
+		}
+		if method != http.MethodPost || path != "/api/libraries/lib1/scan" {
+			t.Error(i, "- Incorrect request - Want: POST /api/libraries/lib1/scan Have:", method, path)
+		}
+		if auth != test.auth {
+			t.Error(i, "- Incorrect authorization - Want:", test.auth, "Have:", auth)
+		}
+	}
+}
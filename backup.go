@@ -0,0 +1,387 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// backupManifestName is the name of the manifest at the root of each backup.
+const backupManifestName = "getcast-backup.json"
+
+// lastBackupName is the name of the file in the profile's data directory that remembers the last backup, so that the
+// next one can be incremental.
+const lastBackupName = "last-backup.json"
+
+// BackupManifest describes a backup. Files lists every file that the backup covers, including the ones that an
+// incremental backup left out because they hadn't changed since the backup it builds on.
+type BackupManifest struct {
+	Created time.Time         `json:"created"`
+	Profile string            `json:"profile,omitempty"`
+	Dir     string            `json:"dir,omitempty"`  // main download directory, for moving the state's paths
+	Base    time.Time         `json:"base,omitempty"` // creation time of the backup this one builds on, if it's incremental
+	Files   map[string]string `json:"files"`          // SHA-256 checksum of each file, keyed by its name in the backup
+}
+
+// backupSource is a file that can be backed up.
+type backupSource struct {
+	name string // name in the backup: config.json, data/..., or logs/...
+	path string // location on disk
+}
+
+// runBackup writes a backup of the profile's config, state, and logs to a tar file, or restores one with -restore.
+func runBackup(configPath string, config *Config, dirArg string, logPath string, args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	incremental := fs.Bool("incremental", false, "Optional. Only include the files that changed since the last backup")
+	restore := fs.Bool("restore", false, "Optional. Restore the backups (a full one, then any incremental ones) instead")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() == 0 || (!*restore && fs.NArg() != 1) {
+		Log("No backup file specified")
+		return errUsage
+	}
+
+	if configPath == "" {
+		configPath = ActiveProfile.ConfigPath()
+	}
+	mainDir := dirArg
+	if mainDir == "" && config != nil {
+		mainDir = config.Dir
+	}
+	if mainDir != "" {
+		if abs, err := filepath.Abs(mainDir); err == nil {
+			mainDir = abs
+		}
+	}
+
+	sources, err := backupSources(configPath, logPath)
+	if err != nil {
+		return err
+	}
+
+	if *restore {
+		return RestoreBackups(fs.Args(), sources, mainDir)
+	}
+
+	return WriteBackup(fs.Arg(0), sources, mainDir, *incremental)
+}
+
+// backupSources lists the files that a backup covers: the config file, everything in the profile's data directory
+// (such as the state and saved tokens), and the log file with its rotated copies.
+func backupSources(configPath string, logPath string) ([]backupSource, error) {
+	sources := []backupSource{{"config.json", configPath}}
+
+	if dataDir := ActiveProfile.DataDir(); dataDir != "" {
+		err := filepath.Walk(dataDir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if info.IsDir() || info.Name() == lastBackupName || strings.HasSuffix(info.Name(), ".tmp") || strings.HasSuffix(info.Name(), ".bak") || strings.HasSuffix(info.Name(), ".lock") {
+				return nil
+			}
+			// Named profiles live under the default profile's data directory, but they're backed up on their own.
+			rel, _ := filepath.Rel(dataDir, path)
+			if ActiveProfile.Name == "" && strings.HasPrefix(filepath.ToSlash(rel), "profiles/") {
+				return nil
+			}
+			sources = append(sources, backupSource{"data/" + filepath.ToSlash(rel), path})
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("error reading data directory: %v", err)
+		}
+	}
+
+	if logPath != "" {
+		sources = append(sources, backupSource{"logs/" + filepath.Base(logPath), logPath})
+		for i := 1; ; i++ {
+			rotated := logPath + "." + strconv.Itoa(i)
+			if _, err := os.Stat(rotated); err != nil {
+				break
+			}
+			sources = append(sources, backupSource{"logs/" + filepath.Base(rotated), rotated})
+		}
+	}
+
+	return sources, nil
+}
+
+// WriteBackup writes the files that exist among the sources to a new tar file at the path. An incremental backup only
+// includes the files that changed since the last backup, which must be restored first.
+func WriteBackup(path string, sources []backupSource, mainDir string, incremental bool) error {
+	manifest := BackupManifest{
+		Created: time.Now().UTC(),
+		Profile: ActiveProfile.Name,
+		Dir:     mainDir,
+		Files:   make(map[string]string),
+	}
+
+	var last BackupManifest
+	lastPath := filepath.Join(ActiveProfile.DataDir(), lastBackupName)
+	if incremental {
+		data, err := ioutil.ReadFile(lastPath)
+		if err != nil {
+			return fmt.Errorf("no earlier backup to build on (make a full backup first)")
+		}
+		if err := json.Unmarshal(data, &last); err != nil {
+			return fmt.Errorf("error reading last backup: %v", err)
+		}
+		manifest.Base = last.Created
+	}
+
+	contents := make(map[string][]byte)
+	var names []string
+	for _, source := range sources {
+		data, err := ioutil.ReadFile(source.path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		sum := sha256.Sum256(data)
+		manifest.Files[source.name] = hex.EncodeToString(sum[:])
+		if incremental && last.Files[source.name] == manifest.Files[source.name] {
+			continue
+		}
+		contents[source.name] = data
+		names = append(names, source.name)
+	}
+	sort.Strings(names)
+
+	manifestData, err := json.MarshalIndent(manifest, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	files := append([]string{backupManifestName}, names...)
+	contents[backupManifestName] = append(manifestData, '\n')
+	for _, name := range files {
+		header := &tar.Header{Name: name, Mode: 0600, Size: int64(len(contents[name])), ModTime: manifest.Created}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(contents[name]); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	// Backups can hold credentials and tokens, so only the user can read them.
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0600); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(lastPath), 0755); err == nil {
+		if err := ioutil.WriteFile(lastPath, manifestData, 0644); err != nil {
+			Log("Error saving backup record:", err)
+		}
+	}
+
+	if incremental {
+		Log("Backed up", len(names), "changed files of", len(manifest.Files), "to", path)
+	} else {
+		Log("Backed up", len(names), "files to", path)
+	}
+
+	return nil
+}
+
+// RestoreBackups puts the files in the backups back in place, in the order given: a full backup first, and then the
+// incremental backups that build on it. Files that would be replaced are kept with a ".bak" extension. If the main
+// download directory has moved since the backup, the paths in the restored state are moved with it.
+func RestoreBackups(paths []string, sources []backupSource, mainDir string) error {
+	targets := make(map[string]string)
+	logDir := ""
+	for _, source := range sources {
+		targets[source.name] = source.path
+		if strings.HasPrefix(source.name, "logs/") {
+			logDir = filepath.Dir(source.path)
+		}
+	}
+	dataDir := ActiveProfile.DataDir()
+
+	var previous *BackupManifest
+	oldDir := ""
+	for _, path := range paths {
+		manifest, files, err := readBackup(path)
+		if err != nil {
+			return fmt.Errorf("error reading %v: %v", path, err)
+		}
+		if !manifest.Base.IsZero() && (previous == nil || !previous.Created.Equal(manifest.Base)) {
+			return fmt.Errorf("%v is an incremental backup of the backup from %v, which must be restored first", path, manifest.Base.Local().Format(time.RFC1123))
+		}
+		if manifest.Dir != "" {
+			oldDir = manifest.Dir
+		}
+
+		for name, data := range files {
+			target, ok := targets[name]
+			switch {
+			case ok:
+			case strings.HasPrefix(name, "data/") && dataDir != "":
+				target = filepath.Join(dataDir, filepath.FromSlash(strings.TrimPrefix(name, "data/")))
+			case strings.HasPrefix(name, "logs/") && logDir != "":
+				target = filepath.Join(logDir, filepath.Base(name))
+			default:
+				Log("Skipping", name, "- nowhere to restore it to")
+				continue
+			}
+			if err := restoreFile(target, data); err != nil {
+				return fmt.Errorf("error restoring %v: %v", name, err)
+			}
+			Debug("Restored", name, "to", target)
+		}
+		Log("Restored", len(files), "files from", path)
+		previous = &manifest
+	}
+
+	if oldDir != "" && mainDir != "" && oldDir != mainDir {
+		state, err := LoadState(StatePath())
+		if err != nil {
+			return err
+		}
+		moved := state.movePaths(oldDir, mainDir)
+		if err := state.Save(); err != nil {
+			return fmt.Errorf("error saving state: %v", err)
+		}
+		Log("Moved", moved, "episodes from", oldDir, "to", mainDir)
+	}
+
+	return nil
+}
+
+// readBackup reads the manifest and the files out of the backup, checking each file against its checksum.
+func readBackup(path string) (BackupManifest, map[string][]byte, error) {
+	var manifest BackupManifest
+	file, err := os.Open(path)
+	if err != nil {
+		return manifest, nil, err
+	}
+	defer file.Close()
+
+	files := make(map[string][]byte)
+	tr := tar.NewReader(file)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return manifest, nil, err
+		}
+		if !safeMemberName(header.Name) {
+			return manifest, nil, fmt.Errorf("refusing to restore %v - it points outside of the backup", header.Name)
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return manifest, nil, err
+		}
+		files[header.Name] = data
+	}
+
+	data, ok := files[backupManifestName]
+	if !ok {
+		return manifest, nil, fmt.Errorf("not a getcast backup")
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, nil, fmt.Errorf("error parsing manifest: %v", err)
+	}
+	delete(files, backupManifestName)
+
+	for name, data := range files {
+		sum := sha256.Sum256(data)
+		if manifest.Files[name] != hex.EncodeToString(sum[:]) {
+			return manifest, nil, fmt.Errorf("%v is corrupt", name)
+		}
+	}
+
+	return manifest, files, nil
+}
+
+// safeMemberName reports whether the name of a file in a backup stays inside of where it's restored to, i.e. it isn't
+// absolute and doesn't climb out with "..".
+func safeMemberName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "/") || strings.HasPrefix(name, `\`) || filepath.IsAbs(name) ||
+		filepath.VolumeName(name) != "" {
+		return false
+	}
+
+	cleaned := filepath.ToSlash(filepath.Clean(filepath.FromSlash(name)))
+	for _, part := range strings.Split(cleaned, "/") {
+		if part == ".." {
+			return false
+		}
+	}
+
+	return true
+}
+
+// restoreFile writes the data to the path, keeping what was there before as a ".bak" copy if it was different.
+func restoreFile(path string, data []byte) error {
+	if existing, err := ioutil.ReadFile(path); err == nil {
+		if bytes.Equal(existing, data) {
+			return nil
+		}
+		if err := ioutil.WriteFile(path+".bak", existing, 0600); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// movePaths changes the recorded location of every episode under oldDir to the same place under newDir, and returns
+// the number of episodes that moved.
+func (s *State) movePaths(oldDir string, newDir string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	moved := 0
+	prefix := filepath.Clean(oldDir) + string(filepath.Separator)
+	for feedURL, ss := range s.Shows {
+		for key, es := range ss.Episodes {
+			if strings.HasPrefix(es.Path, prefix) {
+				es.Path = filepath.Join(newDir, strings.TrimPrefix(es.Path, prefix))
+				s.touch(feedURL, key)
+				moved++
+			}
+		}
+	}
+
+	return moved
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Test that a full backup and an incremental one restore the config, the state, and the logs on another machine, with
+// the state's paths moved to the new download directory.
+func TestBackupRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The old machine.
+	os.Setenv("XDG_DATA_HOME", filepath.Join(dir, "old", "data"))
+	defer os.Unsetenv("XDG_DATA_HOME")
+
+	oldConfig := filepath.Join(dir, "old", "config.json")
+	oldLog := filepath.Join(dir, "old", "getcast.log")
+	os.MkdirAll(filepath.Dir(oldConfig), 0755)
+	ioutil.WriteFile(oldConfig, []byte(`{"subscriptions": []}`), 0644)
+	ioutil.WriteFile(oldLog, []byte("log"), 0644)
+	ioutil.WriteFile(oldLog+".1", []byte("older log"), 0644)
+
+	state, _ := LoadState(StatePath())
+	e := Episode{Title: "Episode", GUID: "guid"}
+	e.path = filepath.Join(dir, "old", "podcasts", "Show", "episode.mp3")
+	state.RecordDownload("https://example.com/feed", "Show", &e)
+	if err := state.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	sources, err := backupSources(oldConfig, oldLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	full := filepath.Join(dir, "full.tar")
+	if err := WriteBackup(full, sources, filepath.Join(dir, "old", "podcasts"), false); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the config changes before the incremental backup.
+	ioutil.WriteFile(oldConfig, []byte(`{"subscriptions": [{"url": "https://example.com/feed"}]}`), 0644)
+	incremental := filepath.Join(dir, "incremental.tar")
+	if err := WriteBackup(incremental, sources, filepath.Join(dir, "old", "podcasts"), true); err != nil {
+		t.Fatal(err)
+	}
+	if _, files, err := readBackup(incremental); err != nil {
+		t.Fatal(err)
+	} else if len(files) != 1 || files["config.json"] == nil {
+		t.Error("Incremental backup has more than the changed file:", len(files))
+	}
+
+	// The new machine.
+	os.Setenv("XDG_DATA_HOME", filepath.Join(dir, "new", "data"))
+	newConfig := filepath.Join(dir, "new", "config.json")
+	newLog := filepath.Join(dir, "new", "getcast.log")
+	sources, err = backupSources(newConfig, newLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RestoreBackups([]string{incremental}, sources, ""); err == nil {
+		t.Error("Incremental backup was restored without the full backup")
+	}
+	if err := RestoreBackups([]string{full, incremental}, sources, filepath.Join(dir, "new", "podcasts")); err != nil {
+		t.Fatal(err)
+	}
+
+	for path, want := range map[string]string{
+		newConfig:     `{"subscriptions": [{"url": "https://example.com/feed"}]}`,
+		newLog + ".1": "older log",
+	} {
+		if have, err := ioutil.ReadFile(path); err != nil || string(have) != want {
+			t.Error("Incorrect restored file", path, "- Want:", want, "Have:", string(have))
+		}
+	}
+
+	restored, err := LoadState(StatePath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := filepath.Join(dir, "new", "podcasts", "Show", "episode.mp3")
+	if _, ok := restored.ByPath()[want]; !ok {
+		t.Error("State's paths were not moved - Want:", want, "Have:", restored.ByPath())
+	}
+}
+
+// Test that a backup with a file that points outside of where it would be restored to isn't restored.
+func TestRestoreUnsafeName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast-backup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("XDG_DATA_HOME", filepath.Join(dir, "data"))
+	defer os.Unsetenv("XDG_DATA_HOME")
+
+	for _, name := range []string{"data/../../evil", "/etc/evil", "logs/../../evil", "data/a/../../../evil"} {
+		sum := sha256.Sum256([]byte("evil"))
+		manifest, _ := json.Marshal(BackupManifest{Files: map[string]string{name: hex.EncodeToString(sum[:])}})
+
+		path := filepath.Join(dir, "backup.tar")
+		file, err := os.Create(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tw := tar.NewWriter(file)
+		for member, data := range map[string][]byte{backupManifestName: manifest, name: []byte("evil")} {
+			tw.WriteHeader(&tar.Header{Name: member, Mode: 0600, Size: int64(len(data))})
+			tw.Write(data)
+		}
+		tw.Close()
+		file.Close()
+
+		sources, err := backupSources(filepath.Join(dir, "config.json"), filepath.Join(dir, "logs", "getcast.log"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := RestoreBackups([]string{path}, sources, ""); err == nil {
+			t.Error(name, "- Want: error Have: restored")
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "evil")); err == nil {
+		t.Error("File was restored outside of the data directory")
+	}
+}
+
+// Test that only names that stay inside of where they're restored to are safe.
+func TestSafeMemberName(t *testing.T) {
+	names := map[string]bool{
+		"config.json":           true,
+		"data/state.json":       true,
+		"data/a/../state.json":  true,
+		"data/..state.json":     true,
+		"":                      false,
+		"/etc/passwd":           false,
+		"data/../../etc/passwd": false,
+		"../config.json":        false,
+	}
+	for name, want := range names {
+		if have := safeMemberName(name); have != want {
+			t.Error(name, "- Want:", want, "Have:", have)
+		}
+	}
+}
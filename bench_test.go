@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/snhilde/getcast/internal/feedtest"
+)
+
+// quietOutput sends the regular output to /dev/null for the rest of the benchmark, so that it doesn't drown out the
+// results. The returned function puts it back.
+func quietOutput(b *testing.B) func() {
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout = devNull
+	return func() {
+		os.Stdout = stdout
+		devNull.Close()
+	}
+}
+
+// Benchmark a full sync of a new show from a local server, from fetching the feed to writing the tagged files.
+func BenchmarkSync(b *testing.B) {
+	feed := feedtest.Generate("Bench Show", 20, 256*1024)
+	server := feedtest.NewServer(feed)
+	defer server.Close()
+
+	u, err := url.Parse(server.FeedURL(0))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	tmpState, tmpCache, tmpArtwork := StateDB, TagCache, ArtworkMode
+	defer func() { StateDB, TagCache, ArtworkMode = tmpState, tmpCache, tmpArtwork }()
+	ArtworkMode = ArtworkNone
+	defer quietOutput(b)()
+
+	b.SetBytes(int64(len(feed.Episodes) * 256 * 1024))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		dir, err := ioutil.TempDir("", "getcast-bench")
+		if err != nil {
+			b.Fatal(err)
+		}
+		StateDB, _ = LoadState(filepath.Join(dir, "state.json"))
+		TagCache = LoadTagIndex(filepath.Join(dir, "tags.json"))
+		b.StartTimer()
+
+		show := &Show{URL: u}
+		good, bad, err := show.Sync(dir, "")
+		if err != nil || good != len(feed.Episodes) || bad != 0 {
+			b.Fatal("Sync - Want:", len(feed.Episodes), "Have:", good, bad, err)
+		}
+
+		b.StopTimer()
+		os.RemoveAll(dir)
+		b.StartTimer()
+	}
+}
+
+// Benchmark parsing a large feed.
+func BenchmarkParseFeed(b *testing.B) {
+	data := feedtest.Generate("Bench Show", 1000, 0).RSS("https://example.com/episodes")
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ParseFeed(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Benchmark reading the frames of a typical tag.
+func BenchmarkParseFrames(b *testing.B) {
+	tag := feedtest.Generate("Bench Show", 1, 0).Episodes[0].Tag.Bytes()
+	b.SetBytes(int64(len(tag)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if meta := NewMeta(tag); meta.NumFrames() != 4 {
+			b.Fatal("Incorrect number of frames - Want: 4 Have:", meta.NumFrames())
+		}
+	}
+}
+
+// Test that the metadata parser survives malformed tags without panicking, using mutations of the corpus of synthetic
+// tags. (The mutations are the same on every run, so a failure can be reproduced.)
+func TestMetaMutations(t *testing.T) {
+	for i, data := range feedtest.Corpus() {
+		for seed := int64(0); seed < 500; seed++ {
+			mutated := feedtest.Mutate(data, int64(i)*1000+seed)
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						t.Fatalf("Corpus %v, seed %v - Panic parsing %q: %v", i, seed, mutated, r)
+					}
+				}()
+
+				meta := NewMeta(mutated)
+				meta.NumFrames()
+				meta.Issues()
+				meta.Build()
+				readTagFrames(bytes.NewReader(mutated), func(string) bool { return true })
+			}()
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// These are the places that an episode's playback position (bookmark) can be kept.
+const (
+	BookmarksTag     = "tag"     // a TXXX:BOOKMARK frame in the episode's metadata
+	BookmarksSidecar = "sidecar" // a "<episode name>.bookmark" file next to the episode
+)
+
+// bookmarkDesc is the description of the user-defined text frame that holds the bookmark, in seconds.
+const bookmarkDesc = "BOOKMARK"
+
+// ValidateBookmarksMode checks that bookmarks can be kept in the place.
+func ValidateBookmarksMode(mode string) error {
+	switch mode {
+	case "", BookmarksTag, BookmarksSidecar:
+		return nil
+	}
+
+	return fmt.Errorf("invalid bookmarks mode: %v", mode)
+}
+
+// bookmarkPath returns the location of the bookmark sidecar for the episode at the path.
+func bookmarkPath(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + ".bookmark"
+}
+
+// ReadBookmark reads the playback position of the episode at the path, in seconds, and when it was last changed. This
+// reports false if the episode doesn't have a bookmark.
+func ReadBookmark(path string, mode string) (int, time.Time, bool) {
+	var value string
+	var modified time.Time
+	switch mode {
+	case BookmarksTag:
+		info, err := os.Stat(path)
+		if err != nil {
+			return 0, modified, false
+		}
+		meta, err := readFileMeta(path)
+		if err != nil {
+			return 0, modified, false
+		}
+		value, modified = meta.GetUserText(bookmarkDesc), info.ModTime()
+	case BookmarksSidecar:
+		info, err := os.Stat(bookmarkPath(path))
+		if err != nil {
+			return 0, modified, false
+		}
+		data, err := ioutil.ReadFile(bookmarkPath(path))
+		if err != nil {
+			return 0, modified, false
+		}
+		value, modified = string(data), info.ModTime()
+	}
+
+	seconds, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || seconds < 0 {
+		return 0, modified, false
+	}
+
+	return seconds, modified, true
+}
+
+// WriteBookmark saves the playback position of the episode at the path, in seconds. A position of 0 removes the
+// bookmark. Files without ID3 metadata can't hold a bookmark in their tag.
+func WriteBookmark(path string, mode string, seconds int) error {
+	switch mode {
+	case BookmarksTag:
+		meta, err := readFileMeta(path)
+		if err != nil {
+			return err
+		}
+		if meta.Version() == 0 {
+			return fmt.Errorf("%v has no ID3 metadata", filepath.Base(path))
+		}
+
+		value := ""
+		if seconds > 0 {
+			value = strconv.Itoa(seconds)
+		}
+		if meta.GetUserText(bookmarkDesc) == value {
+			return nil
+		}
+		if value == "" {
+			meta.RemoveUserText(bookmarkDesc)
+		} else {
+			meta.SetUserText(bookmarkDesc, value)
+		}
+		if err := writeFileMeta(path, meta); err != nil {
+			return err
+		}
+		return refreshChecksum(path)
+	case BookmarksSidecar:
+		if seconds <= 0 {
+			if err := os.Remove(bookmarkPath(path)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return nil
+		}
+		return ioutil.WriteFile(bookmarkPath(path), []byte(strconv.Itoa(seconds)+"\n"), 0644)
+	}
+
+	return nil
+}
+
+// SetBookmark sets the playback position of the episode of the show (by feed URL or title), matched by either its
+// title (case-insensitive) or its GUID. This returns the location of the episode on disk.
+func (s *State) SetBookmark(show string, episode string, config *Config, seconds int) (string, error) {
+	if s == nil {
+		return "", fmt.Errorf("no state available")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	feedURL := s.findShow(show, config)
+	ss, ok := s.Shows[feedURL]
+	if feedURL == "" || !ok {
+		return "", fmt.Errorf("show not found: %v", show)
+	}
+
+	for key, es := range ss.Episodes {
+		if strings.EqualFold(es.Title, episode) || es.GUID == episode {
+			es.setBookmark(seconds, time.Now())
+			s.touch(feedURL, key)
+			return es.Path, nil
+		}
+	}
+
+	return "", fmt.Errorf("episode not found: %v", episode)
+}
+
+// updateBookmark sets the playback position of the episode at the path, unless the state has a newer one. This reports
+// whether or not the position changed.
+func (s *State) updateBookmark(path string, seconds int, when time.Time) bool {
+	if s == nil {
+		return false
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for feedURL, ss := range s.Shows {
+		for key, es := range ss.Episodes {
+			if es.Path != path {
+				continue
+			}
+			if es.Position == seconds || !when.After(es.Bookmarked) {
+				return false
+			}
+			es.setBookmark(seconds, when)
+			s.touch(feedURL, key)
+			return true
+		}
+	}
+
+	return false
+}
+
+// setBookmark records the playback position. An episode whose position reaches its end is marked as played.
+func (es *EpisodeState) setBookmark(seconds int, when time.Time) {
+	es.Position = seconds
+	es.Bookmarked = when
+
+	if duration := parseItunesDuration(es.Duration); duration > 0 && float64(seconds) >= float64(duration)*playedFraction {
+		es.setPlayed(true, when)
+	}
+}
+
+// runBookmark sets the playback position of the episode in args, e.g. "bookmark <show> <episode> 1:02:03", and saves it
+// with the episode if -bookmarks is set.
+func runBookmark(state *State, config *Config, args []string) error {
+	if len(args) != 3 {
+		Log("Need a show, an episode, and a position")
+		return errUsage
+	}
+
+	seconds := parseItunesDuration(args[2])
+	if seconds == 0 && strings.Trim(args[2], "0:") != "" {
+		return fmt.Errorf("invalid position: %v", args[2])
+	}
+
+	path, err := state.SetBookmark(args[0], args[1], config, seconds)
+	if err != nil {
+		return err
+	}
+
+	if err := state.Save(); err != nil {
+		return fmt.Errorf("error saving state: %v", err)
+	}
+
+	if Bookmarks != "" && path != "" {
+		if err := WriteBookmark(path, Bookmarks, seconds); err != nil {
+			return fmt.Errorf("error saving bookmark: %v", err)
+		}
+	}
+
+	if seconds == 0 {
+		Log("Cleared bookmark of", args[1])
+	} else {
+		Log("Bookmarked", args[1], "at", formatSeconds(seconds))
+	}
+
+	return nil
+}
+
+// pullBookmarks reads the bookmarks of the files that getcast put on the device (relative to the device's directory)
+// into the state, wherever they're newer than the state's. The episodes in the main directory get the new bookmarks
+// too. This returns the number of bookmarks that were updated.
+func pullBookmarks(mainDir string, deviceDir string, files []string) int {
+	updated := 0
+	for _, rel := range files {
+		seconds, when, ok := ReadBookmark(filepath.Join(deviceDir, filepath.FromSlash(rel)), Bookmarks)
+		if !ok {
+			continue
+		}
+
+		path, err := filepath.Abs(filepath.Join(mainDir, filepath.FromSlash(rel)))
+		if err != nil || !StateDB.updateBookmark(path, seconds, when) {
+			continue
+		}
+		updated++
+
+		if err := WriteBookmark(path, Bookmarks, seconds); err != nil {
+			Log("Error saving bookmark of", filepath.Base(path), "-", err)
+		}
+	}
+
+	return updated
+}
+
+// pushBookmarks writes the state's bookmarks to the episodes on the device (relative to the main directory), so that
+// playback continues where it left off on any device.
+func pushBookmarks(mainDir string, deviceDir string, files []string) {
+	episodes := StateDB.ByPath()
+	for _, rel := range files {
+		path, err := filepath.Abs(filepath.Join(mainDir, rel))
+		if err != nil {
+			continue
+		}
+		es, ok := episodes[path]
+		if !ok {
+			continue
+		}
+
+		devicePath := filepath.Join(deviceDir, rel)
+		if seconds, _, ok := ReadBookmark(devicePath, Bookmarks); ok && seconds == es.Position {
+			continue
+		} else if !ok && es.Position == 0 {
+			continue
+		}
+
+		if err := WriteBookmark(devicePath, Bookmarks, es.Position); err != nil {
+			Log("Error saving bookmark of", rel, "-", err)
+		}
+	}
+}
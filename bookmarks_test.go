@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test that bookmarks are saved in and read back from both the tag and a sidecar, and that writing them to the tag
+// leaves the rest of the file alone.
+func TestBookmarks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast-bookmarks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// This is an ID3v2.3 tag with only a title, followed by some audio.
+	frame := append([]byte("TIT2\x00\x00\x00\x06\x00\x00"), []byte("\x00Title")...)
+	tag := append([]byte("ID3\x03\x00\x00\x00\x00\x00"), byte(len(frame)))
+	audio := bytes.Repeat([]byte{0xFF, 0xFB, 0x90, 0x00}, 64)
+	path := filepath.Join(dir, "episode.mp3")
+	if err := ioutil.WriteFile(path, append(append(tag, frame...), audio...), 0600); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mode := range []string{BookmarksTag, BookmarksSidecar} {
+		if _, _, ok := ReadBookmark(path, mode); ok {
+			t.Error(mode, "- Found bookmark before one was written")
+		}
+
+		if err := WriteBookmark(path, mode, 1234); err != nil {
+			t.Fatal(mode, "-", err)
+		}
+		if seconds, _, ok := ReadBookmark(path, mode); !ok || seconds != 1234 {
+			t.Error(mode, "- Incorrect bookmark - Want:", 1234, "Have:", seconds, ok)
+		}
+
+		if err := WriteBookmark(path, mode, 0); err != nil {
+			t.Fatal(mode, "-", err)
+		}
+		if _, _, ok := ReadBookmark(path, mode); ok {
+			t.Error(mode, "- Found bookmark after it was cleared")
+		}
+	}
+
+	// The audio has to come through the rewritten tag untouched.
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasSuffix(data, audio) {
+		t.Error("Audio changed by writing bookmarks")
+	}
+	if meta, err := readFileMeta(path); err != nil || getTag(meta, "TIT2") != "Title" {
+		t.Error("Title lost by writing bookmarks")
+	}
+
+	// Players and library scans shouldn't see the episode as a new file.
+	if info, err := os.Stat(path); err != nil {
+		t.Error(err)
+	} else if info.Mode().Perm() != 0600 || !info.ModTime().Equal(modTime) {
+		t.Error("File changed by writing bookmarks - Want:", os.FileMode(0600), modTime, "Have:", info.Mode().Perm(),
+			info.ModTime())
+	}
+}
+
+// Test that a device's bookmarks only replace older ones in the state, and that a bookmark at the end of the episode
+// marks it as played.
+func TestUpdateBookmark(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast-bookmarks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	state, err := LoadState(filepath.Join(dir, "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := Episode{Title: "Episode", GUID: "guid", Duration: "10:00"}
+	e.path = filepath.Join(dir, "Show", "episode.mp3")
+	state.RecordDownload("https://example.com/feed", "Show", &e)
+
+	path, err := state.SetBookmark("Show", "episode", nil, 120)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bookmarked := state.ByPath()[path].Bookmarked
+
+	if state.updateBookmark(path, 60, bookmarked.Add(-time.Minute)) {
+		t.Error("Older bookmark replaced newer one")
+	}
+	if !state.updateBookmark(path, 300, bookmarked.Add(time.Minute)) {
+		t.Error("Newer bookmark was not used")
+	}
+	if es := state.ByPath()[path]; es.Position != 300 || !es.Played.IsZero() {
+		t.Error("Incorrect bookmark - Want:", 300, "Have:", es.Position, es.Played)
+	}
+
+	if !state.updateBookmark(path, 590, bookmarked.Add(2*time.Minute)) {
+		t.Error("Newer bookmark was not used")
+	}
+	if es := state.ByPath()[path]; es.Played.IsZero() {
+		t.Error("Episode bookmarked at its end was not marked as played")
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/snhilde/getcast/internal/httpcache"
+)
+
+// These limit how much the HTTP cache keeps. The cache is pruned when it's first used, and then once every
+// httpCachePruneEvery for as long as the daemon runs.
+const (
+	httpCacheMaxAge     = 30 * 24 * time.Hour // longest to keep a resource that isn't used
+	httpCacheMaxSize    = 256 << 20           // most bytes to keep in total
+	httpCachePruneEvery = 24 * time.Hour
+)
+
+// httpCachePruned is when this process last pruned the HTTP cache.
+var (
+	httpCachePruned time.Time
+	httpCacheMutex  sync.Mutex
+)
+
+// fetchRequest describes a resource to get through the HTTP cache with fetchCached.
+type fetchRequest struct {
+	link       string
+	creds      *Credentials
+	small      bool // don't take up one of the host's request slots (see httpGetSmall)
+	compressed bool // ask for the resource compressed and decompress it (see decodeBody)
+	revalidate bool // ask the server even if the cached copy hasn't gone stale yet
+	limit      int  // largest resource to read, or 0 for no limit
+}
+
+// requestError is an error from fetchCached that came before any of the resource was read: either the request failed,
+// or the server responded with something other than the resource.
+type requestError struct {
+	err error
+}
+
+// Error returns the underlying error's message.
+func (e *requestError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *requestError) Unwrap() error {
+	return e.err
+}
+
+// httpCache returns the cache of fetched feeds, images, and other resources in the profile's cache directory, or nil if
+// there's no cache directory. The cache is pruned the first time, and again once a day.
+func httpCache() *httpcache.Cache {
+	dir := ActiveProfile.CacheDir()
+	if dir == "" {
+		return nil
+	}
+
+	cache := httpcache.New(filepath.Join(dir, "http"))
+	httpCacheMutex.Lock()
+	defer httpCacheMutex.Unlock()
+	if time.Since(httpCachePruned) >= httpCachePruneEvery {
+		httpCachePruned = time.Now()
+		if n, err := cache.Prune(httpCacheMaxAge, httpCacheMaxSize); err != nil {
+			Log("Error pruning HTTP cache:", err)
+		} else if n > 0 {
+			Debug("Removed", n, "old resources from the HTTP cache")
+		}
+	}
+
+	return cache
+}
+
+// fetchCached returns the resource at the link, using the cached copy if the server allows it (with Cache-Control or
+// Expires) or says that it hasn't changed since (with ETag or Last-Modified). Anything newly fetched is kept in the
+// cache for next time, if the server allows that.
+func fetchCached(r fetchRequest) ([]byte, error) {
+	cache := httpCache()
+	entry := cache.Get(r.link)
+	if entry.Fresh() && !r.revalidate {
+		Debug("Using cached copy of", r.link)
+		return entry.Data, nil
+	}
+
+	header := http.Header{}
+	if r.compressed {
+		header.Set("Accept-Encoding", acceptEncoding)
+	}
+	validating := entry.Validate(header)
+
+	resp, err := get(r.link, r.creds, !r.small, header)
+	if err != nil {
+		return nil, &requestError{err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified && validating {
+		Debug("Using cached copy of", r.link, "(not modified)")
+		if err := cache.Revalidated(entry, resp.Header); err != nil {
+			Debug("Error updating cache:", err)
+		}
+		return entry.Data, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &requestError{errors.New(resp.Status)}
+	}
+
+	if r.compressed {
+		encoding, err := decodeBody(resp)
+		if err != nil {
+			return nil, err
+		} else if encoding != "" {
+			Debug(r.link, "is compressed with", encoding)
+		}
+	}
+	body, err := limitBody(resp, r.limit)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cache.Store(r.link, resp.Header, data); err != nil {
+		Debug("Error caching", r.link+":", err)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxChaptersSize is the largest chapters file we'll read from a feed's podcast:chapters link.
+const maxChaptersSize = 1 << 20
+
+// ChaptersLink is a podcast:chapters element from the Podcasting 2.0 namespace, linking to the episode's chapters.
+type ChaptersLink struct {
+	URL  string `xml:"url,attr"`
+	Type string `xml:"type,attr"`
+}
+
+// Chapter is a single chapter of an episode.
+type Chapter struct {
+	Title string  `json:"title,omitempty"`
+	Start float64 `json:"start"` // seconds from the start of the episode
+	Img   string  `json:"img,omitempty"`
+	URL   string  `json:"url,omitempty"`
+}
+
+// chaptersFile is how the chapters are saved next to the episode.
+type chaptersFile struct {
+	Chapters []Chapter `json:"chapters"`
+}
+
+// SaveChapters writes the episode's chapters next to its audio file as "<episode name>.chapters.json". The chapters
+// listed in the feed are preferred, since they can have images and links, with the file's own chapter (CHAP) frames
+// used otherwise. Nothing is written if the episode has no chapters. This must be called after the episode has been
+// downloaded.
+func (e *Episode) SaveChapters() error {
+	if e == nil || e.path == "" {
+		return nil
+	}
+
+	chapters, err := e.feedChapters()
+	if err != nil {
+		Log("Error reading chapters from feed:", err)
+	}
+	// The audio might not have been trimmed as configured (such as when ffmpeg isn't installed), so the chapters are
+	// only moved to match what was actually cut.
+	chapters = shiftChapters(chapters, e.trimStart, e.trimLength)
+	if len(chapters) == 0 {
+		meta := e.meta
+		if meta == nil || !meta.Buffered() {
+			if meta, err = readFileMeta(e.path); err != nil {
+				return err
+			}
+		}
+		chapters = metaChapters(meta)
+	}
+	if len(chapters) == 0 {
+		e.debug("No chapters for", e.Title)
+		return nil
+	}
+
+	data, err := json.MarshalIndent(chaptersFile{chapters}, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	chaptersPath := strings.TrimSuffix(e.path, filepath.Ext(e.path)) + ".chapters.json"
+	e.debug("Saving", len(chapters), "chapters to", chaptersPath)
+	return ioutil.WriteFile(chaptersPath, append(data, '\n'), 0644)
+}
+
+// feedChapters fetches the chapters linked in the episode's podcast:chapters element, if it has one. Only the JSON
+// chapters format is supported.
+func (e *Episode) feedChapters() ([]Chapter, error) {
+	link := strings.TrimSpace(e.Chapters.URL)
+	if link == "" {
+		return nil, nil
+	}
+	if e.Chapters.Type != "" && !strings.Contains(strings.ToLower(e.Chapters.Type), "json") {
+		e.debug("Unsupported chapters type:", e.Chapters.Type)
+		return nil, nil
+	}
+	if e.external(link) {
+		return nil, nil
+	}
+
+	data, err := fetchCached(fetchRequest{link: link, creds: e.showAuth, limit: maxChaptersSize})
+	if err != nil {
+		return nil, err
+	}
+
+	return parseJSONChapters(data)
+}
+
+// parseJSONChapters reads the chapters out of a file in the Podcasting 2.0 JSON chapters format. Chapters that are
+// marked to be left out of the table of contents are skipped.
+func parseJSONChapters(data []byte) ([]Chapter, error) {
+	var file struct {
+		Chapters []struct {
+			StartTime float64 `json:"startTime"`
+			Title     string  `json:"title"`
+			Img       string  `json:"img"`
+			URL       string  `json:"url"`
+			TOC       *bool   `json:"toc"`
+		} `json:"chapters"`
+	}
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("error parsing chapters: %v", err)
+	}
+
+	var chapters []Chapter
+	for _, c := range file.Chapters {
+		if c.TOC != nil && !*c.TOC {
+			continue
+		}
+		chapters = append(chapters, Chapter{Title: c.Title, Start: c.StartTime, Img: c.Img, URL: c.URL})
+	}
+	sortChapters(chapters)
+
+	return chapters, nil
+}
+
+// metaChapters reads the chapters out of the chapter (CHAP) frames in the metadata.
+func metaChapters(meta *Meta) []Chapter {
+	var chapters []Chapter
+	for _, value := range meta.GetValues("CHAP") {
+		if chapter, ok := parseChapterFrame(value, meta.Version()); ok {
+			chapters = append(chapters, chapter)
+		}
+	}
+	sortChapters(chapters)
+
+	return chapters
+}
+
+// parseChapterFrame decodes the value of a CHAP frame. The value is a null-terminated element ID, the start and end
+// times in milliseconds, the start and end byte offsets, and then sub-frames describing the chapter. The title comes
+// from the TIT2 sub-frame and the link from the WXXX sub-frame.
+func parseChapterFrame(value []byte, version byte) (Chapter, bool) {
+	i := bytes.IndexByte(value, 0x00)
+	if i < 0 || len(value) < i+1+16 {
+		return Chapter{}, false
+	}
+	value = value[i+1:]
+
+	chapter := Chapter{Start: float64(binary.BigEndian.Uint32(value[0:4])) / 1000}
+
+	buf := bytes.NewBuffer(value[16:])
+	for buf.Len() > 0 {
+		id := readID(buf, version)
+		size := readLen(buf, version, false)
+		if id == nil || size <= 0 || len(buf.Next(2)) != 2 {
+			break
+		}
+		sub := buf.Next(size)
+		if len(sub) != size {
+			break
+		}
+
+		switch string(id) {
+		case "TIT2":
+			chapter.Title = string(decodeText(sub))
+		case "WXXX":
+			chapter.URL = userURL(sub)
+		}
+	}
+
+	return chapter, true
+}
+
+// userURL returns the URL in the value of a user-defined URL (WXXX) frame. The description before it is in the encoding
+// named by the first byte, but the URL itself is always ISO-8859-1.
+func userURL(value []byte) string {
+	if len(value) < 2 {
+		return ""
+	}
+
+	encoding, value := value[0], value[1:]
+	if encoding == 0x01 || encoding == 0x02 {
+		// UTF-16 descriptions end with two null bytes, aligned to a character.
+		for i := 0; i+1 < len(value); i += 2 {
+			if value[i] == 0x00 && value[i+1] == 0x00 {
+				return string(bytes.TrimRight(value[i+2:], "\x00"))
+			}
+		}
+		return ""
+	}
+
+	i := bytes.IndexByte(value, 0x00)
+	if i < 0 {
+		return ""
+	}
+
+	return string(bytes.TrimRight(value[i+1:], "\x00"))
+}
+
+// shiftChapters moves the chapters back by the length of the intro that was trimmed off the episode. Of the chapters
+// that started in the intro, only the last one is kept, starting at the beginning. If the length of what's left is
+// known, the chapters that started in the trimmed outro are dropped.
+func shiftChapters(chapters []Chapter, trimmed time.Duration, length time.Duration) []Chapter {
+	if trimmed <= 0 && length <= 0 {
+		return chapters
+	}
+
+	offset := trimmed.Seconds()
+	var shifted []Chapter
+	for _, chapter := range chapters {
+		chapter.Start -= offset
+		if length > 0 && chapter.Start >= length.Seconds() {
+			continue
+		}
+		if chapter.Start <= 0 {
+			chapter.Start = 0
+			if len(shifted) > 0 && shifted[len(shifted)-1].Start == 0 {
+				shifted = shifted[:len(shifted)-1]
+			}
+		}
+		shifted = append(shifted, chapter)
+	}
+
+	return shifted
+}
+
+// sortChapters puts the chapters in order of their start times.
+func sortChapters(chapters []Chapter) {
+	sort.SliceStable(chapters, func(i, j int) bool {
+		return chapters[i].Start < chapters[j].Start
+	})
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test that chapters are read from CHAP frames, including their sub-frames.
+func TestParseChapterFrame(t *testing.T) {
+	// Build a v2.3 CHAP frame with a UTF-8 title and a link with a description.
+	subframe := func(id string, value []byte) []byte {
+		frame := append([]byte(id), writeLen(len(value), 3, false)...)
+		frame = append(frame, 0x00, 0x00)
+		return append(frame, value...)
+	}
+	times := make([]byte, 16)
+	binary.BigEndian.PutUint32(times[0:], 90500)
+	binary.BigEndian.PutUint32(times[4:], 120000)
+	binary.BigEndian.PutUint32(times[8:], 0xFFFFFFFF)
+	binary.BigEndian.PutUint32(times[12:], 0xFFFFFFFF)
+
+	var value bytes.Buffer
+	value.WriteString("chp1\x00")
+	value.Write(times)
+	value.Write(subframe("TIT2", []byte("\x03Café talk\x00")))
+	value.Write(subframe("WXXX", []byte("\x00Website\x00https://example.com/cafe")))
+
+	want := Chapter{Title: "Café talk", Start: 90.5, URL: "https://example.com/cafe"}
+	have, ok := parseChapterFrame(value.Bytes(), 3)
+	if !ok || have != want {
+		t.Error("Want:", want, "Have:", have)
+	}
+
+	// UTF-16 descriptions end with two null bytes.
+	if have := userURL([]byte("\x01\xFF\xFEL\x00i\x00n\x00k\x00\x00\x00https://example.com")); have != "https://example.com" {
+		t.Error("UTF-16 link - Want: https://example.com Have:", have)
+	}
+
+	if _, ok := parseChapterFrame([]byte("chp1"), 3); ok {
+		t.Error("Parsed frame without times")
+	}
+}
+
+// Test that chapters from a podcast:chapters file are sorted and that hidden chapters are skipped.
+func TestParseJSONChapters(t *testing.T) {
+	data := []byte(`{"version": "1.2.0", "chapters": [
+		{"startTime": 300, "title": "Second", "img": "https://example.com/2.jpg"},
+		{"startTime": 150, "title": "Hidden", "toc": false},
+		{"startTime": 0, "title": "First", "url": "https://example.com"}
+	]}`)
+
+	chapters, err := parseJSONChapters(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Chapter{
+		{Title: "First", Start: 0, URL: "https://example.com"},
+		{Title: "Second", Start: 300, Img: "https://example.com/2.jpg"},
+	}
+	if len(chapters) != len(want) {
+		t.Fatal("Want:", len(want), "chapters, Have:", len(chapters))
+	}
+	for i := range want {
+		if chapters[i] != want[i] {
+			t.Error("Want:", want[i], "Have:", chapters[i])
+		}
+	}
+
+	if _, err := parseJSONChapters([]byte("<chapters/>")); err == nil {
+		t.Error("Parsed invalid chapters")
+	}
+}
+
+// Test that chapters are moved back to match a trimmed intro.
+func TestShiftChapters(t *testing.T) {
+	chapters := []Chapter{
+		{Title: "Intro", Start: 0},
+		{Title: "Ads", Start: 20},
+		{Title: "Topic", Start: 45},
+	}
+
+	tests := []struct {
+		start  time.Duration
+		length time.Duration
+		want   []Chapter
+	}{
+		{30 * time.Second, 0, []Chapter{{Title: "Ads", Start: 0}, {Title: "Topic", Start: 15}}},
+		{30 * time.Second, 10 * time.Second, []Chapter{{Title: "Ads", Start: 0}}},
+		{0, 40 * time.Second, []Chapter{{Title: "Intro", Start: 0}, {Title: "Ads", Start: 20}}},
+		{0, 0, chapters},
+	}
+	for _, test := range tests {
+		have := shiftChapters(chapters, test.start, test.length)
+		if len(have) != len(test.want) {
+			t.Error(test.start, test.length, "- Want:", test.want, "Have:", have)
+			continue
+		}
+		for i := range test.want {
+			if have[i] != test.want[i] {
+				t.Error(test.start, test.length, "- Want:", test.want[i], "Have:", have[i])
+			}
+		}
+	}
+
+	// The CHAP frames in the metadata are moved too, and kept within the trimmed length.
+	times := make([]byte, 16)
+	binary.BigEndian.PutUint32(times[0:], 45000)
+	binary.BigEndian.PutUint32(times[4:], 600000)
+	meta := &Meta{frames: []Frame{{"CHAP", append([]byte("chp2\x00"), times...)}}}
+	shiftChapterFrames(meta, 30*time.Second, 5*time.Minute)
+
+	value := meta.frames[0].value[5:]
+	if start := binary.BigEndian.Uint32(value[0:]); start != 15000 {
+		t.Error("Start - Want: 15000 Have:", start)
+	}
+	if end := binary.BigEndian.Uint32(value[4:]); end != 300000 {
+		t.Error("End - Want: 300000 Have:", end)
+	}
+	if offset := binary.BigEndian.Uint32(value[8:]); offset != 0xFFFFFFFF {
+		t.Error("Offset - Want: unused Have:", offset)
+	}
+}
+
+// Test that the saved chapters are only moved when the episode was actually trimmed, not just configured to be.
+func TestSaveChaptersUntrimmed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast-chapters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("XDG_CACHE_HOME", dir)
+	defer os.Unsetenv("XDG_CACHE_HOME")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"version": "1.2.0", "chapters": [{"startTime": 0, "title": "Intro"},
+			{"startTime": 45, "title": "Topic"}]}`))
+	}))
+	defer server.Close()
+
+	// Trimming failed, so the episode still has its intro.
+	e := Episode{settings: &ShowSettings{TrimStart: &Duration{30 * time.Second}}}
+	e.Chapters.URL = server.URL + "/chapters.json"
+	e.path = filepath.Join(dir, "episode.mp3")
+	if err := e.SaveChapters(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "episode.chapters.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file chaptersFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatal(err)
+	}
+	if len(file.Chapters) != 2 || file.Chapters[1].Start != 45 {
+		t.Error("Chapters moved for an untrimmed episode - Want: 45 Have:", file.Chapters)
+	}
+}
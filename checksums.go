@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ChecksumsFile is the name of the file in each show's directory that lists the SHA-256 checksums of its episodes, in
+// the format that "sha256sum -c" reads.
+const ChecksumsFile = "SHA256SUMS"
+
+// UpdateChecksums adds the checksums of the files (which must be in the show's directory) to the show's checksums
+// file, replacing any old ones. Entries for files that are no longer there are dropped, so that the list always matches
+// the directory.
+func UpdateChecksums(showDir string, paths ...string) error {
+	sums, err := readChecksums(showDir)
+	if err != nil {
+		return err
+	}
+
+	for rel := range sums {
+		if _, err := os.Stat(filepath.Join(showDir, filepath.FromSlash(rel))); os.IsNotExist(err) {
+			delete(sums, rel)
+		}
+	}
+
+	for _, path := range paths {
+		rel, err := filepath.Rel(showDir, path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return fmt.Errorf("%v is not in the show's directory", filepath.Base(path))
+		}
+
+		sum, err := fileChecksum(path)
+		if err != nil {
+			return err
+		}
+		sums[filepath.ToSlash(rel)] = sum
+	}
+
+	return writeChecksums(showDir, sums)
+}
+
+// refreshChecksum updates the checksum of the file after it was changed in place, if the show that it's in keeps a
+// checksums file. The show's directory is the closest directory above the file that has one.
+func refreshChecksum(path string) error {
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(filepath.Join(dir, ChecksumsFile)); err == nil {
+			return UpdateChecksums(dir, path)
+		}
+		if parent := filepath.Dir(dir); parent == dir {
+			return nil
+		}
+	}
+}
+
+// fileChecksum returns the hex-encoded SHA-256 checksum of the file's contents.
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// readChecksums reads the show's checksums file into a map of checksums keyed by path (relative to the show's
+// directory, with forward slashes). A show without a checksums file has an empty map.
+func readChecksums(showDir string) (map[string]string, error) {
+	sums := make(map[string]string)
+
+	file, err := os.Open(filepath.Join(showDir, ChecksumsFile))
+	if os.IsNotExist(err) {
+		return sums, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// Each line is the checksum, a space, and then either a space (text mode) or "*" (binary mode) before the path.
+		line := scanner.Text()
+		if len(line) < 67 || line[64] != ' ' {
+			continue
+		}
+		sums[line[66:]] = line[:64]
+	}
+
+	return sums, scanner.Err()
+}
+
+// writeChecksums saves the checksums to the show's checksums file, sorted by path. The file is first written to a
+// temporary file and then moved into place so that a mirror never picks up a partial list.
+func writeChecksums(showDir string, sums map[string]string) error {
+	paths := make([]string, 0, len(sums))
+	for rel := range sums {
+		paths = append(paths, rel)
+	}
+	sort.Strings(paths)
+
+	var b strings.Builder
+	for _, rel := range paths {
+		b.WriteString(sums[rel] + "  " + rel + "\n")
+	}
+
+	path := filepath.Join(showDir, ChecksumsFile)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(b.String()), 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
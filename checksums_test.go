@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Test that the checksums file is kept in step with the show's directory.
+func TestUpdateChecksums(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast-checksums")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	one := filepath.Join(dir, "one.mp3")
+	two := filepath.Join(dir, "2021", "two.mp3")
+	os.MkdirAll(filepath.Dir(two), 0755)
+	ioutil.WriteFile(one, []byte("one"), 0644)
+	ioutil.WriteFile(two, []byte("two"), 0644)
+
+	if err := UpdateChecksums(dir, one, two); err != nil {
+		t.Fatal(err)
+	}
+
+	// These are the checksums from sha256sum.
+	want := "3fc4ccfe745870e2c0d99f71f30ff0656c8dedd41cc1d7d3d376b0dbe685e2f3  2021/two.mp3\n" +
+		"7692c3ad3540bb803c020b3aee66cd8887123234ea0c6e7143c0add73ff431ed  one.mp3\n"
+	have, err := ioutil.ReadFile(filepath.Join(dir, ChecksumsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(have) != want {
+		t.Error("Incorrect checksums - Want:", want, "Have:", string(have))
+	}
+
+	// Changing a file and trashing another updates the list.
+	ioutil.WriteFile(one, []byte("three"), 0644)
+	if err := refreshChecksum(one); err != nil {
+		t.Fatal(err)
+	}
+	if err := TrashFile(dir, two); err != nil {
+		t.Fatal(err)
+	}
+
+	want = "8b5b9db0c13db24256c829aa364aa90c6d2eba318b9232a4ab9313b954d3555f  one.mp3\n"
+	have, err = ioutil.ReadFile(filepath.Join(dir, ChecksumsFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(have) != want {
+		t.Error("Incorrect checksums - Want:", want, "Have:", string(have))
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// breaker keeps track of the hosts that keep failing so that we can move on to other shows instead of retrying them.
+var breaker = circuitBreaker{}
+
+// circuitBreaker stops requests to a host after it fails too many times in a row. The circuit stays open for
+// CircuitCooldown, and then a single request is let through to test the host: if it fails, the circuit opens again,
+// and if it succeeds, the host is back to normal.
+type circuitBreaker struct {
+	mutex    sync.Mutex
+	failures map[string]int       // number of server errors in a row for each host
+	open     map[string]time.Time // time at which each host's open circuit can be tested again
+	probing  map[string]bool      // hosts whose test request is in progress
+}
+
+// allow returns an error of kind ErrCircuitOpen if the host's circuit is open.
+func (b *circuitBreaker) allow(host string) error {
+	if CircuitThreshold <= 0 {
+		return nil
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	until, ok := b.open[host]
+	if !ok {
+		return nil
+	}
+	if d := time.Until(until); d > 0 {
+		return newError(ErrCircuitOpen, "skipping %v for another %v after repeated server errors", host, d.Round(time.Second))
+	}
+
+	// The cooldown is over, but only one request gets to test the host. The others are skipped until it finishes.
+	if b.probing[host] {
+		return newError(ErrCircuitOpen, "skipping %v while it's tested after repeated server errors", host)
+	}
+	Debug("Testing", host, "again after cooldown")
+	if b.probing == nil {
+		b.probing = make(map[string]bool)
+	}
+	b.probing[host] = true
+	return nil
+}
+
+// record counts the outcome of a request to the host, opening the circuit if the host has failed too many times in a
+// row. Connection errors, 429 Too Many Requests, and 5xx responses count as failures.
+func (b *circuitBreaker) record(host string, resp *http.Response, err error) {
+	if CircuitThreshold <= 0 {
+		return
+	}
+
+	failed := false
+	switch {
+	case err != nil:
+		// Stopping the sync isn't the host's fault.
+		failed = !errors.Is(err, context.Canceled)
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		failed = true
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.failures == nil {
+		b.failures = make(map[string]int)
+		b.open = make(map[string]time.Time)
+	}
+
+	// The test request after the cooldown decides on its own whether the circuit closes or opens again.
+	probe := b.probing[host]
+	delete(b.probing, host)
+
+	if !failed {
+		delete(b.failures, host)
+		if probe {
+			delete(b.open, host)
+		}
+		return
+	}
+
+	b.failures[host]++
+	if probe || b.failures[host] >= CircuitThreshold {
+		Log("Too many server errors from", host+", skipping it for", CircuitCooldown)
+		b.open[host] = time.Now().Add(CircuitCooldown)
+		delete(b.failures, host)
+	}
+}
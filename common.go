@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
 	"math"
 	"os"
@@ -22,12 +23,17 @@ func Log(a ...interface{}) {
 // Debug prints additional process information if Debug Mode is enabled. If a Log File was specified, it also writes
 // everything to the log.
 func Debug(a ...interface{}) {
-	if DebugMode || LogFile != nil {
+	debugLog(DebugMode, a...)
+}
+
+// debugLog prints the debug message if debug messages are enabled, and writes it to the Log File if one was specified.
+func debugLog(enabled bool, a ...interface{}) {
+	if enabled || LogFile != nil {
 		out := fmt.Sprintln(a...)
 		out = strings.TrimSuffix(out, "\n")
 		lines := strings.Split(out, "\n")
 		for _, line := range lines {
-			if DebugMode {
+			if enabled {
 				fmt.Println("(DEBUG)", line)
 			}
 			if LogFile != nil {
@@ -37,19 +43,138 @@ func Debug(a ...interface{}) {
 	}
 }
 
-// Reduce converts the number of bytes into its human-readable value (less than 1024) with SI unit suffix appended.
+// logOnly writes debug messages to the Log File, if one was specified, without printing them.
+func logOnly(a ...interface{}) {
+	if LogFile == nil {
+		return
+	}
+
+	out := strings.TrimSuffix(fmt.Sprintln(a...), "\n")
+	for _, line := range strings.Split(out, "\n") {
+		fmt.Fprintln(LogFile, "(DEBUG)", line)
+	}
+}
+
+// Confirm asks the user a yes/no question and returns whether or not they answered yes. If AssumeYes is set, this
+// returns true without asking. If there's no terminal to ask on (such as when running as a service), this returns false.
+func Confirm(question string) bool {
+	if AssumeYes {
+		Debug(question, "(assuming yes)")
+		return true
+	}
+
+	if !isTerminal(os.Stdin) {
+		Log(question, "(no terminal to confirm on, assuming no; use -y to assume yes)")
+		return false
+	}
+
+	fmt.Print(question + " [y/N] ")
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+
+	return answer == "y" || answer == "yes"
+}
+
+// isTerminal reports whether or not the file is a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// These are the systems of units that sizes can be shown in.
+const (
+	UnitsBinary = "binary" // powers of 1024: KiB, MiB, GiB, etc.
+	UnitsSI     = "si"     // powers of 1000: kB, MB, GB, etc.
+)
+
+// ValidateUnits checks that the system of units exists.
+func ValidateUnits(units string) error {
+	switch units {
+	case UnitsBinary, UnitsSI:
+		return nil
+	}
+
+	return fmt.Errorf("invalid units: %v", units)
+}
+
+// Reduce converts the number of bytes into a human-readable size with one decimal place, e.g. "1.9GiB", in the units
+// chosen with -units.
 func Reduce(n int) string {
 	if n <= 0 {
 		return "0B"
 	}
 
-	index := int(math.Log2(float64(n))) / 10
-	n >>= (10 * index)
+	base := 1024.0
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB", "PiB"}
+	if SizeUnits == UnitsSI {
+		base = 1000
+		units = []string{"B", "kB", "MB", "GB", "TB", "PB"}
+	}
+
+	size := float64(n)
+	index := 0
+	for size >= base && index < len(units)-1 {
+		size /= base
+		index++
+	}
+	if index == 0 {
+		return strconv.Itoa(n) + units[0]
+	}
+
+	// Don't let rounding give us something like "1024.0KiB".
+	if math.Round(size*10)/10 >= base && index < len(units)-1 {
+		size /= base
+		index++
+	}
 
-	units := []string{"B", "K", "M", "G"}
-	return strconv.Itoa(n) + units[index]
+	return strconv.FormatFloat(size, 'f', 1, 64) + units[index]
 }
 
+// ParseSize converts a human-readable size (such as "512K", "10MiB", or "1.5GB") into its number of bytes. This is
+// the reverse of Reduce. Single-letter units and units ending in "iB" are powers of 1024, and the other units ending in
+// "B" are powers of 1000. A value without a unit suffix is treated as bytes.
+func ParseSize(s string) (int, error) {
+	orig := s
+	s = strings.ToUpper(strings.TrimSpace(s))
+	if s == "" {
+		return 0, nil
+	}
+
+	base := 1024.0
+	if strings.HasSuffix(s, "IB") {
+		s = strings.TrimSuffix(s, "IB")
+	} else if len(s) >= 2 && strings.HasSuffix(s, "B") && strings.IndexByte("KMGTP", s[len(s)-2]) >= 0 {
+		s = strings.TrimSuffix(s, "B")
+		base = 1000
+	} else {
+		s = strings.TrimSuffix(s, "B")
+	}
+
+	power := 0
+	if s != "" {
+		if i := strings.IndexByte("KMGTP", s[len(s)-1]); i >= 0 {
+			power = i + 1
+			s = strings.TrimSpace(s[:len(s)-1])
+		}
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 || math.IsNaN(n) || math.IsInf(n, 0) {
+		return 0, fmt.Errorf("invalid size: %v", orig)
+	}
+
+	// Converting a float that doesn't fit into an int doesn't fail, it just gives some other number.
+	n *= math.Pow(base, float64(power))
+	if n >= float64(maxInt) {
+		return 0, fmt.Errorf("size too large: %v", orig)
+	}
+
+	return int(n), nil
+}
+
+// maxInt is the largest int.
+const maxInt = int(^uint(0) >> 1)
+
 // SanitizeTitle replaces any characters in the provided string that cannot be used in a directory/file name with "_".
 func SanitizeTitle(name string) string {
 	orig := name
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+)
+
+// Test that sizes are reduced to binary or SI units with one decimal place, rounding up to the next unit as needed.
+func TestReduce(t *testing.T) {
+	defer func() { SizeUnits = "" }()
+
+	tests := []struct {
+		units string
+		n     int
+		want  string
+	}{
+		{UnitsBinary, 0, "0B"},
+		{UnitsBinary, 512, "512B"},
+		{UnitsBinary, 1536, "1.5KiB"},
+		{UnitsBinary, 2040109465, "1.9GiB"},
+		{UnitsBinary, 1048575, "1.0MiB"},
+		{UnitsBinary, 3 << 40, "3.0TiB"},
+		{UnitsBinary, 5 << 50, "5.0PiB"},
+		{UnitsSI, 1500, "1.5kB"},
+		{UnitsSI, 1900000000, "1.9GB"},
+		{UnitsSI, 999999, "1.0MB"},
+	}
+
+	for _, test := range tests {
+		SizeUnits = test.units
+		if have := Reduce(test.n); have != test.want {
+			t.Error(test.n, test.units, "- Want:", test.want, "Have:", have)
+		}
+	}
+}
+
+// Test that sizes are parsed with or without a unit, with binary and SI units, and that invalid sizes and sizes too large
+// for an int are rejected.
+func TestParseSize(t *testing.T) {
+	tests := map[string]int{
+		"":       0,
+		"100":    100,
+		"100B":   100,
+		"512K":   512 << 10,
+		"10M":    10 << 20,
+		"10MiB":  10 << 20,
+		"1.5GiB": 3 << 29,
+		"10MB":   10000000,
+		"2T":     2 << 40,
+		"1.5 kB": 1500,
+	}
+
+	for s, want := range tests {
+		if have, err := ParseSize(s); err != nil || have != want {
+			t.Error(s, "- Want:", want, "Have:", have, err)
+		}
+	}
+
+	for _, s := range []string{"M", "ten", "-5K", "NaN", "inf", "-Inf", "Infinity", "1e30", "9999999P"} {
+		if _, err := ParseSize(s); err == nil {
+			t.Error(s, "- Want: error Have: nil")
+		}
+	}
+}
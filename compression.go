@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// acceptEncoding is the list of compressions we ask for when getting a feed. Feeds are mostly repeated markup, so they
+// compress very well, which matters for shows with years of episodes.
+const acceptEncoding = "br, gzip, deflate"
+
+// gzipMagic is the start of every gzip stream.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// decodedBody is a response body that reads through a decompressor and closes the original body.
+type decodedBody struct {
+	io.Reader
+	body io.ReadCloser
+}
+
+// Close closes the original body.
+func (d *decodedBody) Close() error {
+	return d.body.Close()
+}
+
+// decodeBody replaces the response's body with one that decompresses it according to its Content-Encoding. Because we
+// ask for compression ourselves, Go's transport leaves this to us. A gzipped body without a Content-Encoding (like a
+// feed served as a .gz file, or from a server that compresses no matter what) is recognized by how it starts. Like
+// the transport does, this removes the encoding from the header and marks the length as unknown, since it no longer
+// applies to the body. The encoding is returned, or an empty string if the body wasn't compressed.
+func decodeBody(resp *http.Response) (string, error) {
+	var encodings []string
+	for _, encoding := range strings.Split(resp.Header.Get("Content-Encoding"), ",") {
+		encoding = strings.ToLower(strings.TrimSpace(encoding))
+		if encoding != "" && encoding != "identity" {
+			encodings = append(encodings, encoding)
+		}
+	}
+
+	r := bufio.NewReader(resp.Body)
+	if len(encodings) == 0 {
+		if magic, _ := r.Peek(len(gzipMagic)); !bytes.Equal(magic, gzipMagic) {
+			resp.Body = &decodedBody{Reader: r, body: resp.Body}
+			return "", nil
+		}
+		encodings = []string{"gzip"}
+	}
+
+	// Encodings are listed in the order they were applied, so they're undone from last to first.
+	var reader io.Reader = r
+	for i := len(encodings) - 1; i >= 0; i-- {
+		var err error
+		switch encodings[i] {
+		case "gzip", "x-gzip":
+			reader, err = gzip.NewReader(reader)
+		case "deflate":
+			reader, err = inflate(reader)
+		case "br":
+			reader = brotli.NewReader(reader)
+		default:
+			err = fmt.Errorf("unsupported content encoding: %v", encodings[i])
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+
+	resp.Body = &decodedBody{Reader: reader, body: resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+
+	return strings.Join(encodings, ", "), nil
+}
+
+// inflate decompresses a deflate body. The standard says it's wrapped in zlib, but some servers send the raw deflate
+// stream instead, which we can tell apart by the zlib header.
+func inflate(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+
+	// A zlib header names the deflate method in its low bits, and the 2 bytes together are a multiple of 31.
+	if header[0]&0x0F == 8 && (int(header[0])<<8|int(header[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+
+	return flate.NewReader(br), nil
+}
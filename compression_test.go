@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Test that compressed bodies are decoded for each encoding we accept, even when the server doesn't say it compressed
+// the body.
+func TestDecodeBody(t *testing.T) {
+	feed := []byte(strings.Repeat("<item><title>Episode</title></item>\n", 1000))
+
+	compress := func(w io.WriteCloser, buf *bytes.Buffer) []byte {
+		w.Write(feed)
+		w.Close()
+		return buf.Bytes()
+	}
+	var gz, zl, raw, br bytes.Buffer
+	flateWriter, _ := flate.NewWriter(&raw, flate.DefaultCompression)
+	bodies := map[string][]byte{
+		"gzip":    compress(gzip.NewWriter(&gz), &gz),
+		"deflate": compress(zlib.NewWriter(&zl), &zl),
+		"raw":     compress(flateWriter, &raw),
+		"br":      compress(brotli.NewWriter(&br), &br),
+	}
+
+	tests := []struct {
+		body     string // which compressed body to send, or "" for none
+		encoding string // Content-Encoding to send
+		want     string // encoding reported by decodeBody
+	}{
+		{"", "", ""},
+		{"gzip", "gzip", "gzip"},
+		{"gzip", "", "gzip"}, // compressed without saying so
+		{"deflate", "deflate", "deflate"},
+		{"raw", "deflate", "deflate"},
+		{"br", "br", "br"},
+	}
+	for _, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if have := r.Header.Get("Accept-Encoding"); have != acceptEncoding {
+				t.Error("Incorrect Accept-Encoding - Want:", acceptEncoding, "Have:", have)
+			}
+			if test.encoding != "" {
+				w.Header().Set("Content-Encoding", test.encoding)
+			}
+			if test.body == "" {
+				w.Write(feed)
+			} else {
+				w.Write(bodies[test.body])
+			}
+		}))
+
+		header := http.Header{}
+		header.Set("Accept-Encoding", acceptEncoding)
+		resp, err := get(server.URL, nil, true, header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		encoding, err := decodeBody(resp)
+		if err != nil {
+			t.Error(test.body, "- Error decoding:", err)
+		} else if encoding != test.want {
+			t.Error(test.body, "- Incorrect encoding - Want:", test.want, "Have:", encoding)
+		} else if data, err := ioutil.ReadAll(resp.Body); err != nil || !bytes.Equal(data, feed) {
+			t.Error(test.body, "- Incorrect body - Want:", len(feed), "bytes Have:", len(data), "bytes,", err)
+		}
+		resp.Body.Close()
+		server.Close()
+	}
+}
+
+// Test that the limit on the feed's size applies to the decompressed feed and not what was sent.
+func TestDecodeBodyLimit(t *testing.T) {
+	var bomb bytes.Buffer
+	w := gzip.NewWriter(&bomb)
+	w.Write(make([]byte, 10<<20))
+	w.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(bomb.Bytes())
+	}))
+	defer server.Close()
+
+	header := http.Header{}
+	header.Set("Accept-Encoding", acceptEncoding)
+	resp, err := get(server.URL, nil, true, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if _, err := decodeBody(resp); err != nil {
+		t.Fatal(err)
+	}
+	body, err := limitBody(resp, 1<<20)
+	if err == nil {
+		_, err = ioutil.ReadAll(body)
+	}
+	if !errors.Is(err, errTooLarge) {
+		t.Error("Incorrect error - Want:", errTooLarge, "Have:", err)
+	}
+}
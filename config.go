@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Config holds the settings that are read from the config file. It is primarily used for keeping a list of
+// subscriptions that are synced together, such as when running in daemon mode.
+type Config struct {
+	Dir           string         `json:"dir"`           // main download directory for all podcasts
+	Interval      Duration       `json:"interval"`      // time between syncs in daemon mode
+	Subscriptions []Subscription `json:"subscriptions"` // shows to keep synced
+	TimeZone      string         `json:"timezone"`      // zone to normalize dates to, e.g. "UTC"
+	SkipExplicit  bool           `json:"skip_explicit"` // older name for content.block_explicit, which it turns on
+
+	Audiobookshelf *AudiobookshelfConfig `json:"audiobookshelf"` // server to notify after new downloads
+	Content        ContentRules          `json:"content"`        // what the profile is allowed to download
+	Destinations   []Destination         `json:"destinations"`   // where to copy new episodes after they're downloaded
+	WebSub         *WebSubConfig         `json:"websub"`         // how to receive pushes from feeds' hubs in daemon mode
+	Podping        *PodpingConfig        `json:"podping"`        // how to watch Podping for updates in daemon mode
+
+	Groups map[string]ShowSettings `json:"groups"` // settings shared by the subscriptions in each group, e.g. "news"
+}
+
+// Subscription holds the settings for an individual show.
+type Subscription struct {
+	URL        string     `json:"url"`         // URL of the show's RSS feed
+	Username   string     `json:"username"`    // username for premium/private feeds
+	Password   string     `json:"password"`    // password or reference to it (see ResolveSecret)
+	Token      string     `json:"token"`       // bearer token or reference to it (see ResolveSecret)
+	Priority   int        `json:"priority"`    // shows with a higher priority are synced first
+	Order      string     `json:"order"`       // order to download new episodes in: "oldest" or "newest"
+	Genre      string     `json:"genre"`       // genre for the TCON frame, or "category" to use the iTunes category
+	TitleRules TitleRules `json:"title_rules"` // search and replace rules for episode titles
+	Fields     FieldMap   `json:"fields"`      // where the feed keeps episode fields that aren't in the usual tags
+	Groups     []string   `json:"groups"`      // groups the show is tagged with, e.g. "news" or "tech"
+
+	ShowSettings // settings that override the show's groups' and the global ones
+
+	Refresh *TokenRefresh `json:"refresh"` // how to get a new token when it expires
+}
+
+// Credentials resolves the subscription's login information, or returns nil if it doesn't have any.
+func (s Subscription) Credentials() (*Credentials, error) {
+	if s.Username == "" && s.Password == "" && s.Token == "" && s.Refresh == nil {
+		return nil, nil
+	}
+
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	creds := Credentials{Username: s.Username, host: u.Host}
+	if creds.Password, err = ResolveSecret(s.Password); err != nil {
+		return nil, fmt.Errorf("error reading password: %v", err)
+	}
+	if creds.Token, err = ResolveSecret(s.Token); err != nil {
+		return nil, fmt.Errorf("error reading token: %v", err)
+	}
+
+	if s.Refresh != nil {
+		if err := s.Refresh.validate(); err != nil {
+			return nil, err
+		}
+		if creds.refresher, err = newTokenRefresher(s.Refresh, s.URL, &creds); err != nil {
+			return nil, err
+		}
+	}
+
+	return &creds, nil
+}
+
+// InGroup reports whether the subscription is tagged with any of the groups.
+func (s Subscription) InGroup(groups []string) bool {
+	for _, group := range groups {
+		for _, have := range s.Groups {
+			if strings.EqualFold(group, have) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// inherit fills in the subscription's settings that it doesn't have itself from its groups, in the order the groups are
+// listed.
+func (c *Config) inherit(sub Subscription) Subscription {
+	for _, name := range sub.Groups {
+		if group, ok := c.group(name); ok {
+			sub.ShowSettings = sub.ShowSettings.inherit(group)
+		}
+	}
+
+	return sub
+}
+
+// hasGroup reports whether any subscription is tagged with the group, whether or not the group has settings.
+func (c *Config) hasGroup(name string) bool {
+	for _, sub := range c.Subscriptions {
+		if sub.InGroup([]string{name}) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// group finds the group's settings by its name, ignoring case.
+func (c *Config) group(name string) (ShowSettings, bool) {
+	for have, group := range c.Groups {
+		if strings.EqualFold(have, name) {
+			return group, true
+		}
+	}
+
+	return ShowSettings{}, false
+}
+
+// Prioritized returns the subscriptions in the order they should be synced: highest priority first, and in config order
+// for subscriptions with the same priority. Each subscription has its groups' settings filled in.
+func (c *Config) Prioritized() []Subscription {
+	if c == nil {
+		return nil
+	}
+
+	subs := make([]Subscription, len(c.Subscriptions))
+	for i, sub := range c.Subscriptions {
+		subs[i] = c.inherit(sub)
+	}
+	sort.SliceStable(subs, func(i, j int) bool {
+		return subs[i].Priority > subs[j].Priority
+	})
+
+	return subs
+}
+
+// Duration wraps time.Duration so that it can be read from the config file in its string form (e.g. "1h30m").
+type Duration struct {
+	time.Duration
+}
+
+// DefaultInterval is the time between syncs in daemon mode if the config file doesn't specify one.
+const DefaultInterval = time.Hour
+
+// UnmarshalJSON parses the duration from either a string ("1h30m") or a number of seconds.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+
+	switch value := v.(type) {
+	case float64:
+		d.Duration = time.Duration(value) * time.Second
+	case string:
+		dur, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		d.Duration = dur
+	default:
+		return fmt.Errorf("invalid duration: %s", string(b))
+	}
+
+	return nil
+}
+
+// MarshalJSON writes the duration out in its string form.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// DefaultConfigPath returns the location of the default profile's config file, which is used when a config file is
+// not explicitly provided.
+func DefaultConfigPath() string {
+	return Profile{}.ConfigPath()
+}
+
+// LoadConfig reads and parses the config file at the provided path.
+func LoadConfig(path string) (*Config, error) {
+	if path == "" {
+		return nil, fmt.Errorf("missing config path")
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config: %v", err)
+	}
+
+	config := new(Config)
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("error parsing config: %v", err)
+	}
+
+	if config.Interval.Duration <= 0 {
+		config.Interval.Duration = DefaultInterval
+	}
+
+	if _, err := ParseTimeZone(config.TimeZone); err != nil {
+		return nil, fmt.Errorf("error parsing config: %v", err)
+	}
+	if config.SkipExplicit {
+		config.Content.BlockExplicit = true
+	}
+	if err := config.Content.validate(); err != nil {
+		return nil, fmt.Errorf("error parsing config: content: %v", err)
+	}
+	if err := config.WebSub.validate(); err != nil {
+		return nil, fmt.Errorf("error parsing config: websub: %v", err)
+	}
+	for i, dest := range config.Destinations {
+		if err := dest.validate(); err != nil {
+			return nil, fmt.Errorf("error parsing config: destination %v: %v", i+1, err)
+		}
+	}
+
+	for i, sub := range config.Subscriptions {
+		if strings.TrimSpace(sub.URL) == "" {
+			return nil, fmt.Errorf("error parsing config: subscription %v has no URL", i+1)
+		}
+		if err := ValidateOrder(sub.Order); err != nil {
+			return nil, fmt.Errorf("error parsing config: subscription %v: %v", i+1, err)
+		}
+		if err := sub.TitleRules.compile(); err != nil {
+			return nil, fmt.Errorf("error parsing config: subscription %v: %v", i+1, err)
+		}
+		if err := sub.Fields.validate(); err != nil {
+			return nil, fmt.Errorf("error parsing config: subscription %v: %v", i+1, err)
+		}
+		if err := config.Subscriptions[i].ShowSettings.validate(); err != nil {
+			return nil, fmt.Errorf("error parsing config: subscription %v: %v", i+1, err)
+		}
+	}
+
+	for name, group := range config.Groups {
+		if err := group.validate(); err != nil {
+			return nil, fmt.Errorf("error parsing config: group %v: %v", name, err)
+		}
+		config.Groups[name] = group
+	}
+
+	Debug("Loaded config from", path)
+	Debug("Found", len(config.Subscriptions), "subscriptions")
+	return config, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Test that subscriptions pick up their groups' settings unless they have their own, even when their own is 0.
+func TestGroups(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.json")
+	data := `{
+		"groups": {"news": {"dir": "/podcasts/news", "keep": 5}, "kids": {"keep": 20}},
+		"subscriptions": [
+			{"url": "https://example.com/daily.xml", "groups": ["News"]},
+			{"url": "https://example.com/weekly.xml", "groups": ["news"], "keep": 2},
+			{"url": "https://example.com/stories.xml", "groups": ["kids", "news"]},
+			{"url": "https://example.com/tech.xml"},
+			{"url": "https://example.com/archive.xml", "groups": ["news"], "keep": 0}
+		]
+	}`
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A keep of -1 means that it isn't set.
+	want := []struct {
+		dir  string
+		keep int
+	}{
+		{"/podcasts/news", 5},
+		{"/podcasts/news", 2},
+		{"/podcasts/news", 20},
+		{"", -1},
+		{"/podcasts/news", 0},
+	}
+	for i, sub := range config.Prioritized() {
+		keep := -1
+		if sub.Keep != nil {
+			keep = *sub.Keep
+		}
+		if sub.Dir != want[i].dir || keep != want[i].keep {
+			t.Error(sub.URL, "- Want:", want[i].dir, want[i].keep, "Have:", sub.Dir, keep)
+		}
+	}
+
+	if !config.Subscriptions[0].InGroup([]string{"news"}) || config.Subscriptions[3].InGroup([]string{"news"}) {
+		t.Error("Subscriptions matched to the wrong group")
+	}
+}
+
+// Test that a show's settings take precedence over the global ones.
+func TestResolveSettings(t *testing.T) {
+	PrefixMinWidth = 2
+	Attempts = 3
+	defer func() { PrefixMinWidth = 0; Attempts = 0 }()
+
+	show := ShowSettings{MinWidth: intSetting(4), Filename: "{{.Title}}"}
+	if err := show.validate(); err != nil {
+		t.Fatal(err)
+	}
+	settings := show.resolve(CurrentSyncOptions())
+
+	if intValue(settings.MinWidth) != 4 {
+		t.Error("Min width - Want:", 4, "Have:", intValue(settings.MinWidth))
+	}
+	if intValue(settings.Attempts) != 3 {
+		t.Error("Attempts - Want:", 3, "Have:", intValue(settings.Attempts))
+	}
+	if settings.filename == nil {
+		t.Error("Filename template was not compiled")
+	}
+
+	if err := (&ShowSettings{Artwork: "sideways"}).validate(); err == nil {
+		t.Error("Invalid artwork mode was accepted")
+	}
+}
+
+// Test that checking the config reports every problem instead of stopping at the first.
+func TestCheckConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.json")
+	data := `{
+		"timezone": "Nowhere/Special",
+		"subscriptions": [
+			{"url": "https://example.com/feed.xml", "filename": "{{.Title}"},
+			{"url": "example.com/feed.xml"},
+			{"url": "https://example.com/feed.xml"}
+		]
+	}`
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "found 4 problems in config"
+	if err := CheckConfig(path, false); err == nil || err.Error() != want {
+		t.Error("Want:", want, "Have:", err)
+	}
+}
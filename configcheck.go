@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// sampleTemplateData is the episode that templates are rendered with when checking the config.
+var sampleTemplateData = TemplateData{
+	Show:     "Sample Show",
+	Artist:   "Sample Host",
+	Title:    "Sample Episode",
+	Season:   "3",
+	Number:   "05",
+	Prefix:   "3-05",
+	Date:     "2021-04-01",
+	Year:     "2021",
+	Language: "en",
+	Genre:    "Podcast",
+}
+
+// configCheck collects the problems found in a config file.
+type configCheck struct {
+	problems []string
+}
+
+// add records a problem with the part of the config.
+func (c *configCheck) add(part string, format string, a ...interface{}) {
+	c.problems = append(c.problems, part+": "+fmt.Sprintf(format, a...))
+}
+
+// runConfig runs the config subcommand given in the arguments.
+func runConfig(path string, args []string) error {
+	if len(args) == 0 || args[0] != "check" {
+		Log("Unknown config command")
+		return errUsage
+	}
+
+	fs := flag.NewFlagSet("config check", flag.ContinueOnError)
+	offline := fs.Bool("offline", false, "Optional. Don't contact the feeds to check that they're reachable")
+	if err := fs.Parse(args[1:]); err != nil {
+		return errUsage
+	}
+
+	if path == "" {
+		Log("No config file specified")
+		return errUsage
+	}
+
+	return CheckConfig(path, !*offline)
+}
+
+// CheckConfig checks everything in the config file that could go wrong during a sync and reports all of the problems at
+// once. If reach is true, each subscription's feed is requested (with its credentials) to make sure that it's
+// reachable.
+func CheckConfig(path string, reach bool) error {
+	Log("Checking", path)
+	check := &configCheck{}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading config: %v", err)
+	}
+
+	config := new(Config)
+	if err := json.Unmarshal(data, config); err != nil {
+		if syntax, ok := err.(*json.SyntaxError); ok {
+			line := 1 + strings.Count(string(data[:syntax.Offset]), "\n")
+			return fmt.Errorf("error parsing config on line %v: %v", line, err)
+		}
+		return fmt.Errorf("error parsing config: %v", err)
+	}
+
+	check.dir("dir", config.Dir)
+	if _, err := ParseTimeZone(config.TimeZone); err != nil {
+		check.add("timezone", "%v", err)
+	}
+	if err := config.Content.validate(); err != nil {
+		check.add("content", "%v", err)
+	}
+	for i, dest := range config.Destinations {
+		if err := dest.validate(); err != nil {
+			check.add(fmt.Sprintf("destination %v", i+1), "%v", err)
+		}
+	}
+	if abs := config.Audiobookshelf; abs != nil && abs.URL != "" {
+		if u, err := url.Parse(abs.URL); err != nil || u.Host == "" {
+			check.add("audiobookshelf", "invalid URL: %v", abs.URL)
+		}
+	}
+
+	for name, group := range config.Groups {
+		check.settings("group "+name, &group)
+		config.Groups[name] = group
+	}
+
+	seen := make(map[string]int)
+	for i, sub := range config.Subscriptions {
+		part := fmt.Sprintf("subscription %v", i+1)
+		if sub.URL != "" {
+			part += " (" + sub.URL + ")"
+		}
+
+		u, err := url.Parse(strings.TrimSpace(sub.URL))
+		switch {
+		case strings.TrimSpace(sub.URL) == "":
+			check.add(part, "no URL")
+			continue
+		case err != nil:
+			check.add(part, "invalid URL: %v", err)
+			continue
+		case u.Scheme != "http" && u.Scheme != "https", u.Host == "":
+			check.add(part, "URL must be an http or https link to the feed")
+			continue
+		}
+		if first, ok := seen[sub.URL]; ok {
+			check.add(part, "same feed as subscription %v", first)
+		}
+		seen[sub.URL] = i + 1
+
+		if err := ValidateOrder(sub.Order); err != nil {
+			check.add(part, "%v", err)
+		}
+		if err := sub.TitleRules.compile(); err != nil {
+			check.add(part, "%v", err)
+		}
+		if err := sub.Fields.validate(); err != nil {
+			check.add(part, "%v", err)
+		}
+		check.settings(part, &sub.ShowSettings)
+
+		creds, err := sub.Credentials()
+		if err != nil {
+			check.add(part, "%v", err)
+			continue
+		}
+		if reach {
+			check.reach(part, sub.URL, creds)
+		}
+	}
+
+	if len(check.problems) == 0 {
+		Log("Config is OK:", len(config.Subscriptions), "subscriptions")
+		return nil
+	}
+
+	Log("")
+	for _, problem := range check.problems {
+		Log(problem)
+	}
+	if len(check.problems) == 1 {
+		return fmt.Errorf("found 1 problem in config")
+	}
+	return fmt.Errorf("found %v problems in config", len(check.problems))
+}
+
+// dir checks that the directory either exists and can be written to or can be created. Nothing is created.
+func (c *configCheck) dir(part string, dir string) {
+	if dir == "" {
+		return
+	}
+
+	info, err := os.Stat(dir)
+	switch {
+	case os.IsNotExist(err):
+		Log(part+":", dir, "doesn't exist yet and will be created")
+		return
+	case err != nil:
+		c.add(part, "%v", err)
+		return
+	case !info.IsDir():
+		c.add(part, "%v is not a directory", dir)
+		return
+	}
+
+	file, err := ioutil.TempFile(dir, ".getcast-check-")
+	if err != nil {
+		c.add(part, "%v is not writable", dir)
+		return
+	}
+	file.Close()
+	os.Remove(file.Name())
+}
+
+// settings checks the show settings and renders the filename template with a sample episode.
+func (c *configCheck) settings(part string, settings *ShowSettings) {
+	if err := settings.validate(); err != nil {
+		c.add(part, "%v", err)
+		return
+	}
+
+	c.dir(part+" dir", settings.Dir)
+	if settings.filename != nil {
+		name, err := renderFilename(settings.filename, sampleTemplateData)
+		if err != nil {
+			c.add(part, "filename: %v", err)
+		} else {
+			Log(part+": filename renders as", name)
+		}
+	}
+}
+
+// reach requests the feed to make sure that it's reachable with the credentials.
+func (c *configCheck) reach(part string, link string, creds *Credentials) {
+	resp, err := httpGetSmall(link, creds, nil)
+	if err != nil {
+		c.add(part, "feed unreachable: %v", err)
+		return
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized, resp.StatusCode == http.StatusForbidden:
+		c.add(part, "feed rejected the credentials: %v", resp.Status)
+	case resp.StatusCode != http.StatusOK:
+		c.add(part, "feed responded with %v", resp.Status)
+	default:
+		Debug(part, "is reachable")
+	}
+}
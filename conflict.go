@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+)
+
+// These are the ways to resolve an uncertain match between a local file and an episode in the feed.
+const (
+	ConflictAsk      = "ask"      // ask the user, or skip the episode if there's no terminal or with -y
+	ConflictSkip     = "skip"     // assume we already have the episode
+	ConflictDownload = "download" // download the episode again
+)
+
+// ValidateConflictPolicy checks that the conflict policy exists.
+func ValidateConflictPolicy(policy string) error {
+	switch policy {
+	case ConflictAsk, ConflictSkip, ConflictDownload:
+		return nil
+	}
+
+	return fmt.Errorf("invalid conflict policy: %v", policy)
+}
+
+// resolveConflict decides whether or not to download an episode that we might already have, according to the conflict
+// policy. The reason explains why the match is uncertain.
+func resolveConflict(episode *Episode, reason string, policy string) bool {
+	Log("Uncertain whether", episode.Title, "is already downloaded:", reason)
+
+	switch policy {
+	case ConflictDownload:
+		Log("Downloading it again (per -conflicts)")
+		return true
+	case ConflictSkip:
+		Log("Skipping it (per -conflicts)")
+		return false
+	}
+
+	// -y answers yes to confirmations that make changes the user asked for, but downloading an episode we probably
+	// already have isn't one of them, so "ask" falls back to its default of skipping.
+	if AssumeYes {
+		Log("Skipping it (use -conflicts download to download uncertain matches with -y)")
+		return false
+	}
+
+	return Confirm(fmt.Sprintf("Download %q again?", episode.Title))
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+)
+
+// Test that -y doesn't turn the "ask" policy into downloading uncertain matches again.
+func TestResolveConflict(t *testing.T) {
+	oldYes := AssumeYes
+	defer func() { AssumeYes = oldYes }()
+
+	episode := &Episode{Title: "Episode"}
+	tests := []struct {
+		policy string
+		yes    bool
+		want   bool
+	}{
+		{ConflictAsk, true, false},
+		{ConflictSkip, true, false},
+		{ConflictDownload, true, true},
+		{ConflictDownload, false, true},
+	}
+	for _, test := range tests {
+		AssumeYes = test.yes
+		if have := resolveConflict(episode, "test", test.policy); have != test.want {
+			t.Error(test.policy, test.yes, "- Want:", test.want, "Have:", have)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// sniffLen is the number of bytes at the start of a file needed to tell what kind of file it is.
+const sniffLen = 12
+
+// These are the kinds of audio files that we can tell apart. Only MPEG audio gets an ID3v2 tag. The other formats have
+// their own way of storing metadata, and a tag stuck on the front would make the file unplayable for some players.
+const (
+	containerUnknown = ""
+	containerMPEG    = "MPEG" // MP3 (or ADTS AAC), with or without an ID3v2 tag
+	containerOgg     = "Ogg"  // Opus or Vorbis
+	containerMP4     = "MP4"  // M4A or M4B
+	containerFLAC    = "FLAC"
+	containerWAV     = "WAV"
+	containerAIFF    = "AIFF"
+)
+
+// detectContainer works out the kind of audio file from its first bytes.
+func detectContainer(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte("ID3")):
+		return containerMPEG
+	case bytes.HasPrefix(head, []byte("OggS")):
+		return containerOgg
+	case bytes.HasPrefix(head, []byte("fLaC")):
+		return containerFLAC
+	case bytes.HasPrefix(head, []byte("RIFF")):
+		return containerWAV
+	case bytes.HasPrefix(head, []byte("FORM")):
+		return containerAIFF
+	case len(head) >= 8 && string(head[4:8]) == "ftyp":
+		return containerMP4
+	case len(head) >= 2 && head[0] == 0xFF && head[1]&0xE0 == 0xE0:
+		// MPEG frame sync
+		return containerMPEG
+	}
+
+	return containerUnknown
+}
+
+// usesID3 reports whether files of this kind get an ID3v2 tag. Files we can't identify are tagged as well, since
+// that's what we've always done with them.
+func usesID3(container string) bool {
+	return container == containerMPEG || container == containerUnknown
+}
+
+// flushHead works out what kind of file is being downloaded from the bytes buffered so far, and then passes them on
+// through the rest of the pipeline.
+func (e *Episode) flushHead() error {
+	head := e.head
+	e.head = nil
+
+	e.container = detectContainer(head)
+	if usesID3(e.container) {
+		e.phase = phaseTag
+	} else {
+		Log("Not adding an ID3 tag to", e.container, "file")
+		e.phase = phaseAudio
+	}
+
+	n, err := e.write(head)
+	if err == nil && n != len(head) {
+		err = io.ErrShortWrite
+	}
+
+	return err
+}
+
+// sniffExts are the extensions that an audio file whose feed doesn't say what kind of file it is can end up with, in the
+// order to look for them.
+var sniffExts = []string{".mp3", ".m4a", ".ogg", ".flac", ".wav", ".aiff"}
+
+// unknownExt reports whether the episode is audio that the feed doesn't say the kind of, and that doesn't have its
+// extension picked yet.
+func (e *Episode) unknownExt() bool {
+	return e.ext == "" && e.Enclosure.isAudio() && enclosureExt(e.Enclosure) == ""
+}
+
+// findExt picks the extension for an episode of unknown kind from the file that was downloaded for it before, if one
+// of the filenames (without their directories) is that file. That way, the episode is found under the same name that
+// it was saved with.
+func (e *Episode) findExt(filenames map[string]bool) {
+	if !e.unknownExt() {
+		return
+	}
+
+	base := filepath.Base(e.buildFilename(""))
+	for _, ext := range sniffExts {
+		if filenames[base+ext] {
+			e.ext = ext
+			return
+		}
+	}
+}
+
+// sniffExt picks the extension for an episode of unknown kind before it's downloaded, by requesting the first few bytes
+// of the file and seeing what they look like. If they don't look like anything we know, the episode is saved as an MP3
+// file, as it always was. This won't pick a filename that's already taken in the directory.
+func (e *Episode) sniffExt(dir string) error {
+	if !e.unknownExt() {
+		return nil
+	}
+
+	ext := ".mp3"
+	header := http.Header{}
+	header.Set("Range", fmt.Sprintf("bytes=0-%d", sniffLen-1))
+	if resp, err := get(e.Enclosure.URL, e.showAuth, true, header); err != nil {
+		e.debug("Error sniffing episode:", err)
+	} else {
+		head := make([]byte, sniffLen)
+		n, _ := io.ReadFull(resp.Body, head)
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusPartialContent {
+			if sniffed := containerExt(detectContainer(head[:n])); sniffed != "" {
+				ext = sniffed
+			}
+		}
+	}
+
+	e.ext = ext
+	filename := e.buildFilename(dir)
+	if _, err := os.Stat(filename); err == nil {
+		e.ext = ""
+		return fmt.Errorf("not overwriting %v", filepath.Base(filename))
+	}
+	e.debug("Sniffed extension", ext, "for episode")
+
+	return nil
+}
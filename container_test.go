@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test that the container is detected from the first bytes of each supported format.
+func TestDetectContainer(t *testing.T) {
+	tests := map[string]string{
+		"ID3\x04\x00\x00\x00\x00\x00\x00": containerMPEG,
+		"\xff\xfb\x90\x64":                containerMPEG,
+		"OggS\x00\x02":                    containerOgg,
+		"\x00\x00\x00\x20ftypM4A ":        containerMP4,
+		"fLaC\x00\x00\x00\x22":            containerFLAC,
+		"RIFF\x24\x00\x00\x00WAVE":        containerWAV,
+		"<html>":                          containerUnknown,
+	}
+
+	for head, want := range tests {
+		if have := detectContainer([]byte(head)); have != want {
+			t.Errorf("%q - Want: %v Have: %v", head, want, have)
+		}
+	}
+}
+
+// Test that only MPEG audio gets an ID3v2 tag.
+func TestDownloadContainers(t *testing.T) {
+	ogg := append([]byte("OggS\x00\x02\x00\x00\x00\x00\x00\x00"), bytes.Repeat([]byte{0x4f}, 5000)...)
+	mp3 := append([]byte{0xff, 0xfb, 0x90, 0x64}, bytes.Repeat([]byte{0x00}, 5000)...)
+
+	dir, err := ioutil.TempDir("", "getcast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, test := range []struct {
+		name   string
+		data   []byte
+		tagged bool
+	}{
+		{"opus", ogg, false},
+		{"mp3", mp3, true},
+	} {
+		data := test.data
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write(data)
+		}))
+
+		episode := Episode{Title: test.name, Enclosure: Enclosure{URL: server.URL + "/episode", Type: "audio/" + test.name}}
+		err := episode.Download(dir)
+		server.Close()
+		if err != nil {
+			t.Error(test.name, "-", err)
+			continue
+		}
+
+		file, err := ioutil.ReadFile(episode.path)
+		if err != nil {
+			t.Error(test.name, "-", err)
+			continue
+		}
+
+		if tagged := bytes.HasPrefix(file, []byte("ID3")); tagged != test.tagged {
+			t.Error(test.name, "- Want tagged:", test.tagged, "Have:", tagged)
+		}
+		if !bytes.HasSuffix(file, data) {
+			t.Error(test.name, "- Audio data was changed")
+		}
+	}
+}
+
+// Test that enclosures served with a type that doesn't say what they are are judged by their URLs instead.
+func TestEnclosureIsAudio(t *testing.T) {
+	tests := []struct {
+		enclosure Enclosure
+		want      bool
+	}{
+		{Enclosure{URL: "https://example.com/ep.mp3", Type: "audio/mpeg"}, true},
+		{Enclosure{URL: "https://example.com/ep", Type: "audio/x-m4a"}, true},
+		{Enclosure{URL: "https://example.com/ep.mp3", Type: "video/mp4"}, false},
+		{Enclosure{URL: "https://example.com/ep.mp3", Type: "application/octet-stream"}, true},
+		{Enclosure{URL: "https://example.com/ep.m4a?id=1", Type: "binary/octet-stream"}, true},
+		{Enclosure{URL: "https://example.com/ep", Type: "application/octet-stream"}, true},
+		{Enclosure{URL: "https://example.com/bonus.pdf", Type: "application/octet-stream"}, false},
+		{Enclosure{URL: "https://example.com/bonus.pdf", Type: "application/pdf"}, false},
+		{Enclosure{URL: "https://example.com/ep.mp3"}, true},
+	}
+
+	for _, test := range tests {
+		if have := test.enclosure.isAudio(); have != test.want {
+			t.Error(test.enclosure, "- Want:", test.want, "Have:", have)
+		}
+	}
+}
+
+// Test that an episode's extension comes from the enclosure's MIME type, then its URL.
+func TestEnclosureExt(t *testing.T) {
+	tests := []struct {
+		enclosure Enclosure
+		want      string
+	}{
+		{Enclosure{URL: "https://example.com/ep.mp3", Type: "audio/mpeg"}, ".mp3"},
+		{Enclosure{URL: "https://example.com/ep", Type: "audio/x-m4a"}, ".m4a"},
+		{Enclosure{URL: "https://example.com/ep", Type: "audio/flac"}, ".flac"},
+		{Enclosure{URL: "https://example.com/ep", Type: "audio/ogg; codecs=opus"}, ".oga"},
+		{Enclosure{URL: "https://example.com/ep.m4a?id=1", Type: "audio/unknown"}, ".m4a"},
+		{Enclosure{URL: "https://example.com/ep", Type: "audio/unknown"}, ""},
+	}
+
+	for _, test := range tests {
+		if have := enclosureExt(test.enclosure); have != test.want {
+			t.Error(test.enclosure, "- Want:", test.want, "Have:", have)
+		}
+	}
+
+	if have := mimeToExt("video/mp4"); have != ".mp4" {
+		t.Error("video/mp4 - Want: .mp4 Have:", have)
+	}
+}
+
+// Test that an episode whose feed doesn't say what kind of file it is gets its extension before it's saved, from the
+// file that's already there or from what the start of the file looks like, and that it doesn't replace another file.
+func TestSniffExt(t *testing.T) {
+	m4a := append([]byte("\x00\x00\x00\x20ftypM4A "), bytes.Repeat([]byte{0x00}, 5000)...)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(m4a))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "getcast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	enclosure := Enclosure{URL: server.URL + "/episode", Type: "application/octet-stream"}
+	episode := Episode{Title: "Sniffed", Enclosure: enclosure}
+	if err := episode.Download(dir); err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(dir, "Sniffed.m4a"); episode.path != want {
+		t.Error("Incorrect path - Want:", want, "Have:", episode.path)
+	}
+
+	// On the next sync, the file is expected under the same name.
+	again := Episode{Title: "Sniffed", Enclosure: enclosure}
+	again.findExt(map[string]bool{"Sniffed.mp3.txt": true, "Sniffed.m4a": true})
+	if again.buildFilename(dir) != episode.path {
+		t.Error("Incorrect filename on next sync - Want:", episode.path, "Have:", again.buildFilename(dir))
+	}
+
+	taken := Episode{Title: "Sniffed", Enclosure: enclosure}
+	if err := taken.Download(dir); err == nil {
+		t.Error("Replaced a file that was already there")
+	}
+}
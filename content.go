@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ContentRules limit what a profile downloads, such as for a profile for kids. They're set in the profile's config
+// file, and -skip-explicit turns on BlockExplicit from the command line.
+type ContentRules struct {
+	BlockExplicit bool     `json:"block_explicit"` // leave out episodes that the feed marks as explicit
+	AllowShows    []string `json:"allow_shows"`    // titles or feed URLs of the only shows to download, or empty for all
+	MaxDuration   Duration `json:"max_duration"`   // longest episode to download, or 0 for any length
+}
+
+// Content holds the active profile's content rules.
+var Content ContentRules
+
+// validate checks that the rules are usable.
+func (r ContentRules) validate() error {
+	if r.MaxDuration.Duration < 0 {
+		return fmt.Errorf("invalid max_duration: %v", r.MaxDuration)
+	}
+
+	return nil
+}
+
+// allowShow returns why the show isn't allowed, or "" if it is. See Show.blocked for checking a show before its feed
+// is fetched.
+func (r ContentRules) allowShow(s *Show) string {
+	if len(r.AllowShows) == 0 {
+		return ""
+	}
+
+	for _, allowed := range r.AllowShows {
+		if s.matches(allowed) {
+			return ""
+		}
+	}
+
+	return "show is not in the profile's allowed shows"
+}
+
+// blocked returns why the profile's content rules rule out the show, or "" if they don't. Before the feed is fetched,
+// the show goes by the title it had the last time it was synced, if the state has it. A show that hasn't been synced
+// before can only be matched by its feed URL until the feed is fetched, so it isn't ruled out yet.
+func (s *Show) blocked() string {
+	if s.Title != "" || len(Content.AllowShows) == 0 {
+		return Content.allowShow(s)
+	}
+
+	title := StateDB.ShowTitle(s.URL.String())
+	if title == "" {
+		return ""
+	}
+
+	return Content.allowShow(&Show{URL: s.URL, Title: title})
+}
+
+// matches reports whether the show is the one named, by either its title (case-insensitive) or its feed URL.
+func (s *Show) matches(name string) bool {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return false
+	}
+
+	if strings.EqualFold(name, s.Title) || strings.EqualFold(SanitizeTitle(name), s.Title) {
+		return true
+	}
+	if s.URL != nil {
+		public := *s.URL
+		public.User = nil
+		return name == public.String()
+	}
+
+	return false
+}
+
+// allowEpisode returns why the episode isn't allowed, or "" if it is. Episodes whose feed doesn't give a duration are
+// allowed.
+func (r ContentRules) allowEpisode(e *Episode) string {
+	if r.BlockExplicit && e.IsExplicit() {
+		return "episode is marked as explicit"
+	}
+
+	if max := r.MaxDuration.Duration; max > 0 {
+		if seconds := parseItunesDuration(e.Duration); seconds > 0 {
+			if time.Duration(seconds)*time.Second > max {
+				return fmt.Sprintf("episode is longer than %v", max)
+			}
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Credentials holds the login information needed to access a premium or private feed.
+type Credentials struct {
+	Username string // username for basic auth
+	Password string // password for basic auth
+	Token    string // token for bearer auth
+	host     string // host of the feed, so the credentials aren't sent to other hosts
+
+	refresher *tokenRefresher // gets new tokens when they expire, if the feed's tokens do
+}
+
+// apply adds the credentials to the request if the request is going to the same host as the feed. We don't want to
+// leak the credentials to third-party CDNs or image hosts.
+func (c *Credentials) apply(req *http.Request) {
+	if c == nil || req == nil || req.URL.Host != c.host {
+		return
+	}
+
+	if token := c.token(); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if c.Username != "" || c.Password != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+}
+
+// token returns the current bearer token, which can change when it's refreshed.
+func (c *Credentials) token() string {
+	if c.refresher == nil {
+		return c.Token
+	}
+
+	c.refresher.mutex.Lock()
+	defer c.refresher.mutex.Unlock()
+
+	return c.Token
+}
+
+// refreshable reports whether the credentials can get a new token for the request's host.
+func (c *Credentials) refreshable(req *http.Request) bool {
+	return c != nil && c.refresher != nil && req != nil && req.URL.Host == c.host
+}
+
+// ResolveSecret looks up the value of a secret in the config. Secrets are written as a reference to where the value is
+// actually stored, so that it doesn't sit in the config file in cleartext. These references are supported:
+// env:NAME             Value of the environment variable NAME
+// keyring:service      Password stored in the OS keychain under the service name (see keyringLookup)
+// age:path#key         Value for key in the age-encrypted file at path, which holds KEY=VALUE lines
+// Any other value is used as is, with a warning that the secret is being stored in cleartext.
+func ResolveSecret(ref string) (string, error) {
+	if ref == "" {
+		return "", nil
+	}
+
+	parts := strings.SplitN(ref, ":", 2)
+	if len(parts) == 2 {
+		switch parts[0] {
+		case "env":
+			value, ok := os.LookupEnv(parts[1])
+			if !ok {
+				return "", fmt.Errorf("environment variable %v not set", parts[1])
+			}
+			return value, nil
+		case "keyring":
+			return keyringLookup(parts[1])
+		case "age":
+			return ageLookup(parts[1])
+		}
+	}
+
+	Log("WARNING: Secret stored in cleartext in config, consider using a keyring or age reference")
+	return ref, nil
+}
+
+// ageLookup decrypts the age-encrypted secrets file and finds the value for the key. The reference is written as
+// path#key. The identity used for decryption is read from GETCAST_AGE_IDENTITY, defaulting to age.key in the profile's
+// config directory.
+func ageLookup(ref string) (string, error) {
+	i := strings.LastIndex(ref, "#")
+	if i < 0 {
+		return "", fmt.Errorf("invalid age reference (expected path#key): %v", ref)
+	}
+	path, key := ref[:i], ref[i+1:]
+
+	identity := os.Getenv(envPrefix + "AGE_IDENTITY")
+	if identity == "" {
+		identity = filepath.Join(ActiveProfile.ConfigDir(), "age.key")
+	}
+
+	out, err := exec.Command("age", "--decrypt", "-i", identity, path).Output()
+	if err != nil {
+		return "", fmt.Errorf("error decrypting %v: %v", filepath.Base(path), err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "=", 2)
+		if len(fields) == 2 && strings.TrimSpace(fields[0]) == key {
+			return strings.TrimSpace(fields[1]), nil
+		}
+	}
+
+	return "", fmt.Errorf("key %v not found in %v", key, filepath.Base(path))
+}
@@ -0,0 +1,490 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Daemon keeps every subscription in the config synced, checking for new episodes at the configured interval (or less
+// often for shows that rarely come out, see schedule). Sending
+// SIGHUP reloads the config file without interrupting any downloads that are in progress. With WebSub or Podping
+// configured, shows that are announced as updated are also synced right away.
+type Daemon struct {
+	configPath string     // path to the config file, for reloading
+	dirArg     string     // download directory from the command line, which overrides the config
+	args       configArgs // other flags from the command line, which are combined with the config on reload
+	websub     *webSub    // subscriber for feeds with a WebSub hub, or nil if WebSub isn't configured
+	schedule   *schedule
+
+	finishing sync.Mutex // held while a sync writes the playlists and summary, so that passes don't do it at once
+
+	mutex   sync.Mutex
+	config  *Config
+	aliases map[string]string // subscription URLs by the URLs that their feeds give for themselves
+	syncing map[string]bool   // subscriptions with a sync in progress
+}
+
+// pushBuffer is the number of announced updates that can wait to be synced.
+const pushBuffer = 64
+
+// maxPasses is the most syncs that can run at once in daemon mode, each with its own shows.
+const maxPasses = 2
+
+// runDaemon starts daemon mode with the provided config.
+func runDaemon(configPath string, config *Config, dirArg string, args configArgs) error {
+	if config == nil {
+		Log("No config file found")
+		return errUsage
+	}
+
+	d := Daemon{configPath: configPath, dirArg: dirArg, args: args, config: config}
+	return d.Run()
+}
+
+// Run syncs all subscriptions on a loop until the process is told to stop.
+func (d *Daemon) Run() error {
+	if d == nil {
+		return fmt.Errorf("invalid daemon object")
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	// The watchdog is pinged from this loop, so that it's only pinged while the loop is running and the syncs it starts
+	// are making progress.
+	var watchdog <-chan time.Time
+	interval := sdWatchdogInterval()
+	if interval > 0 {
+		Debug("Pinging watchdog every", interval)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		watchdog = ticker.C
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	// Changes to the WebSub and Podping settings take a restart, since the hubs have our callback.
+	pushes := make(chan string, pushBuffer)
+	if d.config.WebSub.Enabled() {
+		d.websub = newWebSub(*d.config.WebSub, pushes)
+		if err := d.websub.Start(); err != nil {
+			return fmt.Errorf("error starting WebSub callback server: %v", err)
+		}
+		defer d.websub.Close()
+	}
+	if d.config.Podping.Enabled() {
+		go newPodping(*d.config.Podping, pushes, d.feeds).Run(done)
+	}
+
+	Log("Starting daemon with", len(d.config.Subscriptions), "subscriptions")
+	if err := sdNotify("READY=1"); err != nil {
+		Debug("Error notifying service manager:", err)
+	}
+
+	// Each feed is checked on its own schedule, and the feeds that come due are synced together in the background so
+	// that we can keep handling signals while downloads are in progress. A second pass can run alongside the first, so
+	// that one long download doesn't hold up every other show, but a show is never synced by two passes at once. Shows
+	// that come due while they're still syncing are skipped until their next check, and shows that are pushed while
+	// they're syncing are queued to be synced again after.
+	d.schedule = newSchedule()
+	timer := time.NewTimer(0)
+	passDone := make(chan struct{}, maxPasses)
+	passes, checkDue := 0, false
+	var pushed []string
+	start := func(feeds []string, kind string) []string {
+		feeds = d.claim(feeds)
+		if len(feeds) == 0 {
+			return nil
+		}
+		passes++
+		noteProgress()
+		go func() {
+			d.sync(feeds, kind)
+			d.release(feeds)
+			passDone <- struct{}{}
+		}()
+		return feeds
+	}
+	for {
+		select {
+		case <-timer.C:
+			checkDue = true
+
+		case feed := <-pushes:
+			Log("Received update for", feed)
+			if !containsString(pushed, feed) {
+				pushed = append(pushed, feed)
+			}
+
+		case <-passDone:
+			passes--
+
+		case <-watchdog:
+			sdWatchdogPing(interval, passes > 0)
+
+		case <-hup:
+			Log("Reloading config")
+			sdNotify("RELOADING=1")
+			if err := d.reload(); err != nil {
+				Log("Error reloading config:", err)
+				Log("Continuing with previous config")
+			}
+			sdNotify("READY=1")
+
+			// Check the schedule now so that new subscriptions don't have to wait. Passes that are already running
+			// pick up the new config on their next run.
+			checkDue = true
+
+		case sig := <-stop:
+			Log("Received", sig, "signal, stopping daemon")
+			sdNotify("STOPPING=1")
+
+			// Let the syncs in progress clean up after themselves before we go.
+			if passes > 0 {
+				requestStop()
+				for ; passes > 0; passes-- {
+					<-passDone
+				}
+			}
+			flushLog()
+			return nil
+		}
+
+		// Start whatever is waiting, if there's room for another pass.
+		if checkDue && passes < maxPasses {
+			checkDue = false
+			if due := d.due(); len(due) > 0 {
+				// The scheduled sync picks up the pushed feeds too.
+				for _, feed := range pushed {
+					if !containsString(due, feed) {
+						due = append(due, feed)
+					}
+				}
+				pushed = removeStrings(pushed, start(due, "scheduled"))
+			}
+		}
+		if len(pushed) > 0 && passes < maxPasses {
+			pushed = removeStrings(pushed, start(pushed, "pushed"))
+		}
+
+		if !checkDue {
+			wait := d.wait()
+			resetTimer(timer, wait)
+			if passes == 0 {
+				Log("Next check in", wait.Round(time.Second))
+				sdNotify("STATUS=Waiting " + wait.Round(time.Second).String() + " until next check")
+			}
+		}
+	}
+}
+
+// reload reads the config file again and swaps it in for the current config, along with the settings that come from it.
+func (d *Daemon) reload() error {
+	config, err := loadConfig(d.configPath)
+	if err != nil {
+		return err
+	} else if config == nil {
+		return fmt.Errorf("no config found")
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if err := d.args.apply(config); err != nil {
+		return err
+	}
+	Log("Loaded", len(config.Subscriptions), "subscriptions (previously", len(d.config.Subscriptions), "subscriptions)")
+	d.config = config
+	return nil
+}
+
+// interval returns the time to wait between checks of a feed.
+func (d *Daemon) interval() time.Duration {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	return d.config.Interval.Duration
+}
+
+// due returns the subscriptions in the current config that are due to be checked. Subscriptions that are still syncing
+// are left out, and are scheduled again once they're done.
+func (d *Daemon) due() []string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	now := time.Now()
+	var due []string
+	for _, feed := range d.schedule.due(d.subscriptions(), now) {
+		if d.syncing[feed] {
+			Debug("Skipping check of", feed+", which is still syncing")
+			continue
+		}
+		due = append(due, feed)
+	}
+
+	return due
+}
+
+// wait returns the time until the next subscription in the current config (that isn't already syncing) is due to be
+// checked. Without any such subscriptions, this is the interval, so that we don't spin waiting for some.
+func (d *Daemon) wait() time.Duration {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	var feeds []string
+	for _, feed := range d.subscriptions() {
+		if !d.syncing[feed] {
+			feeds = append(feeds, feed)
+		}
+	}
+	if len(feeds) == 0 {
+		return d.config.Interval.Duration
+	}
+
+	return d.schedule.wait(feeds, time.Now())
+}
+
+// claim marks the feeds as syncing and returns them, leaving out any that are already syncing.
+func (d *Daemon) claim(feeds []string) []string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if d.syncing == nil {
+		d.syncing = make(map[string]bool)
+	}
+	var claimed []string
+	for _, feed := range feeds {
+		if !d.syncing[feed] {
+			d.syncing[feed] = true
+			claimed = append(claimed, feed)
+		}
+	}
+
+	return claimed
+}
+
+// release marks the feeds as done syncing.
+func (d *Daemon) release(feeds []string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for _, feed := range feeds {
+		delete(d.syncing, feed)
+	}
+}
+
+// checked schedules the next check of the feed, for a show that comes out every cadence (or 0 if unknown).
+func (d *Daemon) checked(feed string, cadence time.Duration) {
+	interval := d.interval()
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.schedule.checked(feed, time.Now(), interval, cadence)
+}
+
+// subscriptions returns the URLs of the subscriptions in the current config. The mutex must be held.
+func (d *Daemon) subscriptions() []string {
+	feeds := make([]string, len(d.config.Subscriptions))
+	for i, sub := range d.config.Subscriptions {
+		feeds[i] = sub.URL
+	}
+
+	return feeds
+}
+
+// sync syncs the subscriptions in the current config with the feed URLs, which are the kind of subscriptions being
+// synced (such as "scheduled" or "pushed") for the log. The feeds are fetched a few at a time ahead of the shows being
+// synced (see prefetchShows).
+func (d *Daemon) sync(feeds []string, kind string) {
+	// Grab the config as it exists right now. If it's reloaded while we're syncing, the changes will take effect on the
+	// next pass.
+	d.mutex.Lock()
+	config := d.config
+	d.mutex.Unlock()
+
+	var subs []Subscription
+	for _, sub := range config.Prioritized() {
+		if containsString(feeds, sub.URL) {
+			subs = append(subs, sub)
+		}
+	}
+
+	sdNotify("STATUS=Syncing " + fmt.Sprint(len(subs)) + " subscriptions")
+	Log("")
+	Log("Beginning sync of", len(subs), kind, "subscriptions at", time.Now().Format(time.RFC3339))
+
+	// Whatever happens, every feed is scheduled again so that none are retried right away.
+	checked := make(map[string]bool, len(subs))
+	defer func() {
+		for _, sub := range subs {
+			if !checked[sub.URL] {
+				d.checked(sub.URL, 0)
+			}
+		}
+	}()
+
+	dir, err := downloadDir(config, d.dirArg)
+	if err != nil {
+		Log("Invalid download directory:", err)
+		return
+	}
+
+	// Other commands (such as pause and resume) might have changed the state since the last sync.
+	if err := StateDB.Refresh(); err != nil {
+		Log("Error reloading state:", err)
+	}
+
+	summary := NewSummary()
+	var shows []*Show
+	var urls []string
+	for _, sub := range subs {
+		if StateDB.IsPaused(sub.URL) {
+			Log("Skipping paused show", sub.URL)
+			continue
+		}
+
+		show, err := NewShow(sub)
+		if err != nil {
+			Log(err)
+			summary.AddError("", sub.URL, err)
+			continue
+		}
+		shows = append(shows, show)
+		urls = append(urls, sub.URL)
+	}
+
+	next, stop := prefetchShows(shows)
+	defer stop()
+
+	downloaded := 0
+	for i, show := range shows {
+		if Interrupted() {
+			break
+		}
+
+		good, err := syncShow(show, dir, "")
+		next()
+		downloaded += good
+		summary.Add(show, good, err)
+		d.subscribe(urls[i], show)
+		d.checked(urls[i], show.cadence)
+		checked[urls[i]] = true
+		if err == errInterrupted {
+			break
+		} else if errors.Is(err, ErrDiskFull) {
+			// There's no point in trying the other shows.
+			Log(err)
+			break
+		} else if err != nil {
+			Log(err)
+		}
+	}
+
+	d.finishing.Lock()
+	defer d.finishing.Unlock()
+
+	summary.Finish()
+
+	writeRecentPlaylist(dir)
+	if downloaded > 0 {
+		notifyAudiobookshelf(config)
+	}
+}
+
+// subscribe subscribes to the show's feed at its WebSub hub, if it has one and WebSub is configured. This also
+// remembers the URL that the feed gives for itself, for matching up updates announced under it.
+func (d *Daemon) subscribe(feed string, show *Show) {
+	hub, self := webSubLinks(show.AtomLinks)
+	if self != "" && self != feed {
+		// Updates are often announced under the feed's own URL.
+		d.mutex.Lock()
+		if d.aliases == nil {
+			d.aliases = make(map[string]string)
+		}
+		d.aliases[feedKey(self)] = feed
+		d.mutex.Unlock()
+	}
+	if hub == "" || d.websub == nil {
+		return
+	}
+	if err := d.websub.Subscribe(feed, hub, self); err != nil {
+		Log("Error subscribing to WebSub hub:", err)
+	}
+}
+
+// feeds returns the URLs of the subscriptions in the current config, by their keys (see feedKey) and the keys of the
+// URLs that their feeds give for themselves.
+func (d *Daemon) feeds() map[string]string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	feeds := make(map[string]string, len(d.config.Subscriptions)+len(d.aliases))
+	for key, feed := range d.aliases {
+		feeds[key] = feed
+	}
+	for _, sub := range d.config.Subscriptions {
+		feeds[feedKey(sub.URL)] = sub.URL
+	}
+
+	return feeds
+}
+
+// feedKey returns the feed URL in a form for matching it up with the same URL written differently, without the scheme
+// and with the host in lowercase, e.g. "example.com/feed.xml" for "HTTPS://Example.com/feed.xml/".
+func feedKey(link string) string {
+	link = strings.TrimSpace(link)
+	if i := strings.Index(link, "://"); i >= 0 {
+		link = link[i+3:]
+	}
+	if i := strings.IndexAny(link, "/?#"); i >= 0 {
+		link = strings.ToLower(link[:i]) + link[i:]
+	} else {
+		link = strings.ToLower(link)
+	}
+
+	return strings.TrimSuffix(link, "/")
+}
+
+// resetTimer stops the timer, throwing away any time that it's already fired, and starts it again for the duration.
+func resetTimer(timer *time.Timer, d time.Duration) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+	timer.Reset(d)
+}
+
+// removeStrings returns the list without any of the strings in remove.
+func removeStrings(list []string, remove []string) []string {
+	var kept []string
+	for _, s := range list {
+		if !containsString(remove, s) {
+			kept = append(kept, s)
+		}
+	}
+
+	return kept
+}
+
+// containsString returns whether or not the list has the string.
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Test that a show that's still syncing is skipped when it comes due again, and isn't claimed by a second pass.
+func TestDaemonSyncing(t *testing.T) {
+	a, b := "https://example.com/a.xml", "https://example.com/b.xml"
+	d := &Daemon{
+		config:   &Config{Interval: Duration{time.Hour}, Subscriptions: []Subscription{{URL: a}, {URL: b}}},
+		schedule: newSchedule(),
+	}
+
+	if claimed := d.claim([]string{a}); len(claimed) != 1 || claimed[0] != a {
+		t.Fatal("Incorrect claim - Want: [a] Have:", claimed)
+	}
+	if due := d.due(); len(due) != 1 || due[0] != b {
+		t.Error("Incorrect feeds due - Want: [b] Have:", due)
+	}
+	if claimed := d.claim([]string{a, b}); len(claimed) != 1 || claimed[0] != b {
+		t.Error("Claimed a feed that's already syncing - Want: [b] Have:", claimed)
+	}
+
+	// With everything syncing, there's nothing to wait for but the interval.
+	if wait := d.wait(); wait != time.Hour {
+		t.Error("Incorrect wait - Want:", time.Hour, "Have:", wait)
+	}
+
+	// Once b is done and scheduled, only a is left to come due, as soon as it's released.
+	d.checked(b, 0)
+	d.release([]string{a, b})
+	if due := d.due(); len(due) != 1 || due[0] != a {
+		t.Error("Incorrect feeds due - Want: [a] Have:", due)
+	}
+	if wait := d.wait(); wait != 0 {
+		t.Error("Incorrect wait - Want: 0 Have:", wait)
+	}
+}
+
+// Test that the watchdog is pinged while idle or making progress, but not while a sync is stuck.
+func TestWatchdogPing(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	os.Setenv("NOTIFY_SOCKET", socket)
+	defer os.Unsetenv("NOTIFY_SOCKET")
+
+	pinged := func() bool {
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		buf := make([]byte, 64)
+		n, err := conn.Read(buf)
+		return err == nil && string(buf[:n]) == "WATCHDOG=1"
+	}
+
+	interval := time.Second
+	atomic.StoreInt64(&lastProgress, time.Now().Add(-time.Minute).UnixNano())
+	tests := []struct {
+		syncing  bool
+		progress bool
+		want     bool
+	}{
+		{false, false, true},
+		{true, false, false},
+		{true, true, true},
+	}
+	for _, test := range tests {
+		if test.progress {
+			noteProgress()
+		}
+		sdWatchdogPing(interval, test.syncing)
+		if have := pinged(); have != test.want {
+			t.Error("Syncing:", test.syncing, "Progress:", test.progress, "- Want:", test.want, "Have:", have)
+		}
+	}
+}
+
+// Test that reloading the config swaps in its content rules, which the command line can still only make stricter, its
+// destinations, and its time zone.
+func TestDaemonReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tmpContent, tmpDestinations, tmpZone := Content, Destinations, DateZone
+	defer func() { Content, Destinations, DateZone = tmpContent, tmpDestinations, tmpZone }()
+
+	path := filepath.Join(dir, "config.json")
+	data := `{"subscriptions": [{"url": "https://example.com/a.xml"}], "content": {"allow_shows": ["Kids Show"]},
+		"destinations": [{"path": "/media/player"}], "timezone": "America/Denver"}`
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	d := &Daemon{configPath: path, args: configArgs{blockExplicit: true}, config: &Config{}}
+	if err := d.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if len(Content.AllowShows) != 1 || Content.AllowShows[0] != "Kids Show" {
+		t.Error("Incorrect allowed shows - Want: [Kids Show] Have:", Content.AllowShows)
+	}
+	if !Content.BlockExplicit {
+		t.Error("Reload loosened the content rules from the command line")
+	}
+	if len(Destinations) != 1 || Destinations[0].Path != "/media/player" {
+		t.Error("Incorrect destinations - Want: [/media/player] Have:", Destinations)
+	}
+	if DateZone == nil || DateZone.String() != "America/Denver" {
+		t.Error("Incorrect time zone - Want: America/Denver Have:", DateZone)
+	}
+
+	// The command line's time zone wins over the config's.
+	d.args.timezone = "UTC"
+	if err := d.reload(); err != nil || DateZone != time.UTC {
+		t.Error("Incorrect time zone - Want: UTC Have:", DateZone, err)
+	}
+	if len(d.config.Subscriptions) != 1 {
+		t.Error("Incorrect subscriptions - Want: 1 Have:", len(d.config.Subscriptions))
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// dateNames maps localized month and day names (and their common abbreviations) to the English abbreviations that
+// the RFC 1123 date format expects. Names are lowercase without a trailing period.
+var dateNames = map[string]string{
+	// Spanish
+	"enero": "Jan", "ene": "Jan", "febrero": "Feb", "marzo": "Mar", "abril": "Apr", "abr": "Apr", "mayo": "May",
+	"junio": "Jun", "julio": "Jul", "agosto": "Aug", "ago": "Aug", "septiembre": "Sep", "setiembre": "Sep",
+	"sept": "Sep", "octubre": "Oct", "noviembre": "Nov", "diciembre": "Dec", "dic": "Dec",
+	"lunes": "Mon", "lun": "Mon", "martes": "Tue", "miércoles": "Wed", "miercoles": "Wed", "mié": "Wed",
+	"mie": "Wed", "jueves": "Thu", "jue": "Thu", "viernes": "Fri", "vie": "Fri", "sábado": "Sat", "sabado": "Sat",
+	"sáb": "Sat", "domingo": "Sun", "dom": "Sun",
+
+	// German
+	"januar": "Jan", "jänner": "Jan", "jän": "Jan", "februar": "Feb", "märz": "Mar", "mär": "Mar", "mai": "May",
+	"juni": "Jun", "juli": "Jul", "oktober": "Oct", "okt": "Oct", "dezember": "Dec", "dez": "Dec",
+	"montag": "Mon", "mo": "Mon", "dienstag": "Tue", "di": "Tue", "mittwoch": "Wed", "mi": "Wed",
+	"donnerstag": "Thu", "do": "Thu", "freitag": "Fri", "fr": "Fri", "samstag": "Sat", "sa": "Sat",
+	"sonntag": "Sun", "so": "Sun",
+
+	// French
+	"janvier": "Jan", "janv": "Jan", "février": "Feb", "févr": "Feb", "fevrier": "Feb", "mars": "Mar",
+	"avril": "Apr", "avr": "Apr", "juin": "Jun", "juillet": "Jul", "juil": "Jul", "août": "Aug", "aout": "Aug",
+	"septembre": "Sep", "octobre": "Oct", "novembre": "Nov", "décembre": "Dec", "déc": "Dec", "decembre": "Dec",
+	"lundi": "Mon", "mardi": "Tue", "mercredi": "Wed", "mer": "Wed", "jeudi": "Thu", "jeu": "Thu",
+	"vendredi": "Fri", "ven": "Fri", "samedi": "Sat", "sam": "Sat", "dimanche": "Sun", "dim": "Sun",
+}
+
+// englishDate replaces any localized month and day names in the date with their English abbreviations. Everything else
+// is left as it is.
+func englishDate(date string) string {
+	var b strings.Builder
+	word := []rune{}
+	flush := func() {
+		if len(word) == 0 {
+			return
+		}
+		if name, ok := dateNames[strings.ToLower(string(word))]; ok {
+			b.WriteString(name)
+		} else {
+			b.WriteString(string(word))
+		}
+		word = word[:0]
+	}
+
+	runes := []rune(date)
+	for i, r := range runes {
+		if unicode.IsLetter(r) {
+			word = append(word, r)
+			continue
+		}
+
+		// Drop the period after an abbreviated name, e.g. "ene." or "Okt.".
+		translated := len(word) > 0 && dateNames[strings.ToLower(string(word))] != ""
+		flush()
+		if r == '.' && translated && i > 0 {
+			continue
+		}
+		b.WriteRune(r)
+	}
+	flush()
+
+	return b.String()
+}
+
+// ParseTimeZone loads the time zone for normalizing dates: "UTC", "Local" (in any case), or an IANA zone name such as
+// "America/Denver". An empty name returns nil, which keeps each date in the zone the feed gave it.
+func ParseTimeZone(name string) (*time.Location, error) {
+	switch {
+	case name == "":
+		return nil, nil
+	case strings.EqualFold(name, "UTC"):
+		return time.UTC, nil
+	case strings.EqualFold(name, "Local"):
+		return time.Local, nil
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time zone: %v", err)
+	}
+
+	return loc, nil
+}
+
+// publishTime returns the episode's publish date, converted to the zone from -timezone if one is set.
+func (e *Episode) publishTime() time.Time {
+	ts := parseDate(e.Date)
+	if DateZone != nil && !ts.IsZero() {
+		ts = ts.In(DateZone)
+	}
+
+	return ts
+}
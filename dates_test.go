@@ -0,0 +1,109 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Test that dates with localized month and day names are parsed.
+func TestParseLocalizedDate(t *testing.T) {
+	tests := []string{
+		"Tue, 05 Mar 2019 10:00:00 +0000",
+		"mar, 05 marzo 2019 10:00:00 +0000",
+		"Di, 5 März 2019 10:00:00 +0000",
+		"Dienstag, 05 Mär. 2019 10:00:00 +0000",
+		"mardi, 5 mars 2019 10:00:00 +0000",
+	}
+
+	for _, test := range tests {
+		ts := parseDate(test)
+		if ts.IsZero() {
+			t.Error("Failed to parse", test)
+		} else if have := ts.Format("2006-01-02"); have != "2019-03-05" {
+			t.Error("Wrong date for", test, "- Have:", have)
+		}
+	}
+}
+
+// Test that time zones are loaded by name, that "UTC" and "Local" are accepted in any case, and that unknown zones are
+// rejected.
+func TestParseTimeZone(t *testing.T) {
+	tests := map[string]*time.Location{
+		"":               nil,
+		"UTC":            time.UTC,
+		"utc":            time.UTC,
+		"Local":          time.Local,
+		"local":          time.Local,
+		"America/Denver": nil, // loaded below
+	}
+
+	for name, want := range tests {
+		loc, err := ParseTimeZone(name)
+		if err != nil {
+			t.Error(name, "- Error loading time zone:", err)
+			continue
+		}
+		if name == "America/Denver" {
+			if loc == nil || loc.String() != name {
+				t.Error(name, "- Incorrect time zone - Have:", loc)
+			}
+		} else if loc != want {
+			t.Error(name, "- Incorrect time zone - Want:", want, "Have:", loc)
+		}
+	}
+
+	for _, name := range []string{"Mars/Olympus_Mons", "EST5EDT,M3.2.0", "../UTC"} {
+		if _, err := ParseTimeZone(name); err == nil {
+			t.Error(name, "- Want: error Have: nil")
+		}
+	}
+}
+
+// Test that the publish date written to the metadata is converted to the zone from -timezone, and left in the feed's
+// zone without it.
+func TestPublishTime(t *testing.T) {
+	tmpZone := DateZone
+	defer func() { DateZone = tmpZone }()
+
+	denver, err := ParseTimeZone("America/Denver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokyo, err := ParseTimeZone("Asia/Tokyo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		zone *time.Location
+		date string
+		tdrc string // ID3v2.4
+		tdat string // ID3v2.3, DDMM
+		time string // ID3v2.3, HHMM
+	}{
+		{nil, "Tue, 05 Mar 2019 23:30:00 +0100", "20190305T233000", "0503", "2330"},
+		{time.UTC, "Tue, 05 Mar 2019 23:30:00 +0100", "20190305T223000", "0503", "2230"},
+		{denver, "Tue, 05 Mar 2019 23:30:00 +0000", "20190305T163000", "0503", "1630"},
+		{tokyo, "Tue, 05 Mar 2019 23:30:00 +0000", "20190306T083000", "0603", "0830"},
+	}
+
+	for _, test := range tests {
+		DateZone = test.zone
+		for _, version := range []byte{3, 4} {
+			tag := []byte{'I', 'D', '3', version, 0, 0, 0, 0, 0, 0}
+			e := &Episode{Title: "Episode", Date: test.date, meta: NewMeta(tag)}
+			e.settings = &ShowSettings{Artwork: ArtworkNone}
+			e.addFrames()
+
+			want := map[string]string{"TDRC": test.tdrc}
+			if version == 3 {
+				want = map[string]string{"TDAT": test.tdat, "TIME": test.time}
+			}
+			for id, value := range want {
+				if have := getTag(e.meta, id); have != value {
+					t.Error(test.zone, test.date, "-", id, "- Want:", value, "Have:", have)
+				}
+			}
+		}
+	}
+}
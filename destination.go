@@ -0,0 +1,314 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Destination is a secondary place that new episodes are copied to after they're downloaded and tagged, such as a
+// second disk, a USB stick for the car, or another machine. The path is either a local directory, which must already
+// exist (so that an unplugged drive is skipped instead of filled in on the main disk), or a remote target in rsync's
+// "host:path" form, which is copied to with rsync over SSH.
+type Destination struct {
+	Name   string   `json:"name"`   // name to use in the logs, defaulting to the path
+	Path   string   `json:"path"`   // local directory or remote "host:path" target
+	Shows  []string `json:"shows"`  // titles or feed URLs of the only shows to copy, or empty for all
+	Newest int      `json:"newest"` // number of each show's newest episodes to keep there, or 0 for all of them
+}
+
+// DestinationManifest is the name of the file that getcast keeps in a local destination to remember which of the
+// files there it copied. Only those files are ever removed from the destination.
+const DestinationManifest = ".getcast-destination.json"
+
+// Destinations are the places that new episodes are copied to, from the config.
+var Destinations []Destination
+
+// validate checks that the destination is usable.
+func (d Destination) validate() error {
+	switch {
+	case strings.TrimSpace(d.Path) == "":
+		return fmt.Errorf("missing path")
+	case d.Newest < 0:
+		return fmt.Errorf("invalid newest: %v", d.Newest)
+	}
+
+	return nil
+}
+
+// String returns the destination's name, or its path if it doesn't have one.
+func (d Destination) String() string {
+	if d.Name != "" {
+		return d.Name
+	}
+
+	return d.Path
+}
+
+// remote reports whether the destination is another machine. Like rsync and scp, a colon before the first slash means
+// that the path is on a remote host.
+func (d Destination) remote() bool {
+	// A single letter before the colon is a Windows drive instead.
+	i := strings.IndexByte(d.Path, ':')
+	return i > 1 && !strings.Contains(d.Path[:i], "/")
+}
+
+// wants reports whether the destination takes episodes of the show.
+func (d Destination) wants(s *Show) bool {
+	if len(d.Shows) == 0 {
+		return true
+	}
+
+	for _, show := range d.Shows {
+		if s.matches(show) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CopyToDestinations copies the episodes downloaded during the show's sync to every destination that wants them. For
+// destinations that only keep the newest episodes, older ones are only copied if they're still among the newest, and
+// the ones that fall out of the newest are removed from local destinations. Problems with one destination don't stop
+// the others. Without the state, there's no telling which episodes are the newest, so nothing is copied.
+func CopyToDestinations(s *Show, mainDir string) {
+	if len(s.New) == 0 || len(Destinations) == 0 {
+		return
+	}
+	if StateDB == nil {
+		Log("Skipping destinations - the state is unavailable")
+		return
+	}
+
+	// The state records absolute paths, so everything is compared that way.
+	mainDir, err := filepath.Abs(mainDir)
+	if err != nil {
+		Log("Error copying to destinations:", err)
+		return
+	}
+	showDir, err := filepath.Abs(s.Dir)
+	if err != nil {
+		Log("Error copying to destinations:", err)
+		return
+	}
+	var files []string
+	for _, file := range s.New {
+		if abs, err := filepath.Abs(file); err == nil {
+			files = append(files, abs)
+		}
+	}
+
+	for _, dest := range Destinations {
+		if !dest.wants(s) {
+			continue
+		}
+
+		if err := dest.copy(mainDir, showDir, files); err != nil {
+			Log("Error copying to", dest.String()+":", err)
+		}
+	}
+}
+
+// copy copies the new files from the show's directory to the destination. Files copied to a local destination are
+// recorded in its manifest.
+func (d Destination) copy(mainDir string, showDir string, files []string) error {
+	var manifest deviceManifest
+	copied := make(map[string]bool)
+	if !d.remote() {
+		if info, err := os.Stat(d.Path); err != nil || !info.IsDir() {
+			Log("Skipping", d.String(), "- destination is not available")
+			return nil
+		}
+
+		var err error
+		if manifest, err = readDeviceManifest(d.Path, DestinationManifest); err != nil {
+			return err
+		}
+		for _, rel := range manifest.Files {
+			copied[rel] = true
+		}
+	}
+
+	keep := newestEpisodes(showDir, d.Newest)
+	n := 0
+	for _, file := range files {
+		if keep != nil && !keep[file] {
+			Debug("Not copying", filepath.Base(file), "to", d.String(), "- it isn't one of the newest", d.Newest)
+			continue
+		}
+
+		rel, err := filepath.Rel(mainDir, file)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			return fmt.Errorf("%v is not in %v", file, mainDir)
+		}
+
+		if d.remote() {
+			err = d.rsync(mainDir, rel)
+		} else if err = copyFile(file, filepath.Join(d.Path, rel)); err == nil {
+			copied[filepath.ToSlash(rel)] = true
+			err = d.saveManifest(copied)
+		}
+		if err != nil {
+			return err
+		}
+		n++
+	}
+	if n > 0 {
+		Log("Copied", n, "episodes to", d.String())
+	}
+
+	if keep != nil && !d.remote() {
+		return d.prune(mainDir, showDir, keep, copied)
+	}
+
+	return nil
+}
+
+// saveManifest saves the files that getcast copied to the local destination, relative to its directory.
+func (d Destination) saveManifest(copied map[string]bool) error {
+	var files []string
+	for rel := range copied {
+		files = append(files, rel)
+	}
+	sort.Strings(files)
+
+	return writeDeviceManifest(d.Path, DestinationManifest, deviceManifest{Files: files})
+}
+
+// rsync copies the file at the path (relative to the main directory) to the remote destination, creating the same
+// directories there.
+func (d Destination) rsync(mainDir string, rel string) error {
+	// The "/./" marks where the path that's recreated at the destination starts.
+	src := filepath.Clean(mainDir) + string(filepath.Separator) + "." + string(filepath.Separator) + rel
+	target := strings.TrimSuffix(d.Path, "/") + "/"
+
+	Debug("Running rsync for", rel, "to", target)
+	if out, err := exec.Command("rsync", "-a", "--relative", src, target).CombinedOutput(); err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("error running rsync: %v", msg)
+		}
+		return fmt.Errorf("error running rsync: %v", err)
+	}
+
+	return nil
+}
+
+// prune removes the show's episodes from the local destination that are no longer among the newest ones. Only files
+// that getcast copied there, which are the ones in copied, are removed, so anything else in the destination is left
+// alone.
+func (d Destination) prune(mainDir string, showDir string, keep map[string]bool, copied map[string]bool) error {
+	rel, err := filepath.Rel(mainDir, showDir)
+	if err != nil {
+		return err
+	}
+	prefix := filepath.ToSlash(rel) + "/"
+
+	wanted := make(map[string]bool)
+	for file := range keep {
+		if fileRel, err := filepath.Rel(mainDir, file); err == nil {
+			wanted[filepath.ToSlash(fileRel)] = true
+		}
+	}
+
+	removed := false
+	for fileRel := range copied {
+		if !strings.HasPrefix(fileRel, prefix) || wanted[fileRel] {
+			continue
+		}
+
+		path := filepath.Join(d.Path, filepath.FromSlash(fileRel))
+		Log("Removing", filepath.Base(path), "from", d.String())
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		delete(copied, fileRel)
+		removed = true
+	}
+	if removed {
+		return d.saveManifest(copied)
+	}
+
+	return nil
+}
+
+// newestEpisodes returns the paths of the n newest episodes downloaded into the show's directory, going by their
+// publish dates, or nil if n is 0.
+func newestEpisodes(showDir string, n int) map[string]bool {
+	if n <= 0 {
+		return nil
+	}
+
+	var episodes []EpisodeState
+	prefix := showDir + string(filepath.Separator)
+	for path, es := range StateDB.ByPath() {
+		if strings.HasPrefix(path, prefix) {
+			episodes = append(episodes, es)
+		}
+	}
+	sort.SliceStable(episodes, func(i, j int) bool {
+		if !episodes[i].Published.Equal(episodes[j].Published) {
+			return episodes[i].Published.After(episodes[j].Published)
+		}
+		return episodes[i].Path > episodes[j].Path
+	})
+
+	keep := make(map[string]bool)
+	for i := 0; i < len(episodes) && i < n; i++ {
+		keep[episodes[i].Path] = true
+	}
+
+	return keep
+}
+
+// copyFile copies the file at src to dest, creating dest's directory if needed. The copy is written to a temporary file
+// first so that an interrupted copy doesn't leave a partial episode behind. Files that are already there with the same
+// size are skipped.
+func copyFile(src string, dest string) error {
+	info, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if existing, err := os.Stat(dest); err == nil && existing.Size() == info.Size() {
+		Debug("Already copied", dest)
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp, err := ioutil.TempFile(filepath.Dir(dest), ".getcast-copy-")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tmp, in); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	os.Chtimes(tmp.Name(), info.ModTime(), info.ModTime())
+
+	if err := os.Rename(tmp.Name(), dest); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	Debug("Copied", src, "to", dest)
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test that new episodes are copied to a local destination and that only the newest are kept there.
+func TestCopyToDestinations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast-destination")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mainDir := filepath.Join(dir, "library")
+	usb := filepath.Join(dir, "usb")
+	show := &Show{Title: "Show", Dir: filepath.Join(mainDir, "Show")}
+	if err := os.MkdirAll(show.Dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(usb, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpState := StateDB
+	defer func() { StateDB = tmpState }()
+	StateDB, _ = LoadState(filepath.Join(dir, "state.json"))
+
+	published := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i, name := range []string{"1.mp3", "2.mp3", "3.mp3"} {
+		e := Episode{Title: name, Date: published.AddDate(0, 0, i).Format(time.RFC1123Z)}
+		e.path = filepath.Join(show.Dir, name)
+		if err := ioutil.WriteFile(e.path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		StateDB.RecordDownload("https://example.com/feed.xml", show.Title, &e)
+	}
+	// An old episode that getcast already copied to the stick, and a file that the user put there.
+	if err := os.MkdirAll(filepath.Join(usb, "Show"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(usb, "Show", "0.mp3"), []byte("0"), 0644)
+	ioutil.WriteFile(filepath.Join(usb, "Show", "mine.mp3"), []byte("mine"), 0644)
+	writeDeviceManifest(usb, DestinationManifest, deviceManifest{Files: []string{"Show/0.mp3"}})
+
+	tmpDestinations := Destinations
+	defer func() { Destinations = tmpDestinations }()
+	Destinations = []Destination{
+		{Path: usb, Newest: 2},
+		{Path: filepath.Join(dir, "unplugged")},
+		{Path: filepath.Join(dir, "other"), Shows: []string{"Other Show"}},
+	}
+
+	show.New = []string{filepath.Join(show.Dir, "1.mp3"), filepath.Join(show.Dir, "3.mp3")}
+	CopyToDestinations(show, mainDir)
+
+	want := map[string]bool{"0.mp3": false, "1.mp3": false, "2.mp3": false, "3.mp3": true, "mine.mp3": true}
+	for name, exists := range want {
+		_, err := os.Stat(filepath.Join(usb, "Show", name))
+		if have := err == nil; have != exists {
+			t.Error(name, "- Want on stick:", exists, "Have:", have)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "unplugged")); !os.IsNotExist(err) {
+		t.Error("Missing destination was created")
+	}
+	if manifest, _ := readDeviceManifest(usb, DestinationManifest); len(manifest.Files) != 1 {
+		t.Error("Incorrect manifest - Want: [Show/3.mp3] Have:", manifest.Files)
+	}
+
+	// Without the state, nothing is copied or removed.
+	StateDB = nil
+	show.New = []string{filepath.Join(show.Dir, "2.mp3")}
+	CopyToDestinations(show, mainDir)
+	for name, exists := range want {
+		_, err := os.Stat(filepath.Join(usb, "Show", name))
+		if have := err == nil; have != exists {
+			t.Error(name, "- Want on stick without state:", exists, "Have:", have)
+		}
+	}
+
+	remote := map[string]bool{"nas:/podcasts": true, "user@nas:podcasts": true, "/mnt/a:b": false, "C:\\Podcasts": false}
+	for path, want := range remote {
+		if have := (Destination{Path: path}).remote(); have != want {
+			t.Error(path, "- Want remote:", want, "Have:", have)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DeviceManifest is the name of the file that getcast keeps on a portable player to remember which of the files there
+// it put there. Only those files are ever removed from the device.
+const DeviceManifest = ".getcast-device.json"
+
+// deviceManifest lists the files that getcast copied to a device or destination, relative to its directory.
+type deviceManifest struct {
+	Files []string `json:"files"`
+}
+
+// DevicePlan is what a device sync will do.
+type DevicePlan struct {
+	Keep   []string // files to have on the device, relative to the main directory
+	Copy   []string // files to copy to the device, relative to the main directory
+	Remove []string // files to remove from the device, relative to the device's directory
+	Size   int      // total size of the files kept on the device
+}
+
+// runDeviceSync fills a portable player with the newest unplayed episodes in the library.
+func runDeviceSync(config *Config, dirArg string, args []string) error {
+	if len(args) == 0 {
+		Log("No device directory specified")
+		return errUsage
+	}
+	deviceDir := args[0]
+
+	fs := flag.NewFlagSet("device-sync", flag.ContinueOnError)
+	budgetArg := fs.String("budget", "", "Optional. Most space to use on the device, e.g. 8G")
+	perShow := fs.Int("per-show", 0, "Optional. Most episodes of each show to put on the device")
+	dryRun := fs.Bool("dry-run", false, "Optional. List the changes without making them")
+	if err := fs.Parse(args[1:]); err != nil {
+		return errUsage
+	}
+
+	budget, err := ParseSize(*budgetArg)
+	if err != nil {
+		return err
+	}
+	if *perShow < 0 {
+		return fmt.Errorf("invalid per-show: %v", *perShow)
+	}
+
+	if info, err := os.Stat(deviceDir); err != nil || !info.IsDir() {
+		return fmt.Errorf("device not found: %v", deviceDir)
+	}
+
+	dir, err := downloadDir(config, dirArg)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := readDeviceManifest(deviceDir, DeviceManifest)
+	if err != nil {
+		return err
+	}
+
+	// Bookmarks set on the device are brought back first, since they can mark episodes as played.
+	if Bookmarks != "" && !*dryRun {
+		if n := pullBookmarks(dir, deviceDir, manifest.Files); n > 0 {
+			Log("Updated", n, "bookmarks from the device")
+			if err := StateDB.Save(); err != nil {
+				return fmt.Errorf("error saving state: %v", err)
+			}
+		}
+	}
+
+	plan, err := PlanDeviceSync(dir, manifest.Files, budget, *perShow)
+	if err != nil {
+		return err
+	}
+
+	for _, rel := range plan.Remove {
+		Log("Removing", rel)
+	}
+	for _, rel := range plan.Copy {
+		Log("Copying", rel)
+	}
+	Log("Device will have", len(plan.Keep), "episodes", "("+Reduce(plan.Size)+")")
+	if *dryRun {
+		return nil
+	}
+
+	for _, rel := range plan.Remove {
+		if err := os.Remove(filepath.Join(deviceDir, rel)); err != nil && !os.IsNotExist(err) {
+			Log("Error removing", rel, "-", err)
+		}
+		if Bookmarks == BookmarksSidecar {
+			os.Remove(bookmarkPath(filepath.Join(deviceDir, rel)))
+		}
+		removeEmptyDirs(deviceDir, filepath.Dir(filepath.Join(deviceDir, rel)))
+	}
+
+	// The manifest is saved as we go, so that an interrupted sync still knows what it put on the device.
+	kept := make(map[string]bool)
+	for _, rel := range manifest.Files {
+		kept[rel] = true
+	}
+	for _, rel := range plan.Remove {
+		delete(kept, filepath.ToSlash(rel))
+	}
+	save := func() error {
+		var files []string
+		for rel := range kept {
+			files = append(files, rel)
+		}
+		sort.Strings(files)
+		return writeDeviceManifest(deviceDir, DeviceManifest, deviceManifest{Files: files})
+	}
+	if err := save(); err != nil {
+		return err
+	}
+
+	for _, rel := range plan.Copy {
+		if Interrupted() {
+			return errInterrupted
+		}
+		if err := copyFile(filepath.Join(dir, rel), filepath.Join(deviceDir, rel)); err != nil {
+			save()
+			return fmt.Errorf("error copying %v: %v", rel, err)
+		}
+		kept[filepath.ToSlash(rel)] = true
+	}
+
+	if Bookmarks != "" {
+		pushBookmarks(dir, deviceDir, plan.Keep)
+	}
+
+	return save()
+}
+
+// PlanDeviceSync decides which episodes in the main directory belong on the device. Unplayed episodes are picked newest
+// first, up to perShow from each show (0 for no limit), for as long as they fit in the budget (0 for no limit). The
+// files that getcast put on the device before (onDevice, relative to the device's directory) and that didn't make the
+// cut are removed.
+func PlanDeviceSync(mainDir string, onDevice []string, budget int, perShow int) (*DevicePlan, error) {
+	mainDir, err := filepath.Abs(mainDir)
+	if err != nil {
+		return nil, err
+	}
+
+	type candidate struct {
+		rel   string
+		show  string
+		state EpisodeState
+		size  int
+	}
+
+	var candidates []candidate
+	for path, es := range StateDB.ByPath() {
+		rel, err := filepath.Rel(mainDir, path)
+		if err != nil || strings.HasPrefix(rel, "..") || !isAudio(path) || !es.Played.IsZero() {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			continue
+		}
+
+		show := strings.SplitN(filepath.ToSlash(rel), "/", 2)[0]
+		values := es.filterValues(show)
+		values.Path, values.Size = path, int(info.Size())
+		if !EpisodeFilter.Selects(values) {
+			continue
+		}
+		candidates = append(candidates, candidate{rel: rel, show: show, state: es, size: int(info.Size())})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if !candidates[i].state.Published.Equal(candidates[j].state.Published) {
+			return candidates[i].state.Published.After(candidates[j].state.Published)
+		}
+		return candidates[i].rel < candidates[j].rel
+	})
+
+	plan := new(DevicePlan)
+	present := make(map[string]bool)
+	for _, rel := range onDevice {
+		present[filepath.ToSlash(rel)] = true
+	}
+
+	keep := make(map[string]bool)
+	perShowCount := make(map[string]int)
+	for _, c := range candidates {
+		if perShow > 0 && perShowCount[c.show] >= perShow {
+			continue
+		}
+		if budget > 0 && plan.Size+c.size > budget {
+			// A smaller episode further down might still fit.
+			continue
+		}
+
+		perShowCount[c.show]++
+		plan.Size += c.size
+		plan.Keep = append(plan.Keep, c.rel)
+		keep[filepath.ToSlash(c.rel)] = true
+		if !present[filepath.ToSlash(c.rel)] {
+			plan.Copy = append(plan.Copy, c.rel)
+		}
+	}
+
+	for _, rel := range onDevice {
+		if !keep[filepath.ToSlash(rel)] {
+			plan.Remove = append(plan.Remove, filepath.FromSlash(rel))
+		}
+	}
+	sort.Strings(plan.Remove)
+
+	return plan, nil
+}
+
+// readDeviceManifest reads the list of files that getcast put on the device from the manifest with the name. A device
+// that getcast hasn't synced yet has an empty list.
+func readDeviceManifest(deviceDir string, name string) (deviceManifest, error) {
+	var manifest deviceManifest
+	data, err := ioutil.ReadFile(filepath.Join(deviceDir, name))
+	if os.IsNotExist(err) {
+		return manifest, nil
+	} else if err != nil {
+		return manifest, fmt.Errorf("error reading device manifest: %v", err)
+	}
+
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, fmt.Errorf("error parsing device manifest: %v", err)
+	}
+
+	// A file that was deleted on the device (or by a player) doesn't need to be removed again.
+	var files []string
+	for _, rel := range manifest.Files {
+		if _, err := os.Stat(filepath.Join(deviceDir, filepath.FromSlash(rel))); err == nil {
+			files = append(files, rel)
+		}
+	}
+	manifest.Files = files
+
+	return manifest, nil
+}
+
+// writeDeviceManifest saves the list of files that getcast put on the device to the manifest with the name.
+func writeDeviceManifest(deviceDir string, name string, manifest deviceManifest) error {
+	data, err := json.MarshalIndent(manifest, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(deviceDir, name), append(data, '\n'), 0644)
+}
+
+// removeEmptyDirs removes the directory and its parents, up to but not including the root, for as long as they're
+// empty.
+func removeEmptyDirs(root string, dir string) {
+	root = filepath.Clean(root)
+	for dir = filepath.Clean(dir); dir != root && strings.HasPrefix(dir, root+string(filepath.Separator)); dir = filepath.Dir(dir) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+	}
+}
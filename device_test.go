@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test that the newest unplayed episodes are chosen for the device within the budget and the limit for each show.
+func TestPlanDeviceSync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast-device")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tmpState := StateDB
+	defer func() { StateDB = tmpState }()
+	StateDB, _ = LoadState(filepath.Join(dir, "state.json"))
+
+	// Each episode's size is its number of kilobytes.
+	published := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	episodes := []struct {
+		show string
+		name string
+		size int
+		day  int
+	}{
+		{"A", "a1.mp3", 3, 1},
+		{"A", "a2.mp3", 3, 2},
+		{"A", "a3.mp3", 3, 3},
+		{"B", "b1.mp3", 8, 4},
+		{"B", "b2.mp3", 2, 5},
+		{"C", "played.mp3", 1, 6},
+	}
+	for _, ep := range episodes {
+		e := Episode{Title: ep.name, Date: published.AddDate(0, 0, ep.day).Format(time.RFC1123Z)}
+		e.path = filepath.Join(dir, "library", ep.show, ep.name)
+		os.MkdirAll(filepath.Dir(e.path), 0755)
+		if err := ioutil.WriteFile(e.path, make([]byte, ep.size*1024), 0644); err != nil {
+			t.Fatal(err)
+		}
+		StateDB.RecordDownload("https://example.com/"+ep.show, ep.show, &e)
+	}
+	for _, show := range StateDB.Shows {
+		for _, es := range show.Episodes {
+			if es.Title == "played.mp3" {
+				es.Played = time.Now()
+			}
+		}
+	}
+
+	onDevice := []string{"A/a1.mp3", "B/b2.mp3"}
+	plan, err := PlanDeviceSync(filepath.Join(dir, "library"), onDevice, 9*1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// b2 (2K) + a3 (3K) + a2 (3K) fit, b1 (8K) doesn't, and a1 is past the limit for show A.
+	check := func(name string, want string, have []string) {
+		if joined := filepath.ToSlash(strings.Join(have, ",")); joined != want {
+			t.Error(name, "- Want:", want, "Have:", joined)
+		}
+	}
+	check("Keep", "B/b2.mp3,A/a3.mp3,A/a2.mp3", plan.Keep)
+	check("Copy", "A/a3.mp3,A/a2.mp3", plan.Copy)
+	check("Remove", "A/a1.mp3", plan.Remove)
+	if plan.Size != 8*1024 {
+		t.Error("Size - Want:", 8*1024, "Have:", plan.Size)
+	}
+
+	// Episodes that don't match the filter are left off the device.
+	tmpFilter := EpisodeFilter
+	defer func() { EpisodeFilter = tmpFilter }()
+	EpisodeFilter, _ = ParseEpisodeFilter(`show == "A" && date >= "2021-01-03"`)
+	if plan, err = PlanDeviceSync(filepath.Join(dir, "library"), onDevice, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	check("Filtered keep", "A/a3.mp3,A/a2.mp3", plan.Keep)
+	check("Filtered remove", "A/a1.mp3,B/b2.mp3", plan.Remove)
+}
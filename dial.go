@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// These are the IP versions that connections can be limited to.
+const (
+	IPAny = ""  // whatever the host resolves to
+	IPv4  = "4" // IPv4 only
+	IPv6  = "6" // IPv6 only
+)
+
+// HostOverrides maps host names to the addresses to connect to instead of the ones from DNS. It can be set on the
+// command line once per host or with a comma-separated list, each given as "host=address".
+type HostOverrides map[string]string
+
+// String returns the overrides as a comma-separated list.
+func (h *HostOverrides) String() string {
+	if h == nil {
+		return ""
+	}
+
+	var overrides []string
+	for host, addr := range *h {
+		overrides = append(overrides, host+"="+addr)
+	}
+	sort.Strings(overrides)
+
+	return strings.Join(overrides, ",")
+}
+
+// Set adds the overrides in the comma-separated list.
+func (h *HostOverrides) Set(value string) error {
+	if *h == nil {
+		*h = make(HostOverrides)
+	}
+
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return fmt.Errorf("invalid host override: %v (want host=address)", item)
+		}
+
+		host := strings.ToLower(strings.TrimSpace(parts[0]))
+		addr := strings.Trim(strings.TrimSpace(parts[1]), "[]")
+		if net.ParseIP(addr) == nil {
+			return fmt.Errorf("invalid address for %v: %v", host, parts[1])
+		}
+		(*h)[host] = addr
+	}
+
+	return nil
+}
+
+// dialer makes the connections for the shared HTTP client.
+var dialer = &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+
+// newTransport builds the shared HTTP client's transport, which is the same as the default one except that it connects
+// through dialContext.
+func newTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialContext
+
+	return transport
+}
+
+// dialContext connects to the address, sending the host to the address given with -resolve (if any) and limiting the
+// connection to the IP version given with -ipv4 or -ipv6.
+func dialContext(ctx context.Context, network string, addr string) (net.Conn, error) {
+	if host, port, err := net.SplitHostPort(addr); err == nil {
+		if override, ok := Resolve[strings.ToLower(host)]; ok {
+			Debug("Connecting to", host, "at", override)
+			addr = net.JoinHostPort(override, port)
+		}
+	}
+
+	switch IPVersion {
+	case IPv4:
+		network = "tcp4"
+	case IPv6:
+		network = "tcp6"
+	}
+
+	return dialer.DialContext(ctx, network, addr)
+}
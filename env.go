@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// envPrefix is prepended to the names of all environment variables that getcast reads.
+const envPrefix = "GETCAST_"
+
+// flagEnvNames maps the single-letter flags to more descriptive environment variable names. Flags not in this table
+// use their own name, uppercased and with "-" replaced by "_" (e.g. -log-size is read from GETCAST_LOG_SIZE).
+var flagEnvNames = map[string]string{
+	"c": "CONFIG",
+	"d": "DIR",
+	"l": "LOG",
+	"m": "MIN_WIDTH",
+	"n": "EPISODE",
+	"u": "URL",
+	"v": "DEBUG",
+	"y": "ASSUME_YES",
+}
+
+// envName returns the name of the environment variable that corresponds to the flag.
+func envName(flagName string) string {
+	if name, ok := flagEnvNames[flagName]; ok {
+		return envPrefix + name
+	}
+
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// setFlagsFromEnv sets the value of every flag that was not given on the command line from its environment variable,
+// if present. Flags given on the command line always take precedence.
+func setFlagsFromEnv(fs *flag.FlagSet) error {
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if given[f.Name] || err != nil {
+			return
+		}
+
+		name := envName(f.Name)
+		if value, ok := os.LookupEnv(name); ok {
+			if e := fs.Set(f.Name, value); e != nil {
+				err = fmt.Errorf("invalid value for %v: %v", name, e)
+			}
+		}
+	})
+
+	return err
+}
+
+// applyEnvConfig overlays the config settings found in the environment on top of the config read from the config file.
+// If there is no config file, then the environment alone can be used to build the config. This returns nil if neither
+// has any config values.
+//
+// These variables are read:
+// GETCAST_FEEDS: Space- or comma-separated list of feed URLs. This replaces the subscriptions in the config file.
+// GETCAST_INTERVAL: Time between syncs in daemon mode, e.g. "1h30m".
+func applyEnvConfig(config *Config) (*Config, error) {
+	feeds, haveFeeds := os.LookupEnv(envPrefix + "FEEDS")
+	interval, haveInterval := os.LookupEnv(envPrefix + "INTERVAL")
+	if !haveFeeds && !haveInterval {
+		return config, nil
+	}
+
+	if config == nil {
+		config = &Config{Interval: Duration{DefaultInterval}}
+	}
+
+	if haveFeeds {
+		config.Subscriptions = nil
+		fields := strings.FieldsFunc(feeds, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\n' || r == '\t'
+		})
+		for _, field := range fields {
+			config.Subscriptions = append(config.Subscriptions, Subscription{URL: field})
+		}
+		Debug("Found", len(config.Subscriptions), "subscriptions in environment")
+	}
+
+	if haveInterval {
+		d, err := time.ParseDuration(interval)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid value for %vINTERVAL: %v", envPrefix, interval)
+		}
+		config.Interval.Duration = d
+	}
+
+	return config, nil
+}
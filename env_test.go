@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"testing"
+	"time"
+)
+
+// Test that flags not given on the command line are read from the environment, by their descriptive names for the
+// single-letter flags.
+func TestFlagsFromEnv(t *testing.T) {
+	fs := flag.NewFlagSet("getcast", flag.ContinueOnError)
+	dir := fs.String("d", "", "")
+	url := fs.String("u", "", "")
+	logSize := fs.Int("log-size", 0, "")
+	if err := fs.Parse([]string{"-u", "https://example.com/flag.xml"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, value := range map[string]string{
+		"GETCAST_DIR":      "/podcasts",
+		"GETCAST_URL":      "https://example.com/env.xml",
+		"GETCAST_LOG_SIZE": "10",
+	} {
+		os.Setenv(name, value)
+		defer os.Unsetenv(name)
+	}
+
+	if err := setFlagsFromEnv(fs); err != nil {
+		t.Fatal(err)
+	}
+	if *dir != "/podcasts" {
+		t.Error("Incorrect dir - Want: /podcasts Have:", *dir)
+	}
+	if *url != "https://example.com/flag.xml" {
+		t.Error("Environment replaced a flag from the command line - Have:", *url)
+	}
+	if *logSize != 10 {
+		t.Error("Incorrect log size - Want: 10 Have:", *logSize)
+	}
+
+	fs = flag.NewFlagSet("getcast", flag.ContinueOnError)
+	fs.Int("log-size", 0, "")
+	os.Setenv("GETCAST_LOG_SIZE", "ten")
+	if err := setFlagsFromEnv(fs); err == nil {
+		t.Error("Invalid value was accepted")
+	}
+}
+
+// Test that the subscriptions and interval from the environment build a config or replace the config file's.
+func TestEnvConfig(t *testing.T) {
+	if config, err := applyEnvConfig(nil); err != nil || config != nil {
+		t.Error("Built a config without any variables:", config, err)
+	}
+
+	os.Setenv("GETCAST_FEEDS", "https://example.com/a.xml, https://example.com/b.xml")
+	defer os.Unsetenv("GETCAST_FEEDS")
+	os.Setenv("GETCAST_INTERVAL", "30m")
+	defer os.Unsetenv("GETCAST_INTERVAL")
+
+	file := &Config{Subscriptions: []Subscription{{URL: "https://example.com/file.xml"}}}
+	config, err := applyEnvConfig(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Subscriptions) != 2 || config.Subscriptions[1].URL != "https://example.com/b.xml" {
+		t.Error("Incorrect subscriptions - Want: a.xml, b.xml Have:", config.Subscriptions)
+	}
+	if config.Interval.Duration != 30*time.Minute {
+		t.Error("Incorrect interval - Want: 30m Have:", config.Interval)
+	}
+
+	os.Setenv("GETCAST_INTERVAL", "-1h")
+	if _, err := applyEnvConfig(nil); err == nil {
+		t.Error("Invalid interval was accepted")
+	}
+}
@@ -1,13 +1,13 @@
 package main
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"net/http"
+	"mime"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -17,26 +17,212 @@ import (
 // Episode represents internal data related to each episode of the podcast.
 type Episode struct {
 	// Show information
-	showTitle  string
-	showArtist string
-	showImage  string
+	showTitle    string
+	showArtist   string
+	showImage    string
+	showAuth     *Credentials
+	showLanguage string
+	showPeople   []Person
+	showGenre    string
+	showURL      string           // location of the show's RSS feed
+	showRights   Rights           // copyright, content advisory, and funding of the show
+	trackTotal   int              // number of episodes in this episode's season
+	seasonTotal  int              // number of seasons in the show
+	artwork      *artworkCache    // images fetched ahead of the download, if any
+	settings     *ShowSettings    // show's settings from the config, or nil to use the global ones
+	download     *DownloadOptions // settings from the command line, or nil to use the current ones
 
 	// Episode information
-	Title     string `xml:"title"`
-	Season    string `xml:"season"`
-	Number    string `xml:"episode"`
-	Image     string `xml:"image,href"`
-	Desc      string `xml:"description"`
-	Date      string `xml:"pubDate"`
-	Enclosure struct {
-		URL  string `xml:"url,attr"`
-		Size string `xml:"length,attr"`
-		Type string `xml:"type,attr"`
-	} `xml:"enclosure"`
+	Title      string       `xml:"title"`
+	GUID       string       `xml:"guid"`
+	Season     string       `xml:"season"`
+	Number     string       `xml:"episode"`
+	Image      string       `xml:"image,href"`
+	Desc       string       `xml:"description"`
+	Content    string       `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	Date       string       `xml:"pubDate"`
+	Duration   string       `xml:"duration"`
+	Enclosures []Enclosure  `xml:"enclosure"`
+	Enclosure  Enclosure    `xml:"-"` // enclosure selected for download
+	Persons    []Person     `xml:"https://podcastindex.org/namespace/1.0 person"`
+	Chapters   ChaptersLink `xml:"https://podcastindex.org/namespace/1.0 chapters"`
+	Explicit   string       `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd explicit"`
+
+	// Alternative media links, for items without an enclosure
+	Media      []MediaContent `xml:"http://search.yahoo.com/mrss/ content"`
+	MediaGroup []MediaContent `xml:"http://search.yahoo.com/mrss/ group>content"`
+	Links      []string       `xml:"link"`
 
 	// Objects to handle reading/writing
 	meta *Meta     // Metadata object
 	w    io.Writer // Writer that will handle writing the file.
+
+	// Results of the download
+	path       string        // location of the file on disk
+	size       int           // number of bytes received
+	serverSize int           // size reported by the server
+	elapsed    time.Duration // time the download took
+	policy     LengthPolicy  // policy used to validate the size
+	trimStart  time.Duration // time cut off the start of the audio by Trim
+	trimLength time.Duration // length of the audio left by Trim, or zero if it wasn't trimmed
+
+	// Progress of the current download, so that retries can resume it
+	phase     downloadPhase // how far along the download got
+	received  int           // number of bytes received from the server so far
+	partial   string        // location of the unfinished file
+	head      []byte        // first bytes of the file, held until we know what kind of file it is
+	container string        // kind of audio file, e.g. "MPEG" or "Ogg"
+	ext       string        // extension for audio files whose feed doesn't say, found on disk or by sniffing
+	replaces  *replacement  // downloaded file that this download replaces once it's done, if any
+}
+
+// MediaContent is a media:content element from the Media RSS namespace.
+type MediaContent struct {
+	URL    string `xml:"url,attr"`
+	Type   string `xml:"type,attr"`
+	Size   string `xml:"fileSize,attr"`
+	Medium string `xml:"medium,attr"`
+}
+
+// isAudio determines if the media is audio, going by its MIME type, its medium, or the extension in its URL.
+func (m MediaContent) isAudio() bool {
+	if strings.HasPrefix(m.Type, "audio/") || m.Medium == "audio" {
+		return true
+	}
+
+	return genericType(m.Type) && m.Medium == "" && isAudioURL(m.URL)
+}
+
+// Enclosure is a media file attached to an item in the feed.
+type Enclosure struct {
+	URL  string `xml:"url,attr"`
+	Size string `xml:"length,attr"`
+	Type string `xml:"type,attr"`
+}
+
+// isAudio determines if the enclosure is audio, going by its MIME type or the extension in its URL. If the type doesn't
+// say (see genericType) and the URL doesn't have an extension either, the enclosure is taken to be audio, and what kind
+// of audio is worked out from the file itself.
+func (en Enclosure) isAudio() bool {
+	if !genericType(en.Type) {
+		return strings.HasPrefix(en.Type, "audio/")
+	}
+
+	if isAudioURL(en.URL) {
+		return true
+	}
+	u, err := url.Parse(en.URL)
+	return err == nil && path.Ext(u.Path) == ""
+}
+
+// genericType reports whether the MIME type says nothing about what kind of file it is, like the
+// "application/octet-stream" that some hosts serve everything as.
+func genericType(mimeType string) bool {
+	if i := strings.IndexByte(mimeType, ';'); i >= 0 {
+		mimeType = mimeType[:i]
+	}
+
+	switch strings.ToLower(strings.TrimSpace(mimeType)) {
+	case "", "application/octet-stream", "binary/octet-stream", "application/binary", "application/x-octet-stream",
+		"application/force-download", "application/download":
+		return true
+	}
+
+	return false
+}
+
+// These are the modes for selecting which enclosures to download when an item has more than one. Any other mode is
+// treated as a MIME type pattern (e.g. "audio/*").
+const (
+	EnclosuresFirst = "first" // download only the first enclosure
+	EnclosuresAll   = "all"   // download every enclosure
+)
+
+// ValidateEnclosureMode checks that the mode is either one of the modes above or a valid MIME type pattern.
+func ValidateEnclosureMode(mode string) error {
+	switch mode {
+	case "", EnclosuresFirst, EnclosuresAll:
+		return nil
+	}
+
+	if _, err := path.Match(mode, ""); err != nil || !strings.Contains(mode, "/") {
+		return fmt.Errorf("invalid enclosure mode: %v", mode)
+	}
+
+	return nil
+}
+
+// SelectEnclosures picks which of the item's enclosures to download according to the mode, and returns one episode for
+// each of them. When downloading every enclosure, the extra enclosures are titled with a suffix ("Title (2)") so that
+// they get distinct filenames and can be synced separately. If the item doesn't have any enclosures, the alternative
+// media links are tried. This returns nil if the item has nothing to download (such as a blog post).
+func (e Episode) SelectEnclosures(mode string) []Episode {
+	var selected []Enclosure
+	for _, en := range e.Enclosures {
+		if en.URL == "" {
+			continue
+		}
+
+		switch mode {
+		case "", EnclosuresFirst:
+			if len(selected) == 0 {
+				selected = append(selected, en)
+			}
+		case EnclosuresAll:
+			selected = append(selected, en)
+		default:
+			if ok, _ := path.Match(mode, en.Type); ok && len(selected) == 0 {
+				selected = append(selected, en)
+			}
+		}
+	}
+
+	if len(e.Enclosures) > 0 && len(selected) == 0 {
+		e.debug("No enclosures of", e.Title, "match", mode)
+		return nil
+	}
+
+	if len(selected) == 0 {
+		if !e.resolveMedia() {
+			return nil
+		}
+		return []Episode{e}
+	}
+
+	episodes := make([]Episode, len(selected))
+	for i, en := range selected {
+		episodes[i] = e
+		episodes[i].Enclosure = en
+		if i > 0 {
+			episodes[i].Title = fmt.Sprintf("%s (%d)", e.Title, i+1)
+		}
+	}
+
+	return episodes
+}
+
+// resolveMedia fills in the enclosure from the alternative media links for items without an enclosure. Media RSS content
+// is tried first, and then any links that point directly at an audio file. This returns false if none of the links are
+// audio.
+func (e *Episode) resolveMedia() bool {
+	for _, media := range append(e.Media, e.MediaGroup...) {
+		if media.URL != "" && media.isAudio() {
+			e.debug("Using media:content link for", e.Title)
+			e.Enclosure = Enclosure{URL: media.URL, Type: media.Type, Size: media.Size}
+			return true
+		}
+	}
+
+	for _, link := range e.Links {
+		link = strings.TrimSpace(link)
+		if isAudioURL(link) {
+			e.debug("Using item link for", e.Title)
+			e.Enclosure = Enclosure{URL: link}
+			return true
+		}
+	}
+
+	return false
 }
 
 // Download downloads the episode. The bytes will stream through this path from web to disk:
@@ -51,44 +237,163 @@ func (e *Episode) Download(showDir string) error {
 		return err
 	}
 
+	// If the feed doesn't say what kind of file this is, we'll go by what it looks like before picking the name.
+	if err := e.sniffExt(showDir); err != nil {
+		return err
+	}
+
 	filename := e.buildFilename(showDir)
-	Debug("Saving episode to", filename)
+	e.debug("Saving episode to", filename)
 
-	file, err := os.Create(filename)
-	if err != nil {
+	// An episode downloaded again goes next to the old file until it's done, so that the old one is kept if it fails.
+	final := filename
+	if e.replaces != nil {
+		filename = replacementPath(filename)
+	}
+
+	// The filename template might put the episode in a subdirectory.
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
 		return err
 	}
-	defer file.Close()
 
-	resp, err := http.Get(e.Enclosure.URL)
+	// If the last attempt got past the tag, we can pick up where it left off.
+	offset := e.resumeOffset(filename)
+	if offset == 0 {
+		e.resetPhase()
+	}
+
+	resp, err := e.request(offset)
 	if err != nil {
-		os.Remove(filename)
+		if Interrupted() {
+			e.removePartial()
+			return errInterrupted
+		}
 		return err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		os.Remove(filename)
+	if offset > 0 && !resumed(resp, offset) {
+		Log("Server can't resume the download, starting over")
+		resp.Body.Close()
+		e.removePartial()
+		offset = 0
+		if resp, err = e.request(0); err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+	}
+
+	if offset == 0 && resp.StatusCode != 200 {
 		return fmt.Errorf("%v", resp.Status)
 	}
 
-	bar := Progress{total: int(resp.ContentLength), totalString: Reduce(int(resp.ContentLength))}
-	tee := io.TeeReader(resp.Body, &bar)
+	// A resumed download only has what's left of the limit.
+	opts := e.downloadOptions()
+	limit := opts.MaxSize
+	if limit > 0 && offset > 0 {
+		if limit -= offset; limit <= 0 {
+			return fmt.Errorf("%w: more than the limit of %v", errTooLarge, Reduce(opts.MaxSize))
+		}
+	}
+	body, err := limitBody(resp, limit)
+	if err != nil {
+		return err
+	}
+
+	var file *os.File
+	if offset > 0 {
+		Log("Resuming download at", Reduce(offset))
+		file, err = os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0644)
+	} else {
+		file, err = os.Create(filename)
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	e.partial = filename
+
+	serverSize := int(resp.ContentLength)
+	if offset > 0 && serverSize >= 0 {
+		serverSize += offset
+	}
 
-	// Connect the episode on both ends of the flow.
-	e.meta = NewMeta(nil)
+	bar := NewDownloadProgress(serverSize, e.FeedSize())
+	bar.have = offset
+	tee := io.TeeReader(body, bar)
+
+	// With -keep-original, the bytes are also saved exactly as they came from the server.
+	if opts.KeepOriginal && e.Enclosure.isAudio() {
+		if original, err := openOriginal(filename, offset); err != nil {
+			Log("Not keeping the original file:", err)
+		} else {
+			defer original.Close()
+			tee = io.TeeReader(tee, original)
+		}
+	}
+
+	// Connect the episode on both ends of the flow. A resumed download is already past the tag.
+	if offset == 0 {
+		e.meta = NewMeta(nil)
+	}
 	e.w = file
 
-	Debug("Beginning download process")
+	e.debug("Beginning download process")
+	start := time.Now()
 	_, err = io.Copy(e, tee)
+	e.elapsed = time.Since(start)
+	bar.Finish()
 	if err != nil {
-		Debug("I/O Copy error:", err)
-		os.Remove(filename)
-		bar.Finish()
+		e.debug("I/O Copy error:", err)
+		if Interrupted() {
+			e.removePartial()
+			Log("Removed partial download", filename)
+			return errInterrupted
+		}
+
+		// A file that's too large won't be any smaller on the next try.
+		if errors.Is(err, errTooLarge) {
+			return err
+		}
+
+		// Whether we can resume depends on how far we got.
+		if e.phase == phaseAudio {
+			Log("Connection lost after", Reduce(e.received)+":", err)
+		} else {
+			Log("Connection lost while reading the episode's tag:", err)
+			e.removePartial()
+		}
+		return errDownload
+	}
+
+	// Files too short to tell what they are are still in the first stage.
+	if e.phase == phaseStart && len(e.head) > 0 {
+		if err := e.flushHead(); err != nil {
+			return err
+		}
+	}
+
+	e.path = filename
+	e.size = bar.have
+	e.serverSize = serverSize
+	e.policy = opts.SizePolicy
+	if err := e.policy.Check(e.size, e.serverSize, e.FeedSize()); err != nil {
 		return err
 	}
+	if err := e.checkTag(); err != nil {
+		return err
+	}
+	if e.replaces != nil {
+		file.Close()
+		if err := e.replaces.finish(filename, final); err != nil {
+			return err
+		}
+		e.path = final
+	}
 
-	return bar.Finish()
+	e.resetPhase()
+	Log("Episode successfully downloaded", "("+Reduce(e.size), "in", e.elapsed.Round(time.Second), "at", Reduce(e.speed())+"/s)")
+	return nil
 }
 
 // Write first constructs and then writes the episode's metadata and then passes all remaining data on to the next layer.
@@ -98,11 +403,42 @@ func (e *Episode) Write(p []byte) (int, error) {
 	} else if e.w == nil {
 		return 0, fmt.Errorf("invalid writer")
 	}
+	noteProgress()
+
+	// Only audio gets metadata. Anything else (like a bonus PDF) is written as is.
+	if !e.Enclosure.isAudio() {
+		e.phase = phaseAudio
+		n, err := e.w.Write(p)
+		e.received += n
+		return n, err
+	}
+
+	// Before anything else, we need to know what kind of file this is.
+	if e.phase == phaseStart {
+		e.head = append(e.head, p...)
+		if len(e.head) < sniffLen {
+			return len(p), nil
+		}
+		if err := e.flushHead(); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
 
+	return e.write(p)
+}
+
+// write passes the data through the metadata stage (for files that get an ID3v2 tag) and on to the file.
+func (e *Episode) write(p []byte) (int, error) {
 	consumed := 0
-	if !e.meta.Buffered() {
+	if e.phase != phaseAudio && !e.meta.Buffered() {
 		// Continue buffering metadata.
+		e.phase = phaseTag
 		n, err := e.meta.Write(p)
+		e.received += n
+		if errors.Is(err, errTagTooLarge) {
+			return n, e.passTag(err)
+		}
 		if err != io.EOF {
 			// Either more data is needed or there was an error writing the metadata.
 			return n, err
@@ -120,16 +456,19 @@ func (e *Episode) Write(p []byte) (int, error) {
 		} else if n != len(metadata) {
 			return consumed, fmt.Errorf("failed to write complete metadata")
 		}
+		e.phase = phaseAudio
 
 		// Metadata has been written. At this point, the next bytes are audio data. Let's do a quick sanity check that
-		// they start with 0x00 like they should.
-		if consumed < len(p) && p[consumed] != 0x00 {
-			Debug("Possible data corruption: Audio data does not start with 0x00")
+		// they start with either padding or an MPEG frame like they should.
+		if consumed < len(p) && p[consumed] != 0x00 && p[consumed] != 0xFF && e.container == containerMPEG {
+			e.debug("Possible data corruption: Audio data does not start with 0x00 or 0xFF")
 		}
 	}
 
 	// If we're here, then all metadata has been successfully written. We can resume with writing the file data now.
+	e.phase = phaseAudio
 	n, err := e.w.Write(p[consumed:])
+	e.received += n
 	return consumed + n, err
 }
 
@@ -155,6 +494,76 @@ func (e *Episode) SetShowImage(image string) {
 	}
 }
 
+// SetShowLanguage sets the language of the episode's show, as given in the feed (e.g. "en-us").
+func (e *Episode) SetShowLanguage(language string) {
+	if e != nil {
+		e.showLanguage = language
+	}
+}
+
+// SetShowPeople sets the people credited for the entire show. These are used for episodes that don't credit anyone
+// themselves.
+func (e *Episode) SetShowPeople(people []Person) {
+	if e != nil {
+		e.showPeople = people
+	}
+}
+
+// SetShowGenre sets the genre of the episode's show.
+func (e *Episode) SetShowGenre(genre string) {
+	if e != nil {
+		e.showGenre = genre
+	}
+}
+
+// sourcePage returns the episode's web page from the feed, falling back to the feed itself.
+func (e *Episode) sourcePage() string {
+	for _, link := range e.Links {
+		link = strings.TrimSpace(link)
+		if strings.HasPrefix(link, "http") && !isAudioURL(link) {
+			return link
+		}
+	}
+
+	return e.showURL
+}
+
+// SetShowURL sets the location of the RSS feed of the episode's show.
+func (e *Episode) SetShowURL(u string) {
+	if e != nil {
+		e.showURL = u
+	}
+}
+
+// SetShowRights sets the copyright, content advisory, and funding information of the episode's show.
+func (e *Episode) SetShowRights(rights Rights) {
+	if e != nil {
+		e.showRights = rights
+	}
+}
+
+// SetShowAuth sets the login information of the episode's show. The credentials are only sent with requests to the
+// feed's host.
+func (e *Episode) SetShowAuth(creds *Credentials) {
+	if e != nil {
+		e.showAuth = creds
+	}
+}
+
+// FeedSize returns the size of the episode as reported by the RSS feed, or 0 if the feed did not report it.
+func (e *Episode) FeedSize() int {
+	if e == nil {
+		return 0
+	}
+
+	n, err := strconv.Atoi(strings.TrimSpace(e.Enclosure.Size))
+	if err != nil || n < 0 {
+		return 0
+	}
+
+	return n
+}
+
 // NumberFormatted parses the season and episode numbers and (if present) formats them according to
 // the configured minimum width prefix (if any).
 func (e *Episode) NumberFormatted() string {
@@ -166,14 +575,14 @@ func (e *Episode) NumberFormatted() string {
 
 	if e.Number != "" {
 		if n, err := strconv.ParseInt(e.Number, 10, 0); err == nil {
-			formatted := fmt.Sprintf("%0*v", PrefixMinWidth, n)
+			formatted := fmt.Sprintf("%0*v", intValue(e.options().MinWidth), n)
 			if s == "" {
 				s = formatted
 			} else {
 				s += "-" + formatted
 			}
 		} else {
-			Debug("Error parsing episode number:", err)
+			e.debug("Error parsing episode number:", err)
 		}
 	}
 
@@ -184,7 +593,7 @@ func (e *Episode) NumberFormatted() string {
 // will not be overwritten with data from the RSS feed. The only exceptions to this rule are the show and episode
 // titles, which must match the data from the RSS feed to sync properly.
 func (e *Episode) addFrames() {
-	Debug("Building metadata frames")
+	e.debug("Building metadata frames")
 
 	// Get the version, defaulting to ID3v2.3.
 	version := e.meta.Version()
@@ -194,10 +603,16 @@ func (e *Episode) addFrames() {
 	case 0:
 		version = 3
 	default:
-		Debug("Version", version, "is not currently supported")
+		e.debug("Version", version, "is not currently supported")
 		return
 	}
 
+	// With -clean-tags, the publisher's frames are thrown out, except for the ones that players need for the audio.
+	if e.downloadOptions().CleanTags {
+		e.debug("Removing the publisher's frames")
+		e.meta.RemoveFramesExcept(isAudioFrame)
+	}
+
 	// Always use the show and episode title from the RSS feed.
 	if e.meta.Version() == 2 {
 		e.meta.SetValue("TAL", []byte(e.showTitle), false)
@@ -208,7 +623,13 @@ func (e *Episode) addFrames() {
 	}
 
 	// Get the episode's timestamp.
-	ts := parseDate(e.Date)
+	ts := e.publishTime()
+
+	// Get the people credited for the episode.
+	involved, musicians, composer := e.creditFrames(version)
+
+	// Get the grouping and sorting values.
+	group, compilation, sortAlbum, sortArtist := e.groupingFrames()
 
 	frames := []struct {
 		idv2  string // ID3v2.2 frame ID
@@ -221,10 +642,25 @@ func (e *Episode) addFrames() {
 		{"TP2", "TPE2", "TPE2", e.showArtist}, // Album Artist
 
 		// Episode information
-		{"TPA", "TPOS", "TPOS", e.Season},        // Season number
-		{"TRK", "TRCK", "TRCK", e.Number},        // Episode number
-		{"TT3", "TDES", "TDES", e.Desc},          // Description
-		{"WAF", "WOAF", "WOAF", e.Enclosure.URL}, // Download link
+		{"TPA", "TPOS", "TPOS", e.discValue()},                   // Season number
+		{"TRK", "TRCK", "TRCK", e.trackValue()},                  // Episode number
+		{"TT3", "TDES", "TDES", e.Desc},                          // Description
+		{"WAF", "WOAF", "WOAF", e.Enclosure.URL},                 // Download link
+		{"WAS", "WOAS", "WOAS", publicURL(e.sourcePage())},       // Episode's web page, or the feed
+		{"TLA", "TLAN", "TLAN", languageISO6392(e.showLanguage)}, // Language
+		{"TCR", "TCOP", "TCOP", e.showRights.Copyright},          // Copyright
+		{"", "WPAY", "WPAY", e.showRights.fundingLink()},         // Funding
+
+		// Credits
+		{"IPL", "IPLS", "TIPL", involved}, // Hosts, guests, and other people involved
+		{"", "", "TMCL", musicians},       // Musicians
+		{"TCM", "TCOM", "TCOM", composer}, // Composer
+
+		// Grouping and sorting
+		{"TT1", "TIT1", "TIT1", group},       // Content group
+		{"TCP", "TCMP", "TCMP", compilation}, // Compilation
+		{"TSA", "TSOA", "TSOA", sortAlbum},   // Album sort order
+		{"TSP", "TSOP", "TSOP", sortArtist},  // Artist sort order
 
 		// Dates
 		{"TYE", "TYER", "", ts.Format("2006")},         // YYYY
@@ -233,7 +669,7 @@ func (e *Episode) addFrames() {
 		{"", "", "TDRC", ts.Format("20060102T150405")}, // YYYYMMDDTHHMMSS
 
 		// Defaults
-		{"TT1", "TCON", "TCON", "Podcast"},
+		{"TCO", "TCON", "TCON", formatGenre(e.showGenre, version)},
 		{"", "PCST", "PCST", "1"},
 	}
 
@@ -258,15 +694,38 @@ func (e *Episode) addFrames() {
 		}
 	}
 
+	// Record where the file came from so that it can be traced back to its feed.
+	if e.showURL != "" && e.meta.GetUserText("FEED_URL") == "" {
+		e.meta.SetUserText("FEED_URL", e.showURL)
+	}
+
+	// Keep the GUID from the feed so that the file can be matched up with its episode later, even if it's renamed or
+	// the state is lost.
+	if e.GUID != "" && e.meta.GetUserText("GUID") == "" {
+		e.meta.SetUserText("GUID", e.GUID)
+	}
+
+	// Mark the episode as explicit or clean for players with parental controls.
+	if advisory := e.advisory(); advisory != "" && e.meta.GetUserText("ITUNESADVISORY") == "" {
+		e.meta.SetUserText("ITUNESADVISORY", advisory)
+	}
+
 	// If the episode has an image, we'll add that. Otherwise, we'll try to get the default image of the show.
 	imageID := "APIC"
 	if version == 2 {
 		imageID = "PIC"
 	}
-	if values := e.meta.GetValues(imageID); values == nil || len(values) == 0 {
-		image := e.downloadImage()
-		if image != nil {
-			e.meta.SetValue(imageID, image, false)
+	switch {
+	case e.options().Artwork == ArtworkNone:
+		e.debug("Skipping artwork")
+	case e.options().Artwork == ArtworkExternal:
+		// The artwork is saved next to the episode instead, so any the publisher embedded is just taking up space.
+		if e.imageLink() != "" {
+			e.meta.RemoveValues(imageID)
+		}
+	case len(e.meta.GetValues(imageID)) == 0:
+		for _, image := range e.downloadImages() {
+			e.meta.SetValue(imageID, image, true)
 		}
 	}
 }
@@ -277,19 +736,22 @@ func (e *Episode) validateData() error {
 		return fmt.Errorf("cannot validata data: bad episode object")
 	}
 
-	Debug("Validating episode title:", e.Title)
+	e.debug("Validating episode title:", e.Title)
 	if e.Title == "" {
 		return fmt.Errorf("missing episode title")
 	}
 
-	Debug("Validating episode link:", e.Enclosure.URL)
+	e.debug("Validating episode link:", e.Enclosure.URL)
 	if e.Enclosure.URL == "" {
 		return fmt.Errorf("missing download link")
 	}
+	if e.downloadOptions().NoExternal && isTracker(e.Enclosure.URL) {
+		return fmt.Errorf("download link goes through tracker %v, which can't be removed", hostOf(e.Enclosure.URL))
+	}
 
-	Debug("Validating episode number:", e.Number)
+	e.debug("Validating episode number:", e.Number)
 	if e.Number == "" {
-		Debug("No episode number found")
+		e.debug("No episode number found")
 	}
 
 	return nil
@@ -298,18 +760,37 @@ func (e *Episode) validateData() error {
 // buildFilename pieces together the different components of the episode into one absolute-path filename.
 // TODO: Add better logic to determine if the episode/season number is already present.
 func (e *Episode) buildFilename(path string) string {
-	// Get the name of this episode.
-	base := SanitizeTitle(e.Title)
+	// Get the name of this episode, either from the filename template or from the default naming.
+	base := ""
+	if tmpl := e.options().filename; tmpl != nil {
+		if name, err := renderFilename(tmpl, e.templateData()); err != nil {
+			Log("Error building filename from template:", err)
+		} else {
+			base = name
+		}
+	}
+
+	if base == "" {
+		base = SanitizeTitle(e.Title)
 
-	// Add an episode/season number prefix if not already present.
-	if prefix := e.NumberFormatted(); prefix != "" {
-		if !strings.HasPrefix(base, prefix) {
-			base = prefix + " " + base
+		// Add an episode/season number prefix if not already present.
+		if prefix := e.NumberFormatted(); prefix != "" {
+			if !strings.HasPrefix(base, prefix) {
+				base = prefix + " " + base
+			}
 		}
 	}
 
 	// Add a filetype suffix if not already present.
-	ext := mimeToExt(e.Enclosure.Type)
+	ext := enclosureExt(e.Enclosure)
+	if ext == "" {
+		ext = e.ext
+	}
+	if !e.Enclosure.isAudio() {
+		if ext = mimeToExt(e.Enclosure.Type); ext == "" {
+			ext = otherExt(e.Enclosure)
+		}
+	}
 	if !strings.HasSuffix(base, ext) {
 		base += ext
 	}
@@ -326,12 +807,29 @@ func parseDate(date string) time.Time {
 	formats := []string{
 		"Mon, 02 Jan 2006 15:04:05 -0700",
 		"Mon, 02 Jan 2006 15:04:05 MST",
+		"Mon, 2 Jan 2006 15:04:05 -0700",
+		"Mon, 2 Jan 2006 15:04:05 MST",
+		"2 Jan 2006 15:04:05 -0700",
+		"2 Jan 2006 15:04:05 MST",
 	}
-	for i, format := range formats {
-		if ts, err := time.Parse(format, date); err != nil {
-			Debug("Error parsing time with format", i, "-", err)
-		} else {
-			return ts
+
+	// Some feeds use localized month and day names, so we'll also try the date with those translated to English. If
+	// the day name still isn't recognized, it's redundant anyway, so we'll try without it too.
+	dates := []string{strings.TrimSpace(date)}
+	if english := englishDate(dates[0]); english != dates[0] {
+		dates = append(dates, english)
+	}
+	if i := strings.Index(dates[len(dates)-1], ","); i >= 0 {
+		dates = append(dates, strings.TrimSpace(dates[len(dates)-1][i+1:]))
+	}
+
+	for _, d := range dates {
+		for i, format := range formats {
+			if ts, err := time.Parse(format, d); err != nil {
+				Debug("Error parsing time with format", i, "-", err)
+			} else {
+				return ts
+			}
 		}
 	}
 
@@ -340,87 +838,135 @@ func parseDate(date string) time.Time {
 	return time.Time{}
 }
 
-// downloadImage downloads either the episode (preferred) or show (fallback) image and build the APIC tag with the data.
-// If no link exists or there's any trouble downloading the image, this return nil.
-func (e *Episode) downloadImage() []byte {
-	if e == nil {
-		return nil
-	}
-	Debug("Downloading image")
-
-	var u *url.URL
-	var err error
-	if e.Image != "" {
-		u, err = url.Parse(e.Image)
-	} else if e.showImage != "" {
-		u, err = url.Parse(e.showImage)
-	} else {
-		Debug("No episode or show image to download")
-		return nil
+// isAudioURL determines if the URL points at an audio file, going by the extension of its path.
+func isAudioURL(link string) bool {
+	u, err := url.Parse(link)
+	if err != nil || u.Path == "" {
+		return false
 	}
 
-	if u == nil || err != nil {
-		Debug("Error parsing episode/show image link")
-		return nil
-	}
+	return isAudio(path.Base(u.Path))
+}
 
-	resp, err := http.Get(u.String())
-	if err != nil {
-		Debug("Error getting image information:", err)
-		return nil
+// otherExt finds the file extension for an enclosure that isn't audio, going by the extension in its URL and then its
+// MIME type.
+func otherExt(en Enclosure) string {
+	if u, err := url.Parse(en.URL); err == nil {
+		if ext := path.Ext(u.Path); ext != "" {
+			return ext
+		}
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		Debug("Error accessing image:", resp.StatusCode)
-		return nil
+	if genericType(en.Type) {
+		return ""
 	}
-
-	data, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		Debug("Error retrieving image:", err)
-		return nil
+	if exts, err := mime.ExtensionsByType(en.Type); err == nil && len(exts) > 0 {
+		return exts[0]
 	}
 
-	buf := new(bytes.Buffer)
-	// MIME type. We are going to explicitly not set this so that the image can set its own type internally.
-	buf.WriteByte(0x00)
-
-	// Picture type (hardcoded as "Cover (front)")
-	buf.WriteByte(0x03)
-
-	// Description (skipped)
-	buf.WriteByte(0x00)
-
-	// Picture data
-	buf.Write(data)
-
-	return buf.Bytes()
+	return ""
 }
 
-// mimeToExt finds the appropriate file extension based on the MIME type.
-func mimeToExt(mime string) string {
+// mimeToExt finds the appropriate file extension based on the MIME type, or "" if the type isn't known.
+func mimeToExt(mimeType string) string {
+	// Parameters like "; codecs=opus" don't change the extension.
+	if i := strings.IndexByte(mimeType, ';'); i >= 0 {
+		mimeType = mimeType[:i]
+	}
+	mimeType = strings.ToLower(strings.TrimSpace(mimeType))
+
 	var ext string
-	switch mime {
-	case "audio/aac":
+	switch mimeType {
+	case "audio/aac", "audio/x-aac":
 		ext = ".aac"
+	case "audio/aiff", "audio/x-aiff":
+		ext = ".aiff"
+	case "audio/flac", "audio/x-flac":
+		ext = ".flac"
+	case "audio/mp4", "audio/m4a", "audio/x-m4a":
+		ext = ".m4a"
+	case "audio/x-m4b":
+		ext = ".m4b"
 	case "audio/midi", "audio/x-midi":
 		ext = ".midi"
-	case "audio/mpeg", "audio/mp3":
+	case "audio/mpeg", "audio/mp3", "audio/mpeg3", "audio/x-mpeg", "audio/x-mp3":
 		ext = ".mp3"
-	case "audio/ogg":
+	case "audio/ogg", "audio/vorbis":
 		ext = ".oga"
 	case "audio/opus":
 		ext = ".opus"
-	case "audio/wav":
+	case "audio/wav", "audio/wave", "audio/x-wav":
 		ext = ".wav"
 	case "audio/webm":
 		ext = ".weba"
-	default:
-		// If we can't match a specific type, we'll default to mp3.
-		ext = ".mp3"
+	case "video/mp4":
+		ext = ".mp4"
+	case "video/x-m4v":
+		ext = ".m4v"
+	case "video/quicktime":
+		ext = ".mov"
+	case "video/webm":
+		ext = ".webm"
 	}
 
-	Debug("Mapping MIME type", mime, "to extension", ext)
+	Debug("Mapping MIME type", mimeType, "to extension", ext)
 	return ext
 }
+
+// containerExt returns the file extension for the kind of audio file, or "" if it isn't known.
+func containerExt(container string) string {
+	switch container {
+	case containerMPEG:
+		return ".mp3"
+	case containerOgg:
+		return ".ogg"
+	case containerMP4:
+		return ".m4a"
+	case containerFLAC:
+		return ".flac"
+	case containerWAV:
+		return ".wav"
+	case containerAIFF:
+		return ".aiff"
+	}
+
+	return ""
+}
+
+// enclosureExt finds the file extension for the enclosure, going by its MIME type, then the extension in its URL, and
+// then any other extension registered for the MIME type. If none of those work, this returns "", and the extension is
+// chosen from a file that's already there or by sniffing the start of the file (see findExt and sniffExt).
+func enclosureExt(en Enclosure) string {
+	if ext := mimeToExt(en.Type); ext != "" {
+		return ext
+	}
+
+	if u, err := url.Parse(en.URL); err == nil {
+		if ext := strings.ToLower(path.Ext(u.Path)); isAudio(ext) {
+			return ext
+		}
+	}
+
+	if genericType(en.Type) {
+		return ""
+	}
+	if exts, err := mime.ExtensionsByType(en.Type); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+
+	return ""
+}
+
+// passTag writes out a tag that is too large to hold in memory exactly as it was published, instead of retagging the
+// episode. Everything after what was buffered so far (the rest of the tag and then the audio) goes straight to the file
+// as it arrives.
+func (e *Episode) passTag(reason error) error {
+	Log("Keeping the episode's tag as published:", reason, "(limit is", Reduce(maxTagSize())+")")
+	if _, err := e.w.Write(e.meta.Bytes()); err != nil {
+		return err
+	}
+
+	e.meta = NewMeta(nil)
+	e.phase = phaseAudio
+	return nil
+}
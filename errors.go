@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// These are the kinds of failures that callers can check for with errors.Is.
+var (
+	ErrFeedUnreachable = errors.New("feed unreachable")
+	ErrNoEpisodes      = errors.New("no episodes found")
+	ErrDiskFull        = errors.New("no space left on disk")
+	ErrEpisodeNotFound = errors.New("episode not found")
+	ErrCircuitOpen     = errors.New("host unavailable")
+)
+
+// Error is an error of a known kind. It keeps the underlying error (if any) so that both the kind and the cause can be
+// checked with errors.Is and errors.As.
+type Error struct {
+	Kind error  // one of the Err values above
+	Err  error  // underlying error, or nil
+	msg  string // full message
+}
+
+// newError creates an error of the kind with the formatted message. The last argument, if it's an error, becomes the
+// underlying error.
+func newError(kind error, format string, a ...interface{}) error {
+	e := &Error{Kind: kind, msg: fmt.Sprintf(format, a...)}
+	if len(a) > 0 {
+		if err, ok := a[len(a)-1].(error); ok {
+			e.Err = err
+		}
+	}
+
+	return e
+}
+
+// Error returns the full error message.
+func (e *Error) Error() string {
+	if e == nil {
+		return "<nil>"
+	}
+
+	return e.msg
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	if e == nil {
+		return nil
+	}
+
+	return e.Err
+}
+
+// Is reports whether the error is of the target kind.
+func (e *Error) Is(target error) bool {
+	return e != nil && e.Kind == target
+}
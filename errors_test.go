@@ -0,0 +1,32 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+)
+
+// Test that errors can be checked for both their kind and their cause.
+func TestErrorKinds(t *testing.T) {
+	err := newError(ErrDiskFull, "error writing episode: %v", syscall.ENOSPC)
+	wrapped := fmt.Errorf("error syncing show: %w", err)
+
+	if !errors.Is(wrapped, ErrDiskFull) {
+		t.Error("Error is not ErrDiskFull")
+	}
+	if !errors.Is(wrapped, syscall.ENOSPC) {
+		t.Error("Error does not wrap ENOSPC")
+	}
+	if errors.Is(wrapped, ErrFeedUnreachable) {
+		t.Error("Error matches the wrong kind")
+	}
+
+	var e *Error
+	if !errors.As(wrapped, &e) || e.Kind != ErrDiskFull {
+		t.Error("Could not get the error's kind")
+	}
+	if have, want := err.Error(), "error writing episode: "+syscall.ENOSPC.Error(); have != want {
+		t.Error("Message does not match - Want:", want, "Have:", have)
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Feed is a fetch of a show's feed, independent of how the feed's XML is laid out. Show and Episode are shaped around
+// decoding the XML and carry the state of a sync; Feed and Item hold just what the feed said, for comparing fetches.
+type Feed struct {
+	URL         string
+	Title       string
+	Author      string
+	Description string
+	Language    string
+	Image       string
+	People      []Person
+	Categories  []Category
+	Copyright   string
+	Explicit    string
+	Funding     []Funding
+	Hub         string // WebSub hub that pushes updates of the feed, if it has one
+	Self        string // URL that the feed gives for itself, which is the topic to subscribe to at the hub
+	Items       []Item // in the order they appear in the feed
+}
+
+// Item is an episode in a Feed.
+type Item struct {
+	Key         string // GUID, or the title if there isn't one, which is how items are matched across fetches
+	GUID        string
+	Title       string
+	Date        string    // pubDate as it appears in the feed
+	Published   time.Time // parsed pubDate, or the zero time if it couldn't be parsed
+	Season      string
+	Number      string
+	Duration    string
+	Description string
+	Content     string // full show notes, if the feed has them apart from the description
+	Image       string
+	Explicit    string
+	Enclosures  []Enclosure
+	Persons     []Person
+	Chapters    ChaptersLink
+	Media       []MediaContent // alternative media, for items without an enclosure
+	MediaGroup  []MediaContent
+	Links       []string
+}
+
+// NewFeed builds the feed model from the parsed show.
+func NewFeed(s *Show) *Feed {
+	if s == nil {
+		return nil
+	}
+
+	feed := &Feed{
+		Title:       s.Title,
+		Author:      s.Author,
+		Description: s.Desc,
+		Language:    s.Language,
+		Image:       s.Image,
+		People:      s.People,
+		Categories:  s.Categories,
+		Copyright:   s.Copyright,
+		Explicit:    s.Explicit,
+		Funding:     s.Funding,
+		Items:       make([]Item, 0, len(s.Episodes)),
+	}
+	feed.Hub, feed.Self = webSubLinks(s.AtomLinks)
+	if s.URL != nil {
+		feed.URL = s.URL.String()
+	}
+	for i := range s.Episodes {
+		feed.Items = append(feed.Items, NewItem(&s.Episodes[i]))
+	}
+
+	return feed
+}
+
+// NewItem builds the item model from the parsed episode.
+func NewItem(e *Episode) Item {
+	item := Item{
+		Key:         e.GUID,
+		GUID:        e.GUID,
+		Title:       e.Title,
+		Date:        e.Date,
+		Published:   parseDate(e.Date),
+		Season:      e.Season,
+		Number:      e.Number,
+		Duration:    e.Duration,
+		Description: e.Desc,
+		Content:     e.Content,
+		Image:       e.Image,
+		Explicit:    e.Explicit,
+		Enclosures:  append([]Enclosure(nil), e.Enclosures...),
+		Persons:     e.Persons,
+		Chapters:    e.Chapters,
+		Media:       e.Media,
+		MediaGroup:  e.MediaGroup,
+		Links:       e.Links,
+	}
+	if item.Key == "" {
+		item.Key = e.Title
+	}
+
+	return item
+}
+
+// fill sets the show's information and episodes from the feed, in the same order as the feed's items.
+func (f *Feed) fill(s *Show) {
+	s.Title = f.Title
+	s.Author = f.Author
+	s.Desc = f.Description
+	s.Language = f.Language
+	s.Image = f.Image
+	s.People = f.People
+	s.Categories = f.Categories
+	s.Copyright = f.Copyright
+	s.Explicit = f.Explicit
+	s.Funding = f.Funding
+	s.AtomLinks = nil
+	if f.Hub != "" {
+		s.AtomLinks = append(s.AtomLinks, atomLink{Href: f.Hub, Rel: "hub"})
+	}
+	if f.Self != "" {
+		s.AtomLinks = append(s.AtomLinks, atomLink{Href: f.Self, Rel: "self"})
+	}
+
+	s.Episodes = make([]Episode, 0, len(f.Items))
+	for _, item := range f.Items {
+		s.Episodes = append(s.Episodes, item.episode())
+	}
+}
+
+// episode builds an episode from the item.
+func (i Item) episode() Episode {
+	return Episode{
+		Title:      i.Title,
+		GUID:       i.GUID,
+		Season:     i.Season,
+		Number:     i.Number,
+		Image:      i.Image,
+		Desc:       i.Description,
+		Content:    i.Content,
+		Date:       i.Date,
+		Duration:   i.Duration,
+		Enclosures: i.Enclosures,
+		Persons:    i.Persons,
+		Chapters:   i.Chapters,
+		Explicit:   i.Explicit,
+		Media:      i.Media,
+		MediaGroup: i.MediaGroup,
+		Links:      i.Links,
+	}
+}
+
+// enclosure returns the item's first enclosure, or an empty one if it doesn't have any.
+func (i Item) enclosure() Enclosure {
+	if len(i.Enclosures) == 0 {
+		return Enclosure{}
+	}
+
+	return i.Enclosures[0]
+}
+
+// These are the ways that an item can differ between two fetches.
+const (
+	ItemAdded   = "+"
+	ItemRemoved = "-"
+	ItemChanged = "~"
+)
+
+// FieldChange is a field whose value changed between two fetches.
+type FieldChange struct {
+	Field string
+	Old   string
+	New   string
+}
+
+// ItemDiff is an item that was added, removed, or changed between two fetches. Old is nil for added items, New is nil
+// for removed items, and Changes lists the fields that changed for changed items.
+type ItemDiff struct {
+	Kind    string // ItemAdded, ItemRemoved, or ItemChanged
+	Old     *Item
+	New     *Item
+	Changes []FieldChange
+}
+
+// FeedDiff holds the differences between two fetches of the same feed.
+type FeedDiff struct {
+	Changes []FieldChange // changes to the show itself
+	Items   []ItemDiff    // added and changed items in the new feed's order, then removed items in the old feed's order
+}
+
+// DiffFeed computes the differences between two fetches of the same feed. Items are matched by their GUID or, if they
+// don't have one, their title. Either feed can be nil, which is treated as a feed without any items.
+func DiffFeed(oldFeed, newFeed *Feed) FeedDiff {
+	if oldFeed == nil {
+		oldFeed = &Feed{}
+	}
+	if newFeed == nil {
+		newFeed = &Feed{}
+	}
+
+	var diff FeedDiff
+	diff.Changes = diffFields([]FieldChange{
+		{"title", oldFeed.Title, newFeed.Title},
+		{"author", oldFeed.Author, newFeed.Author},
+		{"description", oldFeed.Description, newFeed.Description},
+		{"language", oldFeed.Language, newFeed.Language},
+		{"image", oldFeed.Image, newFeed.Image},
+	})
+
+	oldItems := make(map[string]*Item)
+	for i := range oldFeed.Items {
+		oldItems[oldFeed.Items[i].Key] = &oldFeed.Items[i]
+	}
+
+	seen := make(map[string]bool)
+	for i := range newFeed.Items {
+		item := &newFeed.Items[i]
+		seen[item.Key] = true
+
+		old, ok := oldItems[item.Key]
+		if !ok {
+			diff.Items = append(diff.Items, ItemDiff{Kind: ItemAdded, New: item})
+			continue
+		}
+
+		if changes := DiffItem(*old, *item); len(changes) > 0 {
+			diff.Items = append(diff.Items, ItemDiff{Kind: ItemChanged, Old: old, New: item, Changes: changes})
+		}
+	}
+
+	for i := range oldFeed.Items {
+		if item := &oldFeed.Items[i]; !seen[item.Key] {
+			diff.Items = append(diff.Items, ItemDiff{Kind: ItemRemoved, Old: item})
+		}
+	}
+
+	return diff
+}
+
+// DiffItem returns the fields that differ between two versions of the same item.
+func DiffItem(old, new Item) []FieldChange {
+	oldEnclosure, newEnclosure := old.enclosure(), new.enclosure()
+
+	return diffFields([]FieldChange{
+		{"title", old.Title, new.Title},
+		{"pubDate", old.Date, new.Date},
+		{"season", old.Season, new.Season},
+		{"episode", old.Number, new.Number},
+		{"duration", old.Duration, new.Duration},
+		{"description", old.Description, new.Description},
+		{"image", old.Image, new.Image},
+		{"explicit", old.Explicit, new.Explicit},
+		{"enclosure URL", oldEnclosure.URL, newEnclosure.URL},
+		{"enclosure length", oldEnclosure.Size, newEnclosure.Size},
+		{"enclosure type", oldEnclosure.Type, newEnclosure.Type},
+		{"enclosures", strconv.Itoa(len(old.Enclosures)), strconv.Itoa(len(new.Enclosures))},
+	})
+}
+
+// diffFields returns the fields whose old and new values are different.
+func diffFields(fields []FieldChange) []FieldChange {
+	var changes []FieldChange
+	for _, field := range fields {
+		if field.Old != field.New {
+			changes = append(changes, field)
+		}
+	}
+
+	return changes
+}
+
+// Empty reports whether or not the two fetches were the same.
+func (d FeedDiff) Empty() bool {
+	return len(d.Changes) == 0 && len(d.Items) == 0
+}
+
+// Filter returns the items of the provided kind.
+func (d FeedDiff) Filter(kind string) []ItemDiff {
+	var items []ItemDiff
+	for _, item := range d.Items {
+		if item.Kind == kind {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// Lines describes the differences for printing, one change per line. Descriptions are too long to print in full, so
+// only the fact that they changed is shown.
+func (d FeedDiff) Lines() []string {
+	var lines []string
+
+	for _, change := range d.Changes {
+		lines = append(lines, "~ Show "+change.line())
+	}
+
+	for _, item := range d.Items {
+		switch item.Kind {
+		case ItemAdded:
+			lines = append(lines, "+ "+item.New.Title)
+		case ItemRemoved:
+			lines = append(lines, "- "+item.Old.Title)
+		case ItemChanged:
+			for _, change := range item.Changes {
+				lines = append(lines, fmt.Sprintf("~ %s: %s", item.New.Title, change.line()))
+			}
+		}
+	}
+
+	if len(lines) == 0 {
+		lines = append(lines, "No changes")
+	}
+
+	return lines
+}
+
+// line describes the change for printing.
+func (c FieldChange) line() string {
+	if c.Field == "description" {
+		return "description changed"
+	}
+
+	return fmt.Sprintf("%s: %q -> %q", c.Field, c.Old, c.New)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// Test that items are matched by GUID or title and sorted into added, changed, and removed.
+func TestDiffFeed(t *testing.T) {
+	oldFeed := &Feed{Title: "Show", Items: []Item{
+		{Key: "1", GUID: "1", Title: "One"},
+		{Key: "Two", Title: "Two", Duration: "10:00"},
+		{Key: "3", GUID: "3", Title: "Three"},
+	}}
+	newFeed := &Feed{Title: "Show (Rebooted)", Items: []Item{
+		{Key: "4", GUID: "4", Title: "Four"},
+		{Key: "Two", Title: "Two", Duration: "12:00", Description: "Now with notes"},
+		{Key: "3", GUID: "3", Title: "Three"},
+	}}
+
+	diff := DiffFeed(oldFeed, newFeed)
+	if diff.Empty() {
+		t.Fatal("No differences found")
+	}
+
+	wantShow := []FieldChange{{"title", "Show", "Show (Rebooted)"}}
+	if !reflect.DeepEqual(diff.Changes, wantShow) {
+		t.Error("Incorrect show changes - Want:", wantShow, "Have:", diff.Changes)
+	}
+
+	if added := diff.Filter(ItemAdded); len(added) != 1 || added[0].New.Title != "Four" {
+		t.Error("Incorrect added items:", added)
+	}
+	if removed := diff.Filter(ItemRemoved); len(removed) != 1 || removed[0].Old.Title != "One" {
+		t.Error("Incorrect removed items:", removed)
+	}
+
+	wantChanges := []FieldChange{{"duration", "10:00", "12:00"}, {"description", "", "Now with notes"}}
+	if changed := diff.Filter(ItemChanged); len(changed) != 1 || !reflect.DeepEqual(changed[0].Changes, wantChanges) {
+		t.Error("Incorrect changed items - Want:", wantChanges, "Have:", changed)
+	}
+
+	if !DiffFeed(newFeed, newFeed).Empty() {
+		t.Error("Differences found between identical feeds")
+	}
+}
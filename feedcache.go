@@ -0,0 +1,180 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// feedCacheKeep is the number of fetches to keep for each feed in the feed cache.
+const feedCacheKeep = 20
+
+// feedCacheFormat is the timestamp format used for naming the cached copies of a feed. It goes down to the nanosecond
+// so that fetches in the same second don't overwrite each other, and its fixed width sorts chronologically.
+const feedCacheFormat = "20060102T150405.000000000Z"
+
+// ParseFeedArg parses the feed URL given on the command line with -u. Every command that takes -u parses it the same
+// way so that they agree on which feed it is.
+func ParseFeedArg(urlArg string) (*url.URL, error) {
+	u, err := url.Parse(urlArg)
+	if err != nil {
+		return nil, err
+	}
+
+	return normalizeFeedURL(u), nil
+}
+
+// normalizeFeedURL returns a copy of the URL with its scheme and host lower-cased, which is the only part of a URL that
+// isn't case-sensitive. The path and query are left alone, as they may hold tokens.
+func normalizeFeedURL(u *url.URL) *url.URL {
+	normal := *u
+	normal.Scheme = strings.ToLower(u.Scheme)
+	normal.Host = strings.ToLower(u.Host)
+
+	return &normal
+}
+
+// feedCacheDir returns the directory in the feed cache holding all the saved fetches for the feed at the URL. The
+// directory is named after a hash of the URL so that the tokens of private feeds don't show up in the name.
+func feedCacheDir(u *url.URL) string {
+	sum := sha256.Sum256([]byte(normalizeFeedURL(u).String()))
+	host := strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`*"?/\<>:|`, r) {
+			return '_'
+		}
+		return r
+	}, strings.ToLower(u.Hostname()))
+
+	return filepath.Join(ActiveProfile.CacheDir(), "feeds", host+"-"+hex.EncodeToString(sum[:8]))
+}
+
+// SaveFeed stores the raw feed data in the feed cache with the current time, then prunes the oldest copies past the
+// retention count.
+func SaveFeed(u *url.URL, data []byte) error {
+	dir := feedCacheDir(u)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	// If another fetch got the same timestamp, this one is saved just after it.
+	stamp := time.Now().UTC()
+	var file *os.File
+	var err error
+	for {
+		path := filepath.Join(dir, stamp.Format(feedCacheFormat)+".xml")
+		file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if !os.IsExist(err) {
+			break
+		}
+		stamp = stamp.Add(time.Nanosecond)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	Debug("Saved feed to", file.Name())
+
+	saved, err := cachedFeeds(dir)
+	if err != nil {
+		return err
+	}
+	for len(saved) > feedCacheKeep {
+		os.Remove(filepath.Join(dir, saved[0]))
+		saved = saved[1:]
+	}
+
+	return nil
+}
+
+// cachedFeeds returns the names of all cached copies of the feed in the directory, oldest first.
+func cachedFeeds(dir string) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, info := range infos {
+		if !info.IsDir() && filepath.Ext(info.Name()) == ".xml" {
+			names = append(names, info.Name())
+		}
+	}
+
+	// The timestamp format sorts chronologically.
+	sort.Strings(names)
+	return names, nil
+}
+
+// runDiffFeed compares the last two cached fetches of the feed and prints out what changed between them.
+func runDiffFeed(urlArg string) error {
+	if urlArg == "" {
+		Log("No show specified")
+		return errUsage
+	}
+
+	u, err := ParseFeedArg(urlArg)
+	if err != nil {
+		Log("Invalid URL:", err)
+		return errUsage
+	}
+
+	dir := feedCacheDir(u)
+	saved, err := cachedFeeds(dir)
+	if err != nil || len(saved) < 2 {
+		return fmt.Errorf("need at least 2 cached fetches of the feed to compare (enable with -feed-cache)")
+	}
+
+	oldName, newName := saved[len(saved)-2], saved[len(saved)-1]
+	oldShow, err := readCachedFeed(filepath.Join(dir, oldName))
+	if err != nil {
+		return err
+	}
+	newShow, err := readCachedFeed(filepath.Join(dir, newName))
+	if err != nil {
+		return err
+	}
+
+	Log("Comparing", oldName, "to", newName)
+	for _, line := range DiffFeeds(oldShow, newShow) {
+		Log(line)
+	}
+
+	return nil
+}
+
+// readCachedFeed parses the feed saved at the path.
+func readCachedFeed(path string) (*Show, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	feed, err := ParseFeed(data)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing %v: %v", filepath.Base(path), err)
+	}
+	show := new(Show)
+	feed.fill(show)
+
+	return show, nil
+}
+
+// DiffFeeds describes the differences between two fetches of the same feed: new items, removed items, and any changes
+// in the items present in both. Items are matched by their GUID or, if they don't have one, their title.
+func DiffFeeds(oldShow, newShow *Show) []string {
+	return DiffFeed(NewFeed(oldShow), NewFeed(newShow)).Lines()
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test that changes between two fetches of a feed are found.
+func TestDiffFeeds(t *testing.T) {
+	before := `<rss><channel><title>Show</title>
+		<item><title>One</title><guid>1</guid><enclosure url="https://example.com/1.mp3" length="100"/></item>
+		<item><title>Two</title><guid>2</guid><enclosure url="https://example.com/2.mp3" length="200"/></item>
+	</channel></rss>`
+	after := `<rss><channel><title>Show</title>
+		<item><title>Two</title><guid>2</guid><enclosure url="https://example.com/2-fixed.mp3" length="200"/></item>
+		<item><title>Three</title><guid>3</guid><enclosure url="https://example.com/3.mp3" length="300"/></item>
+	</channel></rss>`
+
+	var oldShow, newShow Show
+	if err := xml.Unmarshal([]byte(before), &oldShow); err != nil {
+		t.Fatal(err)
+	}
+	if err := xml.Unmarshal([]byte(after), &newShow); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		`~ Two: enclosure URL: "https://example.com/2.mp3" -> "https://example.com/2-fixed.mp3"`,
+		"+ Three",
+		"- One",
+	}
+	have := DiffFeeds(&oldShow, &newShow)
+	if len(have) != len(want) {
+		t.Fatal("Want:", want, "Have:", have)
+	}
+	for i := range want {
+		if have[i] != want[i] {
+			t.Error("Want:", want[i])
+			t.Log("\tHave:", have[i])
+		}
+	}
+}
+
+// Test that fetches saved within the same second are all kept, that only the user can read them, and that diff-feed
+// finds the feed saved by a sync for the same -u, whatever the case of its scheme and host.
+func TestSaveFeed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast-feeds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("XDG_CACHE_HOME", dir)
+	defer os.Unsetenv("XDG_CACHE_HOME")
+
+	u, err := ParseFeedArg("https://Example.com/Feed.xml?auth=SecretToken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, title := range []string{"One", "Two", "Three"} {
+		feed := "<rss><channel><title>Show</title><item><title>" + title + "</title></item></channel></rss>"
+		if err := SaveFeed(u, []byte(feed)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	saved, err := cachedFeeds(feedCacheDir(u))
+	if err != nil || len(saved) != 3 {
+		t.Fatal("Incorrect number of saved fetches - Want: 3 Have:", saved, err)
+	}
+	last, err := readCachedFeed(filepath.Join(feedCacheDir(u), saved[2]))
+	if err != nil || len(last.Episodes) != 1 || last.Episodes[0].Title != "Three" {
+		t.Error("Saved fetches out of order:", saved)
+	}
+
+	if name := filepath.Base(feedCacheDir(u)); strings.Contains(name, "SecretToken") {
+		t.Error("Token in cache directory name:", name)
+	}
+	info, err := os.Stat(filepath.Join(feedCacheDir(u), saved[0]))
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Error("Incorrect permissions for saved fetch - Want: 0600 Have:", info, err)
+	}
+
+	// The sync's URL comes straight from the config.
+	sub, err := url.Parse("HTTPS://EXAMPLE.com/Feed.xml?auth=SecretToken")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feedCacheDir(sub) != feedCacheDir(u) {
+		t.Error("Sync and -u use different directories - Want:", feedCacheDir(u), "Have:", feedCacheDir(sub))
+	}
+	if err := runDiffFeed("https://EXAMPLE.com/Feed.xml?auth=SecretToken"); err != nil {
+		t.Error("diff-feed didn't find the saved fetches:", err)
+	}
+	if err := runDiffFeed("https://example.com/feed.xml?auth=secrettoken"); err == nil {
+		t.Error("diff-feed found the fetches of a different feed")
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// FieldMap maps episode fields to where a feed keeps them, for feeds that put data in nonstandard tags. Each selector is
+// a path of element names under the item, separated by "/", with namespace prefixes written the way the feed writes
+// them. A path can end with "@name" to take an attribute instead of the element's text, e.g.
+// {"number": "abc:episodeNumber", "image": "media:thumbnail/@url"}.
+type FieldMap map[string]string
+
+// fieldSetters are the episode fields that can be mapped, and how to set each one.
+var fieldSetters = map[string]func(e *Episode, value string){
+	"title":    func(e *Episode, value string) { e.Title = value },
+	"guid":     func(e *Episode, value string) { e.GUID = value },
+	"number":   func(e *Episode, value string) { e.Number = fieldNumber(value) },
+	"season":   func(e *Episode, value string) { e.Season = fieldNumber(value) },
+	"image":    func(e *Episode, value string) { e.Image = value },
+	"date":     func(e *Episode, value string) { e.Date = value },
+	"duration": func(e *Episode, value string) { e.Duration = value },
+}
+
+// fieldDigits finds the number in values like "Episode 12".
+var fieldDigits = regexp.MustCompile(`\d+`)
+
+// fieldNumber returns the first number in the value, or the value itself if it doesn't have one.
+func fieldNumber(value string) string {
+	if number := fieldDigits.FindString(value); number != "" {
+		return number
+	}
+
+	return value
+}
+
+// fieldSelector is a parsed selector.
+type fieldSelector struct {
+	field string
+	path  []string // element names under the item
+	attr  string   // attribute to take, or "" for the element's text
+}
+
+// validate checks that every field can be mapped and every selector can be parsed.
+func (m FieldMap) validate() error {
+	_, err := m.selectors()
+	return err
+}
+
+// selectors parses the selectors, in order of their field names.
+func (m FieldMap) selectors() ([]fieldSelector, error) {
+	var selectors []fieldSelector
+	for field, selector := range m {
+		if _, ok := fieldSetters[field]; !ok {
+			return nil, fmt.Errorf("invalid field mapping: unknown field %q", field)
+		}
+
+		parts := strings.Split(strings.Trim(strings.TrimSpace(selector), "/"), "/")
+		parsed := fieldSelector{field: field}
+		if last := parts[len(parts)-1]; strings.HasPrefix(last, "@") {
+			parsed.attr = strings.TrimPrefix(last, "@")
+			parts = parts[:len(parts)-1]
+		}
+		for _, part := range parts {
+			if part == "" || strings.ContainsAny(part, "@[]* ") {
+				return nil, fmt.Errorf("invalid field mapping for %v: %q", field, selector)
+			}
+		}
+		if len(parts) == 0 && parsed.attr == "" {
+			return nil, fmt.Errorf("invalid field mapping for %v: %q", field, selector)
+		}
+		parsed.path = parts
+		selectors = append(selectors, parsed)
+	}
+
+	sort.Slice(selectors, func(i, j int) bool { return selectors[i].field < selectors[j].field })
+	return selectors, nil
+}
+
+// apply reads the mapped fields out of the feed's items and sets them on the episodes, which must be in the same order
+// as the items in the feed. Fields that an item doesn't have are left as they are.
+func (m FieldMap) apply(data []byte, episodes []Episode) error {
+	if len(m) == 0 {
+		return nil
+	}
+
+	selectors, err := m.selectors()
+	if err != nil {
+		return err
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false
+
+	var stack []string      // names of the elements we're in, from the root
+	var path []string       // names of the elements we're in, under the current item
+	var values []string     // value of each selector for the current item
+	var text []bytes.Buffer // text of each selector's element, while we're in it
+	item := -1
+	for {
+		token, err := decoder.RawToken()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("error reading mapped fields: %v", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			name := rawName(t.Name)
+			if path == nil && name == "item" && len(stack) > 0 && stack[len(stack)-1] == "channel" {
+				item++
+				path = []string{}
+				values = make([]string, len(selectors))
+				text = make([]bytes.Buffer, len(selectors))
+			} else if path != nil {
+				path = append(path, name)
+			}
+			if path != nil {
+				for i, selector := range selectors {
+					if selector.attr == "" || !samePath(path, selector.path) {
+						continue
+					}
+					for _, attr := range t.Attr {
+						if rawName(attr.Name) == selector.attr && values[i] == "" {
+							values[i] = strings.TrimSpace(attr.Value)
+						}
+					}
+				}
+			}
+			stack = append(stack, name)
+
+		case xml.CharData:
+			for i, selector := range selectors {
+				if path != nil && selector.attr == "" && samePath(path, selector.path) {
+					text[i].Write(t)
+				}
+			}
+
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+			if path == nil {
+				continue
+			}
+			if len(path) > 0 {
+				for i, selector := range selectors {
+					if selector.attr == "" && samePath(path, selector.path) && values[i] == "" {
+						values[i] = strings.TrimSpace(text[i].String())
+					}
+				}
+				path = path[:len(path)-1]
+				continue
+			}
+
+			// This is the end of the item.
+			if item < len(episodes) {
+				for i, selector := range selectors {
+					if values[i] != "" {
+						fieldSetters[selector.field](&episodes[item], values[i])
+					}
+				}
+			}
+			path = nil
+		}
+	}
+
+	return nil
+}
+
+// rawName returns the name with its prefix, as it's written in the feed.
+func rawName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+
+	return name.Space + ":" + name.Local
+}
+
+// samePath reports whether or not the two paths are the same.
+func samePath(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+// Test that mapped fields are read out of nonstandard tags and attributes, item by item.
+func TestFieldMap(t *testing.T) {
+	feed := `<rss xmlns:abc="https://example.com/ns" xmlns:media="http://search.yahoo.com/mrss/"><channel><title>Show</title>
+		<item><title>First</title><abc:meta><abc:ep>Episode 12</abc:ep><abc:season>3</abc:season></abc:meta>
+			<media:thumbnail url="https://example.com/12.jpg"/></item>
+		<item><title>Second</title><episode>4</episode><abc:ep>99</abc:ep></item>
+	</channel></rss>`
+
+	var show Show
+	if err := xml.Unmarshal([]byte(feed), &show); err != nil {
+		t.Fatal(err)
+	}
+
+	fields := FieldMap{"number": "abc:meta/abc:ep", "season": "abc:meta/abc:season", "image": "media:thumbnail/@url"}
+	if err := fields.apply([]byte(feed), show.Episodes); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Episode{
+		{Title: "First", Number: "12", Season: "3", Image: "https://example.com/12.jpg"},
+		{Title: "Second", Number: "4"},
+	}
+	for i, e := range show.Episodes {
+		if e.Number != want[i].Number || e.Season != want[i].Season || e.Image != want[i].Image {
+			t.Error(e.Title, "- Want:", want[i].Number, want[i].Season, want[i].Image, "Have:", e.Number, e.Season, e.Image)
+		}
+	}
+
+	for _, bad := range []FieldMap{{"bogus": "abc:ep"}, {"number": ""}, {"number": "abc:ep[1]"}} {
+		if err := bad.validate(); err == nil {
+			t.Error("Invalid field map was accepted:", bad)
+		}
+	}
+}
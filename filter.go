@@ -0,0 +1,543 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Filter is a parsed expression for selecting episodes, such as `number > 100 && title contains "Live"`. Each
+// comparison has a field name on the left and a quoted string or a number on the right, and comparisons can be combined
+// with && and ||, negated with !, and grouped with parentheses. Numbers are compared as numbers, strings that are dates
+// (e.g. "2024-01-01") are compared as dates, and other strings are compared as text.
+type Filter struct {
+	source string
+	root   filterNode
+}
+
+// FilterFields looks up the value of a field of the episode being matched, reporting whether or not the field exists.
+type FilterFields func(name string) (string, bool)
+
+// filterNode is a part of a parsed filter.
+type filterNode interface {
+	match(fields FilterFields) (bool, error)
+}
+
+// filterLogic combines two parts of a filter with && or ||.
+type filterLogic struct {
+	op          string
+	left, right filterNode
+}
+
+// filterNot negates part of a filter.
+type filterNot struct {
+	node filterNode
+}
+
+// filterCompare compares a field to a value.
+type filterCompare struct {
+	field  string
+	op     string
+	value  string
+	number bool // whether or not the value was given as a number
+}
+
+// FilterValues are the fields of an episode that the -filter option can use, gathered from the feed, the state, or the
+// file's tags, depending on the command.
+type FilterValues struct {
+	Show     string
+	Title    string
+	Season   string
+	Number   string
+	GUID     string
+	Path     string    // location of the file, for episodes that have been downloaded
+	Date     time.Time // publish date
+	Duration int       // in seconds
+	Size     int       // in bytes
+}
+
+// ParseFilter parses the filter expression. An empty expression matches everything.
+func ParseFilter(expr string) (*Filter, error) {
+	tokens, err := lexFilter(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter: %v", err)
+	}
+
+	f := &Filter{source: expr}
+	if len(tokens) == 0 {
+		return f, nil
+	}
+
+	p := &filterParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected %v", p.tokens[p.pos].text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter: %v", err)
+	}
+	f.root = root
+
+	return f, nil
+}
+
+// String returns the filter as it was given.
+func (f *Filter) String() string {
+	if f == nil {
+		return ""
+	}
+
+	return f.source
+}
+
+// Match reports whether or not the episode with the fields matches the filter. A nil filter matches everything.
+func (f *Filter) Match(fields FilterFields) (bool, error) {
+	if f == nil || f.root == nil {
+		return true, nil
+	}
+
+	return f.root.match(fields)
+}
+
+// Check makes sure that every field the filter uses exists, so that a typo is reported once up front instead of for
+// every episode.
+func (f *Filter) Check(fields FilterFields) error {
+	if f == nil {
+		return nil
+	}
+
+	var err error
+	var walk func(n filterNode)
+	walk = func(n filterNode) {
+		switch n := n.(type) {
+		case filterLogic:
+			walk(n.left)
+			walk(n.right)
+		case filterNot:
+			walk(n.node)
+		case filterCompare:
+			if _, ok := fields(n.field); !ok && err == nil {
+				err = fmt.Errorf("unknown field in filter: %v", n.field)
+			}
+		}
+	}
+	walk(f.root)
+
+	return err
+}
+
+// ParseEpisodeFilter parses the expression for the -filter option, whose fields are those of FilterValues.
+func ParseEpisodeFilter(expr string) (*Filter, error) {
+	f, err := ParseFilter(expr)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Check(FilterValues{}.Field); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Selects reports whether or not the episode with the values matches the filter. A nil filter selects everything.
+func (f *Filter) Selects(v FilterValues) bool {
+	ok, err := f.Match(v.Field)
+	if err != nil {
+		Debug("Error matching filter:", err)
+		return false
+	}
+
+	return ok
+}
+
+// Field returns the value of the named field: show, title, season, number, guid, path, date (as YYYY-MM-DD), year,
+// duration (in seconds), or size (in bytes).
+func (v FilterValues) Field(name string) (string, bool) {
+	switch strings.ToLower(name) {
+	case "show":
+		return v.Show, true
+	case "title":
+		return v.Title, true
+	case "season":
+		return v.Season, true
+	case "number":
+		return v.Number, true
+	case "guid":
+		return v.GUID, true
+	case "path":
+		return v.Path, true
+	case "date":
+		if v.Date.IsZero() {
+			return "", true
+		}
+		return v.Date.Format("2006-01-02"), true
+	case "year":
+		if v.Date.IsZero() {
+			return "", true
+		}
+		return strconv.Itoa(v.Date.Year()), true
+	case "duration":
+		return strconv.Itoa(v.Duration), true
+	case "size":
+		return strconv.Itoa(v.Size), true
+	}
+
+	return "", false
+}
+
+// filterValues gathers the episode's values from the feed, for the show with the title.
+func (e *Episode) filterValues(show string) FilterValues {
+	return FilterValues{
+		Show:     show,
+		Title:    e.Title,
+		Season:   e.Season,
+		Number:   e.Number,
+		GUID:     e.GUID,
+		Date:     parseDate(e.Date),
+		Duration: parseItunesDuration(e.Duration),
+		Size:     e.FeedSize(),
+	}
+}
+
+// filterValues gathers the downloaded episode's values from what was recorded about it, for the show with the title.
+func (es EpisodeState) filterValues(show string) FilterValues {
+	return FilterValues{
+		Show:     show,
+		Title:    es.Title,
+		Season:   es.Season,
+		Number:   es.Number,
+		GUID:     es.GUID,
+		Path:     es.Path,
+		Date:     es.Published,
+		Duration: parseItunesDuration(es.Duration),
+		Size:     es.Size,
+	}
+}
+
+// filterValues gathers the values of the library's episode. Dates from the tags can have a time after the day, which is
+// left off.
+func (e LibraryEntry) filterValues() FilterValues {
+	day := e.Date
+	if len(day) > 10 {
+		day = day[:10]
+	}
+	date, _ := parseFilterDate(day)
+	return FilterValues{
+		Show:     e.Show,
+		Title:    e.Title,
+		Season:   e.Season,
+		Number:   e.Number,
+		Path:     e.Path,
+		Date:     date,
+		Duration: parseItunesDuration(e.Duration),
+		Size:     e.Size,
+	}
+}
+
+// match evaluates the logical operator, short-circuiting like Go does.
+func (n filterLogic) match(fields FilterFields) (bool, error) {
+	left, err := n.left.match(fields)
+	if err != nil {
+		return false, err
+	}
+	if (n.op == "&&" && !left) || (n.op == "||" && left) {
+		return left, nil
+	}
+
+	return n.right.match(fields)
+}
+
+// match evaluates the negated part and flips it.
+func (n filterNot) match(fields FilterFields) (bool, error) {
+	ok, err := n.node.match(fields)
+	return !ok, err
+}
+
+// match compares the field's value. Values that can't be compared, like a field that isn't a number being compared to
+// one, are only matched by !=.
+func (n filterCompare) match(fields FilterFields) (bool, error) {
+	have, ok := fields(n.field)
+	if !ok {
+		return false, fmt.Errorf("unknown field in filter: %v", n.field)
+	}
+
+	if n.op == "contains" {
+		return strings.Contains(strings.ToLower(have), strings.ToLower(n.value)), nil
+	}
+
+	cmp, ok := compareFilterValues(have, n.value, n.number)
+	if !ok {
+		return n.op == "!=", nil
+	}
+
+	switch n.op {
+	case "==":
+		return cmp == 0, nil
+	case "!=":
+		return cmp != 0, nil
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	case ">=":
+		return cmp >= 0, nil
+	}
+
+	return false, fmt.Errorf("unknown operator in filter: %v", n.op)
+}
+
+// compareFilterValues compares the field's value to the filter's value, as numbers if the filter's value is a number,
+// as dates if it's a date, and as text otherwise. This returns -1, 0, or 1 like strings.Compare, and reports whether or
+// not the values could be compared.
+func compareFilterValues(have string, want string, number bool) (int, bool) {
+	if number {
+		h, err1 := strconv.ParseFloat(strings.TrimSpace(have), 64)
+		w, err2 := strconv.ParseFloat(want, 64)
+		if err1 != nil || err2 != nil {
+			return 0, false
+		}
+		switch {
+		case h < w:
+			return -1, true
+		case h > w:
+			return 1, true
+		}
+		return 0, true
+	}
+
+	if w, ok := parseFilterDate(want); ok {
+		h, ok := parseFilterDate(have)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case h.Before(w):
+			return -1, true
+		case h.After(w):
+			return 1, true
+		}
+		return 0, true
+	}
+
+	return strings.Compare(have, want), true
+}
+
+// parseFilterDate parses a date in a filter or a field, either as a day (YYYY-MM-DD) or as an RFC 3339 timestamp.
+func parseFilterDate(value string) (time.Time, bool) {
+	for _, layout := range []string{"2006-01-02", time.RFC3339} {
+		if ts, err := time.Parse(layout, strings.TrimSpace(value)); err == nil {
+			return ts, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// These are the kinds of tokens in a filter.
+const (
+	tokenField = iota
+	tokenString
+	tokenNumber
+	tokenOp
+)
+
+// filterToken is a token in a filter.
+type filterToken struct {
+	kind int
+	text string // the field name, operator, or number, or the string's value without the quotes
+}
+
+// filterOps are the operators that a filter can use, longest first so that they're matched greedily.
+var filterOps = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")"}
+
+// lexFilter splits the filter into tokens.
+func lexFilter(expr string) ([]filterToken, error) {
+	var tokens []filterToken
+	for i := 0; i < len(expr); {
+		c := rune(expr[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+
+		case c == '"':
+			// Find the closing quote, skipping over escaped ones.
+			end := i + 1
+			for end < len(expr) && expr[end] != '"' {
+				if expr[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(expr) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			value, err := strconv.Unquote(expr[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %v", expr[i:end+1])
+			}
+			tokens = append(tokens, filterToken{tokenString, value})
+			i = end + 1
+
+		case c == '-' || unicode.IsDigit(c):
+			end := i + 1
+			for end < len(expr) && (unicode.IsDigit(rune(expr[end])) || expr[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, filterToken{tokenNumber, expr[i:end]})
+			i = end
+
+		case c == '_' || unicode.IsLetter(c):
+			end := i + 1
+			for end < len(expr) && (expr[end] == '_' || expr[end] == ':' || unicode.IsLetter(rune(expr[end])) || unicode.IsDigit(rune(expr[end]))) {
+				end++
+			}
+			tokens = append(tokens, filterToken{tokenField, expr[i:end]})
+			i = end
+
+		default:
+			found := false
+			for _, op := range filterOps {
+				if strings.HasPrefix(expr[i:], op) {
+					tokens = append(tokens, filterToken{tokenOp, op})
+					i += len(op)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("unexpected %q", c)
+			}
+		}
+	}
+
+	return tokens, nil
+}
+
+// filterParser parses a list of tokens into a filter, by recursive descent. || binds more loosely than &&.
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+// next returns the next token without consuming it, or an empty token at the end.
+func (p *filterParser) next() filterToken {
+	if p.pos >= len(p.tokens) {
+		return filterToken{kind: -1}
+	}
+
+	return p.tokens[p.pos]
+}
+
+// isOp reports whether or not the next token is the operator.
+func (p *filterParser) isOp(op string) bool {
+	t := p.next()
+	return t.kind == tokenOp && t.text == op
+}
+
+// parseOr parses a list of terms joined with ||.
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isOp("||") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = filterLogic{"||", left, right}
+	}
+
+	return left, nil
+}
+
+// parseAnd parses a list of terms joined with &&.
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isOp("&&") {
+		p.pos++
+		right, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		left = filterLogic{"&&", left, right}
+	}
+
+	return left, nil
+}
+
+// parseTerm parses a comparison, a parenthesized expression, or a negation of either.
+func (p *filterParser) parseTerm() (filterNode, error) {
+	if p.isOp("!") {
+		p.pos++
+		node, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		return filterNot{node}, nil
+	}
+
+	if p.isOp("(") {
+		p.pos++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.isOp(")") {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return node, nil
+	}
+
+	field := p.next()
+	if field.kind != tokenField {
+		return nil, fmt.Errorf("expected a field name, found %q", field.text)
+	}
+	p.pos++
+
+	op := p.next()
+	if !isFilterComparison(op) {
+		return nil, fmt.Errorf("expected a comparison after %v", field.text)
+	}
+	p.pos++
+
+	value := p.next()
+	if value.kind != tokenString && value.kind != tokenNumber {
+		return nil, fmt.Errorf("expected a string or number after %v %v", field.text, op.text)
+	}
+	if value.kind == tokenNumber {
+		if _, err := strconv.ParseFloat(value.text, 64); err != nil {
+			return nil, fmt.Errorf("invalid number %v", value.text)
+		}
+	}
+	p.pos++
+
+	return filterCompare{field: field.text, op: op.text, value: value.text, number: value.kind == tokenNumber}, nil
+}
+
+// isFilterComparison reports whether or not the token compares a field to a value. "contains" is lexed as a word, like
+// field names.
+func isFilterComparison(t filterToken) bool {
+	switch {
+	case t.kind == tokenField:
+		return t.text == "contains"
+	case t.kind == tokenOp:
+		switch t.text {
+		case "==", "!=", "<", "<=", ">", ">=":
+			return true
+		}
+	}
+
+	return false
+}
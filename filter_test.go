@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// Test that filters are parsed with the right precedence and matched against the fields.
+func TestFilter(t *testing.T) {
+	values := map[string]string{"show": "Old Name", "title": "Trailer", "number": "3"}
+	fields := func(name string) (string, bool) {
+		value, ok := values[name]
+		return value, ok
+	}
+
+	tests := map[string]bool{
+		``:                   true,
+		`show == "Old Name"`: true,
+		`show != "Old Name"`: false,
+		`show == "Old Name" && title != "Trailer"`:                false,
+		`title == "Other" || number == 3`:                         true,
+		`title == "Other" || show == "Old Name" && number == 4`:   false,
+		`(title == "Other" || show == "Old Name") && number == 3`: true,
+		`show == "Old \"Name\""`:                                  false,
+	}
+	for expr, want := range tests {
+		filter, err := ParseFilter(expr)
+		if err != nil {
+			t.Error(expr, "- Error parsing filter:", err)
+			continue
+		}
+		if have, err := filter.Match(fields); err != nil || have != want {
+			t.Error(expr, "- Want:", want, "Have:", have, err)
+		}
+	}
+
+	for _, bad := range []string{`show`, `show ==`, `show = "x"`, `"x" == show`, `show == "x`, `(show == "x"`, `show == "x")`} {
+		if _, err := ParseFilter(bad); err == nil {
+			t.Error("Invalid filter was parsed:", bad)
+		}
+	}
+
+	filter, _ := ParseFilter(`bogus == "x"`)
+	if _, err := filter.Match(fields); err == nil {
+		t.Error("Unknown field was matched")
+	}
+}
+
+// Test that numbers, dates, and text are each compared the right way, over the fields of an episode.
+func TestFilterComparisons(t *testing.T) {
+	values := FilterValues{
+		Show:     "Show",
+		Title:    "Live at the Fillmore",
+		Number:   "120",
+		Date:     time.Date(2024, 3, 5, 12, 0, 0, 0, time.UTC),
+		Duration: 3600,
+	}
+
+	tests := map[string]bool{
+		`number > 100`:                   true,
+		`number > 1000`:                  false,
+		`number >= 120 && number <= 120`: true,
+		`number < 99.5`:                  false,
+		`season > 1`:                     false, // not a number
+		`season != 1`:                    true,
+		`date > "2024-01-01"`:            true,
+		`date <= "2024-03-04"`:           false,
+		`date == "2024-03-05"`:           true,
+		`year == 2024`:                   true,
+		`duration >= 1800`:               true,
+		`title contains "live"`:          true,
+		`!(title contains "Trailer")`:    true,
+		`!title contains "Fillmore"`:     false,
+		`show < "Tuesday"`:               true,
+		`number > 100 && date > "2024-01-01" && title contains "Live"`: true,
+	}
+	for expr, want := range tests {
+		filter, err := ParseEpisodeFilter(expr)
+		if err != nil {
+			t.Error(expr, "- Error parsing filter:", err)
+			continue
+		}
+		if have := filter.Selects(values); have != want {
+			t.Error(expr, "- Want:", want, "Have:", have)
+		}
+	}
+
+	for _, bad := range []string{`bogus == 1`, `number > 1.2.3`, `title contains`, `!`, `number = 1`} {
+		if _, err := ParseEpisodeFilter(bad); err == nil {
+			t.Error("Invalid filter was parsed:", bad)
+		}
+	}
+}
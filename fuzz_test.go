@@ -0,0 +1,116 @@
+//go:build go1.18
+// +build go1.18
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math"
+	"testing"
+
+	"github.com/snhilde/getcast/internal/feedtest"
+)
+
+// The fuzz targets below run their seeds as part of the regular tests. To fuzz one, run e.g.
+// `go test -run XXX -fuzz FuzzMeta`, or `make fuzz`.
+
+// Test that any tag can be read, and that whatever we build from it can be read again without issues.
+func FuzzMeta(f *testing.F) {
+	for _, data := range feedtest.Corpus() {
+		f.Add(data)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		meta := NewMeta(data)
+		meta.NumFrames()
+		meta.Issues()
+		built := meta.Build()
+
+		// Whatever we build has to be readable again.
+		rebuilt := NewMeta(built)
+		rebuilt.NumFrames()
+		if issues := rebuilt.Issues(); issues.Total() > 0 {
+			t.Error("Built tag has issues - Want: none", "Have:", issues)
+		}
+	})
+}
+
+// Test that a tag written in pieces never buffers more than the maximum tag size.
+func FuzzMetaStream(f *testing.F) {
+	for _, data := range feedtest.Corpus() {
+		f.Add(data, uint8(7))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte, chunk uint8) {
+		// Feed the data in small pieces, like it comes off the network.
+		size := int(chunk) + 1
+		meta := NewMeta(nil)
+		for rest := data; len(rest) > 0; rest = rest[size:] {
+			if size > len(rest) {
+				size = len(rest)
+			}
+			_, err := meta.Write(rest[:size])
+			if err == io.EOF || (err != nil && errors.Is(err, errTagTooLarge)) {
+				break
+			} else if err != nil {
+				t.Fatal("Unexpected error:", err)
+			}
+		}
+		if meta.Len() > maxTagSize()+id3HeaderSize {
+			t.Error("Buffered too much - Want: at most", maxTagSize()+id3HeaderSize, "Have:", meta.Len())
+		}
+
+		meta = NewMeta(nil)
+		meta.ReadFrom(bytes.NewReader(data))
+		meta.NumFrames()
+	})
+}
+
+// Test that reading the frames of any tag doesn't panic.
+func FuzzReadTagFrames(f *testing.F) {
+	for _, data := range feedtest.Corpus() {
+		f.Add(data)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		readTagFrames(bytes.NewReader(data), func(string) bool { return true })
+	})
+}
+
+// Test that any length read is either -1 or fits in 32 bits.
+func FuzzReadLen(f *testing.F) {
+	f.Add([]byte{0x00, 0x00, 0x02, 0x01}, byte(4), true)
+	f.Add([]byte{0xFF, 0xFF, 0xFF, 0xFF}, byte(3), false)
+	f.Add([]byte{0x7F, 0x7F, 0x7F}, byte(2), false)
+
+	f.Fuzz(func(t *testing.T, data []byte, version byte, header bool) {
+		n := readLen(bytes.NewBuffer(data), version, header)
+		if n < -1 || n > math.MaxInt32 {
+			t.Error("Length out of range - Want: -1 to", math.MaxInt32, "Have:", n)
+		}
+	})
+}
+
+// Test that parsing any chapter frame doesn't panic.
+func FuzzParseChapterFrame(f *testing.F) {
+	chapter := []byte("ch0\x00\x00\x00\x00\x00\x00\x00\x03\xe8\xff\xff\xff\xff\xff\xff\xff\xffTIT2\x00\x00\x00\x06\x00\x00\x00Intro")
+	f.Add(chapter, byte(3))
+	f.Add(chapter, byte(4))
+
+	f.Fuzz(func(t *testing.T, value []byte, version byte) {
+		parseChapterFrame(value, version)
+	})
+}
+
+// Test that parsing any picture frame doesn't panic.
+func FuzzParsePicture(f *testing.F) {
+	f.Add("APIC", []byte("\x00image/png\x00\x03\x00\x89PNG"))
+	f.Add("APIC", []byte("\x01image/jpeg\x00\x03\xff\xfeA\x00\x00\x00\xff\xd8"))
+	f.Add("PIC", []byte("\x00PNG\x03\x00\x89PNG"))
+
+	f.Fuzz(func(t *testing.T, id string, value []byte) {
+		parsePicture(id, value)
+	})
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// GenreCategory is the genre setting that uses the show's iTunes category as the genre.
+const GenreCategory = "category"
+
+// DefaultGenre is the genre given to episodes when nothing else is configured.
+const DefaultGenre = "Podcast"
+
+// Category is an itunes:category element. Categories can be nested one level deep to give a subcategory.
+type Category struct {
+	Text          string     `xml:"text,attr"`
+	Subcategories []Category `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd category"`
+}
+
+// id3v1Genres is the list of genres from ID3v1 and the Winamp extensions to it. Each genre's number is its index in
+// the list. ID3v2.3 references these numbers in parentheses, e.g. "(186)" for "Podcast".
+var id3v1Genres = []string{
+	"Blues", "Classic Rock", "Country", "Dance", "Disco", "Funk", "Grunge", "Hip-Hop", "Jazz", "Metal",
+	"New Age", "Oldies", "Other", "Pop", "R&B", "Rap", "Reggae", "Rock", "Techno", "Industrial",
+	"Alternative", "Ska", "Death Metal", "Pranks", "Soundtrack", "Euro-Techno", "Ambient", "Trip-Hop", "Vocal", "Jazz+Funk",
+	"Fusion", "Trance", "Classical", "Instrumental", "Acid", "House", "Game", "Sound Clip", "Gospel", "Noise",
+	"AlternRock", "Bass", "Soul", "Punk", "Space", "Meditative", "Instrumental Pop", "Instrumental Rock", "Ethnic", "Gothic",
+	"Darkwave", "Techno-Industrial", "Electronic", "Pop-Folk", "Eurodance", "Dream", "Southern Rock", "Comedy", "Cult", "Gangsta",
+	"Top 40", "Christian Rap", "Pop/Funk", "Jungle", "Native American", "Cabaret", "New Wave", "Psychedelic", "Rave", "Showtunes",
+	"Trailer", "Lo-Fi", "Tribal", "Acid Punk", "Acid Jazz", "Polka", "Retro", "Musical", "Rock & Roll", "Hard Rock",
+	"Folk", "Folk-Rock", "National Folk", "Swing", "Fast Fusion", "Bebob", "Latin", "Revival", "Celtic", "Bluegrass",
+	"Avantgarde", "Gothic Rock", "Progressive Rock", "Psychedelic Rock", "Symphonic Rock", "Slow Rock", "Big Band", "Chorus", "Easy Listening", "Acoustic",
+	"Humour", "Speech", "Chanson", "Opera", "Chamber Music", "Sonata", "Symphony", "Booty Bass", "Primus", "Porn Groove",
+	"Satire", "Slow Jam", "Club", "Tango", "Samba", "Folklore", "Ballad", "Power Ballad", "Rhythmic Soul", "Freestyle",
+	"Duet", "Punk Rock", "Drum Solo", "A capella", "Euro-House", "Dance Hall", "Goa", "Drum & Bass", "Club-House", "Hardcore",
+	"Terror", "Indie", "BritPop", "Negerpunk", "Polsk Punk", "Beat", "Christian Gangsta Rap", "Heavy Metal", "Black Metal", "Crossover",
+	"Contemporary Christian", "Christian Rock", "Merengue", "Salsa", "Thrash Metal", "Anime", "JPop", "Synthpop", "Abstract", "Art Rock",
+	"Baroque", "Bhangra", "Big Beat", "Breakbeat", "Chillout", "Downtempo", "Dub", "EBM", "Eclectic", "Electro",
+	"Electroclash", "Emo", "Experimental", "Garage", "Global", "IDM", "Illbient", "Industro-Goth", "Jam Band", "Krautrock",
+	"Leftfield", "Lounge", "Math Rock", "New Romantic", "Nu-Breakz", "Post-Punk", "Post-Rock", "Psytrance", "Shoegaze", "Space Rock",
+	"Trop Rock", "World Music", "Neoclassical", "Audiobook", "Audio Theatre", "Neue Deutsche Welle", "Podcast", "Indie Rock", "G-Funk", "Dubstep",
+	"Garage Rock", "Psybient",
+}
+
+// ValidateGenre checks that the genre setting can be used.
+func ValidateGenre(genre string) error {
+	if strings.TrimSpace(genre) == "" {
+		return fmt.Errorf("genre cannot be empty")
+	}
+
+	return nil
+}
+
+// genre returns the genre for the show's episodes. A genre set in the show's subscription wins, then the global
+// setting. If the setting is "category", the show's first iTunes category is used, falling back to "Podcast" if the
+// feed doesn't have one.
+func (s *Show) genre() string {
+	genre := s.Genre
+	if genre == "" {
+		genre = Genre
+	}
+
+	if strings.EqualFold(genre, GenreCategory) {
+		genre = ""
+		for _, category := range s.Categories {
+			if text := strings.TrimSpace(category.Text); text != "" {
+				genre = text
+				break
+			}
+		}
+	}
+
+	if genre == "" {
+		return DefaultGenre
+	}
+
+	return genre
+}
+
+// formatGenre formats the genre for the TCON frame of this ID3 version. ID3v2.2 and ID3v2.3 reference ID3v1 genres by
+// number in parentheses, with a free-text refinement after it for any other genre. ID3v2.4 allows plain text.
+func formatGenre(genre string, version byte) string {
+	genre = strings.TrimSpace(genre)
+	if genre == "" || version >= 4 {
+		return genre
+	}
+
+	for i, name := range id3v1Genres {
+		if strings.EqualFold(name, genre) {
+			return fmt.Sprintf("(%d)", i)
+		}
+	}
+
+	// A refinement that starts with a parenthesis has to be escaped by doubling it.
+	if strings.HasPrefix(genre, "(") {
+		genre = "(" + genre
+	}
+
+	return genre
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+)
+
+// Test that a show's genre comes from its subscription, then the global setting, then its iTunes category, and falls
+// back to "Podcast".
+func TestShowGenre(t *testing.T) {
+	tmpGenre := Genre
+	defer func() { Genre = tmpGenre }()
+
+	categories := []Category{{Text: " "}, {Text: "Comedy", Subcategories: []Category{{Text: "Improv"}}}}
+	tests := []struct {
+		global string
+		show   Show
+		want   string
+	}{
+		{"", Show{}, DefaultGenre},
+		{"Speech", Show{}, "Speech"},
+		{"Speech", Show{Genre: "News"}, "News"},
+		{"category", Show{Categories: categories}, "Comedy"},
+		{"Speech", Show{Genre: "Category", Categories: categories}, "Comedy"},
+		{"category", Show{}, DefaultGenre},
+	}
+	for _, test := range tests {
+		Genre = test.global
+		if have := test.show.genre(); have != test.want {
+			t.Error("Genre for", test.global, test.show.Genre, "- Want:", test.want, "Have:", have)
+		}
+	}
+}
+
+// Test that ID3v1 genres are referenced by number before ID3v2.4, and that other genres are escaped as needed.
+func TestFormatGenre(t *testing.T) {
+	tests := []struct {
+		genre   string
+		version byte
+		want    string
+	}{
+		{"Podcast", 3, "(186)"},
+		{"podcast", 2, "(186)"},
+		{"Podcast", 4, "Podcast"},
+		{"True Crime", 3, "True Crime"},
+		{"(Live) Shows", 3, "((Live) Shows"},
+		{"(Live) Shows", 4, "(Live) Shows"},
+		{" ", 3, ""},
+	}
+	for _, test := range tests {
+		if have := formatGenre(test.genre, test.version); have != test.want {
+			t.Error(test.genre, "for version", test.version, "- Want:", test.want, "Have:", have)
+		}
+	}
+
+	if err := ValidateGenre(" "); err == nil {
+		t.Error("Empty genre was accepted")
+	}
+}
@@ -2,4 +2,7 @@ module github.com/snhilde/getcast
 
 go 1.15
 
-require golang.org/x/text v0.3.4
+require (
+	github.com/andybalholm/brotli v1.1.0
+	golang.org/x/text v0.3.4
+)
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"text/template"
+)
+
+// GroupingOptions holds the templates for the frames that players use to group and sort episodes. A nil template
+// leaves its frame alone.
+type GroupingOptions struct {
+	Group       *template.Template // content group (TIT1)
+	Compilation bool               // mark episodes as part of a compilation (TCMP)
+	SortAlbum   *template.Template // album sort order (TSOA)
+	SortArtist  *template.Template // artist sort order (TSOP)
+}
+
+// groupingFrames renders the grouping templates for this episode. Any template that fails to render is logged and left
+// empty.
+func (e *Episode) groupingFrames() (group string, compilation string, sortAlbum string, sortArtist string) {
+	data := e.templateData()
+	render := func(tmpl *template.Template) string {
+		value, err := renderTemplate(tmpl, data)
+		if err != nil {
+			Log("Error rendering", tmpl.Name(), "template:", err)
+		}
+		return value
+	}
+
+	group = render(Grouping.Group)
+	sortAlbum = render(Grouping.SortAlbum)
+	sortArtist = render(Grouping.SortArtist)
+	if Grouping.Compilation {
+		compilation = "1"
+	}
+
+	return group, compilation, sortAlbum, sortArtist
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+)
+
+// Test that the grouping and sort frames are rendered from their templates, and left empty without them.
+func TestGroupingFrames(t *testing.T) {
+	tmpGrouping := Grouping
+	defer func() { Grouping = tmpGrouping }()
+
+	e := Episode{Title: "Pilot", Season: "2", Number: "1", Date: "Mon, 02 Mar 2020 10:00:00 +0000"}
+	e.SetShowTitle("The Show")
+	e.SetShowArtist("The Host")
+
+	Grouping = GroupingOptions{}
+	if group, compilation, sortAlbum, sortArtist := e.groupingFrames(); group+compilation+sortAlbum+sortArtist != "" {
+		t.Error("Frames without templates - Want: none Have:", group, compilation, sortAlbum, sortArtist)
+	}
+
+	var err error
+	if Grouping.Group, err = ParseTemplate("group", "Podcasts {{.Year}}"); err != nil {
+		t.Fatal(err)
+	}
+	if Grouping.SortAlbum, err = ParseTemplate("sort-album", "{{.Show}} S{{.Season}}"); err != nil {
+		t.Fatal(err)
+	}
+	if Grouping.SortArtist, err = ParseTemplate("sort-artist", "{{.Artist}}"); err != nil {
+		t.Fatal(err)
+	}
+	Grouping.Compilation = true
+
+	group, compilation, sortAlbum, sortArtist := e.groupingFrames()
+	if group != "Podcasts 2020" {
+		t.Error("Group - Want: Podcasts 2020 Have:", group)
+	}
+	if compilation != "1" {
+		t.Error("Compilation - Want: 1 Have:", compilation)
+	}
+	if sortAlbum != "The Show S2" {
+		t.Error("Album sort - Want: The Show S2 Have:", sortAlbum)
+	}
+	if sortArtist != "The Host" {
+		t.Error("Artist sort - Want: The Host Have:", sortArtist)
+	}
+}
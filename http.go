@@ -0,0 +1,303 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// client is the HTTP client used for all requests.
+var client = &http.Client{Transport: newTransport(), CheckRedirect: checkRedirect}
+
+// These control how we handle servers that tell us to slow down.
+const (
+	maxRateLimitRetries = 3               // number of times to retry a rate-limited request
+	maxRetryAfter       = 5 * time.Minute // longest we'll wait for a rate-limited host before giving up
+	defaultRetryAfter   = 30 * time.Second
+)
+
+// hosts keeps track of the requests to each host so that we stay polite to CDNs serving many of our shows.
+var hosts = hostLimiter{}
+
+// hostLimiter caps the number of simultaneous requests to each host and holds off on requests to hosts that have told
+// us to back off.
+type hostLimiter struct {
+	mutex  sync.Mutex
+	slots  map[string]chan struct{} // one buffered channel per host, with a capacity of HostConcurrency
+	limits map[string]int           // lowest capacity that the shows using each host have asked for
+	until  map[string]time.Time     // time at which a rate-limited host can be contacted again
+}
+
+// acquire waits for an open request slot for the host and returns the host's slots, for releasing it.
+func (h *hostLimiter) acquire(host string) chan struct{} {
+	h.mutex.Lock()
+	if h.slots == nil {
+		h.slots = make(map[string]chan struct{})
+	}
+	slot, ok := h.slots[host]
+	if !ok {
+		n, ok := h.limits[host]
+		if !ok {
+			n = HostConcurrency
+		}
+		if n <= 0 {
+			n = 1
+		}
+		slot = make(chan struct{}, n)
+		h.slots[host] = slot
+	}
+	h.mutex.Unlock()
+
+	slot <- struct{}{}
+	return slot
+}
+
+// limit sets the maximum number of simultaneous requests to the link's host. Shows that share a host (such as a CDN)
+// share its limit, so the lowest limit that any of them asks for is kept. The new limit takes effect once the host
+// doesn't have any requests in progress.
+func (h *hostLimiter) limit(link string, n int) {
+	u, err := url.Parse(link)
+	if err != nil || u.Host == "" || n <= 0 {
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.limits == nil {
+		h.limits = make(map[string]int)
+	}
+	if have, ok := h.limits[u.Host]; ok && have <= n {
+		return
+	}
+	h.limits[u.Host] = n
+	if slot, ok := h.slots[u.Host]; ok && cap(slot) != n && len(slot) == 0 {
+		delete(h.slots, u.Host)
+	}
+}
+
+// release frees up a request slot in the host's slots. This uses the slots that the request was acquired from, in case
+// the host's limit has changed since.
+func (h *hostLimiter) release(slot chan struct{}) {
+	<-slot
+}
+
+// wait blocks until the host is no longer rate-limited.
+func (h *hostLimiter) wait(host string) {
+	h.mutex.Lock()
+	until := h.until[host]
+	h.mutex.Unlock()
+
+	if d := time.Until(until); d > 0 {
+		Log("Waiting", d.Round(time.Second), "for", host, "to accept requests again")
+		select {
+		case <-time.After(d):
+		case <-stopCtx.Done():
+		}
+	}
+}
+
+// backoff marks the host as rate-limited for the duration.
+func (h *hostLimiter) backoff(host string, d time.Duration) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.until == nil {
+		h.until = make(map[string]time.Time)
+	}
+	h.until[host] = time.Now().Add(d)
+}
+
+// releaseBody releases the host's request slot when the response body is closed.
+type releaseBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+// Close closes the response body and releases the request slot.
+func (r *releaseBody) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.release)
+	return err
+}
+
+// httpGet requests the resource at the URL, adding the credentials (if any) to the request. Requests are limited per
+// host, and if the host responds with 429 Too Many Requests or 503 Service Unavailable, the request is retried after
+// the delay given in Retry-After.
+func httpGet(u string, creds *Credentials) (*http.Response, error) {
+	return get(u, creds, true, nil)
+}
+
+// httpGetSmall is the same as httpGet except that it doesn't take up one of the host's request slots. This is used for
+// small resources (like artwork) that are requested while a download from the same host is already in progress, which
+// would otherwise deadlock with a limit of 1. Any extra headers (such as for conditional requests) are added to the
+// request.
+func httpGetSmall(u string, creds *Credentials, header http.Header) (*http.Response, error) {
+	return get(u, creds, false, header)
+}
+
+// get performs the request for httpGet and httpGetSmall.
+func get(u string, creds *Credentials, limit bool, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(stopCtx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+
+	// Expired tokens would only get us turned away.
+	if creds.refreshable(req) && creds.expired() {
+		if err := creds.Refresh(creds.token()); err != nil {
+			Log(err)
+		}
+	}
+	creds.apply(req)
+
+	host := req.URL.Host
+	if err := breaker.allow(host); err != nil {
+		return nil, err
+	}
+
+	refreshed := false
+	release := func() {}
+	if limit {
+		slot := hosts.acquire(host)
+		release = func() { hosts.release(slot) }
+	}
+
+	for attempt := 0; ; attempt++ {
+		hosts.wait(host)
+
+		resp, err := client.Do(req)
+		breaker.record(host, resp, err)
+		if err != nil {
+			release()
+			return nil, err
+		}
+
+		// The token might have been revoked or expired early, so we'll get a new one and try again.
+		if resp.StatusCode == http.StatusUnauthorized && !refreshed && creds.refreshable(req) {
+			refreshed = true
+			sent := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+			if err := creds.Refresh(sent); err != nil {
+				Log(err)
+			} else {
+				resp.Body.Close()
+				creds.apply(req)
+				continue
+			}
+		}
+
+		if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+			resp.Body = &releaseBody{ReadCloser: resp.Body, release: release}
+			return resp, nil
+		}
+
+		delay, ok := retryAfter(resp.Header.Get("Retry-After"))
+		if !ok {
+			if resp.StatusCode == http.StatusServiceUnavailable {
+				// Without Retry-After, a 503 is more likely an outage than a request to slow down.
+				resp.Body = &releaseBody{ReadCloser: resp.Body, release: release}
+				return resp, nil
+			}
+			delay = defaultRetryAfter
+		}
+		hosts.backoff(host, delay)
+
+		if attempt >= maxRateLimitRetries || delay > maxRetryAfter {
+			Debug("Giving up on", host, "after", attempt+1, "rate-limited attempts")
+			resp.Body = &releaseBody{ReadCloser: resp.Body, release: release}
+			return resp, nil
+		}
+
+		// If the host has failed too many times by now, there's no point in waiting on it.
+		if err := breaker.allow(host); err != nil {
+			resp.Body.Close()
+			release()
+			return nil, err
+		}
+
+		Log(host, "responded with", resp.Status+", retrying in", delay)
+		resp.Body.Close()
+	}
+}
+
+// retryAfter parses the value of a Retry-After header, which is either a number of seconds or an HTTP date.
+func retryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if t, err := http.ParseTime(value); err == nil {
+		d := time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+
+	return 0, false
+}
+
+// errTooLarge is returned when a response is larger than the limit for its kind, like -max-feed-size.
+var errTooLarge = errors.New("response is too large")
+
+// limitBody checks the response's declared length against the limit and returns its body, which fails with errTooLarge
+// once more than the limit has been read. Checking what's read and not just the declared length catches servers that
+// don't declare one, or that send more than they declare (like a compressed response that expands far beyond its
+// size). A limit of 0 means no limit.
+func limitBody(resp *http.Response, limit int) (io.Reader, error) {
+	if limit <= 0 {
+		return resp.Body, nil
+	}
+	if resp.ContentLength > int64(limit) {
+		return nil, fmt.Errorf("%w: %v, limit is %v", errTooLarge, Reduce(int(resp.ContentLength)), Reduce(limit))
+	}
+
+	return &limitedReader{r: resp.Body, remaining: int64(limit), limit: limit}, nil
+}
+
+// limitedReader reads up to the limit from the reader and then returns errTooLarge if there's anything more.
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+	limit     int
+}
+
+// Read reads from the underlying reader, up to the limit.
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if l.remaining <= 0 {
+		// A response of exactly the limit is fine, so we only fail if there really is more.
+		var b [1]byte
+		n, err := l.r.Read(b[:])
+		if n > 0 {
+			return 0, fmt.Errorf("%w: more than the limit of %v", errTooLarge, Reduce(l.limit))
+		}
+		return 0, err
+	}
+
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
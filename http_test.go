@@ -0,0 +1,496 @@
+package main
+
+import (
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Test that rate-limited requests are retried after the delay the server asks for.
+func TestRateLimitRetry(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	HostConcurrency = 1
+	for i := 0; i < 2; i++ {
+		// With a limit of 1, the second request would hang if the first didn't release its slot.
+		requests = 0
+		resp, err := httpGet(server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK || string(data) != "ok" {
+			t.Error("Want: 200 ok Have:", resp.StatusCode, string(data))
+		}
+		if requests != 3 {
+			t.Error("Want: 3 requests Have:", requests)
+		}
+	}
+}
+
+// Test that both forms of Retry-After are understood.
+func TestRetryAfter(t *testing.T) {
+	if d, ok := retryAfter("120"); !ok || d.Seconds() != 120 {
+		t.Error("Failed to parse seconds - Have:", d, ok)
+	}
+	if _, ok := retryAfter("Wed, 21 Oct 2015 07:28:00 GMT"); !ok {
+		t.Error("Failed to parse HTTP date")
+	}
+	if _, ok := retryAfter("soon"); ok {
+		t.Error("Parsed invalid value")
+	}
+}
+
+// Test that shows sharing a host get the lowest of their request limits, whichever order they ask in.
+func TestHostLimit(t *testing.T) {
+	var h hostLimiter
+	h.limit("https://cdn.example.com/a.mp3", 4)
+	h.limit("https://cdn.example.com/b.mp3", 1)
+	h.limit("https://cdn.example.com/c.mp3", 3)
+	h.limit("https://other.example.com/d.mp3", 3)
+
+	if slot := h.acquire("cdn.example.com"); cap(slot) != 1 {
+		t.Error("Incorrect limit for shared host - Want: 1 Have:", cap(slot))
+	} else {
+		h.release(slot)
+	}
+	if slot := h.acquire("other.example.com"); cap(slot) != 3 {
+		t.Error("Incorrect limit for other host - Want: 3 Have:", cap(slot))
+	} else {
+		h.release(slot)
+	}
+}
+
+// Test that hosts given with -resolve are connected to at the fixed address.
+func TestResolveOverride(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Host))
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	Resolve = nil
+	if err := Resolve.Set("podcast.invalid=" + u.Hostname()); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { Resolve = nil }()
+
+	resp, err := httpGet("http://podcast.invalid:"+u.Port(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if want := "podcast.invalid:" + u.Port(); string(data) != want {
+		t.Error("Want:", want, "Have:", string(data))
+	}
+
+	if err := Resolve.Set("podcast.invalid=not-an-address"); err == nil {
+		t.Error("Want: error Have: nil")
+	}
+}
+
+// Test that servers with certificates from an extra CA are trusted once the CA is given with -ca-cert.
+func TestCACert(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	saved := client
+	defer func() { client = saved }()
+	client = &http.Client{Transport: newTransport()}
+
+	if resp, err := httpGet(server.URL, nil); err == nil {
+		resp.Body.Close()
+		t.Error("Untrusted - Want: error Have: nil")
+	}
+
+	file, err := ioutil.TempFile("", "ca-*.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	pem.Encode(file, &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	file.Close()
+
+	if err := ConfigureTLS(TLSOptions{CACert: file.Name()}); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := httpGet(server.URL, nil)
+	if err != nil {
+		t.Fatal("Trusted - Want: nil Have:", err)
+	}
+	resp.Body.Close()
+}
+
+// Test that expired tokens are refreshed through the OAuth token endpoint and saved for later.
+func TestTokenRefresh(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("XDG_DATA_HOME", dir)
+	defer os.Unsetenv("XDG_DATA_HOME")
+
+	issued := 0
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("grant_type") != "refresh_token" || r.FormValue("refresh_token") == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		issued++
+		w.Write([]byte(`{"access_token": "fresh", "refresh_token": "rotated", "expires_in": 3600}`))
+	}))
+	defer tokens.Close()
+
+	feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer fresh" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer feed.Close()
+
+	sub := Subscription{URL: feed.URL, Token: "stale", Refresh: &TokenRefresh{TokenURL: tokens.URL, RefreshToken: "first"}}
+	creds, err := sub.Credentials()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		resp, err := httpGet(feed.URL, creds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Error("Request", i+1, "- Want: 200 Have:", resp.StatusCode)
+		}
+	}
+	if issued != 1 {
+		t.Error("Want: 1 token issued Have:", issued)
+	}
+
+	// The next run picks up the saved token and the rotated refresh token.
+	token, ok := loadToken(feed.URL)
+	if !ok || token.AccessToken != "fresh" || token.RefreshToken != "rotated" {
+		t.Error("Want: fresh rotated Have:", token.AccessToken, token.RefreshToken)
+	}
+}
+
+// Test that feeds on one host that share a rotating refresh token don't use it twice, even when their requests are
+// turned away at the same time, and that the rotated token is kept for the next run.
+func TestTokenRefreshShared(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("XDG_DATA_HOME", dir)
+	defer os.Unsetenv("XDG_DATA_HOME")
+
+	// Each refresh token works once.
+	var mutex sync.Mutex
+	valid, access, issued := "first", make(map[string]bool), 0
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if r.FormValue("refresh_token") != valid {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		issued++
+		valid = fmt.Sprint("refresh-", issued)
+		access[fmt.Sprint("access-", issued)] = true
+		fmt.Fprintf(w, `{"access_token": "access-%v", "refresh_token": %q, "expires_in": 3600}`, issued, valid)
+	}))
+	defer tokens.Close()
+
+	feed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if !access[strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")] {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer feed.Close()
+
+	var wg sync.WaitGroup
+	statuses := make(chan int, 10)
+	for _, path := range []string{"/one.xml", "/two.xml"} {
+		sub := Subscription{URL: feed.URL + path, Refresh: &TokenRefresh{TokenURL: tokens.URL, RefreshToken: "first"}}
+		creds, err := sub.Credentials()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func(u string) {
+				defer wg.Done()
+				resp, err := httpGet(u, creds)
+				if err != nil {
+					statuses <- 0
+					return
+				}
+				resp.Body.Close()
+				statuses <- resp.StatusCode
+			}(sub.URL)
+		}
+	}
+	wg.Wait()
+	close(statuses)
+
+	for status := range statuses {
+		if status != http.StatusOK {
+			t.Error("Want: 200 Have:", status)
+		}
+	}
+
+	// Each feed waits for the other's refresh, and then only its first request gets a new token.
+	mutex.Lock()
+	latest := valid
+	if issued != 2 {
+		t.Error("Incorrect number of tokens issued - Want: 2 Have:", issued)
+	}
+	mutex.Unlock()
+
+	// The next run starts from the latest refresh token, not the one in the config.
+	if token, ok := readToken(refreshTokenPath("first")); !ok || token.RefreshToken != latest {
+		t.Error("Incorrect saved refresh token - Want:", latest, "Have:", token.RefreshToken)
+	}
+}
+
+// Test that a host that keeps failing is skipped once it fails too many times in a row.
+func TestCircuitBreaker(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	CircuitThreshold = 3
+	CircuitCooldown = time.Minute
+	defer func() { CircuitThreshold = 0 }()
+
+	for i := 0; i < 5; i++ {
+		resp, err := httpGetSmall(server.URL, nil, nil)
+		if err == nil {
+			resp.Body.Close()
+		} else if !errors.Is(err, ErrCircuitOpen) {
+			t.Fatal(err)
+		}
+	}
+
+	if requests != 3 {
+		t.Error("Requests - Want:", 3, "Have:", requests)
+	}
+}
+
+// Test that only one request tests a host after its cooldown, and that its outcome decides whether the circuit closes.
+func TestCircuitProbe(t *testing.T) {
+	CircuitThreshold = 2
+	CircuitCooldown = time.Minute
+	defer func() { CircuitThreshold = 0 }()
+
+	var b circuitBreaker
+	for i := 0; i < 2; i++ {
+		b.record("example.com", &http.Response{StatusCode: http.StatusBadGateway}, nil)
+	}
+	if err := b.allow("example.com"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatal("Circuit didn't open:", err)
+	}
+
+	for _, ok := range []bool{false, true} {
+		b.open["example.com"] = time.Now().Add(-time.Second)
+		if err := b.allow("example.com"); err != nil {
+			t.Fatal("Test request wasn't allowed:", err)
+		}
+		if err := b.allow("example.com"); !errors.Is(err, ErrCircuitOpen) {
+			t.Error("Allowed a second request while testing the host:", err)
+		}
+
+		status := http.StatusBadGateway
+		if ok {
+			status = http.StatusOK
+		}
+		b.record("example.com", &http.Response{StatusCode: status}, nil)
+		if err := b.allow("example.com"); ok != (err == nil) {
+			t.Error("Incorrect circuit after test request - Want closed:", ok, "Have:", err)
+		}
+	}
+}
+
+// Test that a request that opens the circuit while it's being retried fails instead of returning the server error.
+func TestCircuitOpensOnRetry(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	CircuitThreshold = 2
+	CircuitCooldown = time.Minute
+	defer func() { CircuitThreshold = 0 }()
+
+	resp, err := httpGetSmall(server.URL, nil, nil)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("Returned the response from a host whose circuit opened:", resp.Status)
+	}
+	if !errors.Is(err, ErrCircuitOpen) {
+		t.Error("Incorrect error - Want:", ErrCircuitOpen, "Have:", err)
+	}
+}
+
+// Test that bodies over the limit are rejected whether or not the server sends a Content-Length.
+func TestLimitBody(t *testing.T) {
+	body := strings.Repeat("x", 1000)
+	chunked := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if chunked {
+			// Without a Content-Length, only reading the body can tell how big it is.
+			w.(http.Flusher).Flush()
+		}
+		io.WriteString(w, body)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		limit   int
+		chunked bool
+		fail    bool
+	}{
+		{0, false, false},
+		{1000, false, false},
+		{999, false, true},
+		{1000, true, false},
+		{999, true, true},
+	}
+	for _, test := range tests {
+		chunked = test.chunked
+		resp, err := httpGet(server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := limitBody(resp, test.limit)
+		var read []byte
+		if err == nil {
+			read, err = ioutil.ReadAll(data)
+		}
+		resp.Body.Close()
+
+		if test.fail && !errors.Is(err, errTooLarge) {
+			t.Error("Limit", test.limit, "(chunked:", test.chunked, ") - Want:", errTooLarge, "Have:", err)
+		} else if !test.fail && (err != nil || string(read) != body) {
+			t.Error("Limit", test.limit, "(chunked:", test.chunked, ") - Want: full body Have:", len(read), "bytes,", err)
+		}
+	}
+}
+
+// Test that a download stops once it grows past -max-episode-size, even if the server didn't say how big it is.
+func TestMaxEpisodeSize(t *testing.T) {
+	content := append([]byte{0xff, 0xfb, 0x90, 0x64}, make([]byte, 100000)...)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.(http.Flusher).Flush()
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "getcast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	MaxEpisodeSize = 50000
+	defer func() { MaxEpisodeSize = 0 }()
+
+	episode := Episode{Title: "Episode", Enclosure: Enclosure{URL: server.URL + "/episode.mp3", Type: "audio/mpeg"}}
+	episode.settings = &ShowSettings{Artwork: ArtworkNone}
+	if err := episode.Download(dir); !errors.Is(err, errTooLarge) {
+		t.Error("Incorrect error - Want:", errTooLarge, "Have:", err)
+	}
+}
+
+// Test that images are kept in the HTTP cache and only downloaded again once they've changed, and that feeds are always
+// checked with the server even when the cached copy is still fresh.
+func TestFetchCached(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("XDG_CACHE_HOME", dir)
+	defer os.Unsetenv("XDG_CACHE_HOME")
+
+	var full, notModified int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if r.URL.Path == "/feed.xml" {
+			w.Header().Set("Cache-Control", "max-age=3600")
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full++
+		w.Write([]byte("data for " + r.URL.Path))
+	}))
+	defer server.Close()
+
+	for i := 0; i < 2; i++ {
+		if data := fetchImage(server.URL+"/image.jpg", nil); string(data) != "data for /image.jpg" {
+			t.Error("Image", i+1, "- Incorrect data - Want: data for /image.jpg Have:", string(data))
+		}
+	}
+	if full != 1 || notModified != 1 {
+		t.Error("Incorrect image requests - Want: 1 full, 1 not modified Have:", full, notModified)
+	}
+
+	full, notModified = 0, 0
+	for i := 0; i < 2; i++ {
+		data, err := fetchCached(fetchRequest{link: server.URL + "/feed.xml", compressed: true, revalidate: true})
+		if err != nil || string(data) != "data for /feed.xml" {
+			t.Error("Feed", i+1, "- Incorrect data - Want: data for /feed.xml Have:", string(data), err)
+		}
+	}
+	if full != 1 || notModified != 1 {
+		t.Error("Incorrect feed requests - Want: 1 full, 1 not modified Have:", full, notModified)
+	}
+
+	// Without revalidating, the fresh copy is used without asking the server at all.
+	if data, err := fetchCached(fetchRequest{link: server.URL + "/feed.xml"}); err != nil || full+notModified != 2 {
+		t.Error("Asked the server for a fresh copy:", string(data), err)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ignoreFile is the name of the file in a show's directory that lists the paths to leave out of scans.
+const ignoreFile = ".getcastignore"
+
+// IgnorePatterns is a list of glob patterns for paths to leave out of scans. It can be set on the command line once per
+// pattern.
+type IgnorePatterns []string
+
+// String returns the patterns as a comma-separated list.
+func (p *IgnorePatterns) String() string {
+	if p == nil {
+		return ""
+	}
+
+	return strings.Join(*p, ",")
+}
+
+// Set adds the patterns in the comma-separated list.
+func (p *IgnorePatterns) Set(value string) error {
+	for _, pattern := range strings.Split(value, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return err
+			}
+			*p = append(*p, pattern)
+		}
+	}
+
+	return nil
+}
+
+// scanRules decides which parts of a show's directory are scanned for episodes that we already have.
+type scanRules struct {
+	root     string   // show's directory
+	depth    int      // how many levels of subdirectories to scan, or -1 for all of them
+	patterns []string // glob patterns of paths to skip
+}
+
+// scanRules builds the scan rules for the show's directory from -scan-depth, -ignore, and the show's .getcastignore.
+func (s *Show) scanRules() scanRules {
+	rules := scanRules{root: s.Dir, depth: ScanDepth}
+	rules.patterns = append(rules.patterns, Ignore...)
+	rules.patterns = append(rules.patterns, readIgnoreFile(filepath.Join(s.Dir, ignoreFile))...)
+
+	return rules
+}
+
+// readIgnoreFile reads the glob patterns in the ignore file, one per line. Blank lines and lines starting with "#" are
+// skipped. A missing file has no patterns.
+func readIgnoreFile(path string) []string {
+	file, err := os.Open(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			Log("Error reading ignore file:", err)
+		}
+		return nil
+	}
+	defer file.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := filepath.Match(line, ""); err != nil {
+			Log("Skipping invalid pattern in", path, "-", line)
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+
+	return patterns
+}
+
+// skip checks whether the path should be left out of the scan. For directories, this returns filepath.SkipDir so that
+// the walk doesn't go into them. For files, this returns true.
+func (r scanRules) skip(path string, info os.FileInfo) (bool, error) {
+	if path == r.root {
+		return false, nil
+	}
+
+	rel, err := filepath.Rel(r.root, path)
+	if err != nil {
+		return false, nil
+	}
+	rel = filepath.ToSlash(rel)
+
+	if info.IsDir() && r.depth >= 0 && strings.Count(rel, "/")+1 > r.depth {
+		Debug("Skipping directory past the scan depth:", rel)
+		return true, filepath.SkipDir
+	}
+
+	for _, pattern := range r.patterns {
+		dirOnly := strings.HasSuffix(pattern, "/")
+		if dirOnly {
+			if !info.IsDir() {
+				continue
+			}
+			pattern = strings.TrimSuffix(pattern, "/")
+		}
+
+		matchRel, _ := filepath.Match(pattern, rel)
+		matchBase, _ := filepath.Match(pattern, info.Name())
+		if matchRel || matchBase {
+			Debug("Skipping ignored path:", rel)
+			if info.IsDir() {
+				return true, filepath.SkipDir
+			}
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// Test that scans leave out the directories past the scan depth and the paths matching -ignore or the show's
+// .getcastignore.
+func TestScanRules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast-ignore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{
+		"Episode.mp3",
+		"Draft.tmp",
+		"Season 1/Episode.mp3",
+		"Season 1/Notes/Episode.mp3",
+		"Bonus/Episode.mp3",
+		"Music/Song.mp3",
+		"Season 2/Music/Song.mp3",
+	} {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ignore := "# Not episodes\n\nMusic/\n[\n*.tmp\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, ignoreFile), []byte(ignore), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpDepth, tmpIgnore := ScanDepth, Ignore
+	defer func() { ScanDepth, Ignore = tmpDepth, tmpIgnore }()
+	ScanDepth, Ignore = 1, nil
+	if err := Ignore.Set("Bonus, "); err != nil {
+		t.Fatal(err)
+	}
+	if err := Ignore.Set("["); err == nil {
+		t.Error("Invalid pattern was accepted")
+	}
+
+	show := &Show{Dir: dir}
+	rules := show.scanRules()
+	var scanned []string
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if skip, err := rules.skip(path, info); skip {
+			return err
+		}
+		if !info.IsDir() && info.Name() != ignoreFile {
+			rel, _ := filepath.Rel(dir, path)
+			scanned = append(scanned, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(scanned)
+	want := []string{"Episode.mp3", "Season 1/Episode.mp3"}
+	if !reflect.DeepEqual(scanned, want) {
+		t.Error("Incorrect files scanned - Want:", want, "Have:", scanned)
+	}
+}
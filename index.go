@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// IndexName is the name of the catalog written to each show's directory with -index.
+const IndexName = "INDEX.md"
+
+// maxSummary is the most characters of an episode's show notes kept as its summary.
+const maxSummary = 200
+
+// summary returns the first paragraph of the episode's show notes as plain text on one line, shortened to maxSummary
+// characters.
+func (e *Episode) summary() string {
+	notes := htmlToMarkdown(e.Notes())
+	if i := strings.Index(notes, "\n\n"); i >= 0 {
+		notes = notes[:i]
+	}
+	notes = strings.Join(strings.Fields(notes), " ")
+
+	if utf8.RuneCountInString(notes) > maxSummary {
+		runes := []rune(notes)
+		notes = strings.TrimSpace(string(runes[:maxSummary])) + "…"
+	}
+
+	return notes
+}
+
+// formatDuration formats an iTunes duration, which is either a number of seconds or already in H:MM:SS or MM:SS form,
+// as H:MM:SS or M:SS.
+func formatDuration(duration string) string {
+	seconds, err := strconv.Atoi(strings.TrimSpace(duration))
+	if err != nil || seconds < 0 {
+		return strings.TrimSpace(duration)
+	}
+
+	if seconds >= 3600 {
+		return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+// WriteIndex regenerates the INDEX.md catalog in the show's directory: a table of every downloaded episode still on
+// disk, oldest first, with its publish date, duration, and a summary of its show notes.
+func WriteIndex(showDir string, title string) error {
+	showDir, err := filepath.Abs(showDir)
+	if err != nil {
+		return err
+	}
+
+	type indexEntry struct {
+		path string
+		es   EpisodeState
+	}
+	var entries []indexEntry
+	for path, es := range StateDB.ByPath() {
+		rel, err := filepath.Rel(showDir, path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		entries = append(entries, indexEntry{path: rel, es: es})
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i].es, entries[j].es
+		if !a.Published.Equal(b.Published) {
+			return a.Published.Before(b.Published)
+		}
+		return entries[i].path < entries[j].path
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", title)
+	fmt.Fprintf(&b, "%d episodes\n\n", len(entries))
+	b.WriteString("| Date | Episode | Duration | Description |\n")
+	b.WriteString("|------|---------|----------|-------------|\n")
+	for _, entry := range entries {
+		date := ""
+		if published := entry.es.Published; !published.IsZero() {
+			if DateZone != nil {
+				published = published.In(DateZone)
+			}
+			date = published.Format("2006-01-02")
+		}
+
+		// Links to the file need their spaces escaped to work in Markdown.
+		link := strings.ReplaceAll(filepath.ToSlash(entry.path), " ", "%20")
+		episode := fmt.Sprintf("[%s](%s)", markdownCell(entry.es.Title), link)
+		fmt.Fprintf(&b, "| %s | %s | %s | %s |\n", date, episode, formatDuration(entry.es.Duration),
+			markdownCell(entry.es.Summary))
+	}
+
+	path := filepath.Join(showDir, IndexName)
+	Debug("Writing", len(entries), "episodes to", path)
+	return ioutil.WriteFile(path, []byte(b.String()), 0644)
+}
+
+// markdownCell escapes the text for use in a Markdown table cell.
+func markdownCell(text string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(text, "|", `\|`), "\n", " ")
+}
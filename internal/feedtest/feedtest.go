@@ -0,0 +1,206 @@
+// Package feedtest generates synthetic podcast feeds and MP3 files for tests and benchmarks. The feeds and episodes can
+// be served over HTTP, so that a whole sync can be run without touching the network, and the tags can be given any
+// frames (valid or not) for exercising the metadata parser.
+package feedtest
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"math/rand"
+	"time"
+)
+
+// Frame is a frame of an ID3v2 tag. The value is written exactly as given, so text frames need their encoding byte.
+type Frame struct {
+	ID    string
+	Value []byte
+}
+
+// TextFrame creates a text frame with the value in UTF-8 (or ISO-8859-1 for ID3v2.2 and v2.3, which don't have UTF-8).
+func TextFrame(id string, value string) Frame {
+	return Frame{ID: id, Value: append([]byte{0x00}, value...)}
+}
+
+// Tag is an ID3v2 tag.
+type Tag struct {
+	Version byte // major version: 2, 3, or 4
+	Frames  []Frame
+	Padding int // number of zero bytes after the frames
+}
+
+// Bytes builds the tag, with its header.
+func (t Tag) Bytes() []byte {
+	var frames bytes.Buffer
+	for _, frame := range t.Frames {
+		frames.WriteString(frame.ID)
+		switch t.Version {
+		case 2:
+			frames.Write(encodeLen(len(frame.Value), 3, 8))
+		case 3:
+			frames.Write(encodeLen(len(frame.Value), 4, 8))
+			frames.Write([]byte{0x00, 0x00})
+		default:
+			frames.Write(encodeLen(len(frame.Value), 4, 7))
+			frames.Write([]byte{0x00, 0x00})
+		}
+		frames.Write(frame.Value)
+	}
+	frames.Write(make([]byte, t.Padding))
+
+	header := []byte{'I', 'D', '3', t.Version, 0x00, 0x00}
+	header = append(header, encodeLen(frames.Len(), 4, 7)...)
+
+	return append(header, frames.Bytes()...)
+}
+
+// encodeLen encodes the length big-endian in the number of bytes, using the number of bits of each byte (7 for synch-
+// safe lengths).
+func encodeLen(n int, size int, width uint) []byte {
+	b := make([]byte, size)
+	for i := size - 1; i >= 0; i-- {
+		b[i] = byte(n & (1<<width - 1))
+		n >>= width
+	}
+
+	return b
+}
+
+// mpegFrameHeader is the header of an MPEG-1 Layer III frame (128 kbps, 44.1 kHz), which is what players look for at the
+// start of the audio.
+var mpegFrameHeader = []byte{0xFF, 0xFB, 0x90, 0x64}
+
+// MP3 builds an MP3 file of the size with the tag at the start. The audio is silent, and the file is never smaller than
+// the tag plus one frame header.
+func MP3(tag Tag, size int) []byte {
+	data := append(tag.Bytes(), mpegFrameHeader...)
+	if len(data) < size {
+		data = append(data, make([]byte, size-len(data))...)
+	}
+
+	return data
+}
+
+// Episode is an episode of a synthetic feed.
+type Episode struct {
+	Title  string
+	GUID   string
+	Season int
+	Number int
+	Date   time.Time
+	Size   int // size of the MP3 file
+	Tag    Tag // tag of the MP3 file
+}
+
+// Feed is a synthetic podcast feed.
+type Feed struct {
+	Title    string
+	Author   string
+	Episodes []Episode
+}
+
+// Generate creates a feed with the number of episodes, each with an MP3 file of the size and a tag like publishers
+// usually write. The episodes are a week apart, newest first, and the feed is the same every time for the same
+// arguments.
+func Generate(title string, episodes int, size int) Feed {
+	feed := Feed{Title: title, Author: title + " Author"}
+	start := time.Date(2020, 1, 6, 12, 0, 0, 0, time.UTC)
+	for i := episodes; i >= 1; i-- {
+		episodeTitle := fmt.Sprintf("Episode %d", i)
+		feed.Episodes = append(feed.Episodes, Episode{
+			Title:  episodeTitle,
+			GUID:   fmt.Sprintf("%s-%d", title, i),
+			Season: (i-1)/10 + 1,
+			Number: i,
+			Date:   start.AddDate(0, 0, 7*(i-1)),
+			Size:   size,
+			Tag: Tag{
+				Version: 3,
+				Frames: []Frame{
+					TextFrame("TIT2", episodeTitle),
+					TextFrame("TALB", title),
+					TextFrame("TPE1", feed.Author),
+					TextFrame("TRCK", fmt.Sprint(i)),
+				},
+				Padding: 256,
+			},
+		})
+	}
+
+	return feed
+}
+
+// RSS builds the feed's RSS, with the enclosures at baseURL/<episode index>.mp3.
+func (f Feed) RSS(baseURL string) []byte {
+	var b bytes.Buffer
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	b.WriteString(`<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd"><channel>` + "\n")
+	fmt.Fprintf(&b, "<title>%s</title>\n<itunes:author>%s</itunes:author>\n", html.EscapeString(f.Title), html.EscapeString(f.Author))
+	for i, e := range f.Episodes {
+		b.WriteString("<item>\n")
+		fmt.Fprintf(&b, "\t<title>%s</title>\n", html.EscapeString(e.Title))
+		fmt.Fprintf(&b, "\t<guid>%s</guid>\n", html.EscapeString(e.GUID))
+		fmt.Fprintf(&b, "\t<pubDate>%s</pubDate>\n", e.Date.Format(time.RFC1123Z))
+		if e.Season > 0 {
+			fmt.Fprintf(&b, "\t<itunes:season>%d</itunes:season>\n", e.Season)
+		}
+		if e.Number > 0 {
+			fmt.Fprintf(&b, "\t<itunes:episode>%d</itunes:episode>\n", e.Number)
+		}
+		fmt.Fprintf(&b, "\t<enclosure url=\"%s/%d.mp3\" length=\"%d\" type=\"audio/mpeg\"/>\n", baseURL, i, e.Size)
+		b.WriteString("</item>\n")
+	}
+	b.WriteString("</channel></rss>\n")
+
+	return b.Bytes()
+}
+
+// Mutate returns a copy of the data with a few random bytes changed, inserted, or removed, for feeding malformed input
+// to a parser. The same seed always gives the same mutation.
+func Mutate(data []byte, seed int64) []byte {
+	r := rand.New(rand.NewSource(seed))
+	out := append([]byte(nil), data...)
+	for n := r.Intn(4) + 1; n > 0; n-- {
+		if len(out) == 0 {
+			out = append(out, byte(r.Intn(256)))
+			continue
+		}
+
+		i := r.Intn(len(out))
+		switch r.Intn(3) {
+		case 0:
+			out[i] = byte(r.Intn(256))
+		case 1:
+			out = append(out[:i], append([]byte{byte(r.Intn(256))}, out[i:]...)...)
+		default:
+			out = append(out[:i], out[i+1:]...)
+		}
+	}
+
+	return out
+}
+
+// Corpus returns a set of tags covering each ID3v2 version and the kinds of frames the metadata parser handles
+// specially, as starting points for fuzzing.
+func Corpus() [][]byte {
+	var corpus [][]byte
+	for _, version := range []byte{2, 3, 4} {
+		title, artist, comment, picture := "TIT2", "TPE1", "COMM", "APIC"
+		if version == 2 {
+			title, artist, comment, picture = "TT2", "TP1", "COM", "PIC"
+		}
+		tag := Tag{
+			Version: version,
+			Frames: []Frame{
+				TextFrame(title, "Title"),
+				{ID: artist, Value: append([]byte{0x01, 0xFF, 0xFE}, 'A', 0, 'r', 0, 't', 0)},
+				{ID: comment, Value: []byte("\x00engDescription\x00Comment")},
+				{ID: picture, Value: append([]byte("\x00image/png\x00\x03\x00"), 0x89, 'P', 'N', 'G')},
+			},
+			Padding: 16,
+		}
+		corpus = append(corpus, tag.Bytes(), MP3(tag, len(tag.Bytes())+64))
+	}
+
+	return corpus
+}
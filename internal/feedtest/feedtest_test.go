@@ -0,0 +1,57 @@
+package feedtest
+
+import (
+	"bytes"
+	"encoding/xml"
+	"testing"
+)
+
+// Test that the tags have the right sizes for each version.
+func TestTagBytes(t *testing.T) {
+	for _, version := range []byte{2, 3, 4} {
+		id, frameHeader := "TIT2", 10
+		if version == 2 {
+			id, frameHeader = "TT2", 6
+		}
+		tag := Tag{Version: version, Frames: []Frame{TextFrame(id, "Title")}, Padding: 10}
+		data := tag.Bytes()
+
+		if want := 10 + frameHeader + 6 + 10; len(data) != want {
+			t.Error(version, "- Incorrect length - Want:", want, "Have:", len(data))
+		}
+		if size := int(data[6])<<21 | int(data[7])<<14 | int(data[8])<<7 | int(data[9]); size != len(data)-10 {
+			t.Error(version, "- Incorrect size in header - Want:", len(data)-10, "Have:", size)
+		}
+	}
+
+	if data := MP3(Tag{Version: 3}, 1000); len(data) != 1000 || !bytes.Contains(data, mpegFrameHeader) {
+		t.Error("Incorrect MP3 file")
+	}
+}
+
+// Test that the generated feed is valid RSS with an item for every episode.
+func TestGenerate(t *testing.T) {
+	feed := Generate("Show", 25, 1000)
+	if len(feed.Episodes) != 25 || feed.Episodes[0].Number != 25 || feed.Episodes[0].Season != 3 {
+		t.Fatal("Incorrect episodes:", len(feed.Episodes), feed.Episodes[0].Number, feed.Episodes[0].Season)
+	}
+
+	var rss struct {
+		Items []struct {
+			Title     string `xml:"title"`
+			Enclosure struct {
+				URL string `xml:"url,attr"`
+			} `xml:"enclosure"`
+		} `xml:"channel>item"`
+	}
+	if err := xml.Unmarshal(feed.RSS("https://example.com"), &rss); err != nil {
+		t.Fatal(err)
+	}
+	if len(rss.Items) != 25 || rss.Items[1].Title != "Episode 24" || rss.Items[1].Enclosure.URL != "https://example.com/1.mp3" {
+		t.Error("Incorrect RSS:", rss.Items[:2])
+	}
+
+	if !bytes.Equal(Mutate([]byte("data"), 1), Mutate([]byte("data"), 1)) {
+		t.Error("Mutations are not repeatable")
+	}
+}
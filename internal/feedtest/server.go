@@ -0,0 +1,79 @@
+package feedtest
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Server serves synthetic feeds and their episodes over HTTP. Each feed is at /<feed index>/feed.xml, and its episodes
+// are at /<feed index>/<episode index>.mp3. Episodes can be requested with a Range, like from a real host.
+type Server struct {
+	*httptest.Server
+	feeds    []Feed
+	requests int64
+	bytes    int64
+}
+
+// NewServer starts a server for the feeds. Close it when done.
+func NewServer(feeds ...Feed) *Server {
+	s := &Server{feeds: feeds}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+
+	return s
+}
+
+// FeedURL returns the URL of the feed with the index.
+func (s *Server) FeedURL(feed int) string {
+	return fmt.Sprintf("%s/%d/feed.xml", s.URL, feed)
+}
+
+// Requests returns the number of requests served so far.
+func (s *Server) Requests() int {
+	return int(atomic.LoadInt64(&s.requests))
+}
+
+// BytesServed returns the number of bytes of episodes served so far.
+func (s *Server) BytesServed() int64 {
+	return atomic.LoadInt64(&s.bytes)
+}
+
+// serve handles a request for a feed or an episode.
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt64(&s.requests, 1)
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	i, err := strconv.Atoi(parts[0])
+	if err != nil || i < 0 || i >= len(s.feeds) {
+		http.NotFound(w, r)
+		return
+	}
+	feed := s.feeds[i]
+
+	if parts[1] == "feed.xml" {
+		w.Header().Set("Content-Type", "application/rss+xml")
+		w.Write(feed.RSS(fmt.Sprintf("%s/%d", s.URL, i)))
+		return
+	}
+
+	j, err := strconv.Atoi(strings.TrimSuffix(parts[1], ".mp3"))
+	if err != nil || j < 0 || j >= len(feed.Episodes) {
+		http.NotFound(w, r)
+		return
+	}
+	episode := feed.Episodes[j]
+	data := MP3(episode.Tag, episode.Size)
+	atomic.AddInt64(&s.bytes, int64(len(data)))
+
+	w.Header().Set("Content-Type", "audio/mpeg")
+	http.ServeContent(w, r, parts[1], time.Time{}, bytes.NewReader(data))
+}
@@ -0,0 +1,341 @@
+// Package httpcache keeps fetched HTTP resources on disk, keyed by their URL, so that they only need to be downloaded
+// again once they've changed. It follows the parts of HTTP caching that matter to a client fetching the same things
+// over and over: Cache-Control (no-store, no-cache, and max-age) and Expires say how long a copy can be used without
+// asking the server, and ETag and Last-Modified let the server answer with 304 Not Modified once it's stale.
+package httpcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// now returns the current time. Tests replace it to move time along.
+var now = time.Now
+
+// Cache is a directory of cached resources. Each resource is stored as two files named by the hash of its URL: the
+// body, and the body's details with the extension ".json". The URL itself isn't stored, since it might have a token in
+// it. Feeds can be private, so only the user can read the directory. A nil Cache caches nothing.
+type Cache struct {
+	dir string
+}
+
+// Entry is a cached resource.
+type Entry struct {
+	URL          string    `json:"-"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Stored       time.Time `json:"stored"`  // when the resource was fetched or last revalidated
+	Expires      time.Time `json:"expires"` // when the resource goes stale, or the zero time if it always is
+	Data         []byte    `json:"-"`
+}
+
+// New creates a cache in the directory, which is created when the first resource is stored. If dir is empty, this
+// returns nil.
+func New(dir string) *Cache {
+	if dir == "" {
+		return nil
+	}
+
+	return &Cache{dir: dir}
+}
+
+// Get returns the cached copy of the resource at the URL, or nil if there isn't one. Getting an entry counts as using
+// it for Prune.
+func (c *Cache) Get(link string) *Entry {
+	if c == nil {
+		return nil
+	}
+
+	path := c.path(link)
+	meta, err := ioutil.ReadFile(path + ".json")
+	if err != nil {
+		return nil
+	}
+	var e Entry
+	if err := json.Unmarshal(meta, &e); err != nil {
+		return nil
+	}
+	e.URL = link
+	e.Data, err = ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	t := now()
+	os.Chtimes(path+".json", t, t)
+
+	return &e
+}
+
+// Fresh returns whether or not the entry can still be used without asking the server.
+func (e *Entry) Fresh() bool {
+	return e != nil && now().Before(e.Expires)
+}
+
+// Validate adds the headers to the request header that ask the server to only send the resource if it's changed since
+// the entry was stored. It returns false if the entry has nothing to validate it with.
+func (e *Entry) Validate(header http.Header) bool {
+	if e == nil {
+		return false
+	}
+
+	if e.ETag != "" {
+		header.Set("If-None-Match", e.ETag)
+	}
+	if e.LastModified != "" {
+		header.Set("If-Modified-Since", e.LastModified)
+	}
+
+	return e.ETag != "" || e.LastModified != ""
+}
+
+// Store saves the resource at the URL from a 200 OK response with the header. If the response says not to store it, or
+// it would be stale right away with no way to validate it later, any cached copy is removed instead.
+func (c *Cache) Store(link string, header http.Header, data []byte) error {
+	if c == nil {
+		return nil
+	}
+
+	e := Entry{
+		URL:          link,
+		ETag:         strings.TrimSpace(header.Get("ETag")),
+		LastModified: strings.TrimSpace(header.Get("Last-Modified")),
+		Stored:       now().UTC(),
+		Data:         data,
+	}
+	expires, ok := freshness(header, e.Stored)
+	if !ok || (!expires.After(e.Stored) && e.ETag == "" && e.LastModified == "") {
+		return c.Remove(link)
+	}
+	e.Expires = expires
+
+	if err := os.MkdirAll(c.dir, 0700); err != nil {
+		return err
+	}
+	// A directory left by an older version might still be open to everyone.
+	if err := os.Chmod(c.dir, 0700); err != nil {
+		return err
+	}
+	path := c.path(link)
+	if err := writeFile(path, data); err != nil {
+		return err
+	}
+
+	return c.writeMeta(path, e)
+}
+
+// Revalidated updates the entry after the server answered with 304 Not Modified and the header. The body is kept, and
+// the entry's validators and freshness are updated from the response.
+func (c *Cache) Revalidated(e *Entry, header http.Header) error {
+	if c == nil || e == nil {
+		return nil
+	}
+
+	if etag := strings.TrimSpace(header.Get("ETag")); etag != "" {
+		e.ETag = etag
+	}
+	if modified := strings.TrimSpace(header.Get("Last-Modified")); modified != "" {
+		e.LastModified = modified
+	}
+	e.Stored = now().UTC()
+	expires, ok := freshness(header, e.Stored)
+	if !ok {
+		return c.Remove(e.URL)
+	}
+	e.Expires = expires
+
+	return c.writeMeta(c.path(e.URL), *e)
+}
+
+// Remove deletes the cached copy of the resource at the URL, if there is one.
+func (c *Cache) Remove(link string) error {
+	if c == nil {
+		return nil
+	}
+
+	path := c.path(link)
+	if err := os.Remove(path + ".json"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// Prune removes the resources that haven't been stored or used for longer than maxAge, and then the ones that were used
+// the longest ago until the cache is no larger than maxSize bytes. Either limit can be 0 to leave it out. Files left
+// behind by an interrupted write are removed too. This returns the number of resources that were removed.
+func (c *Cache) Prune(maxAge time.Duration, maxSize int64) (int, error) {
+	if c == nil {
+		return 0, nil
+	}
+
+	files, err := ioutil.ReadDir(c.dir)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	// Each resource is its body and its details, and was last used when its details were.
+	type resource struct {
+		name string
+		size int64
+		used time.Time
+	}
+	byName := make(map[string]*resource)
+	var resources []*resource
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() {
+			continue
+		}
+		if strings.HasSuffix(name, ".tmp") {
+			if now().Sub(file.ModTime()) > time.Hour {
+				os.Remove(filepath.Join(c.dir, name))
+			}
+			continue
+		}
+
+		base := strings.TrimSuffix(name, ".json")
+		r, ok := byName[base]
+		if !ok {
+			r = &resource{name: base}
+			byName[base] = r
+			resources = append(resources, r)
+		}
+		r.size += file.Size()
+		if name != base || r.used.IsZero() {
+			r.used = file.ModTime()
+		}
+	}
+
+	sort.Slice(resources, func(i, j int) bool { return resources[i].used.After(resources[j].used) })
+	var total int64
+	removed := 0
+	for _, r := range resources {
+		total += r.size
+		if (maxAge <= 0 || now().Sub(r.used) <= maxAge) && (maxSize <= 0 || total <= maxSize) {
+			continue
+		}
+
+		for _, name := range []string{r.name + ".json", r.name} {
+			if err := os.Remove(filepath.Join(c.dir, name)); err != nil && !os.IsNotExist(err) {
+				return removed, err
+			}
+		}
+		total -= r.size
+		removed++
+	}
+
+	return removed, nil
+}
+
+// path returns the location of the body of the resource at the URL.
+func (c *Cache) path(link string) string {
+	sum := sha256.Sum256([]byte(link))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+// writeMeta saves the entry's details next to its body.
+func (c *Cache) writeMeta(path string, e Entry) error {
+	meta, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	return writeFile(path+".json", meta)
+}
+
+// freshness returns when a response with the header, fetched at the time, goes stale. The zero time means that it must
+// be validated every time. If the response can't be stored at all, this returns false.
+func freshness(header http.Header, fetched time.Time) (time.Time, bool) {
+	directives := cacheControl(header)
+	if _, ok := directives["no-store"]; ok {
+		return time.Time{}, false
+	}
+	if _, ok := directives["no-cache"]; ok {
+		return time.Time{}, true
+	}
+
+	if value, ok := directives["max-age"]; ok {
+		maxAge, err := strconv.Atoi(value)
+		if err != nil || maxAge <= 0 {
+			return time.Time{}, true
+		}
+		// The copy may already have spent some time in a shared cache on the way here.
+		age, _ := strconv.Atoi(header.Get("Age"))
+		if age < 0 {
+			age = 0
+		}
+		return fetched.Add(time.Duration(maxAge-age) * time.Second), true
+	}
+
+	if value := header.Get("Expires"); value != "" {
+		expires, err := http.ParseTime(value)
+		if err != nil {
+			// Invalid dates (like "0") mean that it's already expired.
+			return time.Time{}, true
+		}
+		// Go by the server's clock, in case ours is off.
+		if date, err := http.ParseTime(header.Get("Date")); err == nil {
+			return fetched.Add(expires.Sub(date)), true
+		}
+		return expires, true
+	}
+
+	return time.Time{}, true
+}
+
+// cacheControl returns the directives in the Cache-Control header by name, with any values unquoted.
+func cacheControl(header http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, line := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(line, ",") {
+			name, value := directive, ""
+			if i := strings.Index(directive, "="); i >= 0 {
+				name, value = directive[:i], directive[i+1:]
+			}
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name != "" {
+				directives[name] = strings.Trim(strings.TrimSpace(value), `"`)
+			}
+		}
+	}
+
+	return directives
+}
+
+// writeFile writes the data to a temporary file next to the path and then moves it into place, so that a crash never
+// leaves half of a file behind.
+func writeFile(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,189 @@
+package httpcache
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test that Cache-Control, Age, Expires, and Date are turned into the time that a response goes stale.
+func TestFreshness(t *testing.T) {
+	fetched := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	date := fetched.Add(-time.Hour).Format(http.TimeFormat) // the server's clock is an hour behind ours
+
+	tests := []struct {
+		header map[string]string
+		want   time.Duration // how long until stale, or -1 for never fresh
+		store  bool
+	}{
+		{map[string]string{}, -1, true},
+		{map[string]string{"Cache-Control": "max-age=600"}, 10 * time.Minute, true},
+		{map[string]string{"Cache-Control": "public, MAX-AGE=\"600\"", "Age": "60"}, 9 * time.Minute, true},
+		{map[string]string{"Cache-Control": "max-age=600, no-cache"}, -1, true},
+		{map[string]string{"Cache-Control": "no-store, max-age=600"}, -1, false},
+		{map[string]string{"Cache-Control": "max-age=0"}, -1, true},
+		{map[string]string{"Expires": fetched.Add(time.Hour).Format(http.TimeFormat)}, time.Hour, true},
+		{map[string]string{"Expires": fetched.Format(http.TimeFormat), "Date": date}, time.Hour, true},
+		{map[string]string{"Expires": "0"}, -1, true},
+		{map[string]string{"Cache-Control": "max-age=60", "Expires": "0"}, time.Minute, true},
+	}
+	for _, test := range tests {
+		header := http.Header{}
+		for key, value := range test.header {
+			header.Set(key, value)
+		}
+
+		expires, store := freshness(header, fetched)
+		if store != test.store {
+			t.Error(test.header, "- Incorrect store - Want:", test.store, "Have:", store)
+			continue
+		}
+		if test.want < 0 && !expires.IsZero() {
+			t.Error(test.header, "- Incorrect expiration - Want: never fresh Have:", expires)
+		} else if test.want >= 0 && !expires.Equal(fetched.Add(test.want)) {
+			t.Error(test.header, "- Incorrect expiration - Want:", fetched.Add(test.want), "Have:", expires)
+		}
+	}
+}
+
+// Test that a stored resource is used while it's fresh, validated once it's stale, and not kept at all if it can't be
+// validated or the server says not to, and that only the user can read what's stored.
+func TestCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "httpcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	start := time.Now()
+	clock := start
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+
+	c := New(dir)
+	link := "https://example.com/image.jpg"
+	if e := c.Get(link); e != nil {
+		t.Fatal("Found an entry in an empty cache:", e.URL)
+	}
+
+	header := http.Header{}
+	header.Set("ETag", `"v1"`)
+	header.Set("Cache-Control", "max-age=60")
+	if err := c.Store(link, header, []byte("image")); err != nil {
+		t.Fatal(err)
+	}
+	e := c.Get(link)
+	if e == nil || string(e.Data) != "image" || e.ETag != `"v1"` {
+		t.Fatal("Incorrect entry - Want: image \"v1\" Have:", e)
+	}
+	if !e.Fresh() {
+		t.Error("Entry is stale right after it was stored")
+	}
+
+	// The details don't give away the URL, which might have a token in it.
+	meta, err := ioutil.ReadFile(c.path(link) + ".json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(meta), "example.com") {
+		t.Error("URL was stored with the entry:", string(meta))
+	}
+	for _, path := range []string{dir, c.path(link), c.path(link) + ".json"} {
+		if info, err := os.Stat(path); err != nil || info.Mode().Perm()&0077 != 0 {
+			t.Error(path, "- Readable by others:", info.Mode(), err)
+		}
+	}
+
+	// Once it's stale, it's validated with its ETag.
+	clock = start.Add(2 * time.Minute)
+	if e.Fresh() {
+		t.Error("Entry is still fresh after max-age")
+	}
+	validate := http.Header{}
+	if !e.Validate(validate) || validate.Get("If-None-Match") != `"v1"` {
+		t.Error("Incorrect validation - Want: If-None-Match: \"v1\" Have:", validate)
+	}
+
+	// A 304 keeps the body and starts the clock again.
+	if err := c.Revalidated(e, header); err != nil {
+		t.Fatal(err)
+	}
+	if e = c.Get(link); e == nil || string(e.Data) != "image" || !e.Fresh() {
+		t.Error("Entry wasn't refreshed after revalidation:", e)
+	}
+
+	// A response that can't be validated or used later replaces nothing and removes the old copy.
+	if err := c.Store(link, http.Header{}, []byte("new image")); err != nil {
+		t.Fatal(err)
+	}
+	if e := c.Get(link); e != nil {
+		t.Error("Kept an entry that can't be validated:", string(e.Data))
+	}
+
+	// Neither is anything the server says not to store.
+	header.Set("Cache-Control", "no-store")
+	c.Store(link, header, []byte("private"))
+	if e := c.Get(link); e != nil {
+		t.Error("Kept an entry marked no-store:", string(e.Data))
+	}
+
+	// A nil cache caches nothing.
+	var none *Cache
+	if err := none.Store(link, http.Header{}, nil); err != nil || none.Get(link) != nil {
+		t.Error("Nil cache stored something:", err)
+	}
+}
+
+// Test that resources that haven't been used for a while are pruned, and then the least recently used ones until the
+// cache is small enough.
+func TestPrune(t *testing.T) {
+	dir, err := ioutil.TempDir("", "httpcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	start := time.Now()
+	clock := start
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+
+	c := New(dir)
+	header := http.Header{}
+	header.Set("ETag", `"v1"`)
+	links := []string{"https://example.com/old", "https://example.com/a", "https://example.com/b", "https://example.com/c"}
+	for i, link := range links {
+		clock = start.Add(time.Duration(i) * time.Hour)
+		if err := c.Store(link, header, make([]byte, 1000)); err != nil {
+			t.Fatal(err)
+		}
+		// Prune goes by the times of the files, which the clock doesn't set.
+		os.Chtimes(c.path(link)+".json", clock, clock)
+	}
+	// Using a resource keeps it around.
+	clock = start.Add(10 * time.Hour)
+	c.Get(links[1])
+	ioutil.WriteFile(filepath.Join(dir, "abc.123.tmp"), nil, 0600)
+	os.Chtimes(filepath.Join(dir, "abc.123.tmp"), start, start)
+
+	// The first is too old, and only two of the rest fit.
+	removed, err := c.Prune(8*time.Hour, 2500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 2 {
+		t.Error("Incorrect number removed - Want: 2 Have:", removed)
+	}
+	for i, want := range []bool{false, true, false, true} {
+		if have := c.Get(links[i]) != nil; have != want {
+			t.Error(links[i], "- Want kept:", want, "Have:", have)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "abc.123.tmp")); !os.IsNotExist(err) {
+		t.Error("Leftover temporary file wasn't removed")
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// errInterrupted signals that the user asked us to stop before the work was done.
+var errInterrupted = fmt.Errorf("interrupted")
+
+// stopCtx is canceled when the user asks us to stop, which aborts any requests that are in progress.
+var stopCtx, requestStop = context.WithCancel(context.Background())
+
+// Interrupted reports whether or not the user has asked us to stop.
+func Interrupted() bool {
+	return stopCtx.Err() != nil
+}
+
+// trapInterrupts catches SIGINT and SIGTERM so that we can clean up before exiting. The first signal stops the
+// download in progress, which removes its partial file and lets the sync wind down. A second signal exits right away.
+func trapInterrupts() {
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		sig := <-sigs
+		Log("")
+		Log("Received", sig, "signal, stopping after cleaning up (send again to quit immediately)")
+		requestStop()
+
+		<-sigs
+		Log("Quitting immediately")
+		flushLog()
+		os.Exit(130)
+	}()
+}
+
+// flushLog makes sure that everything written to the log file has made it to disk.
+func flushLog() {
+	if LogFile != nil {
+		LogFile.Sync()
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+)
+
+// Test that a feed whose fetch is stopped by an interrupt isn't reported as a failed feed.
+func TestInterruptedFetch(t *testing.T) {
+	tmpCtx, tmpStop := stopCtx, requestStop
+	defer func() { stopCtx, requestStop = tmpCtx, tmpStop }()
+	stopCtx, requestStop = context.WithCancel(context.Background())
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestStop()
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "getcast-interrupt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	u, _ := url.Parse(server.URL + "/feed.xml")
+	show := &Show{URL: u}
+	if _, _, err := show.Sync(dir, ""); err != errInterrupted {
+		t.Error("Incorrect error - Want:", errInterrupted, "Have:", err)
+	}
+}
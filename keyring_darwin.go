@@ -0,0 +1,19 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// keyringLookup reads the secret for the service from the user's Keychain. The secret is expected to be stored as a
+// generic password with the service name getcast:service, e.g. with
+// `security add-generic-password -s getcast:service -a $USER -w <secret>`.
+func keyringLookup(service string) (string, error) {
+	out, err := exec.Command("security", "find-generic-password", "-s", "getcast:"+service, "-w").Output()
+	if err != nil {
+		return "", fmt.Errorf("secret for %v not found in keychain: %v", service, err)
+	}
+
+	return strings.TrimSuffix(string(out), "\n"), nil
+}
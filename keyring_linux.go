@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// keyringLookup reads the secret for the service from the user's kernel keyring. The secret is expected to be stored
+// as a "user" key named getcast:service, e.g. with `keyctl add user getcast:service <secret> @u`.
+func keyringLookup(service string) (string, error) {
+	id, err := exec.Command("keyctl", "search", "@u", "user", "getcast:"+service).Output()
+	if err != nil {
+		return "", fmt.Errorf("secret for %v not found in keyring: %v", service, err)
+	}
+
+	out, err := exec.Command("keyctl", "pipe", strings.TrimSpace(string(id))).Output()
+	if err != nil {
+		return "", fmt.Errorf("error reading secret for %v from keyring: %v", service, err)
+	}
+
+	return string(out), nil
+}
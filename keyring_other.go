@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !windows
+// +build !linux,!darwin,!windows
+
+package main
+
+import (
+	"fmt"
+)
+
+// keyringLookup is not supported on this platform.
+func keyringLookup(service string) (string, error) {
+	return "", fmt.Errorf("keyring lookup not supported on this platform")
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"unicode/utf16"
+	"unsafe"
+)
+
+// These are the Credential Manager functions in advapi32.dll.
+var (
+	advapi32     = syscall.NewLazyDLL("advapi32.dll")
+	procCredRead = advapi32.NewProc("CredReadW")
+	procCredFree = advapi32.NewProc("CredFree")
+)
+
+// credTypeGeneric is the type of credential stored with `cmdkey /generic`.
+const credTypeGeneric = 1
+
+// credential is the layout of Windows' CREDENTIALW structure.
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// keyringLookup reads the secret for the service from the user's Windows Credential Manager. The secret is expected to
+// be stored as a generic credential with the target name getcast:service, e.g. with
+// `cmdkey /generic:getcast:service /user:me /pass:<secret>`.
+func keyringLookup(service string) (string, error) {
+	target, err := syscall.UTF16PtrFromString("getcast:" + service)
+	if err != nil {
+		return "", err
+	}
+
+	var cred *credential
+	ok, _, err := procCredRead.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred)))
+	if ok == 0 {
+		return "", fmt.Errorf("secret for %v not found in Credential Manager: %v", service, err)
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+
+	size := int(cred.CredentialBlobSize)
+	if size == 0 || cred.CredentialBlob == nil {
+		return "", nil
+	}
+	blob := (*[1 << 30]byte)(unsafe.Pointer(cred.CredentialBlob))[:size:size]
+
+	// cmdkey and the Credential Manager store passwords as UTF-16. Anything else is taken as is.
+	if size%2 != 0 {
+		return string(blob), nil
+	}
+	chars := make([]uint16, size/2)
+	for i := range chars {
+		chars[i] = uint16(blob[2*i]) | uint16(blob[2*i+1])<<8
+	}
+
+	return string(utf16.Decode(chars)), nil
+}
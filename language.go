@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+)
+
+// iso639 maps two-letter ISO 639-1 language codes (as used in RSS feeds) to the three-letter ISO 639-2 codes that ID3
+// expects in the TLAN frame.
+var iso639 = map[string]string{
+	"ar": "ara",
+	"cs": "ces",
+	"da": "dan",
+	"de": "deu",
+	"el": "ell",
+	"en": "eng",
+	"es": "spa",
+	"fa": "fas",
+	"fi": "fin",
+	"fr": "fra",
+	"he": "heb",
+	"hi": "hin",
+	"hu": "hun",
+	"id": "ind",
+	"it": "ita",
+	"ja": "jpn",
+	"ko": "kor",
+	"nl": "nld",
+	"no": "nor",
+	"pl": "pol",
+	"pt": "por",
+	"ro": "ron",
+	"ru": "rus",
+	"sv": "swe",
+	"th": "tha",
+	"tr": "tur",
+	"uk": "ukr",
+	"vi": "vie",
+	"zh": "zho",
+}
+
+// baseLanguage returns the primary language subtag of the language tag in lowercase, e.g. "en" for "en-US".
+func baseLanguage(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if i := strings.IndexAny(tag, "-_"); i >= 0 {
+		tag = tag[:i]
+	}
+
+	return tag
+}
+
+// languageISO6392 converts the language tag from the feed (e.g. "en-us") into its three-letter ISO 639-2 code (e.g.
+// "eng") for the TLAN frame. This returns "" if the language is not known.
+func languageISO6392(tag string) string {
+	base := baseLanguage(tag)
+	if len(base) == 3 {
+		// Already a three-letter code.
+		return base
+	}
+
+	return iso639[base]
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LibraryEntry holds the information about an individual episode in the library.
+type LibraryEntry struct {
+	Show     string `json:"show"`
+	Season   string `json:"season,omitempty"`
+	Number   string `json:"number,omitempty"`
+	Title    string `json:"title"`
+	Date     string `json:"date,omitempty"`     // publish date, as YYYY-MM-DD if known
+	Duration string `json:"duration,omitempty"` // as HH:MM:SS
+	Size     int    `json:"size"`               // bytes on disk
+	Path     string `json:"path"`
+}
+
+// ScanLibrary walks the main download directory and gathers the information about every episode in it. Information
+// from the state DB is preferred, with the file's tags used for episodes that getcast has no record of.
+func ScanLibrary(mainDir string) ([]LibraryEntry, error) {
+	mainDir, err := filepath.Abs(mainDir)
+	if err != nil {
+		return nil, err
+	}
+
+	byPath := StateDB.ByPath()
+
+	var entries []LibraryEntry
+	walkFunc := func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() && path != mainDir {
+				return filepath.SkipDir
+			}
+			return nil
+		} else if info.IsDir() || !isAudio(info.Name()) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(mainDir, path)
+		if err != nil {
+			return err
+		}
+
+		// The show's directory is the first directory under the main directory.
+		show := ""
+		if parts := strings.Split(filepath.ToSlash(rel), "/"); len(parts) > 1 {
+			show = parts[0]
+		}
+
+		entry := LibraryEntry{Show: show, Size: int(info.Size()), Path: path}
+		if es, ok := byPath[path]; ok {
+			entry.Season = es.Season
+			entry.Number = es.Number
+			entry.Title = es.Title
+			if !es.Published.IsZero() {
+				entry.Date = es.Published.Format("2006-01-02")
+			}
+			entry.Duration = formatSeconds(parseItunesDuration(es.Duration))
+		} else {
+			meta, err := readFileMeta(path)
+			if err != nil {
+				Debug("Error reading metadata of", info.Name(), "-", err)
+			}
+			entry.Title = getTag(meta, "TIT2")
+			entry.Season = withoutTotal(getTag(meta, "TPOS"))
+			entry.Number = withoutTotal(getTag(meta, "TRCK"))
+			entry.Date = getTag(meta, "TDRC")
+			if entry.Date == "" {
+				entry.Date = getTag(meta, "TYER")
+			}
+			if ms, err := strconv.Atoi(getTag(meta, "TLEN")); err == nil {
+				entry.Duration = formatSeconds(ms / 1000)
+			}
+		}
+		if entry.Title == "" {
+			entry.Title = strings.TrimSuffix(info.Name(), filepath.Ext(info.Name()))
+		}
+
+		entries = append(entries, entry)
+		return nil
+	}
+
+	if err := filepath.Walk(mainDir, walkFunc); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Show != entries[j].Show {
+			return entries[i].Show < entries[j].Show
+		}
+		return entries[i].Path < entries[j].Path
+	})
+
+	return entries, nil
+}
+
+// runExportLibrary writes one row for every episode in the library to stdout, in either CSV or JSON format.
+func runExportLibrary(config *Config, dirArg string, args []string) error {
+	fs := flag.NewFlagSet("export-library", flag.ContinueOnError)
+	format := fs.String("format", "csv", "Output format: csv or json")
+	output := fs.String("o", "", "Optional. File to write to instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	dir, err := downloadDir(config, dirArg)
+	if err != nil {
+		return err
+	}
+
+	entries, err := ScanLibrary(dir)
+	if err != nil {
+		return fmt.Errorf("error scanning library: %v", err)
+	}
+	if EpisodeFilter != nil {
+		selected := entries[:0]
+		for _, entry := range entries {
+			if EpisodeFilter.Selects(entry.filterValues()) {
+				selected = append(selected, entry)
+			}
+		}
+		entries = selected
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	switch *format {
+	case "csv":
+		return writeLibraryCSV(w, entries)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "\t")
+		return enc.Encode(entries)
+	}
+
+	return fmt.Errorf("invalid format: %v", *format)
+}
+
+// writeLibraryCSV writes the entries as CSV with a header row.
+func writeLibraryCSV(w io.Writer, entries []LibraryEntry) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"show", "season", "number", "title", "date", "duration", "size", "path"})
+	for _, e := range entries {
+		cw.Write([]string{e.Show, e.Season, e.Number, e.Title, e.Date, e.Duration, strconv.Itoa(e.Size), e.Path})
+	}
+	cw.Flush()
+
+	return cw.Error()
+}
+
+// parseItunesDuration parses the duration of an episode as given in the itunes:duration element, which can be either
+// a number of seconds or in the form of HH:MM:SS or MM:SS. This returns 0 if the duration can't be parsed.
+func parseItunesDuration(s string) int {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0
+	}
+
+	seconds := 0
+	for _, part := range strings.Split(s, ":") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return 0
+		}
+		seconds = seconds*60 + n
+	}
+
+	return seconds
+}
+
+// formatSeconds formats the number of seconds as HH:MM:SS, or "" if there are no seconds.
+func formatSeconds(seconds int) string {
+	if seconds <= 0 {
+		return ""
+	}
+
+	d := time.Duration(seconds) * time.Second
+	return fmt.Sprintf("%02d:%02d:%02d", int(d.Hours()), int(d.Minutes())%60, seconds%60)
+}
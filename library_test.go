@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/snhilde/getcast/internal/feedtest"
+)
+
+// Test that exporting the library lists every audio file under the main directory by show, taking the episode's
+// information from the state DB if getcast downloaded it and from the file's tags if not.
+func TestExportLibrary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast-library")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tmpState, tmpCache, tmpFilter := StateDB, TagCache, EpisodeFilter
+	defer func() { StateDB, TagCache, EpisodeFilter = tmpState, tmpCache, tmpFilter }()
+	TagCache, EpisodeFilter = nil, nil
+
+	tagged := feedtest.MP3(feedtest.Tag{Version: 3, Frames: []feedtest.Frame{
+		feedtest.TextFrame("TIT2", "Tagged Episode"),
+		feedtest.TextFrame("TPOS", "1/2"),
+		feedtest.TextFrame("TRCK", "3/10"),
+		feedtest.TextFrame("TYER", "2019"),
+		feedtest.TextFrame("TLEN", "3725000"),
+	}}, 1000)
+	untagged := feedtest.MP3(feedtest.Tag{Version: 3}, 500)
+	files := map[string][]byte{
+		"Alpha/Downloaded.mp3":   untagged,
+		"Alpha/cover.jpg":        nil,
+		"Beta/Season 1/Tag.mp3":  tagged,
+		"Beta/.partial/Part.mp3": untagged,
+		"Loose File.mp3":         untagged,
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	downloaded := filepath.Join(dir, "Alpha", "Downloaded.mp3")
+	StateDB = &State{Version: stateVersion, Shows: map[string]*ShowState{
+		"https://example.com/feed.xml": {Title: "Alpha", Episodes: map[string]*EpisodeState{
+			"guid-1": {
+				Title:     "Downloaded Episode",
+				Season:    "2",
+				Number:    "5",
+				Published: time.Date(2020, time.June, 1, 12, 0, 0, 0, time.UTC),
+				Duration:  "1:02:03",
+				Path:      downloaded,
+			},
+		}},
+	}}
+
+	want := []LibraryEntry{
+		{Show: "", Title: "Loose File", Size: len(untagged), Path: filepath.Join(dir, "Loose File.mp3")},
+		{
+			Show: "Alpha", Season: "2", Number: "5", Title: "Downloaded Episode", Date: "2020-06-01",
+			Duration: "01:02:03", Size: len(untagged), Path: downloaded,
+		},
+		{
+			Show: "Beta", Season: "1", Number: "3", Title: "Tagged Episode", Date: "2019", Duration: "01:02:05",
+			Size: len(tagged), Path: filepath.Join(dir, "Beta", "Season 1", "Tag.mp3"),
+		},
+	}
+
+	output := filepath.Join(dir, "library.json")
+	if err := runExportLibrary(nil, dir, []string{"-format", "json", "-o", output}); err != nil {
+		t.Fatal(err)
+	}
+	var entries []LibraryEntry
+	if data, err := ioutil.ReadFile(output); err != nil {
+		t.Fatal(err)
+	} else if err := json.Unmarshal(data, &entries); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Error("Incorrect JSON records - Want:", want, "Have:", entries)
+	}
+
+	output = filepath.Join(dir, "library.csv")
+	if err := runExportLibrary(nil, dir, []string{"-o", output}); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	rows, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != len(want)+1 {
+		t.Fatal("Incorrect number of CSV rows - Want:", len(want)+1, "Have:", len(rows))
+	}
+	row := []string{"Beta", "1", "3", "Tagged Episode", "2019", "01:02:05", strconv.Itoa(len(tagged)), want[2].Path}
+	if !reflect.DeepEqual(rows[3], row) {
+		t.Error("Incorrect CSV row - Want:", row, "Have:", rows[3])
+	}
+
+	if err := runExportLibrary(nil, dir, []string{"-format", "xml", "-o", output}); err == nil {
+		t.Error("Invalid format was accepted")
+	}
+}
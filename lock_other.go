@@ -0,0 +1,60 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// lockStale is how old a lock file can get before it's assumed to have been left behind by a process that died.
+const lockStale = 2 * time.Minute
+
+// fileLock is an exclusive lock on a file, held across processes.
+type fileLock struct {
+	path string
+}
+
+// lockFile takes an exclusive lock on the file at the path and waits until any other process holding it lets go. There's
+// no portable advisory locking on this platform, so the lock is held by creating the file and released by removing it.
+func lockFile(path string) (*fileLock, error) {
+	return tryLockFile(path, 0)
+}
+
+// tryLockFile is the same as lockFile, except that it gives up with errLocked if another process is still holding the
+// lock after the wait. A wait of 0 means to wait for as long as it takes.
+func tryLockFile(path string, wait time.Duration) (*fileLock, error) {
+	deadline := time.Now().Add(wait)
+	for {
+		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			file.Close()
+			return &fileLock{path: path}, nil
+		}
+		if os.IsNotExist(err) {
+			// The directory isn't there yet, so nothing else can be using the file.
+			return &fileLock{}, nil
+		} else if !os.IsExist(err) {
+			return nil, fmt.Errorf("error taking lock: %v", err)
+		}
+
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > lockStale {
+			Log("Removing stale lock", path)
+			os.Remove(path)
+			continue
+		}
+		if wait > 0 && !time.Now().Before(deadline) {
+			return nil, errLocked
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// Unlock releases the lock.
+func (l *fileLock) Unlock() {
+	if l.path != "" {
+		os.Remove(l.path)
+	}
+}
@@ -0,0 +1,66 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// fileLock is an exclusive lock on a file, held across processes.
+type fileLock struct {
+	file *os.File
+}
+
+// lockFile takes an exclusive lock on the file at the path, creating it if needed, and waits until any other process
+// holding it lets go. The lock is released if the process dies.
+func lockFile(path string) (*fileLock, error) {
+	return tryLockFile(path, 0)
+}
+
+// tryLockFile is the same as lockFile, except that it gives up with errLocked if another process is still holding the
+// lock after the wait. A wait of 0 means to wait for as long as it takes.
+func tryLockFile(path string, wait time.Duration) (*fileLock, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if os.IsNotExist(err) {
+		// The directory isn't there yet, so nothing else can be using the file.
+		return &fileLock{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error opening lock: %v", err)
+	}
+
+	how := syscall.LOCK_EX
+	if wait > 0 {
+		how |= syscall.LOCK_NB
+	}
+	deadline := time.Now().Add(wait)
+	for {
+		err = syscall.Flock(int(file.Fd()), how)
+		if err == syscall.EWOULDBLOCK && time.Now().Before(deadline) {
+			time.Sleep(50 * time.Millisecond)
+			continue
+		} else if err != syscall.EINTR {
+			break
+		}
+	}
+	if err == syscall.EWOULDBLOCK {
+		file.Close()
+		return nil, errLocked
+	} else if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error taking lock: %v", err)
+	}
+
+	return &fileLock{file: file}, nil
+}
+
+// Unlock releases the lock.
+func (l *fileLock) Unlock() {
+	if l.file != nil {
+		syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+		l.file.Close()
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LogOptions controls how the log file is opened and rotated.
+type LogOptions struct {
+	Append  bool          // whether to append to an existing log instead of truncating it
+	MaxSize int           // rotate the log once it reaches this many bytes (0 to disable)
+	MaxAge  time.Duration // rotate the log once it has not been rotated in this long (0 to disable)
+	Keep    int           // number of rotated logs to keep around
+}
+
+// OpenLog opens the log file at the provided path, rotating out the current log first if it has grown too large or too
+// old according to the options.
+func OpenLog(path string, opts LogOptions) (*os.File, error) {
+	if path == "" {
+		return nil, fmt.Errorf("missing log path")
+	}
+
+	if info, err := os.Stat(path); err == nil && needsRotation(path, info, opts) {
+		if err := rotateLog(path, opts.Keep); err != nil {
+			return nil, fmt.Errorf("error rotating log: %v", err)
+		}
+	}
+
+	flags := os.O_CREATE | os.O_WRONLY
+	if opts.Append {
+		flags |= os.O_APPEND
+	} else {
+		flags |= os.O_TRUNC
+	}
+
+	return os.OpenFile(path, flags, 0644)
+}
+
+// LogWriter writes to the log file, rotating it out as it's written to once it grows too large or too old, so that a
+// long-running daemon doesn't keep writing to the same log forever.
+type LogWriter struct {
+	path string
+	opts LogOptions
+	args []string // command line, for the header of each new log
+
+	mutex   sync.Mutex
+	file    *os.File
+	size    int64     // bytes in the current log
+	header  int64     // bytes of the current log's header, which doesn't count towards rotating it
+	created time.Time // when the current log was started
+}
+
+// NewLogWriter opens the log file at the path (see OpenLog) and writes the header for this run to it.
+func NewLogWriter(path string, opts LogOptions, args []string) (*LogWriter, error) {
+	file, err := OpenLog(path, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &LogWriter{path: path, opts: opts, args: args, file: file}
+	w.start()
+	return w, nil
+}
+
+// Write writes to the log, rotating it out first if it's past the size or age limit.
+func (w *LogWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.due() {
+		if err := w.rotate(); err != nil {
+			// Logging the error would come right back here.
+			fmt.Fprintln(os.Stderr, "Error rotating log:", err)
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Sync commits the log to disk.
+func (w *LogWriter) Sync() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.file.Sync()
+}
+
+// Close closes the log.
+func (w *LogWriter) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	return w.file.Close()
+}
+
+// start writes the header to the current log and notes its size and when it was started.
+func (w *LogWriter) start() {
+	w.size, w.header, w.created = 0, 0, time.Now()
+	if info, err := w.file.Stat(); err == nil {
+		w.size = info.Size()
+	}
+	empty := w.size == 0
+
+	WriteLogHeader(w.file, w.args)
+	if info, err := w.file.Stat(); err == nil {
+		w.size = info.Size()
+		w.created = logCreated(w.path, info)
+	}
+	if empty {
+		w.header = w.size
+	}
+}
+
+// due reports whether the current log is past the size or age limit. A log with nothing but its header is never due,
+// so that every log holds at least one message.
+func (w *LogWriter) due() bool {
+	if w.size <= w.header {
+		return false
+	}
+
+	if w.opts.MaxSize > 0 && w.size >= int64(w.opts.MaxSize) {
+		return true
+	}
+
+	return w.opts.MaxAge > 0 && time.Since(w.created) >= w.opts.MaxAge
+}
+
+// rotate moves the current log out of the way and starts a new one. If the log can't be rotated, we'll keep writing
+// to the current one and try again once it's grown by another full limit.
+func (w *LogWriter) rotate() error {
+	w.file.Close()
+	rotateErr := rotateLog(w.path, w.opts.Keep)
+
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	w.file = file
+	if rotateErr != nil {
+		w.size, w.header, w.created = 0, 0, time.Now()
+		return rotateErr
+	}
+
+	w.start()
+	return nil
+}
+
+// WriteLogHeader writes a header block to the log file so that the output of separate runs can be told apart.
+func WriteLogHeader(file *os.File, args []string) {
+	if file == nil {
+		return
+	}
+
+	host, _ := os.Hostname()
+	fmt.Fprintln(file, strings.Repeat("=", 80))
+	fmt.Fprintln(file, logStartPrefix, time.Now().Format(time.RFC3339))
+	fmt.Fprintln(file, "PID:", os.Getpid(), "Host:", host)
+	fmt.Fprintln(file, "Args:", strings.Join(args, " "))
+	fmt.Fprintln(file, strings.Repeat("=", 80))
+}
+
+// needsRotation determines if the log at the path, described by info, has exceeded either the size or the age limit.
+func needsRotation(path string, info os.FileInfo, opts LogOptions) bool {
+	if opts.MaxSize > 0 && info.Size() >= int64(opts.MaxSize) {
+		return true
+	}
+
+	if opts.MaxAge > 0 && time.Since(logCreated(path, info)) >= opts.MaxAge {
+		return true
+	}
+
+	return false
+}
+
+// logStartPrefix starts the line of each run's header that has the time the run started.
+const logStartPrefix = "getcast run started"
+
+// logCreated returns when the log at the path was started. We don't have access to a file's creation time everywhere,
+// and appending to the log updates its modification time on every run, so we'll go by the time in the header of the
+// first run written to it. Logs without a header fall back to the last time they were written to.
+func logCreated(path string, info os.FileInfo) time.Time {
+	file, err := os.Open(path)
+	if err != nil {
+		return info.ModTime()
+	}
+	defer file.Close()
+
+	// The header is at the very start of the log.
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(file, head)
+	for _, line := range strings.Split(string(head[:n]), "\n") {
+		if !strings.HasPrefix(line, logStartPrefix) {
+			continue
+		}
+		started, err := time.Parse(time.RFC3339, strings.TrimSpace(strings.TrimPrefix(line, logStartPrefix)))
+		if err == nil {
+			return started
+		}
+		break
+	}
+
+	return info.ModTime()
+}
+
+// rotateLog shifts all rotated logs up one number (path.1 -> path.2, etc.) and moves the current log to path.1. Any
+// logs past the retention count are removed.
+func rotateLog(path string, keep int) error {
+	if keep <= 0 {
+		// Nothing to keep. We'll just clear out the current log.
+		return os.Remove(path)
+	}
+
+	// Drop the oldest log if it's going to fall off the end.
+	oldest := path + "." + strconv.Itoa(keep)
+	if err := os.Remove(oldest); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for i := keep - 1; i > 0; i-- {
+		from := path + "." + strconv.Itoa(i)
+		to := path + "." + strconv.Itoa(i+1)
+		if err := os.Rename(from, to); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return os.Rename(path, path+".1")
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test that logs are rotated once they pass the size limit and that only the requested number of old logs are kept.
+func TestRotateLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "getcast.log")
+	opts := LogOptions{Append: true, MaxSize: 1, Keep: 2}
+
+	// Each run writes its number into the log. Every run after the first should push the previous log out.
+	for i := 1; i <= 4; i++ {
+		file, err := OpenLog(path, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.WriteString(strconv.Itoa(i))
+		file.Close()
+	}
+
+	want := map[string]string{
+		path:        "4",
+		path + ".1": "3",
+		path + ".2": "2",
+	}
+	for name, value := range want {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(data) != value {
+			t.Error(filepath.Base(name), "- Want:", value, "Have:", string(data))
+		}
+	}
+
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Error("Log past retention count was not removed")
+	}
+}
+
+// Test that append mode keeps the existing contents when no rotation is needed.
+func TestAppendLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "getcast.log")
+	for _, s := range []string{"a", "b"} {
+		file, err := OpenLog(path, LogOptions{Append: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.WriteString(s)
+		file.Close()
+	}
+
+	if data, _ := ioutil.ReadFile(path); string(data) != "ab" {
+		t.Error("Want: ab Have:", string(data))
+	}
+}
+
+// Test that a log that's appended to is rotated by when its first run started, not when it was last written to.
+func TestRotateLogAge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "getcast.log")
+	opts := LogOptions{Append: true, MaxAge: 24 * time.Hour, Keep: 1}
+	header := func(started time.Time) string {
+		return strings.Repeat("=", 80) + "\n" + logStartPrefix + " " + started.Format(time.RFC3339) + "\n"
+	}
+
+	// The log was started an hour ago and written to just now, so it's kept.
+	recent := header(time.Now().Add(-time.Hour)) + header(time.Now())
+	ioutil.WriteFile(path, []byte(recent), 0644)
+	file, err := OpenLog(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Error("Recent log was rotated")
+	}
+
+	old := header(time.Now().Add(-48*time.Hour)) + header(time.Now())
+	ioutil.WriteFile(path, []byte(old), 0644)
+	file, err = OpenLog(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	if data, err := ioutil.ReadFile(path + ".1"); err != nil || string(data) != old {
+		t.Error("Old log was not rotated:", err)
+	}
+}
+
+// Test that a log that stays open is rotated as it's written to once it passes the size or age limit, and that each new
+// log starts with a header.
+func TestLogWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "getcast.log")
+	w, err := NewLogWriter(path, LogOptions{MaxSize: 1000, MaxAge: time.Hour, Keep: 2}, []string{"getcast", "daemon"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	line := strings.Repeat("x", 99)
+	for w.size < 1000 {
+		fmt.Fprintln(w, line)
+	}
+	if _, err := os.Stat(path + ".1"); !os.IsNotExist(err) {
+		t.Error("Log was rotated before reaching the limit")
+	}
+
+	fmt.Fprintln(w, "after size limit")
+	rotated, err := ioutil.ReadFile(path + ".1")
+	if err != nil || !strings.Contains(string(rotated), line) || strings.Contains(string(rotated), "after size limit") {
+		t.Error("Log wasn't rotated at the size limit:", err)
+	}
+
+	// The log isn't rotated again until it's older than the age limit.
+	fmt.Fprintln(w, "before age limit")
+	w.created = w.created.Add(-2 * time.Hour)
+	fmt.Fprintln(w, "after age limit")
+	if data, err := ioutil.ReadFile(path + ".1"); err != nil || !strings.Contains(string(data), "before age limit") {
+		t.Error("Log wasn't rotated at the age limit:", err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), logStartPrefix) || !strings.HasSuffix(string(data), "after age limit\n") {
+		t.Error("Incorrect new log - Have:", string(data))
+	}
+}
@@ -1,12 +1,14 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
-	"net/url"
 	"os"
-	"path"
+	"path/filepath"
 	"strings"
+	"text/template"
+	"time"
 )
 
 var (
@@ -14,10 +16,179 @@ var (
 	DebugMode bool
 
 	// LogFile is the file where we will write all log/debug statements.
-	LogFile *os.File
+	LogFile *LogWriter
 
 	// Minimum width of episode number prefix.
 	PrefixMinWidth int
+
+	// FeedCache signals whether or not we will save a copy of every fetched feed.
+	FeedCache bool
+
+	// PlaylistFormat is the format of the playlists to write after syncing, or "" to not write playlists.
+	PlaylistFormat string
+
+	// ShowIndex signals whether or not we will keep an INDEX.md catalog of the downloaded episodes in each show's
+	// directory.
+	ShowIndex bool
+
+	// Preset is the media server whose library layout we'll follow, or "" for the default layout.
+	Preset string
+
+	// AudiobookshelfFlags holds the Audiobookshelf settings from the command line.
+	AudiobookshelfFlags AudiobookshelfConfig
+
+	// SizeUnits is the system of units that sizes are shown in.
+	SizeUnits string
+
+	// SlowSpeed is the average speed in bytes per second below which a download is reported as slow, or 0 to never
+	// report slow downloads.
+	SlowSpeed int
+
+	// MaxTagSize is the largest tag, in bytes, that will be held in memory. Larger tags are kept as published when
+	// downloading and can't be read from files on disk.
+	MaxTagSize int
+
+	// MaxFeedSize, MaxImageSize, and MaxEpisodeSize are the largest responses, in bytes, that will be read for a feed,
+	// an image, and an episode (or attachment), or 0 for no limit.
+	MaxFeedSize    int
+	MaxImageSize   int
+	MaxEpisodeSize int
+
+	// HostConcurrency is the maximum number of simultaneous requests to any one host.
+	HostConcurrency int
+
+	// CircuitThreshold is the number of server errors in a row from one host after which we stop contacting it for a
+	// while, or 0 to keep trying.
+	CircuitThreshold int
+
+	// CircuitCooldown is how long we stop contacting a host for after too many server errors.
+	CircuitCooldown time.Duration
+
+	// Attempts is the number of times to try downloading each episode before giving up on it.
+	Attempts int
+
+	// IPVersion is the IP version that connections are limited to, or "" for either.
+	IPVersion string
+
+	// Resolve holds the addresses to connect to for hosts instead of the ones from DNS.
+	Resolve HostOverrides
+
+	// TLS holds the settings for TLS connections.
+	TLS TLSOptions
+
+	// Mirror signals whether or not we will remove local episodes that are no longer in the feed.
+	Mirror bool
+
+	// TrashAge is how long removed episodes are kept in the trash before they're permanently deleted.
+	TrashAge time.Duration
+
+	// DownloadOrder is the order to download new episodes in, unless the subscription sets its own.
+	DownloadOrder string
+
+	// Genre is the genre written to each episode's metadata, unless the subscription sets its own. "category" uses the
+	// show's iTunes category.
+	Genre string
+
+	// EnclosureMode decides which enclosures to download for items that have more than one.
+	EnclosureMode string
+
+	// StripTrackers signals whether or not we will remove analytics prefixes from the start of enclosure URLs.
+	StripTrackers bool
+
+	// NoExternal signals whether or not we will keep from contacting any host other than the feed's and the enclosures'.
+	NoExternal bool
+
+	// NotesFormat is the format to save each episode's show notes in, or "" to not save them.
+	NotesFormat string
+
+	// UpdatedMode is what to do with episodes that the publisher changed after they were downloaded.
+	UpdatedMode string
+
+	// EpisodeFilter selects the episodes that syncs, retag, device-sync, and export-library work with, or nil for all
+	// of them.
+	EpisodeFilter *Filter
+
+	// SaveAttachments signals whether or not we will download the PDFs linked in each episode's show notes.
+	SaveAttachments bool
+
+	// SaveChapters signals whether or not we will write each episode's chapters to a JSON file next to it.
+	SaveChapters bool
+
+	// Bookmarks is where each episode's playback position is kept alongside the state, or "" to only keep it in the
+	// state.
+	Bookmarks string
+
+	// Checksums signals whether or not we will keep a SHA256SUMS file in each show's directory.
+	Checksums bool
+
+	// FilenameTemplate describes where to save each episode under its show's directory, or nil for the default naming.
+	FilenameTemplate *template.Template
+
+	// Grouping holds the templates for the grouping and sorting frames.
+	Grouping GroupingOptions
+
+	// TitleOptions describes how to clean up episode titles before they're used for filenames and metadata.
+	TitleOptions TitleCleanup
+
+	// WriteTotals signals whether or not to write the number of episodes and seasons with each episode's numbers.
+	WriteTotals bool
+
+	// YearDirs is the number of episodes a feed needs before its episodes are saved in a directory for each year, or 0
+	// to never use year directories.
+	YearDirs int
+
+	// ScanDepth is how many levels of subdirectories in each show's directory to scan for episodes, or -1 for all of
+	// them.
+	ScanDepth int
+
+	// Ignore is the list of glob patterns for paths in each show's directory to leave out of scans.
+	Ignore IgnorePatterns
+
+	// TagCache remembers the titles read from local files' tags between syncs.
+	TagCache *TagIndex
+
+	// SummaryPath is where to write the JSON summary of each sync, "-" for stdout, or "" for no summary.
+	SummaryPath string
+
+	// DateZone is the time zone that dates are converted to before they're written to metadata, or nil to keep each
+	// date in the zone the feed gave it.
+	DateZone *time.Location
+
+	// TitleDistance is how many characters a local episode's title can differ from the feed's title and still be
+	// considered the same episode, or 0 to only allow differences in case, spacing, and punctuation style.
+	TitleDistance int
+
+	// ConflictPolicy decides what to do about episodes that might already be downloaded.
+	ConflictPolicy string
+
+	// ArtworkMode decides whether each episode's artwork is embedded in its metadata, saved next to it, or left out.
+	ArtworkMode string
+
+	// Pictures is the list of pictures to embed in each episode's metadata, or empty to only embed the episode's artwork
+	// as the front cover.
+	Pictures PictureRules
+
+	// AssumeYes signals whether or not we will answer yes to all confirmations.
+	AssumeYes bool
+
+	// SizePolicy decides how the size of downloaded episodes is validated.
+	SizePolicy LengthPolicy
+
+	// KeepOriginal signals whether or not we will save a copy of each episode exactly as it was downloaded, before its
+	// tag is rewritten.
+	KeepOriginal bool
+
+	// CleanTags signals whether or not we will discard the frames in each episode's published tag and only write our own.
+	CleanTags bool
+
+	// StrictTags signals whether or not we will fail downloads whose published tags have frames that can't be read.
+	StrictTags bool
+
+	// StateDB is the record of the library's downloads.
+	StateDB *State
+
+	// ActiveProfile is the profile whose config and data are in use.
+	ActiveProfile Profile
 )
 
 func main() {
@@ -26,59 +197,654 @@ func main() {
 	numArg := flag.String("n", "", "Optional. Episode number to download. If podcast also has season, specify the episode like this: seasonNum-episodeNum, e.g. 3-5 to download episode 5 of season 3.")
 	logArg := flag.String("l", "", "Optional. Path to log, for writing all debug and non-debug statements")
 	minWidthArg := flag.Int("m", 0, "Optional. Minimum width of digits for episode number in filename.")
+	logAppendFlag := flag.Bool("log-append", false, "Optional. Append to the log file instead of overwriting it")
+	logSizeArg := flag.String("log-size", "", "Optional. Rotate the log file once it reaches this size, e.g. 10M")
+	logAgeArg := flag.Duration("log-age", 0, "Optional. Rotate the log file once it is this old, e.g. 168h")
+	logKeepArg := flag.Int("log-keep", 5, "Optional. Number of rotated log files to keep")
+	configArg := flag.String("c", "", "Optional. Path to config file with list of subscriptions (default "+DefaultConfigPath()+")")
+	profileArg := flag.String("profile", "", "Optional. Name of profile to use, for keeping separate configs and libraries")
+	lengthPolicyArg := flag.String("length-policy", PolicyTrustServer, "Optional. How to validate the size of downloads: trust-server, trust-feed, or a tolerance percentage, e.g. 5%")
+	flag.BoolVar(&KeepOriginal, "keep-original", false, "Optional. Save a copy of each episode exactly as it was downloaded next to it, as EpisodeName.mp3.orig, for comparing against the retagged file")
+	flag.BoolVar(&CleanTags, "clean-tags", false, "Optional. Discard the frames in each episode's published tag (except ones needed for the audio, like ReplayGain) and only write the ones from the feed")
+	flag.BoolVar(&StrictTags, "strict-tags", false, "Optional. Fail downloads whose published tags have frames that can't be read (parse errors, unknown encodings, or invalid IDs)")
+	playlistArg := flag.String("playlist", "", "Optional. Write a playlist of each show's episodes after syncing, in this format: m3u or pls")
+	flag.BoolVar(&ShowIndex, "index", false, "Optional. Keep an INDEX.md in each show's directory listing the downloaded episodes with their dates, durations, and descriptions")
+	presetArg := flag.String("preset", "", "Optional. Lay out the library for a media server: jellyfin or plex")
+	flag.StringVar(&AudiobookshelfFlags.URL, "abs-url", "", "Optional. URL of Audiobookshelf server to notify after new downloads")
+	flag.StringVar(&AudiobookshelfFlags.Token, "abs-token", "", "Optional. API token for the Audiobookshelf server")
+	flag.StringVar(&AudiobookshelfFlags.Library, "abs-library", "", "Optional. ID of the Audiobookshelf library to rescan")
+	flag.StringVar(&SizeUnits, "units", UnitsBinary, "Optional. Units to show sizes in: binary (MiB) or si (MB)")
+	maxFeedSizeArg := flag.String("max-feed-size", "50M", "Optional. Largest RSS feed to read, e.g. 100M, or 0 for no limit")
+	maxImageSizeArg := flag.String("max-image-size", "20M", "Optional. Largest artwork image to download, e.g. 5M, or 0 for no limit")
+	maxEpisodeSizeArg := flag.String("max-episode-size", "10G", "Optional. Largest episode or attachment to download, e.g. 2G, or 0 for no limit")
+	maxTagSizeArg := flag.String("max-tag-size", "32M", "Optional. Largest episode tag to hold in memory, e.g. 8M. Larger tags are kept as published instead of being retagged")
+	slowSpeedArg := flag.String("slow-speed", "", "Optional. Warn about downloads whose average speed is below this per second, e.g. 200K")
+	flag.IntVar(&HostConcurrency, "host-concurrency", 2, "Optional. Maximum number of simultaneous requests to any one host")
+	flag.IntVar(&Attempts, "attempts", 3, "Optional. Number of times to try downloading each episode before giving up on it")
+	flag.IntVar(&CircuitThreshold, "circuit-threshold", 5, "Optional. Number of server errors in a row from one host after which it's skipped for a while, or 0 to never skip hosts")
+	flag.DurationVar(&CircuitCooldown, "circuit-cooldown", 10*time.Minute, "Optional. How long to skip a host for after too many server errors")
+	ipv4Flag := flag.Bool("ipv4", false, "Optional. Only connect to hosts over IPv4")
+	ipv6Flag := flag.Bool("ipv6", false, "Optional. Only connect to hosts over IPv6")
+	flag.StringVar(&TLS.CACert, "ca-cert", "", "Optional. PEM file of extra certificate authorities to trust, e.g. for a proxy or a private CA")
+	flag.StringVar(&TLS.ClientCert, "client-cert", "", "Optional. PEM file of the client certificate to present to servers that ask for one")
+	flag.StringVar(&TLS.ClientKey, "client-key", "", "Optional. PEM file of the client certificate's private key, if it isn't in -client-cert")
+	flag.BoolVar(&TLS.Insecure, "insecure", false, "Optional. Don't verify servers' TLS certificates (dangerous)")
+	flag.Var(&Resolve, "resolve", "Optional. Comma-separated hosts to connect to at a fixed address instead of the one from DNS, as host=address")
+	flag.BoolVar(&Mirror, "mirror", false, "Optional. Remove local episodes that are no longer in the feed, after confirming")
+	flag.DurationVar(&TrashAge, "trash-age", 30*24*time.Hour, "Optional. How long to keep removed episodes in the trash, or 0 to keep them forever")
+	flag.StringVar(&DownloadOrder, "order", OrderOldest, "Optional. Order to download new episodes in: oldest or newest")
+	flag.StringVar(&Genre, "genre", DefaultGenre, "Optional. Genre to write to each episode's metadata, or \"category\" to use the show's iTunes category")
+	flag.StringVar(&EnclosureMode, "enclosures", EnclosuresFirst, "Optional. Which enclosures to download for items with more than one: first, all, or a MIME type pattern, e.g. audio/*")
+	flag.BoolVar(&StripTrackers, "strip-trackers", false, "Optional. Remove tracking prefixes (Podtrac, Chartable, etc.) from enclosure URLs and download from the real URL")
+	flag.BoolVar(&NoExternal, "no-external", false, "Optional. Privacy mode: only contact each show's feed host and enclosure hosts (implies -strip-trackers)")
+	flag.BoolVar(&Content.BlockExplicit, "skip-explicit", false, "Optional. Don't download episodes that the feed marks as explicit (itunes:explicit)")
+	flag.StringVar(&NotesFormat, "notes", "", "Optional. Save each episode's show notes next to it, in this format: html or md")
+	flag.StringVar(&UpdatedMode, "updated", UpdatedFlag, "Optional. What to do with downloaded episodes whose enclosure URL, length, or pubDate the publisher changed: flag, redownload, or ignore")
+	filterArg := flag.String("filter", "", "Optional. Only work with the episodes that match this expression, e.g. 'number > 100 && title contains \"Live\"'")
+	flag.BoolVar(&SaveAttachments, "attachments", false, "Optional. Download the PDFs linked in each episode's show notes")
+	flag.BoolVar(&SaveChapters, "chapters", false, "Optional. Save each episode's chapters next to it as JSON, from the feed or the file's CHAP frames")
+	flag.StringVar(&Bookmarks, "bookmarks", "", "Optional. Also keep each episode's playback position with the episode, for device-sync: tag (a TXXX:BOOKMARK frame) or sidecar (a .bookmark file)")
+	flag.BoolVar(&Checksums, "checksums", false, "Optional. Keep a SHA256SUMS file in each show's directory with the checksum of every episode")
+	filenameArg := flag.String("filename", "", "Optional. Template for each episode's path in its show's directory, e.g. \"{{.Language}}/{{.Prefix}} {{.Title}}\"")
+	groupArg := flag.String("group", "", "Optional. Template for each episode's content group (TIT1), e.g. \"Podcasts\"")
+	flag.BoolVar(&Grouping.Compilation, "compilation", false, "Optional. Mark each episode as part of a compilation (TCMP)")
+	sortAlbumArg := flag.String("sort-album", "", "Optional. Template for each episode's album sort order (TSOA), e.g. \"{{.Show}}\"")
+	sortArtistArg := flag.String("sort-artist", "", "Optional. Template for each episode's artist sort order (TSOP), e.g. \"{{.Artist}}\"")
+	flag.BoolVar(&TitleOptions.StripShow, "strip-show-name", false, "Optional. Remove the show's name from the start of episode titles, e.g. \"ShowName - \"")
+	flag.BoolVar(&TitleOptions.StripNumber, "strip-episode-number", false, "Optional. Remove episode numbers from the end of episode titles, e.g. \" | Ep. 45\"")
+	flag.Var(&TitleOptions.Rules, "title-replace", "Optional. Search and replace in episode titles with a regular expression, as pattern=>replacement (can be given more than once)")
+	flag.BoolVar(&WriteTotals, "totals", false, "Optional. Write episode and season numbers with their totals, e.g. 42/317")
+	flag.IntVar(&YearDirs, "year-dirs", 0, "Optional. Save episodes in a directory for each year (YYYY/) for shows with at least this many episodes")
+	flag.IntVar(&ScanDepth, "scan-depth", -1, "Optional. Levels of subdirectories to scan for episodes in each show's directory, or -1 for all of them")
+	flag.Var(&Ignore, "ignore", "Optional. Comma-separated glob patterns for paths in each show's directory to leave out of scans")
+	flag.StringVar(&SummaryPath, "summary", "", "Optional. Write a JSON summary of each sync (including every failure) to this file, or - for stdout")
+	timezoneArg := flag.String("timezone", "", "Optional. Time zone to convert publish dates to before writing metadata: UTC, Local, or a zone name such as America/Denver")
+	flag.IntVar(&TitleDistance, "title-distance", 0, "Optional. Number of characters a local title can differ from the feed's title and still match")
+	flag.StringVar(&ConflictPolicy, "conflicts", ConflictAsk, "Optional. What to do about episodes that might already be downloaded: ask, skip, or download")
+	flag.StringVar(&ArtworkMode, "artwork", ArtworkEmbed, "Optional. What to do with each episode's artwork: embed, external (save it next to the episode), or none")
+	flag.Var(&Pictures, "pictures", "Optional. Comma-separated pictures to embed, as source=type, e.g. episode=cover-front,show=other-icon")
+	noArtworkFlag := flag.Bool("no-artwork", false, "Optional. Don't embed the episode or show artwork in each episode's metadata (same as -artwork none)")
+	flag.BoolVar(&AssumeYes, "y", false, "Optional. Answer yes to all confirmations")
+	feedCacheFlag := flag.Bool("feed-cache", false, "Optional. Save a copy of each fetched RSS feed, for inspecting with diff-feed")
 	debugFlag := flag.Bool("v", false, "Enable debug mode")
+	flag.Usage = usage
 	flag.Parse()
 
+	if err := setFlagsFromEnv(flag.CommandLine); err != nil {
+		Log(err)
+		os.Exit(1)
+	}
+
 	if *debugFlag {
 		DebugMode = true
 		Debug("Debug mode enabled")
 	}
 
 	if *logArg != "" {
-		if file, err := os.Create(*logArg); err != nil {
+		opts := LogOptions{Append: *logAppendFlag, MaxAge: *logAgeArg, Keep: *logKeepArg}
+		if size, err := ParseSize(*logSizeArg); err != nil {
+			Log("Invalid log size:", err)
+			os.Exit(1)
+		} else {
+			opts.MaxSize = size
+		}
+
+		if writer, err := NewLogWriter(*logArg, opts, os.Args); err != nil {
 			Log("Error creating log file:", err)
 		} else {
-			LogFile = file
+			LogFile = writer
 			defer LogFile.Close()
 		}
 	}
 
+	FeedCache = *feedCacheFlag
+
+	if err := ValidatePlaylistFormat(*playlistArg); err != nil {
+		Log(err)
+		os.Exit(1)
+	}
+	PlaylistFormat = *playlistArg
+
+	if err := ValidateOrder(DownloadOrder); err != nil {
+		Log(err)
+		os.Exit(1)
+	}
+
+	if err := ValidateGenre(Genre); err != nil {
+		Log(err)
+		os.Exit(1)
+	}
+
+	if err := ValidateConflictPolicy(ConflictPolicy); err != nil {
+		Log(err)
+		os.Exit(1)
+	}
+
+	if err := ValidateEnclosureMode(EnclosureMode); err != nil {
+		Log(err)
+		os.Exit(1)
+	}
+
+	if err := ValidateNotesFormat(NotesFormat); err != nil {
+		Log(err)
+		os.Exit(1)
+	}
+
+	if err := ValidateUpdatedMode(UpdatedMode); err != nil {
+		Log(err)
+		os.Exit(1)
+	}
+
+	if f, err := ParseEpisodeFilter(*filterArg); err != nil {
+		Log(err)
+		os.Exit(1)
+	} else {
+		EpisodeFilter = f
+	}
+
+	if err := ValidateBookmarksMode(Bookmarks); err != nil {
+		Log(err)
+		os.Exit(1)
+	}
+
+	switch {
+	case *ipv4Flag && *ipv6Flag:
+		Log("Only one of -ipv4 and -ipv6 can be used")
+		os.Exit(1)
+	case *ipv4Flag:
+		IPVersion = IPv4
+	case *ipv6Flag:
+		IPVersion = IPv6
+	}
+
+	if err := ValidateUnits(SizeUnits); err != nil {
+		Log(err)
+		os.Exit(1)
+	}
+
+	if speed, err := ParseSize(*slowSpeedArg); err != nil {
+		Log(err)
+		os.Exit(1)
+	} else {
+		SlowSpeed = speed
+	}
+
+	for _, limit := range []struct {
+		arg  *string
+		size *int
+	}{
+		{maxFeedSizeArg, &MaxFeedSize},
+		{maxImageSizeArg, &MaxImageSize},
+		{maxEpisodeSizeArg, &MaxEpisodeSize},
+	} {
+		size, err := ParseSize(*limit.arg)
+		if err != nil {
+			Log(err)
+			os.Exit(1)
+		}
+		*limit.size = size
+	}
+
+	if size, err := ParseSize(*maxTagSizeArg); err != nil {
+		Log(err)
+		os.Exit(1)
+	} else if size <= 0 {
+		Log("Invalid tag size limit:", *maxTagSizeArg)
+		os.Exit(1)
+	} else {
+		MaxTagSize = size
+	}
+
+	if err := ConfigureTLS(TLS); err != nil {
+		Log(err)
+		os.Exit(1)
+	}
+
+	if *noArtworkFlag {
+		ArtworkMode = ArtworkNone
+	}
+	if err := ValidateArtworkMode(ArtworkMode); err != nil {
+		Log(err)
+		os.Exit(1)
+	}
+
+	if tmpl, err := ParseFilenameTemplate(*filenameArg); err != nil {
+		Log(err)
+		os.Exit(1)
+	} else {
+		FilenameTemplate = tmpl
+	}
+
+	for _, t := range []struct {
+		name string
+		text string
+		tmpl **template.Template
+	}{
+		{"group", *groupArg, &Grouping.Group},
+		{"sort-album", *sortAlbumArg, &Grouping.SortAlbum},
+		{"sort-artist", *sortArtistArg, &Grouping.SortArtist},
+	} {
+		tmpl, err := ParseTemplate(t.name, t.text)
+		if err != nil {
+			Log(err)
+			os.Exit(1)
+		}
+		*t.tmpl = tmpl
+	}
+
+	if err := ValidatePreset(*presetArg); err != nil {
+		Log(err)
+		os.Exit(1)
+	}
+	Preset = *presetArg
+
+	if policy, err := ParseLengthPolicy(*lengthPolicyArg); err != nil {
+		Log(err)
+		os.Exit(1)
+	} else {
+		SizePolicy = policy
+	}
+
 	if *minWidthArg > 0 {
 		PrefixMinWidth = *minWidthArg
 	}
 
-	if *urlArg == "" {
-		Log("No show specified")
-		fmt.Println("Usage:")
-		flag.PrintDefaults()
+	profile, err := NewProfile(*profileArg)
+	if err != nil {
+		Log(err)
 		os.Exit(1)
 	}
+	ActiveProfile = profile
+	Debug("Using", ActiveProfile, "profile")
+
+	// If a config file was explicitly requested, then it must load. Otherwise, we'll use the profile's config only if
+	// it exists. (A named profile is expected to have one, though.)
+	configPath := *configArg
+	if configPath == "" {
+		if _, err := os.Stat(profile.ConfigPath()); err == nil {
+			configPath = profile.ConfigPath()
+		} else if profile.Name != "" {
+			Log("No config found for profile", profile, "at", profile.ConfigPath())
+			os.Exit(1)
+		}
+	}
 
-	u, err := url.Parse(strings.ToLower(*urlArg))
+	// Checking the config has to work even when the config can't be loaded.
+	if flag.Arg(0) == "config" {
+		if err := runConfig(configPath, flag.Args()[1:]); err == errUsage {
+			usage()
+			os.Exit(1)
+		} else if err != nil {
+			Log(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	config, err := loadConfig(configPath)
 	if err != nil {
-		Log("Invalid URL:", err)
-		fmt.Println("Usage:")
-		flag.PrintDefaults()
+		Log(err)
 		os.Exit(1)
 	}
-	show := Show{URL: u}
 
-	// Validate (or create) the download directory.
-	dir := path.Clean(*dirArg)
-	if dir == "" {
-		Log("No download directory specified")
-		fmt.Println("Usage:")
-		flag.PrintDefaults()
+	// The profile's content rules, destinations, and time zone come from its config.
+	cmdline := configArgs{blockExplicit: Content.BlockExplicit, timezone: *timezoneArg}
+	if err := cmdline.apply(config); err != nil {
+		Log(err)
 		os.Exit(1)
 	}
-	if err := ValidateDir(dir); err != nil {
+
+	// Without the state, most commands can carry on as if the library had no history, recognizing what's already
+	// downloaded by the files' tags. The commands that are all about the state can't.
+	if cmd := flag.Arg(0); cmd != "repair-state" {
+		if state, err := OpenState(StatePath()); err == nil {
+			StateDB = state
+		} else if needsState(cmd) {
+			Log(err)
+			Log("Run \"getcast repair-state\" to rebuild the state from the library")
+			os.Exit(1)
+		} else {
+			warnNoState(err)
+		}
+	}
+
+	TagCache = LoadTagIndex(TagIndexPath())
+
+	// The daemon handles its own signals.
+	if flag.Arg(0) != "daemon" {
+		trapInterrupts()
+	}
+
+	switch cmd := flag.Arg(0); cmd {
+	case "", "sync":
+		var args []string
+		if flag.NArg() > 0 {
+			args = flag.Args()[1:]
+		}
+		err = runSync(config, *urlArg, *dirArg, *numArg, args)
+	case "adopt":
+		err = runAdopt(config, *urlArg, flag.Args()[1:])
+	case "archive":
+		err = runArchive(config, *dirArg, flag.Args()[1:])
+	case "backup":
+		err = runBackup(configPath, config, *dirArg, *logArg, flag.Args()[1:])
+	case "bookmark":
+		err = runBookmark(StateDB, config, flag.Args()[1:])
+	case "daemon":
+		err = runDaemon(configPath, config, *dirArg, cmdline)
+	case "device-sync":
+		err = runDeviceSync(config, *dirArg, flag.Args()[1:])
+	case "diff-feed":
+		feedURL := *urlArg
+		if feedURL == "" {
+			feedURL = flag.Arg(1)
+		}
+		err = runDiffFeed(feedURL)
+	case "extract-art":
+		err = runExtractArt(flag.Args()[1:])
+	case "export-library":
+		err = runExportLibrary(config, *dirArg, flag.Args()[1:])
+	case "find":
+		err = runFind(config, *dirArg, flag.Args()[1:])
+	case "import-played":
+		err = runImportPlayed(StateDB, flag.Args()[1:])
+	case "migrate":
+		err = runMigrate(configPath, flag.Args()[1:])
+	case "pause":
+		err = runPause(StateDB, config, flag.Args()[1:], true)
+	case "resume":
+		err = runPause(StateDB, config, flag.Args()[1:], false)
+	case "played":
+		err = runPlayed(StateDB, config, flag.Args()[1:], true)
+	case "profiles":
+		err = runProfiles()
+	case "repair-state":
+		err = runRepairState(config, *dirArg, flag.Args()[1:])
+	case "restore":
+		err = runRestore(config, *dirArg, flag.Args()[1:])
+	case "retag":
+		err = runRetag(config, *urlArg, *dirArg)
+	case "stats":
+		err = runStats(StateDB)
+	case "tag":
+		err = runTag(config, *dirArg, flag.Args()[1:])
+	case "unplayed":
+		err = runPlayed(StateDB, config, flag.Args()[1:], false)
+	default:
+		Log("Unknown command:", cmd)
+		usage()
+		os.Exit(1)
+	}
+
+	if err == errUsage {
+		usage()
+		os.Exit(1)
+	} else if err == errInterrupted {
+		flushLog()
+		os.Exit(130)
+	} else if err != nil {
 		Log(err)
 		os.Exit(1)
 	}
+}
+
+// errUsage signals that the program was called incorrectly and that the usage should be printed.
+var errUsage = fmt.Errorf("invalid usage")
+
+// usage prints the available commands and options.
+func usage() {
+	fmt.Println("Usage:")
+	fmt.Println("  getcast [options] [command]")
+	fmt.Println("  getcast -profile kids sync")
+	fmt.Println()
+	fmt.Println("Commands:")
+	fmt.Println("  sync [-group name]")
+	fmt.Println("             Sync the show specified with -u, or all subscriptions in the config (default), or only the")
+	fmt.Println("             ones in the comma-separated groups")
+	fmt.Println("  adopt <showdir> [-u feed] [-dry-run]")
+	fmt.Println("             Record the episodes already in the directory as downloaded, matching them to the feed")
+	fmt.Println("  archive -show <show> -season <n> [-format tar.gz|tar.zst|zip] [-o dir] [-remove] [-force] [-dry-run]")
+	fmt.Println("             Bundle a finished season of the show into an archive with a manifest, for cold storage")
+	fmt.Println("  backup [-incremental] <file>")
+	fmt.Println("             Back up the config, the state, and the logs to a tar file")
+	fmt.Println("  backup -restore <file>...")
+	fmt.Println("             Restore the backups, a full one first and then any incremental ones, in order")
+	fmt.Println("  bookmark <show> <episode> <position>")
+	fmt.Println("             Save where playback of the episode left off, e.g. 1:02:03, or 0 to clear it")
+	fmt.Println("  config check [-offline]")
+	fmt.Println("             Check the config for problems, including whether each feed is reachable")
+	fmt.Println("  daemon     Keep all subscriptions in the config synced")
+	fmt.Println("  device-sync <devicedir> [-budget size] [-per-show n] [-dry-run]")
+	fmt.Println("             Fill a portable player with the newest unplayed episodes, removing old ones from it")
+	fmt.Println("  diff-feed  Show what changed between the last two cached fetches of the feed at -u")
+	fmt.Println("  export-library [-format csv|json] [-o file]")
+	fmt.Println("             Write the information about every episode in the library as CSV or JSON")
+	fmt.Println("  extract-art <file> [-o image]")
+	fmt.Println("             Save the artwork embedded in the audio file's metadata")
+	fmt.Println("  find [-rebuild] <words>")
+	fmt.Println("             Search the titles, descriptions, show notes, and transcripts of the episodes in the library")
+	fmt.Println("             and print the matching files")
+	fmt.Println("  import-played -from gpodder|itunes <file>")
+	fmt.Println("             Mark the episodes that another player has played, from gPodder episode actions or an iTunes")
+	fmt.Println("             library export")
+	fmt.Println("  migrate -from gpodder|castget|podget [-dry-run] [path]")
+	fmt.Println("             Add another podcatcher's subscriptions to the config and its downloads to the state")
+	fmt.Println("  pause [show]")
+	fmt.Println("             Skip the show (by title or feed URL) in scheduled syncs, or all shows if none is given")
+	fmt.Println("  played <show> [episode]")
+	fmt.Println("             Mark the episode (by title or GUID) as played, or every episode of the show if none is given")
+	fmt.Println("  profiles   List all profiles")
+	fmt.Println("  repair-state [-dry-run]")
+	fmt.Println("             Rebuild the state from the tags of the episodes in the library and the season archives")
+	fmt.Println("  resume [show]")
+	fmt.Println("             Undo pause for the show, or for everything if no show is given")
+	fmt.Println("  restore <show> [pattern]")
+	fmt.Println("             List the show's trashed episodes, or restore the ones matching the pattern")
+	fmt.Println("  retag      Update the episode and season totals of episodes already downloaded")
+	fmt.Println("  stats      Show download statistics for every show")
+	fmt.Println("  tag -set ID=value... [-where filter] [-dry-run] [path...]")
+	fmt.Println("             Change a frame of the metadata of every downloaded episode that matches the filter")
+	fmt.Println("  unplayed <show> [episode]")
+	fmt.Println("             Undo played for the episode, or for every episode of the show if none is given")
+	fmt.Println()
+	fmt.Println("Options:")
+	flag.PrintDefaults()
+	fmt.Println()
+	fmt.Println("Every option can also be set with an environment variable, e.g. GETCAST_DIR for -d or GETCAST_LOG_SIZE")
+	fmt.Println("for -log-size. Subscriptions can be set with GETCAST_FEEDS and the daemon interval with GETCAST_INTERVAL.")
+}
+
+// loadConfig reads the config file, if there is one, and then applies any config settings from the environment. This
+// returns nil if there is no config to use.
+func loadConfig(path string) (*Config, error) {
+	var config *Config
+	if path != "" {
+		c, err := LoadConfig(path)
+		if err != nil {
+			return nil, err
+		}
+		config = c
+	}
+
+	return applyEnvConfig(config)
+}
+
+// configArgs holds the command-line flags that are combined with the settings in the config file, so that they can be
+// combined again when the daemon reloads the config.
+type configArgs struct {
+	blockExplicit bool   // -skip-explicit
+	timezone      string // -timezone
+}
+
+// apply sets the globals that come from the config: the content rules, the destinations, and the time zone. The
+// command line wins over the config's time zone, and can only make the content rules stricter. If the time zone is
+// invalid, nothing is changed.
+func (a configArgs) apply(config *Config) error {
+	timezone := a.timezone
+	if timezone == "" && config != nil {
+		timezone = config.TimeZone
+	}
+	loc, err := ParseTimeZone(timezone)
+	if err != nil {
+		return err
+	}
+	DateZone = loc
+
+	if config != nil {
+		Content = config.Content
+		Content.BlockExplicit = Content.BlockExplicit || a.blockExplicit
+		Destinations = config.Destinations
+	}
+
+	return nil
+}
+
+// runSync syncs either the show at the provided URL or, if no URL was given, every subscription in the config. With
+// -group, only the subscriptions in those groups are synced.
+func runSync(config *Config, urlArg string, dirArg string, numArg string, args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ContinueOnError)
+	groupArg := fs.String("group", "", "Optional. Comma-separated groups of subscriptions to sync")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	if urlArg == "" && (config == nil || len(config.Subscriptions) == 0) {
+		Log("No show specified")
+		return errUsage
+	}
+
+	var groups []string
+	for _, group := range strings.Split(*groupArg, ",") {
+		if group = strings.TrimSpace(group); group == "" {
+			continue
+		}
+		if urlArg != "" || config == nil {
+			Log("Groups can only be synced from the config")
+			return errUsage
+		}
+		if _, ok := config.group(group); !ok && !config.hasGroup(group) {
+			return fmt.Errorf("unknown group: %v", group)
+		}
+		groups = append(groups, group)
+	}
+
+	dir, err := downloadDir(config, dirArg)
+	if err != nil {
+		return err
+	}
+
+	if urlArg != "" {
+		u, err := ParseFeedArg(urlArg)
+		if err != nil {
+			Log("Invalid URL:", err)
+			return errUsage
+		}
+		summary := NewSummary()
+		show := &Show{URL: u}
+		good, err := syncShow(show, dir, numArg)
+		summary.Add(show, good, err)
+		summary.Finish()
+		writeRecentPlaylist(dir)
+		if good > 0 {
+			notifyAudiobookshelf(config)
+		}
+		if err == errInterrupted {
+			Log("Stopped early after downloading", good, "episodes")
+		}
+		return err
+	}
+
+	failed := 0
+	downloaded := 0
+	summary := NewSummary()
+	for _, sub := range config.Prioritized() {
+		if Interrupted() {
+			break
+		}
+
+		if StateDB.IsPaused(sub.URL) {
+			Log("Skipping paused show", sub.URL)
+			continue
+		}
+
+		if len(groups) > 0 && !sub.InGroup(groups) {
+			Debug("Skipping", sub.URL, "outside of groups", groups)
+			continue
+		}
+
+		show, err := NewShow(sub)
+		if err != nil {
+			Log(err)
+			summary.AddError("", sub.URL, err)
+			failed++
+			continue
+		}
+		good, err := syncShow(show, dir, "")
+		downloaded += good
+		summary.Add(show, good, err)
+		if err == errInterrupted {
+			break
+		} else if errors.Is(err, ErrDiskFull) {
+			// There's no point in trying the other shows.
+			Log(err)
+			failed++
+			break
+		} else if errors.Is(err, ErrCircuitOpen) {
+			// The show will be synced once its host is back, so it isn't counted as a failure.
+			Log(err)
+		} else if err != nil {
+			Log(err)
+			failed++
+		}
+	}
+	summary.Finish()
+
+	writeRecentPlaylist(dir)
+	if downloaded > 0 {
+		notifyAudiobookshelf(config)
+	}
 
-	// And sync the show.
+	if Interrupted() {
+		Log("Stopped early after downloading", downloaded, "episodes")
+		return errInterrupted
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to sync %v of %v shows", failed, len(config.Subscriptions))
+	}
+	return nil
+}
+
+// downloadDir determines the main download directory (the command line takes precedence over the config) and makes
+// sure that it's usable.
+func downloadDir(config *Config, dirArg string) (string, error) {
+	dir := dirArg
+	if dir == "" && config != nil {
+		dir = config.Dir
+	}
+
+	if dir == "" {
+		Log("No download directory specified")
+		return "", errUsage
+	}
+	dir = filepath.Clean(dir)
+
+	// Validate (or create) the download directory.
+	if err := ValidateDir(dir); err != nil {
+		return "", err
+	}
+
+	return dir, nil
+}
+
+// syncShow syncs the show into the main download directory and reports the results. This returns the number of
+// episodes that were downloaded.
+func syncShow(show *Show, dir string, numArg string) (int, error) {
 	Log("Beginning sync process for", show.URL)
-	good, bad, err := show.Sync(dir, *numArg)
+	if root := show.options().Dir; root != "" {
+		dir = filepath.Clean(root)
+	}
+	good, bad, err := show.Sync(dir, numArg)
 	Log("")
 	Log("Synced", good, "episodes")
 	switch bad {
@@ -90,8 +856,28 @@ func main() {
 		Log("Failed to sync", bad, "episodes")
 	}
 
-	if err != nil {
-		Log(err)
-		os.Exit(1)
+	if show.Dir != "" {
+		CopyToDestinations(show, dir)
+	}
+
+	if PlaylistFormat != "" && show.Dir != "" {
+		if err := WritePlaylist(show.Dir, PlaylistFormat); err != nil {
+			Log("Error writing playlist:", err)
+		}
+	}
+
+	if ShowIndex && show.Dir != "" {
+		if err := WriteIndex(show.Dir, show.Title); err != nil {
+			Log("Error writing index:", err)
+		}
+	}
+
+	return good, err
+}
+
+// writeRecentPlaylist regenerates the global playlist of new episodes, if enabled.
+func writeRecentPlaylist(dir string) {
+	if err := WriteRecentPlaylist(dir, PlaylistFormat); err != nil {
+		Log("Error writing playlist:", err)
 	}
 }
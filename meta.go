@@ -3,9 +3,11 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"golang.org/x/text/encoding/unicode"
 	"io"
+	"math"
 	"strings"
 )
 
@@ -16,6 +18,8 @@ type Meta struct {
 	noMeta     bool          // whether or not the file has any metadata
 	readFrames bool          // whether or not the metadata frames have been read and parsed.
 	frames     []Frame       // list of frames
+	issues     TagIssues     // frames that were skipped because they couldn't be read
+	quiet      bool          // whether or not to keep the frames out of the debug output (they're still logged)
 }
 
 // Frame is used to store information about a metadata frame.
@@ -35,6 +39,16 @@ func NewMeta(file []byte) *Meta {
 	return m
 }
 
+// debug prints the message like Debug, unless the metadata is quiet, in which case the message only goes to the log.
+func (m *Meta) debug(a ...interface{}) {
+	if m.quiet {
+		logOnly(a...)
+		return
+	}
+
+	Debug(a...)
+}
+
 // Write buffers metadata into the internal buffer. When the metadata has been completely written, Write will stop
 // writing to the buffer and return (n, io.EOF), with n designating how many bytes were consumed in this operation.
 func (m *Meta) Write(p []byte) (int, error) {
@@ -60,11 +74,17 @@ func (m *Meta) Write(p []byte) (int, error) {
 		// Need more data.
 		return len(p), nil
 	}
+	if length > maxTagSize() {
+		// The size is declared up front, so a corrupt or malicious file could otherwise have us buffer hundreds of
+		// megabytes. What's been buffered so far is left for the caller to pass through as is.
+		return len(p), fmt.Errorf("%w: %v declared", errTagTooLarge, Reduce(length))
+	}
 
 	if length == 0 {
-		// The file has data but not any metadata.
+		// The file has data but not any metadata, so there's nothing more to buffer.
 		m.buffer.Truncate(0)
-		return 0, nil
+		m.noMeta = true
+		return 0, io.EOF
 	}
 
 	if m.buffer.Len() <= length {
@@ -80,6 +100,80 @@ func (m *Meta) Write(p []byte) (int, error) {
 	return need, io.EOF
 }
 
+// id3HeaderSize is the size of the header at the start of an ID3v2 tag, which holds the size of the rest of the tag.
+const id3HeaderSize = 10
+
+// defaultMaxTagSize is the largest tag that will be read, in bytes, unless -max-tag-size says otherwise. Even tags with
+// several large images are well under this.
+const defaultMaxTagSize = 32 << 20
+
+// maxReadChunk is the most that is read from a stream at once while reading a tag, so that a tag that declares a large
+// size doesn't have its full size allocated before its bytes show up.
+const maxReadChunk = 64 << 10
+
+// errTagTooLarge is returned for tags larger than the limit.
+var errTagTooLarge = errors.New("tag is too large")
+
+// maxTagSize returns the largest tag that will be held in memory, in bytes.
+func maxTagSize() int {
+	if MaxTagSize > 0 {
+		return MaxTagSize
+	}
+
+	return defaultMaxTagSize
+}
+
+// ReadFrom reads the metadata from the start of the stream. Only the tag's header and frames are read, and the rest of
+// the stream (the audio) is left unread, so reading the tags of a large file costs no more than the tags themselves.
+// This returns the number of bytes read. An error is returned if the stream ends before the metadata does. If the stream
+// doesn't have any metadata and can seek, it's put back to where it started.
+func (m *Meta) ReadFrom(r io.Reader) (int64, error) {
+	if m == nil {
+		return 0, fmt.Errorf("invalid meta object")
+	}
+
+	var total int64
+	for !m.Buffered() {
+		// Until we have the header, we don't know how long the tag is, and until we have the "ID3" at the start of it,
+		// we don't know if there's a tag at all.
+		need := id3HeaderSize - m.Len()
+		if m.Len() < 3 {
+			need = 3 - m.Len()
+		} else if length := m.length(); length > maxTagSize() {
+			return total, fmt.Errorf("%w: %v declared", errTagTooLarge, Reduce(length))
+		} else if length > 0 {
+			need = length - m.Len()
+		}
+		if need > maxReadChunk {
+			need = maxReadChunk
+		}
+
+		chunk := make([]byte, need)
+		n, err := io.ReadFull(r, chunk)
+		total += int64(n)
+		if _, werr := m.Write(chunk[:n]); werr != nil && werr != io.EOF {
+			return total, werr
+		}
+		if err != nil {
+			if m.Buffered() {
+				break
+			}
+			return total, err
+		}
+	}
+
+	if m.noMeta && total > 0 {
+		if seeker, ok := r.(io.Seeker); ok {
+			if _, err := seeker.Seek(-total, io.SeekCurrent); err != nil {
+				return total, err
+			}
+			total = 0
+		}
+	}
+
+	return total, nil
+}
+
 // Buffered checks if all of the metadata for the episode's file has been fully buffered or not. If the file doesn't
 // have any metadata, then this will return true.
 func (m *Meta) Buffered() bool {
@@ -139,6 +233,15 @@ func (m *Meta) Version() byte {
 	return data[3]
 }
 
+// Issues returns the number of frames that were skipped while reading the metadata, by the reason they were skipped.
+func (m *Meta) Issues() TagIssues {
+	if m == nil || m.noMeta || !m.Buffered() {
+		return nil
+	}
+
+	return m.issues
+}
+
 // NumFrames returns the number of frames in the metadata. If multiple frames have the same frame ID, each instance of
 // the ID is counted separately.
 func (m *Meta) NumFrames() int {
@@ -174,7 +277,7 @@ func (m *Meta) SetValue(id string, value []byte, multiple bool) {
 	}
 
 	if (m.Version() == 2 && len(id) != 3) || (m.Version() != 2 && len(id) != 4) {
-		Debug("Invalid frame ID:", id)
+		m.debug("Invalid frame ID:", id)
 		return
 	}
 
@@ -192,7 +295,93 @@ func (m *Meta) SetValue(id string, value []byte, multiple bool) {
 	}
 
 	m.frames = append(m.frames, Frame{id, value})
-	Debug("Set frame", id, "to", string(value))
+	if isPictureFrame(id) {
+		m.debug("Set frame", id, "to", len(value), "bytes of picture data")
+	} else {
+		m.debug("Set frame", id, "to", string(value))
+	}
+}
+
+// RemoveValues removes all frames with the given frame ID from the metadata.
+func (m *Meta) RemoveValues(id string) {
+	if m == nil || !m.Buffered() {
+		return
+	}
+
+	var frames []Frame
+	for _, frame := range m.frames {
+		if frame.id != id {
+			frames = append(frames, frame)
+		}
+	}
+	m.frames = frames
+}
+
+// RemoveFramesExcept removes every frame from the metadata except the ones that keep reports should stay.
+func (m *Meta) RemoveFramesExcept(keep func(id string, value []byte) bool) {
+	if m == nil || !m.Buffered() {
+		return
+	}
+
+	var frames []Frame
+	for _, frame := range m.frames {
+		if keep(frame.id, frame.value) {
+			frames = append(frames, frame)
+		} else {
+			m.debug("Removing frame", frame.id)
+		}
+	}
+	m.frames = frames
+}
+
+// GetUserText returns the value of the user-defined text frame (TXXX, or TXX for ID3v2.2) with this description, or
+// "" if there isn't one.
+func (m *Meta) GetUserText(desc string) string {
+	for _, value := range m.GetValues(m.userTextID()) {
+		fields := bytes.SplitN(value, []byte{0x00}, 2)
+		if len(fields) == 2 && string(fields[0]) == desc {
+			return string(fields[1])
+		}
+	}
+
+	return ""
+}
+
+// SetUserText sets the value of the user-defined text frame with this description. Unlike SetValue, this only replaces
+// the frame with the same description, since a file can have any number of user-defined text frames.
+func (m *Meta) SetUserText(desc string, value string) {
+	if m == nil || !m.Buffered() {
+		return
+	}
+
+	m.RemoveUserText(desc)
+	m.SetValue(m.userTextID(), []byte(desc+"\x00"+value), true)
+}
+
+// RemoveUserText removes the user-defined text frame with this description, leaving the others alone.
+func (m *Meta) RemoveUserText(desc string) {
+	if m == nil || !m.Buffered() {
+		return
+	}
+
+	id := m.userTextID()
+	var frames []Frame
+	for _, frame := range m.frames {
+		if frame.id == id && bytes.HasPrefix(frame.value, []byte(desc+"\x00")) {
+			continue
+		}
+		frames = append(frames, frame)
+	}
+	m.frames = frames
+}
+
+// userTextID returns the ID of the user-defined text frame for this version of ID3.
+func (m *Meta) userTextID() string {
+	if m.Version() == 2 {
+		return "TXX"
+	}
+
+	return "TXXX"
 }
 
 // Build constructs the metadata for the episode's file. If the metadata cannot be constructed, this will return nil.
@@ -257,6 +446,13 @@ func (m *Meta) buildFrames(version byte) []byte {
 			// Write ID.
 			buf.WriteString(strings.ToUpper(frame.id))
 
+			// URL frames are plain ISO-8859-1 without an encoding byte, and binary frames carry their own.
+			if isURLFrame(frame.id) || isBinaryFrame(frame.id) {
+				buf.Write(writeLen(len(frame.value), version, false))
+				buf.Write(frame.value)
+				continue
+			}
+
 			// Write length. (+2 for encoding bytes around value.)
 			length := writeLen(len(frame.value)+2, version, false)
 			buf.Write(length)
@@ -275,6 +471,14 @@ func (m *Meta) buildFrames(version byte) []byte {
 			// Write ID.
 			buf.WriteString(strings.ToUpper(frame.id))
 
+			// URL frames are plain ISO-8859-1 without an encoding byte, and binary frames carry their own.
+			if isURLFrame(frame.id) || isBinaryFrame(frame.id) {
+				buf.Write(writeLen(len(frame.value), version, false))
+				buf.Write([]byte{0x00, 0x00})
+				buf.Write(frame.value)
+				continue
+			}
+
 			// Write length. (+2 for encoding bytes around value.)
 			length := writeLen(len(frame.value)+2, version, false)
 			buf.Write(length)
@@ -296,6 +500,46 @@ func (m *Meta) buildFrames(version byte) []byte {
 	return buf.Bytes()
 }
 
+// isURLFrame reports whether the frame is a URL link frame, like WOAF. The user-defined URL frame (WXXX) has an
+// encoding byte like the text frames do, so it doesn't count.
+func isURLFrame(id string) bool {
+	id = strings.ToUpper(id)
+	return strings.HasPrefix(id, "W") && id != "WXXX" && id != "WXX"
+}
+
+// isPictureFrame reports whether the frame is an attached picture (APIC, or PIC for ID3v2.2). Picture frames are kept
+// exactly as they are in the file, encoding byte and all, since most of their value is binary data.
+func isPictureFrame(id string) bool {
+	id = strings.ToUpper(id)
+	return id == "APIC" || id == "PIC"
+}
+
+// isChapterFrame reports whether the frame is a chapter (CHAP) or a table of contents (CTOC). These hold their own
+// sub-frames, so they're kept exactly as they are in the file.
+func isChapterFrame(id string) bool {
+	id = strings.ToUpper(id)
+	return id == "CHAP" || id == "CTOC"
+}
+
+// isAudioFrame reports whether the frame describes the audio itself instead of the episode, such as a volume adjustment,
+// seek table, or ReplayGain value, so that players need it to play the file properly.
+func isAudioFrame(id string, value []byte) bool {
+	switch strings.ToUpper(id) {
+	case "MLLT", "SEEK", "ASPI", "RVA2", "RVAD", "EQU2", "EQUA", "ETCO", "SYTC", "TLEN", "RGAD",
+		"MLL", "RVA", "EQU", "ETC", "STC", "TLE":
+		return true
+	case "TXXX", "TXX":
+		return bytes.HasPrefix(bytes.ToUpper(value), []byte("REPLAYGAIN_"))
+	}
+
+	return false
+}
+
+// isBinaryFrame reports whether the frame's value is kept as is instead of being decoded as text.
+func isBinaryFrame(id string) bool {
+	return isPictureFrame(id) || isChapterFrame(id)
+}
+
 // parseFrames creates the internal list of all frames (represented as id/value pairs) in the metadata.
 func (m *Meta) parseFrames() {
 	if m.noMeta || !m.buffered || m.readFrames {
@@ -318,28 +562,43 @@ func (m *Meta) parseFrames() {
 	// Skip past the length.
 	buf.Next(4)
 
-	// Skip past the extended header, if present (not needed for ID3v2.2).
+	// Skip past the extended header, if present (not needed for ID3v2.2). Its length includes the 4 bytes of the
+	// length itself.
+	m.issues = make(TagIssues)
 	if version != 2 && flags&(1<<6) > 0 {
 		length := readLen(buf, version, true)
+		if length < 4 {
+			m.debug("Stopping frame parse early: Invalid extended header length -", length)
+			m.issues[IssueParse]++
+			return
+		}
 		buf.Next(length - 4)
 	}
 
 	// If we encounter any error while reading the metadata, we won't know how to continue parsing the rest of the
-	// frames and will have to bail out with what we've got.
+	// frames and will have to bail out with what we've got. Every frame we couldn't read is counted so that it can be
+	// reported.
 	// TODO: A good area for future development would be to enhance this, perhaps by trying to continue on until the
 	// next tag is found.
 	for buf.Len() > 0 {
+		// The padding at the end of the tag is all zeros, where the next frame's ID would be.
+		if buf.Bytes()[0] == 0x00 {
+			break
+		}
+
 		// Read out the frame's ID.
 		id := readID(buf, version)
 		if id == nil {
-			Debug("Stopping frame parse early: Invalid frame ID")
+			m.debug("Stopping frame parse early: Invalid frame ID")
+			m.issues[IssueInvalidID]++
 			break
 		}
 
 		// Read out the frame's length.
 		size := readLen(buf, version, false)
 		if size <= 0 {
-			Debug("Stopping frame parse early: Invalid length for", string(id), "-", size)
+			m.debug("Stopping frame parse early: Invalid length for", string(id), "-", size)
+			m.issues[IssueParse]++
 			break
 		}
 
@@ -347,52 +606,163 @@ func (m *Meta) parseFrames() {
 		if version != 2 {
 			flags := buf.Next(2)
 			if len(flags) != 2 {
-				Debug("Stopping frame parse early: Error reading frame flags")
+				m.debug("Stopping frame parse early: Error reading frame flags")
+				m.issues[IssueParse]++
 				break
 			}
 
 			// We only want the frame if these flags are not set.
 			if flags[1]&0x0C > 0 {
 				buf.Next(size)
-				Debug("Skipping frame")
+				m.debug("Skipping frame")
 				continue
 			}
 		}
 
 		value := buf.Next(size)
 		if len(value) != size {
-			Debug("Stopping frame parse early: Error reading frame value")
+			m.debug("Stopping frame parse early: Error reading frame value")
+			m.issues[IssueParse]++
 			break
 		}
 
-		switch value[0] {
-		case 0x00:
-			// ASCII characters. Remove the first byte.
-			value = value[1:]
-		case 0x01:
-			// UTF-16 with BOM. Remove the first byte and decode to UTF-8.
-			value = value[1:]
-			decoder := unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM).NewDecoder()
-			value, _ = decoder.Bytes(value)
-		case 0x02:
-			// UTF-16 Big Endian without BOM. Remove the first byte and decode to UTF-8.
-			value = value[1:]
-			decoder := unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM).NewDecoder()
-			value, _ = decoder.Bytes(value)
-		case 0x03:
-			// UTF-8 (Unicode). Remove the first byte.
-			value = value[1:]
-		}
-		value = bytes.TrimSuffix(value, []byte{0x00})
-
-		// Debug print everything but the image bytes.
-		if string(id) != "PIC" && string(id) != "APIC" {
-			Debug("Found", string(id), "-", string(value))
+		if isBinaryFrame(string(id)) {
+			m.frames = append(m.frames, Frame{string(id), value})
+			continue
 		}
+
+		// Text frames start with a byte naming their encoding, and we can't decode one that names an encoding we don't
+		// know.
+		if id[0] == 'T' && value[0] > 0x03 {
+			m.debug("Skipping", string(id), "- Unknown text encoding", value[0])
+			m.issues[IssueEncoding]++
+			continue
+		}
+
+		value = decodeText(value)
+		m.debug("Found", string(id), "-", string(value))
 		m.frames = append(m.frames, Frame{string(id), value})
 	}
 }
 
+// readTagFrames reads the values of the wanted frames out of the tag at the start of the stream, seeking past all the
+// other frames (such as embedded artwork) instead of reading them. Text frames are decoded to UTF-8. This also returns
+// the tag's version, or 0 if the stream doesn't have a tag.
+func readTagFrames(r io.ReadSeeker, wanted func(id string) bool) (map[string][][]byte, byte, error) {
+	header := make([]byte, id3HeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, 0, nil
+		}
+		return nil, 0, err
+	}
+	if string(header[:3]) != "ID3" {
+		return nil, 0, nil
+	}
+	version := header[3]
+	flags := header[5]
+	remaining := readLen(bytes.NewBuffer(header[6:]), version, true)
+	if remaining > maxTagSize() {
+		return nil, version, fmt.Errorf("%w: %v declared", errTagTooLarge, Reduce(remaining))
+	}
+
+	// Skip past the extended header, if present (not needed for ID3v2.2).
+	if version != 2 && flags&(1<<6) > 0 {
+		size := make([]byte, 4)
+		if _, err := io.ReadFull(r, size); err != nil {
+			return nil, version, err
+		}
+		length := readLen(bytes.NewBuffer(size), version, true)
+		if length < 4 {
+			return nil, version, fmt.Errorf("invalid extended header length: %v", length)
+		}
+		if _, err := r.Seek(int64(length-4), io.SeekCurrent); err != nil {
+			return nil, version, err
+		}
+		remaining -= length
+	}
+
+	frameHeaderSize := 10
+	if version == 2 {
+		frameHeaderSize = 6
+	}
+
+	frames := make(map[string][][]byte)
+	frameHeader := make([]byte, frameHeaderSize)
+	for remaining >= frameHeaderSize {
+		if _, err := io.ReadFull(r, frameHeader); err != nil {
+			break
+		}
+		remaining -= frameHeaderSize
+
+		// We're done when we reach the padding or anything else that isn't a frame.
+		buf := bytes.NewBuffer(frameHeader)
+		id := readID(buf, version)
+		if id == nil {
+			break
+		}
+		size := readLen(buf, version, false)
+		if size <= 0 || size > remaining {
+			break
+		}
+		remaining -= size
+
+		// We only want the frame if it's not compressed or encrypted.
+		skip := !wanted(string(id))
+		if version != 2 && frameHeader[9]&0x0C > 0 {
+			skip = true
+		}
+		if skip {
+			if _, err := r.Seek(int64(size), io.SeekCurrent); err != nil {
+				return frames, version, err
+			}
+			continue
+		}
+
+		// The frame is read as its bytes arrive, in case the stream is shorter than the frame claims to be.
+		var frame bytes.Buffer
+		if _, err := io.CopyN(&frame, r, int64(size)); err != nil {
+			break
+		}
+		value := frame.Bytes()
+		if !isBinaryFrame(string(id)) {
+			value = decodeText(value)
+		}
+		frames[string(id)] = append(frames[string(id)], value)
+	}
+
+	return frames, version, nil
+}
+
+// decodeText converts the value of a text frame to UTF-8, using the encoding named in its first byte, and removes the
+// encoding byte and the trailing null terminator.
+func decodeText(value []byte) []byte {
+	if len(value) == 0 {
+		return value
+	}
+
+	switch value[0] {
+	case 0x00:
+		// ASCII characters. Remove the first byte.
+		value = value[1:]
+	case 0x01:
+		// UTF-16 with BOM. Remove the first byte and decode to UTF-8.
+		value = value[1:]
+		decoder := unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM).NewDecoder()
+		value, _ = decoder.Bytes(value)
+	case 0x02:
+		// UTF-16 Big Endian without BOM. Remove the first byte and decode to UTF-8.
+		value = value[1:]
+		decoder := unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM).NewDecoder()
+		value, _ = decoder.Bytes(value)
+	case 0x03:
+		// UTF-8 (Unicode). Remove the first byte.
+		value = value[1:]
+	}
+
+	return bytes.TrimSuffix(value, []byte{0x00})
+}
+
 // length returns the reported length in bytes of the entire metadata, or -1 if the metadata could not be successfully
 // parsed (possibly indicating that more metadata is needed). It is not necessary to have the entire metadata buffered.
 // If no metadata exists in the file's contents, this will return 0.
@@ -486,10 +856,16 @@ func readLen(buf *bytes.Buffer, version byte, header bool) int {
 		width = 8
 	}
 
-	num := int32(0)
+	// Keep the high bit out of synch-safe bytes, where it would otherwise overlap the next byte, and count in 64 bits
+	// so that a 32-bit length can't overflow into a negative one.
+	mask := byte(0xFF >> (8 - width))
+	num := int64(0)
 	for _, b := range bytes {
 		num <<= width
-		num |= int32(b)
+		num |= int64(b & mask)
+	}
+	if num > math.MaxInt32 {
+		return -1
 	}
 
 	return int(num)
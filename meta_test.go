@@ -2,13 +2,21 @@ package main
 
 import (
 	"bytes"
+	"errors"
+	"io"
 	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"os/exec"
 	"path"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/snhilde/getcast/internal/feedtest"
 )
 
 // localData is used to hold the information about a test file on disk, including its location, metadata size, and frames.
@@ -366,14 +374,241 @@ func splitFile(path string) (*Meta, []byte, error) {
 		return nil, nil, err
 	}
 
-	data, err := ioutil.ReadAll(file)
-	if err != nil {
+	defer file.Close()
+
+	meta := NewMeta(nil)
+	if _, err := meta.ReadFrom(file); err != nil {
 		return nil, nil, err
 	}
-	defer file.Close()
 
-	meta := NewMeta(data)
-	audio := data[meta.Len():]
+	audio, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	return meta, audio, nil
 }
+
+// Test that reading the metadata from a stream gives the same tag as reading the whole file, without reading the audio.
+func TestMetaReadFrom(t *testing.T) {
+	for _, name := range []string{"white", "pink", "brown"} {
+		path := "./tests/" + name + ".mp3"
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := NewMeta(data)
+
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		have := NewMeta(nil)
+		n, err := have.ReadFrom(file)
+		file.Close()
+		if err != nil {
+			t.Error(name, "- Error reading metadata:", err)
+			continue
+		}
+
+		if int(n) != want.Len() || !bytes.Equal(have.Bytes(), want.Bytes()) {
+			t.Error(name, "- Incorrect metadata - Want:", want.Len(), "bytes Have:", n, "bytes read,", have.Len(), "buffered")
+		}
+		if have.NumFrames() != want.NumFrames() {
+			t.Error(name, "- Incorrect number of frames - Want:", want.NumFrames(), "Have:", have.NumFrames())
+		}
+	}
+
+	// A stream without metadata is put back where it started.
+	file, err := ioutil.TempFile("", "getcast-meta")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("not a tag")
+	file.Seek(0, io.SeekStart)
+	if n, err := NewMeta(nil).ReadFrom(file); err != nil || n != 0 {
+		t.Error("Incorrect read of stream without metadata:", n, err)
+	}
+	if offset, _ := file.Seek(0, io.SeekCurrent); offset != 0 {
+		t.Error("Stream without metadata was not put back - Want: 0 Have:", offset)
+	}
+	file.Close()
+}
+
+// Test that frames that can't be read are skipped and counted, while the padding at the end of a tag isn't.
+func TestMetaIssues(t *testing.T) {
+	frame := func(id string, value string) []byte {
+		return append(append([]byte(id), writeLen(len(value), 3, false)...), append([]byte{0, 0}, value...)...)
+	}
+
+	var frames []byte
+	frames = append(frames, frame("TIT2", "\x00Title")...)
+	frames = append(frames, frame("TALB", "\x07Album")...)
+	frames = append(frames, frame("TPE1", "\x00Artist")...)
+	frames = append(frames, frame("tpe2", "\x00Artist")...)
+	frames = append(frames, frame("TCON", "\x00Podcast")...)
+	frames = append(frames, make([]byte, 20)...)
+
+	tag := append([]byte{'I', 'D', '3', 3, 0, 0}, writeLen(len(frames), 3, true)...)
+	meta := NewMeta(append(tag, frames...))
+
+	if have := getTag(meta, "TIT2"); have != "Title" {
+		t.Error("Incorrect title - Want: Title Have:", have)
+	}
+	if have := getTag(meta, "TALB"); have != "" {
+		t.Error("Frame with an unknown encoding was read:", have)
+	}
+	if have := getTag(meta, "TPE1"); have != "Artist" {
+		t.Error("Incorrect artist - Want: Artist Have:", have)
+	}
+
+	issues := meta.Issues()
+	if issues[IssueEncoding] != 1 || issues[IssueInvalidID] != 1 || issues.Total() != 2 {
+		t.Error("Incorrect issues - Want: 1 invalid ID, 1 unknown encoding Have:", issues)
+	}
+	if want := "1 invalid ID, 1 unknown encoding"; issues.String() != want {
+		t.Error("Incorrect report - Want:", want, "Have:", issues.String())
+	}
+
+	// A tag with only padding after its frames has nothing to report.
+	tag = append([]byte{'I', 'D', '3', 3, 0, 0}, writeLen(len(frame("TIT2", "\x00Title"))+20, 3, true)...)
+	tag = append(append(tag, frame("TIT2", "\x00Title")...), make([]byte, 20)...)
+	if issues := NewMeta(tag).Issues(); issues.Total() != 0 {
+		t.Error("Padding was counted as an issue:", issues)
+	}
+
+	StrictTags = true
+	defer func() { StrictTags = false }()
+	e := &Episode{meta: meta}
+	if err := e.checkTag(); err == nil {
+		t.Error("Strict mode accepted a tag with unreadable frames")
+	}
+}
+
+// Test that -clean-tags throws out the publisher's frames except the ones needed for the audio.
+func TestCleanTags(t *testing.T) {
+	frame := func(id string, value string) []byte {
+		return append(append([]byte(id), writeLen(len(value), 3, false)...), append([]byte{0, 0}, value...)...)
+	}
+
+	var frames []byte
+	frames = append(frames, frame("TIT2", "\x00Publisher Title")...)
+	frames = append(frames, frame("TSRC", "\x00QZABC1234567")...)
+	frames = append(frames, frame("COMM", "\x00engJunk")...)
+	frames = append(frames, frame("RVA2", "track\x00\x01\x00\x10\x00")...)
+	frames = append(frames, frame("TXXX", "\x00REPLAYGAIN_TRACK_GAIN\x00-6.5 dB")...)
+	frames = append(frames, frame("TXXX", "\x00TRACKER\x00https://tracker.example.com")...)
+	tag := append([]byte{'I', 'D', '3', 3, 0, 0}, writeLen(len(frames), 3, true)...)
+
+	CleanTags = true
+	defer func() { CleanTags = false }()
+	e := &Episode{Title: "Feed Title", GUID: "guid-1", meta: NewMeta(append(tag, frames...))}
+	e.settings = &ShowSettings{Artwork: ArtworkNone}
+	e.addFrames()
+
+	for id, want := range map[string]int{"TIT2": 1, "TSRC": 0, "COMM": 0, "RVA2": 1} {
+		if have := len(e.meta.GetValues(id)); have != want {
+			t.Error(id, "- Incorrect number of frames - Want:", want, "Have:", have)
+		}
+	}
+	if have := getTag(e.meta, "TIT2"); have != "Feed Title" {
+		t.Error("Incorrect title - Want: Feed Title Have:", have)
+	}
+	if have := e.meta.GetUserText("REPLAYGAIN_TRACK_GAIN"); have != "-6.5 dB" {
+		t.Error("ReplayGain was not kept - Have:", have)
+	}
+	if have := e.meta.GetUserText("TRACKER"); have != "" {
+		t.Error("Publisher's user-defined frame was kept:", have)
+	}
+	if have := e.meta.GetUserText("GUID"); have != "guid-1" {
+		t.Error("Incorrect GUID - Want: guid-1 Have:", have)
+	}
+}
+
+// Test that a tag whose header claims more than the maximum tag size is rejected without reading past the header.
+func TestMetaTooLarge(t *testing.T) {
+	// The header claims a tag of 256 MB, but only a few bytes follow.
+	data := append([]byte{'I', 'D', '3', 4, 0, 0, 0x7F, 0x7F, 0x7F, 0x7F}, "TIT2"...)
+
+	meta := NewMeta(nil)
+	if _, err := meta.Write(data); !errors.Is(err, errTagTooLarge) {
+		t.Error("Incorrect error writing - Want:", errTagTooLarge, "Have:", err)
+	}
+
+	meta = NewMeta(nil)
+	if n, err := meta.ReadFrom(bytes.NewReader(data)); !errors.Is(err, errTagTooLarge) {
+		t.Error("Incorrect error reading - Want:", errTagTooLarge, "Have:", err)
+	} else if n != id3HeaderSize {
+		t.Error("Read past the header - Want:", id3HeaderSize, "Have:", n)
+	}
+
+	if _, _, err := readTagFrames(bytes.NewReader(data), func(string) bool { return true }); !errors.Is(err, errTagTooLarge) {
+		t.Error("Incorrect error reading frames - Want:", errTagTooLarge, "Have:", err)
+	}
+}
+
+// Test that lengths are read as synch-safe or plain integers for each ID3 version, and that lengths that don't fit in
+// 32 bits or are cut short are rejected.
+func TestReadLen(t *testing.T) {
+	tests := []struct {
+		data    []byte
+		version byte
+		header  bool
+		want    int
+	}{
+		{[]byte{0x00, 0x00, 0x02, 0x01}, 4, true, 257},
+		{[]byte{0x00, 0x00, 0x02, 0x01}, 3, false, 513},
+		{[]byte{0x00, 0x02, 0x01}, 2, false, 257},
+		{[]byte{0x80, 0x00, 0x00, 0x81}, 4, false, 1}, // high bits of synch-safe bytes are ignored
+		{[]byte{0x7F, 0xFF, 0xFF, 0xFF}, 3, false, math.MaxInt32},
+		{[]byte{0xFF, 0xFF, 0xFF, 0xFF}, 3, false, -1}, // doesn't fit in 32 bits
+		{[]byte{0x00, 0x00}, 4, true, -1},
+	}
+
+	for _, test := range tests {
+		if have := readLen(bytes.NewBuffer(test.data), test.version, test.header); have != test.want {
+			t.Error("Incorrect length for", test.data, "- Want:", test.want, "Have:", have)
+		}
+	}
+}
+
+// Test that an episode whose tag is larger than -max-tag-size is saved exactly as published.
+func TestDownloadLargeTag(t *testing.T) {
+	tag := feedtest.Tag{
+		Version: 3,
+		Frames: []feedtest.Frame{
+			feedtest.TextFrame("TIT2", "Published Title"),
+			{ID: "APIC", Value: append([]byte("\x00image/png\x00\x03\x00"), bytes.Repeat([]byte{0x89}, 200000)...)},
+		},
+	}
+	content := feedtest.MP3(tag, 300000)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "episode.mp3", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "getcast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	MaxTagSize = 64 << 10
+	defer func() { MaxTagSize = 0 }()
+
+	episode := Episode{Title: "Episode", Enclosure: Enclosure{URL: server.URL + "/episode.mp3", Type: "audio/mpeg"}}
+	episode.settings = &ShowSettings{Artwork: ArtworkNone}
+	if err := episode.Download(dir); err != nil {
+		t.Fatal("Download - Want: nil Have:", err)
+	}
+
+	saved, err := ioutil.ReadFile(episode.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(saved, content) {
+		t.Error("Episode was changed - Want:", len(content), "bytes Have:", len(saved), "bytes")
+	}
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// These are the podcatchers that subscriptions and download history can be migrated from.
+const (
+	MigrateGPodder = "gpodder" // gPodder 3, from its home directory (with Database and Downloads) or its Database file
+	MigrateCastget = "castget" // castget, from its .castgetrc
+	MigratePodget  = "podget"  // podget, from its config directory (with podgetrc and serverlist)
+)
+
+// Migration holds what was read from another podcatcher.
+type Migration struct {
+	Dir           string            // where the podcatcher saved its downloads, if it has a single library directory
+	Subscriptions []Subscription    // shows that were subscribed to
+	Paused        []string          // feed URLs of the shows that were paused
+	Downloads     []MigratedEpisode // episodes that were downloaded and are still on disk
+}
+
+// MigratedEpisode is an episode that the other podcatcher downloaded.
+type MigratedEpisode struct {
+	Feed    string // URL of the show's feed
+	Show    string // title of the show
+	Episode Episode
+}
+
+// migratedSubscription is how a migrated subscription is written to the config, leaving out all the empty settings.
+type migratedSubscription struct {
+	URL      string `json:"url"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// runMigrate reads another podcatcher's subscriptions and download history and adds them to the config and the state.
+func runMigrate(configPath string, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	fromArg := fs.String("from", "", "Podcatcher to migrate from: gpodder, castget, or podget")
+	dryRun := fs.Bool("dry-run", false, "Optional. List what would be migrated without changing anything")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	path := fs.Arg(0)
+	var migration *Migration
+	var err error
+	switch *fromArg {
+	case MigrateGPodder:
+		migration, err = ReadGPodder(path)
+	case MigrateCastget:
+		migration, err = ReadCastget(path)
+	case MigratePodget:
+		migration, err = ReadPodget(path)
+	default:
+		Log("Unknown podcatcher to migrate from:", *fromArg)
+		return errUsage
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, sub := range migration.Subscriptions {
+		Log("Subscription:", sub.URL)
+	}
+	for _, download := range migration.Downloads {
+		Debug("Downloaded:", download.Show, "/", download.Episode.Title)
+	}
+	Log("Found", len(migration.Subscriptions), "subscriptions and", len(migration.Downloads), "downloaded episodes")
+	if *dryRun {
+		return nil
+	}
+
+	if configPath == "" {
+		configPath = ActiveProfile.ConfigPath()
+	}
+	added, err := migration.writeConfig(configPath)
+	if err != nil {
+		return err
+	}
+	Log("Added", added, "subscriptions to", configPath)
+
+	for _, download := range migration.Downloads {
+		StateDB.RecordDownload(download.Feed, download.Show, &download.Episode)
+	}
+	config := &Config{Subscriptions: migration.Subscriptions}
+	for _, feed := range migration.Paused {
+		if err := StateDB.SetPaused(feed, config, true); err != nil {
+			Log("Error pausing", feed, "-", err)
+		}
+	}
+	if err := StateDB.Save(); err != nil {
+		return fmt.Errorf("error saving state: %v", err)
+	}
+
+	if len(migration.Downloads) == 0 && len(migration.Subscriptions) > 0 {
+		Log("No download history was migrated. Use adopt on each show's directory to record the episodes already there.")
+	}
+	if migration.Dir != "" {
+		Log("Episodes were downloaded to", migration.Dir)
+	}
+
+	return nil
+}
+
+// writeConfig adds the subscriptions that aren't already in the config file at the path, creating the file if it
+// doesn't exist. Everything else in the file is kept, and the original is saved with a .bak extension. This returns the
+// number of subscriptions that were added.
+func (m *Migration) writeConfig(path string) (int, error) {
+	raw := newJSONObject()
+	data, err := ioutil.ReadFile(path)
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(data, raw); err != nil {
+			return 0, fmt.Errorf("error parsing config: %v", err)
+		}
+		// The original might have passwords in it too.
+		if err := ioutil.WriteFile(path+".bak", data, 0600); err != nil {
+			return 0, fmt.Errorf("error backing up config: %v", err)
+		}
+	case !os.IsNotExist(err):
+		return 0, fmt.Errorf("error reading config: %v", err)
+	}
+
+	var subs []json.RawMessage
+	if value, ok := raw.values["subscriptions"]; ok {
+		if err := json.Unmarshal(value, &subs); err != nil {
+			return 0, fmt.Errorf("error parsing config: %v", err)
+		}
+	}
+
+	existing := make(map[string]bool)
+	for _, value := range subs {
+		var sub Subscription
+		if err := json.Unmarshal(value, &sub); err == nil {
+			existing[sub.URL] = true
+		}
+	}
+
+	added := 0
+	for _, sub := range m.Subscriptions {
+		if existing[sub.URL] {
+			Debug("Already subscribed to", sub.URL)
+			continue
+		}
+		existing[sub.URL] = true
+
+		value, err := json.Marshal(migratedSubscription{URL: sub.URL, Username: sub.Username, Password: sub.Password})
+		if err != nil {
+			return 0, err
+		}
+		subs = append(subs, value)
+		added++
+	}
+
+	value, err := json.Marshal(subs)
+	if err != nil {
+		return 0, err
+	}
+	raw.set("subscriptions", value)
+	if _, ok := raw.values["dir"]; !ok && m.Dir != "" {
+		if value, err = json.Marshal(m.Dir); err != nil {
+			return 0, err
+		}
+		raw.set("dir", value)
+	}
+
+	data, err = json.MarshalIndent(raw, "", "\t")
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, err
+	}
+
+	// Passwords might be in the config now.
+	return added, ioutil.WriteFile(path, append(data, '\n'), 0600)
+}
+
+// jsonObject is a JSON object that keeps its keys in the order they were read, so that a file the user wrote can be
+// changed without shuffling it.
+type jsonObject struct {
+	keys   []string
+	values map[string]json.RawMessage
+}
+
+// newJSONObject creates an empty object.
+func newJSONObject() *jsonObject {
+	return &jsonObject{values: make(map[string]json.RawMessage)}
+}
+
+// set sets the key to the value, adding the key at the end if it's new.
+func (o *jsonObject) set(key string, value json.RawMessage) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = value
+}
+
+// UnmarshalJSON reads the object's keys in order, leaving their values as they are.
+func (o *jsonObject) UnmarshalJSON(data []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if token, err := dec.Token(); err != nil {
+		return err
+	} else if token != json.Delim('{') {
+		return fmt.Errorf("not an object")
+	}
+
+	for dec.More() {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, _ := token.(string)
+
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return err
+		}
+		o.set(key, value)
+	}
+
+	_, err := dec.Token()
+	return err
+}
+
+// MarshalJSON writes the object with its keys in order.
+func (o *jsonObject) MarshalJSON() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	buf.WriteByte('{')
+	for i, key := range o.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(o.values[key])
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+// ReadGPodder reads the subscriptions and download history from gPodder's database. The path is either gPodder's home
+// directory (~/gPodder by default) or the Database file in it. Downloads are looked for in the Downloads directory next
+// to the database.
+func ReadGPodder(path string) (*Migration, error) {
+	if path == "" {
+		home, _ := os.UserHomeDir()
+		path = filepath.Join(home, "gPodder")
+	}
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, "Database")
+	}
+	downloads := filepath.Join(filepath.Dir(path), "Downloads")
+
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading gPodder database: %v", err)
+	}
+	if _, err := os.Stat(path + "-wal"); err == nil {
+		Log("WARNING: gPodder seems to be running. Quit it first so that all of its changes are in the database.")
+	}
+
+	podcasts, err := db.Table("podcast")
+	if err != nil {
+		return nil, fmt.Errorf("error reading gPodder podcasts: %v", err)
+	}
+	episodes, err := db.Table("episode")
+	if err != nil {
+		return nil, fmt.Errorf("error reading gPodder episodes: %v", err)
+	}
+
+	m := &Migration{Dir: downloads}
+	shows := make(map[int64]map[string]interface{})
+	for _, podcast := range podcasts {
+		feed := sqlString(podcast["url"])
+		if feed == "" {
+			continue
+		}
+		shows[sqlInt(podcast["id"])] = podcast
+
+		sub := Subscription{URL: feed, Username: sqlString(podcast["auth_username"]), Password: sqlString(podcast["auth_password"])}
+		m.Subscriptions = append(m.Subscriptions, sub)
+		if sqlInt(podcast["pause_subscription"]) != 0 {
+			m.Paused = append(m.Paused, feed)
+		}
+	}
+
+	// gPodder's episode states are 0 for new, 1 for downloaded, and 2 for deleted.
+	for _, row := range episodes {
+		podcast, ok := shows[sqlInt(row["podcast_id"])]
+		filename := sqlString(row["download_filename"])
+		if !ok || sqlInt(row["state"]) != 1 || filename == "" {
+			continue
+		}
+
+		file := filepath.Join(downloads, sqlString(podcast["download_folder"]), filename)
+		info, err := os.Stat(file)
+		if err != nil {
+			Debug("Skipping missing download", file)
+			continue
+		}
+
+		episode := Episode{Title: sqlString(row["title"]), GUID: sqlString(row["guid"])}
+		episode.Enclosure = Enclosure{URL: sqlString(row["url"]), Type: sqlString(row["mime_type"])}
+		if size := sqlInt(row["file_size"]); size > 0 {
+			episode.Enclosure.Size = strconv.FormatInt(size, 10)
+		}
+		if published := sqlInt(row["published"]); published > 0 {
+			episode.Date = time.Unix(published, 0).UTC().Format(time.RFC1123Z)
+		}
+		episode.path = file
+		episode.size = int(info.Size())
+
+		m.Downloads = append(m.Downloads, MigratedEpisode{Feed: sqlString(podcast["url"]), Show: sqlString(podcast["title"]), Episode: episode})
+	}
+
+	return m, nil
+}
+
+// ReadCastget reads the subscriptions from castget's config file (~/.castgetrc by default). Each channel is a section
+// with a url, and the global section's spool is the download directory. castget only remembers which enclosure URLs it
+// has downloaded, which isn't enough to identify the episodes, so no download history is migrated.
+func ReadCastget(path string) (*Migration, error) {
+	if path == "" {
+		home, _ := os.UserHomeDir()
+		path = filepath.Join(home, ".castgetrc")
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading castget config: %v", err)
+	}
+	defer file.Close()
+
+	m := &Migration{}
+	section := ""
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(parts[0]))
+		value := strings.TrimSpace(parts[1])
+
+		switch {
+		case section == "global" && key == "spool":
+			m.Dir = value
+		case section != "global" && key == "url":
+			m.Subscriptions = append(m.Subscriptions, Subscription{URL: value})
+		}
+	}
+
+	return m, scanner.Err()
+}
+
+// ReadPodget reads the subscriptions from podget's config directory (~/.podget by default). The serverlist has one feed
+// per line, as "URL CATEGORY NAME", and podgetrc's dir_library is the download directory. podget doesn't keep any
+// download history, so none is migrated.
+func ReadPodget(path string) (*Migration, error) {
+	if path == "" {
+		home, _ := os.UserHomeDir()
+		path = filepath.Join(home, ".podget")
+	}
+
+	m := &Migration{}
+	if data, err := ioutil.ReadFile(filepath.Join(path, "podgetrc")); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if strings.HasPrefix(line, "dir_library=") {
+				m.Dir = strings.Trim(strings.TrimPrefix(line, "dir_library="), `"'`)
+			}
+		}
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(path, "serverlist"))
+	if err != nil {
+		return nil, fmt.Errorf("error reading podget serverlist: %v", err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		m.Subscriptions = append(m.Subscriptions, Subscription{URL: fields[0]})
+	}
+
+	return m, nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Test reading a gPodder database, which spans several pages and has a row that overflows onto other pages.
+func TestReadGPodder(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data, err := ioutil.ReadFile("./tests/gpodder.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "Database"), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	showDir := filepath.Join(dir, "Downloads", "Sample Show")
+	if err := os.MkdirAll(showDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"ep1.mp3", "ep3.mp3"} {
+		if err := ioutil.WriteFile(filepath.Join(showDir, name), []byte("audio"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m, err := ReadGPodder(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.Subscriptions) != 2 {
+		t.Fatal("Subscriptions - Want:", 2, "Have:", len(m.Subscriptions))
+	}
+	if sub := m.Subscriptions[1]; sub.Username != "user" || sub.Password != "secret" {
+		t.Error("Credentials - Want: user secret Have:", sub.Username, sub.Password)
+	}
+	if len(m.Paused) != 1 || m.Paused[0] != "https://example.org/private.xml" {
+		t.Error("Paused - Want: [https://example.org/private.xml] Have:", m.Paused)
+	}
+
+	if len(m.Downloads) != 2 {
+		t.Fatal("Downloads - Want:", 2, "Have:", len(m.Downloads))
+	}
+	if e := m.Downloads[1].Episode; e.Title != "Episode 3" || e.GUID != "guid-3" || e.size != 5 {
+		t.Error("Download - Want: Episode 3 guid-3 5 Have:", e.Title, e.GUID, e.size)
+	}
+
+	db, err := openSQLite(filepath.Join(dir, "Database"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	episodes, err := db.Table("episode")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(episodes) != 60 {
+		t.Error("Episodes - Want:", 60, "Have:", len(episodes))
+	}
+	want := strings.Repeat("Long show notes. ", 400)
+	if have := sqlString(episodes[2]["description"]); have != want {
+		t.Error("Overflowing description - Want:", len(want), "bytes Have:", len(have), "bytes")
+	}
+}
+
+// Test reading podget's config directory, skipping the comments and blank lines in its serverlist.
+func TestReadPodget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rc := "# podget config\ndir_library=/srv/podcasts\n"
+	list := "# comment\nhttps://example.com/feed.xml News Daily Show\n\nhttps://example.org/other.xml Tech Other\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "podgetrc"), []byte(rc), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "serverlist"), []byte(list), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := ReadPodget(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Dir != "/srv/podcasts" || len(m.Subscriptions) != 2 || m.Subscriptions[1].URL != "https://example.org/other.xml" {
+		t.Error("Want: /srv/podcasts with 2 subscriptions Have:", m.Dir, m.Subscriptions)
+	}
+}
+
+// Test that migrated subscriptions are added to the config without reordering it, and that the original is kept where
+// only the user can read it.
+func TestWriteConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.json")
+	original := `{"interval": "1h", "subscriptions": [{"url": "https://example.com/a.xml", "password": "secret"}],
+		"dir": "/srv"}`
+	if err := ioutil.WriteFile(path, []byte(original), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	subs := []Subscription{{URL: "https://example.com/a.xml"}, {URL: "https://example.com/b.xml"}}
+	m := &Migration{Dir: "/other", Subscriptions: subs}
+	added, err := m.writeConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if added != 1 {
+		t.Error("Incorrect number added - Want: 1 Have:", added)
+	}
+
+	data, _ := ioutil.ReadFile(path)
+	config := string(data)
+	interval, subscriptions := strings.Index(config, `"interval"`), strings.Index(config, `"subscriptions"`)
+	if interval < 0 || interval > subscriptions || subscriptions > strings.Index(config, `"dir"`) {
+		t.Error("Config was reordered:", config)
+	}
+	if !strings.Contains(config, "secret") || !strings.Contains(config, "b.xml") || strings.Contains(config, "/other") {
+		t.Error("Incorrect config:", config)
+	}
+
+	info, err := os.Stat(path + ".bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0600 {
+		t.Errorf("Incorrect backup mode - Want: 0600 Have: %#o", mode)
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// mirror removes the local episodes in the show's directory whose episodes no longer appear in the feed, so that the
+// directory exactly matches the feed. Episodes are matched by the title in their metadata, and files without a title
+// are left alone. The user is asked to confirm before anything is moved to the trash.
+func (s *Show) mirror(feedTitles *titleSet) error {
+	Log("Looking for episodes no longer in the feed")
+	stale, err := s.findEpisodes(func(title string) bool {
+		return !feedTitles.Has(title)
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(stale) == 0 {
+		Log("Show directory matches the feed")
+		return nil
+	}
+
+	Log("These episodes are no longer in the feed:")
+	for _, path := range stale {
+		Log("  " + filepath.Base(path))
+	}
+	if !Confirm("Move these episodes to the trash?") {
+		Log("Leaving episodes in place")
+		return nil
+	}
+
+	for _, path := range stale {
+		if err := TrashFile(s.Dir, path); err != nil {
+			Log("Error removing", filepath.Base(path), "-", err)
+			continue
+		}
+		Log("Moved", filepath.Base(path), "to trash")
+	}
+
+	return nil
+}
+
+// retain moves the local episodes that aren't among the newest n episodes in the feed to the trash, and drops them from
+// the list of episodes to download. Files that aren't in the feed at all are left alone (see mirror). This must be
+// called while the episodes are still sorted oldest first.
+func (s *Show) retain(n int) error {
+	if len(s.Episodes) <= n {
+		return nil
+	}
+
+	old := newTitleSet()
+	keep := newTitleSet()
+	for i, episode := range s.Episodes {
+		if i < len(s.Episodes)-n {
+			old.Add(episode.Title)
+		} else {
+			keep.Add(episode.Title)
+		}
+	}
+	s.Episodes = s.Episodes[len(s.Episodes)-n:]
+
+	Log("Keeping only the newest", n, "episodes")
+	stale, err := s.findEpisodes(func(title string) bool {
+		return old.Has(title) && !keep.Has(title)
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, path := range stale {
+		if err := TrashFile(s.Dir, path); err != nil {
+			Log("Error removing", filepath.Base(path), "-", err)
+			continue
+		}
+		Log("Moved", filepath.Base(path), "to trash")
+	}
+
+	return nil
+}
+
+// findEpisodes returns the paths of the local episodes in the show's directory whose titles match. Episodes are matched
+// by the title in their metadata, and files without a title are left out.
+func (s *Show) findEpisodes(match func(title string) bool) ([]string, error) {
+	var found []string
+	rules := s.scanRules()
+	walkFunc := func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if skip, err := rules.skip(path, info); skip {
+			return err
+		}
+
+		if strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() && path != s.Dir {
+				return filepath.SkipDir
+			}
+			return nil
+		} else if info.IsDir() || !isAudio(info.Name()) {
+			return nil
+		}
+
+		entry, err := TagCache.Lookup(path, info)
+		if err != nil {
+			s.debug("Error reading metadata of", info.Name(), "-", err)
+			return nil
+		}
+		if entry.Title != "" && match(entry.Title) {
+			found = append(found, path)
+		}
+
+		return nil
+	}
+
+	if err := filepath.Walk(s.Dir, walkFunc); err != nil {
+		return nil, err
+	}
+
+	return found, nil
+}
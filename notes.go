@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// These are the formats that show notes can be saved in.
+const (
+	NotesHTML     = "html"
+	NotesMarkdown = "md"
+)
+
+// ValidateNotesFormat checks that show notes can be saved in the format.
+func ValidateNotesFormat(format string) error {
+	switch format {
+	case "", NotesHTML, NotesMarkdown:
+		return nil
+	}
+
+	return fmt.Errorf("invalid show notes format: %v", format)
+}
+
+// Notes returns the episode's show notes. The full content (content:encoded) is preferred over the description, which
+// is often a shortened version.
+func (e *Episode) Notes() string {
+	if e == nil {
+		return ""
+	}
+
+	if strings.TrimSpace(e.Content) != "" {
+		return e.Content
+	}
+
+	return e.Desc
+}
+
+// SaveNotes saves the episode's show notes next to its audio file in the format, with the same name as the audio file.
+// This must be called after the episode has been downloaded.
+func (e *Episode) SaveNotes(format string) error {
+	if format == "" || e.path == "" {
+		return nil
+	}
+
+	notes := e.Notes()
+	if strings.TrimSpace(notes) == "" {
+		e.debug("No show notes for", e.Title)
+		return nil
+	}
+
+	var content string
+	switch format {
+	case NotesHTML:
+		content = fmt.Sprintf("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n</head>\n<body>\n"+
+			"<h1>%s</h1>\n<p><em>%s</em></p>\n%s\n</body>\n</html>\n",
+			html.EscapeString(e.Title), html.EscapeString(e.Title), html.EscapeString(e.Date), notes)
+	case NotesMarkdown:
+		content = "# " + e.Title + "\n\n"
+		if e.Date != "" {
+			content += "_" + e.Date + "_\n\n"
+		}
+		content += htmlToMarkdown(notes) + "\n"
+	}
+
+	notesPath := strings.TrimSuffix(e.path, filepath.Ext(e.path)) + "." + format
+	e.debug("Saving show notes to", notesPath)
+	return ioutil.WriteFile(notesPath, []byte(content), 0644)
+}
+
+// pdfLink matches links to PDF files in the show notes.
+var pdfLink = regexp.MustCompile(`(?i)href\s*=\s*["']([^"']+\.pdf(?:\?[^"']*)?)["']`)
+
+// SaveAttachments downloads every PDF linked in the episode's show notes and saves it next to the episode's audio file
+// as "<episode name> - <attachment name>". Attachments that have already been saved are skipped. This must be called
+// after the episode has been downloaded.
+func (e *Episode) SaveAttachments() error {
+	if e == nil || e.path == "" {
+		return nil
+	}
+
+	base := strings.TrimSuffix(e.path, filepath.Ext(e.path))
+	for _, match := range pdfLink.FindAllStringSubmatch(e.Notes(), -1) {
+		link := html.UnescapeString(match[1])
+		u, err := url.Parse(link)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			continue
+		}
+
+		if e.external(link) {
+			continue
+		}
+
+		dest := base + " - " + SanitizeTitle(path.Base(u.Path))
+		if _, err := os.Stat(dest); err == nil {
+			continue
+		}
+
+		Log("Downloading attachment", path.Base(u.Path))
+		if err := download(link, dest, e.showAuth, e.downloadOptions().MaxSize); err != nil {
+			Log("Error downloading attachment:", err)
+		}
+	}
+
+	return nil
+}
+
+// download saves the resource at the link to the file at dest, reading no more than limit bytes (or all of it for 0).
+func download(link string, dest string, creds *Credentials, limit int) error {
+	resp, err := httpGet(link, creds)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("%v", resp.Status)
+	}
+	body, err := limitBody(resp, limit)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, body); err != nil {
+		os.Remove(dest)
+		return err
+	}
+
+	return nil
+}
+
+// These are used for converting HTML show notes into Markdown.
+var (
+	mdLink      = regexp.MustCompile(`(?is)<a\s[^>]*href\s*=\s*["']([^"']*)["'][^>]*>(.*?)</a>`)
+	mdHeading   = regexp.MustCompile(`(?is)<h([1-6])[^>]*>(.*?)</h[1-6]>`)
+	mdBold      = regexp.MustCompile(`(?is)<(?:b|strong)(?:\s[^>]*)?>(.*?)</(?:b|strong)>`)
+	mdItalic    = regexp.MustCompile(`(?is)<(?:i|em)(?:\s[^>]*)?>(.*?)</(?:i|em)>`)
+	mdListItem  = regexp.MustCompile(`(?is)<li[^>]*>`)
+	mdLineBreak = regexp.MustCompile(`(?i)<br\s*/?>`)
+	mdParagraph = regexp.MustCompile(`(?i)</?(?:p|div|ul|ol)(?:\s[^>]*)?>`)
+	mdTag       = regexp.MustCompile(`(?s)<[^>]*>`)
+	mdBlank     = regexp.MustCompile(`\n{3,}`)
+)
+
+// htmlToMarkdown does a best-effort conversion of the HTML in show notes to Markdown. Show notes use a small set of
+// tags, so this handles links, headings, emphasis, lists, and paragraphs, and drops everything else.
+func htmlToMarkdown(s string) string {
+	s = mdLink.ReplaceAllString(s, "[$2]($1)")
+	s = mdHeading.ReplaceAllStringFunc(s, func(m string) string {
+		parts := mdHeading.FindStringSubmatch(m)
+		return "\n\n" + strings.Repeat("#", int(parts[1][0]-'0')) + " " + parts[2] + "\n\n"
+	})
+	s = mdBold.ReplaceAllString(s, "**$1**")
+	s = mdItalic.ReplaceAllString(s, "_${1}_")
+	s = mdListItem.ReplaceAllString(s, "\n- ")
+	s = mdLineBreak.ReplaceAllString(s, "\n")
+	s = mdParagraph.ReplaceAllString(s, "\n\n")
+	s = mdTag.ReplaceAllString(s, "")
+	s = html.UnescapeString(s)
+
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(line)
+	}
+	s = strings.Join(lines, "\n")
+	s = mdBlank.ReplaceAllString(s, "\n\n")
+
+	return strings.TrimSpace(s)
+}
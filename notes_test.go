@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+)
+
+// Test that common show notes HTML is converted into Markdown.
+func TestHTMLToMarkdown(t *testing.T) {
+	in := `<p>Guest: <strong>Jane Doe</strong> &amp; friends</p><h2>Links</h2>` +
+		`<ul><li><a href="https://example.com/a">Article</a></li><li><em>Book</em></li></ul>Line one<br/>Line two`
+	want := "Guest: **Jane Doe** & friends\n\n## Links\n\n- [Article](https://example.com/a)\n- _Book_\n\nLine one\nLine two"
+
+	if have := htmlToMarkdown(in); have != want {
+		t.Error("Markdown does not match")
+		t.Log("\tWant:", want)
+		t.Log("\tHave:", have)
+	}
+}
+
+// Test that the index's summary is the first paragraph of the show notes on one line.
+func TestSummary(t *testing.T) {
+	e := Episode{Desc: "<p>In this episode,\n  we talk | argue about <em>things</em>.</p><p>Sponsors: lots of them</p>"}
+	want := "In this episode, we talk | argue about _things_."
+	if have := e.summary(); have != want {
+		t.Error("Want:", want, "Have:", have)
+	}
+
+	durations := map[string]string{"3725": "1:02:05", "59": "0:59", "1:02:05": "1:02:05", "": ""}
+	for in, want := range durations {
+		if have := formatDuration(in); have != want {
+			t.Error(in, "- Want:", want, "Have:", have)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"time"
+)
+
+// SyncOptions holds the settings for syncing a show that come from the command line instead of the show's
+// subscription. A show without its own options uses the current command-line settings (see CurrentSyncOptions), so
+// shows that are synced side by side can each be given different ones. Debug only covers the messages of the show and
+// its episodes; the code they share, like the HTTP client and the caches, still follows DebugMode.
+type SyncOptions struct {
+	FeedCache       bool            // whether or not to save a copy of the fetched feed
+	MaxFeedSize     int             // largest feed to read, in bytes, or 0 for no limit
+	StripTrackers   bool            // whether or not to remove analytics prefixes from enclosure URLs
+	TrashAge        time.Duration   // how long removed episodes are kept in the trash
+	Preset          string          // media server whose library layout to follow, or "" for the default layout
+	Mirror          bool            // whether or not to remove local episodes that are no longer in the feed
+	Order           string          // order to download new episodes in, unless the show sets its own
+	Filter          *Filter         // episodes to download, or nil for all of them
+	SaveAttachments bool            // whether or not to download the PDFs linked in the show notes
+	SaveChapters    bool            // whether or not to write the chapters to a JSON file next to each episode
+	Checksums       bool            // whether or not to keep a SHA256SUMS file in the show's directory
+	Conflicts       string          // what to do about episodes that might already be downloaded, or "" to ask
+	Debug           bool            // whether or not to print the debug messages of the show and its episodes
+	Settings        ShowSettings    // settings for what the show's subscription leaves out (-m, -artwork, -pictures, ...)
+	Download        DownloadOptions // settings for downloading each episode
+}
+
+// DownloadOptions holds the settings for downloading an episode that come from the command line instead of the show's
+// subscription.
+type DownloadOptions struct {
+	MaxSize      int          // largest episode (or attachment) to read, in bytes, or 0 for no limit
+	SizePolicy   LengthPolicy // how the size of the download is validated
+	KeepOriginal bool         // whether or not to save a copy of the episode exactly as it was downloaded
+	CleanTags    bool         // whether or not to discard the frames in the published tag
+	NoExternal   bool         // whether or not to refuse download links that go through a tracker
+
+	debug bool // whether or not to print the episode's debug messages, from SyncOptions.Debug
+}
+
+// CurrentSyncOptions returns the sync options from the command line.
+func CurrentSyncOptions() *SyncOptions {
+	return &SyncOptions{
+		FeedCache:       FeedCache,
+		MaxFeedSize:     MaxFeedSize,
+		StripTrackers:   StripTrackers,
+		TrashAge:        TrashAge,
+		Preset:          Preset,
+		Mirror:          Mirror,
+		Order:           DownloadOrder,
+		Filter:          EpisodeFilter,
+		SaveAttachments: SaveAttachments,
+		SaveChapters:    SaveChapters,
+		Checksums:       Checksums,
+		Conflicts:       ConflictPolicy,
+		Debug:           DebugMode,
+		Settings:        globalSettings(),
+		Download: DownloadOptions{
+			MaxSize:      MaxEpisodeSize,
+			SizePolicy:   SizePolicy,
+			KeepOriginal: KeepOriginal,
+			CleanTags:    CleanTags,
+			NoExternal:   NoExternal,
+		},
+	}
+}
+
+// downloadOptions returns the options for downloading the show's episodes.
+func (o *SyncOptions) downloadOptions() *DownloadOptions {
+	download := o.Download
+	download.debug = o.Debug
+	return &download
+}
+
+// syncOptions returns the show's sync options, falling back to the ones from the command line.
+func (s *Show) syncOptions() *SyncOptions {
+	if s == nil || s.Options == nil {
+		return CurrentSyncOptions()
+	}
+
+	return s.Options
+}
+
+// downloadOptions returns the episode's download options, falling back to the ones from the command line for episodes
+// that weren't prepared by a show with its own options.
+func (e *Episode) downloadOptions() *DownloadOptions {
+	if e == nil || e.download == nil {
+		return CurrentSyncOptions().downloadOptions()
+	}
+
+	return e.download
+}
+
+// debug prints the debug message if the show's options ask for them. It's also written to the Log File, if one was
+// specified.
+func (s *Show) debug(a ...interface{}) {
+	debugLog(s.syncOptions().Debug, a...)
+}
+
+// debug prints the debug message if the episode's options ask for them. It's also written to the Log File, if one was
+// specified.
+func (e *Episode) debug(a ...interface{}) {
+	debugLog(e.downloadOptions().debug, a...)
+}
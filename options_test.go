@@ -0,0 +1,107 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/snhilde/getcast/internal/feedtest"
+)
+
+// Test that shows synced side by side each use their own options, and that shows without options use the ones from
+// the command line.
+func TestSyncOptions(t *testing.T) {
+	feed := feedtest.Generate("Options Show", 2, 4096)
+	server := feedtest.NewServer(feed)
+	defer server.Close()
+	u, err := url.Parse(server.FeedURL(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpState, tmpCache, tmpArtwork, tmpChecksums := StateDB, TagCache, ArtworkMode, Checksums
+	defer func() { StateDB, TagCache, ArtworkMode, Checksums = tmpState, tmpCache, tmpArtwork, tmpChecksums }()
+	StateDB, TagCache, ArtworkMode, Checksums = nil, nil, ArtworkNone, true
+
+	if opts := CurrentSyncOptions(); !opts.Checksums || opts.Download.MaxSize != MaxEpisodeSize {
+		t.Error("Options don't match the command line:", opts)
+	}
+
+	dirs := make([]string, 3)
+	for i := range dirs {
+		dir, err := ioutil.TempDir("", "getcast-options")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		dirs[i] = dir
+	}
+
+	limited := &Show{URL: u, Options: &SyncOptions{Download: DownloadOptions{MaxSize: 1024}}}
+	plain := &Show{URL: u, Options: &SyncOptions{}}
+	current := &Show{URL: u}
+	var wg sync.WaitGroup
+	for i, show := range []*Show{limited, plain} {
+		wg.Add(1)
+		go func(show *Show, dir string) {
+			defer wg.Done()
+			show.Sync(dir, "")
+		}(show, dirs[i])
+	}
+	wg.Wait()
+
+	if len(limited.Failures) != 2 {
+		t.Error("Incorrect failures with a size limit - Want: 2 Have:", len(limited.Failures))
+	}
+	if len(plain.Failures) != 0 || len(plain.New) != 2 {
+		t.Error("Incorrect downloads without options - Want: 2 Have:", len(plain.New), plain.Failures)
+	}
+	if _, err := os.Stat(filepath.Join(dirs[1], "Options Show", ChecksumsFile)); !os.IsNotExist(err) {
+		t.Error("Wrote checksums that the show's options leave out")
+	}
+
+	if _, _, err := current.Sync(dirs[2], ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dirs[2], "Options Show", ChecksumsFile)); err != nil {
+		t.Error("Didn't write checksums from the command line:", err)
+	}
+}
+
+// Test that a show's settings fall back to its options instead of the command line, and that its episodes get its
+// settings, conflict policy, and debug setting.
+func TestOptionsSettings(t *testing.T) {
+	tmpWidth, tmpArtwork, tmpDebug := PrefixMinWidth, ArtworkMode, DebugMode
+	defer func() { PrefixMinWidth, ArtworkMode, DebugMode = tmpWidth, tmpArtwork, tmpDebug }()
+	PrefixMinWidth, ArtworkMode, DebugMode = 2, ArtworkExternal, false
+
+	opts := &SyncOptions{Conflicts: ConflictDownload, Debug: true, Settings: ShowSettings{MinWidth: intSetting(3)}}
+	sub := Subscription{URL: "https://example.com/feed.xml", ShowSettings: ShowSettings{Keep: intSetting(5)}}
+	show, err := NewShow(sub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	show.Options = opts
+
+	settings := show.options()
+	if intValue(settings.MinWidth) != 3 || intValue(settings.Keep) != 5 {
+		t.Error("Incorrect settings - Want: 3 5 Have:", intValue(settings.MinWidth), intValue(settings.Keep))
+	}
+	if settings.Artwork != ArtworkEmbed || intValue(settings.Attempts) != 1 {
+		t.Error("Incorrect defaults - Want:", ArtworkEmbed, 1, "Have:", settings.Artwork, intValue(settings.Attempts))
+	}
+	if have := (&Show{}).options().Artwork; have != ArtworkExternal {
+		t.Error("Incorrect artwork without options - Want:", ArtworkExternal, "Have:", have)
+	}
+
+	episode := Episode{settings: settings, download: opts.downloadOptions()}
+	if intValue(episode.options().MinWidth) != 3 || !episode.downloadOptions().debug {
+		t.Error("Episode doesn't have the show's options")
+	}
+	if !resolveConflict(&episode, "test", show.syncOptions().Conflicts) {
+		t.Error("Conflict policy from the options was not used")
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// originalSuffix is added to the episode's filename for the copy of the file exactly as the server sent it, kept with
+// -keep-original so that the tag that getcast wrote can be compared against the publisher's.
+const originalSuffix = ".orig"
+
+// openOriginal opens the copy of the file as the server sent it, for -keep-original. A resumed download picks up the
+// copy at the same offset as the download, which only works if the last attempt was also keeping a copy.
+func openOriginal(filename string, offset int) (*os.File, error) {
+	path := filename + originalSuffix
+	if offset <= 0 {
+		return os.Create(path)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := file.Stat(); err != nil || info.Size() < int64(offset) {
+		file.Close()
+		os.Remove(path)
+		return nil, fmt.Errorf("the copy from the last attempt is incomplete")
+	}
+	if err := file.Truncate(int64(offset)); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekEnd); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return file, nil
+}
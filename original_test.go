@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+// Test that -keep-original saves the bytes as the server sent them, including across a resumed download.
+func TestKeepOriginal(t *testing.T) {
+	content := append([]byte{0xff, 0xfb, 0x90, 0x64}, bytes.Repeat([]byte("0123456789"), 10000)...)
+
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			// Promise the whole file but only send part of it.
+			w.Header().Set("Content-Length", "100004")
+			w.Write(content[:50000])
+			return
+		}
+		http.ServeContent(w, r, "episode.mp3", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "getcast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	KeepOriginal = true
+	defer func() { KeepOriginal = false }()
+
+	episode := Episode{Title: "Episode", Enclosure: Enclosure{URL: server.URL + "/episode.mp3", Type: "audio/mpeg"}}
+	episode.settings = &ShowSettings{Artwork: ArtworkNone}
+	if err := episode.Download(dir); err != errDownload {
+		t.Fatal("First attempt - Want:", errDownload, "Have:", err)
+	}
+	if err := episode.Download(dir); err != nil {
+		t.Fatal("Second attempt - Want: nil Have:", err)
+	}
+
+	original, err := ioutil.ReadFile(episode.path + originalSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(original, content) {
+		t.Error("Original - Want:", len(content), "bytes Have:", len(original), "bytes")
+	}
+
+	tagged, err := ioutil.ReadFile(episode.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(tagged, []byte("ID3")) || !bytes.HasSuffix(tagged, content) {
+		t.Error("Episode was not tagged as usual")
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FeedParser reads a feed in one format. Parse returns an error if the data isn't a feed it understands, so that the
+// next parser in the chain can try it.
+type FeedParser interface {
+	Parse(data []byte) (*Feed, error)
+}
+
+// FeedParserFunc lets an ordinary function be used as a FeedParser.
+type FeedParserFunc func(data []byte) (*Feed, error)
+
+// Parse calls the function.
+func (f FeedParserFunc) Parse(data []byte) (*Feed, error) {
+	return f(data)
+}
+
+// namedParser is a parser in the chain, with the name used for logging.
+type namedParser struct {
+	name   string
+	parser FeedParser
+}
+
+// feedParsers is the chain of parsers that ParseFeed tries, in order: strict RSS, Atom, JSON Feed, and finally a lenient
+// RSS parser that copes with the mistakes that sometimes make it into published feeds.
+var feedParsers = []namedParser{
+	{"RSS", FeedParserFunc(parseRSS)},
+	{"Atom", FeedParserFunc(parseAtom)},
+	{"JSON Feed", FeedParserFunc(parseJSONFeed)},
+	{"lenient RSS", FeedParserFunc(parseLenientRSS)},
+}
+
+// RegisterFeedParser adds a parser for feeds from a particular host or in a particular format. Registered parsers are
+// tried before the built-in ones, in the order they were registered, so they should only accept feeds that they know
+// are theirs.
+func RegisterFeedParser(name string, parser FeedParser) {
+	registered := 0
+	for registered < len(feedParsers) && !isBuiltinParser(feedParsers[registered].name) {
+		registered++
+	}
+
+	feedParsers = append(feedParsers[:registered], append([]namedParser{{name, parser}}, feedParsers[registered:]...)...)
+}
+
+// isBuiltinParser reports whether or not the parser with the name is one of the built-in ones.
+func isBuiltinParser(name string) bool {
+	switch name {
+	case "RSS", "Atom", "JSON Feed", "lenient RSS":
+		return true
+	}
+
+	return false
+}
+
+// ParseFeed parses the feed with the first parser in the chain that accepts it. If none do, the error from the strict
+// RSS parser is returned, since that's the format most feeds are meant to be in.
+func ParseFeed(data []byte) (*Feed, error) {
+	var rssErr error
+	for _, p := range feedParsers {
+		feed, err := p.parser.Parse(data)
+		if err == nil {
+			if p.name != "RSS" {
+				Debug("Parsed feed as", p.name)
+			}
+			return feed, nil
+		}
+
+		Debug("Feed isn't", p.name+":", err)
+		if p.name == "RSS" {
+			rssErr = err
+		}
+	}
+
+	if rssErr == nil {
+		rssErr = fmt.Errorf("unrecognized feed format")
+	}
+	return nil, rssErr
+}
+
+// parseRSS parses an RSS feed.
+func parseRSS(data []byte) (*Feed, error) {
+	show := new(Show)
+	if err := xml.Unmarshal(data, show); err != nil {
+		return nil, err
+	}
+	if show.Title == "" {
+		return nil, fmt.Errorf("no show information found")
+	}
+
+	return NewFeed(show), nil
+}
+
+// parseLenientRSS parses an RSS feed that isn't well-formed XML, such as one with HTML entities, unclosed tags, or
+// stray control characters.
+func parseLenientRSS(data []byte) (*Feed, error) {
+	cleaned := bytes.Map(func(r rune) rune {
+		if r < 0x20 && r != '\t' && r != '\n' && r != '\r' {
+			return -1
+		}
+		return r
+	}, data)
+
+	decoder := xml.NewDecoder(bytes.NewReader(cleaned))
+	decoder.Strict = false
+	decoder.AutoClose = xml.HTMLAutoClose
+	decoder.Entity = xml.HTMLEntity
+
+	show := new(Show)
+	if err := decoder.Decode(show); err != nil {
+		return nil, err
+	}
+	if show.Title == "" {
+		return nil, fmt.Errorf("no show information found")
+	}
+
+	return NewFeed(show), nil
+}
+
+// atomFeed is an Atom feed, with the iTunes extensions that podcast feeds in Atom sometimes carry.
+type atomFeed struct {
+	XMLName  xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title    string      `xml:"title"`
+	Subtitle string      `xml:"subtitle"`
+	Author   string      `xml:"author>name"`
+	Icon     string      `xml:"icon"`
+	Logo     string      `xml:"logo"`
+	Rights   string      `xml:"rights"`
+	Image    string      `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image,href"`
+	Links    []atomLink  `xml:"link"`
+	Entries  []atomEntry `xml:"entry"`
+}
+
+// atomEntry is an entry in an Atom feed.
+type atomEntry struct {
+	ID        string     `xml:"id"`
+	Title     string     `xml:"title"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+	Summary   string     `xml:"summary"`
+	Content   string     `xml:"content"`
+	Links     []atomLink `xml:"link"`
+	Duration  string     `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration"`
+	Season    string     `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd season"`
+	Episode   string     `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd episode"`
+	Image     string     `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image,href"`
+	Explicit  string     `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd explicit"`
+}
+
+// atomLink is a link in an Atom entry or feed. Enclosures are links with the "enclosure" relation, and feeds name their
+// WebSub hub with the "hub" relation.
+type atomLink struct {
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr"`
+	Type   string `xml:"type,attr"`
+	Length string `xml:"length,attr"`
+}
+
+// parseAtom parses an Atom feed.
+func parseAtom(data []byte) (*Feed, error) {
+	var atom atomFeed
+	if err := xml.Unmarshal(data, &atom); err != nil {
+		return nil, err
+	}
+	if atom.Title == "" {
+		return nil, fmt.Errorf("no show information found")
+	}
+
+	feed := &Feed{
+		Title:       strings.TrimSpace(atom.Title),
+		Author:      strings.TrimSpace(atom.Author),
+		Description: atom.Subtitle,
+		Image:       firstNonEmpty(atom.Image, atom.Logo, atom.Icon),
+		Copyright:   atom.Rights,
+	}
+	feed.Hub, feed.Self = webSubLinks(atom.Links)
+	for _, entry := range atom.Entries {
+		item := Item{
+			GUID:        entry.ID,
+			Title:       strings.TrimSpace(entry.Title),
+			Date:        pubDate(firstNonEmpty(entry.Published, entry.Updated)),
+			Season:      entry.Season,
+			Number:      entry.Episode,
+			Duration:    entry.Duration,
+			Description: firstNonEmpty(entry.Summary, entry.Content),
+			Content:     entry.Content,
+			Image:       entry.Image,
+			Explicit:    entry.Explicit,
+		}
+		for _, link := range entry.Links {
+			switch link.Rel {
+			case "enclosure":
+				item.Enclosures = append(item.Enclosures, Enclosure{URL: link.Href, Size: link.Length, Type: link.Type})
+			case "", "alternate":
+				item.Links = append(item.Links, link.Href)
+			}
+		}
+		item.Published = parseDate(item.Date)
+		item.Key = firstNonEmpty(item.GUID, item.Title)
+		feed.Items = append(feed.Items, item)
+	}
+
+	return feed, nil
+}
+
+// jsonFeed is a feed in the JSON Feed format, version 1 or 1.1.
+type jsonFeed struct {
+	Version     string           `json:"version"`
+	Title       string           `json:"title"`
+	Description string           `json:"description"`
+	Icon        string           `json:"icon"`
+	Language    string           `json:"language"`
+	Author      *jsonFeedAuthor  `json:"author"`
+	Authors     []jsonFeedAuthor `json:"authors"`
+	Items       []jsonFeedItem   `json:"items"`
+}
+
+// jsonFeedAuthor is the author of a JSON Feed or one of its items.
+type jsonFeedAuthor struct {
+	Name string `json:"name"`
+}
+
+// jsonFeedItem is an item in a JSON Feed.
+type jsonFeedItem struct {
+	ID            string               `json:"id"`
+	URL           string               `json:"url"`
+	Title         string               `json:"title"`
+	ContentHTML   string               `json:"content_html"`
+	ContentText   string               `json:"content_text"`
+	Summary       string               `json:"summary"`
+	Image         string               `json:"image"`
+	DatePublished string               `json:"date_published"`
+	Attachments   []jsonFeedAttachment `json:"attachments"`
+}
+
+// jsonFeedAttachment is a file attached to an item in a JSON Feed.
+type jsonFeedAttachment struct {
+	URL      string  `json:"url"`
+	MimeType string  `json:"mime_type"`
+	Size     int64   `json:"size_in_bytes"`
+	Duration float64 `json:"duration_in_seconds"`
+}
+
+// parseJSONFeed parses a feed in the JSON Feed format.
+func parseJSONFeed(data []byte) (*Feed, error) {
+	var jf jsonFeed
+	if err := json.Unmarshal(data, &jf); err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(jf.Version, "https://jsonfeed.org/version/") {
+		return nil, fmt.Errorf("not a JSON Feed")
+	}
+	if jf.Title == "" {
+		return nil, fmt.Errorf("no show information found")
+	}
+
+	feed := &Feed{
+		Title:       strings.TrimSpace(jf.Title),
+		Description: jf.Description,
+		Image:       jf.Icon,
+		Language:    jf.Language,
+	}
+	if jf.Author != nil {
+		feed.Author = jf.Author.Name
+	} else if len(jf.Authors) > 0 {
+		feed.Author = jf.Authors[0].Name
+	}
+
+	for _, ji := range jf.Items {
+		item := Item{
+			GUID:        ji.ID,
+			Title:       strings.TrimSpace(ji.Title),
+			Date:        pubDate(ji.DatePublished),
+			Description: firstNonEmpty(ji.Summary, ji.ContentText, ji.ContentHTML),
+			Content:     ji.ContentHTML,
+			Image:       ji.Image,
+		}
+		if ji.URL != "" {
+			item.Links = append(item.Links, ji.URL)
+		}
+		for _, attachment := range ji.Attachments {
+			enclosure := Enclosure{URL: attachment.URL, Type: attachment.MimeType}
+			if attachment.Size > 0 {
+				enclosure.Size = strconv.FormatInt(attachment.Size, 10)
+			}
+			item.Enclosures = append(item.Enclosures, enclosure)
+			if item.Duration == "" && attachment.Duration > 0 {
+				item.Duration = strconv.Itoa(int(attachment.Duration))
+			}
+		}
+		item.Published = parseDate(item.Date)
+		item.Key = firstNonEmpty(item.GUID, item.Title)
+		feed.Items = append(feed.Items, item)
+	}
+
+	return feed, nil
+}
+
+// pubDate converts an RFC 3339 timestamp, as Atom and JSON Feed use, into the RFC 1123 format of RSS's pubDate. Dates
+// in other formats are returned unchanged.
+func pubDate(date string) string {
+	date = strings.TrimSpace(date)
+	if ts, err := time.Parse(time.RFC3339, date); err == nil {
+		return ts.Format(time.RFC1123Z)
+	}
+
+	return date
+}
+
+// firstNonEmpty returns the first of the values that isn't empty, or "" if they all are.
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			return value
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// Test that each format in the chain is recognized and read into the same model.
+func TestParseFeed(t *testing.T) {
+	feeds := map[string]string{
+		"RSS": `<rss><channel><title>Show</title>
+			<item><title>One</title><guid>1</guid><pubDate>Mon, 02 Jan 2023 15:04:05 +0000</pubDate>
+			<enclosure url="https://example.com/1.mp3" length="100" type="audio/mpeg"/></item>
+		</channel></rss>`,
+		"Atom": `<feed xmlns="http://www.w3.org/2005/Atom"><title>Show</title>
+			<entry><id>1</id><title>One</title><published>2023-01-02T15:04:05Z</published>
+			<link rel="enclosure" href="https://example.com/1.mp3" length="100" type="audio/mpeg"/></entry>
+		</feed>`,
+		"JSON Feed": `{"version": "https://jsonfeed.org/version/1.1", "title": "Show", "items": [
+			{"id": "1", "title": "One", "date_published": "2023-01-02T15:04:05Z",
+			"attachments": [{"url": "https://example.com/1.mp3", "mime_type": "audio/mpeg", "size_in_bytes": 100}]}
+		]}`,
+		"lenient RSS": "<rss><channel><title>Show&nbsp;</title>\x01" + `
+			<item><title>One</title><guid>1</guid><pubDate>Mon, 02 Jan 2023 15:04:05 +0000</pubDate>
+			<enclosure url="https://example.com/1.mp3" length="100" type="audio/mpeg"><br></item>
+		</channel></rss>`,
+	}
+
+	for format, data := range feeds {
+		feed, err := ParseFeed([]byte(data))
+		if err != nil {
+			t.Error(format, "- Error parsing feed:", err)
+			continue
+		}
+		if len(feed.Items) != 1 {
+			t.Error(format, "- Incorrect number of items - Want: 1 Have:", len(feed.Items))
+			continue
+		}
+
+		item := feed.Items[0]
+		enclosure := item.enclosure()
+		if item.Key != "1" || item.Title != "One" || enclosure.URL != "https://example.com/1.mp3" || enclosure.Size != "100" {
+			t.Error(format, "- Incorrect item:", item)
+		}
+		if want := "2023-01-02T15:04:05Z"; item.Published.UTC().Format("2006-01-02T15:04:05Z") != want {
+			t.Error(format, "- Incorrect publish date - Want:", want, "Have:", item.Published)
+		}
+	}
+
+	if _, err := ParseFeed([]byte("not a feed")); err == nil {
+		t.Error("Unrecognized data was parsed")
+	}
+}
+
+// Test that registered parsers are tried before the built-in ones.
+func TestRegisterFeedParser(t *testing.T) {
+	saved := append([]namedParser(nil), feedParsers...)
+	defer func() { feedParsers = saved }()
+
+	RegisterFeedParser("first", FeedParserFunc(func(data []byte) (*Feed, error) {
+		return nil, fmt.Errorf("not mine")
+	}))
+	RegisterFeedParser("second", FeedParserFunc(func(data []byte) (*Feed, error) {
+		return &Feed{Title: "Second"}, nil
+	}))
+
+	if feedParsers[0].name != "first" || feedParsers[1].name != "second" || feedParsers[2].name != "RSS" {
+		t.Error("Parsers were registered out of order:", feedParsers)
+	}
+
+	feed, err := ParseFeed([]byte(`<rss><channel><title>Show</title></channel></rss>`))
+	if err != nil || feed.Title != "Second" {
+		t.Error("Registered parser was not used:", feed, err)
+	}
+}
+
+// Test that items without an enclosure take their audio from a media:content element or from an item link to an audio
+// file, and that items without any audio are dropped.
+func TestResolveMedia(t *testing.T) {
+	data := `<rss xmlns:media="http://search.yahoo.com/mrss/"><channel><title>Show</title>
+		<item><title>Media</title><guid>1</guid>
+			<media:content url="https://example.com/1.jpg" type="image/jpeg"/>
+			<media:content url="https://example.com/1.m4a" type="audio/mp4" fileSize="100"/></item>
+		<item><title>Group</title><guid>2</guid>
+			<media:group><media:content url="https://example.com/2" medium="audio"/></media:group></item>
+		<item><title>Link</title><guid>3</guid>
+			<link>https://example.com/post/3</link>
+			<link> https://example.com/3.mp3?source=rss </link></item>
+		<item><title>Post</title><guid>4</guid><link>https://example.com/post/4</link></item>
+	</channel></rss>`
+
+	feed, err := ParseFeed([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	show := &Show{}
+	feed.fill(show)
+	if len(show.Episodes) != 4 {
+		t.Fatal("Incorrect number of items - Want: 4 Have:", len(show.Episodes))
+	}
+
+	want := map[string]Enclosure{
+		"Media": {URL: "https://example.com/1.m4a", Type: "audio/mp4", Size: "100"},
+		"Group": {URL: "https://example.com/2"},
+		"Link":  {URL: "https://example.com/3.mp3?source=rss"},
+	}
+	for _, episode := range show.Episodes {
+		selected := episode.SelectEnclosures(EnclosuresFirst)
+		enclosure, ok := want[episode.Title]
+		if !ok {
+			if selected != nil {
+				t.Error("Item without audio was kept:", episode.Title)
+			}
+			continue
+		}
+
+		if len(selected) != 1 {
+			t.Error(episode.Title, "- Incorrect number of episodes - Want: 1 Have:", len(selected))
+		} else if selected[0].Enclosure != enclosure {
+			t.Error(episode.Title, "- Incorrect enclosure - Want:", enclosure, "Have:", selected[0].Enclosure)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// IsPaused returns whether or not scheduled syncs should skip the show with the feed URL, either because the show is
+// paused or because everything is paused.
+func (s *State) IsPaused(feedURL string) bool {
+	if s == nil {
+		return false
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.Paused {
+		return true
+	}
+
+	show, ok := s.Shows[feedURL]
+	return ok && show.Paused
+}
+
+// SetPaused pauses or resumes the show identified by either its feed URL or its title. If the show is "", everything is
+// paused or resumed.
+func (s *State) SetPaused(show string, config *Config, paused bool) error {
+	if s == nil {
+		return fmt.Errorf("no state available")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if show == "" {
+		s.Paused = paused
+		s.dirtyPaused = true
+		return nil
+	}
+
+	feedURL := s.findShow(show, config)
+	if feedURL == "" {
+		return fmt.Errorf("show not found: %v", show)
+	}
+
+	// If we haven't synced this subscription yet, we can still pause it.
+	ss := s.show(feedURL)
+	ss.Paused = paused
+	s.touchShow(feedURL)
+
+	return nil
+}
+
+// findShow finds the feed URL of the show identified by either its feed URL or its title (case-insensitive), looking
+// in both the state and the config's subscriptions. The caller must hold the lock.
+func (s *State) findShow(show string, config *Config) string {
+	if _, ok := s.Shows[show]; ok {
+		return show
+	}
+
+	if config != nil {
+		for _, sub := range config.Subscriptions {
+			if sub.URL == show {
+				return show
+			}
+		}
+	}
+
+	for feedURL, ss := range s.Shows {
+		if strings.EqualFold(ss.Title, show) {
+			return feedURL
+		}
+	}
+
+	return ""
+}
+
+// runPause pauses or resumes scheduled syncs for the show in args, or for everything if no show is given.
+func runPause(state *State, config *Config, args []string, paused bool) error {
+	show := strings.Join(args, " ")
+	if err := state.SetPaused(show, config, paused); err != nil {
+		return err
+	}
+
+	if err := state.Save(); err != nil {
+		return fmt.Errorf("error saving state: %v", err)
+	}
+
+	action := "Resumed"
+	if paused {
+		action = "Paused"
+	}
+	if show == "" {
+		Log(action, "all scheduled syncs")
+	} else {
+		Log(action, show)
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+)
+
+// Person is a podcast:person element from the Podcasting 2.0 namespace, naming someone involved in the show or episode.
+type Person struct {
+	Name  string `xml:",chardata" json:"name"`
+	Role  string `xml:"role,attr" json:"role,omitempty"`
+	Group string `xml:"group,attr" json:"group,omitempty"`
+	Href  string `xml:"href,attr" json:"href,omitempty"`
+}
+
+// role returns the person's role in lowercase, defaulting to "host" as the namespace specifies.
+func (p Person) role() string {
+	if role := strings.ToLower(strings.TrimSpace(p.Role)); role != "" {
+		return role
+	}
+
+	return "host"
+}
+
+// isMusician reports whether the person is credited for the music rather than the production of the episode.
+func (p Person) isMusician() bool {
+	return strings.EqualFold(strings.TrimSpace(p.Group), "music")
+}
+
+// isComposer reports whether the person should be credited as the composer.
+func (p Person) isComposer() bool {
+	return p.role() == "composer"
+}
+
+// People returns everyone credited for this episode. People listed on the episode itself replace the show's list.
+func (e *Episode) People() []Person {
+	if e == nil {
+		return nil
+	}
+
+	people := e.Persons
+	if len(people) == 0 {
+		people = e.showPeople
+	}
+
+	var clean []Person
+	for _, person := range people {
+		person.Name = strings.TrimSpace(person.Name)
+		if person.Name != "" {
+			clean = append(clean, person)
+		}
+	}
+
+	return clean
+}
+
+// creditFrames returns the values for the involved people (TIPL/IPLS), musician credits (TMCL), and composer (TCOM)
+// frames. The credit lists are null-separated role/name pairs as the ID3 standard specifies. ID3v2.3 and earlier have no
+// musician credits list, so musicians are merged into the involved people list for those versions.
+func (e *Episode) creditFrames(version byte) (involved string, musicians string, composer string) {
+	var involvedPairs, musicianPairs, composers []string
+	for _, person := range e.People() {
+		switch {
+		case person.isComposer():
+			composers = append(composers, person.Name)
+		case person.isMusician() && version >= 4:
+			musicianPairs = append(musicianPairs, person.role(), person.Name)
+		default:
+			involvedPairs = append(involvedPairs, person.role(), person.Name)
+		}
+	}
+
+	return strings.Join(involvedPairs, "\x00"), strings.Join(musicianPairs, "\x00"), strings.Join(composers, "/")
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+// Test that podcast:person credits are read from each episode, falling back to its show, and split into the involved
+// people, musician, and composer frames for each ID3 version.
+func TestCreditFrames(t *testing.T) {
+	feed := `<rss xmlns:podcast="https://podcastindex.org/namespace/1.0">
+<channel>
+	<title>Show</title>
+	<podcast:person>Show Host</podcast:person>
+	<item><title>Inherited</title></item>
+	<item>
+		<title>Credited</title>
+		<podcast:person role="Guest">Guest Star</podcast:person>
+		<podcast:person role="composer" group="music">First Composer</podcast:person>
+		<podcast:person role="composer" group="music">Second Composer</podcast:person>
+		<podcast:person role="guitarist" group="Music">Player</podcast:person>
+		<podcast:person role="guest">  </podcast:person>
+	</item>
+</channel>
+</rss>`
+
+	var s Show
+	if err := xml.Unmarshal([]byte(feed), &s); err != nil {
+		t.Fatal(err)
+	}
+	for i := range s.Episodes {
+		s.Episodes[i].SetShowPeople(s.People)
+	}
+
+	if involved, _, _ := s.Episodes[0].creditFrames(4); involved != "host\x00Show Host" {
+		t.Errorf("Inherited credits - Want: %q Have: %q", "host\x00Show Host", involved)
+	}
+
+	credited := &s.Episodes[1]
+	if people := credited.People(); len(people) != 4 {
+		t.Error("Incorrect number of people - Want: 4 Have:", len(people))
+	}
+
+	involved, musicians, composer := credited.creditFrames(4)
+	if involved != "guest\x00Guest Star" || musicians != "guitarist\x00Player" {
+		t.Errorf("v2.4 credits - Want: %q, %q Have: %q, %q", "guest\x00Guest Star", "guitarist\x00Player", involved,
+			musicians)
+	}
+	if composer != "First Composer/Second Composer" {
+		t.Error("Composer - Want: First Composer/Second Composer Have:", composer)
+	}
+
+	// ID3v2.3 has no musician credits list, so the musicians are listed with everyone else.
+	involved, musicians, _ = credited.creditFrames(3)
+	if involved != "guest\x00Guest Star\x00guitarist\x00Player" || musicians != "" {
+		t.Errorf("v2.3 credits - Want: %q Have: %q, %q", "guest\x00Guest Star\x00guitarist\x00Player", involved,
+			musicians)
+	}
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/text/encoding/unicode"
+)
+
+// These are the picture types that are used by default.
+const (
+	PictureOtherIcon  = 0x02 // show logo
+	PictureFrontCover = 0x03 // episode or show artwork
+)
+
+// pictureTypes maps the names of the ID3 picture types to their values.
+var pictureTypes = map[string]byte{
+	"other":              0x00,
+	"file-icon":          0x01,
+	"other-icon":         0x02,
+	"cover-front":        0x03,
+	"cover-back":         0x04,
+	"leaflet":            0x05,
+	"media":              0x06,
+	"lead-artist":        0x07,
+	"artist":             0x08,
+	"conductor":          0x09,
+	"band":               0x0A,
+	"composer":           0x0B,
+	"lyricist":           0x0C,
+	"recording-location": 0x0D,
+	"during-recording":   0x0E,
+	"during-performance": 0x0F,
+	"screen-capture":     0x10,
+	"fish":               0x11,
+	"illustration":       0x12,
+	"band-logo":          0x13,
+	"publisher-logo":     0x14,
+}
+
+// These are the images that can be embedded as pictures.
+const (
+	PictureSourceEpisode = "episode" // episode's artwork, or the show's if the episode doesn't have any
+	PictureSourceShow    = "show"    // show's artwork
+)
+
+// PictureRule embeds the image from the source as a picture of this type.
+type PictureRule struct {
+	Source string
+	Type   byte
+}
+
+// PictureRules is the list of pictures to embed in each episode's metadata. It can be set on the command line once per
+// rule or with a comma-separated list of rules, each given as "source=type", e.g. "show=other-icon". If there are no
+// rules, only the episode's artwork is embedded as the front cover.
+type PictureRules []PictureRule
+
+// String returns the rules as a comma-separated list.
+func (r *PictureRules) String() string {
+	if r == nil {
+		return ""
+	}
+
+	var rules []string
+	for _, rule := range *r {
+		rules = append(rules, rule.Source+"="+pictureTypeName(rule.Type))
+	}
+
+	return strings.Join(rules, ",")
+}
+
+// pictureTypeName returns the name of the picture type, or its number if it doesn't have one.
+func pictureTypeName(t byte) string {
+	for name, value := range pictureTypes {
+		if value == t {
+			return name
+		}
+	}
+
+	return strconv.Itoa(int(t))
+}
+
+// Set adds the rules in the comma-separated list. Picture types can be given by name or by number.
+func (r *PictureRules) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid picture: %v (want source=type)", item)
+		}
+
+		source := strings.ToLower(strings.TrimSpace(parts[0]))
+		if source != PictureSourceEpisode && source != PictureSourceShow {
+			return fmt.Errorf("invalid picture source: %v", parts[0])
+		}
+
+		name := strings.ToLower(strings.TrimSpace(parts[1]))
+		t, ok := pictureTypes[name]
+		if !ok {
+			n, err := strconv.Atoi(name)
+			if err != nil || n < 0 || n > 0x14 {
+				return fmt.Errorf("invalid picture type: %v", parts[1])
+			}
+			t = byte(n)
+		}
+
+		*r = append(*r, PictureRule{Source: source, Type: t})
+	}
+
+	return nil
+}
+
+// pictureRules returns the rules for the pictures to embed in the episode's metadata.
+func (e *Episode) pictureRules() PictureRules {
+	rules := e.options().Pictures
+	if len(rules) == 0 {
+		return PictureRules{{Source: PictureSourceEpisode, Type: PictureFrontCover}}
+	}
+
+	return rules
+}
+
+// link returns the link to the rule's image for the episode.
+func (r PictureRule) link(e *Episode) string {
+	if r.Source == PictureSourceShow {
+		return e.showImage
+	}
+
+	return e.imageLink()
+}
+
+// Picture is an image attached to the metadata in an APIC frame (PIC for ID3v2.2).
+type Picture struct {
+	MIME string // MIME type of the image, e.g. "image/jpeg"
+	Type byte   // what the picture shows, e.g. 0x03 for the front cover
+	Desc string // description of the picture
+	Data []byte // raw image data
+}
+
+// imageTypes maps the MIME types of images to the 3-character image formats used by ID3v2.2 and the file extensions
+// that the images are saved with.
+var imageTypes = map[string]struct{ format, ext string }{
+	"image/jpeg": {"JPG", ".jpg"},
+	"image/png":  {"PNG", ".png"},
+	"image/gif":  {"GIF", ".gif"},
+	"image/bmp":  {"BMP", ".bmp"},
+	"image/webp": {"WEB", ".webp"},
+}
+
+// NewPicture creates a front cover picture from the image data, detecting the image's MIME type.
+func NewPicture(data []byte) Picture {
+	return Picture{MIME: http.DetectContentType(data), Type: PictureFrontCover, Data: data}
+}
+
+// Ext returns the file extension for the picture's image type, detecting it from the data if the frame didn't say.
+func (p Picture) Ext() string {
+	if t, ok := imageTypes[p.MIME]; ok {
+		return t.ext
+	}
+
+	if t, ok := imageTypes[http.DetectContentType(p.Data)]; ok {
+		return t.ext
+	}
+
+	return ".img"
+}
+
+// frameValue builds the value of the picture's frame for this version of ID3, including the encoding byte. The
+// description is always written as ISO-8859-1, since ID3v2.2 and v2.3 don't support UTF-8.
+func (p Picture) frameValue(version byte) []byte {
+	buf := new(bytes.Buffer)
+	buf.WriteByte(0x00)
+
+	if version == 2 {
+		format := "JPG"
+		if t, ok := imageTypes[p.MIME]; ok {
+			format = t.format
+		}
+		buf.WriteString(format)
+	} else {
+		buf.WriteString(p.MIME)
+		buf.WriteByte(0x00)
+	}
+
+	buf.WriteByte(p.Type)
+	buf.WriteString(p.Desc)
+	buf.WriteByte(0x00)
+	buf.Write(p.Data)
+
+	return buf.Bytes()
+}
+
+// parsePicture parses the value of a picture frame, as stored in the metadata with its encoding byte.
+func parsePicture(id string, value []byte) (Picture, error) {
+	var p Picture
+	if len(value) < 1 {
+		return p, fmt.Errorf("empty %v frame", id)
+	}
+
+	encoding := value[0]
+	rest := value[1:]
+
+	if strings.ToUpper(id) == "PIC" {
+		if len(rest) < 3 {
+			return p, fmt.Errorf("short %v frame", id)
+		}
+		format := strings.ToUpper(string(rest[:3]))
+		for mime, t := range imageTypes {
+			if t.format == format {
+				p.MIME = mime
+			}
+		}
+		rest = rest[3:]
+	} else {
+		end := bytes.IndexByte(rest, 0x00)
+		if end < 0 {
+			return p, fmt.Errorf("missing MIME type in %v frame", id)
+		}
+		p.MIME = strings.ToLower(string(rest[:end]))
+		rest = rest[end+1:]
+	}
+
+	if len(rest) < 1 {
+		return p, fmt.Errorf("missing picture type in %v frame", id)
+	}
+	p.Type = rest[0]
+	rest = rest[1:]
+
+	// The description ends with a null character, which is 2 bytes for the UTF-16 encodings.
+	switch encoding {
+	case 0x01, 0x02:
+		end := -1
+		for i := 0; i+1 < len(rest); i += 2 {
+			if rest[i] == 0x00 && rest[i+1] == 0x00 {
+				end = i
+				break
+			}
+		}
+		if end < 0 {
+			return p, fmt.Errorf("missing description in %v frame", id)
+		}
+		decoder := unicode.UTF16(unicode.BigEndian, unicode.ExpectBOM).NewDecoder()
+		if encoding == 0x02 {
+			decoder = unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM).NewDecoder()
+		}
+		desc, _ := decoder.Bytes(rest[:end])
+		p.Desc = string(desc)
+		rest = rest[end+2:]
+	default:
+		end := bytes.IndexByte(rest, 0x00)
+		if end < 0 {
+			return p, fmt.Errorf("missing description in %v frame", id)
+		}
+		p.Desc = string(rest[:end])
+		rest = rest[end+1:]
+	}
+
+	// Some taggers write a link to the image instead of the image itself.
+	if p.MIME == "-->" {
+		return p, fmt.Errorf("%v frame links to %v instead of embedding the image", id, string(rest))
+	}
+
+	if p.MIME == "" || p.MIME == "image/jpg" {
+		p.MIME = http.DetectContentType(rest)
+	}
+	p.Data = rest
+
+	return p, nil
+}
+
+// Pictures returns all of the pictures attached to the metadata. Frames that can't be parsed are skipped.
+func (m *Meta) Pictures() []Picture {
+	id := "APIC"
+	if m.Version() == 2 {
+		id = "PIC"
+	}
+
+	var pictures []Picture
+	for _, value := range m.GetValues(id) {
+		p, err := parsePicture(id, value)
+		if err != nil {
+			Debug("Skipping picture:", err)
+			continue
+		}
+		pictures = append(pictures, p)
+	}
+
+	return pictures
+}
+
+// runExtractArt saves the pictures embedded in an audio file's metadata. Without -o, each picture is saved next to the
+// file with the same name and the extension for its image type. If there's more than one picture, the ones after the
+// first are numbered, e.g. "cover-2.png".
+func runExtractArt(args []string) error {
+	if len(args) == 0 {
+		Log("No file specified")
+		return errUsage
+	}
+	file := args[0]
+
+	fs := flag.NewFlagSet("extract-art", flag.ContinueOnError)
+	output := fs.String("o", "", "Optional. Path to save the image to")
+	if err := fs.Parse(args[1:]); err != nil {
+		return errUsage
+	}
+
+	meta, err := readFileMeta(file)
+	if err != nil {
+		return err
+	}
+
+	pictures := meta.Pictures()
+	if len(pictures) == 0 {
+		return fmt.Errorf("no embedded artwork in %v", file)
+	}
+
+	for i, p := range pictures {
+		var path string
+		if *output != "" {
+			ext := filepath.Ext(*output)
+			path = strings.TrimSuffix(*output, ext)
+			if i > 0 {
+				path += fmt.Sprintf("-%d", i+1)
+			}
+			if i > 0 || ext == "" {
+				ext = p.Ext()
+			}
+			path += ext
+		} else {
+			path = strings.TrimSuffix(file, filepath.Ext(file))
+			if i > 0 {
+				path += fmt.Sprintf("-%d", i+1)
+			}
+			path += p.Ext()
+		}
+
+		if err := ioutil.WriteFile(path, p.Data, 0644); err != nil {
+			return err
+		}
+		Log("Saved", p.MIME, "image to", path)
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Test that picture frames are parsed for each ID3 version and text encoding, and that linked pictures are rejected.
+func TestParsePicture(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR")
+
+	// Round trip through the frame values that we write.
+	for _, version := range []byte{2, 3, 4} {
+		id := "APIC"
+		if version == 2 {
+			id = "PIC"
+		}
+		want := Picture{MIME: "image/png", Type: PictureFrontCover, Desc: "Cover", Data: png}
+		have, err := parsePicture(id, want.frameValue(version))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if have.MIME != want.MIME || have.Type != want.Type || have.Desc != want.Desc || !bytes.Equal(have.Data, want.Data) {
+			t.Error("Version", version, "- Want:", want.MIME, want.Type, want.Desc, "Have:", have.MIME, have.Type, have.Desc)
+		}
+		if have.Ext() != ".png" {
+			t.Error("Extension - Want: .png Have:", have.Ext())
+		}
+	}
+
+	// UTF-16 description with a BOM and a 2-byte terminator.
+	value := []byte("\x01image/png\x00\x04\xff\xfeA\x00r\x00t\x00\x00\x00")
+	value = append(value, png...)
+	have, err := parsePicture("APIC", value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have.Desc != "Art" || have.Type != 0x04 || !bytes.Equal(have.Data, png) {
+		t.Error("UTF-16 - Want: Art 4", png, "Have:", have.Desc, have.Type, have.Data)
+	}
+
+	// Links instead of images aren't extracted.
+	if _, err := parsePicture("APIC", []byte("\x00-->\x00\x03\x00http://example.com/a.jpg")); err == nil {
+		t.Error("Link - Want: error Have: nil")
+	}
+}
+
+// Test that -pictures rules are parsed by name or number, that invalid rules are rejected, and that episodes without
+// their own artwork use the show's.
+func TestPictureRules(t *testing.T) {
+	var rules PictureRules
+	if err := rules.Set("episode=cover-front, show=other-icon"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rules.Set("show=20"); err != nil {
+		t.Fatal(err)
+	}
+	if want, have := "episode=cover-front,show=other-icon,show=publisher-logo", rules.String(); have != want {
+		t.Error("Want:", want, "Have:", have)
+	}
+
+	for _, bad := range []string{"episode", "feed=cover-front", "show=poster", "show=21"} {
+		var rules PictureRules
+		if err := rules.Set(bad); err == nil {
+			t.Error(bad, "- Want: error Have: nil")
+		}
+	}
+
+	// Episodes without their own artwork fall back to the show's.
+	episode := &Episode{showImage: "http://example.com/show.jpg"}
+	for _, rule := range rules {
+		if have := rule.link(episode); have != episode.showImage {
+			t.Error(rule.Source, "- Want:", episode.showImage, "Have:", have)
+		}
+	}
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// playedFraction is how much of an episode has to have been listened to for a player's position to count as played.
+const playedFraction = 0.95
+
+// SetPlayed marks the episode of the show (by feed URL or title) as played or unplayed. The episode is matched by either
+// its title (case-insensitive) or its GUID. If no episode is given, every episode of the show is marked. This returns
+// the number of episodes that were marked.
+func (s *State) SetPlayed(show string, episode string, config *Config, played bool) (int, error) {
+	if s == nil {
+		return 0, fmt.Errorf("no state available")
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	feedURL := s.findShow(show, config)
+	ss, ok := s.Shows[feedURL]
+	if feedURL == "" || !ok {
+		return 0, fmt.Errorf("show not found: %v", show)
+	}
+
+	when := time.Now()
+	marked := 0
+	for key, es := range ss.Episodes {
+		if episode != "" && !strings.EqualFold(es.Title, episode) && es.GUID != episode {
+			continue
+		}
+		if es.setPlayed(played, when) > 0 {
+			s.touch(feedURL, key)
+			marked++
+		}
+	}
+	if episode != "" && marked == 0 {
+		return 0, fmt.Errorf("episode not found: %v", episode)
+	}
+
+	return marked, nil
+}
+
+// IsPlayed reports whether the episode of the show with the provided feed URL has been played.
+func (s *State) IsPlayed(feedURL string, e *Episode) bool {
+	es, ok := s.Lookup(feedURL, e)
+	return ok && !es.Played.IsZero()
+}
+
+// markPlayed marks every episode that matches as played (at the provided time) or unplayed, and returns the number of
+// episodes that were marked.
+func (s *State) markPlayed(match func(feedURL string, es *EpisodeState) bool, played bool, when time.Time) int {
+	if s == nil {
+		return 0
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	marked := 0
+	for feedURL, ss := range s.Shows {
+		for key, es := range ss.Episodes {
+			if match(feedURL, es) && es.setPlayed(played, when) > 0 {
+				s.touch(feedURL, key)
+				marked++
+			}
+		}
+	}
+
+	return marked
+}
+
+// setPlayed marks the episode as played or unplayed, and returns 1 if that changed anything. An episode that was already
+// played keeps the time it was first played.
+func (es *EpisodeState) setPlayed(played bool, when time.Time) int {
+	switch {
+	case played && es.Played.IsZero():
+		es.Played = when
+	case !played && !es.Played.IsZero():
+		es.Played = time.Time{}
+	default:
+		return 0
+	}
+
+	return 1
+}
+
+// runPlayed marks the episode in args (or every episode of the show, if only the show is given) as played or unplayed.
+func runPlayed(state *State, config *Config, args []string, played bool) error {
+	if len(args) == 0 {
+		Log("No show specified")
+		return errUsage
+	}
+	show := args[0]
+	episode := strings.Join(args[1:], " ")
+
+	marked, err := state.SetPlayed(show, episode, config, played)
+	if err != nil {
+		return err
+	}
+
+	if err := state.Save(); err != nil {
+		return fmt.Errorf("error saving state: %v", err)
+	}
+
+	action := "unplayed"
+	if played {
+		action = "played"
+	}
+	Log("Marked", marked, "episodes as", action)
+
+	return nil
+}
+
+// runImportPlayed marks the episodes that another player has played, from either gPodder's episode actions (as synced
+// with gpodder.net or a compatible server) or an iTunes/Music library export.
+func runImportPlayed(state *State, args []string) error {
+	fs := flag.NewFlagSet("import-played", flag.ContinueOnError)
+	fromArg := fs.String("from", "", "Required. Format of the file: gpodder or itunes")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() != 1 {
+		Log("No file specified")
+		return errUsage
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var marked int
+	switch *fromArg {
+	case "gpodder":
+		marked, err = state.ImportGPodderActions(f)
+	case "itunes":
+		marked, err = state.ImportITunesLibrary(f)
+	default:
+		Log("Unknown format:", *fromArg)
+		return errUsage
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := state.Save(); err != nil {
+		return fmt.Errorf("error saving state: %v", err)
+	}
+	Log("Marked", marked, "episodes as played or unplayed")
+
+	return nil
+}
+
+// gpodderAction is an episode action from gPodder's sync API.
+type gpodderAction struct {
+	Podcast   string `json:"podcast"`
+	Episode   string `json:"episode"` // enclosure URL
+	GUID      string `json:"guid"`
+	Action    string `json:"action"`
+	Timestamp string `json:"timestamp"`
+	Position  int    `json:"position"` // seconds
+	Total     int    `json:"total"`    // seconds
+}
+
+// ImportGPodderActions reads gPodder episode actions, either the response of the API (an object with "actions") or a
+// plain list, and marks the episodes that were played to the end as played. Episodes that were marked as new again are
+// marked as unplayed. Episodes are matched by their enclosure URL or their GUID. This returns the number of episodes
+// that changed.
+func (s *State) ImportGPodderActions(r io.Reader) (int, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+
+	var actions []gpodderAction
+	if err := json.Unmarshal(data, &actions); err != nil {
+		var response struct {
+			Actions []gpodderAction `json:"actions"`
+		}
+		if err := json.Unmarshal(data, &response); err != nil {
+			return 0, fmt.Errorf("error parsing episode actions: %v", err)
+		}
+		actions = response.Actions
+	}
+
+	// Later actions win, so they're applied in order.
+	sort.SliceStable(actions, func(i, j int) bool {
+		return actions[i].Timestamp < actions[j].Timestamp
+	})
+
+	marked := 0
+	for _, action := range actions {
+		var played bool
+		switch action.Action {
+		case "play":
+			if action.Total <= 0 || float64(action.Position) < float64(action.Total)*playedFraction {
+				continue
+			}
+			played = true
+		case "new":
+			played = false
+		default:
+			continue
+		}
+
+		when, err := time.Parse("2006-01-02T15:04:05", action.Timestamp)
+		if err != nil {
+			when = time.Now()
+		}
+
+		// With -strip-trackers, we recorded the real URL instead of the one in the feed.
+		unwrapped := UnwrapTrackers(action.Episode)
+		marked += s.markPlayed(func(feedURL string, es *EpisodeState) bool {
+			if action.Episode != "" && (es.URL == action.Episode || es.URL == unwrapped) {
+				return true
+			}
+			return action.GUID != "" && es.GUID == action.GUID && (action.Podcast == "" || action.Podcast == feedURL)
+		}, played, when)
+	}
+
+	return marked, nil
+}
+
+// ImportITunesLibrary reads an iTunes (or Music) library export and marks the tracks that have been played as played.
+// Tracks are matched to episodes by their location on disk, or by their filename if only one episode has it. This
+// returns the number of episodes that changed.
+func (s *State) ImportITunesLibrary(r io.Reader) (int, error) {
+	decoder := xml.NewDecoder(r)
+	var library interface{}
+	for library == nil {
+		token, err := decoder.Token()
+		if err != nil {
+			return 0, fmt.Errorf("error parsing library: %v", err)
+		}
+		if start, ok := token.(xml.StartElement); ok && start.Name.Local == "dict" {
+			if library, err = decodePlist(decoder, start); err != nil {
+				return 0, fmt.Errorf("error parsing library: %v", err)
+			}
+		}
+	}
+
+	root, _ := library.(map[string]interface{})
+	tracks, ok := root["Tracks"].(map[string]interface{})
+	if !ok {
+		return 0, fmt.Errorf("no tracks in library")
+	}
+
+	// Filenames are only used when they're unique in the library.
+	byName := make(map[string]int)
+	for path := range s.ByPath() {
+		byName[filepath.Base(path)]++
+	}
+
+	marked := 0
+	for _, value := range tracks {
+		track, ok := value.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		location, _ := track["Location"].(string)
+		u, err := url.Parse(location)
+		if err != nil || u.Scheme != "file" || u.Path == "" {
+			continue
+		}
+		path := filepath.FromSlash(u.Path)
+
+		count, _ := track["Play Count"].(int64)
+		date, _ := track["Play Date UTC"].(time.Time)
+		flagged, _ := track["Played"].(bool)
+		if count == 0 && date.IsZero() && !flagged {
+			continue
+		}
+		if date.IsZero() {
+			date = time.Now()
+		}
+
+		name := filepath.Base(path)
+		marked += s.markPlayed(func(feedURL string, es *EpisodeState) bool {
+			if es.Path == "" {
+				return false
+			}
+			return es.Path == path || (byName[name] == 1 && filepath.Base(es.Path) == name)
+		}, true, date)
+	}
+
+	return marked, nil
+}
+
+// decodePlist decodes the property list element that was just started, returning a map for a dict, a slice for an
+// array, and a string, int64, float64, bool, or time.Time for the rest. Data is left as its base64 string.
+func decodePlist(decoder *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	switch start.Name.Local {
+	case "dict":
+		dict := make(map[string]interface{})
+		key := ""
+		for {
+			token, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			switch t := token.(type) {
+			case xml.StartElement:
+				if t.Name.Local == "key" {
+					var k string
+					if err := decoder.DecodeElement(&k, &t); err != nil {
+						return nil, err
+					}
+					key = k
+					continue
+				}
+				value, err := decodePlist(decoder, t)
+				if err != nil {
+					return nil, err
+				}
+				dict[key] = value
+			case xml.EndElement:
+				return dict, nil
+			}
+		}
+	case "array":
+		var array []interface{}
+		for {
+			token, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			switch t := token.(type) {
+			case xml.StartElement:
+				value, err := decodePlist(decoder, t)
+				if err != nil {
+					return nil, err
+				}
+				array = append(array, value)
+			case xml.EndElement:
+				return array, nil
+			}
+		}
+	case "true", "false":
+		if err := decoder.Skip(); err != nil {
+			return nil, err
+		}
+		return start.Name.Local == "true", nil
+	}
+
+	var text string
+	if err := decoder.DecodeElement(&text, &start); err != nil {
+		return nil, err
+	}
+	text = strings.TrimSpace(text)
+
+	switch start.Name.Local {
+	case "integer":
+		var n int64
+		_, err := fmt.Sscan(text, &n)
+		return n, err
+	case "real":
+		var f float64
+		_, err := fmt.Sscan(text, &f)
+		return f, err
+	case "date":
+		return time.Parse(time.RFC3339, text)
+	}
+
+	return text, nil
+}
+
+// deletePlayed moves the show's episodes that were played more than the provided number of days ago to the trash.
+// Played episodes aren't downloaded again (see filter), so they stay gone.
+func (s *Show) deletePlayed(days int) {
+	// The state records absolute paths.
+	showDir, err := filepath.Abs(s.Dir)
+	if err != nil {
+		Log("Error removing played episodes:", err)
+		return
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -days)
+	prefix := showDir + string(filepath.Separator)
+	var paths []string
+	for path, es := range StateDB.ByPath() {
+		if strings.HasPrefix(path, prefix) && !es.Played.IsZero() && es.Played.Before(cutoff) {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		if err := TrashFile(showDir, path); err != nil {
+			Log("Error removing", filepath.Base(path), "-", err)
+			continue
+		}
+		Log("Moved played episode", filepath.Base(path), "to trash")
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newPlayedState returns a state with a few downloaded episodes of one show, for testing played tracking.
+func newPlayedState(t *testing.T, dir string) *State {
+	state, err := LoadState(filepath.Join(dir, "state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"one", "two", "three"} {
+		e := Episode{Title: "Episode " + name, GUID: "guid-" + name}
+		e.Enclosure.URL = "https://example.com/" + name + ".mp3"
+		e.path = filepath.Join(dir, "Show", name+".mp3")
+		state.RecordDownload("https://example.com/feed", "Show", &e)
+	}
+
+	return state
+}
+
+// playedTitles returns the titles of the played episodes, joined in order.
+func playedTitles(state *State) string {
+	var titles []string
+	for _, title := range []string{"Episode one", "Episode two", "Episode three"} {
+		if state.IsPlayed("https://example.com/feed", &Episode{Title: title, GUID: "guid-" + strings.TrimPrefix(title, "Episode ")}) {
+			titles = append(titles, title)
+		}
+	}
+
+	return strings.Join(titles, ",")
+}
+
+// Test marking episodes as played and unplayed by show and episode.
+func TestSetPlayed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast-played")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	state := newPlayedState(t, dir)
+
+	if n, err := state.SetPlayed("show", "episode TWO", nil, true); err != nil || n != 1 {
+		t.Error("Failed to mark episode by title - Want:", 1, "Have:", n, err)
+	}
+	if n, err := state.SetPlayed("https://example.com/feed", "guid-three", nil, true); err != nil || n != 1 {
+		t.Error("Failed to mark episode by GUID - Want:", 1, "Have:", n, err)
+	}
+	if have := playedTitles(state); have != "Episode two,Episode three" {
+		t.Error("Incorrect played episodes - Want:", "Episode two,Episode three", "Have:", have)
+	}
+
+	// Marking the whole show only counts the episodes that changed.
+	if n, err := state.SetPlayed("Show", "", nil, true); err != nil || n != 1 {
+		t.Error("Failed to mark show - Want:", 1, "Have:", n, err)
+	}
+	if n, err := state.SetPlayed("Show", "", nil, false); err != nil || n != 3 {
+		t.Error("Failed to unmark show - Want:", 3, "Have:", n, err)
+	}
+	if have := playedTitles(state); have != "" {
+		t.Error("Episodes still played - Have:", have)
+	}
+
+	if _, err := state.SetPlayed("Other Show", "", nil, true); err == nil {
+		t.Error("Missing show was not an error")
+	}
+	if _, err := state.SetPlayed("Show", "Episode four", nil, true); err == nil {
+		t.Error("Missing episode was not an error")
+	}
+}
+
+// Test importing played episodes from gPodder episode actions.
+func TestImportGPodderActions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast-played")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	state := newPlayedState(t, dir)
+	state.SetPlayed("Show", "Episode three", nil, true)
+
+	actions := `{"actions": [
+		{"podcast": "https://example.com/feed", "episode": "https://example.com/one.mp3", "action": "play", "timestamp": "2021-03-01T10:00:00", "position": 1790, "total": 1800},
+		{"podcast": "https://example.com/feed", "episode": "https://other.example.com/two.mp3", "guid": "guid-two", "action": "play", "timestamp": "2021-03-01T11:00:00", "position": 600, "total": 1800},
+		{"podcast": "https://example.com/feed", "episode": "https://example.com/three.mp3", "action": "new", "timestamp": "2021-03-02T10:00:00"},
+		{"podcast": "https://example.com/feed", "episode": "https://example.com/three.mp3", "action": "download", "timestamp": "2021-03-02T11:00:00"}
+	], "timestamp": 1614682800}`
+
+	n, err := state.ImportGPodderActions(strings.NewReader(actions))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Error("Incorrect number of changes - Want:", 2, "Have:", n)
+	}
+	if have := playedTitles(state); have != "Episode one" {
+		t.Error("Incorrect played episodes - Want:", "Episode one", "Have:", have)
+	}
+
+	want := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+	for _, es := range state.ByPath() {
+		if es.Title == "Episode one" && !es.Played.Equal(want) {
+			t.Error("Incorrect played time - Want:", want, "Have:", es.Played)
+		}
+	}
+}
+
+// Test importing played episodes from an iTunes library export.
+func TestImportITunesLibrary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast-played")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	state := newPlayedState(t, dir)
+
+	library := `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Major Version</key><integer>1</integer>
+	<key>Tracks</key>
+	<dict>
+		<key>101</key>
+		<dict>
+			<key>Track ID</key><integer>101</integer>
+			<key>Name</key><string>Episode one</string>
+			<key>Play Count</key><integer>2</integer>
+			<key>Play Date UTC</key><date>2021-04-05T06:07:08Z</date>
+			<key>Podcast</key><true/>
+			<key>Location</key><string>file://localhost` + filepath.ToSlash(filepath.Join(dir, "Show", "one.mp3")) + `</string>
+		</dict>
+		<key>102</key>
+		<dict>
+			<key>Track ID</key><integer>102</integer>
+			<key>Name</key><string>Episode two</string>
+			<key>Location</key><string>file:///Volumes/Music/two.mp3</string>
+		</dict>
+		<key>103</key>
+		<dict>
+			<key>Track ID</key><integer>103</integer>
+			<key>Name</key><string>Episode three</string>
+			<key>Play Count</key><integer>1</integer>
+			<key>Location</key><string>file:///Volumes/Music/Podcasts/three.mp3</string>
+		</dict>
+	</dict>
+	<key>Playlists</key>
+	<array>
+		<dict><key>Name</key><string>Library</string></dict>
+	</array>
+</dict>
+</plist>`
+
+	n, err := state.ImportITunesLibrary(strings.NewReader(library))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Error("Incorrect number of changes - Want:", 2, "Have:", n)
+	}
+	if have := playedTitles(state); have != "Episode one,Episode three" {
+		t.Error("Incorrect played episodes - Want:", "Episode one,Episode three", "Have:", have)
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// recentPlaylistAge is how far back the global playlist of new episodes reaches.
+const recentPlaylistAge = 7 * 24 * time.Hour
+
+// playlistEntry is an individual file in a playlist.
+type playlistEntry struct {
+	path   string    // path relative to the playlist
+	title  string    // title to display
+	season int       // season number, or 0 if unknown
+	number int       // episode number, or 0 if unknown
+	date   time.Time // publish date, or the file's modification time if unknown
+}
+
+// ValidatePlaylistFormat checks that playlists can be written in the format.
+func ValidatePlaylistFormat(format string) error {
+	switch format {
+	case "", "m3u", "pls":
+		return nil
+	}
+
+	return fmt.Errorf("invalid playlist format: %v", format)
+}
+
+// WritePlaylist regenerates the playlist of all episodes in the show's directory. Episodes are ordered by season and
+// episode number if every episode has a number, or by publish date if not.
+func WritePlaylist(showDir string, format string) error {
+	if format == "" {
+		return nil
+	}
+
+	showDir, err := filepath.Abs(showDir)
+	if err != nil {
+		return err
+	}
+	byPath := StateDB.ByPath()
+
+	var entries []playlistEntry
+	walkFunc := func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() && path != showDir {
+				return filepath.SkipDir
+			}
+			return nil
+		} else if info.IsDir() || !isAudio(info.Name()) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(showDir, path)
+		if err != nil {
+			return err
+		}
+
+		entry := playlistEntry{
+			path:  rel,
+			title: strings.TrimSuffix(info.Name(), filepath.Ext(info.Name())),
+			date:  info.ModTime(),
+		}
+		if es, ok := byPath[path]; ok {
+			entry.title = es.Title
+			entry.season, _ = strconv.Atoi(es.Season)
+			entry.number, _ = strconv.Atoi(es.Number)
+			if !es.Published.IsZero() {
+				entry.date = es.Published
+			}
+		}
+		entries = append(entries, entry)
+
+		return nil
+	}
+
+	if err := filepath.Walk(showDir, walkFunc); err != nil {
+		return err
+	}
+
+	numbered := true
+	for _, entry := range entries {
+		if entry.number == 0 {
+			numbered = false
+			break
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if numbered && (a.season != b.season || a.number != b.number) {
+			if a.season != b.season {
+				return a.season < b.season
+			}
+			return a.number < b.number
+		}
+		return a.date.Before(b.date)
+	})
+
+	path := filepath.Join(showDir, "playlist."+format)
+	Debug("Writing", len(entries), "episodes to", path)
+	return ioutil.WriteFile(path, buildPlaylist(entries, format), 0644)
+}
+
+// WriteRecentPlaylist regenerates the global playlist in the main download directory of every episode downloaded in
+// the last week, oldest first.
+func WriteRecentPlaylist(mainDir string, format string) error {
+	if format == "" || StateDB == nil {
+		return nil
+	}
+
+	mainDir, err := filepath.Abs(mainDir)
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-recentPlaylistAge)
+
+	var entries []playlistEntry
+	for path, es := range StateDB.ByPath() {
+		if es.Downloaded.Before(cutoff) {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+
+		rel, err := filepath.Rel(mainDir, path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			// The episode is in a different library.
+			continue
+		}
+
+		entries = append(entries, playlistEntry{path: rel, title: es.Title, date: es.Downloaded})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].date.Before(entries[j].date)
+	})
+
+	path := filepath.Join(mainDir, "new-this-week."+format)
+	Debug("Writing", len(entries), "episodes to", path)
+	return ioutil.WriteFile(path, buildPlaylist(entries, format), 0644)
+}
+
+// buildPlaylist formats the entries as either an M3U or PLS playlist.
+func buildPlaylist(entries []playlistEntry, format string) []byte {
+	buf := new(bytes.Buffer)
+
+	switch format {
+	case "pls":
+		buf.WriteString("[playlist]\n")
+		for i, entry := range entries {
+			n := i + 1
+			fmt.Fprintf(buf, "File%d=%s\n", n, filepath.ToSlash(entry.path))
+			fmt.Fprintf(buf, "Title%d=%s\n", n, entry.title)
+			fmt.Fprintf(buf, "Length%d=-1\n", n)
+		}
+		fmt.Fprintf(buf, "NumberOfEntries=%d\n", len(entries))
+		buf.WriteString("Version=2\n")
+	default:
+		buf.WriteString("#EXTM3U\n")
+		for _, entry := range entries {
+			fmt.Fprintf(buf, "#EXTINF:-1,%s\n", entry.title)
+			fmt.Fprintf(buf, "%s\n", filepath.ToSlash(entry.path))
+		}
+	}
+
+	return buf.Bytes()
+}
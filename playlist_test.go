@@ -0,0 +1,126 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test that a show's playlist lists its audio files relative to the show's directory, ordered by episode number if
+// every episode has one and by publish date if not.
+func TestWritePlaylist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast-playlist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tmpState := StateDB
+	defer func() { StateDB = tmpState }()
+
+	write := func(name string) string {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	published := func(day int) time.Time {
+		return time.Date(2020, time.May, day, 0, 0, 0, 0, time.UTC)
+	}
+	one, two := write("Season 2/One.mp3"), write("Two.mp3")
+	write(".partial/Third.mp3")
+	write("cover.jpg")
+	StateDB = &State{Version: stateVersion, Shows: map[string]*ShowState{
+		"https://example.com/feed.xml": {Title: "Show", Episodes: map[string]*EpisodeState{
+			"1": {Title: "Episode 1", Season: "2", Number: "1", Published: published(3), Path: one},
+			"2": {Title: "Episode 2", Season: "2", Number: "2", Published: published(2), Path: two},
+		}},
+	}}
+
+	if err := WritePlaylist(dir, "m3u"); err != nil {
+		t.Fatal(err)
+	}
+	want := "#EXTM3U\n" +
+		"#EXTINF:-1,Episode 1\nSeason 2/One.mp3\n" +
+		"#EXTINF:-1,Episode 2\nTwo.mp3\n"
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, "playlist.m3u")); string(data) != want {
+		t.Error("Incorrect numbered playlist - Want:", want, "Have:", string(data))
+	}
+
+	// An untracked file has no number, so the playlist falls back to the publish dates and the file's mod time.
+	bonus := write("Bonus.mp3")
+	if err := os.Chtimes(bonus, published(1), published(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := WritePlaylist(dir, "pls"); err != nil {
+		t.Fatal(err)
+	}
+	want = "[playlist]\n" +
+		"File1=Bonus.mp3\nTitle1=Bonus\nLength1=-1\n" +
+		"File2=Two.mp3\nTitle2=Episode 2\nLength2=-1\n" +
+		"File3=Season 2/One.mp3\nTitle3=Episode 1\nLength3=-1\n" +
+		"NumberOfEntries=3\nVersion=2\n"
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, "playlist.pls")); string(data) != want {
+		t.Error("Incorrect dated playlist - Want:", want, "Have:", string(data))
+	}
+
+	if err := WritePlaylist(dir, ""); err != nil {
+		t.Error(err)
+	}
+}
+
+// Test that the playlist of new episodes lists the episodes in the main directory that were downloaded in the last week
+// and are still on disk, oldest first.
+func TestWriteRecentPlaylist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast-playlist")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tmpState, tmpFormat := StateDB, PlaylistFormat
+	defer func() { StateDB, PlaylistFormat = tmpState, tmpFormat }()
+
+	mainDir := filepath.Join(dir, "Podcasts")
+	write := func(name string) string {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	ago := func(days int) time.Time {
+		return time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+	}
+	StateDB = &State{Version: stateVersion, Shows: map[string]*ShowState{
+		"https://example.com/feed.xml": {Title: "Show", Episodes: map[string]*EpisodeState{
+			"new":     {Title: "New", Path: write("Podcasts/Show/New.mp3"), Downloaded: ago(1)},
+			"older":   {Title: "Older", Path: write("Podcasts/Show/Older.mp3"), Downloaded: ago(3)},
+			"old":     {Title: "Old", Path: write("Podcasts/Show/Old.mp3"), Downloaded: ago(10)},
+			"deleted": {Title: "Deleted", Path: filepath.Join(mainDir, "Show", "Deleted.mp3"), Downloaded: ago(1)},
+			"other":   {Title: "Other", Path: write("Elsewhere/Show/Other.mp3"), Downloaded: ago(1)},
+		}},
+	}}
+
+	PlaylistFormat = "m3u"
+	writeRecentPlaylist(mainDir)
+	want := "#EXTM3U\n" +
+		"#EXTINF:-1,Older\nShow/Older.mp3\n" +
+		"#EXTINF:-1,New\nShow/New.mp3\n"
+	if data, _ := ioutil.ReadFile(filepath.Join(mainDir, "new-this-week.m3u")); string(data) != want {
+		t.Error("Incorrect playlist - Want:", want, "Have:", string(data))
+	}
+
+	StateDB = nil
+	if err := WriteRecentPlaylist(dir, "m3u"); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "new-this-week.m3u")); !os.IsNotExist(err) {
+		t.Error("Playlist was written without a state DB")
+	}
+}
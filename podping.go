@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// PodpingConfig holds the settings for watching Podping in daemon mode. Podping is a stream of announcements, written
+// to the Hive blockchain, that a feed has been updated. Hosting companies announce their feeds as they publish, so
+// watching it finds new episodes of many shows within seconds, without having to fetch their feeds to find out.
+type PodpingConfig struct {
+	API      string   `json:"api"`      // URL of the Hive API node to read blocks from, or empty for the default
+	Accounts []string `json:"accounts"` // only trust announcements from these Hive accounts, or empty for any
+	Poll     Duration `json:"poll"`     // time between checks for new blocks, or 0 for the default
+}
+
+// Enabled returns whether or not Podping should be watched, which is whenever it's in the config.
+func (p *PodpingConfig) Enabled() bool {
+	return p != nil
+}
+
+// These are the defaults for watching Podping.
+const (
+	defaultPodpingAPI  = "https://api.hive.blog"
+	defaultPodpingPoll = 3 * time.Second // a new block is made every 3 seconds
+)
+
+// These limit how much is read from the API at once.
+const (
+	podpingBatch       = 50      // most blocks to ask for at once
+	podpingMaxBehind   = 1200    // most blocks (an hour's worth) to catch up on, after which older ones are skipped
+	podpingMaxResponse = 8 << 20 // largest response to read from the API
+)
+
+// podping watches the blocks of the Hive blockchain for Podping announcements of subscribed feeds, and sends those
+// feeds on to be synced.
+type podping struct {
+	config  PodpingConfig
+	pushes  chan<- string            // where to send the feeds that are announced
+	feeds   func() map[string]string // returns the subscribed feeds by their keys (see feedKey)
+	next    int                      // number of the next block to read
+	failing bool                     // whether or not the last check failed, so that failures are only logged once
+}
+
+// newPodping creates the watcher with the settings. Announced feeds that are found in feeds are sent on the channel.
+func newPodping(config PodpingConfig, pushes chan<- string, feeds func() map[string]string) *podping {
+	if config.API == "" {
+		config.API = defaultPodpingAPI
+	}
+	if config.Poll.Duration <= 0 {
+		config.Poll.Duration = defaultPodpingPoll
+	}
+
+	return &podping{config: config, pushes: pushes, feeds: feeds}
+}
+
+// Run checks for new blocks at the polling interval until done is closed.
+func (p *podping) Run(done <-chan struct{}) {
+	Log("Watching Podping for updates through", p.config.API)
+	ticker := time.NewTicker(p.config.Poll.Duration)
+	defer ticker.Stop()
+
+	for {
+		err := p.check()
+		if err != nil && !p.failing {
+			Log("Error reading Podping announcements:", err)
+		} else if err == nil && p.failing {
+			Log("Reading Podping announcements again")
+		}
+		p.failing = err != nil
+
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check reads the blocks made since the last check and sends on the subscribed feeds that they announce.
+func (p *podping) check() error {
+	var props struct {
+		Head int `json:"head_block_number"`
+	}
+	if err := p.call("condenser_api.get_dynamic_global_properties", []interface{}{}, &props); err != nil {
+		return err
+	}
+
+	// On the first check, we start from the newest block. Anything before that is picked up by the first sync.
+	if p.next == 0 || props.Head-p.next > podpingMaxBehind {
+		if p.next != 0 {
+			Debug("Skipping", props.Head-p.next, "Podping blocks")
+		}
+		p.next = props.Head
+	}
+
+	for p.next <= props.Head {
+		count := props.Head - p.next + 1
+		if count > podpingBatch {
+			count = podpingBatch
+		}
+
+		var result struct {
+			Blocks []hiveBlock `json:"blocks"`
+		}
+		params := map[string]int{"starting_block_num": p.next, "count": count}
+		if err := p.call("block_api.get_block_range", params, &result); err != nil {
+			return err
+		}
+		if len(result.Blocks) == 0 {
+			// The node doesn't have the blocks yet.
+			return nil
+		}
+
+		feeds := p.feeds()
+		for _, block := range result.Blocks {
+			for _, link := range block.podpings(p.config.Accounts) {
+				if feed, ok := feeds[feedKey(link)]; ok {
+					Debug("Podping announced an update of", link)
+					select {
+					case p.pushes <- feed:
+					default:
+						Debug("Too many updates waiting, dropping the one for", feed)
+					}
+				}
+			}
+		}
+		p.next += len(result.Blocks)
+	}
+
+	return nil
+}
+
+// call calls the method of the Hive API with the parameters and decodes the result into result.
+func (p *podping) call(method string, params interface{}, result interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params, "id": 1})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(stopCtx, http.MethodPost, p.config.API, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%v responded with %v", p.config.API, resp.Status)
+	}
+	limited, err := limitBody(resp, podpingMaxResponse)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(limited)
+	if err != nil {
+		return err
+	}
+
+	var response struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil {
+		return fmt.Errorf("invalid response from %v: %v", p.config.API, err)
+	}
+	if response.Error != nil {
+		return fmt.Errorf("%v: %v", method, response.Error.Message)
+	}
+
+	return json.Unmarshal(response.Result, result)
+}
+
+// hiveBlock is a block of the Hive blockchain, as returned by block_api. Podping announcements are custom_json
+// operations, with the announcement itself as JSON in a string.
+type hiveBlock struct {
+	Transactions []struct {
+		Operations []struct {
+			Type  string `json:"type"`
+			Value struct {
+				ID    string   `json:"id"`
+				JSON  string   `json:"json"`
+				Auths []string `json:"required_posting_auths"`
+			} `json:"value"`
+		} `json:"operations"`
+	} `json:"transactions"`
+}
+
+// podpings returns the feed URLs announced in the block by the accounts, or by any account if accounts is empty.
+func (b hiveBlock) podpings(accounts []string) []string {
+	var links []string
+	for _, tx := range b.Transactions {
+		for _, op := range tx.Operations {
+			if op.Type != "custom_json_operation" || !isPodpingID(op.Value.ID) {
+				continue
+			}
+			if len(accounts) > 0 && !anyString(op.Value.Auths, accounts) {
+				continue
+			}
+			links = append(links, parsePodping(op.Value.JSON)...)
+		}
+	}
+
+	return links
+}
+
+// isPodpingID returns whether or not the ID of a custom_json operation is for Podping: "podping" for the first
+// version, and "pp_<medium>_<reason>" (e.g. "pp_podcast_update") since.
+func isPodpingID(id string) bool {
+	return id == "podping" || strings.HasPrefix(id, "pp_")
+}
+
+// parsePodping returns the feed URLs in the announcement. These are in "iris" since version 1.0, and in "urls" (or
+// "url", for a single feed) before that.
+func parsePodping(data string) []string {
+	var announcement struct {
+		IRIs []string `json:"iris"`
+		URLs []string `json:"urls"`
+		URL  string   `json:"url"`
+	}
+	if err := json.Unmarshal([]byte(data), &announcement); err != nil {
+		return nil
+	}
+
+	links := append(announcement.IRIs, announcement.URLs...)
+	if announcement.URL != "" {
+		links = append(links, announcement.URL)
+	}
+
+	return links
+}
+
+// anyString returns whether or not any of the strings are in the list.
+func anyString(values []string, list []string) bool {
+	for _, s := range values {
+		if containsString(list, s) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// Test that only the updates of followed feeds announced by trusted accounts are passed on, starting from the newest
+// block.
+func TestPodping(t *testing.T) {
+	// Each block has one announcement: an update of a subscribed feed (written differently), one from an account that
+	// isn't trusted, and one of a feed we don't follow.
+	op := func(account string, announcement string) string {
+		value, _ := json.Marshal(map[string]interface{}{
+			"id":                     "pp_podcast_update",
+			"json":                   announcement,
+			"required_posting_auths": []string{account},
+		})
+		return `{"transactions": [{"operations": [{"type": "custom_json_operation", "value": ` + string(value) + `}]}]}`
+	}
+	blocks := map[int]string{
+		100: op("podping.aaa", `{"version": "1.0", "medium": "podcast", "reason": "update", "iris": ["http://Example.com/feed.xml/"]}`),
+		101: op("someone.else", `{"version": "1.0", "iris": ["https://example.com/other.xml"]}`),
+		102: op("podping.aaa", `{"version": "0.3", "urls": ["https://example.com/unknown.xml"]}`),
+	}
+
+	head := 100
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+
+		switch req.Method {
+		case "condenser_api.get_dynamic_global_properties":
+			w.Write([]byte(`{"jsonrpc": "2.0", "result": {"head_block_number": ` + strconv.Itoa(head) + `}, "id": 1}`))
+		case "block_api.get_block_range":
+			var params struct {
+				Start int `json:"starting_block_num"`
+				Count int `json:"count"`
+			}
+			json.Unmarshal(req.Params, &params)
+			result := `{"jsonrpc": "2.0", "result": {"blocks": [`
+			for i := params.Start; i < params.Start+params.Count && i <= head; i++ {
+				if i > params.Start {
+					result += ","
+				}
+				result += blocks[i]
+			}
+			w.Write([]byte(result + `]}, "id": 1}`))
+		default:
+			w.Write([]byte(`{"jsonrpc": "2.0", "error": {"message": "unknown method"}, "id": 1}`))
+		}
+	}))
+	defer server.Close()
+
+	feeds := func() map[string]string {
+		return map[string]string{
+			feedKey("https://example.com/feed.xml"):  "https://example.com/feed.xml",
+			feedKey("https://example.com/other.xml"): "https://example.com/other.xml",
+		}
+	}
+	pushes := make(chan string, 10)
+	p := newPodping(PodpingConfig{API: server.URL, Accounts: []string{"podping.aaa"}}, pushes, feeds)
+
+	// The first check starts at the newest block.
+	if err := p.check(); err != nil {
+		t.Fatal(err)
+	}
+	head = 102
+	if err := p.check(); err != nil {
+		t.Fatal(err)
+	}
+	if p.next != 103 {
+		t.Error("Incorrect next block - Want: 103 Have:", p.next)
+	}
+
+	close(pushes)
+	var have []string
+	for feed := range pushes {
+		have = append(have, feed)
+	}
+	if len(have) != 1 || have[0] != "https://example.com/feed.xml" {
+		t.Error("Incorrect feeds - Want: [https://example.com/feed.xml] Have:", have)
+	}
+}
+
+// Test that feed URLs differing only in scheme, host case, or a trailing slash are treated as the same feed.
+func TestFeedKey(t *testing.T) {
+	for _, test := range []struct {
+		a, b  string
+		equal bool
+	}{
+		{"https://example.com/feed.xml", "http://EXAMPLE.com/feed.xml/", true},
+		{"https://example.com/feed.xml", "https://example.com/Feed.xml", false},
+		{"https://example.com/feed.xml?id=1", "https://example.com/feed.xml?id=2", false},
+		{"example.com", "https://Example.com/", true},
+	} {
+		if equal := feedKey(test.a) == feedKey(test.b); equal != test.equal {
+			t.Error(test.a, "and", test.b, "- Want equal:", test.equal, "Have:", feedKey(test.a), feedKey(test.b))
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// LengthPolicy decides how the size of a downloaded episode is validated. The server's Content-Length and the length
+// reported by the RSS feed do not always agree, such as when a publisher re-encodes a file after publishing the feed.
+type LengthPolicy struct {
+	Mode      string  // one of the policy modes below
+	Tolerance float64 // allowed difference in percent, for PolicyTolerance
+}
+
+// These are the available length policy modes.
+const (
+	PolicyTrustServer = "trust-server" // the download must match the server's Content-Length
+	PolicyTrustFeed   = "trust-feed"   // the download must match the length in the RSS feed
+	PolicyTolerance   = "tolerance"    // the download must be within a percentage of the expected size
+)
+
+// ParseLengthPolicy parses the policy from its string form: "trust-server", "trust-feed", or a tolerance percentage
+// such as "5%".
+func ParseLengthPolicy(s string) (LengthPolicy, error) {
+	switch s {
+	case "", PolicyTrustServer:
+		return LengthPolicy{Mode: PolicyTrustServer}, nil
+	case PolicyTrustFeed:
+		return LengthPolicy{Mode: PolicyTrustFeed}, nil
+	}
+
+	if strings.HasSuffix(s, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 64)
+		if err == nil && percent >= 0 {
+			return LengthPolicy{Mode: PolicyTolerance, Tolerance: percent}, nil
+		}
+	}
+
+	return LengthPolicy{}, fmt.Errorf("invalid length policy: %v", s)
+}
+
+// String returns the policy in the same form that ParseLengthPolicy reads.
+func (p LengthPolicy) String() string {
+	switch p.Mode {
+	case "":
+		return PolicyTrustServer
+	case PolicyTolerance:
+		return strconv.FormatFloat(p.Tolerance, 'f', -1, 64) + "%"
+	}
+
+	return p.Mode
+}
+
+// Check validates the number of bytes received against the size reported by the server and the size reported by the
+// RSS feed. A size of 0 or less means that the size was not reported.
+func (p LengthPolicy) Check(received int, server int, feed int) error {
+	if server > 0 && feed > 0 && server != feed {
+		Debug("Server reported", server, "bytes, RSS feed reported", feed, "bytes")
+	}
+
+	// Figure out which size we're checking against. If the preferred source didn't report a size, we'll use the other.
+	expected := server
+	if (p.Mode == PolicyTrustFeed && feed > 0) || expected <= 0 {
+		expected = feed
+	}
+
+	if expected <= 0 {
+		// Nothing to check against.
+		Debug("No expected size available, skipping length check")
+		return nil
+	}
+
+	if received == expected {
+		return nil
+	}
+	Debug("Expected", expected, "bytes, Received", received, "bytes")
+
+	if p.Mode == PolicyTolerance {
+		diff := math.Abs(float64(received-expected)) * 100 / float64(expected)
+		if diff <= p.Tolerance {
+			Log("Size is off by", strconv.FormatFloat(diff, 'f', 2, 64)+"%, within tolerance")
+			return nil
+		}
+	}
+
+	if received < expected {
+		Log("Failed to download entire episode")
+	} else {
+		Log("Downloaded more bytes than expected")
+	}
+	return errDownload
+}
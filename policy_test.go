@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+)
+
+// Test that each length policy accepts and rejects the right downloads.
+func TestLengthPolicy(t *testing.T) {
+	tests := []struct {
+		policy   string
+		received int
+		server   int
+		feed     int
+		ok       bool
+	}{
+		{"trust-server", 100, 100, 90, true},
+		{"trust-server", 90, 100, 90, false},
+		{"trust-server", 90, -1, 90, true},
+		{"trust-feed", 90, 100, 90, true},
+		{"trust-feed", 100, 100, 90, false},
+		{"trust-feed", 100, 100, 0, true},
+		{"5%", 96, 100, 0, true},
+		{"5%", 94, 100, 0, false},
+		{"0%", 100, 100, 0, true},
+		{"trust-server", 100, -1, 0, true},
+	}
+
+	for _, test := range tests {
+		policy, err := ParseLengthPolicy(test.policy)
+		if err != nil {
+			t.Error(test.policy, "-", err)
+			continue
+		}
+		if policy.String() != test.policy {
+			t.Error("Policy string does not match - Want:", test.policy, "Have:", policy.String())
+		}
+
+		err = policy.Check(test.received, test.server, test.feed)
+		if test.ok && err != nil {
+			t.Error(test.policy, "- Rejected", test.received, "of", test.server, "(server) /", test.feed, "(feed)")
+		} else if !test.ok && err == nil {
+			t.Error(test.policy, "- Accepted", test.received, "of", test.server, "(server) /", test.feed, "(feed)")
+		}
+	}
+
+	for _, bad := range []string{"trust", "-5%", "five%"} {
+		if _, err := ParseLengthPolicy(bad); err == nil {
+			t.Error("Parsed invalid policy:", bad)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// These are the available library presets.
+const (
+	PresetJellyfin = "jellyfin" // Jellyfin music/audiobook libraries
+	PresetPlex     = "plex"     // Plex music libraries
+)
+
+// ValidatePreset checks that the preset exists.
+func ValidatePreset(preset string) error {
+	switch preset {
+	case "", PresetJellyfin, PresetPlex:
+		return nil
+	}
+
+	return fmt.Errorf("invalid preset: %v", preset)
+}
+
+// presetArtwork returns the names (without an extension) of the files that the preset's media server looks for when
+// finding a show's artwork. The extension comes from the kind of image.
+func presetArtwork(preset string) []string {
+	switch preset {
+	case PresetJellyfin:
+		return []string{"folder"}
+	case PresetPlex:
+		return []string{"cover"}
+	}
+
+	return nil
+}
+
+// findArtwork returns the path of the show's artwork with the name in any image format, or "" if there isn't one.
+func findArtwork(dir string, name string) string {
+	for _, t := range imageTypes {
+		path := filepath.Join(dir, name+t.ext)
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+
+	return ""
+}
+
+// seasonDir returns the directory that the episode should be saved in according to the preset. Media servers expect
+// each season to be in its own folder ("Season 01") under the show's directory.
+func seasonDir(preset string, showDir string, episode *Episode) string {
+	if preset == "" || episode.Season == "" {
+		return showDir
+	}
+
+	season, err := strconv.Atoi(episode.Season)
+	if err != nil {
+		return showDir
+	}
+
+	return filepath.Join(showDir, fmt.Sprintf("Season %02d", season))
+}
+
+// nfoAlbum is the layout of the album.nfo file that Jellyfin reads for album (show) information.
+type nfoAlbum struct {
+	XMLName xml.Name `xml:"album"`
+	Title   string   `xml:"title"`
+	Artist  string   `xml:"artist"`
+	Genre   string   `xml:"genre"`
+	Review  string   `xml:"review,omitempty"`
+}
+
+// applyPreset adds the files that the preset's media server expects in the show's directory: the show's artwork and,
+// for Jellyfin, an NFO file with the show's information. Existing files are left alone.
+func (s *Show) applyPreset(preset string) error {
+	if preset == "" {
+		return nil
+	}
+
+	for _, name := range presetArtwork(preset) {
+		if s.Image == "" || findArtwork(s.Dir, name) != "" {
+			continue
+		}
+
+		data := fetchImage(s.Image, s.Auth)
+		if data == nil {
+			continue
+		}
+		ext := NewPicture(data).Ext()
+		if ext == ".img" {
+			Log("Not saving show artwork - unknown image type", http.DetectContentType(data))
+			continue
+		}
+
+		path := filepath.Join(s.Dir, name+ext)
+		s.debug("Saving show artwork to", path)
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			return err
+		}
+	}
+
+	if preset == PresetJellyfin {
+		path := filepath.Join(s.Dir, "album.nfo")
+		if _, err := os.Stat(path); err == nil {
+			return nil
+		}
+
+		nfo := nfoAlbum{Title: s.Title, Artist: s.Author, Genre: "Podcast", Review: s.Desc}
+		data, err := xml.MarshalIndent(nfo, "", "\t")
+		if err != nil {
+			return err
+		}
+
+		s.debug("Writing show information to", path)
+		data = append([]byte(xml.Header), data...)
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Test that the show's artwork for a media server is saved with the extension of its image type, and only once.
+func TestPresetArtwork(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast-preset")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("XDG_CACHE_HOME", filepath.Join(dir, "cache"))
+	defer os.Unsetenv("XDG_CACHE_HOME")
+
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR")
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write(png)
+	}))
+	defer server.Close()
+
+	show := &Show{Title: "Show", Dir: dir, Image: server.URL + "/cover"}
+	for i := 0; i < 2; i++ {
+		if err := show.applyPreset(PresetPlex); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if data, err := ioutil.ReadFile(filepath.Join(dir, "cover.png")); err != nil || string(data) != string(png) {
+		t.Error("Artwork not saved as cover.png:", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "cover.jpg")); !os.IsNotExist(err) {
+		t.Error("PNG artwork saved as cover.jpg")
+	}
+	if requests != 1 {
+		t.Error("Incorrect number of requests - Want: 1 Have:", requests)
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maxRedirects is the most redirects followed for one request, the same as the HTTP client's default.
+const maxRedirects = 10
+
+// hostOf returns the lower-cased host name in the link, or "" if the link can't be parsed.
+func hostOf(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+
+	return strings.ToLower(u.Hostname())
+}
+
+// external reports whether the link would be skipped with -no-external because it's on a host other than the show's
+// feed host or the episode's enclosure host.
+func (e *Episode) external(link string) bool {
+	if !NoExternal || e == nil {
+		return false
+	}
+
+	host := hostOf(link)
+	if host != "" && (host == hostOf(e.showURL) || host == hostOf(e.Enclosure.URL)) {
+		return false
+	}
+
+	e.debug("Not contacting third-party host", host, "with -no-external")
+	return true
+}
+
+// checkRedirect decides whether the shared HTTP client follows a redirect. With -no-external, a request can only be
+// redirected within the host it was sent to.
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+
+	if NoExternal {
+		from := strings.ToLower(via[0].URL.Hostname())
+		if to := strings.ToLower(req.URL.Hostname()); to != from {
+			return fmt.Errorf("not following redirect from %v to third-party host %v with -no-external", from, to)
+		}
+	}
+
+	return nil
+}
+
+// secretParams are parts of the names of query parameters that carry login information, like the "?auth=" of a private
+// feed, which are left out of the links written into the tags.
+var secretParams = []string{"auth", "token", "key", "secret", "pass", "pwd", "sig", "session", "credential", "code"}
+
+// publicURL returns the link without its login information: the user name and password, and the query parameters that
+// look like tokens. Links that can't be parsed are returned unchanged.
+func publicURL(link string) string {
+	u, err := url.Parse(link)
+	if err != nil || u.Host == "" {
+		return link
+	}
+
+	u.User = nil
+	if u.RawQuery != "" {
+		query := u.Query()
+		for name := range query {
+			lower := strings.ToLower(name)
+			for _, secret := range secretParams {
+				if strings.Contains(lower, secret) {
+					query.Del(name)
+					break
+				}
+			}
+		}
+		u.RawQuery = query.Encode()
+	}
+
+	return u.String()
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Profile is a named set of settings. Each profile has its own config file (and with it, its own download root and
+// subscriptions) and its own data directory for the state of its library. This lets a single install serve separate
+// libraries, such as one for each member of a household.
+type Profile struct {
+	Name string // name of the profile, or "" for the default profile
+}
+
+// NewProfile creates a new Profile object after checking that the name can be used in a path.
+func NewProfile(name string) (Profile, error) {
+	if name == "" {
+		return Profile{}, nil
+	}
+
+	if strings.ContainsAny(name, `/\`) || name == "." || name == ".." || strings.HasPrefix(name, ".") {
+		return Profile{}, fmt.Errorf("invalid profile name: %v", name)
+	}
+
+	return Profile{Name: name}, nil
+}
+
+// ConfigDir returns the directory holding the profile's config file.
+func (p Profile) ConfigDir() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	return p.subdir(filepath.Join(dir, "getcast"))
+}
+
+// ConfigPath returns the location of the profile's config file.
+func (p Profile) ConfigPath() string {
+	dir := p.ConfigDir()
+	if dir == "" {
+		return ""
+	}
+
+	return filepath.Join(dir, "config.json")
+}
+
+// DataDir returns the directory holding the profile's data, such as the state of its library. This follows the XDG
+// base directory spec, defaulting to ~/.local/share/getcast.
+func (p Profile) DataDir() string {
+	dir := os.Getenv("XDG_DATA_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".local", "share")
+	}
+
+	return p.subdir(filepath.Join(dir, "getcast"))
+}
+
+// CacheDir returns the directory holding the profile's cached files, such as saved copies of fetched feeds.
+func (p Profile) CacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+
+	return p.subdir(filepath.Join(dir, "getcast"))
+}
+
+// String returns the name of the profile for printing.
+func (p Profile) String() string {
+	if p.Name == "" {
+		return "default"
+	}
+
+	return p.Name
+}
+
+// subdir returns the profile's directory under the main directory. The default profile uses the main directory itself,
+// while named profiles are kept under main/profiles/name.
+func (p Profile) subdir(main string) string {
+	if p.Name == "" {
+		return main
+	}
+
+	return filepath.Join(main, "profiles", p.Name)
+}
+
+// ListProfiles returns the names of all profiles that have a config directory.
+func ListProfiles() ([]string, error) {
+	dir := filepath.Dir(Profile{Name: "x"}.ConfigDir())
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var names []string
+	for _, info := range infos {
+		if info.IsDir() && !strings.HasPrefix(info.Name(), ".") {
+			names = append(names, info.Name())
+		}
+	}
+
+	return names, nil
+}
+
+// runProfiles prints the names of all profiles.
+func runProfiles() error {
+	names, err := ListProfiles()
+	if err != nil {
+		return fmt.Errorf("error listing profiles: %v", err)
+	}
+
+	Log("default")
+	for _, name := range names {
+		Log(name)
+	}
+
+	return nil
+}
@@ -2,67 +2,190 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"strings"
+	"time"
 )
 
 var (
 	errDownload = fmt.Errorf("error downloading correct data")
 )
 
-// Progress is used to keep track during the download process and to display a progress bar during the operation.
+// These control how often the progress is printed and how quickly the throughput reacts to changes in speed.
+const (
+	downloadInterval = 200 * time.Millisecond // time between status updates for downloads
+	scanInterval     = time.Second            // time between status updates for scans
+	rateSmoothing    = 0.3                    // weight of the newest sample in the throughput's moving average
+)
+
+// Progress is used to keep track of a long operation, such as a download or a scan of the local files, and to display
+// its status on a single line of the terminal. Downloads count bytes (fed through Write), and scans count files (fed
+// through Add). When the output isn't a terminal (such as a log or a service's journal), only the final status is
+// printed.
 type Progress struct {
-	total       int    // total number of bytes to be downloaded
-	totalString string // size of file to be downloaded, ready for printing
-	have        int    // number of bytes we currently have
-	writeCount  int    // running count of write operations, for determining if we should print or not
+	out      io.Writer              // where the status is printed
+	now      func() time.Time       // clock, which can be swapped out for tests
+	format   func(*Progress) string // builds the status line
+	interval time.Duration          // minimum time between printing the status
+	total    int                    // total number of bytes or files
+	have     int                    // number of bytes or files so far
+	width    int                    // length of the last status printed, for clearing the line
+	printed  time.Time              // when the status was last printed
+	live     bool                   // whether or not the status is redrawn as it changes
+
+	rate      float64   // smoothed throughput, in bytes or files per second
+	sampled   time.Time // when the throughput was last sampled
+	sampleHas int       // number of bytes or files at the last sample
+}
+
+// newProgress creates a new Progress object that prints to stdout.
+func newProgress(total int, interval time.Duration, format func(*Progress) string) *Progress {
+	pr := &Progress{out: os.Stdout, now: time.Now, format: format, interval: interval, total: total}
+	pr.live = isTerminal(os.Stdout)
+	pr.printed = pr.now()
+	pr.sampled = pr.printed
+
+	return pr
+}
+
+// spinner is the animation shown for downloads of unknown size.
+const spinner = `|/-\`
+
+// NewDownloadProgress creates a new Progress object for a download. The size reported by the server is used as the
+// total, falling back to the size given in the RSS feed. If neither is known (such as for chunked responses), the
+// progress only shows a spinner and the number of bytes received so far.
+func NewDownloadProgress(serverSize int, feedSize int) *Progress {
+	total := serverSize
+	estimated := false
+	if total <= 0 && feedSize > 0 {
+		total = feedSize
+		estimated = true
+	}
+
+	frame := 0
+	return newProgress(total, downloadInterval, func(pr *Progress) string {
+		var status string
+		switch {
+		case pr.total <= 0:
+			frame = (frame + 1) % len(spinner)
+			status = fmt.Sprintf("%c Received %v so far", spinner[frame], Reduce(pr.have))
+		case estimated:
+			// The feed's size is often a little off, so we won't claim to be past 100%.
+			percent := (pr.have * 100) / pr.total
+			if percent > 99 {
+				percent = 99
+			}
+			status = fmt.Sprintf("Received %v of about %v total (%v%%)", Reduce(pr.have), Reduce(pr.total), percent)
+		default:
+			status = fmt.Sprintf("Received %v of %v total (%v%%)", Reduce(pr.have), Reduce(pr.total), (pr.have*100)/pr.total)
+		}
+
+		if pr.rate > 0 {
+			status += fmt.Sprintf(" at %v/s", Reduce(int(pr.rate)))
+		}
+		return status
+	})
 }
 
-// Write prints the number of bytes written to stdout.
+// NewScanProgress creates a new Progress object for scanning files. The files are counted as they're scanned, so there's
+// no total.
+func NewScanProgress() *Progress {
+	return newProgress(0, scanInterval, func(pr *Progress) string {
+		return fmt.Sprintf("Scanned %v local files", pr.have)
+	})
+}
+
+// Write counts the bytes written and prints the current status.
 func (pr *Progress) Write(p []byte) (int, error) {
 	n := len(p)
+	pr.count(n)
+
+	return n, nil
+}
+
+// Add counts another scanned file and prints the current status.
+func (pr *Progress) Add() {
+	if pr == nil {
+		return
+	}
+
+	pr.count(1)
+}
+
+// count adds to the progress and prints the current status, at most once per interval.
+func (pr *Progress) count(n int) {
 	pr.have += n
 
-	// We don't need to do expensive print operations that often.
-	pr.writeCount++
-	if pr.writeCount%50 > 0 {
-		return n, nil
+	now := pr.now()
+	if now.Sub(pr.printed) < pr.interval {
+		return
 	}
+	pr.printed = now
+	pr.sample(now)
 
-	// Clear the line and print the current status.
-	fmt.Printf("\r%s", strings.Repeat(" ", 35))
-	fmt.Printf("%v", pr.String())
+	if pr.live {
+		pr.print("")
+	}
+}
 
-	return n, nil
+// sample updates the smoothed throughput with the progress since the last sample.
+func (pr *Progress) sample(now time.Time) {
+	elapsed := now.Sub(pr.sampled).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+
+	current := float64(pr.have-pr.sampleHas) / elapsed
+	if pr.rate == 0 {
+		pr.rate = current
+	} else {
+		pr.rate = rateSmoothing*current + (1-rateSmoothing)*pr.rate
+	}
+	pr.sampled = now
+	pr.sampleHas = pr.have
+}
+
+// Rate returns the smoothed throughput, in bytes or files per second.
+func (pr *Progress) Rate() float64 {
+	if pr == nil {
+		return 0
+	}
+
+	return pr.rate
 }
 
-// String shows the current transfer status.
+// print clears the line and prints the current status, followed by the ending. Without a terminal, there's no line to
+// clear.
+func (pr *Progress) print(end string) {
+	status := pr.String()
+	if !pr.live {
+		fmt.Fprint(pr.out, status, end)
+		return
+	}
+
+	fmt.Fprintf(pr.out, "\r%s\r%s%s", strings.Repeat(" ", pr.width), status, end)
+	pr.width = len(status)
+}
+
+// String shows the current status.
 func (pr *Progress) String() string {
 	if pr == nil {
 		return "<nil>"
 	}
 
-	return fmt.Sprintf("\rReceived %v of %v total (%v%%)", Reduce(pr.have), pr.totalString, ((pr.have * 100) / pr.total))
+	return pr.format(pr)
 }
 
-// Finish cleans up the terminal line and prints the overall success of the download operation.
-func (pr *Progress) Finish() error {
-	// Print the final status.
-	fmt.Printf("\r%s", strings.Repeat(" ", 35))
-	fmt.Printf("%v", pr.String())
+// Finish prints the final status of the operation on its own line and writes it to the log.
+func (pr *Progress) Finish() {
+	if pr == nil {
+		return
+	}
 
 	// Because we've been mucking around with carriage returns, we need to manually move down a row.
-	fmt.Println()
-
-	if pr.have != pr.total {
-		Debug("Expected", pr.total, "bytes, Received", pr.have, "bytes")
-		if pr.have < pr.total {
-			Log("Failed to download entire episode")
-		} else {
-			Log("Downloaded more bytes than expected")
-		}
-		return errDownload
+	pr.print("\n")
+	if LogFile != nil {
+		fmt.Fprintln(LogFile, pr.String())
 	}
-
-	Log("Episode successfully downloaded")
-	return nil
 }
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock for tests that only moves when told to.
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+// Test that download progress is printed once per interval with a smoothed rate, and that scan progress counts the
+// files scanned.
+func TestProgress(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	out := new(bytes.Buffer)
+
+	pr := NewDownloadProgress(4000, 0)
+	pr.out = out
+	pr.live = true
+	pr.now = clock.now
+	pr.printed = clock.now()
+	pr.sampled = clock.now()
+
+	// Nothing is printed until the interval has passed.
+	pr.Write(make([]byte, 1000))
+	if out.Len() != 0 {
+		t.Error("Before interval - Want: no output Have:", out.String())
+	}
+
+	// 2000 bytes over 1 second.
+	clock.advance(time.Second)
+	pr.Write(make([]byte, 1000))
+	if rate := pr.Rate(); rate != 2000 {
+		t.Error("First sample - Want: 2000 Have:", rate)
+	}
+	if !strings.Contains(out.String(), "(50%)") {
+		t.Error("Status - Want: 50% Have:", out.String())
+	}
+
+	// A sudden burst only moves the rate part of the way there.
+	clock.advance(time.Second)
+	pr.Write(make([]byte, 2000))
+	if rate := pr.Rate(); rate != 0.3*2000+0.7*2000 {
+		t.Error("Second sample - Want: 2000 Have:", rate)
+	}
+	clock.advance(time.Second)
+	pr.Write(make([]byte, 10000))
+	if rate := pr.Rate(); rate != 0.3*10000+0.7*2000 {
+		t.Error("Third sample - Want:", 0.3*10000+0.7*2000, "Have:", rate)
+	}
+
+	out.Reset()
+	pr.Finish()
+	if !strings.HasSuffix(out.String(), "\n") {
+		t.Error("Finish - Want: trailing newline Have:", out.String())
+	}
+
+	scan := NewScanProgress()
+	scan.out = out
+	scan.Add()
+	scan.Add()
+	if have := scan.String(); have != "Scanned 2 local files" {
+		t.Error("Scan - Want: Scanned 2 local files Have:", have)
+	}
+}
+
+// Test that the status isn't redrawn when the output isn't a terminal, and that the final status is still printed.
+func TestProgressNotTerminal(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	out := new(bytes.Buffer)
+
+	pr := NewScanProgress()
+	pr.out = out
+	pr.live = false
+	pr.now = clock.now
+	pr.printed = clock.now()
+	pr.sampled = clock.now()
+
+	for i := 0; i < 5; i++ {
+		clock.advance(time.Second)
+		pr.Add()
+	}
+	if out.Len() != 0 {
+		t.Error("Status was redrawn - Want: no output Have:", out.String())
+	}
+
+	pr.Finish()
+	if have := out.String(); have != "Scanned 5 local files\n" {
+		t.Error("Finish - Want: Scanned 5 local files Have:", have)
+	}
+}
+
+// Test that downloads without a Content-Length fall back to the feed's size, or else show how much has been received.
+func TestProgressUnknownLength(t *testing.T) {
+	pr := NewDownloadProgress(-1, 1000)
+	pr.Write(make([]byte, 1200))
+	if have := pr.String(); !strings.Contains(have, "about") || !strings.Contains(have, "(99%)") {
+		t.Error("Feed size - Want: about ... (99%) Have:", have)
+	}
+
+	pr = NewDownloadProgress(-1, 0)
+	pr.Write(make([]byte, 1200))
+	if have := pr.String(); !strings.Contains(have, "so far") || strings.Contains(have, "%") {
+		t.Error("No size - Want: ... so far Have:", have)
+	}
+}
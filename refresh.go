@@ -0,0 +1,333 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// refreshMargin is how long before a token expires that it's refreshed, so that it doesn't expire mid-download.
+const refreshMargin = time.Minute
+
+// TokenRefresh describes how to get a new token for a feed whose tokens expire. Either a command or an OAuth token
+// endpoint can be used. The command is run by the shell and should print the new token on stdout. It's given the feed's
+// URL in GETCAST_FEED_URL.
+type TokenRefresh struct {
+	Command      string `json:"command"`       // command that prints a new token
+	TokenURL     string `json:"token_url"`     // OAuth token endpoint for the refresh_token grant
+	ClientID     string `json:"client_id"`     // OAuth client ID
+	ClientSecret string `json:"client_secret"` // OAuth client secret or reference to it (see ResolveSecret)
+	RefreshToken string `json:"refresh_token"` // OAuth refresh token or reference to it (see ResolveSecret)
+}
+
+// validate checks that the refresh settings are usable.
+func (r *TokenRefresh) validate() error {
+	if r == nil {
+		return nil
+	}
+
+	switch {
+	case r.Command != "" && r.TokenURL != "":
+		return fmt.Errorf("token refresh can have a command or a token URL, not both")
+	case r.Command == "" && r.TokenURL == "":
+		return fmt.Errorf("token refresh needs a command or a token URL")
+	case r.TokenURL != "" && r.RefreshToken == "":
+		return fmt.Errorf("token refresh with a token URL needs a refresh token")
+	}
+
+	return nil
+}
+
+// savedToken is the last token received for a feed, which is kept in the profile's data directory so that it can be
+// used until it expires. OAuth servers that rotate refresh tokens send a new one with each access token, which is kept
+// here as well since the old one stops working.
+type savedToken struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token,omitempty"`
+	Expiry       time.Time `json:"expiry,omitempty"`
+}
+
+// refreshLocks holds a lock for each feed host, which is held while a token for the host is refreshed. Feeds on the
+// same host often share a rotating refresh token, which can only be used once.
+var refreshLocks = struct {
+	sync.Mutex
+	hosts map[string]*sync.Mutex
+}{hosts: make(map[string]*sync.Mutex)}
+
+// refreshLock returns the lock for refreshing tokens for the host.
+func refreshLock(host string) *sync.Mutex {
+	refreshLocks.Lock()
+	defer refreshLocks.Unlock()
+
+	lock, ok := refreshLocks.hosts[host]
+	if !ok {
+		lock = &sync.Mutex{}
+		refreshLocks.hosts[host] = lock
+	}
+
+	return lock
+}
+
+// tokenPath returns the location of the saved token for the feed.
+func tokenPath(feed string) string {
+	sum := sha1.Sum([]byte(feed))
+	return filepath.Join(ActiveProfile.DataDir(), "tokens", hex.EncodeToString(sum[:])+".json")
+}
+
+// refreshTokenPath returns the location of the latest refresh token in the chain of rotations that started with the
+// configured one. Feeds that are set up with the same refresh token share the file, so that once the token is rotated
+// for one of them, the others use the new one too.
+func refreshTokenPath(configured string) string {
+	sum := sha1.Sum([]byte(configured))
+	return filepath.Join(ActiveProfile.DataDir(), "tokens", "refresh-"+hex.EncodeToString(sum[:])+".json")
+}
+
+// loadToken reads the saved token for the feed, if there is one.
+func loadToken(feed string) (savedToken, bool) {
+	return readToken(tokenPath(feed))
+}
+
+// saveToken writes the token for the feed.
+func saveToken(feed string, token savedToken) error {
+	return writeToken(tokenPath(feed), token)
+}
+
+// readToken reads the saved token at the path, if there is one.
+func readToken(path string) (savedToken, bool) {
+	var token savedToken
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return token, false
+	}
+
+	if err := json.Unmarshal(data, &token); err != nil {
+		Debug("Ignoring invalid saved token:", err)
+		return token, false
+	}
+
+	return token, true
+}
+
+// writeToken writes the token to the path. The file is only readable by the user.
+func writeToken(path string, token savedToken) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(token, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// tokenRefresher gets new tokens for a feed's credentials.
+type tokenRefresher struct {
+	mutex      sync.Mutex
+	config     TokenRefresh
+	feed       string    // URL of the feed
+	configured string    // refresh token from the config, for OAuth
+	refresh    string    // current refresh token, for OAuth
+	expiry     time.Time // when the current token expires, or zero if unknown
+}
+
+// newTokenRefresher sets up the refresher for the feed, picking up the token saved from the last refresh if it's still
+// good.
+func newTokenRefresher(config *TokenRefresh, feed string, creds *Credentials) (*tokenRefresher, error) {
+	r := &tokenRefresher{config: *config, feed: feed}
+
+	var err error
+	if r.configured, err = ResolveSecret(config.RefreshToken); err != nil {
+		return nil, fmt.Errorf("error reading refresh token: %v", err)
+	}
+	r.refresh = r.configured
+	if r.config.ClientSecret, err = ResolveSecret(config.ClientSecret); err != nil {
+		return nil, fmt.Errorf("error reading client secret: %v", err)
+	}
+
+	if token, ok := loadToken(feed); ok {
+		if token.RefreshToken != "" {
+			r.refresh = token.RefreshToken
+		}
+		if token.Expiry.IsZero() || time.Now().Before(token.Expiry) {
+			creds.Token = token.AccessToken
+			r.expiry = token.Expiry
+		}
+	}
+	r.loadRefresh()
+
+	return r, nil
+}
+
+// loadRefresh picks up the latest refresh token rotated from the configured one, which might have been saved for
+// another feed or by another run of getcast.
+func (r *tokenRefresher) loadRefresh() {
+	if r.configured == "" {
+		return
+	}
+
+	if token, ok := readToken(refreshTokenPath(r.configured)); ok && token.RefreshToken != "" {
+		r.refresh = token.RefreshToken
+	}
+}
+
+// expiring reports whether the current token is about to expire.
+func (r *tokenRefresher) expiring() bool {
+	return !r.expiry.IsZero() && time.Now().Add(refreshMargin).After(r.expiry)
+}
+
+// expired reports whether the credentials' token is missing or about to expire.
+func (c *Credentials) expired() bool {
+	if c == nil || c.refresher == nil {
+		return false
+	}
+
+	c.refresher.mutex.Lock()
+	defer c.refresher.mutex.Unlock()
+
+	return c.Token == "" || c.refresher.expiring()
+}
+
+// Refresh gets a new token for the credentials to replace the stale one, which expired or was turned away, and saves it
+// for later runs. Only one token is refreshed for a host at a time. If the credentials already got a new token while
+// waiting for that, it's used instead of refreshing again.
+func (c *Credentials) Refresh(stale string) error {
+	if c == nil || c.refresher == nil {
+		return fmt.Errorf("no way to refresh credentials")
+	}
+
+	lock := refreshLock(c.host)
+	lock.Lock()
+	defer lock.Unlock()
+
+	r := c.refresher
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if c.Token != "" && c.Token != stale && !r.expiring() {
+		return nil
+	}
+
+	Log("Refreshing token for", c.host)
+	r.loadRefresh()
+
+	var token savedToken
+	var err error
+	if r.config.Command != "" {
+		token, err = r.runCommand()
+	} else {
+		token, err = r.requestToken()
+	}
+	if err != nil {
+		return fmt.Errorf("error refreshing token: %v", err)
+	}
+
+	c.Token = token.AccessToken
+	r.expiry = token.Expiry
+	if token.RefreshToken != "" {
+		// The old refresh token stops working once it's rotated, so the new one has to be kept for the other feeds
+		// that were using it.
+		r.refresh = token.RefreshToken
+		if r.configured != "" {
+			if err := writeToken(refreshTokenPath(r.configured), savedToken{RefreshToken: r.refresh}); err != nil {
+				Log("Error saving rotated refresh token:", err)
+			}
+		}
+	} else {
+		token.RefreshToken = r.refresh
+	}
+
+	if err := saveToken(r.feed, token); err != nil {
+		Log("Error saving refreshed token:", err)
+	}
+
+	return nil
+}
+
+// runCommand gets a new token from the refresh command.
+func (r *tokenRefresher) runCommand() (savedToken, error) {
+	cmd := exec.CommandContext(stopCtx, "sh", "-c", r.config.Command)
+	cmd.Env = append(os.Environ(), envPrefix+"FEED_URL="+r.feed)
+	cmd.Stderr = os.Stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return savedToken{}, err
+	}
+
+	token := strings.TrimSpace(string(out))
+	if token == "" {
+		return savedToken{}, fmt.Errorf("refresh command didn't print a token")
+	}
+
+	return savedToken{AccessToken: token}, nil
+}
+
+// requestToken gets a new access token from the OAuth token endpoint with the refresh token.
+func (r *tokenRefresher) requestToken() (savedToken, error) {
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", r.refresh)
+	if r.config.ClientID != "" {
+		form.Set("client_id", r.config.ClientID)
+	}
+	if r.config.ClientSecret != "" {
+		form.Set("client_secret", r.config.ClientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(stopCtx, http.MethodPost, r.config.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return savedToken{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return savedToken{}, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return savedToken{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return savedToken{}, fmt.Errorf("token endpoint responded with %v", resp.Status)
+	}
+
+	var body struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int    `json:"expires_in"`
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return savedToken{}, fmt.Errorf("invalid response from token endpoint: %v", err)
+	}
+	if body.AccessToken == "" {
+		return savedToken{}, fmt.Errorf("token endpoint didn't return an access token")
+	}
+
+	token := savedToken{AccessToken: body.AccessToken, RefreshToken: body.RefreshToken}
+	if body.ExpiresIn > 0 {
+		token.Expiry = time.Now().Add(time.Duration(body.ExpiresIn) * time.Second)
+	}
+
+	return token, nil
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// repairResult counts what went into a rebuilt state.
+type repairResult struct {
+	shows    int // shows with at least one episode
+	episodes int // episodes found in the library
+	archived int // episodes found in season archives
+	untagged int // audio files without a feed URL in their tags, which couldn't be matched to a show
+}
+
+// needsState returns whether or not the command can't run without the state, because the state is what it works on or
+// because it would remove the wrong files without it (device-sync picks what stays on the device from the state).
+func needsState(cmd string) bool {
+	switch cmd {
+	case "adopt", "archive", "bookmark", "device-sync", "import-played", "migrate", "pause", "resume", "played",
+		"unplayed", "stats":
+		return true
+	}
+
+	return false
+}
+
+// warnNoState logs a warning that stands out from the rest of the output about running without the state.
+func warnNoState(err error) {
+	Log("")
+	Log("=== WARNING: Running Without State ===")
+	Log(err)
+	Log("Downloads are recognized by the tags of the files in each show's directory, and nothing is recorded")
+	Log("until the state is back. Pauses, played episodes, and archived seasons are ignored, so deleted episodes")
+	Log("may download again.")
+	Log("Run \"getcast repair-state\" to rebuild the state from the library.")
+	Log("")
+}
+
+// runRepairState rebuilds the state from the library, for when it's been lost or can't be read. Episodes in the
+// download directory are recorded under the feed URL and GUID in their tags, and episodes in season archives under the
+// archives' manifests. If the old state can still be read, everything in it is kept and brought up to date with the
+// library. The old state file is kept next to the new one either way.
+func runRepairState(config *Config, dirArg string, args []string) error {
+	fs := flag.NewFlagSet("repair-state", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "Optional. Count what would be recorded without replacing the state")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	path := StatePath()
+	if path == "" {
+		return fmt.Errorf("missing state path")
+	}
+	mainDir, err := downloadDir(config, dirArg)
+	if err != nil {
+		return err
+	}
+	mainDir, err = filepath.Abs(mainDir)
+	if err != nil {
+		return err
+	}
+
+	lock, err := tryLockFile(path+".lock", stateLockWait)
+	if errors.Is(err, errLocked) {
+		return fmt.Errorf("state is %v (stop the daemon first)", err)
+	} else if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	// Whatever the old state has left is a better start than nothing.
+	old, _, err := readState(path)
+	if err != nil {
+		Log("Can't read the old state, starting from scratch:", err)
+		old = nil
+	} else {
+		old.migrate()
+	}
+
+	Log("Rebuilding state from", mainDir)
+	state, result, err := rebuildState(path, mainDir, config, old)
+	if err != nil {
+		return err
+	}
+	Log("Found", result.episodes, "episodes and", result.archived, "archived episodes")
+	Log("The state has", result.shows, "shows")
+	if result.untagged > 0 {
+		Log("Skipped", result.untagged, "files without a feed URL in their tags")
+	}
+	if *dryRun {
+		return nil
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		backup := path + "." + time.Now().UTC().Format("20060102T150405Z")
+		if err := os.Rename(path, backup); err != nil {
+			return fmt.Errorf("error keeping old state: %v", err)
+		}
+		Log("Kept old state at", backup)
+	}
+
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if err := state.write(); err != nil {
+		return fmt.Errorf("error saving state: %v", err)
+	}
+	Log("Saved rebuilt state to", path)
+
+	return nil
+}
+
+// rebuildState builds a new state for the path from the audio files and season archives in the main directory. Feed
+// URLs in the tags are matched to the subscriptions in the config, whose URLs might have login information that's kept
+// out of the tags. The old state, if not nil, is the starting point.
+func rebuildState(path string, mainDir string, config *Config, old *State) (*State, repairResult, error) {
+	state := &State{path: path, Version: stateVersion, Shows: make(map[string]*ShowState)}
+	var result repairResult
+
+	feeds := make(map[string]string)
+	if config != nil {
+		for _, sub := range config.Subscriptions {
+			feeds[publicURL(sub.URL)] = sub.URL
+		}
+	}
+	feedURL := func(tagged string) string {
+		if feed, ok := feeds[tagged]; ok {
+			return feed
+		}
+		return tagged
+	}
+
+	// Everything in the old state is kept, such as played episodes that have since been deleted, so that they aren't
+	// downloaded again.
+	if old != nil {
+		state.Paused, state.Shows = old.Paused, old.Shows
+	}
+
+	// record adds the episode to the show. If the old state already has it, the old record is kept and brought up to
+	// date with where the episode is now.
+	seen := make(map[*EpisodeState]bool)
+	record := func(feed string, showTitle string, es EpisodeState) *EpisodeState {
+		key := es.GUID
+		if key == "" {
+			key = es.Title
+		}
+
+		show := state.show(feed)
+		if show.Title == "" {
+			show.Title = showTitle
+		}
+		current, ok := show.Episodes[key]
+		if !ok {
+			current = &es
+			show.Episodes[key] = current
+		} else if !seen[current] {
+			current.Title, current.GUID = es.Title, es.GUID
+			current.Path, current.Size = es.Path, es.Size
+			if es.URL != "" {
+				current.URL = es.URL
+			}
+			if current.Downloaded.IsZero() {
+				current.Downloaded = es.Downloaded
+			}
+		}
+		seen[current] = true
+
+		return current
+	}
+
+	var archives []string
+	err := filepath.Walk(mainDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == mainDir {
+				return err
+			}
+			Debug("Skipping", path+":", err)
+			return nil
+		}
+		if info.IsDir() {
+			// This skips the trash, among other things.
+			if path != mainDir && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if archiveFormat(path) != "" {
+			archives = append(archives, path)
+			return nil
+		}
+		if !isAudio(path) {
+			return nil
+		}
+
+		meta, err := readFileMeta(path)
+		if err != nil {
+			Debug("Skipping", path+":", err)
+			return nil
+		}
+		feed := meta.GetUserText("FEED_URL")
+		if feed == "" {
+			result.untagged++
+			return nil
+		}
+
+		title := strings.TrimSpace(getTag(meta, "TIT2"))
+		if title == "" {
+			title = strings.TrimSuffix(info.Name(), filepath.Ext(info.Name()))
+		}
+		es := EpisodeState{
+			Title:      title,
+			GUID:       meta.GetUserText("GUID"),
+			Path:       path,
+			Size:       int(info.Size()),
+			Downloaded: info.ModTime(),
+		}
+		if values := meta.GetValues("WOAF"); len(values) > 0 {
+			es.URL = strings.TrimRight(string(values[0]), "\x00")
+		}
+		record(feedURL(feed), showDirName(mainDir, path), es)
+		result.episodes++
+
+		return nil
+	})
+	if err != nil {
+		return nil, result, err
+	}
+
+	for _, archive := range archives {
+		manifest, err := readArchiveManifest(archive)
+		if err != nil {
+			Log("Skipping archive", filepath.Base(archive)+":", err)
+			continue
+		} else if manifest.FeedURL == "" {
+			continue
+		}
+
+		for _, entry := range manifest.Episodes {
+			es := record(feedURL(manifest.FeedURL), manifest.Show, EpisodeState{
+				Title:     entry.Title,
+				GUID:      entry.GUID,
+				Number:    entry.Number,
+				Published: entry.Published,
+				Duration:  entry.Duration,
+				Path:      filepath.Join(mainDir, filepath.FromSlash(entry.Path)),
+				Size:      int(entry.Size),
+				Archive:   archive,
+			})
+			es.Archive = archive
+			result.archived++
+		}
+	}
+
+	for _, show := range state.Shows {
+		if len(show.Episodes) > 0 {
+			result.shows++
+		}
+	}
+
+	return state, result, nil
+}
+
+// showDirName returns the name of the show's directory that the file at the path is in, which is the first directory
+// under the main directory.
+func showDirName(mainDir string, path string) string {
+	rel, err := filepath.Rel(mainDir, path)
+	if err != nil {
+		return ""
+	}
+	if parts := strings.Split(filepath.ToSlash(rel), "/"); len(parts) > 1 {
+		return parts[0]
+	}
+
+	return ""
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// downloadPhase is how far along the download of an episode got, which decides whether a retry can pick up where the
+// last attempt left off.
+type downloadPhase int
+
+const (
+	phaseStart downloadPhase = iota // nothing has been written yet, while we find out what kind of file it is
+	phaseTag                        // the episode's tag is being buffered, so nothing has been written to disk yet
+	phaseAudio                      // the tag has been written, and the rest of the file is being written as it comes
+)
+
+// String returns the name of the phase.
+func (p downloadPhase) String() string {
+	switch p {
+	case phaseTag:
+		return "tag"
+	case phaseAudio:
+		return "audio"
+	}
+
+	return "start"
+}
+
+// resumeOffset returns the byte offset in the server's file to resume the download at, or 0 if the download has to
+// start over. Downloads can only be resumed after the tag stage: before that, the tag bytes were only in memory, and
+// the tag on disk is the one we built, so the server's bytes can't be lined up with the file otherwise.
+func (e *Episode) resumeOffset(filename string) int {
+	if e.phase != phaseAudio || e.received <= 0 || e.partial != filename {
+		return 0
+	}
+
+	if _, err := os.Stat(filename); err != nil {
+		return 0
+	}
+
+	return e.received
+}
+
+// resetPhase forgets the progress of the last attempt so that the download starts over.
+func (e *Episode) resetPhase() {
+	e.phase = phaseStart
+	e.received = 0
+	e.partial = ""
+	e.head = nil
+}
+
+// request requests the episode's enclosure, starting at the offset if it isn't 0.
+func (e *Episode) request(offset int) (*http.Response, error) {
+	if offset <= 0 {
+		return httpGet(e.Enclosure.URL, e.showAuth)
+	}
+
+	header := http.Header{}
+	header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	return get(e.Enclosure.URL, e.showAuth, true, header)
+}
+
+// resumed reports whether the response picks up the file at the offset.
+func resumed(resp *http.Response, offset int) bool {
+	if resp.StatusCode != http.StatusPartialContent {
+		return false
+	}
+
+	// Content-Range looks like "bytes 1000-4999/5000".
+	value := strings.TrimPrefix(resp.Header.Get("Content-Range"), "bytes ")
+	i := strings.IndexByte(value, '-')
+	if i < 0 {
+		return false
+	}
+	start, err := strconv.Atoi(strings.TrimSpace(value[:i]))
+
+	return err == nil && start == offset
+}
+
+// removePartial removes the file left behind by an unfinished download, if there is one.
+func (e *Episode) removePartial() {
+	if e == nil || e.partial == "" {
+		return
+	}
+
+	e.debug("Removing partial download", e.partial)
+	os.Remove(e.partial)
+	os.Remove(e.partial + originalSuffix)
+	e.resetPhase()
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/snhilde/getcast/internal/feedtest"
+)
+
+// Test that a download cut off after the tag stage picks up where it left off instead of starting over.
+func TestResumeDownload(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		if len(ranges) == 1 {
+			// Promise the whole file but only send half of it.
+			w.Header().Set("Content-Length", "100000")
+			w.Write(content[:50000])
+			return
+		}
+		http.ServeContent(w, r, "notes.pdf", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "getcast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	episode := Episode{Title: "Bonus", Enclosure: Enclosure{URL: server.URL + "/notes.pdf", Type: "application/pdf"}}
+	if err := episode.Download(dir); err != errDownload {
+		t.Fatal("First attempt - Want:", errDownload, "Have:", err)
+	}
+	if episode.phase != phaseAudio || episode.received != 50000 {
+		t.Error("Progress - Want: audio 50000 Have:", episode.phase, episode.received)
+	}
+
+	if err := episode.Download(dir); err != nil {
+		t.Fatal("Second attempt - Want: nil Have:", err)
+	}
+	if len(ranges) != 2 || ranges[1] != "bytes=50000-" {
+		t.Error("Range - Want: bytes=50000- Have:", strings.Join(ranges, ", "))
+	}
+
+	data, err := ioutil.ReadFile(episode.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Error("Content - Want:", len(content), "bytes Have:", len(data), "bytes")
+	}
+}
+
+// Test that a resumed MP3 download lines the server's bytes up after the tag we wrote in place of the publisher's, so
+// that the audio comes through whole.
+func TestResumeMP3(t *testing.T) {
+	tag := feedtest.Tag{Version: 3, Frames: []feedtest.Frame{feedtest.TextFrame("TIT2", "Published Title")}}
+	audio := append([]byte{0xFF, 0xFB, 0x90, 0x64}, bytes.Repeat([]byte("0123456789"), 10000)...)
+	content := append(tag.Bytes(), audio...)
+	cut := len(content) / 2
+
+	var ranges []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		if len(ranges) == 1 {
+			// Promise the whole file but only send half of it.
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.Write(content[:cut])
+			return
+		}
+		http.ServeContent(w, r, "episode.mp3", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "getcast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	episode := Episode{Title: "Episode", Enclosure: Enclosure{URL: server.URL + "/episode.mp3", Type: "audio/mpeg"}}
+	episode.settings = &ShowSettings{Artwork: ArtworkNone}
+	if err := episode.Download(dir); err != errDownload {
+		t.Fatal("First attempt - Want:", errDownload, "Have:", err)
+	}
+	if episode.phase != phaseAudio || episode.received != cut {
+		t.Error("Progress - Want: audio", cut, "Have:", episode.phase, episode.received)
+	}
+
+	if err := episode.Download(dir); err != nil {
+		t.Fatal("Second attempt - Want: nil Have:", err)
+	}
+	if want := "bytes=" + strconv.Itoa(cut) + "-"; len(ranges) != 2 || ranges[1] != want {
+		t.Error("Range - Want:", want, "Have:", strings.Join(ranges, ", "))
+	}
+
+	data, err := ioutil.ReadFile(episode.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The tag's size is in the last 4 bytes of its header, 7 bits to a byte.
+	tagLen := 10 + (int(data[6])<<21 | int(data[7])<<14 | int(data[8])<<7 | int(data[9]))
+	if tagLen > len(data) || !bytes.Equal(data[tagLen:], audio) {
+		t.Error("Audio changed by resuming - Want:", len(audio), "bytes after the tag Have:", len(data)-tagLen)
+	}
+	meta, err := readFileMeta(episode.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have := getTag(meta, "TIT2"); have != "Episode" {
+		t.Error("Incorrect title - Want: Episode Have:", have)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+)
+
+// Rights holds a show's copyright, content advisory, and funding information from the feed.
+type Rights struct {
+	Copyright string    // copyright notice or license
+	Explicit  string    // itunes:explicit value of the show, which episodes can override
+	Funding   []Funding // links to support the show
+}
+
+// Funding is a podcast:funding element from the Podcasting 2.0 namespace, linking to where the show can be supported.
+type Funding struct {
+	URL  string `xml:"url,attr"`
+	Text string `xml:",chardata"`
+}
+
+// fundingLink returns the first link for supporting the show, or "" if there isn't one.
+func (r Rights) fundingLink() string {
+	for _, funding := range r.Funding {
+		if link := strings.TrimSpace(funding.URL); link != "" {
+			return link
+		}
+	}
+
+	return ""
+}
+
+// parseExplicit interprets an itunes:explicit value. This returns whether the value marks explicit content and whether
+// the value says anything at all. Older feeds use "yes" and "clean" instead of "true" and "false".
+func parseExplicit(value string) (explicit bool, ok bool) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "true", "yes", "explicit":
+		return true, true
+	case "false", "no", "clean":
+		return false, true
+	}
+
+	return false, false
+}
+
+// IsExplicit reports whether the episode is marked as explicit, either itself or by its show.
+func (e *Episode) IsExplicit() bool {
+	if e == nil {
+		return false
+	}
+
+	if explicit, ok := parseExplicit(e.Explicit); ok {
+		return explicit
+	}
+	explicit, _ := parseExplicit(e.showRights.Explicit)
+
+	return explicit
+}
+
+// advisory returns the value of the episode's iTunes content advisory (TXXX:ITUNESADVISORY): "1" for explicit, "2" for
+// clean, or "" if the feed doesn't say.
+func (e *Episode) advisory() string {
+	explicit, ok := parseExplicit(e.Explicit)
+	if !ok {
+		explicit, ok = parseExplicit(e.showRights.Explicit)
+	}
+
+	switch {
+	case !ok:
+		return ""
+	case explicit:
+		return "1"
+	}
+
+	return "2"
+}
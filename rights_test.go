@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+// Test that the explicit flag is read from each episode, falling back to its show, and that explicit episodes can be
+// blocked.
+func TestExplicit(t *testing.T) {
+	feed := `<rss xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd" xmlns:podcast="https://podcastindex.org/namespace/1.0">
+<channel>
+	<title>Show</title>
+	<copyright>© 2021 Someone</copyright>
+	<itunes:explicit>yes</itunes:explicit>
+	<podcast:funding url="https://example.com/donate">Support the show</podcast:funding>
+	<item><title>Inherited</title></item>
+	<item><title>Clean</title><itunes:explicit>false</itunes:explicit></item>
+	<item><title>Explicit</title><itunes:explicit>true</itunes:explicit></item>
+</channel>
+</rss>`
+
+	var s Show
+	if err := xml.Unmarshal([]byte(feed), &s); err != nil {
+		t.Fatal(err)
+	}
+	rights := Rights{Copyright: s.Copyright, Explicit: s.Explicit, Funding: s.Funding}
+	if rights.Copyright != "© 2021 Someone" {
+		t.Error("Copyright - Want: © 2021 Someone Have:", rights.Copyright)
+	}
+	if link := rights.fundingLink(); link != "https://example.com/donate" {
+		t.Error("Funding - Want: https://example.com/donate Have:", link)
+	}
+
+	want := map[string]string{"Inherited": "1", "Clean": "2", "Explicit": "1"}
+	for i := range s.Episodes {
+		s.Episodes[i].SetShowRights(rights)
+		if have := s.Episodes[i].advisory(); have != want[s.Episodes[i].Title] {
+			t.Error(s.Episodes[i].Title, "- Want:", want[s.Episodes[i].Title], "Have:", have)
+		}
+	}
+
+	rules := ContentRules{BlockExplicit: true}
+	for _, episode := range s.Episodes {
+		if blocked := rules.allowEpisode(&episode) != ""; blocked != (episode.Title != "Clean") {
+			t.Error(episode.Title, "- Want blocked:", !blocked, "Have:", blocked)
+		}
+	}
+
+	// Without a flag anywhere, there's no advisory.
+	e := Episode{}
+	if e.IsExplicit() || e.advisory() != "" {
+		t.Error("Episode without a flag is marked")
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"math/rand"
+	"sort"
+	"time"
+)
+
+// These control how the daemon spreads out its checks of the feeds.
+const (
+	checksPerEpisode = 24             // times to check a feed in the usual time between its episodes
+	maxCheckInterval = 24 * time.Hour // longest to go between checks of a feed, unless the interval is even longer
+	scheduleJitter   = 0.2            // most that a check is moved earlier or later, as a fraction of its interval
+	cadenceEpisodes  = 10             // number of the newest episodes to judge how often a show comes out by
+	prefetchAhead    = 4              // most feeds to fetch at once, ahead of the show being synced
+)
+
+// schedule keeps track of when each feed is next due to be checked in daemon mode. Instead of checking every feed at
+// once on every interval, each feed is checked on its own interval, which depends on how often the show comes out, and
+// the checks are spread out across the interval with some randomness so that they don't bunch up again over time.
+type schedule struct {
+	next map[string]time.Time // by subscription URL
+	rand *rand.Rand
+}
+
+// newSchedule creates an empty schedule, in which every feed is due right away.
+func newSchedule() *schedule {
+	return &schedule{
+		next: make(map[string]time.Time),
+		rand: rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// due returns the feeds that are due to be checked at the time, in the same order as feeds. Feeds that haven't been
+// checked yet are always due. Feeds that are no longer listed are forgotten.
+func (s *schedule) due(feeds []string, now time.Time) []string {
+	listed := make(map[string]bool, len(feeds))
+	var due []string
+	for _, feed := range feeds {
+		listed[feed] = true
+		if next, ok := s.next[feed]; !ok || !now.Before(next) {
+			due = append(due, feed)
+		}
+	}
+	for feed := range s.next {
+		if !listed[feed] {
+			delete(s.next, feed)
+		}
+	}
+
+	return due
+}
+
+// wait returns the time from now until the next of the feeds is due, or 0 if one already is.
+func (s *schedule) wait(feeds []string, now time.Time) time.Duration {
+	var wait time.Duration = -1
+	for _, feed := range feeds {
+		next, ok := s.next[feed]
+		if !ok || !now.Before(next) {
+			return 0
+		}
+		if d := next.Sub(now); wait < 0 || d < wait {
+			wait = d
+		}
+	}
+	if wait < 0 {
+		return 0
+	}
+
+	return wait
+}
+
+// checked schedules the next check of the feed, which was just checked at the time. The interval is the configured
+// time between checks, and cadence is how often the show comes out (see publishingCadence), or 0 if that isn't known.
+// The first time a feed is checked, its next check is placed anywhere in its interval, which spreads out feeds that
+// were all checked together when the daemon started.
+func (s *schedule) checked(feed string, now time.Time, interval time.Duration, cadence time.Duration) {
+	interval = checkInterval(interval, cadence)
+
+	var wait time.Duration
+	if _, ok := s.next[feed]; !ok {
+		wait = time.Duration(s.rand.Int63n(int64(interval)) + 1)
+	} else {
+		wait = time.Duration(float64(interval) * (1 + scheduleJitter*(2*s.rand.Float64()-1)))
+	}
+	s.next[feed] = now.Add(wait)
+}
+
+// checkInterval returns how often to check a feed for a show that comes out every cadence: often enough to find a new
+// episode soon after it's out, but never more often than the configured interval, and never less often than
+// maxCheckInterval (or the configured interval, if that's longer). Shows that come out daily are checked more often than
+// weekly shows, which are checked more often than shows that have stopped.
+func checkInterval(interval time.Duration, cadence time.Duration) time.Duration {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	if cadence <= 0 {
+		return interval
+	}
+
+	longest := maxCheckInterval
+	if interval > longest {
+		longest = interval
+	}
+	check := cadence / checksPerEpisode
+	if check < interval {
+		check = interval
+	} else if check > longest {
+		check = longest
+	}
+
+	return check
+}
+
+// publishingCadence returns roughly how often the episodes come out at the time: the middle of the times between the
+// newest episodes, or the time since the newest episode if that's longer (as it is for a show that's stopped). If there
+// aren't enough dates to tell, this returns 0.
+func publishingCadence(episodes []Episode, now time.Time) time.Duration {
+	var dates []time.Time
+	for _, episode := range episodes {
+		if date := parseDate(episode.Date); !date.IsZero() && !date.After(now) {
+			dates = append(dates, date)
+		}
+	}
+	if len(dates) < 2 {
+		return 0
+	}
+
+	sort.Slice(dates, func(i, j int) bool { return dates[i].After(dates[j]) })
+	if len(dates) > cadenceEpisodes {
+		dates = dates[:cadenceEpisodes]
+	}
+	gaps := make([]time.Duration, len(dates)-1)
+	for i := range gaps {
+		gaps[i] = dates[i].Sub(dates[i+1])
+	}
+	sort.Slice(gaps, func(i, j int) bool { return gaps[i] < gaps[j] })
+
+	cadence := gaps[len(gaps)/2]
+	if since := now.Sub(dates[0]); since > cadence {
+		cadence = since
+	}
+
+	return cadence
+}
+
+// prefetchShows starts fetching the shows' feeds in the background, several at once, so that each feed is usually
+// ready by the time its show's turn comes to sync. No more than prefetchAhead feeds are fetched ahead of the syncing;
+// call next each time a show is done syncing to make room for another. Call stop if the rest of the shows won't be
+// synced.
+func prefetchShows(shows []*Show) (next func(), stop func()) {
+	slots := make(chan struct{}, prefetchAhead)
+	quit := make(chan struct{})
+	for _, show := range shows {
+		show.fetched = make(chan struct{})
+	}
+
+	go func() {
+		for _, show := range shows {
+			select {
+			case slots <- struct{}{}:
+			case <-quit:
+				return
+			}
+			go show.prefetch()
+		}
+	}()
+
+	return func() { <-slots }, func() { close(quit) }
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/snhilde/getcast/internal/feedtest"
+)
+
+// Test that a show's check interval grows by an hour for every day between its episodes, within the configured bounds.
+func TestCheckInterval(t *testing.T) {
+	day := 24 * time.Hour
+	tests := []struct {
+		interval time.Duration
+		cadence  time.Duration
+		want     time.Duration
+	}{
+		{time.Hour, 0, time.Hour},           // unknown
+		{time.Hour, day, time.Hour},         // daily
+		{time.Hour, 7 * day, 7 * time.Hour}, // weekly
+		{time.Hour, 365 * day, day},         // stopped
+		{time.Hour, time.Hour, time.Hour},   // never more often than the interval
+		{2 * day, 365 * day, 2 * day},       // never less often than a long interval
+		{10 * time.Minute, day, time.Hour},  // daily, with a short interval
+		{0, 7 * day, 7 * time.Hour},         // no interval set
+	}
+	for _, test := range tests {
+		if have := checkInterval(test.interval, test.cadence); have != test.want {
+			t.Error(test.interval, test.cadence, "- Want:", test.want, "Have:", have)
+		}
+	}
+}
+
+// Test that the publishing cadence is the usual gap between episodes, or the time since the last one if it's longer,
+// and that off-schedule episodes don't throw it off.
+func TestPublishingCadence(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	episodes := func(newest time.Time, gap time.Duration, n int) []Episode {
+		var list []Episode
+		for i := 0; i < n; i++ {
+			list = append(list, Episode{Date: newest.Add(-gap * time.Duration(i)).Format(time.RFC1123Z)})
+		}
+		return list
+	}
+	day := 24 * time.Hour
+
+	tests := []struct {
+		name     string
+		episodes []Episode
+		want     time.Duration
+	}{
+		{"weekly", episodes(now.Add(-day), 7*day, 20), 7 * day},
+		{"stopped", episodes(now.Add(-100*day), 7*day, 20), 100 * day},
+		{"one episode", episodes(now, day, 1), 0},
+		{"no dates", []Episode{{}, {}, {}}, 0},
+	}
+	// An episode that came out off schedule doesn't throw off the rest.
+	bonus := append(episodes(now.Add(-day), 7*day, 10), Episode{Date: now.Add(-2 * day).Format(time.RFC1123Z)})
+	tests = append(tests, struct {
+		name     string
+		episodes []Episode
+		want     time.Duration
+	}{"bonus episode", bonus, 7 * day})
+
+	for _, test := range tests {
+		if have := publishingCadence(test.episodes, now); have != test.want {
+			t.Error(test.name, "- Want:", test.want, "Have:", have)
+		}
+	}
+}
+
+// Test that feeds checked together are spread out across the interval, and that they come due again on their own.
+func TestSchedule(t *testing.T) {
+	s := newSchedule()
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var feeds []string
+	for i := 0; i < 100; i++ {
+		feeds = append(feeds, fmt.Sprintf("https://example.com/%d.xml", i))
+	}
+
+	if due := s.due(feeds, now); len(due) != len(feeds) {
+		t.Fatal("Feeds that were never checked aren't due - Want:", len(feeds), "Have:", len(due))
+	}
+	for _, feed := range feeds {
+		s.checked(feed, now, time.Hour, 0)
+	}
+
+	// Every quarter of the interval has some of the feeds.
+	quarters := make([]int, 4)
+	for _, feed := range feeds {
+		next := s.next[feed].Sub(now)
+		if next <= 0 || next > time.Hour {
+			t.Fatal("Next check is outside the interval:", next)
+		}
+		quarters[int((next-1)/(15*time.Minute))]++
+	}
+	for i, n := range quarters {
+		if n == 0 {
+			t.Error("No checks in quarter", i+1, "of the interval:", quarters)
+		}
+	}
+
+	if wait := s.wait(feeds, now); wait <= 0 || wait > 15*time.Minute {
+		t.Error("Incorrect wait for the first check:", wait)
+	}
+	if due := s.due(feeds, now.Add(30*time.Minute)); len(due) == 0 || len(due) == len(feeds) {
+		t.Error("Incorrect number of feeds due halfway through - Have:", len(due))
+	}
+
+	// After that, checks are an interval apart, give or take the jitter.
+	s.checked(feeds[0], now, time.Hour, 0)
+	if next := s.next[feeds[0]].Sub(now); next < 48*time.Minute || next > 72*time.Minute {
+		t.Error("Incorrect time until next check - Want: 48m-72m Have:", next)
+	}
+
+	// Feeds that are no longer subscribed to are forgotten.
+	s.due(feeds[:1], now)
+	if len(s.next) != 1 {
+		t.Error("Incorrect number of scheduled feeds - Want: 1 Have:", len(s.next))
+	}
+}
+
+// Test that shows synced after their feeds are prefetched end up the same as when the feed is fetched during the sync.
+func TestPrefetchShows(t *testing.T) {
+	var feeds []feedtest.Feed
+	for i := 0; i < 6; i++ {
+		feeds = append(feeds, feedtest.Generate(fmt.Sprint("Show ", i), 2, 4096))
+	}
+	server := feedtest.NewServer(feeds...)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "getcast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("XDG_CACHE_HOME", filepath.Join(dir, "cache"))
+	defer os.Unsetenv("XDG_CACHE_HOME")
+
+	tmpState, tmpCache, tmpArtwork := StateDB, TagCache, ArtworkMode
+	defer func() { StateDB, TagCache, ArtworkMode = tmpState, tmpCache, tmpArtwork }()
+	ArtworkMode = ArtworkNone
+	StateDB, _ = LoadState(filepath.Join(dir, "state.json"))
+	TagCache = LoadTagIndex(filepath.Join(dir, "tags.json"))
+
+	var shows []*Show
+	for i := range feeds {
+		u, _ := url.Parse(server.FeedURL(i))
+		shows = append(shows, &Show{URL: u})
+	}
+
+	next, stop := prefetchShows(shows)
+	defer stop()
+	for i, show := range shows {
+		good, bad, err := show.Sync(filepath.Join(dir, "shows"), "")
+		next()
+		if err != nil || good != 2 || bad != 0 {
+			t.Error("Show", i, "- Want: 2 synced Have:", good, bad, err)
+		}
+		if show.Title != feeds[i].Title {
+			t.Error("Show", i, "- Incorrect title - Want:", feeds[i].Title, "Have:", show.Title)
+		}
+		if show.cadence <= 0 {
+			t.Error("Show", i, "- Missing cadence")
+		}
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// searchTextExts are the extensions of the files next to an episode's audio file (with the same name) whose text is
+// searched along with the episode. These are the saved show notes and any transcripts.
+var searchTextExts = []string{"." + NotesMarkdown, "." + NotesHTML, ".txt", ".srt", ".vtt"}
+
+// titleWeight is how many times more a word in an episode's title counts for than a word anywhere else.
+const titleWeight = 10
+
+// SearchIndex is a small full-text index of the episodes in the library. For every audio file, it holds the words in
+// the episode's title, description, show notes, and transcript. An entry is only used while none of those files have
+// changed. It is stored as JSON in the profile's cache directory.
+type SearchIndex struct {
+	path  string
+	dirty bool
+
+	Files map[string]SearchEntry `json:"files"` // keyed by absolute path of the audio file
+}
+
+// SearchEntry is the indexed text of an individual episode.
+type SearchEntry struct {
+	Size    int64          `json:"size"`  // size of the audio file
+	ModTime time.Time      `json:"mtime"` // latest modification time of the audio file and its text files
+	Title   string         `json:"title"`
+	Terms   map[string]int `json:"terms"` // number of times each word appears
+}
+
+// SearchResult is an episode that matched a search.
+type SearchResult struct {
+	Path  string
+	Title string
+	Score int
+}
+
+// SearchIndexPath returns the location of the active profile's search index.
+func SearchIndexPath() string {
+	dir := ActiveProfile.CacheDir()
+	if dir == "" {
+		return ""
+	}
+
+	return filepath.Join(dir, "search.json")
+}
+
+// LoadSearchIndex reads the search index at the provided path. If the index does not exist yet or can't be read, an
+// empty index is returned; everything in it can be rebuilt from the library.
+func LoadSearchIndex(path string) *SearchIndex {
+	index := &SearchIndex{path: path, Files: make(map[string]SearchEntry)}
+	if path == "" {
+		return index
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			Log("Error reading search index:", err)
+		}
+		return index
+	}
+
+	if err := json.Unmarshal(data, index); err != nil {
+		Log("Error parsing search index, starting over:", err)
+		index.Files = make(map[string]SearchEntry)
+	}
+	if index.Files == nil {
+		index.Files = make(map[string]SearchEntry)
+	}
+
+	Debug("Loaded search index of", len(index.Files), "files from", path)
+	return index
+}
+
+// Update walks the main download directory and indexes every episode that is new or has changed since it was last
+// indexed. Episodes that are no longer in the directory are dropped from the index.
+func (i *SearchIndex) Update(mainDir string) error {
+	mainDir, err := filepath.Abs(mainDir)
+	if err != nil {
+		return err
+	}
+
+	byPath := StateDB.ByPath()
+	seen := make(map[string]bool)
+	walkFunc := func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == mainDir {
+				return err
+			}
+			// One unreadable file or directory shouldn't stop the whole update.
+			Log("Skipping unreadable path:", err)
+			return nil
+		}
+
+		if strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() && path != mainDir {
+				return filepath.SkipDir
+			}
+			return nil
+		} else if info.IsDir() || !isAudio(info.Name()) {
+			return nil
+		}
+		seen[path] = true
+
+		modTime := info.ModTime()
+		for _, file := range searchTextFiles(path) {
+			if textInfo, err := os.Stat(file); err == nil && textInfo.ModTime().After(modTime) {
+				modTime = textInfo.ModTime()
+			}
+		}
+		if entry, ok := i.Files[path]; ok && entry.Size == info.Size() && entry.ModTime.Equal(modTime) {
+			return nil
+		}
+
+		Debug("Indexing", path)
+		entry := indexEpisode(path, byPath[path])
+		entry.Size = info.Size()
+		entry.ModTime = modTime
+		i.Files[path] = entry
+		i.dirty = true
+
+		return nil
+	}
+
+	if err := filepath.Walk(mainDir, walkFunc); err != nil {
+		return err
+	}
+
+	for path := range i.Files {
+		if !seen[path] && strings.HasPrefix(path, mainDir+string(filepath.Separator)) {
+			delete(i.Files, path)
+			i.dirty = true
+		}
+	}
+
+	return nil
+}
+
+// indexEpisode gathers the words of the episode in the audio file at the path. The title comes from the state DB if
+// getcast downloaded the episode and from the file's tags otherwise. The description always comes from the tags, since
+// the state only has its first paragraph.
+func indexEpisode(path string, es EpisodeState) SearchEntry {
+	title, desc := es.Title, ""
+	meta, err := readFileMeta(path)
+	if err != nil {
+		Debug("Error reading metadata of", filepath.Base(path), "-", err)
+	} else {
+		if title == "" {
+			title = getTag(meta, "TIT2")
+		}
+		desc = getTag(meta, "TDES")
+	}
+	if desc == "" {
+		desc = es.Summary
+	}
+	if title == "" {
+		title = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+
+	entry := SearchEntry{Title: title, Terms: make(map[string]int)}
+	for _, term := range searchTerms(title) {
+		entry.Terms[term] += titleWeight
+	}
+	for _, term := range searchTerms(htmlToMarkdown(desc)) {
+		entry.Terms[term]++
+	}
+
+	for _, file := range searchTextFiles(path) {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		for _, term := range searchTerms(searchText(file, string(data))) {
+			entry.Terms[term]++
+		}
+	}
+
+	return entry
+}
+
+// searchTextFiles returns the paths of the text files that might be next to the audio file. They might not exist.
+func searchTextFiles(path string) []string {
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+
+	files := make([]string, len(searchTextExts))
+	for i, ext := range searchTextExts {
+		files[i] = base + ext
+	}
+
+	return files
+}
+
+// searchText returns just the readable text in the file's contents, dropping HTML markup and the cue numbers and
+// timings of transcripts.
+func searchText(file string, text string) string {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case "." + NotesHTML:
+		return htmlToMarkdown(text)
+	case ".srt", ".vtt":
+		var lines []string
+		for _, line := range strings.Split(text, "\n") {
+			line = strings.TrimSpace(line)
+			if line == "WEBVTT" || strings.Contains(line, "-->") || strings.Trim(line, "0123456789") == "" {
+				continue
+			}
+			lines = append(lines, htmlToMarkdown(line))
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	return text
+}
+
+// searchTerms splits the text into lowercase words.
+func searchTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// Search returns the episodes under the main directory that have every word in the query, best matches first.
+func (i *SearchIndex) Search(mainDir string, query string) []SearchResult {
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	mainDir, err := filepath.Abs(mainDir)
+	if err != nil {
+		return nil
+	}
+
+	var results []SearchResult
+	for path, entry := range i.Files {
+		if !strings.HasPrefix(path, mainDir+string(filepath.Separator)) {
+			continue
+		}
+
+		score := 0
+		for _, term := range terms {
+			n := entry.Terms[term]
+			if n == 0 {
+				score = 0
+				break
+			}
+			score += n
+		}
+		if score > 0 {
+			results = append(results, SearchResult{Path: path, Title: entry.Title, Score: score})
+		}
+	}
+
+	sort.Slice(results, func(a, b int) bool {
+		if results[a].Score != results[b].Score {
+			return results[a].Score > results[b].Score
+		}
+		return results[a].Path < results[b].Path
+	})
+
+	return results
+}
+
+// Save writes the index out to disk if it has changed.
+func (i *SearchIndex) Save() error {
+	if i == nil || i.path == "" || !i.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(i)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(i.path), 0755); err != nil {
+		return err
+	}
+
+	tmp := i.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("error writing search index: %v", err)
+	}
+	if err := os.Rename(tmp, i.path); err != nil {
+		return err
+	}
+
+	i.dirty = false
+	return nil
+}
+
+// runFind searches the titles, descriptions, show notes, and transcripts of every episode in the library for the
+// words in the query and prints the paths of the matching files.
+func runFind(config *Config, dirArg string, args []string) error {
+	fs := flag.NewFlagSet("find", flag.ContinueOnError)
+	rebuild := fs.Bool("rebuild", false, "Optional. Index every episode again instead of only the ones that changed")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+
+	query := strings.Join(fs.Args(), " ")
+	if len(searchTerms(query)) == 0 {
+		Log("No search terms specified")
+		return errUsage
+	}
+
+	dir, err := downloadDir(config, dirArg)
+	if err != nil {
+		return err
+	}
+
+	index := LoadSearchIndex(SearchIndexPath())
+	if *rebuild {
+		index.Files = make(map[string]SearchEntry)
+		index.dirty = true
+	}
+	if err := index.Update(dir); err != nil {
+		return fmt.Errorf("error indexing library: %v", err)
+	}
+	if err := index.Save(); err != nil {
+		Log("Error saving search index:", err)
+	}
+
+	results := index.Search(dir, query)
+	for _, result := range results {
+		fmt.Println(result.Path)
+	}
+	Debug("Found", len(results), "matching episodes")
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/snhilde/getcast/internal/feedtest"
+)
+
+// Test that episodes are found by the words in their titles, show notes, and transcripts, and that changed files are
+// indexed again.
+func TestSearchIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast-search")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	showDir := filepath.Join(dir, "Show")
+	if err := os.Mkdir(showDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"01 Containers.mp3": "",
+		"01 Containers.md":  "# Containers\n\nWe talk about Kubernetes and Docker.",
+		"02 Databases.mp3":  "",
+		"02 Databases.vtt":  "WEBVTT\n\n1\n00:00:01.000 --> 00:00:04.000\nRunning Postgres on <i>Kubernetes</i>\n",
+		"03 Networks.mp3":   "",
+		"03 Networks.txt":   "Nothing to see here",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(showDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	index := LoadSearchIndex("")
+	if err := index.Update(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	search := func(query string, want ...string) {
+		t.Helper()
+		results := index.Search(dir, query)
+		if len(results) != len(want) {
+			t.Error(query, "- Want:", len(want), "results, Have:", len(results))
+			return
+		}
+		for i, result := range results {
+			if have := filepath.Base(result.Path); have != want[i] {
+				t.Error(query, "- Want:", want[i], "Have:", have)
+			}
+		}
+	}
+
+	search("kubernetes", "01 Containers.mp3", "02 Databases.mp3")
+	search("KUBERNETES postgres", "02 Databases.mp3")
+	search("00")
+	search("networks", "03 Networks.mp3")
+	search("webassembly")
+
+	// A changed transcript is indexed again.
+	transcript := filepath.Join(showDir, "03 Networks.txt")
+	if err := ioutil.WriteFile(transcript, []byte("Routing Kubernetes traffic"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := os.Stat(transcript)
+	later := info.ModTime().Add(time.Minute)
+	os.Chtimes(transcript, later, later)
+	if err := index.Update(dir); err != nil {
+		t.Fatal(err)
+	}
+	search("kubernetes", "01 Containers.mp3", "02 Databases.mp3", "03 Networks.mp3")
+
+	// Deleted episodes are dropped.
+	os.Remove(filepath.Join(showDir, "01 Containers.mp3"))
+	if err := index.Update(dir); err != nil {
+		t.Fatal(err)
+	}
+	search("kubernetes", "02 Databases.mp3", "03 Networks.mp3")
+}
+
+// Test that all of the description of an episode that getcast downloaded is searched, not just the start of it that's
+// in the state.
+func TestSearchFullDescription(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast-search")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tmpState := StateDB
+	defer func() { StateDB = tmpState }()
+	StateDB, _ = LoadState(filepath.Join(dir, "state.json"))
+
+	desc := strings.Repeat("A long introduction. ", 20) + "\n\nThe unicorns arrive at the end."
+	e := Episode{Title: "Storage", Desc: desc}
+	e.path = filepath.Join(dir, "Show", "Storage.mp3")
+	os.MkdirAll(filepath.Dir(e.path), 0755)
+	frames := []feedtest.Frame{feedtest.TextFrame("TIT2", "Storage"), feedtest.TextFrame("TDES", desc)}
+	tag := feedtest.Tag{Version: 3, Frames: frames}
+	if err := ioutil.WriteFile(e.path, feedtest.MP3(tag, 100), 0644); err != nil {
+		t.Fatal(err)
+	}
+	StateDB.RecordDownload("https://example.com/feed.xml", "Show", &e)
+
+	index := LoadSearchIndex("")
+	if err := index.Update(dir); err != nil {
+		t.Fatal(err)
+	}
+	if results := index.Search(dir, "unicorns"); len(results) != 1 {
+		t.Error("Incorrect results - Want: 1 Have:", len(results))
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"text/template"
+	"time"
+)
+
+// ShowSettings holds the settings that can be given for each show in the config, either by the subscription itself or
+// by the groups it's in. Settings that are left out (empty, or null for numbers and lengths) are inherited, in this
+// order of precedence:
+//  1. the subscription's own setting
+//  2. the setting of the subscription's groups, in the order they're listed
+//  3. the option on the command line or in the environment
+//  4. the option's default
+type ShowSettings struct {
+	Dir             string       `json:"dir"`              // download directory for the show, instead of the main one
+	Keep            *int         `json:"keep"`             // number of newest episodes to keep, or 0 for all of them
+	MinWidth        *int         `json:"min_width"`        // minimum width of the episode number (-m)
+	Filename        string       `json:"filename"`         // filename template (-filename)
+	Attempts        *int         `json:"attempts"`         // download attempts for each episode (-attempts)
+	Artwork         string       `json:"artwork"`          // what to do with each episode's artwork (-artwork)
+	Pictures        PictureRules `json:"pictures"`         // pictures to embed (-pictures)
+	HostConcurrency *int         `json:"host_concurrency"` // simultaneous requests to the show's hosts (-host-concurrency)
+	Enclosures      string       `json:"enclosures"`       // which enclosures to download (-enclosures)
+	Notes           string       `json:"notes"`            // format to save show notes in (-notes)
+	TrimStart       *Duration    `json:"trim_start"`       // length of the intro to cut off each episode
+	TrimEnd         *Duration    `json:"trim_end"`         // length of the outro to cut off each episode
+	DeletePlayed    *int         `json:"delete_played"`    // days after an episode is played to move it to the trash, or 0 to keep it
+	Updated         string       `json:"updated"`          // what to do with episodes the publisher changed (-updated)
+
+	filename *template.Template // compiled filename template
+}
+
+// validate checks that the settings that are given are usable, and compiles the filename template.
+func (s *ShowSettings) validate() error {
+	switch {
+	case s.Keep != nil && *s.Keep < 0:
+		return fmt.Errorf("invalid keep: %v", *s.Keep)
+	case s.MinWidth != nil && *s.MinWidth < 0:
+		return fmt.Errorf("invalid min_width: %v", *s.MinWidth)
+	case s.Attempts != nil && *s.Attempts < 1:
+		return fmt.Errorf("invalid attempts: %v", *s.Attempts)
+	case s.HostConcurrency != nil && *s.HostConcurrency < 1:
+		return fmt.Errorf("invalid host_concurrency: %v", *s.HostConcurrency)
+	case s.TrimStart != nil && s.TrimStart.Duration < 0:
+		return fmt.Errorf("invalid trim_start: %v", s.TrimStart)
+	case s.TrimEnd != nil && s.TrimEnd.Duration < 0:
+		return fmt.Errorf("invalid trim_end: %v", s.TrimEnd)
+	case s.DeletePlayed != nil && *s.DeletePlayed < 0:
+		return fmt.Errorf("invalid delete_played: %v", *s.DeletePlayed)
+	}
+
+	if s.Artwork != "" {
+		if err := ValidateArtworkMode(s.Artwork); err != nil {
+			return err
+		}
+	}
+	if s.Enclosures != "" {
+		if err := ValidateEnclosureMode(s.Enclosures); err != nil {
+			return err
+		}
+	}
+	if err := ValidateNotesFormat(s.Notes); err != nil {
+		return err
+	}
+	if err := ValidateUpdatedMode(s.Updated); err != nil {
+		return err
+	}
+
+	if s.Filename != "" && s.filename == nil {
+		tmpl, err := ParseFilenameTemplate(s.Filename)
+		if err != nil {
+			return fmt.Errorf("invalid filename: %v", err)
+		}
+		s.filename = tmpl
+	}
+
+	return nil
+}
+
+// inherit fills in the settings that aren't given from the fallback.
+func (s ShowSettings) inherit(from ShowSettings) ShowSettings {
+	if s.Dir == "" {
+		s.Dir = from.Dir
+	}
+	if s.Keep == nil {
+		s.Keep = from.Keep
+	}
+	if s.MinWidth == nil {
+		s.MinWidth = from.MinWidth
+	}
+	if s.Filename == "" {
+		s.Filename = from.Filename
+		s.filename = from.filename
+	}
+	if s.Attempts == nil {
+		s.Attempts = from.Attempts
+	}
+	if s.Artwork == "" {
+		s.Artwork = from.Artwork
+	}
+	if len(s.Pictures) == 0 {
+		s.Pictures = from.Pictures
+	}
+	if s.HostConcurrency == nil {
+		s.HostConcurrency = from.HostConcurrency
+	}
+	if s.Enclosures == "" {
+		s.Enclosures = from.Enclosures
+	}
+	if s.Notes == "" {
+		s.Notes = from.Notes
+	}
+	if s.TrimStart == nil {
+		s.TrimStart = from.TrimStart
+	}
+	if s.TrimEnd == nil {
+		s.TrimEnd = from.TrimEnd
+	}
+	if s.DeletePlayed == nil {
+		s.DeletePlayed = from.DeletePlayed
+	}
+	if s.Updated == "" {
+		s.Updated = from.Updated
+	}
+
+	return s
+}
+
+// globalSettings returns the settings from the command line, the environment, and the defaults.
+func globalSettings() ShowSettings {
+	attempts := Attempts
+	if attempts <= 0 {
+		attempts = 1
+	}
+	s := ShowSettings{
+		Keep:            intSetting(0),
+		MinWidth:        intSetting(PrefixMinWidth),
+		Attempts:        intSetting(attempts),
+		Artwork:         ArtworkMode,
+		Pictures:        Pictures,
+		HostConcurrency: intSetting(HostConcurrency),
+		Enclosures:      EnclosureMode,
+		Notes:           NotesFormat,
+		TrimStart:       &Duration{},
+		TrimEnd:         &Duration{},
+		DeletePlayed:    intSetting(0),
+		Updated:         UpdatedMode,
+		filename:        FilenameTemplate,
+	}
+	if s.Artwork == "" {
+		s.Artwork = ArtworkEmbed
+	}
+	if s.Updated == "" {
+		s.Updated = UpdatedFlag
+	}
+
+	return s
+}
+
+// defaultSettings returns the settings used when neither the show nor the sync options give them.
+func defaultSettings() ShowSettings {
+	return ShowSettings{
+		Keep:         intSetting(0),
+		MinWidth:     intSetting(0),
+		Attempts:     intSetting(1),
+		Artwork:      ArtworkEmbed,
+		Enclosures:   EnclosuresFirst,
+		TrimStart:    &Duration{},
+		TrimEnd:      &Duration{},
+		DeletePlayed: intSetting(0),
+		Updated:      UpdatedFlag,
+	}
+}
+
+// intSetting returns a setting with the value, for settings that are built in code instead of read from the config.
+func intSetting(n int) *int {
+	return &n
+}
+
+// intValue returns the value of the setting, or 0 if it isn't given.
+func intValue(n *int) int {
+	if n == nil {
+		return 0
+	}
+
+	return *n
+}
+
+// durationValue returns the value of the setting, or 0 if it isn't given.
+func durationValue(d *Duration) time.Duration {
+	if d == nil {
+		return 0
+	}
+
+	return d.Duration
+}
+
+// options returns the settings of the episode's show, falling back to the ones from the command line for episodes that
+// weren't prepared by a show.
+func (e *Episode) options() *ShowSettings {
+	if e == nil || e.settings == nil {
+		return ShowSettings{}.resolve(CurrentSyncOptions())
+	}
+
+	return e.settings
+}
+
+// resolve returns the settings with everything that isn't given filled in from the sync options, and then from the
+// defaults. The settings must already be validated.
+func (s ShowSettings) resolve(opts *SyncOptions) *ShowSettings {
+	resolved := s.inherit(opts.Settings).inherit(defaultSettings())
+	return &resolved
+}
+
+// UnmarshalJSON reads the rules from either a comma-separated string, as given on the command line, or a list of
+// strings.
+func (r *PictureRules) UnmarshalJSON(b []byte) error {
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		var value string
+		if err := json.Unmarshal(b, &value); err != nil {
+			return fmt.Errorf("invalid pictures: %s", string(b))
+		}
+		list = []string{value}
+	}
+
+	*r = nil
+	for _, value := range list {
+		if err := r.Set(value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
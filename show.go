@@ -1,53 +1,151 @@
 package main
 
 import (
-	"encoding/xml"
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
 // Show is the main type. It holds information about the podcast and its episodes.
 type Show struct {
-	URL      *url.URL
-	Dir      string    // show's directory on disk
-	Title    string    `xml:"channel>title"`
-	Author   string    `xml:"channel>author"`
-	Image    string    `xml:"channel>image,href"`
-	Episodes []Episode `xml:"channel>item"`
+	URL        *url.URL
+	Auth       *Credentials   // login information for premium/private feeds
+	Order      string         // order to download new episodes in: "oldest" or "newest"
+	Genre      string         // genre for the TCON frame, or "category" to use the iTunes category
+	TitleRules TitleRules     // search and replace rules for episode titles
+	Fields     FieldMap       // where the feed keeps episode fields that aren't in the usual tags
+	Dir        string         // show's directory on disk
+	Failures   []Failure      // episodes that failed to download during the sync
+	Slow       []SlowDownload // episodes that downloaded slower than -slow-speed during the sync
+	BadTags    []BadTag       // episodes whose published tags had frames that couldn't be read
+	New        []string       // files downloaded during the sync
+	Title      string         `xml:"channel>title"`
+	Author     string         `xml:"channel>author"`
+	Desc       string         `xml:"channel>description"`
+	Language   string         `xml:"channel>language"`
+	Image      string         `xml:"channel>image,href"`
+	People     []Person       `xml:"https://podcastindex.org/namespace/1.0 channel>person"`
+	Categories []Category     `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd channel>category"`
+	Copyright  string         `xml:"channel>copyright"`
+	Explicit   string         `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd channel>explicit"`
+	Funding    []Funding      `xml:"https://podcastindex.org/namespace/1.0 channel>funding"`
+	AtomLinks  []atomLink     `xml:"http://www.w3.org/2005/Atom channel>link"`
+	Episodes   []Episode      `xml:"channel>item"`
+
+	Options  *SyncOptions  // settings from the command line, or nil to use the current ones (see CurrentSyncOptions)
+	settings *ShowSettings // settings from the config, which are resolved against the options, or nil to use the options
+	cadence  time.Duration // how often the show comes out (see publishingCadence), or 0 if unknown
+	fetched  chan struct{} // closed once the feed is prefetched (see prefetchShows), or nil to fetch it during Sync
+	fetchErr error         // error from prefetching the feed
 }
 
-// Sync gets the current list of available episodes, determines which of them need to be downloaded, and then gets them.
-func (s *Show) Sync(mainDir string, specificEp string) (int, int, error) {
-	resp, err := http.Get(s.URL.String())
+// options returns the show's settings, with the ones that the show's subscription doesn't give taken from its sync
+// options.
+func (s *Show) options() *ShowSettings {
+	if s == nil || s.settings == nil {
+		return ShowSettings{}.resolve(s.syncOptions())
+	}
+
+	return s.settings.resolve(s.syncOptions())
+}
+
+// These are the orders that new episodes can be downloaded in.
+const (
+	OrderOldest = "oldest"
+	OrderNewest = "newest"
+)
+
+// ValidateOrder checks that the download order exists. An empty order uses the default.
+func ValidateOrder(order string) error {
+	switch order {
+	case "", OrderOldest, OrderNewest:
+		return nil
+	}
+
+	return fmt.Errorf("invalid download order: %v", order)
+}
+
+// NewShow creates a new Show object for the subscription.
+func NewShow(sub Subscription) (*Show, error) {
+	u, err := url.Parse(sub.URL)
 	if err != nil {
-		return 0, 0, fmt.Errorf("error getting RSS feed: %v", err)
+		return nil, fmt.Errorf("invalid URL: %v", err)
 	}
-	defer resp.Body.Close()
 
-	data, err := ioutil.ReadAll(resp.Body)
+	creds, err := sub.Credentials()
 	if err != nil {
-		return 0, 0, fmt.Errorf("error reading RSS feed: %v", err)
+		return nil, fmt.Errorf("invalid credentials for %v: %v", sub.URL, err)
+	}
+
+	if err := sub.TitleRules.compile(); err != nil {
+		return nil, fmt.Errorf("invalid subscription for %v: %v", sub.URL, err)
+	}
+	if err := sub.Fields.validate(); err != nil {
+		return nil, fmt.Errorf("invalid subscription for %v: %v", sub.URL, err)
+	}
+
+	settings := sub.ShowSettings
+	if err := settings.validate(); err != nil {
+		return nil, fmt.Errorf("invalid settings for %v: %v", sub.URL, err)
+	}
+
+	return &Show{URL: u, Auth: creds, Order: sub.Order, Genre: sub.Genre, TitleRules: sub.TitleRules, Fields: sub.Fields, settings: &settings}, nil
+}
+
+// Fetch downloads and parses the show's RSS feed, preparing the list of episodes in the feed from oldest to newest.
+func (s *Show) Fetch() error {
+	opts := s.syncOptions()
+
+	// The feed is always checked with the server, even if the cached copy hasn't gone stale, because we're usually asked
+	// to sync a show when it might have changed. An unchanged feed still costs only a 304.
+	data, err := fetchCached(fetchRequest{
+		link:       s.URL.String(),
+		creds:      s.Auth,
+		compressed: true,
+		revalidate: true,
+		limit:      opts.MaxFeedSize,
+	})
+	noteProgress()
+	var reqErr *requestError
+	if err != nil && Interrupted() {
+		// The fetch was stopped along with the sync, so the feed didn't fail.
+		return errInterrupted
+	} else if errors.As(err, &reqErr) {
+		return newError(ErrFeedUnreachable, "error getting RSS feed: %v", reqErr.err)
+	} else if err != nil {
+		return fmt.Errorf("error reading RSS feed: %v", err)
+	}
+	Log("Parsing RSS feed", "("+Reduce(len(data))+")")
+
+	if opts.FeedCache {
+		if err := SaveFeed(s.URL, data); err != nil {
+			Log("Error saving feed to cache:", err)
+		}
 	}
 
-	if err := xml.Unmarshal(data, s); err != nil {
-		return 0, 0, fmt.Errorf("error reading RSS feed: %v", err)
+	feed, err := ParseFeed(data)
+	if err != nil {
+		return fmt.Errorf("error reading RSS feed: %v", err)
 	}
+	feed.fill(s)
 	if s.Title == "" {
-		return 0, 0, fmt.Errorf("error parsing RSS feed: no show information found")
+		return fmt.Errorf("error parsing RSS feed: no show information found")
 	} else if len(s.Episodes) == 0 {
-		return 0, 0, fmt.Errorf("error parsing RSS feed: no episodes found")
+		return newError(ErrNoEpisodes, "error parsing RSS feed: no episodes found")
+	}
+	if err := s.Fields.apply(data, s.Episodes); err != nil {
+		return err
 	}
 
-	Log("Found show:", s.Title)
+	Log("Found show:", s.Title, "-", len(s.Episodes), "items in feed")
 
 	// The feed will list episodes newest to oldest. We'll reverse that here to make error handling easier later on.
 	length := len(s.Episodes)
@@ -55,14 +153,93 @@ func (s *Show) Sync(mainDir string, specificEp string) (int, int, error) {
 		s.Episodes[i], s.Episodes[length-1-i] = s.Episodes[length-1-i], s.Episodes[i]
 	}
 
+	// Some items don't have an enclosure but do link to audio elsewhere. Items without any audio at all (like blog
+	// posts) aren't episodes, so we'll drop them here instead of counting them as failures later.
+	// Items with more than one enclosure might also become more than one episode here.
+	var episodes []Episode
+	settings, download := s.options(), opts.downloadOptions()
+	for _, episode := range s.Episodes {
+		episode.settings, episode.download = settings, download
+		if selected := episode.SelectEnclosures(settings.Enclosures); selected != nil {
+			if opts.StripTrackers || opts.Download.NoExternal {
+				for i := range selected {
+					selected[i].Enclosure.URL = UnwrapTrackers(selected[i].Enclosure.URL)
+				}
+			}
+			episodes = append(episodes, selected...)
+		} else {
+			s.debug("Skipping item without audio:", episode.Title)
+		}
+	}
+	s.Episodes = episodes
+
+	// Clean up the episode titles before they're used for anything else.
+	cleanup := s.cleanup()
+	for i := range s.Episodes {
+		s.Episodes[i].Title = cleanup.Clean(s.Episodes[i].Title, s.Title)
+	}
+
 	// Make sure we can create directories and files with the names that were parsed earlier from the RSS feed.
 	s.Title = SanitizeTitle(s.Title)
-	Debug("Setting show title to", s.Title)
-	Debug("Setting show artist to", s.Author)
+	s.debug("Setting show title to", s.Title)
+	s.debug("Setting show artist to", s.Author)
+	genre := s.genre()
+	// Never write login information into the files.
+	feedURL := publicURL(s.URL.String())
+	s.debug("Setting show genre to", genre)
+	rights := Rights{Copyright: strings.TrimSpace(s.Copyright), Explicit: s.Explicit, Funding: s.Funding}
 	for i := range s.Episodes {
 		s.Episodes[i].SetShowTitle(s.Title)
 		s.Episodes[i].SetShowArtist(s.Author)
 		s.Episodes[i].SetShowImage(s.Image)
+		s.Episodes[i].SetShowAuth(s.Auth)
+		s.Episodes[i].SetShowLanguage(s.Language)
+		s.Episodes[i].SetShowPeople(s.People)
+		s.Episodes[i].SetShowGenre(genre)
+		s.Episodes[i].SetShowURL(feedURL)
+		s.Episodes[i].SetShowRights(rights)
+	}
+	s.setTotals()
+	s.cadence = publishingCadence(s.Episodes, time.Now())
+
+	return nil
+}
+
+// prefetch fetches the feed ahead of Sync, which then picks up where this left off. Shows that the profile's content
+// rules already rule out aren't fetched.
+func (s *Show) prefetch() {
+	defer close(s.fetched)
+	if s.blocked() != "" {
+		return
+	}
+
+	hosts.limit(s.URL.String(), intValue(s.options().HostConcurrency))
+	s.fetchErr = s.Fetch()
+}
+
+// Sync gets the current list of available episodes, determines which of them need to be downloaded, and then gets them.
+func (s *Show) Sync(mainDir string, specificEp string) (int, int, error) {
+	settings := s.options()
+	opts := s.syncOptions()
+	hosts.limit(s.URL.String(), intValue(settings.HostConcurrency))
+
+	// The profile's content rules can rule out the whole show. This is checked before the feed is fetched, if the show
+	// is already known, and again before anything in the show's directory is touched.
+	if reason := s.blocked(); reason != "" {
+		Log("Skipping", s.URL, "-", reason)
+		return 0, 0, nil
+	}
+	if s.fetched != nil {
+		<-s.fetched
+		if s.fetchErr != nil {
+			return 0, 0, s.fetchErr
+		}
+	} else if err := s.Fetch(); err != nil {
+		return 0, 0, err
+	}
+	if reason := s.blocked(); reason != "" {
+		Log("Skipping", s.Title, "-", reason)
+		return 0, 0, nil
 	}
 
 	// Validate (or create) this show's directory.
@@ -71,15 +248,62 @@ func (s *Show) Sync(mainDir string, specificEp string) (int, int, error) {
 		return 0, 0, fmt.Errorf("invalid show directory: %v", err)
 	}
 
+	if err := PurgeTrash(s.Dir, opts.TrashAge); err != nil {
+		Log("Error purging trash:", err)
+	}
+
+	if err := s.applyPreset(opts.Preset); err != nil {
+		Log("Error setting up show directory for", opts.Preset, "-", err)
+	}
+
+	// If we're mirroring the feed, get rid of anything that's no longer in it.
+	if opts.Mirror && specificEp == "" {
+		feedTitles := newTitleSet()
+		for _, episode := range s.Episodes {
+			feedTitles.Add(episode.Title)
+		}
+		if err := s.mirror(feedTitles); err != nil {
+			Log("Error mirroring feed:", err)
+		}
+	}
+
+	// Huge archives get bucketed into a directory for each year. This has to be decided before we filter the list of
+	// episodes down to the ones we need.
+	byYear := useYearDirs(len(s.Episodes))
+
+	// Only the newest episodes are kept for shows with a retention limit.
+	if keep := intValue(s.options().Keep); keep > 0 && specificEp == "" {
+		if err := s.retain(keep); err != nil {
+			Log("Error removing old episodes:", err)
+		}
+	}
+
+	// Episodes that have been played can be cleared out after a while.
+	if days := intValue(s.options().DeletePlayed); days > 0 && specificEp == "" {
+		s.deletePlayed(days)
+	}
+
 	// Choose which episodes we want to download.
-	if err := s.filter(specificEp); err != nil {
+	if err := s.filter(specificEp, opts.Filter); err != nil {
 		return 0, 0, fmt.Errorf("error selecting episodes: %v", err)
 	}
 
+	// The episodes are oldest first at this point.
+	order := s.Order
+	if order == "" {
+		order = opts.Order
+	}
+	if order == OrderNewest {
+		length := len(s.Episodes)
+		for i := 0; i < length/2; i++ {
+			s.Episodes[i], s.Episodes[length-1-i] = s.Episodes[length-1-i], s.Episodes[i]
+		}
+	}
+
 	switch len(s.Episodes) {
 	case 0:
 		if specificEp != "" {
-			return 0, 0, fmt.Errorf("episode %v not found", specificEp)
+			return 0, 0, newError(ErrEpisodeNotFound, "episode %v not found", specificEp)
 		}
 		Log("No new episodes")
 		return 0, 0, nil
@@ -89,35 +313,109 @@ func (s *Show) Sync(mainDir string, specificEp string) (int, int, error) {
 		Log("Downloading", len(s.Episodes), "episodes")
 	}
 
+	// Fetch the artwork in the background so that building each episode's tags doesn't have to wait on it.
+	if settings.Artwork != ArtworkNone {
+		prefetchArtwork(s.Episodes)
+	}
+
 	success := 0
 	failures := 0
 	for _, episode := range s.Episodes {
+		if Interrupted() {
+			return success, failures, errInterrupted
+		}
+
 		message := fmt.Sprintf("\n--- Downloading %s", episode.Title)
 		if num := episode.NumberFormatted(); num != "" {
 			message += fmt.Sprintf(" (%s)", num)
 		}
 		message += " ---"
 		Log(message)
-		// Try up to 3 times to download the episode properly.
-		for j := 1; j <= 3; j++ {
-			if err := episode.Download(s.Dir); err == errDownload {
-				if j < 3 {
-					Log("Download attempt", j, "of 3 failed, trying again")
+		noteProgress()
+		// A problem with one episode in the feed shouldn't stop the others from syncing.
+		if err := episode.validateData(); err != nil {
+			Log("Skipping invalid episode:", err)
+			failures++
+			s.fail(&episode, 0, err)
+			continue
+		}
+
+		dir := seasonDir(opts.Preset, s.Dir, &episode)
+		if byYear {
+			dir = yearDir(dir, &episode)
+		}
+		if err := ValidateDir(dir); err != nil {
+			Log("Invalid season directory:", err)
+			failures++
+			s.fail(&episode, 0, err)
+			continue
+		}
+
+		hosts.limit(episode.Enclosure.URL, intValue(settings.HostConcurrency))
+
+		// Try up to the configured number of times to download the episode properly.
+		attempts := intValue(settings.Attempts)
+		if attempts <= 0 {
+			attempts = 1
+		}
+		for j := 1; j <= attempts; j++ {
+			if err := episode.Download(dir); err == errInterrupted {
+				// Nothing was saved, so there's nothing to record.
+				return success, failures, err
+			} else if errors.Is(err, ErrCircuitOpen) {
+				// The host is down, so the rest of the show will be picked up on the next sync.
+				Log("Skipping the rest of the show:", err)
+				return success, failures, err
+			} else if err == errDownload {
+				if j < attempts {
+					Log("Download attempt", j, "of", attempts, "failed, trying again")
 				} else {
-					Log("ERROR: All 3 download attempts failed")
+					Log("ERROR: All", attempts, "download attempts failed")
+					episode.removePartial()
 					failures++
+					s.fail(&episode, j, err)
 					break
 				}
 			} else if err != nil {
 				Log("Error downloading episode:", err)
+				episode.removePartial()
 				failures++
+				s.fail(&episode, j, err)
 				if errors.Is(err, syscall.ENOSPC) {
 					// If there's no space left for writing, then we'll stop the entire process.
-					return success, failures, fmt.Errorf("no space left on disk, stopping process")
+					return success, failures, newError(ErrDiskFull, "no space left on disk, stopping process: %v", err)
 				}
 				break
 			} else {
 				success++
+				if err := episode.Trim(durationValue(settings.TrimStart), durationValue(settings.TrimEnd)); err != nil {
+					Log("Error trimming episode:", err)
+				}
+				s.record(&episode, nil)
+				s.checkSpeed(&episode)
+				s.checkTag(&episode)
+				s.New = append(s.New, episode.path)
+				if err := episode.SaveNotes(settings.Notes); err != nil {
+					Log("Error saving show notes:", err)
+				}
+				if settings.Artwork == ArtworkExternal {
+					if err := episode.SaveArtwork(); err != nil {
+						Log("Error saving artwork:", err)
+					}
+				}
+				if opts.SaveAttachments {
+					episode.SaveAttachments()
+				}
+				if opts.SaveChapters {
+					if err := episode.SaveChapters(); err != nil {
+						Log("Error saving chapters:", err)
+					}
+				}
+				if opts.Checksums {
+					if err := UpdateChecksums(s.Dir, episode.path); err != nil {
+						Log("Error updating checksums:", err)
+					}
+				}
 				break
 			}
 		}
@@ -126,49 +424,144 @@ func (s *Show) Sync(mainDir string, specificEp string) (int, int, error) {
 	return success, failures, nil
 }
 
-// filter filters out the episodes we don't want to download.
-func (s *Show) filter(specificEp string) error {
-	have := make(map[string]bool)
+// record saves the outcome of the episode's download in the state DB.
+func (s *Show) record(episode *Episode, err error) {
+	if StateDB == nil {
+		return
+	}
+
+	if err == nil {
+		StateDB.RecordDownload(s.URL.String(), s.Title, episode)
+	} else {
+		StateDB.RecordFailure(s.URL.String(), s.Title, episode, err)
+	}
+
+	if err := StateDB.Save(); err != nil {
+		Log("Error saving state:", err)
+	}
+}
+
+// fail records the episode's failure in the state and adds it to the show's list of failures for the summary.
+func (s *Show) fail(episode *Episode, attempts int, err error) {
+	s.record(episode, err)
+	// Untitled episodes still need something to identify them in the summary.
+	name := episode.Title
+	if name == "" {
+		name = episode.GUID
+	}
+	if name == "" {
+		name = episode.Enclosure.URL
+	}
+	if name == "" {
+		name = "(untitled episode)"
+	}
+
+	s.Failures = append(s.Failures, Failure{
+		Show:     s.Title,
+		Episode:  name,
+		Attempts: attempts,
+		Error:    err.Error(),
+	})
+}
+
+// filter filters out the episodes we don't want to download, including the ones that don't match the episode filter.
+func (s *Show) filter(specificEp string, selector *Filter) error {
+	have := newTitleSet()
+	haveGUIDs := make(map[string]bool)
+	haveFiles := make(map[string]bool)
+	rules := s.scanRules()
+	var progress *Progress
+
+	// These are the shortcuts for recognizing files without reading their tags: the files we've recorded downloading,
+	// and the filenames that the episodes in the feed would be saved with.
+	known := StateDB.ByPath()
+	expected := make(map[string]string)
+	for _, episode := range s.Episodes {
+		if !episode.Enclosure.isAudio() {
+			continue
+		}
+		name := filepath.Base(episode.buildFilename(""))
+		if !episode.unknownExt() {
+			expected[name] = episode.Title
+			continue
+		}
+		// Episodes that the feed doesn't say the kind of could have been saved with any of the extensions.
+		for _, ext := range sniffExts {
+			expected[name+ext] = episode.Title
+		}
+	}
+	audioFiles := make(map[string]bool)
+	claimed := make(map[*Episode]bool)
 
 	// We're going to use this function to inspect all the episodes we currently have in the show's directory.
 	walkFunc := func(path string, info os.FileInfo, err error) error {
 		if err != nil {
+			if path == s.Dir {
+				return err
+			}
+			// One unreadable file or directory shouldn't stop the whole sync.
+			Log("Skipping unreadable path:", err)
+			return nil
+		}
+
+		if skip, err := rules.skip(path, info); skip {
 			return err
 		}
 
 		filename := info.Name()
+		if !info.IsDir() && !strings.HasPrefix(filename, ".") {
+			progress.Add()
+		}
 		if strings.HasPrefix(filename, ".") {
-			Debug("Skipping hidden file:", filename)
+			if info.IsDir() && path != s.Dir {
+				// This also keeps us out of the trash.
+				s.debug("Skipping hidden directory:", filename)
+				return filepath.SkipDir
+			}
+			s.debug("Skipping hidden file:", filename)
 			return nil
 		} else if !isAudio(filename) {
-			Debug("Skipping non-audio file:", filename)
+			// Non-audio files (like bonus PDFs) don't have metadata, so we can only go by their names.
+			s.debug("Skipping non-audio file:", filename)
+			haveFiles[filename] = true
 			return nil
 		}
 
-		file, err := os.Open(path)
-		if err != nil {
-			return err
-		}
-		defer file.Close()
+		audioFiles[filename] = true
 
-		// Build the metadata object so we can inspect the tag contents.
-		// (We're temporarily turning off Debug Mode so we don't spam print all the metadata frames. They'll still get
-		// written to the log.)
-		tmpDebug := DebugMode
-		DebugMode = false
-		meta := NewMeta(nil)
-		if _, err := io.Copy(meta, file); err != nil && err != io.EOF {
-			Debug("Stopping walk check early")
-			return err
+		// Opening every file to read its tags is slow for large archives, so we'll first try to recognize the file from
+		// what we recorded when we downloaded it, and then from the filename we would have given it.
+		if abs, err := filepath.Abs(path); err == nil {
+			if es, ok := known[abs]; ok && es.Title != "" {
+				have.AddFile(es.Title, info.Size())
+				if es.GUID != "" {
+					haveGUIDs[es.GUID] = true
+				}
+				return nil
+			}
+		}
+		if title, ok := expected[filename]; ok {
+			have.AddFile(title, info.Size())
+			return nil
 		}
-		DebugMode = tmpDebug
 
-		titleID := "TIT2"
-		if meta.Version() == 2 {
-			titleID = "TT2"
+		entry, err := TagCache.Lookup(path, info)
+		if err != nil {
+			// The file is still here, so it's counted as an episode we have by whichever title is in its name, or by
+			// the name itself, rather than being downloaded again.
+			Log("Unable to read metadata of", filename, "- going by its name:", err)
+			if episode := s.matchName(filename, claimed); episode != nil {
+				claimed[episode] = true
+				have.AddFile(episode.Title, info.Size())
+			} else {
+				have.AddFile(strings.TrimSuffix(filename, filepath.Ext(filename)), info.Size())
+			}
+			return nil
+		}
+		have.AddFile(entry.Title, info.Size())
+		if entry.GUID != "" {
+			haveGUIDs[entry.GUID] = true
 		}
-		title := getFirstValue(meta, titleID)
-		have[title] = true
 
 		return nil
 	}
@@ -177,26 +570,113 @@ func (s *Show) filter(specificEp string) error {
 		Log("\nLooking for specified episode")
 		if ep, found := findSpecific(s.Episodes, specificEp); found {
 			s.Episodes = []Episode{ep}
+			if reason := Content.allowEpisode(&ep); reason != "" {
+				Log("Skipping", ep.Title, "-", reason)
+				s.Episodes = nil
+			}
 		} else {
 			s.Episodes = nil
 		}
 	} else {
 		Log("Building list of unsynced episodes")
 		// Get all the metadata titles of the episodes we already have.
+		progress = NewScanProgress()
 		if err := filepath.Walk(s.Dir, walkFunc); err != nil {
 			return err
 		}
+		progress.Finish()
+		if err := TagCache.Save(); err != nil {
+			Log("Error saving tag index:", err)
+		}
 
-		// Compare that list to what's available to find the episodes we need to download.
+		// Episodes of unknown kind are saved with the extension of the file they were saved as before, if any.
+		for i := range s.Episodes {
+			s.Episodes[i].findExt(audioFiles)
+		}
+
+		// Episodes that share a title with another episode in the feed can't be told apart by title alone.
+		feedTitles := make(map[string]int)
+		for _, episode := range s.Episodes {
+			feedTitles[normalizeTitle(episode.Title)]++
+		}
+
+		// Compare that list to what's available to find the episodes we need to download. The profile's content rules
+		// are checked first, and the reasons are counted so they can be logged together.
+		blocked := make(map[string]int)
+		unselected := 0
 		want := []Episode{}
 		for _, episode := range s.Episodes {
-			if _, ok := have[episode.Title]; !ok {
-				Debug("Need", episode.Title)
+			if reason := Content.allowEpisode(&episode); reason != "" {
+				s.debug("Skipping", episode.Title, "-", reason)
+				blocked[reason]++
+				continue
+			}
+			if !selector.Selects(episode.filterValues(s.Title)) {
+				s.debug("Skipping", episode.Title, "- it doesn't match the filter")
+				unselected++
+				continue
+			}
+
+			if !episode.Enclosure.isAudio() {
+				if !haveFiles[filepath.Base(episode.buildFilename(""))] {
+					s.debug("Need", episode.Title)
+					want = append(want, episode)
+				}
+				continue
+			}
+
+			if s.checkUpdated(&episode) {
+				want = append(want, episode)
+				continue
+			}
+
+			if episode.GUID != "" && haveGUIDs[episode.GUID] {
+				continue
+			}
+
+			match, size, exact, ok := have.Lookup(episode.Title)
+			if !ok {
+				// Episodes that were played or archived and then removed aren't needed again.
+				if es, found := StateDB.Lookup(s.URL.String(), &episode); found && (!es.Played.IsZero() || es.Archive != "") {
+					s.debug("Skipping", episode.Title, "- already played or archived")
+					continue
+				}
+				s.debug("Need", episode.Title)
+				want = append(want, episode)
+				continue
+			}
+
+			// We think we have this one, but let's make sure we're not fooling ourselves.
+			reason := ""
+			if !exact {
+				reason = fmt.Sprintf("it is only a close match for the local episode %q", match)
+			} else if feedTitles[match] > 1 {
+				reason = "more than one episode in the feed has this title"
+			} else if feedSize := int64(episode.FeedSize()); feedSize > 0 && size > 0 && size < feedSize/2 {
+				reason = fmt.Sprintf("the local file is much smaller than the feed says (%v of %v)", Reduce(int(size)), Reduce(int(feedSize)))
+			}
+			if reason != "" && resolveConflict(&episode, reason, s.syncOptions().Conflicts) {
+				s.debug("Need", episode.Title)
 				want = append(want, episode)
 			}
 		}
 
+		reasons := make([]string, 0, len(blocked))
+		for reason := range blocked {
+			reasons = append(reasons, reason)
+		}
+		sort.Strings(reasons)
+		for _, reason := range reasons {
+			Log("Skipping", blocked[reason], "episodes by the profile's content rules:", reason)
+		}
+		if unselected > 0 {
+			Log("Skipping", unselected, "episodes that don't match the filter")
+		}
 		s.Episodes = want
+
+		if err := StateDB.Save(); err != nil {
+			Log("Error saving state:", err)
+		}
 	}
 
 	return nil
@@ -270,16 +750,138 @@ func getFirstValue(meta *Meta, id string) string {
 	return string(values[0])
 }
 
+// readFileMeta reads the metadata of the audio file at the path.
+func readFileMeta(path string) (*Meta, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// Build the metadata object so we can inspect the tag contents. (It's kept quiet so we don't spam print all the
+	// metadata frames. They'll still get written to the log.)
+	meta := &Meta{quiet: true}
+	if _, err := meta.ReadFrom(file); err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+
+	return meta, nil
+}
+
+// writeFileMeta replaces the metadata at the start of the file with the (changed) metadata that was read from it.
+func writeFileMeta(path string, meta *Meta) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() < int64(meta.Len()) {
+		return fmt.Errorf("file is shorter than its metadata")
+	}
+
+	tag := meta.Build()
+	if tag == nil {
+		return fmt.Errorf("error building metadata")
+	}
+
+	// The audio that follows the old metadata is copied over after the new metadata a piece at a time, since episodes
+	// can run to hundreds of megabytes.
+	tmp := path + ".tmp"
+	if err := writeRetagged(tmp, tag, file, int64(meta.Len()), info.Mode().Perm()); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	// The file keeps its mode and modification time, so that players and the library scans that go by them don't see a
+	// new episode. The tag index can't tell the file changed from those, so it's told directly.
+	if err := os.Chmod(tmp, info.Mode().Perm()); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Chtimes(tmp, time.Now(), info.ModTime()); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	TagCache.Forget(path)
+
+	return nil
+}
+
+// writeRetagged writes the tag to a new file at the path, followed by everything in the original file past the offset.
+func writeRetagged(path string, tag []byte, original *os.File, offset int64, perm os.FileMode) error {
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := out.Write(tag); err != nil {
+		out.Close()
+		return err
+	}
+	if _, err := original.Seek(offset, io.SeekStart); err != nil {
+		out.Close()
+		return err
+	}
+	if _, err := io.Copy(out, original); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+// v22IDs maps ID3v2.3/v2.4 frame IDs to their ID3v2.2 equivalents.
+var v22IDs = map[string]string{
+	"APIC": "PIC",
+	"COMM": "COM",
+	"TALB": "TAL",
+	"TCON": "TCO",
+	"TDRC": "TYE",
+	"TIT2": "TT2",
+	"TLEN": "TLE",
+	"TPE1": "TP1",
+	"TPE2": "TP2",
+	"TPOS": "TPA",
+	"TRCK": "TRK",
+	"TYER": "TYE",
+}
+
+// getTag gets the first value for the given ID3v2.3/v2.4 frame ID, translating the ID for ID3v2.2 metadata.
+func getTag(meta *Meta, id string) string {
+	if meta.Version() == 2 {
+		if v22, ok := v22IDs[id]; ok {
+			id = v22
+		}
+	}
+
+	return getFirstValue(meta, id)
+}
+
 // isAudio determines if the provided file is an audio file or not.
 func isAudio(filename string) bool {
-	switch filepath.Ext(filename) {
+	switch strings.ToLower(filepath.Ext(filename)) {
 	case ".aac":
 		return true
+	case ".aiff":
+		return true
+	case ".flac":
+		return true
+	case ".m4a", ".m4b":
+		return true
 	case ".midi":
 		return true
 	case ".mp3":
 		return true
-	case ".oga":
+	case ".oga", ".ogg":
 		return true
 	case ".opus":
 		return true
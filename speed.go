@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/url"
+	"time"
+)
+
+// minSlowCheck is how long a download has to take before its speed is judged. Small files finish too quickly for their
+// average speed to mean much.
+var minSlowCheck = 10 * time.Second
+
+// SlowDownload describes an episode that downloaded slower than the speed given with -slow-speed.
+type SlowDownload struct {
+	Show    string  `json:"show"`
+	Feed    string  `json:"feed,omitempty"`
+	Episode string  `json:"episode"`
+	Host    string  `json:"host"`
+	Size    int     `json:"size"`
+	Seconds float64 `json:"seconds"`
+	Speed   int     `json:"speed"` // average bytes per second
+}
+
+// speed returns the episode's average download speed in bytes per second, or 0 if it hasn't been downloaded.
+func (e *Episode) speed() int {
+	if e == nil || e.elapsed <= 0 {
+		return 0
+	}
+
+	return int(float64(e.size) / e.elapsed.Seconds())
+}
+
+// checkSpeed warns about the episode if it downloaded slower than the speed given with -slow-speed, and adds it to the
+// show's list of slow downloads for the summary. A sudden drop in speed usually means that the host is throttling us.
+func (s *Show) checkSpeed(episode *Episode) {
+	if SlowSpeed <= 0 || episode.elapsed < minSlowCheck {
+		return
+	}
+
+	speed := episode.speed()
+	if speed >= SlowSpeed {
+		return
+	}
+
+	host := ""
+	if u, err := url.Parse(episode.Enclosure.URL); err == nil {
+		host = u.Host
+	}
+	Log("WARNING: Average speed of", Reduce(speed)+"/s is below", Reduce(SlowSpeed)+"/s, the host", host, "might be throttling downloads")
+
+	s.Slow = append(s.Slow, SlowDownload{
+		Show:    s.Title,
+		Episode: episode.Title,
+		Host:    host,
+		Size:    episode.size,
+		Seconds: episode.elapsed.Round(time.Second).Seconds(),
+		Speed:   speed,
+	})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/snhilde/getcast/internal/feedtest"
+)
+
+// Test that an episode served slower than -slow-speed is listed as a slow download with its host, and that nothing is
+// listed when the threshold is below the download speed or turned off.
+func TestCheckSpeed(t *testing.T) {
+	feed := feedtest.Generate("Slow Show", 1, 20000)
+	audio := feedtest.MP3(feed.Episodes[0].Tag, feed.Episodes[0].Size)
+
+	// The episode trickles out in small chunks, as it would from a host throttling downloads.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/feed.xml" {
+			w.Header().Set("Content-Type", "application/rss+xml")
+			w.Write(feed.RSS("http://" + r.Host))
+			return
+		}
+
+		w.Header().Set("Content-Type", "audio/mpeg")
+		w.Header().Set("Content-Length", strconv.Itoa(len(audio)))
+		for reader := bytes.NewReader(audio); reader.Len() > 0; {
+			chunk := make([]byte, 2000)
+			n, _ := reader.Read(chunk)
+			w.Write(chunk[:n])
+			w.(http.Flusher).Flush()
+			time.Sleep(30 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+	u, err := url.Parse(server.URL + "/feed.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpState, tmpCache, tmpArtwork := StateDB, TagCache, ArtworkMode
+	tmpSpeed, tmpCheck := SlowSpeed, minSlowCheck
+	defer func() {
+		StateDB, TagCache, ArtworkMode = tmpState, tmpCache, tmpArtwork
+		SlowSpeed, minSlowCheck = tmpSpeed, tmpCheck
+	}()
+	StateDB, TagCache, ArtworkMode = nil, nil, ArtworkNone
+	minSlowCheck = 100 * time.Millisecond
+
+	tests := []struct {
+		speed int
+		slow  bool
+	}{
+		{1 << 30, true},
+		{1 << 10, false},
+		{0, false},
+	}
+
+	for _, test := range tests {
+		dir, err := ioutil.TempDir("", "getcast-speed")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+
+		SlowSpeed = test.speed
+		show := &Show{URL: u}
+		if good, bad, err := show.Sync(dir, ""); err != nil || good != 1 || bad != 0 {
+			t.Fatal("Sync - Want: 1 Have:", good, bad, err)
+		}
+
+		if !test.slow {
+			if len(show.Slow) != 0 {
+				t.Error(test.speed, "- Download was reported as slow:", show.Slow)
+			}
+			continue
+		}
+
+		if len(show.Slow) != 1 {
+			t.Error(test.speed, "- Incorrect number of slow downloads - Want: 1 Have:", len(show.Slow))
+			continue
+		}
+		slow := show.Slow[0]
+		if slow.Show != "Slow Show" || slow.Episode != "Episode 1" || slow.Host != u.Host || slow.Size != len(audio) {
+			t.Error(test.speed, "- Incorrect slow download:", slow)
+		}
+		if slow.Speed <= 0 || slow.Speed >= test.speed {
+			t.Error(test.speed, "- Incorrect speed - Want: below threshold Have:", slow.Speed)
+		}
+	}
+}
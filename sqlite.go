@@ -0,0 +1,377 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"strings"
+)
+
+// sqliteDB is a minimal read-only reader for SQLite 3 database files. It reads just enough of the format to list the
+// rows of a table, which is all we need to migrate other podcatchers' databases without pulling in a database driver.
+// Only UTF-8 databases are supported, and anything still in a write-ahead log (-wal file) isn't seen.
+type sqliteDB struct {
+	data     []byte
+	pageSize int
+	usable   int // page size minus the bytes reserved at the end of each page
+}
+
+// sqliteMaxDepth is the deepest b-tree we'll follow, which keeps a corrupt file from sending us around in circles.
+const sqliteMaxDepth = 32
+
+// openSQLite reads the database file into memory.
+func openSQLite(path string) (*sqliteDB, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < 100 || string(data[:16]) != "SQLite format 3\x00" {
+		return nil, fmt.Errorf("%v is not an SQLite database", path)
+	}
+
+	pageSize := int(binary.BigEndian.Uint16(data[16:18]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+	if pageSize < 512 || len(data) < pageSize {
+		return nil, fmt.Errorf("invalid page size in %v", path)
+	}
+	if encoding := binary.BigEndian.Uint32(data[56:60]); encoding > 1 {
+		return nil, fmt.Errorf("unsupported text encoding in %v", path)
+	}
+
+	return &sqliteDB{data: data, pageSize: pageSize, usable: pageSize - int(data[20])}, nil
+}
+
+// page returns the page with the number, counting from 1.
+func (db *sqliteDB) page(n uint32) ([]byte, error) {
+	start := (int(n) - 1) * db.pageSize
+	if n == 0 || start+db.pageSize > len(db.data) {
+		return nil, fmt.Errorf("invalid page number: %v", n)
+	}
+
+	return db.data[start : start+db.pageSize], nil
+}
+
+// Table returns the rows of the table, each keyed by column name.
+func (db *sqliteDB) Table(name string) ([]map[string]interface{}, error) {
+	// The schema table lists every table as: type, name, tbl_name, rootpage, sql.
+	var root int64
+	var sql string
+	err := db.scan(1, 0, func(rowid int64, values []interface{}) error {
+		if len(values) >= 5 && sqlString(values[0]) == "table" && strings.EqualFold(sqlString(values[1]), name) {
+			root = sqlInt(values[3])
+			sql = sqlString(values[4])
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if root <= 0 {
+		return nil, fmt.Errorf("no table named %v", name)
+	}
+
+	columns, rowids := sqliteColumns(sql)
+	var rows []map[string]interface{}
+	err = db.scan(uint32(root), 0, func(rowid int64, values []interface{}) error {
+		row := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			var value interface{}
+			if i < len(values) {
+				value = values[i]
+			}
+			// A column that's an alias for the row ID is stored as NULL.
+			if value == nil && rowids[i] {
+				value = rowid
+			}
+			row[column] = value
+		}
+		rows = append(rows, row)
+		return nil
+	})
+
+	return rows, err
+}
+
+// scan calls fn for every row in the table b-tree rooted at the page.
+func (db *sqliteDB) scan(n uint32, depth int, fn func(rowid int64, values []interface{}) error) error {
+	if depth > sqliteMaxDepth {
+		return fmt.Errorf("database tree is too deep")
+	}
+
+	page, err := db.page(n)
+	if err != nil {
+		return err
+	}
+
+	// The first page starts with the file header.
+	header := 0
+	if n == 1 {
+		header = 100
+	}
+	if header+12 > len(page) {
+		return fmt.Errorf("invalid page %v", n)
+	}
+	cells := int(binary.BigEndian.Uint16(page[header+3:]))
+
+	switch page[header] {
+	case 0x0D: // table leaf
+		pointers := header + 8
+		for i := 0; i < cells; i++ {
+			if pointers+2*i+2 > len(page) {
+				return fmt.Errorf("invalid page %v", n)
+			}
+			offset := int(binary.BigEndian.Uint16(page[pointers+2*i:]))
+			if offset >= len(page) {
+				return fmt.Errorf("invalid cell in page %v", n)
+			}
+
+			size, k := sqliteVarint(page[offset:])
+			offset += k
+			rowid, k := sqliteVarint(page[offset:])
+			offset += k
+
+			payload, err := db.payload(page, offset, int(size))
+			if err != nil {
+				return err
+			}
+			values, err := sqliteRecord(payload)
+			if err != nil {
+				return err
+			}
+			if err := fn(int64(rowid), values); err != nil {
+				return err
+			}
+		}
+
+	case 0x05: // table interior
+		pointers := header + 12
+		for i := 0; i < cells; i++ {
+			if pointers+2*i+2 > len(page) {
+				return fmt.Errorf("invalid page %v", n)
+			}
+			offset := int(binary.BigEndian.Uint16(page[pointers+2*i:]))
+			if offset+4 > len(page) {
+				return fmt.Errorf("invalid cell in page %v", n)
+			}
+			if err := db.scan(binary.BigEndian.Uint32(page[offset:]), depth+1, fn); err != nil {
+				return err
+			}
+		}
+		return db.scan(binary.BigEndian.Uint32(page[header+8:]), depth+1, fn)
+
+	default:
+		return fmt.Errorf("page %v is not part of a table", n)
+	}
+
+	return nil
+}
+
+// payload reads the cell's payload of the size, starting at the offset in the page and continuing onto overflow pages
+// if it doesn't fit.
+func (db *sqliteDB) payload(page []byte, offset int, size int) ([]byte, error) {
+	// These are the limits on how much of the payload is kept on the page, from the file format.
+	u := db.usable
+	local := size
+	if max := u - 35; size > max {
+		min := ((u-12)*32)/255 - 23
+		local = min + (size-min)%(u-4)
+		if local > max {
+			local = min
+		}
+	}
+
+	if size < 0 || offset+local > len(page) {
+		return nil, fmt.Errorf("invalid cell size")
+	}
+	data := append([]byte{}, page[offset:offset+local]...)
+	if local == size {
+		return data, nil
+	}
+
+	if offset+local+4 > len(page) {
+		return nil, fmt.Errorf("invalid cell size")
+	}
+	next := binary.BigEndian.Uint32(page[offset+local:])
+	for i := 0; len(data) < size; i++ {
+		if next == 0 || i > len(db.data)/db.pageSize {
+			return nil, fmt.Errorf("missing overflow page")
+		}
+		overflow, err := db.page(next)
+		if err != nil {
+			return nil, err
+		}
+
+		take := size - len(data)
+		if take > u-4 {
+			take = u - 4
+		}
+		data = append(data, overflow[4:4+take]...)
+		next = binary.BigEndian.Uint32(overflow)
+	}
+
+	return data, nil
+}
+
+// sqliteVarint decodes the variable-length integer at the start of the data, returning it and its length in bytes.
+func sqliteVarint(data []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < 9 && i < len(data); i++ {
+		if i == 8 {
+			return v<<8 | uint64(data[i]), 9
+		}
+		v = v<<7 | uint64(data[i]&0x7F)
+		if data[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+
+	return v, len(data)
+}
+
+// sqliteRecord decodes the values in a row's record. Integers are returned as int64, reals as float64, text as string,
+// blobs as []byte, and NULL as nil.
+func sqliteRecord(data []byte) ([]interface{}, error) {
+	headerSize, pos := sqliteVarint(data)
+	if int(headerSize) > len(data) {
+		return nil, fmt.Errorf("invalid record")
+	}
+
+	var types []uint64
+	for pos < int(headerSize) {
+		t, k := sqliteVarint(data[pos:])
+		types = append(types, t)
+		pos += k
+	}
+
+	var values []interface{}
+	body := data[headerSize:]
+	for _, t := range types {
+		var size int
+		switch {
+		case t <= 4:
+			size = int(t)
+		case t == 5:
+			size = 6
+		case t == 6, t == 7:
+			size = 8
+		case t >= 12:
+			size = int(t-12) / 2
+		}
+		if size > len(body) {
+			return nil, fmt.Errorf("invalid record")
+		}
+		field := body[:size]
+		body = body[size:]
+
+		switch {
+		case t == 0:
+			values = append(values, nil)
+		case t <= 6:
+			// Integers are big-endian two's complement of 1, 2, 3, 4, 6, or 8 bytes.
+			var v int64
+			for _, b := range field {
+				v = v<<8 | int64(b)
+			}
+			shift := uint(64 - 8*size)
+			values = append(values, v<<shift>>shift)
+		case t == 7:
+			values = append(values, math.Float64frombits(binary.BigEndian.Uint64(field)))
+		case t == 8:
+			values = append(values, int64(0))
+		case t == 9:
+			values = append(values, int64(1))
+		case t >= 12 && t%2 == 0:
+			values = append(values, append([]byte{}, field...))
+		case t >= 13:
+			values = append(values, string(field))
+		default:
+			return nil, fmt.Errorf("invalid record")
+		}
+	}
+
+	return values, nil
+}
+
+// sqliteColumns returns the names of the columns in a CREATE TABLE statement, along with which of them are aliases for
+// the row ID.
+func sqliteColumns(sql string) ([]string, []bool) {
+	start := strings.IndexByte(sql, '(')
+	end := strings.LastIndexByte(sql, ')')
+	if start < 0 || end < start {
+		return nil, nil
+	}
+
+	// Split the definitions on the commas that aren't inside parentheses or quotes.
+	var defs []string
+	depth := 0
+	var quote rune
+	last := start + 1
+	for i, r := range sql[start+1 : end] {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'' || r == '`' || r == '[':
+			quote = r
+			if r == '[' {
+				quote = ']'
+			}
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+		case r == ',' && depth == 0:
+			defs = append(defs, sql[last:start+1+i])
+			last = start + 2 + i
+		}
+	}
+	defs = append(defs, sql[last:end])
+
+	var columns []string
+	var rowids []bool
+	for _, def := range defs {
+		fields := strings.Fields(def)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN":
+			continue
+		}
+
+		columns = append(columns, strings.Trim(fields[0], "\"'`[]"))
+		upper := strings.ToUpper(strings.Join(fields, " "))
+		rowids = append(rowids, strings.Contains(upper, "INTEGER PRIMARY KEY"))
+	}
+
+	return columns, rowids
+}
+
+// sqlString returns the value as a string, or "" if it isn't text.
+func sqlString(v interface{}) string {
+	switch value := v.(type) {
+	case string:
+		return value
+	case []byte:
+		return string(value)
+	}
+
+	return ""
+}
+
+// sqlInt returns the value as an integer, or 0 if it isn't a number.
+func sqlInt(v interface{}) int64 {
+	switch value := v.(type) {
+	case int64:
+		return value
+	case float64:
+		return int64(value)
+	}
+
+	return 0
+}
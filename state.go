@@ -0,0 +1,562 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// State is the record of everything that has happened in a library: which episodes have been downloaded, where they
+// were saved, and which downloads failed. It is stored as JSON in the profile's data directory.
+//
+// More than one process can use the state at once, such as the daemon and a command run from the shell. Each one keeps
+// track of the records it has changed, and saving merges those changes into whatever the others have saved in the
+// meantime, with the state file locked while that happens. Update runs a change as a transaction.
+type State struct {
+	path  string
+	mutex sync.Mutex
+
+	dirty       map[string]map[string]bool // episode keys changed since the last save, by feed URL
+	dirtyShows  map[string]bool            // shows whose own fields changed since the last save
+	dirtyPaused bool                       // whether or not Paused changed since the last save
+	modTime     time.Time                  // modification time of the state file when it was last read or written
+	size        int64                      // size of the state file when it was last read or written
+	lock        *fileLock                  // lock on the state file, while a transaction holds it
+
+	Version int                   `json:"version"`          // version of the schema, see stateMigrations
+	Paused  bool                  `json:"paused,omitempty"` // whether or not all scheduled syncs are paused
+	Shows   map[string]*ShowState `json:"shows"`            // keyed by feed URL
+}
+
+// ShowState holds the record of an individual show.
+type ShowState struct {
+	Title    string                   `json:"title"`
+	Paused   bool                     `json:"paused,omitempty"` // whether or not scheduled syncs skip this show
+	Episodes map[string]*EpisodeState `json:"episodes"`         // keyed by GUID, or title if the episode has no GUID
+}
+
+// EpisodeState holds the record of an individual episode.
+type EpisodeState struct {
+	Title        string    `json:"title"`
+	GUID         string    `json:"guid,omitempty"`
+	Season       string    `json:"season,omitempty"`
+	Number       string    `json:"number,omitempty"`
+	Published    time.Time `json:"published,omitempty"`     // publish date from the RSS feed
+	Duration     string    `json:"duration,omitempty"`      // duration from the RSS feed
+	Summary      string    `json:"summary,omitempty"`       // start of the show notes, for the show's index
+	People       []Person  `json:"people,omitempty"`        // hosts, guests, and others credited in the feed
+	URL          string    `json:"url,omitempty"`           // enclosure URL the episode was downloaded from
+	Path         string    `json:"path,omitempty"`          // location of the file on disk
+	Size         int       `json:"size,omitempty"`          // number of bytes received
+	ServerSize   int       `json:"server_size,omitempty"`   // size reported by the server's Content-Length
+	FeedSize     int       `json:"feed_size,omitempty"`     // size reported by the RSS feed
+	LengthPolicy string    `json:"length_policy,omitempty"` // policy used to validate the size
+	Downloaded   time.Time `json:"downloaded,omitempty"`    // time of the last successful download
+	Played       time.Time `json:"played,omitempty"`        // time the episode was played, if it has been
+	Position     int       `json:"position,omitempty"`      // seconds into the episode where playback left off
+	Bookmarked   time.Time `json:"bookmarked,omitempty"`    // time the position was last changed
+	Archive      string    `json:"archive,omitempty"`       // archive the episode was moved into, if it was
+	Failures     int       `json:"failures,omitempty"`      // number of failed syncs
+	LastError    string    `json:"last_error,omitempty"`    // error from the last failed sync
+	LastFailure  time.Time `json:"last_failure,omitempty"`  // time of the last failed sync
+
+	Updated       time.Time `json:"updated,omitempty"`        // time the publisher was found to have changed the episode since its download
+	UpdatedFields []string  `json:"updated_fields,omitempty"` // what the publisher changed
+}
+
+// StatePath returns the location of the active profile's state file.
+func StatePath() string {
+	dir := ActiveProfile.DataDir()
+	if dir == "" {
+		return ""
+	}
+
+	return filepath.Join(dir, "state.json")
+}
+
+// stateVersion is the current version of the state's schema. States saved with an older version are migrated when
+// they're loaded, and states saved with a newer version are refused rather than risk losing what they hold.
+const stateVersion = 1
+
+// stateMigrations bring a state up to the next version of the schema, in order: stateMigrations[0] migrates a version 0
+// state to version 1, and so on.
+var stateMigrations = []func(s *State){
+	// Version 0 didn't always give shows an episode map, such as for a show that was paused before its first sync.
+	func(s *State) {
+		for _, show := range s.Shows {
+			if show.Episodes == nil {
+				show.Episodes = make(map[string]*EpisodeState)
+			}
+		}
+	},
+}
+
+// LoadState reads the state file at the provided path. If the file does not exist yet, an empty state is returned. A
+// state saved with an older version of the schema is migrated, and the original is kept next to it, e.g.
+// "state.json.v0".
+func LoadState(path string) (*State, error) {
+	if path == "" {
+		return nil, fmt.Errorf("missing state path")
+	}
+
+	s, data, err := readState(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if version := s.Version; version < stateVersion && data != nil {
+		s.migrate()
+		backup := fmt.Sprintf("%s.v%d", path, version)
+		if err := ioutil.WriteFile(backup, data, 0600); err != nil {
+			return nil, fmt.Errorf("error saving copy of state before migrating: %v", err)
+		}
+		if err := s.Save(); err != nil {
+			return nil, fmt.Errorf("error saving migrated state: %v", err)
+		}
+		Log("Migrated state from version", version, "to", stateVersion, "(original kept at "+backup+")")
+	}
+
+	Debug("Loaded state for", len(s.Shows), "shows from", path)
+	return s, nil
+}
+
+// errLocked is returned when another process has held a lock for too long.
+var errLocked = errors.New("locked by another process")
+
+// stateLockWait is how long to wait for another process to let go of the state when opening it, before giving up on it.
+const stateLockWait = 30 * time.Second
+
+// OpenState loads the state file at the path like LoadState, after first making sure that no other process is stuck
+// holding it, which would otherwise hold up every save for as long as it's stuck.
+func OpenState(path string) (*State, error) {
+	if path != "" {
+		lock, err := tryLockFile(path+".lock", stateLockWait)
+		if err != nil {
+			return nil, fmt.Errorf("state is unavailable: %v", err)
+		}
+		lock.Unlock()
+	}
+
+	return LoadState(path)
+}
+
+// readState reads and parses the state file at the path, without migrating it. This also returns the file's contents,
+// or nil if it doesn't exist yet.
+func readState(path string) (*State, []byte, error) {
+	s := &State{path: path, Version: stateVersion, Shows: make(map[string]*ShowState)}
+
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		Debug("No state found at", path)
+		return s, nil, nil
+	} else if err != nil {
+		return nil, nil, fmt.Errorf("error reading state: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error reading state: %v", err)
+	}
+
+	// A state from before the schema was versioned doesn't have a version.
+	s.Version = 0
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, nil, fmt.Errorf("error parsing state: %v", err)
+	}
+	if s.Version > stateVersion {
+		return nil, nil, fmt.Errorf("state was saved by a newer version of getcast (schema version %v)", s.Version)
+	}
+	if s.Shows == nil {
+		s.Shows = make(map[string]*ShowState)
+	}
+	s.modTime, s.size = info.ModTime(), info.Size()
+
+	return s, data, nil
+}
+
+// migrate brings the state up to the current version of the schema.
+func (s *State) migrate() {
+	for ; s.Version < stateVersion; s.Version++ {
+		stateMigrations[s.Version](s)
+	}
+}
+
+// Save writes the changes made to the state out to disk, merging them into any changes that other processes have saved
+// since this state was read. The state is first written to a temporary file and then moved into place so that a crash
+// mid-write doesn't leave a corrupt state behind.
+func (s *State) Save() error {
+	if s == nil {
+		return nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.lock == nil {
+		lock, err := lockFile(s.path + ".lock")
+		if err != nil {
+			return err
+		}
+		defer lock.Unlock()
+	}
+
+	if err := s.refresh(); err != nil {
+		return err
+	}
+
+	return s.write()
+}
+
+// Refresh brings the state up to date with the changes that other processes have saved, keeping this process's changes
+// that haven't been saved yet.
+func (s *State) Refresh() error {
+	if s == nil {
+		return nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.lock == nil {
+		lock, err := lockFile(s.path + ".lock")
+		if err != nil {
+			return err
+		}
+		defer lock.Unlock()
+	}
+
+	return s.refresh()
+}
+
+// Update runs fn as a transaction. The state file stays locked against other processes from the time the state is
+// brought up to date until fn's changes are saved, so that fn's decisions can't be based on a stale state. If fn returns
+// an error, its changes are rolled back and nothing is saved. fn can use the state's other methods, including Save.
+func (s *State) Update(fn func() error) error {
+	if s == nil {
+		return fmt.Errorf("no state available")
+	}
+
+	lock, err := lockFile(s.path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	s.mutex.Lock()
+	s.lock = lock
+	err = s.refresh()
+	snapshot, snapshotErr := s.snapshot()
+	s.mutex.Unlock()
+	defer func() {
+		s.mutex.Lock()
+		s.lock = nil
+		s.mutex.Unlock()
+	}()
+	if err != nil {
+		return err
+	} else if snapshotErr != nil {
+		return snapshotErr
+	}
+
+	if err := fn(); err != nil {
+		s.mutex.Lock()
+		s.rollback(snapshot)
+		s.mutex.Unlock()
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.write()
+}
+
+// stateSnapshot is a copy of the state, for rolling back a transaction.
+type stateSnapshot struct {
+	data        []byte
+	dirty       map[string]map[string]bool
+	dirtyShows  map[string]bool
+	dirtyPaused bool
+}
+
+// snapshot copies the state. The caller must hold the lock.
+func (s *State) snapshot() (stateSnapshot, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return stateSnapshot{}, err
+	}
+
+	snapshot := stateSnapshot{data: data, dirty: make(map[string]map[string]bool), dirtyShows: make(map[string]bool), dirtyPaused: s.dirtyPaused}
+	for feedURL, keys := range s.dirty {
+		snapshot.dirty[feedURL] = make(map[string]bool)
+		for key := range keys {
+			snapshot.dirty[feedURL][key] = true
+		}
+	}
+	for feedURL := range s.dirtyShows {
+		snapshot.dirtyShows[feedURL] = true
+	}
+
+	return snapshot, nil
+}
+
+// rollback puts the state back the way it was when the snapshot was taken. The caller must hold the lock.
+func (s *State) rollback(snapshot stateSnapshot) {
+	restored := &State{}
+	if err := json.Unmarshal(snapshot.data, restored); err != nil {
+		Log("Error rolling back state:", err)
+		return
+	}
+
+	s.Paused, s.Shows = restored.Paused, restored.Shows
+	s.dirty, s.dirtyShows, s.dirtyPaused = snapshot.dirty, snapshot.dirtyShows, snapshot.dirtyPaused
+}
+
+// refresh reads the state file again if another process has saved it since this state was read or written, and then
+// applies this state's unsaved changes on top of it. The caller must hold the lock and the file lock.
+func (s *State) refresh() error {
+	info, err := os.Stat(s.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("error reading state: %v", err)
+	}
+	if info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return nil
+	}
+
+	saved, _, err := readState(s.path)
+	if err != nil {
+		return err
+	}
+	saved.migrate()
+
+	for feedURL := range s.dirtyShows {
+		if show, ok := s.Shows[feedURL]; ok {
+			savedShow := saved.show(feedURL)
+			savedShow.Title, savedShow.Paused = show.Title, show.Paused
+		}
+	}
+	for feedURL, keys := range s.dirty {
+		show, ok := s.Shows[feedURL]
+		if !ok {
+			continue
+		}
+		savedShow := saved.show(feedURL)
+		for key := range keys {
+			if es, ok := show.Episodes[key]; ok {
+				savedShow.Episodes[key] = es
+			}
+		}
+	}
+	if s.dirtyPaused {
+		saved.Paused = s.Paused
+	}
+
+	Debug("Merged state with changes saved by another process")
+	s.Paused, s.Shows = saved.Paused, saved.Shows
+	s.modTime, s.size = saved.modTime, saved.size
+
+	return nil
+}
+
+// write saves the state to disk and clears the record of unsaved changes. The caller must hold the lock and the file
+// lock.
+func (s *State) write() error {
+	s.Version = stateVersion
+	data, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+
+	s.dirty, s.dirtyShows, s.dirtyPaused = nil, nil, false
+	if info, err := os.Stat(s.path); err == nil {
+		s.modTime, s.size = info.ModTime(), info.Size()
+	}
+
+	return nil
+}
+
+// show returns the record of the show with the feed URL, creating it if it doesn't exist. The caller must hold the
+// lock.
+func (s *State) show(feedURL string) *ShowState {
+	show, ok := s.Shows[feedURL]
+	if !ok {
+		show = &ShowState{Episodes: make(map[string]*EpisodeState)}
+		s.Shows[feedURL] = show
+	}
+
+	return show
+}
+
+// touch records that the episode with the key in the show with the feed URL changed. The caller must hold the lock.
+func (s *State) touch(feedURL string, key string) {
+	if s.dirty == nil {
+		s.dirty = make(map[string]map[string]bool)
+	}
+	if s.dirty[feedURL] == nil {
+		s.dirty[feedURL] = make(map[string]bool)
+	}
+	s.dirty[feedURL][key] = true
+}
+
+// touchShow records that the show's own fields changed. The caller must hold the lock.
+func (s *State) touchShow(feedURL string) {
+	if s.dirtyShows == nil {
+		s.dirtyShows = make(map[string]bool)
+	}
+	s.dirtyShows[feedURL] = true
+}
+
+// Episode returns the record of the episode in the show with the provided feed URL, creating it if it doesn't exist.
+// The caller must hold the lock.
+func (s *State) episode(feedURL string, showTitle string, e *Episode) *EpisodeState {
+	show := s.show(feedURL)
+	if show.Title != showTitle {
+		show.Title = showTitle
+		s.touchShow(feedURL)
+	}
+
+	key := e.GUID
+	if key == "" {
+		key = e.Title
+	}
+
+	es, ok := show.Episodes[key]
+	if !ok {
+		es = &EpisodeState{}
+		show.Episodes[key] = es
+	}
+	s.touch(feedURL, key)
+	es.Title = e.Title
+	es.GUID = e.GUID
+
+	return es
+}
+
+// RecordDownload records a successful download of the episode.
+func (s *State) RecordDownload(feedURL string, showTitle string, e *Episode) {
+	if s == nil || e == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	es := s.episode(feedURL, showTitle, e)
+	es.Season = e.Season
+	es.Number = e.Number
+	es.Published = parseDate(e.Date)
+	es.Duration = e.Duration
+	es.Summary = e.summary()
+	es.People = e.People()
+	es.URL = e.Enclosure.URL
+	es.Path = e.path
+	if abs, err := filepath.Abs(e.path); err == nil {
+		es.Path = abs
+	}
+	es.Size = e.size
+	es.ServerSize = e.serverSize
+	es.FeedSize = e.FeedSize()
+	es.LengthPolicy = e.policy.String()
+	es.Downloaded = time.Now()
+	es.LastError = ""
+	es.Updated = time.Time{}
+	es.UpdatedFields = nil
+}
+
+// RecordFailure records a failed download of the episode.
+func (s *State) RecordFailure(feedURL string, showTitle string, e *Episode, err error) {
+	if s == nil || e == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	es := s.episode(feedURL, showTitle, e)
+	es.Failures++
+	es.LastFailure = time.Now()
+	if err != nil {
+		es.LastError = err.Error()
+	}
+}
+
+// ShowTitle returns the title of the show with the feed URL from the last time it was synced, or "" if it isn't known.
+func (s *State) ShowTitle(feedURL string) string {
+	if s == nil {
+		return ""
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if show, ok := s.Shows[feedURL]; ok {
+		return show.Title
+	}
+
+	return ""
+}
+
+// Lookup returns a copy of the record of the episode of the show with the provided feed URL, if there is one.
+func (s *State) Lookup(feedURL string, e *Episode) (EpisodeState, bool) {
+	if s == nil || e == nil {
+		return EpisodeState{}, false
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	show, ok := s.Shows[feedURL]
+	if !ok {
+		return EpisodeState{}, false
+	}
+
+	key := e.GUID
+	if key == "" {
+		key = e.Title
+	}
+	es, ok := show.Episodes[key]
+	if !ok {
+		return EpisodeState{}, false
+	}
+
+	return *es, true
+}
+
+// ByPath returns a copy of the record of every downloaded episode, keyed by the episode's location on disk.
+func (s *State) ByPath() map[string]EpisodeState {
+	episodes := make(map[string]EpisodeState)
+	if s == nil {
+		return episodes
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for _, show := range s.Shows {
+		for _, es := range show.Episodes {
+			if es.Path != "" {
+				episodes[es.Path] = *es
+			}
+		}
+	}
+
+	return episodes
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Test that two processes using the same state file keep each other's changes when they save.
+func TestStateMerge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state.json")
+	one, _ := LoadState(path)
+	two, _ := LoadState(path)
+
+	e := Episode{Title: "Episode one", GUID: "guid-one"}
+	e.path = filepath.Join(dir, "one.mp3")
+	one.RecordDownload("https://example.com/feed", "Show", &e)
+	if err := one.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	e = Episode{Title: "Episode two", GUID: "guid-two"}
+	e.path = filepath.Join(dir, "two.mp3")
+	two.RecordDownload("https://example.com/feed", "Show", &e)
+	if err := two.SetPaused("", nil, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := two.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first one picks up the second one's changes without losing its own.
+	if err := one.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if have := len(one.ByPath()); have != 2 {
+		t.Error("Incorrect number of episodes - Want: 2 Have:", have)
+	}
+	if !one.IsPaused("https://example.com/feed") {
+		t.Error("Pause was lost")
+	}
+
+	saved, _ := LoadState(path)
+	if have := len(saved.ByPath()); have != 2 {
+		t.Error("Incorrect number of saved episodes - Want: 2 Have:", have)
+	}
+}
+
+// Test that a transaction's changes are rolled back when it fails and saved when it succeeds.
+func TestStateUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state.json")
+	state, _ := LoadState(path)
+
+	err = state.Update(func() error {
+		state.SetPaused("", nil, true)
+		return fmt.Errorf("failed")
+	})
+	if err == nil {
+		t.Error("Transaction's error was not returned")
+	}
+	if state.IsPaused("") {
+		t.Error("Failed transaction was not rolled back")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Failed transaction was saved")
+	}
+
+	err = state.Update(func() error {
+		return state.SetPaused("", nil, true)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved, _ := LoadState(path); !saved.IsPaused("") {
+		t.Error("Transaction was not saved")
+	}
+}
+
+// Test that an unversioned state is migrated and kept, that only the user can read either copy, and that a state from a
+// newer version is refused.
+func TestStateMigrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "state.json")
+	old := `{"shows": {"https://example.com/feed": {"title": "Show", "paused": true, "episodes": null}}}`
+	ioutil.WriteFile(path, []byte(old), 0644)
+
+	state, err := LoadState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Version != stateVersion {
+		t.Error("Incorrect version - Want:", stateVersion, "Have:", state.Version)
+	}
+	if state.Shows["https://example.com/feed"].Episodes == nil {
+		t.Error("Show's episodes were not migrated")
+	}
+	if have, err := ioutil.ReadFile(path + ".v0"); err != nil || string(have) != old {
+		t.Error("Original state was not kept - Want:", old, "Have:", string(have))
+	}
+	for _, name := range []string{path, path + ".v0"} {
+		if info, err := os.Stat(name); err != nil {
+			t.Error(err)
+		} else if info.Mode().Perm() != 0600 {
+			t.Error("Incorrect permissions for", filepath.Base(name), "- Want: 0600 Have:", info.Mode().Perm())
+		}
+	}
+
+	ioutil.WriteFile(path, []byte(fmt.Sprintf(`{"version": %d, "shows": {}}`, stateVersion+1)), 0644)
+	if _, err := LoadState(path); err == nil {
+		t.Error("State from a newer version was loaded")
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ShowStats holds the download statistics of an individual show.
+type ShowStats struct {
+	Title    string
+	URL      string
+	Episodes int            // number of episodes downloaded
+	Missing  int            // number of downloaded episodes no longer on disk
+	Bytes    int            // number of bytes on disk
+	Failures int            // number of failed syncs
+	PerMonth map[string]int // number of downloads per month (YYYY-MM)
+}
+
+// AverageSize returns the average size of the show's episodes on disk.
+func (s ShowStats) AverageSize() int {
+	onDisk := s.Episodes - s.Missing
+	if onDisk <= 0 {
+		return 0
+	}
+
+	return s.Bytes / onDisk
+}
+
+// Stats computes the download statistics of every show in the state DB, sorted by the number of bytes on disk (largest
+// first).
+func (s *State) Stats() []ShowStats {
+	if s == nil {
+		return nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	var stats []ShowStats
+	for u, show := range s.Shows {
+		st := ShowStats{Title: show.Title, URL: u, PerMonth: make(map[string]int)}
+		for _, es := range show.Episodes {
+			st.Failures += es.Failures
+			if es.Downloaded.IsZero() {
+				continue
+			}
+
+			st.Episodes++
+			st.PerMonth[es.Downloaded.Format("2006-01")]++
+			if info, err := os.Stat(es.Path); err == nil {
+				st.Bytes += int(info.Size())
+			} else {
+				st.Missing++
+			}
+		}
+		stats = append(stats, st)
+	}
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Bytes == stats[j].Bytes {
+			return stats[i].Title < stats[j].Title
+		}
+		return stats[i].Bytes > stats[j].Bytes
+	})
+
+	return stats
+}
+
+// runStats prints the download statistics of every show in the state DB.
+func runStats(state *State) error {
+	stats := state.Stats()
+	if len(stats) == 0 {
+		Log("No download history found")
+		return nil
+	}
+
+	total := ShowStats{}
+	for _, st := range stats {
+		Log(st.Title)
+		Log("  Feed:        ", st.URL)
+		Log("  Episodes:    ", st.Episodes)
+		if st.Missing > 0 {
+			Log("  Missing:     ", st.Missing, "episodes no longer on disk")
+		}
+		Log("  On disk:     ", Reduce(st.Bytes))
+		Log("  Average size:", Reduce(st.AverageSize()))
+		Log("  Failures:    ", st.Failures)
+
+		months := make([]string, 0, len(st.PerMonth))
+		for month := range st.PerMonth {
+			months = append(months, month)
+		}
+		sort.Strings(months)
+		for i, month := range months {
+			months[i] = fmt.Sprintf("%v: %v", month, st.PerMonth[month])
+		}
+		if len(months) > 0 {
+			Log("  Per month:   ", strings.Join(months, ", "))
+		}
+		Log("")
+
+		total.Episodes += st.Episodes
+		total.Missing += st.Missing
+		total.Bytes += st.Bytes
+		total.Failures += st.Failures
+	}
+
+	Log("Total:", len(stats), "shows,", total.Episodes, "episodes,", Reduce(total.Bytes), "on disk,", total.Failures, "failures")
+	return nil
+}
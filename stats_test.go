@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test that the statistics of each show count its downloads, missing files, bytes on disk, failures, and downloads per
+// month, and that the shows are sorted by the bytes on disk.
+func TestStats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast-stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := func(name string, size int) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	march := time.Date(2020, time.March, 10, 0, 0, 0, 0, time.UTC)
+	april := time.Date(2020, time.April, 2, 0, 0, 0, 0, time.UTC)
+
+	state := &State{Shows: map[string]*ShowState{
+		"https://example.com/small.xml": {Title: "Small", Episodes: map[string]*EpisodeState{
+			"1": {Path: file("small-1.mp3", 100), Downloaded: march},
+			"2": {Path: file("small-2.mp3", 300), Downloaded: april},
+			"3": {Path: filepath.Join(dir, "deleted.mp3"), Downloaded: april},
+			"4": {Failures: 2},
+		}},
+		"https://example.com/large.xml": {Title: "Large", Episodes: map[string]*EpisodeState{
+			"1": {Path: file("large-1.mp3", 1000), Downloaded: march, Failures: 1},
+		}},
+		"https://example.com/empty.xml": {Title: "Empty", Episodes: map[string]*EpisodeState{}},
+	}}
+
+	tests := []struct {
+		stats   ShowStats
+		average int
+	}{
+		{ShowStats{
+			Title: "Large", URL: "https://example.com/large.xml",
+			Episodes: 1, Bytes: 1000, Failures: 1, PerMonth: map[string]int{"2020-03": 1},
+		}, 1000},
+		{ShowStats{
+			Title: "Small", URL: "https://example.com/small.xml",
+			Episodes: 3, Missing: 1, Bytes: 400, Failures: 2, PerMonth: map[string]int{"2020-03": 1, "2020-04": 2},
+		}, 200},
+		{ShowStats{Title: "Empty", URL: "https://example.com/empty.xml", PerMonth: map[string]int{}}, 0},
+	}
+
+	stats := state.Stats()
+	if len(stats) != len(tests) {
+		t.Fatal("Incorrect number of shows - Want:", len(tests), "Have:", len(stats))
+	}
+	for i, test := range tests {
+		if !reflect.DeepEqual(stats[i], test.stats) {
+			t.Error("Incorrect stats - Want:", test.stats, "Have:", stats[i])
+		}
+		if average := stats[i].AverageSize(); average != test.average {
+			t.Error("Incorrect average size for", test.stats.Title, "- Want:", test.average, "Have:", average)
+		}
+	}
+
+	tmpLog := LogFile
+	defer func() { LogFile = tmpLog }()
+	LogFile, err = NewLogWriter(filepath.Join(dir, "getcast.log"), LogOptions{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer LogFile.Close()
+	if err := runStats(state); err != nil {
+		t.Error(err)
+	}
+	log, err := ioutil.ReadFile(filepath.Join(dir, "getcast.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	total := "Total: 3 shows, 4 episodes, " + Reduce(1400) + " on disk, 3 failures\n"
+	if !strings.HasSuffix(string(log), total) {
+		t.Error("Incorrect totals - Want:", total, "Have:", string(log))
+	}
+	if stats := (*State)(nil).Stats(); stats != nil {
+		t.Error("Stats without a state DB - Want: nil Have:", stats)
+	}
+	if err := runStats(nil); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+)
+
+// Failure describes an episode (or a whole show) that failed to sync.
+type Failure struct {
+	Show     string `json:"show"`
+	Feed     string `json:"feed,omitempty"`
+	Episode  string `json:"episode,omitempty"` // empty if the entire show failed
+	Attempts int    `json:"attempts,omitempty"`
+	Error    string `json:"error"`
+}
+
+// Summary is the outcome of a sync of one or more shows.
+type Summary struct {
+	Started     time.Time `json:"started"`
+	Finished    time.Time `json:"finished"`
+	Shows       int       `json:"shows"`
+	Downloaded  int       `json:"downloaded"`
+	Failed      int       `json:"failed"`
+	Interrupted bool      `json:"interrupted,omitempty"`
+	Failures    []Failure `json:"failures,omitempty"`
+
+	Slow    []SlowDownload `json:"slow,omitempty"`     // episodes that downloaded slower than -slow-speed
+	Skipped []Failure      `json:"skipped,omitempty"`  // shows left for later because their host kept failing
+	BadTags []BadTag       `json:"bad_tags,omitempty"` // episodes whose published tags had frames that couldn't be read
+}
+
+// NewSummary starts a new summary.
+func NewSummary() *Summary {
+	return &Summary{Started: time.Now()}
+}
+
+// Add adds the results of syncing the show to the summary. If the sync as a whole failed, err is the reason.
+func (s *Summary) Add(show *Show, downloaded int, err error) {
+	if s == nil || show == nil {
+		return
+	}
+
+	s.Shows++
+	s.Downloaded += downloaded
+	for _, failure := range show.Failures {
+		failure.Feed = show.URL.String()
+		s.Failures = append(s.Failures, failure)
+	}
+	s.Failed = len(s.Failures)
+	for _, slow := range show.Slow {
+		slow.Feed = show.URL.String()
+		s.Slow = append(s.Slow, slow)
+	}
+	for _, bad := range show.BadTags {
+		bad.Feed = show.URL.String()
+		s.BadTags = append(s.BadTags, bad)
+	}
+
+	if err == errInterrupted {
+		s.Interrupted = true
+	} else if err != nil {
+		s.AddError(show.Title, show.URL.String(), err)
+	}
+	s.Failed = len(s.Failures)
+}
+
+// AddError adds a show that failed to sync as a whole to the summary.
+func (s *Summary) AddError(title string, feed string, err error) {
+	if s == nil || err == nil {
+		return
+	}
+
+	if title == "" {
+		title = feed
+	}
+	if errors.Is(err, ErrCircuitOpen) {
+		s.Skipped = append(s.Skipped, Failure{Show: title, Feed: feed, Error: err.Error()})
+		return
+	}
+	s.Failures = append(s.Failures, Failure{Show: title, Feed: feed, Error: err.Error()})
+	s.Failed = len(s.Failures)
+}
+
+// Finish marks the end of the sync, prints the failures, and writes the summary to the path from -summary.
+func (s *Summary) Finish() {
+	if s == nil {
+		return
+	}
+
+	s.Finished = time.Now()
+	s.Print()
+
+	if SummaryPath != "" {
+		if err := s.Save(SummaryPath); err != nil {
+			Log("Error writing summary:", err)
+		}
+	}
+}
+
+// Print logs a block listing every failure, slow download, and unreadable tag, so that they don't get lost in the rest of
+// the output.
+func (s *Summary) Print() {
+	if s == nil {
+		return
+	}
+
+	if len(s.Slow) > 0 {
+		Log("")
+		Log("=== Slow Downloads ===")
+		for _, slow := range s.Slow {
+			Log(fmt.Sprintf("%v / %v: %v/s from %v (%v in %vs)", slow.Show, slow.Episode, Reduce(slow.Speed), slow.Host,
+				Reduce(slow.Size), slow.Seconds))
+		}
+	}
+
+	if len(s.BadTags) > 0 {
+		total := make(TagIssues)
+		Log("")
+		Log("=== Unreadable Tag Frames ===")
+		for _, bad := range s.BadTags {
+			Log(bad.Show + " / " + bad.Episode + ": " + bad.Issues.String())
+			total.Add(bad.Issues)
+		}
+		Log(fmt.Sprintf("%v frames skipped in %v episodes (%v)", total.Total(), len(s.BadTags), total))
+	}
+
+	if len(s.Skipped) > 0 {
+		Log("")
+		Log("=== Skipped Shows ===")
+		for _, skipped := range s.Skipped {
+			Log(skipped.Show + ": " + skipped.Error)
+		}
+	}
+
+	if len(s.Failures) == 0 {
+		return
+	}
+
+	Log("")
+	Log("=== Failures ===")
+	for _, failure := range s.Failures {
+		line := failure.Show
+		if failure.Episode != "" {
+			line += " / " + failure.Episode
+		}
+		if failure.Attempts > 0 {
+			line += fmt.Sprintf(" (%v attempts)", failure.Attempts)
+		}
+		Log(line + ": " + failure.Error)
+	}
+}
+
+// Save writes the summary as JSON to the path, or to stdout if the path is "-".
+func (s *Summary) Save(path string) error {
+	data, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if path == "-" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+
+	return ioutil.WriteFile(path, data, 0644)
+}
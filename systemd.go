@@ -0,0 +1,71 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// sdNotify sends the provided state (such as "READY=1") to the service manager. If we were not started by systemd with
+// Type=notify, then this does nothing.
+func sdNotify(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+
+	// A leading "@" signifies an abstract socket.
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// sdWatchdogInterval returns how often we should ping the service manager's watchdog, or 0 if the watchdog is not
+// enabled for this process. Per systemd's recommendation, this is half of the configured timeout.
+func sdWatchdogInterval() time.Duration {
+	usec, err := strconv.Atoi(os.Getenv("WATCHDOG_USEC"))
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		// The watchdog is meant for a different process.
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// lastProgress is when a sync last made progress (fetched a feed, started an episode, or received data), in Unix
+// nanoseconds.
+var lastProgress int64
+
+// noteProgress records that a sync is making progress, which keeps the service manager's watchdog from restarting us.
+func noteProgress() {
+	atomic.StoreInt64(&lastProgress, time.Now().UnixNano())
+}
+
+// sdWatchdogPing pings the service manager's watchdog, unless a sync is running and hasn't made any progress in the
+// length of the watchdog's timeout (twice the interval). A sync that's stuck then gets the daemon restarted, instead of
+// being hidden by pings that go out no matter what.
+func sdWatchdogPing(interval time.Duration, syncing bool) {
+	if syncing && time.Since(time.Unix(0, atomic.LoadInt64(&lastProgress))) >= 2*interval {
+		Log("No progress syncing in", 2*interval, "- no longer pinging the watchdog")
+		return
+	}
+
+	if err := sdNotify("WATCHDOG=1"); err != nil {
+		Debug("Error pinging watchdog:", err)
+	}
+}
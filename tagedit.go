@@ -0,0 +1,292 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// TagEdit is a change to a frame of the files' metadata, given as "ID=value" with a template for the value, e.g.
+// `TALB={{.Show}} (Archive)`. User-defined text frames are given as "TXXX:description=value". An empty value removes the
+// frame.
+type TagEdit struct {
+	ID    string // frame ID, e.g. "TALB" or "TXXX"
+	Desc  string // description of the user-defined text frame, for TXXX
+	Value *template.Template
+}
+
+// TagEdits is a list of tag edits. It can be set on the command line as "ID=value", once per edit.
+type TagEdits []TagEdit
+
+// String returns the IDs of the frames that are edited.
+func (t *TagEdits) String() string {
+	if t == nil {
+		return ""
+	}
+
+	var ids []string
+	for _, edit := range *t {
+		ids = append(ids, edit.name())
+	}
+
+	return strings.Join(ids, ", ")
+}
+
+// Set adds an edit in the form "ID=value".
+func (t *TagEdits) Set(value string) error {
+	fields := strings.SplitN(value, "=", 2)
+	if len(fields) != 2 {
+		return fmt.Errorf("tag edit must be in the form ID=value")
+	}
+
+	edit := TagEdit{ID: strings.ToUpper(fields[0])}
+	if i := strings.Index(fields[0], ":"); i >= 0 {
+		edit.ID, edit.Desc = strings.ToUpper(fields[0][:i]), fields[0][i+1:]
+		if edit.ID != "TXXX" || edit.Desc == "" {
+			return fmt.Errorf("invalid frame in tag edit: %v", fields[0])
+		}
+	}
+	if len(edit.ID) != 4 || (edit.ID[0] != 'T' && edit.ID[0] != 'W') || edit.ID == "WXXX" || (edit.ID == "TXXX" && edit.Desc == "") {
+		return fmt.Errorf("only text and URL frames can be edited: %v", fields[0])
+	}
+
+	tmpl, err := ParseTemplate(edit.name(), fields[1])
+	if err != nil {
+		return err
+	}
+	edit.Value = tmpl
+
+	*t = append(*t, edit)
+	return nil
+}
+
+// name returns the frame as it was given.
+func (e TagEdit) name() string {
+	if e.Desc != "" {
+		return e.ID + ":" + e.Desc
+	}
+
+	return e.ID
+}
+
+// runTag applies the tag edits to every audio file that matches the filter, in the paths given (or the whole library).
+func runTag(config *Config, dirArg string, args []string) error {
+	fs := flag.NewFlagSet("tag", flag.ContinueOnError)
+	var edits TagEdits
+	fs.Var(&edits, "set", "Frame to set, as ID=value, where the value is a template, e.g. TALB={{.Show}}. Can be given more than once")
+	where := fs.String("where", "", "Optional. Only change the files that match this filter, e.g. 'show == \"Old Name\"'")
+	dryRun := fs.Bool("dry-run", false, "Optional. Only list the changes that would be made")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if len(edits) == 0 {
+		Log("No tag edits specified")
+		return errUsage
+	}
+
+	filter, err := ParseFilter(*where)
+	if err != nil {
+		return err
+	}
+	if err := filter.Check(func(name string) (string, bool) { return fileField(name, "", TemplateData{}, &Meta{}) }); err != nil {
+		return err
+	}
+
+	mainDir, err := downloadDir(config, dirArg)
+	if err != nil {
+		return err
+	}
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{mainDir}
+	}
+
+	matched, changed := 0, 0
+	for _, root := range paths {
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if strings.HasPrefix(info.Name(), ".") && path != root {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.IsDir() || !isAudio(info.Name()) {
+				return nil
+			}
+
+			ok, updated, err := editFileTags(path, mainDir, edits, filter, *dryRun)
+			if err != nil {
+				Log("Error editing tags of", path, "-", err)
+				return nil
+			}
+			if ok {
+				matched++
+			}
+			if updated {
+				changed++
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if *dryRun {
+		Log("Would update", changed, "of", matched, "matching files")
+	} else {
+		Log("Updated", changed, "of", matched, "matching files")
+	}
+
+	return nil
+}
+
+// editFileTags applies the edits to the file if it matches the filter. This reports whether or not the file matched
+// and whether or not it was (or, for a dry run, would be) changed.
+func editFileTags(path string, mainDir string, edits TagEdits, filter *Filter, dryRun bool) (bool, bool, error) {
+	meta, err := readFileMeta(path)
+	if err != nil {
+		return false, false, err
+	}
+
+	data := fileTemplateData(path, mainDir, meta)
+	ok, err := filter.Match(func(name string) (string, bool) {
+		return fileField(name, path, data, meta)
+	})
+	if err != nil || !ok {
+		return false, false, err
+	}
+	if meta.Version() == 0 {
+		Log("Skipping", path, "- it doesn't have ID3 metadata")
+		return true, false, nil
+	}
+
+	changed := false
+	for _, edit := range edits {
+		value, err := renderTemplate(edit.Value, data)
+		if err != nil {
+			return true, false, err
+		}
+
+		id := edit.ID
+		if meta.Version() == 2 {
+			if id = v22IDs[edit.ID]; id == "" {
+				Log("Skipping", edit.name(), "for", path, "- it has no ID3v2.2 equivalent")
+				continue
+			}
+		}
+
+		old := getFirstValue(meta, id)
+		if edit.Desc != "" {
+			old = meta.GetUserText(edit.Desc)
+		}
+		if old == value {
+			continue
+		}
+
+		changed = true
+		if dryRun {
+			Log(fmt.Sprintf("%v: %v: %q -> %q", path, edit.name(), old, value))
+			continue
+		}
+		Debug(fmt.Sprintf("%v: %v: %q -> %q", path, edit.name(), old, value))
+		switch {
+		case edit.Desc != "" && value == "":
+			meta.RemoveUserText(edit.Desc)
+		case edit.Desc != "":
+			meta.SetUserText(edit.Desc, value)
+		case value == "":
+			meta.RemoveValues(id)
+		default:
+			meta.SetValue(id, []byte(value), false)
+		}
+	}
+
+	if !changed || dryRun {
+		return true, changed, nil
+	}
+
+	if err := writeFileMeta(path, meta); err != nil {
+		return true, false, err
+	}
+	if err := refreshChecksum(path); err != nil {
+		Log("Error updating checksums:", err)
+	}
+
+	return true, true, nil
+}
+
+// fileTemplateData gathers the values for templates from the file's tags. The show is the directory that the file is
+// in under the main download directory.
+func fileTemplateData(path string, mainDir string, meta *Meta) TemplateData {
+	data := TemplateData{
+		Show:     filepath.Base(filepath.Dir(path)),
+		Artist:   getTag(meta, "TPE1"),
+		Title:    getTag(meta, "TIT2"),
+		Season:   withoutTotal(getTag(meta, "TPOS")),
+		Number:   withoutTotal(getTag(meta, "TRCK")),
+		Language: getTag(meta, "TLAN"),
+		Genre:    getTag(meta, "TCON"),
+	}
+
+	if rel, err := filepath.Rel(mainDir, path); err == nil && !strings.HasPrefix(rel, "..") {
+		if parts := strings.Split(filepath.ToSlash(rel), "/"); len(parts) > 1 {
+			data.Show = parts[0]
+		}
+	}
+
+	date := getTag(meta, "TDRC")
+	if date == "" {
+		date = getTag(meta, "TYER")
+	}
+	if len(date) >= 10 {
+		data.Date = date[:10]
+	}
+	if len(date) >= 4 {
+		data.Year = date[:4]
+	}
+
+	return data
+}
+
+// fileField returns the value of the field for filters: one of the template values in lowercase (e.g. "show" or
+// "title"), "path", or a frame ID (e.g. "TALB" or "TXXX:GUID") to read it straight from the tags.
+func fileField(name string, path string, data TemplateData, meta *Meta) (string, bool) {
+	switch strings.ToLower(name) {
+	case "show":
+		return data.Show, true
+	case "artist":
+		return data.Artist, true
+	case "title":
+		return data.Title, true
+	case "season":
+		return data.Season, true
+	case "number":
+		return data.Number, true
+	case "date":
+		return data.Date, true
+	case "year":
+		return data.Year, true
+	case "language":
+		return data.Language, true
+	case "genre":
+		return data.Genre, true
+	case "path":
+		return path, true
+	}
+
+	if strings.HasPrefix(name, "TXXX:") {
+		return meta.GetUserText(strings.TrimPrefix(name, "TXXX:")), true
+	}
+	if len(name) == 4 && strings.ToUpper(name) == name {
+		return getTag(meta, name), true
+	}
+
+	return "", false
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Test that tag edits are only applied to matching files, and not at all for a dry run, and that the audio is kept.
+func TestEditFileTags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast-tag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	data, err := ioutil.ReadFile("./tests/white.mp3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "Old Name", "white.mp3")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	original, err := readFileMeta(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var edits TagEdits
+	for _, edit := range []string{"TALB=New Name", "TXXX:Note={{.Show}} archive"} {
+		if err := edits.Set(edit); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, bad := range []string{"TALB", "APIC=x", "TXXX=x", "TIT2:x=y", "TALB={{.Bogus"} {
+		if err := edits.Set(bad); err == nil {
+			t.Error("Invalid tag edit was accepted:", bad)
+		}
+	}
+
+	other, _ := ParseFilter(`show == "Other"`)
+	if matched, changed, err := editFileTags(path, dir, edits, other, false); err != nil || matched || changed {
+		t.Error("Edited a file that doesn't match the filter:", matched, changed, err)
+	}
+
+	filter, _ := ParseFilter(`show == "Old Name"`)
+	if matched, changed, err := editFileTags(path, dir, edits, filter, true); err != nil || !matched || !changed {
+		t.Error("Dry run did not find the changes:", matched, changed, err)
+	}
+	if have, _ := ioutil.ReadFile(path); string(have) != string(data) {
+		t.Error("Dry run changed the file")
+	}
+
+	if matched, changed, err := editFileTags(path, dir, edits, filter, false); err != nil || !matched || !changed {
+		t.Fatal("Did not edit the file:", matched, changed, err)
+	}
+	meta, err := readFileMeta(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have := getTag(meta, "TALB"); have != "New Name" {
+		t.Error("Incorrect album - Want: New Name Have:", have)
+	}
+	if have := meta.GetUserText("Note"); have != "Old Name archive" {
+		t.Error("Incorrect note - Want: Old Name archive Have:", have)
+	}
+	if have, _ := ioutil.ReadFile(path); !bytes.Equal(have[meta.Len():], data[original.Len():]) {
+		t.Error("Audio after the tag was changed")
+	}
+
+	// Running it again has nothing left to change.
+	if _, changed, err := editFileTags(path, dir, edits, filter, false); err != nil || changed {
+		t.Error("Edited an already updated file:", changed, err)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// TagIndex remembers the titles and GUIDs read from the tags of local files so that unchanged files don't have to be parsed
+// again on the next sync. An entry is only used while the file's size and modification time still match. It is stored
+// as JSON in the profile's cache directory.
+type TagIndex struct {
+	path  string
+	mutex sync.Mutex
+	dirty bool
+
+	Files map[string]TagEntry `json:"files"` // keyed by absolute path
+}
+
+// TagEntry is the cached tag information of an individual file.
+type TagEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Title   string    `json:"title"`
+	GUID    string    `json:"guid,omitempty"`
+}
+
+// TagIndexPath returns the location of the active profile's tag index.
+func TagIndexPath() string {
+	dir := ActiveProfile.CacheDir()
+	if dir == "" {
+		return ""
+	}
+
+	return filepath.Join(dir, "tags.json")
+}
+
+// LoadTagIndex reads the tag index at the provided path. If the index does not exist yet or can't be read, an empty
+// index is returned; it's only a cache, so it will be rebuilt as files are parsed.
+func LoadTagIndex(path string) *TagIndex {
+	index := &TagIndex{path: path, Files: make(map[string]TagEntry)}
+	if path == "" {
+		return index
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			Log("Error reading tag index:", err)
+		}
+		return index
+	}
+
+	if err := json.Unmarshal(data, index); err != nil {
+		Log("Error parsing tag index, starting over:", err)
+		index.Files = make(map[string]TagEntry)
+	}
+	if index.Files == nil {
+		index.Files = make(map[string]TagEntry)
+	}
+
+	Debug("Loaded tag index of", len(index.Files), "files from", path)
+	return index
+}
+
+// Lookup returns the title and GUID of the file at the path, reading them from the index if the file hasn't changed
+// since it was last parsed and from the file's tags otherwise.
+func (i *TagIndex) Lookup(path string, info os.FileInfo) (TagEntry, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+
+	if i != nil {
+		i.mutex.Lock()
+		entry, ok := i.Files[abs]
+		i.mutex.Unlock()
+		if ok && entry.Size == info.Size() && entry.ModTime.Equal(info.ModTime()) {
+			return entry, nil
+		}
+	}
+
+	title, guid, err := readFileTitle(path)
+	if err != nil {
+		return TagEntry{}, err
+	}
+	entry := TagEntry{
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		Title:   title,
+		GUID:    guid,
+	}
+
+	if i != nil {
+		i.mutex.Lock()
+		i.Files[abs] = entry
+		i.dirty = true
+		i.mutex.Unlock()
+	}
+
+	return entry, nil
+}
+
+// Forget drops the entry for the file at the path, for when its tags were changed without changing its size or
+// modification time.
+func (i *TagIndex) Forget(path string) {
+	if i == nil {
+		return
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+	if _, ok := i.Files[abs]; ok {
+		delete(i.Files, abs)
+		i.dirty = true
+	}
+}
+
+// Save writes the index out to disk if it has changed, dropping the entries for files that no longer exist.
+func (i *TagIndex) Save() error {
+	if i == nil || i.path == "" {
+		return nil
+	}
+
+	i.mutex.Lock()
+	defer i.mutex.Unlock()
+
+	for path := range i.Files {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			delete(i.Files, path)
+			i.dirty = true
+		}
+	}
+
+	if !i.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(i)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(i.path), 0755); err != nil {
+		return err
+	}
+
+	tmp := i.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("error writing tag index: %v", err)
+	}
+	if err := os.Rename(tmp, i.path); err != nil {
+		return err
+	}
+
+	i.dirty = false
+	return nil
+}
+
+// readFileTitle reads the title and GUID from the tags of the audio file at the path. Only the frames holding them are
+// read, so this costs a few reads per file no matter how large the file or its artwork is.
+func readFileTitle(path string) (string, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer file.Close()
+
+	frames, version, err := readTagFrames(file, func(id string) bool {
+		switch id {
+		case "TIT2", "TT2", "TXXX", "TXX":
+			return true
+		}
+		return false
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	titleID, userTextID := "TIT2", "TXXX"
+	if version == 2 {
+		titleID, userTextID = "TT2", "TXX"
+	}
+
+	title := ""
+	if values := frames[titleID]; len(values) > 0 {
+		title = string(values[0])
+	}
+	guid := ""
+	for _, value := range frames[userTextID] {
+		fields := bytes.SplitN(value, []byte{0x00}, 2)
+		if len(fields) == 2 && string(fields[0]) == "GUID" {
+			guid = string(fields[1])
+			break
+		}
+	}
+
+	return title, guid, nil
+}
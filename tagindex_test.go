@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test that the tag index is used for unchanged files and survives a round trip to disk.
+func TestTagIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast-tags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "episode.mp3")
+	if err := ioutil.WriteFile(path, []byte("audio"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index := LoadTagIndex(filepath.Join(dir, "tags.json"))
+	index.Files[path] = TagEntry{Size: info.Size(), ModTime: info.ModTime(), Title: "Cached Title"}
+	index.Files[filepath.Join(dir, "gone.mp3")] = TagEntry{Title: "Gone"}
+	index.dirty = true
+	if err := index.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	index = LoadTagIndex(filepath.Join(dir, "tags.json"))
+	if len(index.Files) != 1 {
+		t.Error("Missing file was not dropped from the index")
+	}
+	if entry, err := index.Lookup(path, info); err != nil || entry.Title != "Cached Title" {
+		t.Error("Did not use the cached title - Have:", entry.Title, err)
+	}
+
+	// Once the file changes, the cached title must not be used.
+	later := info.ModTime().Add(time.Minute)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if info, err = os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+	if entry, _ := index.Lookup(path, info); entry.Title == "Cached Title" {
+		t.Error("Used the cached title for a changed file")
+	}
+}
+
+// Test that reading just the title and GUID frames gives the same values as reading the whole tag.
+func TestReadFileTitle(t *testing.T) {
+	for _, name := range []string{"white", "pink", "brown"} {
+		path := "./tests/" + name + ".mp3"
+		meta, err := readFileMeta(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		title, guid, err := readFileTitle(path)
+		if err != nil {
+			t.Error(name, "- Error reading title:", err)
+			continue
+		}
+		if want := getTag(meta, "TIT2"); title != want || want == "" {
+			t.Error(name, "- Incorrect title - Want:", want, "Have:", title)
+		}
+		if want := meta.GetUserText("GUID"); guid != want {
+			t.Error(name, "- Incorrect GUID - Want:", want, "Have:", guid)
+		}
+	}
+}
+
+// Test that a file whose tags can't be read still counts as the episode in its name, so it isn't downloaded again.
+func TestFilterUnreadableTags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast-tags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The tag has an extended header that's too short to be one.
+	broken := []byte("ID3\x03\x00\x40\x00\x00\x00\x10\x00\x00\x00\x01")
+	if err := ioutil.WriteFile(filepath.Join(dir, "Copy of Episode One.mp3"), broken, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := readFileTitle(filepath.Join(dir, "Copy of Episode One.mp3")); err == nil {
+		t.Fatal("Read the tags of a broken file")
+	}
+
+	u, _ := url.Parse("https://example.com/feed.xml")
+	show := &Show{URL: u, Title: "Show", Dir: dir}
+	for _, title := range []string{"Episode One", "Episode Two"} {
+		e := Episode{Title: title}
+		e.Enclosure.URL = "https://example.com/" + title + ".mp3"
+		e.Enclosure.Type = "audio/mpeg"
+		show.Episodes = append(show.Episodes, e)
+	}
+	if err := show.filter("", nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(show.Episodes) != 1 || show.Episodes[0].Title != "Episode Two" {
+		t.Error("Incorrect episodes to download - Want: [Episode Two] Have:", show.Episodes)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// These are the reasons that a frame in an episode's existing tag can be skipped.
+const (
+	IssueParse     = "parse error"
+	IssueEncoding  = "unknown encoding"
+	IssueInvalidID = "invalid ID"
+)
+
+// TagIssues counts the frames of a tag that were skipped because they couldn't be read, by the reason they were skipped.
+type TagIssues map[string]int
+
+// Total returns the number of frames that were skipped for any reason.
+func (t TagIssues) Total() int {
+	total := 0
+	for _, n := range t {
+		total += n
+	}
+
+	return total
+}
+
+// Add adds the other counts to these.
+func (t TagIssues) Add(other TagIssues) {
+	for reason, n := range other {
+		t[reason] += n
+	}
+}
+
+// String lists the counts, e.g. "1 invalid ID, 2 unknown encoding".
+func (t TagIssues) String() string {
+	reasons := make([]string, 0, len(t))
+	for reason, n := range t {
+		if n > 0 {
+			reasons = append(reasons, reason)
+		}
+	}
+	sort.Strings(reasons)
+
+	parts := make([]string, len(reasons))
+	for i, reason := range reasons {
+		parts[i] = fmt.Sprintf("%v %v", t[reason], reason)
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// BadTag describes an episode whose tag, as published, had frames that couldn't be read.
+type BadTag struct {
+	Show    string    `json:"show"`
+	Feed    string    `json:"feed,omitempty"`
+	Episode string    `json:"episode"`
+	Issues  TagIssues `json:"issues"`
+}
+
+// checkTag returns an error if -strict-tags is set and the episode's tag, as published, had frames that couldn't be
+// read.
+func (e *Episode) checkTag() error {
+	if !StrictTags || e.meta == nil {
+		return nil
+	}
+
+	if issues := e.meta.Issues(); issues.Total() > 0 {
+		return fmt.Errorf("published tag has frames that can't be read: %v", issues)
+	}
+
+	return nil
+}
+
+// checkTag warns about the episode if its tag, as published, had frames that couldn't be read, and adds it to the
+// show's list of bad tags for the summary. This points to a problem with the publisher's encoder.
+func (s *Show) checkTag(episode *Episode) {
+	issues := episode.meta.Issues()
+	if issues.Total() == 0 {
+		return
+	}
+
+	Log("WARNING: Skipped frames in the episode's published tag:", issues)
+	s.BadTags = append(s.BadTags, BadTag{
+		Show:    s.Title,
+		Episode: episode.Title,
+		Issues:  issues,
+	})
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// TemplateData holds the values that can be used in filename and tag templates.
+type TemplateData struct {
+	Show     string // title of the show
+	Artist   string // author of the show
+	Title    string // title of the episode
+	Season   string // season number
+	Number   string // episode number, padded to the minimum width
+	Prefix   string // season and episode number, e.g. "3-05"
+	Date     string // publish date, as YYYY-MM-DD
+	Year     string // publish year
+	Language string // language of the show, e.g. "en"
+	Genre    string // genre of the show
+}
+
+// ParseTemplate parses a template for a filename or tag. An empty template returns nil.
+func ParseTemplate(name string, text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %v template: %v", name, err)
+	}
+
+	// Make sure the template works before we start downloading anything.
+	if _, err := renderTemplate(tmpl, TemplateData{}); err != nil {
+		return nil, fmt.Errorf("invalid %v template: %v", name, err)
+	}
+
+	return tmpl, nil
+}
+
+// ParseFilenameTemplate parses a filename template, such as "{{.Language}}/{{.Prefix}} {{.Title}}". The template
+// describes the episode's path under the show's directory without the extension. A "/" in the template creates
+// subdirectories.
+func ParseFilenameTemplate(text string) (*template.Template, error) {
+	return ParseTemplate("filename", text)
+}
+
+// templateData gathers the episode's values for templates.
+func (e *Episode) templateData() TemplateData {
+	data := TemplateData{
+		Show:     e.showTitle,
+		Artist:   e.showArtist,
+		Title:    e.Title,
+		Season:   e.Season,
+		Prefix:   e.NumberFormatted(),
+		Language: baseLanguage(e.showLanguage),
+		Genre:    e.showGenre,
+	}
+
+	if e.Number != "" {
+		data.Number = fmt.Sprintf("%0*s", intValue(e.options().MinWidth), e.Number)
+	}
+
+	if ts := e.publishTime(); !ts.IsZero() {
+		data.Date = ts.Format("2006-01-02")
+		data.Year = ts.Format("2006")
+	}
+
+	return data
+}
+
+// renderTemplate renders the template with the data, trimming any surrounding whitespace. A nil template renders as
+// an empty string.
+func renderTemplate(tmpl *template.Template, data TemplateData) (string, error) {
+	if tmpl == nil {
+		return "", nil
+	}
+
+	buf := new(bytes.Buffer)
+	if err := tmpl.Execute(buf, data); err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// sanitized returns the data with every value made safe for a filename (see SanitizeTitle).
+func (d TemplateData) sanitized() TemplateData {
+	return TemplateData{
+		Show:     SanitizeTitle(d.Show),
+		Artist:   SanitizeTitle(d.Artist),
+		Title:    SanitizeTitle(d.Title),
+		Season:   SanitizeTitle(d.Season),
+		Number:   SanitizeTitle(d.Number),
+		Prefix:   SanitizeTitle(d.Prefix),
+		Date:     SanitizeTitle(d.Date),
+		Year:     SanitizeTitle(d.Year),
+		Language: SanitizeTitle(d.Language),
+		Genre:    SanitizeTitle(d.Genre),
+	}
+}
+
+// renderFilename renders the filename template with the data. The values are sanitized before they go into the
+// template so that only a "/" in the template itself can add directories (a title like "AC/DC" stays one name), and
+// then each part of the path is sanitized again and empty parts are dropped.
+func renderFilename(tmpl *template.Template, data TemplateData) (string, error) {
+	name, err := renderTemplate(tmpl, data.sanitized())
+	if err != nil {
+		return "", err
+	}
+
+	var parts []string
+	for _, part := range strings.Split(name, "/") {
+		part = strings.TrimSpace(part)
+		if part == "" || part == "." || part == ".." {
+			continue
+		}
+		parts = append(parts, SanitizeTitle(part))
+	}
+
+	return filepath.Join(parts...), nil
+}
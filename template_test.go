@@ -0,0 +1,29 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// Test that values with a "/" in them don't add directories to a filename, but that the template's own "/" does.
+func TestRenderFilename(t *testing.T) {
+	tmpl, err := ParseFilenameTemplate("{{.Show}}/{{.Year}}/{{.Prefix}} {{.Title}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := TemplateData{Show: "AC/DC Stories", Year: "2021", Prefix: "1-02", Title: "Back/In/Black"}
+	name, err := renderFilename(tmpl, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join("AC-DC Stories", "2021", "1-02 Back-In-Black"); name != want {
+		t.Error("Incorrect filename - Want:", want, "Have:", name)
+	}
+
+	// A value can't climb out of the show's directory either.
+	data = TemplateData{Show: "..", Title: "../../etc/passwd"}
+	if name, _ := renderFilename(tmpl, data); name != "..-..-etc-passwd" {
+		t.Error("Incorrect filename - Want: ..-..-etc-passwd Have:", name)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// episodeSuffix matches a redundant episode number at the end of a title, such as "| Ep. 45", "- Episode 12", or "#3".
+var episodeSuffix = regexp.MustCompile(`(?i)(\s*[|:\-–—]\s*(ep\.?|episode)\s*\d+|\s*[|:\-–—]?\s*#\d+)\s*$`)
+
+// titleSeparators are the separators that publishers put between the show's name and the episode's title.
+var titleSeparators = []string{"-", "–", "—", ":", "|"}
+
+// TitleRule is a regular expression search and replace for episode titles. The replacement can use $1 and the like to
+// refer to submatches.
+type TitleRule struct {
+	Match   string `json:"match"`   // regular expression to search for
+	Replace string `json:"replace"` // text to replace each match with
+
+	re *regexp.Regexp
+}
+
+// compile compiles the rule's regular expression.
+func (r *TitleRule) compile() error {
+	re, err := regexp.Compile(r.Match)
+	if err != nil {
+		return fmt.Errorf("invalid title rule %q: %v", r.Match, err)
+	}
+
+	r.re = re
+	return nil
+}
+
+// TitleRules is a list of title rules. It can be set on the command line as "pattern=>replacement", once per rule.
+type TitleRules []TitleRule
+
+// String returns the rules as they would be given on the command line.
+func (r *TitleRules) String() string {
+	if r == nil {
+		return ""
+	}
+
+	var rules []string
+	for _, rule := range *r {
+		rules = append(rules, rule.Match+"=>"+rule.Replace)
+	}
+
+	return strings.Join(rules, ", ")
+}
+
+// Set adds a rule in the form "pattern=>replacement". An empty replacement removes the matches.
+func (r *TitleRules) Set(value string) error {
+	fields := strings.SplitN(value, "=>", 2)
+	if len(fields) != 2 {
+		return fmt.Errorf("title rule must be in the form pattern=>replacement")
+	}
+
+	rule := TitleRule{Match: fields[0], Replace: fields[1]}
+	if err := rule.compile(); err != nil {
+		return err
+	}
+
+	*r = append(*r, rule)
+	return nil
+}
+
+// compile compiles the regular expressions of all the rules.
+func (r TitleRules) compile() error {
+	for i := range r {
+		if err := r[i].compile(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// TitleCleanup describes how to clean up episode titles before they're used for filenames and metadata.
+type TitleCleanup struct {
+	StripShow   bool       // remove the show's name from the start of titles
+	StripNumber bool       // remove episode numbers from the end of titles
+	Rules       TitleRules // search and replace rules, applied in order
+}
+
+// Clean applies the cleanup to the episode's title. If cleaning would leave nothing, the title is returned unchanged.
+func (c TitleCleanup) Clean(title string, showTitle string) string {
+	cleaned := strings.TrimSpace(title)
+
+	if c.StripShow {
+		cleaned = stripShowName(cleaned, showTitle)
+	}
+
+	if c.StripNumber {
+		cleaned = episodeSuffix.ReplaceAllString(cleaned, "")
+	}
+
+	for _, rule := range c.Rules {
+		if rule.re != nil {
+			cleaned = rule.re.ReplaceAllString(cleaned, rule.Replace)
+		}
+	}
+
+	cleaned = strings.TrimSpace(cleaned)
+	if cleaned == "" {
+		return title
+	}
+
+	return cleaned
+}
+
+// stripShowName removes the show's name and the separator after it from the start of the title, e.g. "ShowName – ".
+func stripShowName(title string, showTitle string) string {
+	showTitle = strings.TrimSpace(showTitle)
+	if showTitle == "" || len(title) <= len(showTitle) || !strings.EqualFold(title[:len(showTitle)], showTitle) {
+		return title
+	}
+
+	rest := strings.TrimSpace(title[len(showTitle):])
+	for _, sep := range titleSeparators {
+		if strings.HasPrefix(rest, sep) {
+			return strings.TrimSpace(strings.TrimPrefix(rest, sep))
+		}
+	}
+
+	return title
+}
+
+// cleanup returns the title cleanup for this show, with the subscription's rules applied after the global ones.
+func (s *Show) cleanup() TitleCleanup {
+	cleanup := TitleOptions
+	cleanup.Rules = append(TitleRules(nil), TitleOptions.Rules...)
+	cleanup.Rules = append(cleanup.Rules, s.TitleRules...)
+
+	return cleanup
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+)
+
+// Test that episode titles are cleaned up according to the rules.
+func TestCleanTitle(t *testing.T) {
+	var rules TitleRules
+	if err := rules.Set(`\s*\(Rebroadcast\)=>`); err != nil {
+		t.Fatal(err)
+	}
+
+	cleanup := TitleCleanup{StripShow: true, StripNumber: true, Rules: rules}
+	tests := []struct {
+		title string
+		want  string
+	}{
+		{"My Show – The Big Story", "The Big Story"},
+		{"my show: The Big Story | Ep. 45", "The Big Story"},
+		{"The Big Story - Episode 12", "The Big Story"},
+		{"The Big Story (Rebroadcast) #3", "The Big Story"},
+		{"My Showcase of Stories", "My Showcase of Stories"},
+		{"My Show", "My Show"},
+		{"Ep. 45", "Ep. 45"},
+	}
+
+	for _, test := range tests {
+		if have := cleanup.Clean(test.title, "My Show"); have != test.want {
+			t.Error("Title does not match for", test.title, "- Want:", test.want, "Have:", have)
+		}
+	}
+
+	if err := rules.Set("no separator"); err == nil {
+		t.Error("Accepted a rule without a replacement")
+	}
+}
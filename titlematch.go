@@ -0,0 +1,124 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// titleReplacer swaps typographic punctuation for the plain ASCII that publishers switch between.
+var titleReplacer = strings.NewReplacer(
+	"‘", "'", "’", "'", "‚", "'", "′", "'",
+	"“", `"`, "”", `"`, "„", `"`, "″", `"`,
+	"–", "-", "—", "-", "‐", "-", "‑", "-",
+	"…", "...",
+)
+
+// normalizeTitle puts the title in a form that ignores differences that don't matter: Unicode composition, curly
+// quotes and dashes, letter case, and spacing.
+func normalizeTitle(title string) string {
+	title = norm.NFC.String(title)
+	title = titleReplacer.Replace(title)
+	title = strings.ToLower(title)
+
+	return strings.Join(strings.FieldsFunc(title, unicode.IsSpace), " ")
+}
+
+// titleSet is a set of episode titles that can be checked for titles that are nearly the same, for when a publisher
+// touches up a title after we've downloaded the episode. Each title keeps the size of its local file, if known.
+type titleSet struct {
+	titles map[string]int64 // normalized titles
+}
+
+// newTitleSet creates an empty set of titles.
+func newTitleSet() *titleSet {
+	return &titleSet{titles: make(map[string]int64)}
+}
+
+// Add adds the title to the set.
+func (t *titleSet) Add(title string) {
+	t.AddFile(title, 0)
+}
+
+// AddFile adds the title of a local file of this size to the set.
+func (t *titleSet) AddFile(title string, size int64) {
+	if title = normalizeTitle(title); title != "" {
+		t.titles[title] = size
+	}
+}
+
+// Has checks whether the set has the title after normalizing, or a title within -title-distance edits of it.
+func (t *titleSet) Has(title string) bool {
+	_, _, _, ok := t.Lookup(title)
+	return ok
+}
+
+// Lookup finds the title in the set, first after normalizing and then within -title-distance edits of it. This returns
+// the title that matched, the size of its file, and whether or not the match was exact.
+func (t *titleSet) Lookup(title string) (string, int64, bool, bool) {
+	title = normalizeTitle(title)
+	if size, ok := t.titles[title]; ok {
+		return title, size, true, true
+	}
+
+	if TitleDistance <= 0 || title == "" {
+		return "", 0, false, false
+	}
+
+	for have, size := range t.titles {
+		if levenshtein(title, have, TitleDistance) <= TitleDistance {
+			Debug("Fuzzy title match:", title, "~", have)
+			return have, size, false, true
+		}
+	}
+
+	return "", 0, false, false
+}
+
+// levenshtein returns the number of single-character edits needed to turn a into b. Once the distance is sure to be
+// more than max, this stops early and returns max+1.
+func levenshtein(a string, b string, max int) int {
+	ra, rb := []rune(a), []rune(b)
+	if diff := len(ra) - len(rb); diff > max || -diff > max {
+		return max + 1
+	}
+
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		lowest := curr[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if curr[j] < lowest {
+				lowest = curr[j]
+			}
+		}
+		if lowest > max {
+			return max + 1
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(rb)]
+}
+
+// minInt returns the smallest of the numbers.
+func minInt(n int, rest ...int) int {
+	for _, m := range rest {
+		if m < n {
+			n = m
+		}
+	}
+
+	return n
+}
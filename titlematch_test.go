@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+)
+
+// Test that titles match despite small differences.
+func TestTitleSet(t *testing.T) {
+	set := newTitleSet()
+	set.Add("Don’t  Panic — Part 1")
+	set.Add("Café Stories")
+
+	if !set.Has("don't panic - part 1") {
+		t.Error("Normalized titles did not match")
+	}
+	if !set.Has("Cafe\u0301 Stories") {
+		t.Error("Composed and decomposed titles did not match")
+	}
+	if set.Has("Don't Panic - Part 2") {
+		t.Error("Matched a different title without a distance")
+	}
+
+	TitleDistance = 1
+	defer func() { TitleDistance = 0 }()
+	if !set.Has("Don't Panic - Part 2") {
+		t.Error("Did not match a title within the distance")
+	}
+	if set.Has("Don't Panic - Part 22") {
+		t.Error("Matched a title outside the distance")
+	}
+}
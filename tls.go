@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// TLSOptions holds the settings for the shared HTTP client's TLS connections.
+type TLSOptions struct {
+	CACert     string // PEM file of extra certificate authorities to trust, along with the system's
+	ClientCert string // PEM file of the client certificate to present to servers that ask for one
+	ClientKey  string // PEM file of the client certificate's private key, if it isn't in ClientCert
+	Insecure   bool   // don't verify servers' certificates at all
+}
+
+// ConfigureTLS applies the options to the shared HTTP client's transport.
+func ConfigureTLS(opts TLSOptions) error {
+	transport, ok := client.Transport.(*http.Transport)
+	if !ok {
+		return fmt.Errorf("cannot configure TLS: unknown transport")
+	}
+
+	config := transport.TLSClientConfig
+	if config == nil {
+		config = &tls.Config{}
+	}
+
+	if opts.CACert != "" {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+
+		data, err := ioutil.ReadFile(opts.CACert)
+		if err != nil {
+			return fmt.Errorf("error reading CA certificates: %v", err)
+		}
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificates found in %v", opts.CACert)
+		}
+		config.RootCAs = pool
+	}
+
+	if opts.ClientCert != "" {
+		key := opts.ClientKey
+		if key == "" {
+			key = opts.ClientCert
+		}
+
+		cert, err := tls.LoadX509KeyPair(opts.ClientCert, key)
+		if err != nil {
+			return fmt.Errorf("error loading client certificate: %v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	} else if opts.ClientKey != "" {
+		return fmt.Errorf("client key given without a client certificate")
+	}
+
+	if opts.Insecure {
+		Log("WARNING: Not verifying TLS certificates. Feeds and episodes can be tampered with in transit.")
+		config.InsecureSkipVerify = true
+	}
+
+	transport.TLSClientConfig = config
+
+	return nil
+}
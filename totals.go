@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// setTotals works out the number of episodes in each season and the number of seasons from the episode numbers in
+// the feed, and passes them along to the episodes. Feeds often drop their oldest items, so the totals are taken from
+// the highest numbers instead of by counting items.
+func (s *Show) setTotals() {
+	seasonTotal := 0
+	trackTotals := make(map[int]int)
+	for _, episode := range s.Episodes {
+		season, _ := strconv.Atoi(episode.Season)
+		if season > seasonTotal {
+			seasonTotal = season
+		}
+		if number, err := strconv.Atoi(episode.Number); err == nil && number > trackTotals[season] {
+			trackTotals[season] = number
+		}
+	}
+
+	for i := range s.Episodes {
+		season, _ := strconv.Atoi(s.Episodes[i].Season)
+		s.Episodes[i].SetTotals(trackTotals[season], seasonTotal)
+	}
+}
+
+// SetTotals sets the number of episodes in the episode's season and the number of seasons in the show. A total of 0
+// means that it's not known.
+func (e *Episode) SetTotals(tracks int, seasons int) {
+	if e != nil {
+		e.trackTotal = tracks
+		e.seasonTotal = seasons
+	}
+}
+
+// trackValue returns the value for the TRCK frame: the episode number, with the total if -totals is set and the total
+// is known, e.g. "42/317".
+func (e *Episode) trackValue() string {
+	return withTotal(e.Number, e.trackTotal)
+}
+
+// discValue returns the value for the TPOS frame: the season number, with the total if -totals is set and the total is
+// known, e.g. "2/5".
+func (e *Episode) discValue() string {
+	return withTotal(e.Season, e.seasonTotal)
+}
+
+// withTotal appends the total to the number for the "n/total" format.
+func withTotal(number string, total int) string {
+	if !WriteTotals || number == "" || total <= 0 {
+		return number
+	}
+
+	if n, err := strconv.Atoi(number); err != nil || n > total {
+		return number
+	}
+
+	return number + "/" + strconv.Itoa(total)
+}
+
+// withoutTotal removes the total from a number in the "n/total" format.
+func withoutTotal(value string) string {
+	if i := strings.Index(value, "/"); i >= 0 {
+		return value[:i]
+	}
+
+	return value
+}
+
+// runRetag updates the track and season totals in the metadata of episodes already on disk, for the show at urlArg or
+// every subscription in the config.
+func runRetag(config *Config, urlArg string, dirArg string) error {
+	if urlArg == "" && (config == nil || len(config.Subscriptions) == 0) {
+		Log("No show specified")
+		return errUsage
+	}
+
+	mainDir, err := downloadDir(config, dirArg)
+	if err != nil {
+		return err
+	}
+
+	// The point of retagging is to write the totals.
+	WriteTotals = true
+
+	var shows []*Show
+	if urlArg != "" {
+		u, err := ParseFeedArg(urlArg)
+		if err != nil {
+			Log("Invalid URL:", err)
+			return errUsage
+		}
+		shows = append(shows, &Show{URL: u})
+	} else {
+		for _, sub := range config.Prioritized() {
+			show, err := NewShow(sub)
+			if err != nil {
+				Log(err)
+				continue
+			}
+			shows = append(shows, show)
+		}
+	}
+
+	failed := 0
+	for _, show := range shows {
+		if err := show.retag(mainDir); err != nil {
+			Log("Error retagging", show.URL, "-", err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("failed to retag %v of %v shows", failed, len(shows))
+	}
+	return nil
+}
+
+// retag updates the totals of every episode in the show's directory that is still in the feed.
+func (s *Show) retag(mainDir string) error {
+	if err := s.Fetch(); err != nil {
+		return err
+	}
+
+	s.Dir = filepath.Join(mainDir, s.Title)
+	if _, err := os.Stat(s.Dir); err != nil {
+		return fmt.Errorf("show directory not found: %v", err)
+	}
+
+	// Files are matched to their episodes by GUID, either from the file's tags or from what we recorded downloading,
+	// and otherwise by title, ignoring differences in case, spacing, and punctuation.
+	byGUID := make(map[string]*Episode)
+	byTitle := make(map[string]*Episode)
+	for i := range s.Episodes {
+		if guid := s.Episodes[i].GUID; guid != "" {
+			byGUID[guid] = &s.Episodes[i]
+		}
+		byTitle[normalizeTitle(s.Episodes[i].Title)] = &s.Episodes[i]
+	}
+	known := StateDB.ByPath()
+	match := func(path string, meta *Meta) *Episode {
+		if episode, ok := byGUID[meta.GetUserText("GUID")]; ok {
+			return episode
+		}
+		if abs, err := filepath.Abs(path); err == nil {
+			if episode, ok := byGUID[known[abs].GUID]; ok {
+				return episode
+			}
+		}
+		return byTitle[normalizeTitle(getTag(meta, "TIT2"))]
+	}
+
+	updated := 0
+	rules := s.scanRules()
+	err := filepath.Walk(s.Dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if skip, err := rules.skip(path, info); skip {
+			return err
+		}
+		if info.IsDir() {
+			if path != s.Dir && strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !isAudio(path) {
+			return nil
+		}
+
+		meta, err := readFileMeta(path)
+		if err != nil {
+			Log("Error reading metadata of", path, "-", err)
+			return nil
+		}
+
+		episode := match(path, meta)
+		if episode == nil {
+			return nil
+		}
+		values := episode.filterValues(s.Title)
+		values.Path, values.Size = path, int(info.Size())
+		if !EpisodeFilter.Selects(values) {
+			return nil
+		}
+
+		if changed, err := retagFile(path, meta, episode); err != nil {
+			Log("Error retagging", path, "-", err)
+		} else if changed {
+			updated++
+			if err := refreshChecksum(path); err != nil {
+				Log("Error updating checksums:", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	Log("Updated", updated, "episodes of", s.Title)
+	return nil
+}
+
+// retagFile rewrites the file's TRCK and TPOS frames with the episode's current totals. This reports whether or not
+// the file was changed. Files without ID3 metadata are left alone.
+func retagFile(path string, meta *Meta, episode *Episode) (bool, error) {
+	if meta.Version() == 0 {
+		return false, nil
+	}
+
+	trackID, discID := "TRCK", "TPOS"
+	if meta.Version() == 2 {
+		trackID, discID = v22IDs[trackID], v22IDs[discID]
+	}
+
+	track, disc := episode.trackValue(), episode.discValue()
+	if getFirstValue(meta, trackID) == track && getFirstValue(meta, discID) == disc {
+		return false, nil
+	}
+
+	if track != "" {
+		meta.SetValue(trackID, []byte(track), false)
+	}
+	if disc != "" {
+		meta.SetValue(discID, []byte(disc), false)
+	}
+
+	return true, writeFileMeta(path, meta)
+}
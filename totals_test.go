@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/snhilde/getcast/internal/feedtest"
+)
+
+// Test that rewriting a file's tags keeps its audio, mode, and modification time and drops its entry from the tag
+// index.
+func TestWriteFileMetaKeepsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast-totals")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tmpCache := TagCache
+	defer func() { TagCache = tmpCache }()
+	TagCache = LoadTagIndex(filepath.Join(dir, "tags.json"))
+
+	path := filepath.Join(dir, "episode.mp3")
+	tag := feedtest.Tag{Version: 3, Frames: []feedtest.Frame{feedtest.TextFrame("TIT2", "Old Title")}}
+	original := feedtest.MP3(tag, 100000)
+	if err := ioutil.WriteFile(path, original, 0600); err != nil {
+		t.Fatal(err)
+	}
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	info, _ := os.Stat(path)
+	if _, err := TagCache.Lookup(path, info); err != nil {
+		t.Fatal(err)
+	}
+
+	meta, err := readFileMeta(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audio := original[meta.Len():]
+	meta.SetValue("TIT2", []byte("New Title"), false)
+	if err := writeFileMeta(path, meta); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ioutil.ReadFile(path); err != nil || !bytes.HasSuffix(data, audio) {
+		t.Error("Audio after the tag was not kept")
+	}
+
+	info, err = os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Error("Incorrect mode - Want:", os.FileMode(0600), "Have:", info.Mode().Perm())
+	}
+	if !info.ModTime().Equal(modTime) {
+		t.Error("Incorrect modification time - Want:", modTime, "Have:", info.ModTime())
+	}
+	if entry, err := TagCache.Lookup(path, info); err != nil || entry.Title != "New Title" {
+		t.Error("Incorrect title from tag index - Want: New Title Have:", entry.Title, err)
+	}
+}
+
+// Test that retagging finds the episode for a file by its GUID, by the path recorded in the state, or by a title that
+// only differs in case and spacing.
+func TestRetagMatching(t *testing.T) {
+	items := ""
+	for i, title := range []string{"By Tag", "By State", "By Title", "Unknown"} {
+		items += fmt.Sprintf(`<item><title>%v</title><guid>guid-%v</guid><itunes:season>1</itunes:season>
+			<itunes:episode>%v</itunes:episode><enclosure url="/%v.mp3" length="100" type="audio/mpeg"/></item>`,
+			title, i+1, i+1, i+1)
+	}
+	feed := `<?xml version="1.0"?><rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd">` +
+		`<channel><title>Show</title>` + items + `</channel></rss>`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(feed))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "getcast-totals")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	showDir := filepath.Join(dir, "Show")
+	os.MkdirAll(showDir, 0755)
+
+	write := func(name string, frames ...feedtest.Frame) string {
+		path := filepath.Join(showDir, name)
+		if err := ioutil.WriteFile(path, feedtest.MP3(feedtest.Tag{Version: 3, Frames: frames}, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	byTag := write("1.mp3", feedtest.TextFrame("TIT2", "Renamed"), feedtest.TextFrame("TXXX", "GUID\x00guid-1"))
+	byState := write("2.mp3", feedtest.TextFrame("TIT2", "Renamed Too"))
+	byTitle := write("3.mp3", feedtest.TextFrame("TIT2", "by  TITLE"))
+	other := write("4.mp3", feedtest.TextFrame("TIT2", "Something Else"))
+
+	u, _ := url.Parse(server.URL + "/feed.xml")
+	tmpState, tmpTotals := StateDB, WriteTotals
+	defer func() { StateDB, WriteTotals = tmpState, tmpTotals }()
+	WriteTotals = true
+	StateDB = &State{Version: stateVersion, Shows: map[string]*ShowState{
+		u.String(): {Title: "Show", Episodes: map[string]*EpisodeState{
+			"guid-2": {Title: "By State", GUID: "guid-2", Path: byState},
+		}},
+	}}
+
+	show := &Show{URL: u}
+	if err := show.retag(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, path := range []string{byTag, byState, byTitle} {
+		meta, err := readFileMeta(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := fmt.Sprintf("%v/4", i+1)
+		if have := getTag(meta, "TRCK"); have != want {
+			t.Error("Incorrect track for", filepath.Base(path), "- Want:", want, "Have:", have)
+		}
+	}
+	meta, err := readFileMeta(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have := getTag(meta, "TRCK"); have != "" {
+		t.Error("Retagged a file without a matching episode:", have)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/url"
+	"path"
+	"strings"
+)
+
+// trackingPrefix is an analytics service that's put in front of enclosure URLs. The service counts the request and then
+// redirects to the real URL, which is tacked onto the end of its own: "https://dts.podtrac.com/redirect.mp3/host/file".
+type trackingPrefix struct {
+	host string   // host of the service
+	path []string // path segments before the real URL, as path.Match patterns (usually "*" for a show or user ID)
+}
+
+// trackingPrefixes are the prefixes that are removed from enclosure URLs with -strip-trackers.
+var trackingPrefixes = []trackingPrefix{
+	{"dts.podtrac.com", []string{"redirect.*"}},
+	{"www.podtrac.com", []string{"pts", "redirect.*"}},
+	{"play.podtrac.com", []string{"*"}},
+	{"chtbl.com", []string{"track", "*"}},
+	{"chrt.fm", []string{"track", "*"}},
+	{"pdst.fm", []string{"e"}},
+	{"op3.dev", []string{"e*"}},
+	{"pscrb.fm", []string{"rss", "p"}},
+	{"verifi.podscribe.com", []string{"rss", "p"}},
+	{"arttrk.com", []string{"p", "*"}},
+	{"mgln.ai", []string{"e", "*"}},
+	{"prfx.byspotify.com", []string{"e"}},
+	{"pfx.vpixl.com", []string{"*"}},
+	{"clrtpod.com", []string{"m"}},
+	{"tracking.swap.fm", []string{"track", "*"}},
+}
+
+// maxTrackers is the most prefixes that are removed from one URL, which is more than any real feed chains together.
+const maxTrackers = 10
+
+// UnwrapTrackers removes the tracking prefixes from the start of the link, returning the URL of the file itself. Links
+// without a known prefix are returned unchanged.
+func UnwrapTrackers(link string) string {
+	for i := 0; i < maxTrackers; i++ {
+		stripped, ok := stripTracker(link)
+		if !ok {
+			break
+		}
+		link = stripped
+	}
+
+	return link
+}
+
+// stripTracker removes one tracking prefix from the link, if it has one.
+func stripTracker(link string) (string, bool) {
+	u, err := url.Parse(link)
+	if err != nil || u.Host == "" {
+		return link, false
+	}
+
+	host := strings.ToLower(u.Hostname())
+	for _, prefix := range trackingPrefixes {
+		if host != prefix.host {
+			continue
+		}
+
+		// The real URL can have its own scheme ("https://op3.dev/e/https://host/file") or borrow the tracker's.
+		segments := strings.Split(strings.TrimPrefix(u.EscapedPath(), "/"), "/")
+		if len(segments) <= len(prefix.path) || !matchSegments(prefix.path, segments) {
+			return link, false
+		}
+		rest := strings.Join(segments[len(prefix.path):], "/")
+
+		switch {
+		case strings.HasPrefix(rest, "http:/"), strings.HasPrefix(rest, "https:/"):
+			i := strings.Index(rest, ":/")
+			rest = rest[:i] + "://" + strings.TrimLeft(rest[i+1:], "/")
+		default:
+			rest = u.Scheme + "://" + rest
+		}
+		if u.RawQuery != "" {
+			rest += "?" + u.RawQuery
+		}
+
+		// Only take the result if it still looks like a link to a file somewhere.
+		target, err := url.Parse(rest)
+		if err != nil || !strings.Contains(target.Hostname(), ".") {
+			return link, false
+		}
+
+		Debug("Removed tracking prefix", prefix.host, "from", link)
+		return rest, true
+	}
+
+	return link, false
+}
+
+// isTracker reports whether the link is on the host of one of the known tracking prefixes.
+func isTracker(link string) bool {
+	host := hostOf(link)
+	for _, prefix := range trackingPrefixes {
+		if host == prefix.host {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchSegments reports whether the path segments start with the patterns.
+func matchSegments(patterns []string, segments []string) bool {
+	for i, pattern := range patterns {
+		if ok, _ := path.Match(pattern, segments[i]); !ok || segments[i] == "" {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// trashDirName is the name of the directory in each show's directory that holds removed episodes.
+const trashDirName = ".trash"
+
+// trashTimesName is the name of the file in the trash directory that records when each file was trashed. The files keep
+// their own modification times so that they're unchanged when they're restored.
+const trashTimesName = ".trashed.json"
+
+// readTrashTimes reads when each file in the show's trash was trashed, keyed by the file's slash-separated path relative
+// to the trash directory.
+func readTrashTimes(showDir string) map[string]time.Time {
+	times := make(map[string]time.Time)
+	data, err := ioutil.ReadFile(filepath.Join(showDir, trashDirName, trashTimesName))
+	if err != nil {
+		return times
+	}
+	if err := json.Unmarshal(data, &times); err != nil {
+		Log("Ignoring invalid trash times:", err)
+	}
+
+	return times
+}
+
+// writeTrashTimes writes when each file in the show's trash was trashed.
+func writeTrashTimes(showDir string, times map[string]time.Time) error {
+	path := filepath.Join(showDir, trashDirName, trashTimesName)
+	if len(times) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	data, err := json.MarshalIndent(times, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// TrashFile moves the file at the path into the show's trash directory instead of deleting it, keeping its location
+// relative to the show's directory so it can be restored later.
+func TrashFile(showDir string, path string) error {
+	rel, err := filepath.Rel(showDir, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return fmt.Errorf("%v is not in the show's directory", filepath.Base(path))
+	}
+
+	dest := filepath.Join(showDir, trashDirName, rel)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+
+	// If an older copy is already in the trash, the newer one replaces it.
+	if err := os.Rename(path, dest); err != nil {
+		return err
+	}
+
+	// The purge age counts from when the file was trashed, not when it was last modified.
+	times := readTrashTimes(showDir)
+	times[filepath.ToSlash(rel)] = time.Now()
+	if err := writeTrashTimes(showDir, times); err != nil {
+		Log("Error recording trash time:", err)
+	}
+
+	// The file is no longer one of the show's episodes.
+	if _, err := os.Stat(filepath.Join(showDir, ChecksumsFile)); err == nil {
+		if err := UpdateChecksums(showDir); err != nil {
+			Log("Error updating checksums:", err)
+		}
+	}
+
+	Debug("Moved", filepath.Base(path), "to trash")
+	return nil
+}
+
+// PurgeTrash permanently removes the files in the show's trash directory that have been there longer than maxAge. A
+// maxAge of 0 keeps trashed files forever.
+func PurgeTrash(showDir string, maxAge time.Duration) error {
+	if maxAge <= 0 {
+		return nil
+	}
+
+	trashDir := filepath.Join(showDir, trashDirName)
+	if _, err := os.Stat(trashDir); os.IsNotExist(err) {
+		return nil
+	}
+
+	// Files trashed before their trash times were recorded go by when they were last modified.
+	times := readTrashTimes(showDir)
+	cutoff := time.Now().Add(-maxAge)
+	err := filepath.Walk(trashDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || path == filepath.Join(trashDir, trashTimesName) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(trashDir, path)
+		if err != nil {
+			return err
+		}
+		trashed, ok := times[filepath.ToSlash(rel)]
+		if !ok {
+			trashed = info.ModTime()
+		}
+
+		if trashed.Before(cutoff) {
+			Debug("Purging", filepath.Base(path), "from trash")
+			if err := os.Remove(path); err != nil {
+				Log("Error purging", filepath.Base(path), "from trash:", err)
+				return nil
+			}
+			delete(times, filepath.ToSlash(rel))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return writeTrashTimes(showDir, times)
+}
+
+// listTrash returns the paths (relative to the trash directory) of all files in the show's trash.
+func listTrash(showDir string) ([]string, error) {
+	trashDir := filepath.Join(showDir, trashDirName)
+
+	var files []string
+	err := filepath.Walk(trashDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		if !info.IsDir() && path != filepath.Join(trashDir, trashTimesName) {
+			rel, err := filepath.Rel(trashDir, path)
+			if err != nil {
+				return err
+			}
+			files = append(files, rel)
+		}
+
+		return nil
+	})
+
+	return files, err
+}
+
+// runRestore moves trashed episodes back into place. With only a show name, it lists the show's trash. With a pattern
+// as well, it restores every trashed file whose name matches the pattern (e.g. "*Mini-Stories*").
+func runRestore(config *Config, dirArg string, args []string) error {
+	if len(args) == 0 {
+		Log("No show specified")
+		return errUsage
+	}
+
+	mainDir, err := downloadDir(config, dirArg)
+	if err != nil {
+		return err
+	}
+	showDir := filepath.Join(mainDir, args[0])
+
+	files, err := listTrash(showDir)
+	if err != nil {
+		return fmt.Errorf("error reading trash: %v", err)
+	}
+
+	if len(args) < 2 {
+		if len(files) == 0 {
+			Log("Trash is empty")
+		}
+		for _, file := range files {
+			Log(file)
+		}
+		return nil
+	}
+
+	pattern := args[1]
+	times := readTrashTimes(showDir)
+	restored := 0
+	for _, file := range files {
+		if ok, err := filepath.Match(pattern, filepath.Base(file)); err != nil {
+			return fmt.Errorf("invalid pattern: %v", err)
+		} else if !ok {
+			continue
+		}
+
+		dest := filepath.Join(showDir, file)
+		if _, err := os.Stat(dest); err == nil {
+			Log("Not restoring", file, "- file already exists")
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(filepath.Join(showDir, trashDirName, file), dest); err != nil {
+			Log("Error restoring", file, "-", err)
+			continue
+		}
+		Log("Restored", file)
+		delete(times, filepath.ToSlash(file))
+		restored++
+		if isAudio(dest) {
+			if err := refreshChecksum(dest); err != nil {
+				Log("Error updating checksums:", err)
+			}
+		}
+	}
+
+	if restored == 0 {
+		return fmt.Errorf("no trashed files match %v", pattern)
+	}
+	if err := writeTrashTimes(showDir, times); err != nil {
+		Log("Error updating trash times:", err)
+	}
+
+	return nil
+}
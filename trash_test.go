@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Test that trashed and restored files keep their modification times, and that the purge age still counts from when
+// they were trashed.
+func TestTrashKeepsModTime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "getcast-trash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	showDir := filepath.Join(dir, "Show")
+	os.MkdirAll(filepath.Join(showDir, "2020"), 0755)
+	path := filepath.Join(showDir, "2020", "Episode.mp3")
+	ioutil.WriteFile(path, []byte("audio"), 0644)
+	modified := time.Now().Add(-90 * 24 * time.Hour).Truncate(time.Second)
+	os.Chtimes(path, modified, modified)
+
+	if err := TrashFile(showDir, path); err != nil {
+		t.Fatal(err)
+	}
+	trashed := filepath.Join(showDir, trashDirName, "2020", "Episode.mp3")
+	if info, err := os.Stat(trashed); err != nil || !info.ModTime().Equal(modified) {
+		t.Error("Trashed file's time changed - Want:", modified, "Have:", info)
+	}
+
+	// The file was only just trashed, so it's kept.
+	if err := PurgeTrash(showDir, 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := listTrash(showDir); len(files) != 1 {
+		t.Fatal("Incorrect files in trash - Want: [2020/Episode.mp3] Have:", files)
+	}
+
+	if err := runRestore(nil, dir, []string{"Show", "Episode.mp3"}); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || !info.ModTime().Equal(modified) {
+		t.Error("Restored file's time changed - Want:", modified, "Have:", info)
+	}
+	if _, err := os.Stat(filepath.Join(showDir, trashDirName, trashTimesName)); !os.IsNotExist(err) {
+		t.Error("Trash times kept for an empty trash")
+	}
+
+	// Files trashed before trash times were recorded go by their modification times.
+	ioutil.WriteFile(trashed, []byte("audio"), 0644)
+	os.Chtimes(trashed, modified, modified)
+	if err := PurgeTrash(showDir, 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(trashed); !os.IsNotExist(err) {
+		t.Error("Old trashed file was not purged")
+	}
+}
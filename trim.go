@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Trim cuts the length of time off the start and end of the episode's audio, for shows with fixed-length intros and
+// outros. The audio is cut with ffmpeg without re-encoding it. The file's ID3 tag is carried over as is, with the times
+// of its chapters moved to match; other kinds of files keep their metadata through ffmpeg. If anything goes wrong, the
+// original file is left alone. This must be called after the episode has been downloaded.
+func (e *Episode) Trim(start, end time.Duration) error {
+	if e == nil || e.path == "" || (start <= 0 && end <= 0) || !isAudio(e.path) {
+		return nil
+	}
+
+	length, err := probeDuration(e.path)
+	if err != nil {
+		return err
+	}
+	keep := length - start - end
+	if keep <= 0 {
+		return fmt.Errorf("episode is only %v long", length.Round(time.Second))
+	}
+
+	meta, err := readFileMeta(e.path)
+	if err != nil {
+		return err
+	}
+	hasTag := meta.Buffered() && !meta.noMeta && meta.Version() != 0
+
+	ext := filepath.Ext(e.path)
+	tmp := strings.TrimSuffix(e.path, ext) + ".trimmed" + ext
+	args := []string{"-v", "error", "-nostdin", "-y", "-ss", ffmpegTime(start), "-i", e.path, "-t", ffmpegTime(keep)}
+	if hasTag {
+		// The tag is put back on afterward, so ffmpeg shouldn't write one of its own.
+		args = append(args, "-map", "0:a", "-map_metadata", "-1", "-id3v2_version", "0", "-write_id3v1", "0")
+	} else {
+		args = append(args, "-map", "0", "-map_metadata", "0")
+	}
+	args = append(args, "-c", "copy", tmp)
+
+	e.debug("Trimming", start, "from the start and", end, "from the end of", e.path)
+	if out, err := exec.Command("ffmpeg", args...).CombinedOutput(); err != nil {
+		os.Remove(tmp)
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("error running ffmpeg: %v", msg)
+		}
+		return fmt.Errorf("error running ffmpeg: %v", err)
+	}
+
+	if hasTag {
+		shiftChapterFrames(meta, start, keep)
+		audio, err := ioutil.ReadFile(tmp)
+		if err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		if err := ioutil.WriteFile(tmp, append(meta.Build(), audio...), 0644); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		e.meta = meta
+	}
+
+	info, err := os.Stat(tmp)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, e.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	e.size = int(info.Size())
+	e.trimStart, e.trimLength = start, keep
+
+	return nil
+}
+
+// probeDuration asks ffprobe for the length of the audio file at the path.
+func probeDuration(path string) (time.Duration, error) {
+	out, err := exec.Command("ffprobe", "-v", "error", "-show_entries", "format=duration", "-of", "default=noprint_wrappers=1:nokey=1", path).Output()
+	if err != nil {
+		return 0, fmt.Errorf("error running ffprobe: %v", err)
+	}
+
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration from ffprobe: %v", strings.TrimSpace(string(out)))
+	}
+
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// ffmpegTime formats the duration as a number of seconds for ffmpeg.
+func ffmpegTime(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+// shiftChapterFrames moves the chapters (CHAP frames) in the metadata back by the time trimmed off the start, so that
+// they still line up with the audio, and keeps them within the length of what's left. The byte offsets of the chapters
+// are no longer valid after trimming, so they're marked as unused.
+func shiftChapterFrames(meta *Meta, start, length time.Duration) {
+	for i, frame := range meta.frames {
+		if frame.id != "CHAP" {
+			continue
+		}
+
+		n := bytes.IndexByte(frame.value, 0x00)
+		if n < 0 || len(frame.value) < n+1+16 {
+			continue
+		}
+
+		value := append([]byte{}, frame.value...)
+		times := value[n+1:]
+		for _, pos := range []int{0, 4} {
+			ms := time.Duration(binary.BigEndian.Uint32(times[pos:])) * time.Millisecond
+			ms -= start
+			if ms < 0 {
+				ms = 0
+			} else if ms > length {
+				ms = length
+			}
+			binary.BigEndian.PutUint32(times[pos:], uint32(ms/time.Millisecond))
+		}
+		binary.BigEndian.PutUint32(times[8:], 0xFFFFFFFF)
+		binary.BigEndian.PutUint32(times[12:], 0xFFFFFFFF)
+
+		meta.frames[i].value = value
+	}
+}
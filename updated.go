@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// These are the ways to handle episodes that the publisher changed after they were downloaded, such as to fix a glitch
+// in the audio.
+const (
+	UpdatedFlag       = "flag"       // record and log the change, but keep the downloaded file
+	UpdatedRedownload = "redownload" // move the downloaded file to the trash and download the episode again
+	UpdatedIgnore     = "ignore"     // don't check for changes
+)
+
+// ValidateUpdatedMode checks that the mode for handling updated episodes exists. An empty mode uses the default.
+func ValidateUpdatedMode(mode string) error {
+	switch mode {
+	case "", UpdatedFlag, UpdatedRedownload, UpdatedIgnore:
+		return nil
+	}
+
+	return fmt.Errorf("invalid updated mode: %v", mode)
+}
+
+// publisherChanges lists what the publisher changed about the episode since it was downloaded: its enclosure's URL or
+// length, or its publish date. Tracking prefixes come and go on their own, so they aren't counted as a change to the URL.
+// Anything that wasn't recorded at the time of the download isn't compared.
+func (es EpisodeState) publisherChanges(e *Episode) []string {
+	var changes []string
+
+	if es.URL != "" && e.Enclosure.URL != "" && UnwrapTrackers(es.URL) != UnwrapTrackers(e.Enclosure.URL) {
+		changes = append(changes, "enclosure URL")
+	}
+	if size := e.FeedSize(); es.FeedSize > 0 && size > 0 && es.FeedSize != size {
+		changes = append(changes, "enclosure length")
+	}
+	if published := parseDate(e.Date); !es.Published.IsZero() && !published.IsZero() && !es.Published.Equal(published) {
+		changes = append(changes, "pubDate")
+	}
+
+	return changes
+}
+
+// markUpdated records that the publisher changed the episode of the show with the feed URL since it was downloaded. This
+// reports whether or not the episode was already marked.
+func (s *State) markUpdated(feedURL string, e *Episode, changes []string) bool {
+	if s == nil {
+		return false
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	show, ok := s.Shows[feedURL]
+	if !ok {
+		return false
+	}
+	key := e.GUID
+	if key == "" {
+		key = e.Title
+	}
+	es, ok := show.Episodes[key]
+	if !ok {
+		return false
+	}
+	if !es.Updated.IsZero() {
+		return true
+	}
+
+	es.Updated = time.Now()
+	es.UpdatedFields = changes
+	s.touch(feedURL, key)
+
+	return false
+}
+
+// checkUpdated compares the episode in the feed to the one we downloaded, and flags it in the state if the publisher
+// changed it. This reports whether or not the episode should be downloaded again, in which case the old file is moved
+// to the trash once the new one is downloaded.
+func (s *Show) checkUpdated(episode *Episode) bool {
+	mode := s.options().Updated
+	if mode == UpdatedIgnore {
+		return false
+	}
+
+	es, ok := StateDB.Lookup(s.URL.String(), episode)
+	if !ok || es.Path == "" {
+		return false
+	}
+	if _, err := os.Stat(es.Path); err != nil {
+		return false
+	}
+
+	changes := es.publisherChanges(episode)
+	if len(changes) == 0 {
+		return false
+	}
+
+	if !StateDB.markUpdated(s.URL.String(), episode, changes) {
+		Log("Episode", episode.Title, "was updated by the publisher ("+strings.Join(changes, ", ")+" changed)")
+	}
+	// There's no point in fetching a fresh copy of an episode that has already been listened to.
+	if mode != UpdatedRedownload || !es.Played.IsZero() {
+		return false
+	}
+
+	episode.replaces = &replacement{path: es.Path, showDir: s.Dir}
+	Log("Downloading", episode.Title, "again (the old file will be moved to the trash once it's done)")
+
+	return true
+}
+
+// replacement is a downloaded file that's being replaced by a new download of the same episode.
+type replacement struct {
+	path    string // old file
+	showDir string // directory of the show, whose trash the old file goes to
+}
+
+// replacementPath returns where to download the new copy of the episode at the filename, which is hidden next to it.
+func replacementPath(filename string) string {
+	return filepath.Join(filepath.Dir(filename), ".getcast-new-"+filepath.Base(filename))
+}
+
+// finish moves the old file to the trash and the new copy of the episode, which was downloaded to tmp, into its place
+// at the filename.
+func (r *replacement) finish(tmp string, filename string) error {
+	if err := TrashFile(r.showDir, r.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("error moving old file to the trash: %v", err)
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		return err
+	}
+	if _, err := os.Stat(tmp + originalSuffix); err == nil {
+		os.Rename(tmp+originalSuffix, filename+originalSuffix)
+	}
+
+	Log("Moved the old file to the trash")
+	return nil
+}
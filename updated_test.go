@@ -0,0 +1,86 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Test that changes to an episode's enclosure and publish date are found, but not changes to its tracking prefixes.
+func TestPublisherChanges(t *testing.T) {
+	e := Episode{Title: "Episode", Date: "Mon, 02 Jan 2023 15:04:05 GMT"}
+	e.Enclosure = Enclosure{URL: "https://dts.podtrac.com/redirect.mp3/example.com/episode.mp3", Size: "1000"}
+
+	es := EpisodeState{URL: "https://example.com/episode.mp3", FeedSize: 1000, Published: parseDate(e.Date)}
+	if changes := es.publisherChanges(&e); len(changes) != 0 {
+		t.Error("Unchanged episode has changes:", changes)
+	}
+
+	e.Enclosure = Enclosure{URL: "https://example.com/episode-fixed.mp3", Size: "1200"}
+	e.Date = "Tue, 03 Jan 2023 15:04:05 GMT"
+	want := []string{"enclosure URL", "enclosure length", "pubDate"}
+	if changes := es.publisherChanges(&e); !reflect.DeepEqual(changes, want) {
+		t.Error("Incorrect changes - Want:", want, "Have:", changes)
+	}
+
+	// Nothing can be compared for an episode recorded without these.
+	if changes := (EpisodeState{}).publisherChanges(&e); len(changes) != 0 {
+		t.Error("Episode without a record has changes:", changes)
+	}
+}
+
+// Test that the old file of an episode that's downloaded again is kept until the new download is done, and then moved
+// to the trash.
+func TestDownloadReplacement(t *testing.T) {
+	fail := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("new"))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "getcast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	old := filepath.Join(dir, "Episode.pdf")
+	if err := ioutil.WriteFile(old, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	episode := Episode{Title: "Episode", Enclosure: Enclosure{URL: server.URL + "/episode.pdf", Type: "application/pdf"}}
+	episode.replaces = &replacement{path: old, showDir: dir}
+
+	if err := episode.Download(dir); err == nil {
+		t.Fatal("Download didn't fail")
+	}
+	episode.removePartial()
+	if data, err := ioutil.ReadFile(old); err != nil || string(data) != "old" {
+		t.Error("Old file wasn't kept after a failed download:", string(data), err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Error("Incorrect number of files after a failed download - Want: 1 Have:", len(files))
+	}
+
+	fail = false
+	if err := episode.Download(dir); err != nil {
+		t.Fatal(err)
+	}
+	if episode.path != old {
+		t.Error("Incorrect path - Want:", old, "Have:", episode.path)
+	}
+	if data, _ := ioutil.ReadFile(old); string(data) != "new" {
+		t.Error("Incorrect file - Want: new Have:", string(data))
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(dir, trashDirName, "Episode.pdf")); string(data) != "old" {
+		t.Error("Old file wasn't moved to the trash - Have:", string(data))
+	}
+}
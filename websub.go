@@ -0,0 +1,345 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WebSubConfig holds the settings for receiving WebSub (formerly PubSubHubbub) pushes in daemon mode. Feeds that name a
+// hub are subscribed to at the hub, and the hub lets us know as soon as the feed changes.
+type WebSubConfig struct {
+	Listen   string   `json:"listen"`   // address to serve the callback on, e.g. ":8089"
+	Callback string   `json:"callback"` // URL that the hubs can reach the callback at, e.g. https://example.com/websub
+	Lease    Duration `json:"lease"`    // how long to ask the hubs to keep each subscription, or 0 for their default
+}
+
+// Enabled returns whether or not enough settings are present to receive pushes.
+func (w *WebSubConfig) Enabled() bool {
+	return w != nil && w.Listen != "" && w.Callback != ""
+}
+
+// validate checks that the callback is a full URL.
+func (w *WebSubConfig) validate() error {
+	if !w.Enabled() {
+		return nil
+	}
+
+	u, err := url.Parse(w.Callback)
+	if err != nil {
+		return err
+	} else if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("callback must be an http or https URL: %v", w.Callback)
+	}
+
+	return nil
+}
+
+// These control how subscriptions are kept up.
+const (
+	webSubRenewBefore = 24 * time.Hour   // how long before a subscription runs out to renew it
+	webSubRetryAfter  = 10 * time.Minute // how long to wait on a hub to verify a subscription before asking again
+)
+
+// webSubTopic is a feed that we've subscribed to (or asked to subscribe to) at its hub.
+type webSubTopic struct {
+	feed      string    // URL of the feed in the config, which is what gets synced
+	hub       string    // URL of the hub
+	topic     string    // URL that the feed gives for itself, which the hub knows it by
+	secret    string    // key that the hub signs pushes with
+	requested time.Time // when we last asked the hub to subscribe
+	expires   time.Time // when the subscription runs out, or the zero time if the hub hasn't verified it yet
+}
+
+// webSub subscribes to feeds at their hubs and serves the callback that the hubs verify subscriptions with and push
+// updates to. Each subscription has its own callback URL, ending in a random ID, so that pushes can be matched up with
+// their feed and secret.
+type webSub struct {
+	config WebSubConfig
+	server *http.Server
+	pushes chan<- string // where to send the feeds that the hubs push updates for
+
+	mutex  sync.Mutex
+	topics map[string]*webSubTopic // by callback ID
+}
+
+// newWebSub creates the subscriber with the settings. Feeds with updates are sent on the channel.
+func newWebSub(config WebSubConfig, pushes chan<- string) *webSub {
+	return &webSub{
+		config: config,
+		pushes: pushes,
+		topics: make(map[string]*webSubTopic),
+	}
+}
+
+// Start starts serving the callback in the background.
+func (w *webSub) Start() error {
+	listener, err := net.Listen("tcp", w.config.Listen)
+	if err != nil {
+		return err
+	}
+
+	w.server = &http.Server{Handler: w, ReadHeaderTimeout: 30 * time.Second}
+	go func() {
+		if err := w.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			Log("WebSub callback server stopped:", err)
+		}
+	}()
+
+	Log("Listening for WebSub pushes on", listener.Addr())
+	return nil
+}
+
+// Close stops serving the callback.
+func (w *webSub) Close() error {
+	if w.server == nil {
+		return nil
+	}
+
+	return w.server.Close()
+}
+
+// Subscribe asks the hub to push updates of the topic, unless there's already a subscription that isn't about to run
+// out (or a request that the hub hasn't gotten back to yet). The feed is the URL from the config that the updates are
+// for.
+func (w *webSub) Subscribe(feed string, hub string, topic string) error {
+	if topic == "" {
+		topic = feed
+	}
+
+	w.mutex.Lock()
+	id, t := w.find(feed)
+	now := time.Now()
+	switch {
+	case t != nil && t.hub == hub && t.topic == topic && !t.expires.IsZero() && now.Before(t.expires.Add(-webSubRenewBefore)):
+		w.mutex.Unlock()
+		return nil
+	case t != nil && t.hub == hub && t.topic == topic && t.expires.IsZero() && now.Before(t.requested.Add(webSubRetryAfter)):
+		w.mutex.Unlock()
+		return nil
+	case t == nil || t.hub != hub || t.topic != topic:
+		// A new subscription (or one for a feed that moved to another hub) gets a new callback and secret.
+		delete(w.topics, id)
+		id, t = randomHex(16), &webSubTopic{feed: feed, hub: hub, topic: topic, secret: randomHex(32)}
+		w.topics[id] = t
+	}
+	t.requested = now
+	secret := t.secret
+	w.mutex.Unlock()
+
+	form := url.Values{}
+	form.Set("hub.mode", "subscribe")
+	form.Set("hub.topic", topic)
+	form.Set("hub.callback", strings.TrimSuffix(w.config.Callback, "/")+"/"+id)
+	form.Set("hub.secret", secret)
+	if w.config.Lease.Duration > 0 {
+		form.Set("hub.lease_seconds", strconv.Itoa(int(w.config.Lease.Seconds())))
+	}
+
+	req, err := http.NewRequestWithContext(stopCtx, http.MethodPost, hub, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	Debug("Subscribing to", topic, "at WebSub hub", hub)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("hub responded with %v: %v", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
+
+// find returns the subscription for the feed, if there is one.
+func (w *webSub) find(feed string) (string, *webSubTopic) {
+	for id, t := range w.topics {
+		if t.feed == feed {
+			return id, t
+		}
+	}
+
+	return "", nil
+}
+
+// ServeHTTP handles the hubs' requests to the callback: GET to verify a subscription, and POST to push an update.
+func (w *webSub) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	id := path.Base(r.URL.Path)
+
+	w.mutex.Lock()
+	t, ok := w.topics[id]
+	var topic webSubTopic
+	if ok {
+		topic = *t
+	}
+	w.mutex.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		w.verify(rw, r, id, ok, topic)
+	case http.MethodPost:
+		if !ok {
+			// Letting the hub know that the subscription is gone keeps it from pushing to us again.
+			http.Error(rw, "unknown subscription", http.StatusGone)
+			return
+		}
+		w.push(rw, r, topic)
+	default:
+		rw.Header().Set("Allow", "GET, POST")
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// verify answers the hub's check that we really asked for the subscription (or for it to be removed).
+func (w *webSub) verify(rw http.ResponseWriter, r *http.Request, id string, ok bool, t webSubTopic) {
+	query := r.URL.Query()
+	mode := query.Get("hub.mode")
+	challenge := query.Get("hub.challenge")
+
+	switch mode {
+	case "subscribe":
+		if !ok || query.Get("hub.topic") != t.topic {
+			http.NotFound(rw, r)
+			return
+		}
+
+		lease, _ := strconv.Atoi(query.Get("hub.lease_seconds"))
+		if lease <= 0 {
+			// Without a lease, we'll check back in on the next sync after a day.
+			lease = int((webSubRenewBefore + time.Hour).Seconds())
+		}
+		w.mutex.Lock()
+		if current, ok := w.topics[id]; ok {
+			current.expires = time.Now().Add(time.Duration(lease) * time.Second)
+		}
+		w.mutex.Unlock()
+		Log("WebSub hub verified the subscription to", t.topic, "for", time.Duration(lease)*time.Second)
+
+	case "unsubscribe":
+		// We only ever drop subscriptions by forgetting them, so we only agree to unsubscribe from those.
+		if ok {
+			http.NotFound(rw, r)
+			return
+		}
+
+	case "denied":
+		Log("WebSub hub denied the subscription to", query.Get("hub.topic")+":", query.Get("hub.reason"))
+		w.mutex.Lock()
+		delete(w.topics, id)
+		w.mutex.Unlock()
+
+	default:
+		http.Error(rw, "invalid mode", http.StatusBadRequest)
+		return
+	}
+
+	io.WriteString(rw, challenge)
+}
+
+// push takes an update from the hub and, if it's signed with the subscription's secret, passes the feed on to be
+// synced. The update itself isn't used, since syncing fetches the feed anyway.
+func (w *webSub) push(rw http.ResponseWriter, r *http.Request, t webSubTopic) {
+	var body io.Reader = r.Body
+	if MaxFeedSize > 0 {
+		body = io.LimitReader(r.Body, int64(MaxFeedSize)+1)
+	}
+	data, err := ioutil.ReadAll(body)
+	if err != nil {
+		http.Error(rw, "error reading body", http.StatusBadRequest)
+		return
+	} else if MaxFeedSize > 0 && len(data) > MaxFeedSize {
+		http.Error(rw, "body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// An update that isn't signed properly is acknowledged but otherwise ignored, so that nobody can find out from us
+	// what's a good signature.
+	rw.WriteHeader(http.StatusAccepted)
+	if !validSignature(r.Header.Get("X-Hub-Signature"), t.secret, data) {
+		Log("Ignoring WebSub push for", t.topic, "with an invalid signature")
+		return
+	}
+
+	Debug("WebSub hub pushed an update of", t.topic)
+	select {
+	case w.pushes <- t.feed:
+	default:
+		Debug("Too many WebSub pushes waiting, dropping the one for", t.topic)
+	}
+}
+
+// validSignature checks the X-Hub-Signature header, like "sha256=<hex>", against the HMAC of the body.
+func validSignature(header string, secret string, body []byte) bool {
+	fields := strings.SplitN(header, "=", 2)
+	if len(fields) != 2 {
+		return false
+	}
+
+	var h func() hash.Hash
+	switch strings.ToLower(fields[0]) {
+	case "sha1":
+		h = sha1.New
+	case "sha256":
+		h = sha256.New
+	case "sha384":
+		h = sha512.New384
+	case "sha512":
+		h = sha512.New
+	default:
+		return false
+	}
+
+	want, err := hex.DecodeString(fields[1])
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(h, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), want)
+}
+
+// webSubLinks returns the hub and the URL of the feed itself from the feed's links.
+func webSubLinks(links []atomLink) (string, string) {
+	hub, self := "", ""
+	for _, link := range links {
+		for _, rel := range strings.Fields(link.Rel) {
+			switch {
+			case rel == "hub" && hub == "":
+				hub = strings.TrimSpace(link.Href)
+			case rel == "self" && self == "":
+				self = strings.TrimSpace(link.Href)
+			}
+		}
+	}
+
+	return hub, self
+}
+
+// randomHex returns n random bytes in hex.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
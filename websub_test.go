@@ -0,0 +1,154 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Test that the hub and self links are read from RSS and Atom feeds and kept through to the show.
+func TestWebSubLinks(t *testing.T) {
+	rss := `<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom"><channel>
+		<title>Show</title>
+		<atom:link rel="self" href="https://example.com/feed.xml"/>
+		<atom:link rel="hub" href="https://pubsubhubbub.appspot.com/"/>
+	</channel></rss>`
+	atom := `<feed xmlns="http://www.w3.org/2005/Atom">
+		<title>Show</title>
+		<link rel="hub" href="https://hub.example.com/"/>
+		<link rel="self" href="https://example.com/atom.xml"/>
+	</feed>`
+
+	for _, test := range []struct {
+		data string
+		hub  string
+		self string
+	}{
+		{rss, "https://pubsubhubbub.appspot.com/", "https://example.com/feed.xml"},
+		{atom, "https://hub.example.com/", "https://example.com/atom.xml"},
+	} {
+		feed, err := ParseFeed([]byte(test.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if feed.Hub != test.hub || feed.Self != test.self {
+			t.Error("Incorrect links - Want:", test.hub, test.self, "Have:", feed.Hub, feed.Self)
+		}
+
+		// The links need to survive the trip through the show.
+		show := new(Show)
+		feed.fill(show)
+		if hub, self := webSubLinks(show.AtomLinks); hub != test.hub || self != test.self {
+			t.Error("Incorrect show links - Want:", test.hub, test.self, "Have:", hub, self)
+		}
+	}
+}
+
+// Test that subscriptions are verified and renewed only when they're about to run out, and that only pushes with a
+// valid signature are passed on.
+func TestWebSub(t *testing.T) {
+	pushes := make(chan string, 1)
+	w := newWebSub(WebSubConfig{}, pushes)
+	callback := httptest.NewServer(w)
+	defer callback.Close()
+	w.config.Callback = callback.URL + "/websub"
+
+	// The hub verifies each subscription right away, like most do.
+	var form url.Values
+	hub := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		form = r.PostForm
+
+		query := url.Values{}
+		query.Set("hub.mode", "subscribe")
+		query.Set("hub.topic", form.Get("hub.topic"))
+		query.Set("hub.challenge", "challenge-123")
+		query.Set("hub.lease_seconds", "864000")
+		resp, err := http.Get(form.Get("hub.callback") + "?" + query.Encode())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer resp.Body.Close()
+		if body, _ := ioutil.ReadAll(resp.Body); resp.StatusCode != 200 || string(body) != "challenge-123" {
+			t.Error("Incorrect verification - Want: challenge-123 Have:", resp.Status, string(body))
+		}
+		rw.WriteHeader(http.StatusAccepted)
+	}))
+	defer hub.Close()
+
+	feed := "https://example.com/feed.xml?auth=1"
+	if err := w.Subscribe(feed, hub.URL, "https://example.com/feed.xml"); err != nil {
+		t.Fatal(err)
+	}
+	if form.Get("hub.mode") != "subscribe" || form.Get("hub.topic") != "https://example.com/feed.xml" {
+		t.Error("Incorrect subscription request:", form)
+	}
+	if !strings.HasPrefix(form.Get("hub.callback"), w.config.Callback+"/") || form.Get("hub.secret") == "" {
+		t.Error("Missing callback or secret:", form)
+	}
+
+	// Once verified, the subscription isn't renewed until it's about to run out.
+	form = nil
+	if err := w.Subscribe(feed, hub.URL, "https://example.com/feed.xml"); err != nil || form != nil {
+		t.Error("Renewed a fresh subscription:", err, form)
+	}
+
+	push := func(link string, signature string) int {
+		body := "<rss/>"
+		if signature == "" {
+			mac := hmac.New(sha256.New, []byte(form.Get("hub.secret")))
+			mac.Write([]byte(body))
+			signature = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+		}
+		req, _ := http.NewRequest(http.MethodPost, link, strings.NewReader(body))
+		req.Header.Set("X-Hub-Signature", signature)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	w.mutex.Lock()
+	for _, topic := range w.topics {
+		topic.expires = time.Now()
+	}
+	w.mutex.Unlock()
+	if err := w.Subscribe(feed, hub.URL, "https://example.com/feed.xml"); err != nil || form == nil {
+		t.Fatal("Didn't renew an expiring subscription:", err)
+	}
+	link := form.Get("hub.callback")
+
+	if status := push(link, ""); status != http.StatusAccepted {
+		t.Error("Incorrect status for push - Want:", http.StatusAccepted, "Have:", status)
+	}
+	select {
+	case have := <-pushes:
+		if have != feed {
+			t.Error("Incorrect feed pushed - Want:", feed, "Have:", have)
+		}
+	case <-time.After(time.Second):
+		t.Error("Push wasn't passed on")
+	}
+
+	// Pushes with a bad signature are acknowledged but dropped.
+	push(link, "sha256=00")
+	select {
+	case have := <-pushes:
+		t.Error("Passed on push with a bad signature for", have)
+	default:
+	}
+
+	if status := push(w.config.Callback+"/unknown", ""); status != http.StatusGone {
+		t.Error("Incorrect status for unknown subscription - Want:", http.StatusGone, "Have:", status)
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"path/filepath"
+)
+
+// yearDir returns the directory for the episode inside dir when year-based subdirectories are in use, e.g.
+// "<dir>/2019". Episodes without a usable publish date stay in dir.
+func yearDir(dir string, episode *Episode) string {
+	ts := episode.publishTime()
+	if ts.IsZero() {
+		return dir
+	}
+
+	return filepath.Join(dir, ts.Format("2006"))
+}
+
+// useYearDirs reports whether or not a show with this many episodes in its feed should be bucketed into year-based
+// subdirectories.
+func useYearDirs(episodes int) bool {
+	return YearDirs > 0 && episodes >= YearDirs
+}
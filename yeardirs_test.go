@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/snhilde/getcast/internal/feedtest"
+)
+
+// Test that shows with enough episodes are saved in a directory for each year, and that the episodes there are found
+// again on the next sync.
+func TestYearDirs(t *testing.T) {
+	feed := feedtest.Generate("Year Show", 60, 1024)
+	server := feedtest.NewServer(feed)
+	defer server.Close()
+	u, err := url.Parse(server.FeedURL(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "getcast-years")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tmpState, tmpCache, tmpArtwork := StateDB, TagCache, ArtworkMode
+	tmpYears, tmpDepth := YearDirs, ScanDepth
+	defer func() {
+		StateDB, TagCache, ArtworkMode = tmpState, tmpCache, tmpArtwork
+		YearDirs, ScanDepth = tmpYears, tmpDepth
+	}()
+	StateDB, TagCache, ArtworkMode = nil, nil, ArtworkNone
+	YearDirs, ScanDepth = 50, -1
+
+	show := &Show{URL: u}
+	if good, bad, err := show.Sync(dir, ""); err != nil || good != 60 || bad != 0 {
+		t.Fatal("First sync - Want: 60 Have:", good, bad, err)
+	}
+
+	// The weekly episodes start in January 2020, so the last 8 are in 2021.
+	for year, want := range map[string]int{"2020": 52, "2021": 8} {
+		files, _ := filepath.Glob(filepath.Join(dir, "Year Show", year, "*.mp3"))
+		if len(files) != want {
+			t.Error("Incorrect episodes in", year, "- Want:", want, "Have:", len(files))
+		}
+	}
+
+	show = &Show{URL: u}
+	if good, bad, err := show.Sync(dir, ""); err != nil || good != 0 || bad != 0 {
+		t.Error("Second sync - Want: 0 Have:", good, bad, err)
+	}
+
+	if useYearDirs(49) || !useYearDirs(50) {
+		t.Error("Incorrect threshold for year directories")
+	}
+	YearDirs = 0
+	if useYearDirs(1000) {
+		t.Error("Used year directories when they're turned off")
+	}
+}